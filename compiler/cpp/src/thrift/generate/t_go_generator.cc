@@ -928,6 +928,16 @@ string t_go_generator::go_imports_begin(bool consts) {
     system_packages.push_back("errors");
   }
   system_packages.push_back("fmt");
+  // The clients of the services guard their last response meta, unless they
+  // extend another service and embed its client instead.
+  if (!consts) {
+    for (auto service : get_program()->get_services()) {
+      if (service->get_extends() == nullptr) {
+        system_packages.push_back("sync");
+        break;
+      }
+    }
+  }
   system_packages.push_back("time");
   system_packages.push_back(gen_thrift_import_);
   return "import(\n" + render_system_packages(system_packages);
@@ -992,7 +1002,7 @@ void t_go_generator::generate_typedef(t_typedef* ttypedef) {
  * @param tenum The enumeration
  */
 void t_go_generator::generate_enum(t_enum* tenum) {
-  std::ostringstream to_string_mapping, from_string_mapping;
+  std::ostringstream to_string_mapping, from_string_mapping, values_list;
   std::string tenum_name(publicize(tenum->get_name()));
   generate_go_docstring(f_types_, tenum);
   f_types_ << "type " << tenum_name << " int64" << endl << "const (" << endl;
@@ -1015,6 +1025,7 @@ void t_go_generator::generate_enum(t_enum* tenum) {
     string iter_name((*c_iter)->get_name());
     f_types_ << indent() << "  " << tenum_name << "_" << iter_name << ' ' << tenum_name << " = "
              << value << endl;
+    values_list << tenum_name << "_" << iter_name << ", ";
     // Dictionaries to/from string names of enums
     to_string_mapping << indent() << "  case " << tenum_name << "_" << iter_name << ": return \""
                       << iter_std_name << "\"" << endl;
@@ -1046,6 +1057,12 @@ void t_go_generator::generate_enum(t_enum* tenum) {
   f_types_ << "func " << tenum_name << "Ptr(v " << tenum_name << ") *" << tenum_name
            << " { return &v }" << endl << endl;
 
+  // Generate Values, listing the values of the enum for the runtime, e.g. for
+  // thrift.JSONSchema.
+  f_types_ << "func (p " << tenum_name << ") Values() []" << tenum_name << " {" << endl;
+  f_types_ << "return []" << tenum_name << "{" << values_list.str() << "}" << endl;
+  f_types_ << "}" << endl << endl;
+
   // Generate MarshalText
   f_types_ << "func (p " << tenum_name << ") MarshalText() ([]byte, error) {" << endl;
   f_types_ << "return []byte(p.String()), nil" << endl;
@@ -1423,6 +1440,11 @@ void t_go_generator::generate_go_struct_definition(ostream& out,
       if ((*m_iter)->get_req() == t_field::T_REQUIRED) {
         out << ",required";
       }
+      // The fields annotated as sensitive or pii are masked by thrift.Redact.
+      if ((*m_iter)->annotations_.count("sensitive") != 0
+          || (*m_iter)->annotations_.count("pii") != 0) {
+        out << ",sensitive";
+      }
 
       out << "\" " << gotag << "`" << endl;
       sorted_keys_pos++;
@@ -2060,6 +2082,7 @@ void t_go_generator::generate_service_client(t_service* tservice) {
     f_types_ << indent() << "*" << extends_client << endl;
   } else {
     f_types_ << indent() << "c thrift.TClient" << endl;
+    f_types_ << indent() << "mu sync.Mutex" << endl;
     f_types_ << indent() << "meta thrift.ResponseMeta" << endl;
   }
 
@@ -2134,12 +2157,16 @@ void t_go_generator::generate_service_client(t_service* tservice) {
 
     f_types_ << indent() << "func (p *" << serviceName << "Client) LastResponseMeta_() thrift.ResponseMeta {" << endl;
     indent_up();
+    f_types_ << indent() << "p.mu.Lock()" << endl;
+    f_types_ << indent() << "defer p.mu.Unlock()" << endl;
     f_types_ << indent() << "return p.meta" << endl;
     indent_down();
     f_types_ << indent() << "}" << endl << endl;
 
     f_types_ << indent() << "func (p *" << serviceName << "Client) SetLastResponseMeta_(meta thrift.ResponseMeta) {" << endl;
     indent_up();
+    f_types_ << indent() << "p.mu.Lock()" << endl;
+    f_types_ << indent() << "defer p.mu.Unlock()" << endl;
     f_types_ << indent() << "p.meta = meta" << endl;
     indent_down();
     f_types_ << indent() << "}" << endl << endl;
@@ -2798,8 +2825,9 @@ void t_go_generator::generate_service_server(t_service* tservice) {
     f_types_ << indent() << "func (p *" << serviceName
                << "Processor) Process(ctx context.Context, iprot, oprot thrift.TProtocol) (success bool, err "
                   "thrift.TException) {" << endl;
-    f_types_ << indent() << "  name, _, seqId, err2 := iprot.ReadMessageBegin(ctx)" << endl;
+    f_types_ << indent() << "  name, typeId, seqId, err2 := iprot.ReadMessageBegin(ctx)" << endl;
     f_types_ << indent() << "  if err2 != nil { return false, thrift.WrapTException(err2) }" << endl;
+    f_types_ << indent() << "  ctx = thrift.WithMessageType(ctx, typeId)" << endl;
     f_types_ << indent() << "  if processor, ok := p.GetProcessorFunction(name); ok {" << endl;
     f_types_ << indent() << "    return processor.Process(ctx, seqId, iprot, oprot)" << endl;
     f_types_ << indent() << "  }" << endl;
@@ -2880,7 +2908,22 @@ void t_go_generator::generate_process_function(t_service* tservice, t_function*
   }
   f_types_ << indent() << "  return false, thrift.WrapTException(err2)" << endl;
   f_types_ << indent() << "}" << endl;
-  f_types_ << indent() << "iprot.ReadMessageEnd(ctx)" << endl << endl;
+  f_types_ << indent() << "iprot.ReadMessageEnd(ctx)" << endl;
+  f_types_ << indent() << "if err2 = thrift.ValidateArguments(ctx, \"" << escape_string(tfunction->get_name())
+             << "\", &args); err2 != nil {" << endl;
+  if (!tfunction->is_oneway()) {
+    f_types_ << indent()
+               << "  x := thrift.NewTApplicationExceptionWithReason(thrift.PROTOCOL_ERROR, "
+                  "thrift.ExceptionReasonInvalidArgument, err2.Error())"
+               << endl;
+    f_types_ << indent() << "  oprot.WriteMessageBegin(ctx, \"" << escape_string(tfunction->get_name())
+               << "\", thrift.EXCEPTION, seqId)" << endl;
+    f_types_ << indent() << "  x.Write(ctx, oprot)" << endl;
+    f_types_ << indent() << "  oprot.WriteMessageEnd(ctx)" << endl;
+    f_types_ << indent() << "  oprot.Flush(ctx)" << endl;
+  }
+  f_types_ << indent() << "  return true, thrift.WrapTException(err2)" << endl;
+  f_types_ << indent() << "}" << endl << endl;
 
   // Even though we never create the goroutine in oneway handlers,
   // always have (nop) tickerCancel defined makes the writing part of code
@@ -2970,6 +3013,10 @@ void t_go_generator::generate_process_function(t_service* tservice, t_function*
 
   f_types_ << "); err2 != nil {" << endl;
   f_types_ << indent() << "  tickerCancel()" << endl;
+  if (!tfunction->is_oneway()) {
+    f_types_ << indent() << "  err2 = thrift.ClassifyHandlerError(ctx, \""
+               << escape_string(tfunction->get_name()) << "\", err2)" << endl;
+  }
 
   t_struct* exceptions = tfunction->get_xceptions();
   const vector<t_field*>& x_fields = exceptions->get_members();
@@ -2993,9 +3040,8 @@ void t_go_generator::generate_process_function(t_service* tservice, t_function*
     f_types_ << indent() << "    return false, thrift.WrapTException(err2)" << endl;
     f_types_ << indent() << "  }" << endl;
 
-    f_types_ << indent() << "  x := thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "
-                              "\"Internal error processing " << escape_string(tfunction->get_name())
-               << ": \" + err2.Error())" << endl;
+    f_types_ << indent() << "  x := thrift.HandlerErrorApplicationException(ctx, \""
+               << escape_string(tfunction->get_name()) << "\", err2)" << endl;
     f_types_ << indent() << "  oprot.WriteMessageBegin(ctx, \"" << escape_string(tfunction->get_name())
                << "\", thrift.EXCEPTION, seqId)" << endl;
     f_types_ << indent() << "  x.Write(ctx, oprot)" << endl;
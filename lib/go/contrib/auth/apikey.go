@@ -0,0 +1,79 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+)
+
+// APIKeyValidator returns a Validator authenticating the API keys sent in the
+// header key, with the Principals returned by lookup.
+//
+// lookup returns nil for unknown keys, see StaticAPIKeys.
+func APIKeyValidator(header string, lookup func(ctx context.Context, key string) (*Principal, error)) Validator {
+	return ValidatorFunc(func(ctx context.Context, creds Credentials) (*Principal, error) {
+		key := creds.Header(header)
+		if key == "" {
+			return nil, ErrNoCredentials
+		}
+		p, err := lookup(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, errUnknownAPIKey
+		}
+		return p, nil
+	})
+}
+
+var errUnknownAPIKey = errors.New("unknown API key")
+
+// StaticAPIKeys returns an APIKeyValidator lookup function for a fixed set of
+// keys, compared in constant time.
+//
+// The Scheme of the Principals is set to "apikey" if empty.
+func StaticAPIKeys(keys map[string]Principal) func(ctx context.Context, key string) (*Principal, error) {
+	type apiKey struct {
+		key       []byte
+		principal Principal
+	}
+	known := make([]apiKey, 0, len(keys))
+	for key, p := range keys {
+		if p.Scheme == "" {
+			p.Scheme = "apikey"
+		}
+		known = append(known, apiKey{[]byte(key), p})
+	}
+	return func(ctx context.Context, key string) (*Principal, error) {
+		var found *Principal
+		for i := range known {
+			// All the keys are compared, so that the time taken doesn't
+			// depend on which one matches.
+			if subtle.ConstantTimeCompare(known[i].key, []byte(key)) == 1 {
+				p := known[i].principal
+				found = &p
+			}
+		}
+		return found, nil
+	}
+}
@@ -0,0 +1,425 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// AuditRecord is the audit record of a call, see AuditMiddleware.
+type AuditRecord struct {
+	// Seq is the position of the record in the chain of the middleware,
+	// starting at 1.
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+
+	Method string `json:"method"`
+	SeqID  int32  `json:"seqid"`
+
+	// Subject and Scheme are the ones of the Principal of the call, empty
+	// if it had none.
+	Subject string `json:"subject,omitempty"`
+	Scheme  string `json:"scheme,omitempty"`
+
+	// Peer is the remote address of the client, empty if unknown.
+	Peer string `json:"peer,omitempty"`
+
+	// Arguments are the argument fields selected by the AuditFields of the
+	// method, by name, after redaction.
+	Arguments map[string]string `json:"arguments,omitempty"`
+
+	// Status is one of the thrift.AccessLogStatus* constants.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// PrevHash is the Hash of the previous record of the chain, empty for
+	// the first one.
+	PrevHash string `json:"prev_hash,omitempty"`
+
+	// Hash is the hex encoded SHA-256 (or HMAC-SHA256, see
+	// AuditOptions.Key) of the record, including PrevHash, so that altering,
+	// removing or reordering records breaks the chain. See
+	// VerifyAuditChain.
+	Hash string `json:"hash"`
+}
+
+// AuditField selects an argument field to include in the AuditRecords.
+type AuditField struct {
+	// ID is the id of the field in the arguments of the method, as in the
+	// IDL.
+	ID int16
+
+	// Name is the key of the field in AuditRecord.Arguments.
+	Name string
+
+	// Redact formats the value of the field for the record, e.g.
+	// RedactValue or HashValue for sensitive fields. The values are decoded
+	// as bool, int8, int16, int32, int64, float64 or string, structs as
+	// map[int16]interface{} by field id, lists and sets as []interface{},
+	// and maps as map[interface{}]interface{}.
+	//
+	// If nil, the value is formatted with fmt.Sprint.
+	Redact func(value interface{}) string
+}
+
+// RedactValue is an AuditField.Redact function hiding the value.
+func RedactValue(value interface{}) string {
+	return "[redacted]"
+}
+
+// HashValue is an AuditField.Redact function replacing the value with its
+// SHA-256, so that the records with the same value can be correlated without
+// revealing it.
+func HashValue(value interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(value)))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// AuditSink stores the AuditRecords.
+type AuditSink interface {
+	WriteAudit(ctx context.Context, record AuditRecord) error
+}
+
+// AuditSinkFunc is a function implementing AuditSink.
+type AuditSinkFunc func(ctx context.Context, record AuditRecord) error
+
+// WriteAudit calls f.
+func (f AuditSinkFunc) WriteAudit(ctx context.Context, record AuditRecord) error {
+	return f(ctx, record)
+}
+
+// JSONAuditSink returns an AuditSink writing the records to w as JSON, one
+// per line.
+func JSONAuditSink(w io.Writer) AuditSink {
+	enc := json.NewEncoder(w)
+	return AuditSinkFunc(func(ctx context.Context, record AuditRecord) error {
+		return enc.Encode(record)
+	})
+}
+
+// AuditOptions configures AuditMiddleware.
+type AuditOptions struct {
+	// Sink stores the records. It must not be nil.
+	//
+	// It's called with the records in the order of the chain, one at a
+	// time, so slow sinks should buffer them.
+	Sink AuditSink
+
+	// Arguments are the argument fields to include in the records, by
+	// method name. The arguments of the other methods are not decoded.
+	//
+	// The arguments are captured while the processor function reads them,
+	// so the fields it doesn't know can't be included.
+	Arguments map[string][]AuditField
+
+	// Key is the key of the HMAC-SHA256 chaining the records, so that the
+	// chain can't be recomputed without it once altered.
+	//
+	// If nil, the records are chained with SHA-256.
+	Key []byte
+
+	// PrevHash is the Hash of the last record written before, e.g. before
+	// a restart, to continue its chain.
+	PrevHash string
+
+	// OnError is called with the errors of Sink, for example to log them.
+	OnError func(ctx context.Context, err error)
+}
+
+// AuditMiddleware returns a thrift.ProcessorMiddleware writing an AuditRecord
+// for every call to the Sink of opts, after it's processed.
+//
+// The records are chained by their hashes: each one includes the Hash of the
+// previous one, so that the chain can be verified with VerifyAuditChain. It
+// should be called after Middleware, to record the Principals of the calls.
+func AuditMiddleware(opts AuditOptions) thrift.ProcessorMiddleware {
+	chain := &auditChain{
+		sink:     opts.Sink,
+		key:      opts.Key,
+		prevHash: opts.PrevHash,
+		onError:  opts.OnError,
+	}
+	return func(name string, next thrift.TProcessorFunction) thrift.TProcessorFunction {
+		fields := opts.Arguments[name]
+		return thrift.WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+				record := AuditRecord{
+					Time:   time.Now().UTC(),
+					Method: name,
+					SeqID:  seqID,
+				}
+				var args *thrift.TMemoryBuffer
+				if len(fields) > 0 {
+					args = thrift.NewTMemoryBuffer()
+					in = &thrift.TDebugProtocol{
+						Delegate:    in,
+						Logger:      thrift.NopLogger,
+						DuplicateTo: thrift.NewTBinaryProtocolConf(args, nil),
+					}
+				}
+				ok, err := next.Process(ctx, seqID, in, out)
+				if p, found := FromContext(ctx); found {
+					record.Subject = p.Subject
+					record.Scheme = p.Scheme
+				}
+				if addr, found := thrift.RemoteAddrFromContext(ctx); found {
+					record.Peer = addr.String()
+				}
+				if args != nil {
+					record.Arguments = auditArguments(ctx, args, fields)
+				}
+				record.Status = auditStatus(err)
+				if err != nil {
+					record.Error = err.Error()
+				}
+				chain.write(ctx, record)
+				return ok, err
+			},
+		}
+	}
+}
+
+func auditStatus(err thrift.TException) string {
+	if err == nil {
+		return thrift.AccessLogStatusOK
+	}
+	switch err.TExceptionType() {
+	case thrift.TExceptionTypeApplication:
+		return thrift.AccessLogStatusApplicationError
+	case thrift.TExceptionTypeProtocol:
+		return thrift.AccessLogStatusProtocolError
+	case thrift.TExceptionTypeTransport:
+		return thrift.AccessLogStatusTransportError
+	default:
+		return thrift.AccessLogStatusError
+	}
+}
+
+// auditChain chains the records of an AuditMiddleware.
+type auditChain struct {
+	sink    AuditSink
+	key     []byte
+	onError func(ctx context.Context, err error)
+
+	mu       sync.Mutex
+	seq      uint64
+	prevHash string
+}
+
+func (c *auditChain) write(ctx context.Context, record AuditRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	record.Seq = c.seq
+	record.PrevHash = c.prevHash
+	sum, err := auditHash(record, c.key)
+	if err == nil {
+		record.Hash = sum
+		c.prevHash = sum
+		err = c.sink.WriteAudit(ctx, record)
+	}
+	if err != nil && c.onError != nil {
+		c.onError(ctx, err)
+	}
+}
+
+// auditHash returns the hash of the JSON encoding of record without its Hash.
+func auditHash(record AuditRecord, key []byte) (string, error) {
+	record.Hash = ""
+	b, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	var h hash.Hash
+	if key != nil {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyAuditChain verifies the hashes of consecutive records written by an
+// AuditMiddleware with key, e.g. read back from a JSONAuditSink, returning
+// an error for the first altered, removed or reordered record.
+func VerifyAuditChain(records []AuditRecord, key []byte) error {
+	for i, record := range records {
+		if i > 0 && (record.Seq != records[i-1].Seq+1 || record.PrevHash != records[i-1].Hash) {
+			return fmt.Errorf("audit record %d: %w", record.Seq, errBrokenAuditChain)
+		}
+		sum, err := auditHash(record, key)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal([]byte(sum), []byte(record.Hash)) {
+			return fmt.Errorf("audit record %d: %w", record.Seq, errAuditHashMismatch)
+		}
+	}
+	return nil
+}
+
+var (
+	errBrokenAuditChain  = errors.New("not chained to the previous record")
+	errAuditHashMismatch = errors.New("hash mismatch")
+)
+
+// auditArguments decodes the fields of the arguments captured in buf.
+func auditArguments(ctx context.Context, buf *thrift.TMemoryBuffer, fields []AuditField) map[string]string {
+	proto := thrift.NewTBinaryProtocolConf(buf, nil)
+	args := make(map[string]string, len(fields))
+	if _, err := proto.ReadStructBegin(ctx); err != nil {
+		return nil
+	}
+	for {
+		_, typeID, id, err := proto.ReadFieldBegin(ctx)
+		if err != nil || typeID == thrift.STOP {
+			break
+		}
+		field := auditField(fields, id)
+		if field == nil {
+			err = proto.Skip(ctx, typeID)
+		} else {
+			var value interface{}
+			if value, err = readAuditValue(ctx, proto, typeID); err == nil {
+				if field.Redact != nil {
+					args[field.Name] = field.Redact(value)
+				} else {
+					args[field.Name] = fmt.Sprint(value)
+				}
+			}
+		}
+		if err != nil || proto.ReadFieldEnd(ctx) != nil {
+			break
+		}
+	}
+	return args
+}
+
+func auditField(fields []AuditField, id int16) *AuditField {
+	for i := range fields {
+		if fields[i].ID == id {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// readAuditValue decodes a value of type typeID, see AuditField.Redact.
+func readAuditValue(ctx context.Context, proto thrift.TProtocol, typeID thrift.TType) (interface{}, error) {
+	switch typeID {
+	case thrift.BOOL:
+		return proto.ReadBool(ctx)
+	case thrift.BYTE:
+		return proto.ReadByte(ctx)
+	case thrift.I16:
+		return proto.ReadI16(ctx)
+	case thrift.I32:
+		return proto.ReadI32(ctx)
+	case thrift.I64:
+		return proto.ReadI64(ctx)
+	case thrift.DOUBLE:
+		return proto.ReadDouble(ctx)
+	case thrift.STRING:
+		return proto.ReadString(ctx)
+	case thrift.STRUCT:
+		if _, err := proto.ReadStructBegin(ctx); err != nil {
+			return nil, err
+		}
+		fields := make(map[int16]interface{})
+		for {
+			_, fieldType, id, err := proto.ReadFieldBegin(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if fieldType == thrift.STOP {
+				break
+			}
+			if fields[id], err = readAuditValue(ctx, proto, fieldType); err != nil {
+				return nil, err
+			}
+			if err := proto.ReadFieldEnd(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return fields, proto.ReadStructEnd(ctx)
+	case thrift.MAP:
+		keyType, valueType, size, err := proto.ReadMapBegin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[interface{}]interface{}, size)
+		for i := 0; i < size; i++ {
+			key, err := readAuditValue(ctx, proto, keyType)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readAuditValue(ctx, proto, valueType)
+			if err != nil {
+				return nil, err
+			}
+			// Struct and container keys are not comparable.
+			switch key.(type) {
+			case map[int16]interface{}, map[interface{}]interface{}, []interface{}:
+				key = fmt.Sprint(key)
+			}
+			m[key] = value
+		}
+		return m, proto.ReadMapEnd(ctx)
+	case thrift.SET, thrift.LIST:
+		var elemType thrift.TType
+		var size int
+		var err error
+		if typeID == thrift.SET {
+			elemType, size, err = proto.ReadSetBegin(ctx)
+		} else {
+			elemType, size, err = proto.ReadListBegin(ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+		elems := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			elem, err := readAuditValue(ctx, proto, elemType)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		if typeID == thrift.SET {
+			return elems, proto.ReadSetEnd(ctx)
+		}
+		return elems, proto.ReadListEnd(ctx)
+	default:
+		return nil, proto.Skip(ctx, typeID)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftauth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func TestAuditMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	key := []byte("audit key")
+	middleware := AuditMiddleware(AuditOptions{
+		Sink: JSONAuditSink(&buf),
+		Arguments: map[string][]AuditField{
+			"login": {
+				{ID: 1, Name: "user"},
+				{ID: 2, Name: "password", Redact: RedactValue},
+				{ID: 3, Name: "ids"},
+			},
+		},
+		Key: key,
+	})
+	next := thrift.WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+			if _, err := readAuditValue(ctx, in, thrift.STRUCT); err != nil {
+				return false, thrift.WrapTException(err)
+			}
+			if err := in.ReadMessageEnd(ctx); err != nil {
+				return false, thrift.WrapTException(err)
+			}
+			if seqID == 2 {
+				return true, thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "failed")
+			}
+			return true, nil
+		},
+	}
+	ctx := NewContext(context.Background(), &Principal{Subject: "alice", Scheme: "jwt"})
+	for seqID := int32(1); seqID <= 3; seqID++ {
+		proto := thrift.NewTBinaryProtocolConf(thrift.NewTMemoryBuffer(), nil)
+		proto.WriteStructBegin(ctx, "args")
+		proto.WriteFieldBegin(ctx, "user", thrift.STRING, 1)
+		proto.WriteString(ctx, "alice")
+		proto.WriteFieldEnd(ctx)
+		proto.WriteFieldBegin(ctx, "password", thrift.STRING, 2)
+		proto.WriteString(ctx, "hunter2")
+		proto.WriteFieldEnd(ctx)
+		proto.WriteFieldBegin(ctx, "ids", thrift.LIST, 3)
+		proto.WriteListBegin(ctx, thrift.I32, 2)
+		proto.WriteI32(ctx, 1)
+		proto.WriteI32(ctx, 2)
+		proto.WriteListEnd(ctx)
+		proto.WriteFieldEnd(ctx)
+		proto.WriteFieldStop(ctx)
+		proto.WriteStructEnd(ctx)
+		proto.WriteMessageEnd(ctx)
+		if _, err := middleware("login", next).Process(ctx, seqID, proto, proto); err != nil && seqID != 2 {
+			t.Fatal(err)
+		}
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Error("expected the password to be redacted")
+	}
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	r := records[0]
+	if r.Seq != 1 || r.Method != "login" || r.Subject != "alice" || r.Scheme != "jwt" || r.Status != thrift.AccessLogStatusOK {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r.Arguments["user"] != "alice" || r.Arguments["password"] != "[redacted]" || r.Arguments["ids"] != "[1 2]" {
+		t.Errorf("unexpected arguments %v", r.Arguments)
+	}
+	if r := records[1]; r.Status != thrift.AccessLogStatusApplicationError || r.Error == "" {
+		t.Errorf("expected an application error, got %+v", r)
+	}
+
+	if err := VerifyAuditChain(records, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAuditChain(records, []byte("other key")); !errors.Is(err, errAuditHashMismatch) {
+		t.Errorf("expected a hash mismatch with another key, got %v", err)
+	}
+	altered := append([]AuditRecord(nil), records...)
+	altered[1].Status = thrift.AccessLogStatusOK
+	if err := VerifyAuditChain(altered, key); !errors.Is(err, errAuditHashMismatch) {
+		t.Errorf("expected a hash mismatch for an altered record, got %v", err)
+	}
+	if err := VerifyAuditChain([]AuditRecord{records[0], records[2]}, key); !errors.Is(err, errBrokenAuditChain) {
+		t.Errorf("expected a broken chain for a removed record, got %v", err)
+	}
+}
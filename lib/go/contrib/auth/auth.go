@@ -0,0 +1,249 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftauth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// ErrNoCredentials is returned by the Validators when the request has none of
+// the credentials they validate, so that the next Validators are tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is the authenticated identity of a client.
+type Principal struct {
+	// Subject identifies the client, e.g. the "sub" claim of a JWT.
+	Subject string
+
+	// Scheme is the scheme the client was authenticated with, e.g. "jwt",
+	// "apikey" or "mtls".
+	Scheme string
+
+	// Roles are the roles of the client, e.g. for authorization.
+	Roles []string
+
+	// Attributes are additional attributes, specific to the Validator,
+	// e.g. the claims of a JWT.
+	Attributes map[string]interface{}
+}
+
+// HasRole reports whether p has role.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx with p as its Principal.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the Principal of the request, if authenticated.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// Credentials are the credentials presented by the client of a request.
+type Credentials struct {
+	// Headers are the THeader headers of the request, or its HTTP headers
+	// with HTTPHandler, with lower case keys.
+	Headers map[string]string
+
+	// TLS is the TLS connection state of the client, nil if not connected
+	// over TLS.
+	TLS *tls.ConnectionState
+}
+
+// Header returns the value of the header key, case-insensitively.
+func (c Credentials) Header(key string) string {
+	return c.Headers[strings.ToLower(key)]
+}
+
+// BearerToken returns the token of a "Bearer <token>" header key, "" if
+// there's none.
+func (c Credentials) BearerToken(key string) string {
+	const prefix = "bearer "
+	value := c.Header(key)
+	if len(value) <= len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(value[len(prefix):])
+}
+
+// CredentialsFromContext returns the credentials of the request of ctx.
+func CredentialsFromContext(ctx context.Context) Credentials {
+	var creds Credentials
+	if keys := thrift.GetReadHeaderList(ctx); len(keys) > 0 {
+		creds.Headers = make(map[string]string, len(keys))
+		for _, key := range keys {
+			creds.Headers[strings.ToLower(key)], _ = thrift.GetHeader(ctx, key)
+		}
+	}
+	if state, ok := thrift.TLSStateFromContext(ctx); ok {
+		creds.TLS = state
+	} else if state, ok := ctx.Value(httpTLSKey{}).(*tls.ConnectionState); ok {
+		creds.TLS = state
+	}
+	return creds
+}
+
+// Validator authenticates the credentials of a request.
+//
+// It returns ErrNoCredentials if the request has none of the credentials it
+// validates, and another error if they are invalid.
+type Validator interface {
+	Validate(ctx context.Context, creds Credentials) (*Principal, error)
+}
+
+// ValidatorFunc is a function implementing Validator.
+type ValidatorFunc func(ctx context.Context, creds Credentials) (*Principal, error)
+
+// Validate calls f.
+func (f ValidatorFunc) Validate(ctx context.Context, creds Credentials) (*Principal, error) {
+	return f(ctx, creds)
+}
+
+// Options configures Middleware.
+type Options struct {
+	// Validators are tried in order, until one of them authenticates the
+	// request or fails with an error other than ErrNoCredentials.
+	Validators []Validator
+
+	// Public reports whether method can be called without credentials,
+	// e.g. health checks. The credentials of the calls to public methods
+	// are still validated when present.
+	//
+	// If nil, no method is public.
+	Public func(method string) bool
+
+	// OnReject is called for every rejected request, with ErrNoCredentials
+	// or the error of the Validator, for example to log it. The error is not
+	// sent to the client.
+	OnReject func(ctx context.Context, method string, err error)
+
+	// Oneway reports the oneway methods, see thrift.OnewayFunc.
+	Oneway thrift.OnewayFunc
+}
+
+// Middleware returns a thrift.ProcessorMiddleware authenticating the requests
+// with the Validators of opts.
+//
+// The rejected requests are replied with an INTERNAL_ERROR
+// TApplicationException of reason thrift.ExceptionReasonUnauthenticated,
+// without decoding their arguments.
+func Middleware(opts Options) thrift.ProcessorMiddleware {
+	return func(name string, next thrift.TProcessorFunction) thrift.TProcessorFunction {
+		public := opts.Public != nil && opts.Public(name)
+		return thrift.WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+				p, err := authenticate(ctx, opts.Validators, CredentialsFromContext(ctx))
+				if err == nil {
+					return next.Process(NewContext(ctx, p), seqID, in, out)
+				}
+				if public && errors.Is(err, ErrNoCredentials) {
+					return next.Process(ctx, seqID, in, out)
+				}
+				if opts.OnReject != nil {
+					opts.OnReject(ctx, name, err)
+				}
+				exc := thrift.NewTApplicationExceptionWithReason(thrift.INTERNAL_ERROR, thrift.ExceptionReasonUnauthenticated, "unauthenticated")
+				if err := reject(ctx, in, out, name, seqID, opts.Oneway.IsOneway(ctx, name), exc); err != nil {
+					return false, thrift.WrapTException(err)
+				}
+				return true, exc
+			},
+		}
+	}
+}
+
+func authenticate(ctx context.Context, validators []Validator, creds Credentials) (*Principal, error) {
+	for _, v := range validators {
+		p, err := v.Validate(ctx, creds)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return nil, ErrNoCredentials
+}
+
+// reject skips the arguments of a request and replies with exc.
+func reject(ctx context.Context, in, out thrift.TProtocol, name string, seqID int32, oneway bool, exc thrift.TApplicationException) error {
+	if err := in.Skip(ctx, thrift.STRUCT); err != nil {
+		return err
+	}
+	if err := in.ReadMessageEnd(ctx); err != nil {
+		return err
+	}
+	if oneway {
+		return nil
+	}
+	if err := out.WriteMessageBegin(ctx, name, thrift.EXCEPTION, seqID); err != nil {
+		return err
+	}
+	if err := exc.Write(ctx, out); err != nil {
+		return err
+	}
+	if err := out.WriteMessageEnd(ctx); err != nil {
+		return err
+	}
+	return out.Flush(ctx)
+}
+
+type httpTLSKey struct{}
+
+// HTTPHandler wraps the handler of a thrift HTTP server, see
+// thrift.NewThriftHandlerFunc, so that Middleware gets the credentials of the
+// requests from their HTTP headers and TLS connection state, and
+// thrift.PeerIdentityFromContext the identity of their client certificates.
+func HTTPHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := make(thrift.THeaderMap, len(r.Header))
+		for key, values := range r.Header {
+			if len(values) > 0 {
+				headers[strings.ToLower(key)] = values[0]
+			}
+		}
+		ctx := thrift.AddReadTHeaderToContext(r.Context(), headers)
+		if r.TLS != nil {
+			ctx = context.WithValue(ctx, httpTLSKey{}, r.TLS)
+			if id, ok := thrift.PeerIdentityFromTLSState(r.TLS); ok {
+				ctx = thrift.AddPeerIdentityToContext(ctx, id)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
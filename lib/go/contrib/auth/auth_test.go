@@ -0,0 +1,158 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftauth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// writeArgs writes empty args followed by the message end.
+func writeArgs(ctx context.Context, proto thrift.TProtocol) {
+	proto.WriteStructBegin(ctx, "args")
+	proto.WriteFieldStop(ctx)
+	proto.WriteStructEnd(ctx)
+	proto.WriteMessageEnd(ctx)
+}
+
+func TestMiddleware(t *testing.T) {
+	var rejected []error
+	var principal *Principal
+	middleware := Middleware(Options{
+		Validators: []Validator{
+			APIKeyValidator("x-api-key", StaticAPIKeys(map[string]Principal{
+				"secret": {Subject: "batch", Roles: []string{"admin"}},
+			})),
+		},
+		Public: func(method string) bool {
+			return method == "ping"
+		},
+		OnReject: func(ctx context.Context, method string, err error) {
+			rejected = append(rejected, err)
+		},
+	})
+	next := thrift.WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+			principal, _ = FromContext(ctx)
+			return true, nil
+		},
+	}
+	call := func(method, apiKey string) thrift.TException {
+		t.Helper()
+		principal = nil
+		ctx := context.Background()
+		if apiKey != "" {
+			ctx = thrift.AddReadTHeaderToContext(ctx, thrift.THeaderMap{"X-Api-Key": apiKey})
+		}
+		proto := thrift.NewTBinaryProtocolConf(thrift.NewTMemoryBuffer(), nil)
+		writeArgs(ctx, proto)
+		ok, err := middleware(method, next).Process(ctx, 1, proto, proto)
+		if !ok {
+			t.Fatalf("%s: expected the connection to be kept open", method)
+		}
+		return err
+	}
+
+	if err := call("get", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if principal == nil || principal.Subject != "batch" || principal.Scheme != "apikey" || !principal.HasRole("admin") {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+	if err := call("ping", ""); err != nil || principal != nil {
+		t.Errorf("expected public calls without credentials to be allowed, got %v, %+v", err, principal)
+	}
+
+	for _, apiKey := range []string{"", "wrong"} {
+		var tae thrift.TApplicationException
+		if err := call("get", apiKey); !errors.As(err, &tae) || thrift.ExceptionReason(err) != thrift.ExceptionReasonUnauthenticated {
+			t.Errorf("key %q: expected an unauthenticated TApplicationException, got %v", apiKey, err)
+		}
+	}
+	// Invalid credentials are rejected even for public methods.
+	if err := call("ping", "wrong"); err == nil {
+		t.Error("expected a public call with invalid credentials to be rejected")
+	}
+	if len(rejected) != 3 || !errors.Is(rejected[0], ErrNoCredentials) || !errors.Is(rejected[1], errUnknownAPIKey) {
+		t.Errorf("unexpected rejections %v", rejected)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	var creds Credentials
+	srv := httptest.NewServer(HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds = CredentialsFromContext(r.Context())
+	})))
+	defer srv.Close()
+	req, err := http.NewRequest(http.MethodPost, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer abc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if token := creds.BearerToken("authorization"); token != "abc" {
+		t.Errorf("expected the bearer token from the HTTP headers, got %q", token)
+	}
+}
+
+func TestMTLSValidator(t *testing.T) {
+	spiffeID, _ := url.Parse("spiffe://example.com/batch")
+	cert := &x509.Certificate{
+		Subject: pkix.Name{CommonName: "batch"},
+		URIs:    []*url.URL{spiffeID},
+	}
+	v := MTLSValidator(MTLSOptions{
+		Roles: func(cert *x509.Certificate) []string {
+			return []string{cert.Subject.CommonName}
+		},
+	})
+	ctx := context.Background()
+	if _, err := v.Validate(ctx, Credentials{}); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("expected ErrNoCredentials without TLS, got %v", err)
+	}
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if _, err := v.Validate(ctx, Credentials{TLS: state}); err == nil {
+		t.Error("expected unverified certificates to be rejected")
+	}
+	state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	p, err := v.Validate(ctx, Credentials{TLS: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "spiffe://example.com/batch" || p.Scheme != "mtls" || !p.HasRole("batch") {
+		t.Errorf("unexpected principal %+v", p)
+	}
+	if id, ok := p.Attributes["peer_identity"].(*thrift.TPeerIdentity); !ok || id.CommonName != "batch" {
+		t.Errorf("expected the peer identity in the attributes, got %v", p.Attributes)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftauth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// ErrPermissionDenied is returned by the Policies denying a call.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Policy authorizes the calls of the Principals.
+//
+// p is nil for the calls without a Principal, e.g. the calls to the public
+// methods of Middleware. Authorize returns nil to allow the call, and an
+// error to deny it, either ErrPermissionDenied or the reason of the denial,
+// e.g. the failure of an external authorizer.
+type Policy interface {
+	Authorize(ctx context.Context, p *Principal, method string) error
+}
+
+// PolicyFunc is a function implementing Policy, for example calling an
+// external authorizer.
+type PolicyFunc func(ctx context.Context, p *Principal, method string) error
+
+// Authorize calls f.
+func (f PolicyFunc) Authorize(ctx context.Context, p *Principal, method string) error {
+	return f(ctx, p, method)
+}
+
+// RolePolicy is a Policy allowing the methods listed for any role of the
+// Principal, and denying all the others.
+//
+// The methods listed for the role "*" are allowed to everyone, including the
+// calls without a Principal, and the method "*" matches all the methods.
+type RolePolicy map[string][]string
+
+// Authorize implements Policy.
+func (r RolePolicy) Authorize(ctx context.Context, p *Principal, method string) error {
+	if allows(r["*"], method) {
+		return nil
+	}
+	if p != nil {
+		for _, role := range p.Roles {
+			if allows(r[role], method) {
+				return nil
+			}
+		}
+	}
+	return ErrPermissionDenied
+}
+
+func allows(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method || m == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Denial is the audit record of a call denied by AuthorizationMiddleware.
+type Denial struct {
+	Time    time.Time
+	Service string
+	Method  string
+
+	// Principal is the Principal of the call, nil if it had none.
+	Principal *Principal
+
+	// Err is the error of the Policy.
+	Err error
+}
+
+// AuthorizationOptions configures AuthorizationMiddleware.
+type AuthorizationOptions struct {
+	// Policy authorizes the calls. It must not be nil.
+	Policy Policy
+
+	// Service is the name of the service in the Denials, for the servers
+	// with a TMultiplexedProcessor, see RegisterService.
+	Service string
+
+	// OnDeny is called for every denied call, for example to write it to an
+	// audit log. The error of the Policy is not sent to the client.
+	OnDeny func(ctx context.Context, denial Denial)
+
+	// Oneway reports the oneway methods, see thrift.OnewayFunc.
+	Oneway thrift.OnewayFunc
+}
+
+// AuthorizationMiddleware returns a thrift.ProcessorMiddleware authorizing
+// the calls with the Policy of opts, using the Principal set by Middleware,
+// which must be called first:
+//
+//	thrift.WrapProcessor(processor, thriftauth.Middleware(authn), thriftauth.AuthorizationMiddleware(authz))
+//
+// The denied calls are replied with an INTERNAL_ERROR TApplicationException
+// of reason thrift.ExceptionReasonPermissionDenied, without decoding their
+// arguments.
+func AuthorizationMiddleware(opts AuthorizationOptions) thrift.ProcessorMiddleware {
+	return func(name string, next thrift.TProcessorFunction) thrift.TProcessorFunction {
+		return thrift.WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+				p, _ := FromContext(ctx)
+				err := opts.Policy.Authorize(ctx, p, name)
+				if err == nil {
+					return next.Process(ctx, seqID, in, out)
+				}
+				if opts.OnDeny != nil {
+					opts.OnDeny(ctx, Denial{
+						Time:      time.Now(),
+						Service:   opts.Service,
+						Method:    name,
+						Principal: p,
+						Err:       err,
+					})
+				}
+				exc := thrift.NewTApplicationExceptionWithReason(thrift.INTERNAL_ERROR, thrift.ExceptionReasonPermissionDenied, "permission denied")
+				if err := reject(ctx, in, out, name, seqID, opts.Oneway.IsOneway(ctx, name), exc); err != nil {
+					return false, thrift.WrapTException(err)
+				}
+				return true, exc
+			},
+		}
+	}
+}
+
+// RegisterService registers processor as the service name of mp, with the
+// calls to the service authenticated with authn and authorized with authz, so
+// that each service of a multiplexed server has its own Policy. The Service
+// of authz is set to name.
+func RegisterService(mp *thrift.TMultiplexedProcessor, name string, processor thrift.TProcessor, authn Options, authz AuthorizationOptions) {
+	authz.Service = name
+	mp.RegisterProcessorWithMiddleware(name, processor, Middleware(authn), AuthorizationMiddleware(authz))
+}
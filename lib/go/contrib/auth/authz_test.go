@@ -0,0 +1,154 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// testProcessor dispatches the calls to the functions of its processor map.
+type testProcessor map[string]thrift.TProcessorFunction
+
+func (p testProcessor) Process(ctx context.Context, in, out thrift.TProtocol) (bool, thrift.TException) {
+	name, _, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, thrift.WrapTException(err)
+	}
+	return p[name].Process(ctx, seqID, in, out)
+}
+
+func (p testProcessor) ProcessorMap() map[string]thrift.TProcessorFunction {
+	return p
+}
+
+func (p testProcessor) AddToProcessorMap(name string, f thrift.TProcessorFunction) {
+	p[name] = f
+}
+
+func TestRolePolicy(t *testing.T) {
+	policy := RolePolicy{
+		"*":      {"ping"},
+		"reader": {"get"},
+		"admin":  {"*"},
+	}
+	ctx := context.Background()
+	for _, c := range []struct {
+		roles   []string
+		method  string
+		allowed bool
+	}{
+		{nil, "ping", true},
+		{nil, "get", false},
+		{[]string{"reader"}, "get", true},
+		{[]string{"reader"}, "delete", false},
+		{[]string{"reader", "admin"}, "delete", true},
+	} {
+		var p *Principal
+		if c.roles != nil {
+			p = &Principal{Subject: "client", Roles: c.roles}
+		}
+		err := policy.Authorize(ctx, p, c.method)
+		if c.allowed && err != nil {
+			t.Errorf("%v %s: expected the call to be allowed, got %v", c.roles, c.method, err)
+		}
+		if !c.allowed && !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%v %s: expected ErrPermissionDenied, got %v", c.roles, c.method, err)
+		}
+	}
+}
+
+func TestRegisterService(t *testing.T) {
+	var called []string
+	newProcessor := func(methods ...string) thrift.TProcessor {
+		p := make(testProcessor)
+		for _, method := range methods {
+			method := method
+			p[method] = thrift.WrappedTProcessorFunction{
+				Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+					called = append(called, method)
+					return true, nil
+				},
+			}
+		}
+		return p
+	}
+	var denials []Denial
+	authn := Options{
+		Validators: []Validator{
+			APIKeyValidator("x-api-key", StaticAPIKeys(map[string]Principal{
+				"reader": {Subject: "dashboard", Roles: []string{"reader"}},
+			})),
+		},
+	}
+	onDeny := func(ctx context.Context, denial Denial) {
+		denials = append(denials, denial)
+	}
+	external := errors.New("authorizer unavailable")
+	mp := thrift.NewTMultiplexedProcessor()
+	RegisterService(mp, "users", newProcessor("get", "delete"), authn, AuthorizationOptions{
+		Policy: RolePolicy{"reader": {"get"}},
+		OnDeny: onDeny,
+	})
+	RegisterService(mp, "billing", newProcessor("get"), authn, AuthorizationOptions{
+		Policy: PolicyFunc(func(ctx context.Context, p *Principal, method string) error {
+			return external
+		}),
+		OnDeny: onDeny,
+	})
+
+	call := func(name string) thrift.TException {
+		t.Helper()
+		ctx := thrift.AddReadTHeaderToContext(context.Background(), thrift.THeaderMap{"x-api-key": "reader"})
+		proto := thrift.NewTBinaryProtocolConf(thrift.NewTMemoryBuffer(), nil)
+		proto.WriteMessageBegin(ctx, name, thrift.CALL, 1)
+		writeArgs(ctx, proto)
+		ok, err := mp.Process(ctx, proto, proto)
+		if !ok {
+			t.Fatalf("%s: expected the connection to be kept open", name)
+		}
+		return err
+	}
+
+	if err := call("users:get"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"users:delete", "billing:get"} {
+		var tae thrift.TApplicationException
+		if err := call(name); !errors.As(err, &tae) || thrift.ExceptionReason(err) != thrift.ExceptionReasonPermissionDenied {
+			t.Errorf("%s: expected a permission denied TApplicationException, got %v", name, err)
+		}
+	}
+	if len(called) != 1 || called[0] != "get" {
+		t.Errorf("unexpected calls %v", called)
+	}
+	if len(denials) != 2 {
+		t.Fatalf("expected 2 denials, got %+v", denials)
+	}
+	if d := denials[0]; d.Service != "users" || d.Method != "delete" || d.Principal.Subject != "dashboard" || !errors.Is(d.Err, ErrPermissionDenied) {
+		t.Errorf("unexpected denial %+v", d)
+	}
+	if d := denials[1]; d.Service != "billing" || !errors.Is(d.Err, external) {
+		t.Errorf("unexpected denial %+v", d)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package thriftauth authenticates the clients of thrift servers.
+//
+// The middleware returned by Middleware extracts the credentials of every
+// request from its THeader headers (or HTTP headers, see HTTPHandler) and TLS
+// connection state, runs them through pluggable Validators (JWT, API keys,
+// mTLS identities, or custom ones), and makes the authenticated Principal
+// available to the handlers with FromContext. The unauthenticated calls are
+// rejected with an INTERNAL_ERROR TApplicationException of reason
+// thrift.ExceptionReasonUnauthenticated:
+//
+//	processor := thrift.WrapProcessor(NewMyServiceProcessor(handler), thriftauth.Middleware(thriftauth.Options{
+//		Validators: []thriftauth.Validator{
+//			thriftauth.JWTValidator(thriftauth.JWTOptions{Key: keys, Issuer: "https://issuer.example.com"}),
+//			thriftauth.MTLSValidator(thriftauth.MTLSOptions{}),
+//		},
+//	}))
+//
+// Clients send JWTs with thrift.BearerTokenMiddleware, and API keys in
+// THeaderProtocol headers, for example:
+//
+//	ctx = thrift.AppendOutgoingHeader(ctx, "x-api-key", key)
+//
+// The calls are then authorized with AuthorizationMiddleware, checking the
+// Principal against a Policy, e.g. a RolePolicy listing the methods allowed
+// to each role, or a PolicyFunc calling an external authorizer. The denied
+// calls are rejected with an INTERNAL_ERROR TApplicationException of reason
+// thrift.ExceptionReasonPermissionDenied and
+// reported to OnDeny for auditing. With a TMultiplexedProcessor,
+// RegisterService sets the policies of each service:
+//
+//	thriftauth.RegisterService(mp, "Users", NewUsersProcessor(handler), authn, thriftauth.AuthorizationOptions{
+//		Policy: thriftauth.RolePolicy{"reader": {"get"}, "admin": {"*"}},
+//		OnDeny: audit,
+//	})
+//
+// AuditMiddleware writes an AuditRecord for every call, with its Principal,
+// result status and the argument fields selected per method, redacted as
+// configured, to an AuditSink. The records are chained by their hashes, so
+// that altering or removing records is detected by VerifyAuditChain.
+package thriftauth
@@ -0,0 +1,7 @@
+module github.com/apache/thrift/lib/go/contrib/auth
+
+go 1.20
+
+require github.com/apache/thrift v0.0.0-00010101000000-000000000000
+
+replace github.com/apache/thrift => ../../../..
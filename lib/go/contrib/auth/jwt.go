@@ -0,0 +1,272 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	// The hash functions of the supported algorithms.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// JWTOptions configures JWTValidator.
+type JWTOptions struct {
+	// Key returns the key verifying the signature of the tokens with the
+	// given "alg" and "kid" header parameters:
+	//
+	//   - a []byte for HS256, HS384 and HS512,
+	//   - an *rsa.PublicKey for RS256, RS384 and RS512,
+	//   - an *ecdsa.PublicKey for ES256, ES384 and ES512.
+	//
+	// The tokens with another kind of key than expected by their
+	// algorithm are rejected.
+	Key func(alg, kid string) (interface{}, error)
+
+	// Issuer, if not empty, is the required "iss" claim.
+	Issuer string
+
+	// Audience, if not empty, is required in the "aud" claim.
+	Audience string
+
+	// RolesClaim is the claim with the roles of the Principal, either an
+	// array of strings or a space separated string. "roles" if empty.
+	RolesClaim string
+
+	// Leeway is the clock skew tolerated when checking the "exp" and "nbf"
+	// claims.
+	Leeway time.Duration
+
+	// Header is the header with the "Bearer <token>" value, "authorization"
+	// if empty.
+	Header string
+
+	// now is time.Now if nil.
+	now func() time.Time
+}
+
+// JWTValidator returns a Validator authenticating the JSON Web Tokens sent as
+// bearer tokens.
+//
+// The Principals it returns have the "sub" claim as Subject, "jwt" as Scheme
+// and the claims as Attributes.
+func JWTValidator(opts JWTOptions) Validator {
+	if opts.Header == "" {
+		opts.Header = "authorization"
+	}
+	if opts.RolesClaim == "" {
+		opts.RolesClaim = "roles"
+	}
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+	return ValidatorFunc(func(ctx context.Context, creds Credentials) (*Principal, error) {
+		token := creds.BearerToken(opts.Header)
+		if token == "" {
+			return nil, ErrNoCredentials
+		}
+		claims, err := verifyJWT(token, opts.Key)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkJWTClaims(claims, opts); err != nil {
+			return nil, err
+		}
+		p := &Principal{
+			Scheme:     "jwt",
+			Roles:      stringsClaim(claims[opts.RolesClaim]),
+			Attributes: claims,
+		}
+		p.Subject, _ = claims["sub"].(string)
+		return p, nil
+	})
+}
+
+type jwtAlgorithm struct {
+	hash crypto.Hash
+	// verify verifies sig with a key of the right type.
+	verify func(key interface{}, hash crypto.Hash, input, sig []byte) error
+}
+
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"HS256": {crypto.SHA256, verifyHMAC},
+	"HS384": {crypto.SHA384, verifyHMAC},
+	"HS512": {crypto.SHA512, verifyHMAC},
+	"RS256": {crypto.SHA256, verifyRSA},
+	"RS384": {crypto.SHA384, verifyRSA},
+	"RS512": {crypto.SHA512, verifyRSA},
+	"ES256": {crypto.SHA256, verifyECDSA},
+	"ES384": {crypto.SHA384, verifyECDSA},
+	"ES512": {crypto.SHA512, verifyECDSA},
+}
+
+var errInvalidSignature = errors.New("jwt: invalid signature")
+
+func verifyHMAC(key interface{}, hash crypto.Hash, input, sig []byte) error {
+	secret, ok := key.([]byte)
+	if !ok {
+		return fmt.Errorf("jwt: unexpected key type %T", key)
+	}
+	mac := hmac.New(hash.New, secret)
+	mac.Write(input)
+	if !hmac.Equal(mac.Sum(nil), sig) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+func verifyRSA(key interface{}, hash crypto.Hash, input, sig []byte) error {
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("jwt: unexpected key type %T", key)
+	}
+	h := hash.New()
+	h.Write(input)
+	if rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sig) != nil {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+func verifyECDSA(key interface{}, hash crypto.Hash, input, sig []byte) error {
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("jwt: unexpected key type %T", key)
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	if len(sig) != 2*size {
+		return errInvalidSignature
+	}
+	h := hash.New()
+	h.Write(input)
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+	if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// verifyJWT verifies the signature of token and returns its claims.
+func verifyJWT(token string, keys func(alg, kid string) (interface{}, error)) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jwt: malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	alg, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", header.Alg)
+	}
+	if keys == nil {
+		return nil, errors.New("jwt: no key")
+	}
+	key, err := keys(header.Alg, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("jwt: malformed signature")
+	}
+	if err := alg.verify(key, alg.hash, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("jwt: malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("jwt: malformed token: %w", err)
+	}
+	return nil
+}
+
+func checkJWTClaims(claims map[string]interface{}, opts JWTOptions) error {
+	now := opts.now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(unixTime(exp).Add(opts.Leeway)) {
+		return errors.New("jwt: token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(opts.Leeway).Before(unixTime(nbf)) {
+		return errors.New("jwt: token not valid yet")
+	}
+	if opts.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != opts.Issuer {
+			return fmt.Errorf("jwt: unexpected issuer %q", iss)
+		}
+	}
+	if opts.Audience != "" {
+		var found bool
+		for _, aud := range stringsClaim(claims["aud"]) {
+			found = found || aud == opts.Audience
+		}
+		if !found {
+			return fmt.Errorf("jwt: token not issued for %q", opts.Audience)
+		}
+	}
+	return nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// stringsClaim returns the strings of a claim which is either an array of
+// strings or a space separated string.
+func stringsClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeJWTPart(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(t *testing.T, secret []byte, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	input := encodeJWTPart(t, map[string]string{"alg": "HS256", "kid": kid}) + "." + encodeJWTPart(t, claims)
+	mac := hmac.New(crypto.SHA256.New, secret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	input := encodeJWTPart(t, map[string]string{"alg": "ES256", "kid": "k1"}) + "." + encodeJWTPart(t, claims)
+	h := crypto.SHA256.New()
+	h.Write([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func bearer(token string) Credentials {
+	return Credentials{Headers: map[string]string{"authorization": "Bearer " + token}}
+}
+
+func TestJWTValidator(t *testing.T) {
+	secret := []byte("secret")
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	v := JWTValidator(JWTOptions{
+		Key: func(alg, kid string) (interface{}, error) {
+			if kid == "k1" {
+				return &ecKey.PublicKey, nil
+			}
+			return secret, nil
+		},
+		Issuer:   "issuer",
+		Audience: "service",
+		now: func() time.Time {
+			return now
+		},
+	})
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":   "alice",
+			"iss":   "issuer",
+			"aud":   []string{"other", "service"},
+			"exp":   now.Add(time.Minute).Unix(),
+			"roles": "reader writer",
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	ctx := context.Background()
+
+	for _, token := range []string{
+		signHS256(t, secret, "", claims(nil)),
+		signES256(t, ecKey, claims(nil)),
+	} {
+		p, err := v.Validate(ctx, bearer(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Subject != "alice" || p.Scheme != "jwt" || !p.HasRole("writer") {
+			t.Errorf("unexpected principal %+v", p)
+		}
+	}
+
+	if _, err := v.Validate(ctx, Credentials{}); err != ErrNoCredentials {
+		t.Errorf("expected ErrNoCredentials, got %v", err)
+	}
+	tampered := strings.Split(signHS256(t, secret, "", claims(nil)), ".")
+	tampered[1] = encodeJWTPart(t, claims(map[string]interface{}{"sub": "mallory"}))
+	for label, token := range map[string]string{
+		"expired":    signHS256(t, secret, "", claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})),
+		"not before": signHS256(t, secret, "", claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})),
+		"issuer":     signHS256(t, secret, "", claims(map[string]interface{}{"iss": "other"})),
+		"audience":   signHS256(t, secret, "", claims(map[string]interface{}{"aud": "other"})),
+		"signature":  signHS256(t, []byte("wrong"), "", claims(nil)),
+		"tampered":   strings.Join(tampered, "."),
+		"malformed":  "abc",
+		"alg none":   encodeJWTPart(t, map[string]string{"alg": "none"}) + "." + encodeJWTPart(t, claims(nil)) + ".",
+		// HMAC signed with the public key, which is not a secret.
+		"key mismatch": signHS256(t, []byte("public key"), "k1", claims(nil)),
+	} {
+		if _, err := v.Validate(ctx, bearer(token)); err == nil || err == ErrNoCredentials {
+			t.Errorf("%s: expected the token to be rejected, got %v", label, err)
+		}
+	}
+}
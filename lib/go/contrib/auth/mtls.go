@@ -0,0 +1,81 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftauth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// MTLSOptions configures MTLSValidator.
+type MTLSOptions struct {
+	// Subject returns the Subject of the Principal of a client
+	// certificate. If nil, it's its SPIFFE ID, or its first URI SAN if it has
+	// none, or its Common Name if it has no URI SAN.
+	Subject func(cert *x509.Certificate) string
+
+	// Roles returns the Roles of the Principal of a client certificate, nil
+	// if nil.
+	Roles func(cert *x509.Certificate) []string
+}
+
+// MTLSValidator returns a Validator authenticating the clients by their TLS
+// client certificates.
+//
+// The certificates must be verified during the TLS handshake, by setting the
+// ClientAuth of the tls.Config of the server to
+// tls.RequireAndVerifyClientCert or tls.VerifyClientCertIfGiven. The
+// Principals have "mtls" as Scheme, and the thrift.TPeerIdentity of the
+// client as the "peer_identity" Attribute.
+func MTLSValidator(opts MTLSOptions) Validator {
+	return ValidatorFunc(func(ctx context.Context, creds Credentials) (*Principal, error) {
+		if creds.TLS == nil || len(creds.TLS.PeerCertificates) == 0 {
+			return nil, ErrNoCredentials
+		}
+		id, ok := thrift.PeerIdentityFromTLSState(creds.TLS)
+		if !ok {
+			return nil, errors.New("mtls: client certificate not verified")
+		}
+		cert := id.Certificate
+		p := &Principal{
+			Scheme: "mtls",
+			Attributes: map[string]interface{}{
+				"peer_identity": id,
+			},
+		}
+		switch {
+		case opts.Subject != nil:
+			p.Subject = opts.Subject(cert)
+		case id.SPIFFEID != "":
+			p.Subject = id.SPIFFEID
+		case len(id.URIs) > 0:
+			p.Subject = id.URIs[0].String()
+		default:
+			p.Subject = id.CommonName
+		}
+		if opts.Roles != nil {
+			p.Roles = opts.Roles(cert)
+		}
+		return p, nil
+	})
+}
@@ -0,0 +1,232 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package thriftotel provides OpenTelemetry tracing for thrift servers and
+// clients.
+//
+// Spans are created per RPC by the middlewares returned by
+// ProcessorMiddleware and ClientMiddleware, and the trace context is
+// propagated through THeader headers, so it requires THeaderProtocol on both
+// ends to link client and server spans.
+//
+// It lives in its own module, so that the thrift library itself doesn't
+// depend on OpenTelemetry.
+package thriftotel
+
+import (
+	"context"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer used by this package.
+const InstrumentationName = "github.com/apache/thrift/lib/go/contrib/otel"
+
+// Options configures the middlewares.
+type Options struct {
+	// Service is the name of the thrift service, used in the span names and
+	// the rpc.service attribute.
+	//
+	// On the server side, it's only used for the methods not prefixed by a
+	// service name, which is the case for all the methods unless the
+	// processor is a TMultiplexedProcessor.
+	Service string
+
+	// TracerProvider creating the spans, otel.GetTracerProvider() if nil.
+	TracerProvider trace.TracerProvider
+
+	// Propagators propagating the trace context through THeaders,
+	// otel.GetTextMapPropagator() if nil.
+	Propagators propagation.TextMapPropagator
+}
+
+func (o Options) tracer() trace.Tracer {
+	tp := o.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(InstrumentationName)
+}
+
+func (o Options) propagators() propagation.TextMapPropagator {
+	if o.Propagators != nil {
+		return o.Propagators
+	}
+	return otel.GetTextMapPropagator()
+}
+
+// ProcessorMiddleware returns a thrift.ProcessorMiddleware creating a server
+// span for every request, as a child of the trace context read from the
+// THeaders of the request if any.
+//
+// The spans have an error status when the processor function returns an
+// error, which is the case when the handler returns an error that is not an
+// exception declared in the IDL.
+func ProcessorMiddleware(opts Options) thrift.ProcessorMiddleware {
+	tracer := opts.tracer()
+	propagators := opts.propagators()
+	return func(name string, next thrift.TProcessorFunction) thrift.TProcessorFunction {
+		service, method := opts.Service, name
+		if i := strings.Index(name, thrift.MULTIPLEXED_SEPARATOR); i >= 0 {
+			service, method = name[:i], name[i+len(thrift.MULTIPLEXED_SEPARATOR):]
+		}
+		spanName := spanName(service, method)
+		attrs := rpcAttributes(service, method)
+		return thrift.WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+				ctx = propagators.Extract(ctx, readHeaderCarrier{ctx})
+				ctx, span := tracer.Start(
+					ctx,
+					spanName,
+					trace.WithSpanKind(trace.SpanKindServer),
+					trace.WithAttributes(attrs...),
+				)
+				defer span.End()
+
+				ok, err := next.Process(ctx, seqID, in, out)
+				if err != nil {
+					recordError(span, err)
+				}
+				return ok, err
+			},
+		}
+	}
+}
+
+// ClientMiddleware returns a thrift.ClientMiddleware creating a client span
+// for every call, and propagating it to the server through THeaders.
+func ClientMiddleware(opts Options) thrift.ClientMiddleware {
+	tracer := opts.tracer()
+	propagators := opts.propagators()
+	return func(next thrift.TClient) thrift.TClient {
+		return thrift.WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result thrift.TStruct) (thrift.ResponseMeta, error) {
+				ctx, span := tracer.Start(
+					ctx,
+					spanName(opts.Service, method),
+					trace.WithSpanKind(trace.SpanKindClient),
+					trace.WithAttributes(rpcAttributes(opts.Service, method)...),
+				)
+				defer span.End()
+
+				carrier := make(propagation.MapCarrier)
+				propagators.Inject(ctx, carrier)
+				ctx = setWriteHeaders(ctx, carrier)
+
+				meta, err := next.Call(ctx, method, args, result)
+				if err != nil {
+					recordError(span, err)
+				}
+				return meta, err
+			},
+		}
+	}
+}
+
+func spanName(service, method string) string {
+	if service == "" {
+		return method
+	}
+	return service + "/" + method
+}
+
+func rpcAttributes(service, method string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("rpc.system", "apache_thrift"),
+		attribute.String("rpc.method", method),
+	}
+	if service != "" {
+		attrs = append(attrs, attribute.String("rpc.service", service))
+	}
+	return attrs
+}
+
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	if te, ok := err.(thrift.TException); ok {
+		span.SetAttributes(attribute.String("rpc.thrift.exception_type", exceptionType(te)))
+	}
+}
+
+func exceptionType(err thrift.TException) string {
+	switch err.TExceptionType() {
+	case thrift.TExceptionTypeApplication:
+		return "application"
+	case thrift.TExceptionTypeProtocol:
+		return "protocol"
+	case thrift.TExceptionTypeTransport:
+		return "transport"
+	case thrift.TExceptionTypeCompiled:
+		return "compiled"
+	default:
+		return "unknown"
+	}
+}
+
+// readHeaderCarrier is a propagation.TextMapCarrier reading the THeaders of
+// a server request from its context.
+type readHeaderCarrier struct {
+	ctx context.Context
+}
+
+func (c readHeaderCarrier) Get(key string) string {
+	value, _ := thrift.GetHeader(c.ctx, key)
+	return value
+}
+
+func (c readHeaderCarrier) Set(key, value string) {}
+
+func (c readHeaderCarrier) Keys() []string {
+	return thrift.GetReadHeaderList(c.ctx)
+}
+
+// setWriteHeaders adds headers to the THeaders to write from ctx.
+func setWriteHeaders(ctx context.Context, headers propagation.MapCarrier) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	existing := thrift.GetWriteHeaderList(ctx)
+	keys := make([]string, len(existing), len(existing)+len(headers))
+	copy(keys, existing)
+	for key, value := range headers {
+		ctx = thrift.SetHeader(ctx, key, value)
+		if !contains(existing, key) {
+			keys = append(keys, key)
+		}
+	}
+	return thrift.SetWriteHeaderList(ctx, keys)
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+var _ propagation.TextMapCarrier = readHeaderCarrier{}
@@ -0,0 +1,242 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// stringStruct is a TStruct with a single string field, used as both the
+// args and the result of the test service.
+type stringStruct struct {
+	id    int16
+	value string
+}
+
+func (s *stringStruct) Write(ctx context.Context, p thrift.TProtocol) error {
+	p.WriteStructBegin(ctx, "stringStruct")
+	p.WriteFieldBegin(ctx, "value", thrift.STRING, s.id)
+	p.WriteString(ctx, s.value)
+	p.WriteFieldEnd(ctx)
+	p.WriteFieldStop(ctx)
+	return p.WriteStructEnd(ctx)
+}
+
+func (s *stringStruct) Read(ctx context.Context, p thrift.TProtocol) error {
+	if _, err := p.ReadStructBegin(ctx); err != nil {
+		return err
+	}
+	for {
+		_, typeID, id, err := p.ReadFieldBegin(ctx)
+		if err != nil {
+			return err
+		}
+		if typeID == thrift.STOP {
+			break
+		}
+		if id == s.id && typeID == thrift.STRING {
+			if s.value, err = p.ReadString(ctx); err != nil {
+				return err
+			}
+		} else if err := p.Skip(ctx, typeID); err != nil {
+			return err
+		}
+		p.ReadFieldEnd(ctx)
+	}
+	return p.ReadStructEnd(ctx)
+}
+
+// testProcessor dispatches the requests to its processor map, like the
+// generated processors.
+type testProcessor struct {
+	processorMap map[string]thrift.TProcessorFunction
+}
+
+func (p *testProcessor) ProcessorMap() map[string]thrift.TProcessorFunction {
+	return p.processorMap
+}
+
+func (p *testProcessor) AddToProcessorMap(name string, f thrift.TProcessorFunction) {
+	p.processorMap[name] = f
+}
+
+func (p *testProcessor) Process(ctx context.Context, in, out thrift.TProtocol) (bool, thrift.TException) {
+	name, _, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, thrift.WrapTException(err)
+	}
+	return p.processorMap[name].Process(ctx, seqID, in, out)
+}
+
+// echoFunction is a processor function calling handler with the args, like
+// the generated ones.
+func echoFunction(name string, handler func(ctx context.Context, value string) (string, error)) thrift.TProcessorFunction {
+	return thrift.WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+			args := &stringStruct{id: 1}
+			if err := args.Read(ctx, in); err != nil {
+				return false, thrift.WrapTException(err)
+			}
+			in.ReadMessageEnd(ctx)
+			value, err := handler(ctx, args.value)
+			if err != nil {
+				x := thrift.NewTApplicationException(thrift.INTERNAL_ERROR, err.Error())
+				out.WriteMessageBegin(ctx, name, thrift.EXCEPTION, seqID)
+				x.Write(ctx, out)
+				out.WriteMessageEnd(ctx)
+				out.Flush(ctx)
+				return true, thrift.WrapTException(err)
+			}
+			out.WriteMessageBegin(ctx, name, thrift.REPLY, seqID)
+			(&stringStruct{id: 0, value: value}).Write(ctx, out)
+			out.WriteMessageEnd(ctx)
+			return true, thrift.WrapTException(out.Flush(ctx))
+		},
+	}
+}
+
+func TestClientAndServerSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	opts := Options{
+		Service:        "Echo",
+		TracerProvider: provider,
+		Propagators:    propagation.TraceContext{},
+	}
+
+	handlerSpans := make(chan trace.SpanContext, 2)
+	handler := func(ctx context.Context, value string) (string, error) {
+		handlerSpans <- trace.SpanContextFromContext(ctx)
+		if value == "fail" {
+			return "", errors.New("boom")
+		}
+		return value, nil
+	}
+	processor := thrift.WrapProcessor(&testProcessor{
+		processorMap: map[string]thrift.TProcessorFunction{
+			"echo": echoFunction("echo", handler),
+		},
+	}, ProcessorMiddleware(opts))
+
+	serverTrans, err := thrift.NewTServerSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &thrift.TConfiguration{SocketTimeout: 5 * time.Second}
+	protoFactory := thrift.NewTHeaderProtocolFactoryConf(conf)
+	server := thrift.NewTSimpleServer4(processor, serverTrans, thrift.NewTTransportFactory(), protoFactory)
+	server.SetLogger(func(string) {})
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go server.AcceptLoop()
+	defer server.Stop()
+
+	sock, err := thrift.NewTSocketConf(serverTrans.Addr().String(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sock.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+	proto := protoFactory.GetProtocol(sock)
+	client := thrift.WrapClient(thrift.NewTStandardClient(proto, proto), ClientMiddleware(opts))
+
+	ctx := context.Background()
+	result := &stringStruct{id: 0}
+	if _, err := client.Call(ctx, "echo", &stringStruct{id: 1, value: "hello"}, result); err != nil {
+		t.Fatal(err)
+	}
+	if result.value != "hello" {
+		t.Errorf("expected echo of %q, got %q", "hello", result.value)
+	}
+	if _, err := client.Call(ctx, "echo", &stringStruct{id: 1, value: "fail"}, &stringStruct{id: 0}); err == nil {
+		t.Error("expected error")
+	}
+
+	// Server spans end after the reply is written, give them some time.
+	deadline := time.Now().Add(time.Second)
+	for len(recorder.Ended()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("expected 4 spans, got %d", len(spans))
+	}
+
+	var clientSpans, serverSpans []sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.Name() != "Echo/echo" {
+			t.Errorf("unexpected span name %q", span.Name())
+		}
+		switch span.SpanKind() {
+		case trace.SpanKindClient:
+			clientSpans = append(clientSpans, span)
+		case trace.SpanKindServer:
+			serverSpans = append(serverSpans, span)
+		}
+	}
+	if len(clientSpans) != 2 || len(serverSpans) != 2 {
+		t.Fatalf("expected 2 client and 2 server spans, got %d and %d", len(clientSpans), len(serverSpans))
+	}
+	for i := range clientSpans {
+		clientSpan := clientSpans[i]
+		var serverSpan sdktrace.ReadOnlySpan
+		for _, s := range serverSpans {
+			if s.Parent().SpanID() == clientSpan.SpanContext().SpanID() {
+				serverSpan = s
+			}
+		}
+		if serverSpan == nil {
+			t.Fatalf("no server span for client span %v", clientSpan.SpanContext().SpanID())
+		}
+		if serverSpan.SpanContext().TraceID() != clientSpan.SpanContext().TraceID() {
+			t.Error("expected server span in the client trace")
+		}
+		handlerSpan := <-handlerSpans
+		if handlerSpan.TraceID() != clientSpan.SpanContext().TraceID() {
+			t.Error("expected handler context to carry the trace")
+		}
+	}
+	// Spans are recorded in the order they end, so the first client span is
+	// the one of the successful call.
+	if clientSpans[0].Status().Code == codes.Error {
+		t.Errorf("unexpected error status on the successful call: %v", clientSpans[0].Status())
+	}
+	if clientSpans[1].Status().Code != codes.Error {
+		t.Error("expected error status on the client span of the failed call")
+	}
+	for _, s := range serverSpans {
+		if s.Parent().SpanID() == clientSpans[1].SpanContext().SpanID() && s.Status().Code != codes.Error {
+			t.Error("expected error status on the server span of the failed call")
+		}
+	}
+}
@@ -0,0 +1,259 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftprometheus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ClientMetricsOptions configures ClientMetrics.
+type ClientMetricsOptions struct {
+	// Namespace of the metrics, "thrift" if empty.
+	Namespace string
+
+	// Service is the service label of the calls.
+	Service string
+
+	// Buckets of the latency histogram, prometheus.DefBuckets if nil.
+	Buckets []float64
+
+	// ConstLabels are added to all the metrics.
+	ConstLabels prometheus.Labels
+}
+
+// ClientMetrics is a prometheus.Collector of per service/method client
+// metrics:
+//
+// * thrift_client_calls_total: the number of calls made;
+//
+// * thrift_client_errors_total: the number of calls failed, with the type of
+// the error (application, protocol, transport or unknown) or the name of the
+// exception declared in the IDL as the type label;
+//
+// * thrift_client_call_duration_seconds: the latency histogram;
+//
+// * thrift_client_in_flight_calls: the number of calls waiting for a reply.
+//
+// The metrics are recorded by the thrift.ClientMiddleware returned by
+// Middleware. The connections of the client are described by per address
+// metrics, recorded by the thrift.TConnectionListener returned by
+// ConnectionListener:
+//
+// * thrift_client_connections: the number of open connections;
+//
+// * thrift_client_dials_total: the number of dials, with their result
+// (success or failure) as the result label, and the TLS handshakes failing
+// after a successful dial as handshake_failure;
+//
+// * thrift_client_connection_errors_total: the number of failed reads and
+// writes.
+type ClientMetrics struct {
+	service string
+
+	calls    *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+
+	connections      *prometheus.GaugeVec
+	dials            *prometheus.CounterVec
+	connectionErrors *prometheus.CounterVec
+}
+
+// NewClientMetrics creates ClientMetrics, which need to be registered to a
+// prometheus.Registerer to be exported.
+func NewClientMetrics(opts ClientMetricsOptions) *ClientMetrics {
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = "thrift"
+	}
+	buckets := opts.Buckets
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	labels := []string{"service", "method"}
+	return &ClientMetrics{
+		service: opts.Service,
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "client",
+			Name:        "calls_total",
+			Help:        "Number of calls made by the client.",
+			ConstLabels: opts.ConstLabels,
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "client",
+			Name:        "errors_total",
+			Help:        "Number of calls failed with an error or a declared exception.",
+			ConstLabels: opts.ConstLabels,
+		}, append(labels, "type")),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   "client",
+			Name:        "call_duration_seconds",
+			Help:        "Latency of the calls made by the client.",
+			Buckets:     buckets,
+			ConstLabels: opts.ConstLabels,
+		}, labels),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "client",
+			Name:        "in_flight_calls",
+			Help:        "Number of calls waiting for a reply.",
+			ConstLabels: opts.ConstLabels,
+		}, labels),
+		connections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "client",
+			Name:        "connections",
+			Help:        "Number of open connections of the client.",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"address"}),
+		dials: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "client",
+			Name:        "dials_total",
+			Help:        "Number of connections dialed by the client.",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"address", "result"}),
+		connectionErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "client",
+			Name:        "connection_errors_total",
+			Help:        "Number of failed reads and writes of the connections of the client.",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"address"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *ClientMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.calls.Describe(ch)
+	m.errors.Describe(ch)
+	m.latency.Describe(ch)
+	m.inFlight.Describe(ch)
+	m.connections.Describe(ch)
+	m.dials.Describe(ch)
+	m.connectionErrors.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *ClientMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.calls.Collect(ch)
+	m.errors.Collect(ch)
+	m.latency.Collect(ch)
+	m.inFlight.Collect(ch)
+	m.connections.Collect(ch)
+	m.dials.Collect(ch)
+	m.connectionErrors.Collect(ch)
+}
+
+// Middleware returns the thrift.ClientMiddleware recording the metrics of the
+// calls, to be used with thrift.WrapClient.
+func (m *ClientMetrics) Middleware() thrift.ClientMiddleware {
+	return func(next thrift.TClient) thrift.TClient {
+		return thrift.WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result thrift.TStruct) (thrift.ResponseMeta, error) {
+				inFlight := m.inFlight.WithLabelValues(m.service, method)
+				inFlight.Inc()
+				start := time.Now()
+				meta, err := next.Call(ctx, method, args, result)
+				m.latency.WithLabelValues(m.service, method).Observe(time.Since(start).Seconds())
+				inFlight.Dec()
+				m.calls.WithLabelValues(m.service, method).Inc()
+				if err != nil {
+					m.errors.WithLabelValues(m.service, method, callErrorType(err)).Inc()
+				} else if exception := declaredException(result); exception != "" {
+					m.errors.WithLabelValues(m.service, method, exception).Inc()
+				}
+				return meta, err
+			},
+		}
+	}
+}
+
+// ConnectionListener returns the thrift.TConnectionListener recording the
+// metrics of the connections, to be set in the thrift.TConfiguration of the
+// sockets of the client.
+func (m *ClientMetrics) ConnectionListener() thrift.TConnectionListener {
+	return thrift.TConnectionListenerFunc(func(event thrift.TConnectionEvent) {
+		switch event.Type {
+		case thrift.ConnectionDialSuccess:
+			m.connections.WithLabelValues(event.Address).Inc()
+			m.dials.WithLabelValues(event.Address, "success").Inc()
+		case thrift.ConnectionDialFailure:
+			m.dials.WithLabelValues(event.Address, "failure").Inc()
+		case thrift.ConnectionHandshake:
+			// The connection is closed without ConnectionClose when the
+			// handshake fails.
+			if event.Err != nil {
+				m.connections.WithLabelValues(event.Address).Dec()
+				m.dials.WithLabelValues(event.Address, "handshake_failure").Inc()
+			}
+		case thrift.ConnectionClose:
+			m.connections.WithLabelValues(event.Address).Dec()
+		case thrift.ConnectionError:
+			m.connectionErrors.WithLabelValues(event.Address).Inc()
+		}
+	})
+}
+
+// callErrorType returns the type label of an error returned by a call.
+func callErrorType(err error) string {
+	var te thrift.TException
+	if !errors.As(err, &te) {
+		return "unknown"
+	}
+	return errorType(te)
+}
+
+// declaredException returns the name of the exception declared in the IDL
+// set in result, "" if none.
+//
+// The generated result structs have the success as field 0, and the
+// exceptions as the other fields.
+func declaredException(result thrift.TStruct) string {
+	v := reflect.ValueOf(result)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		tag := strings.Split(field.Tag.Get("thrift"), ",")
+		if len(tag) < 2 || tag[1] == "0" || field.Type.Kind() != reflect.Ptr {
+			continue
+		}
+		if !v.Field(i).IsNil() {
+			return field.Type.Elem().Name()
+		}
+	}
+	return ""
+}
+
+var _ prometheus.Collector = (*ClientMetrics)(nil)
@@ -0,0 +1,142 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftprometheus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// NotFound is an exception declared in the IDL.
+type NotFound struct{}
+
+func (*NotFound) Error() string {
+	return "not found"
+}
+
+// testResult is a result struct like the generated ones.
+type testResult struct {
+	Success  *string   `thrift:"success,0" db:"success" json:"success,omitempty"`
+	NotFound *NotFound `thrift:"notFound,1" db:"notFound" json:"notFound,omitempty"`
+}
+
+func (*testResult) Read(ctx context.Context, in thrift.TProtocol) error {
+	return nil
+}
+
+func (*testResult) Write(ctx context.Context, out thrift.TProtocol) error {
+	return nil
+}
+
+func TestClientMetrics(t *testing.T) {
+	metrics := NewClientMetrics(ClientMetricsOptions{Service: "Store"})
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(metrics)
+
+	client := thrift.WrapClient(thrift.WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result thrift.TStruct) (thrift.ResponseMeta, error) {
+			switch method {
+			case "missing":
+				result.(*testResult).NotFound = &NotFound{}
+			case "rejected":
+				return thrift.ResponseMeta{}, thrift.NewTApplicationExceptionWithReason(thrift.INTERNAL_ERROR, thrift.ExceptionReasonRateLimited, "rate limited")
+			case "broken":
+				return thrift.ResponseMeta{}, errors.New("broken")
+			default:
+				value := "value"
+				result.(*testResult).Success = &value
+			}
+			return thrift.ResponseMeta{}, nil
+		},
+	}, metrics.Middleware())
+
+	ctx := context.Background()
+	for _, method := range []string{"get", "get", "missing", "rejected", "broken"} {
+		client.Call(ctx, method, nil, &testResult{})
+	}
+
+	expected := `
+# HELP thrift_client_calls_total Number of calls made by the client.
+# TYPE thrift_client_calls_total counter
+thrift_client_calls_total{method="broken",service="Store"} 1
+thrift_client_calls_total{method="get",service="Store"} 2
+thrift_client_calls_total{method="missing",service="Store"} 1
+thrift_client_calls_total{method="rejected",service="Store"} 1
+# HELP thrift_client_errors_total Number of calls failed with an error or a declared exception.
+# TYPE thrift_client_errors_total counter
+thrift_client_errors_total{method="broken",service="Store",type="unknown"} 1
+thrift_client_errors_total{method="missing",service="Store",type="NotFound"} 1
+thrift_client_errors_total{method="rejected",service="Store",type="application"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"thrift_client_calls_total",
+		"thrift_client_errors_total",
+	); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(metrics, "thrift_client_call_duration_seconds"); n != 4 {
+		t.Errorf("expected 4 latency histograms, got %d", n)
+	}
+}
+
+func TestClientMetricsConnections(t *testing.T) {
+	metrics := NewClientMetrics(ClientMetricsOptions{})
+	listener := metrics.ConnectionListener()
+	for _, event := range []thrift.TConnectionEvent{
+		{Type: thrift.ConnectionDialStart, Address: "a:1"},
+		{Type: thrift.ConnectionDialSuccess, Address: "a:1"},
+		{Type: thrift.ConnectionHandshake, Address: "a:1"},
+		{Type: thrift.ConnectionDialStart, Address: "a:1"},
+		{Type: thrift.ConnectionDialSuccess, Address: "a:1"},
+		{Type: thrift.ConnectionHandshake, Address: "a:1", Err: errors.New("bad certificate")},
+		{Type: thrift.ConnectionDialStart, Address: "b:1"},
+		{Type: thrift.ConnectionDialFailure, Address: "b:1", Err: errors.New("refused")},
+		{Type: thrift.ConnectionError, Address: "a:1", Err: errors.New("reset")},
+	} {
+		listener.OnConnectionEvent(event)
+	}
+
+	expected := `
+# HELP thrift_client_connection_errors_total Number of failed reads and writes of the connections of the client.
+# TYPE thrift_client_connection_errors_total counter
+thrift_client_connection_errors_total{address="a:1"} 1
+# HELP thrift_client_connections Number of open connections of the client.
+# TYPE thrift_client_connections gauge
+thrift_client_connections{address="a:1"} 1
+# HELP thrift_client_dials_total Number of connections dialed by the client.
+# TYPE thrift_client_dials_total counter
+thrift_client_dials_total{address="a:1",result="handshake_failure"} 1
+thrift_client_dials_total{address="a:1",result="success"} 2
+thrift_client_dials_total{address="b:1",result="failure"} 1
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected),
+		"thrift_client_connection_errors_total",
+		"thrift_client_connections",
+		"thrift_client_dials_total",
+	); err != nil {
+		t.Error(err)
+	}
+}
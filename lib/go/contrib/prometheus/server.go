@@ -0,0 +1,218 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftprometheus
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ServerMetricsOptions configures ServerMetrics.
+type ServerMetricsOptions struct {
+	// Namespace of the metrics, "thrift" if empty.
+	Namespace string
+
+	// Service is the service label of the methods not prefixed by a service
+	// name, which is the case for all the methods unless the processor is a
+	// TMultiplexedProcessor.
+	Service string
+
+	// Buckets of the latency histogram, prometheus.DefBuckets if nil.
+	Buckets []float64
+
+	// ConstLabels are added to all the metrics.
+	ConstLabels prometheus.Labels
+}
+
+// ServerMetrics is a prometheus.Collector of per service/method server
+// metrics:
+//
+// * thrift_server_requests_total: the number of requests handled;
+//
+// * thrift_server_errors_total: the number of requests failed with an error,
+// with the type of the error (application, protocol, transport or unknown for
+// handler errors) as the type label;
+//
+// * thrift_server_request_duration_seconds: the latency histogram;
+//
+// * thrift_server_in_flight_requests: the number of requests being handled;
+//
+// * thrift_server_received_bytes_total and thrift_server_sent_bytes_total:
+// the size of the requests and responses, see TransportFactory.
+//
+// The metrics are recorded by the ProcessorMiddleware returned by Middleware.
+type ServerMetrics struct {
+	service string
+
+	requests      *prometheus.CounterVec
+	errors        *prometheus.CounterVec
+	latency       *prometheus.HistogramVec
+	inFlight      *prometheus.GaugeVec
+	receivedBytes *prometheus.CounterVec
+	sentBytes     *prometheus.CounterVec
+}
+
+// NewServerMetrics creates ServerMetrics, which need to be registered to a
+// prometheus.Registerer to be exported.
+func NewServerMetrics(opts ServerMetricsOptions) *ServerMetrics {
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = "thrift"
+	}
+	buckets := opts.Buckets
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	labels := []string{"service", "method"}
+	return &ServerMetrics{
+		service: opts.Service,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "server",
+			Name:        "requests_total",
+			Help:        "Number of requests handled by the server.",
+			ConstLabels: opts.ConstLabels,
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "server",
+			Name:        "errors_total",
+			Help:        "Number of requests failed with an error.",
+			ConstLabels: opts.ConstLabels,
+		}, append(labels, "type")),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   "server",
+			Name:        "request_duration_seconds",
+			Help:        "Latency of the requests handled by the server.",
+			Buckets:     buckets,
+			ConstLabels: opts.ConstLabels,
+		}, labels),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "server",
+			Name:        "in_flight_requests",
+			Help:        "Number of requests being handled by the server.",
+			ConstLabels: opts.ConstLabels,
+		}, labels),
+		receivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "server",
+			Name:        "received_bytes_total",
+			Help:        "Size of the requests received by the server.",
+			ConstLabels: opts.ConstLabels,
+		}, labels),
+		sentBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "server",
+			Name:        "sent_bytes_total",
+			Help:        "Size of the responses sent by the server.",
+			ConstLabels: opts.ConstLabels,
+		}, labels),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *ServerMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.errors.Describe(ch)
+	m.latency.Describe(ch)
+	m.inFlight.Describe(ch)
+	m.receivedBytes.Describe(ch)
+	m.sentBytes.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *ServerMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.errors.Collect(ch)
+	m.latency.Collect(ch)
+	m.inFlight.Collect(ch)
+	m.receivedBytes.Collect(ch)
+	m.sentBytes.Collect(ch)
+}
+
+// Middleware returns the thrift.ProcessorMiddleware recording the metrics,
+// to be used with thrift.WrapProcessor.
+func (m *ServerMetrics) Middleware() thrift.ProcessorMiddleware {
+	return func(name string, next thrift.TProcessorFunction) thrift.TProcessorFunction {
+		service, method := m.service, name
+		if i := strings.Index(name, thrift.MULTIPLEXED_SEPARATOR); i >= 0 {
+			service, method = name[:i], name[i+len(thrift.MULTIPLEXED_SEPARATOR):]
+		}
+		requests := m.requests.WithLabelValues(service, method)
+		latency := m.latency.WithLabelValues(service, method)
+		inFlight := m.inFlight.WithLabelValues(service, method)
+		receivedBytes := m.receivedBytes.WithLabelValues(service, method)
+		sentBytes := m.sentBytes.WithLabelValues(service, method)
+		return thrift.WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+				inFlight.Inc()
+				start := time.Now()
+				ok, err := next.Process(ctx, seqID, in, out)
+				latency.Observe(time.Since(start).Seconds())
+				inFlight.Dec()
+				requests.Inc()
+				if err != nil {
+					m.errors.WithLabelValues(service, method, errorType(err)).Inc()
+				}
+				request, response := thrift.PayloadSizes(in, out)
+				if request >= 0 {
+					receivedBytes.Add(float64(request))
+				}
+				if response >= 0 {
+					sentBytes.Add(float64(response))
+				}
+				return ok, err
+			},
+		}
+	}
+}
+
+func errorType(err thrift.TException) string {
+	switch err.TExceptionType() {
+	case thrift.TExceptionTypeApplication:
+		return "application"
+	case thrift.TExceptionTypeProtocol:
+		return "protocol"
+	case thrift.TExceptionTypeTransport:
+		return "transport"
+	default:
+		return "unknown"
+	}
+}
+
+// TransportFactory wraps factory to count the bytes read and written by the
+// server, so that the ServerMetrics middleware can report them. It is
+// thrift.AccessLogTransportFactory, so the sizes are shared with
+// thrift.AccessLogMiddleware.
+//
+// It must be used as both the input and output transport factories of the
+// server. Sizes are not reported with THeaderProtocol, as THeaderTransport
+// hides the transports created by factory from the middleware.
+func TransportFactory(factory thrift.TTransportFactory) thrift.TTransportFactory {
+	return thrift.AccessLogTransportFactory(factory)
+}
+
+var _ prometheus.Collector = (*ServerMetrics)(nil)
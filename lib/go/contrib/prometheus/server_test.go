@@ -0,0 +1,172 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftprometheus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testProcessor dispatches the requests to its processor map, like the
+// generated processors.
+type testProcessor struct {
+	processorMap map[string]thrift.TProcessorFunction
+}
+
+func (p *testProcessor) ProcessorMap() map[string]thrift.TProcessorFunction {
+	return p.processorMap
+}
+
+func (p *testProcessor) AddToProcessorMap(name string, f thrift.TProcessorFunction) {
+	p.processorMap[name] = f
+}
+
+func (p *testProcessor) Process(ctx context.Context, in, out thrift.TProtocol) (bool, thrift.TException) {
+	name, _, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, thrift.WrapTException(err)
+	}
+	return p.processorMap[name].Process(ctx, seqID, in, out)
+}
+
+// echoFunction replies with the string it reads, or fails with err if set.
+func echoFunction(name string, err error) thrift.TProcessorFunction {
+	return thrift.WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+			value, readErr := in.ReadString(ctx)
+			if readErr != nil {
+				return false, thrift.WrapTException(readErr)
+			}
+			in.ReadMessageEnd(ctx)
+			if err != nil {
+				return true, thrift.WrapTException(err)
+			}
+			out.WriteMessageBegin(ctx, name, thrift.REPLY, seqID)
+			out.WriteString(ctx, value)
+			out.WriteMessageEnd(ctx)
+			return true, thrift.WrapTException(out.Flush(ctx))
+		},
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	metrics := NewServerMetrics(ServerMetricsOptions{Service: "Echo"})
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(metrics)
+
+	processor := thrift.WrapProcessor(&testProcessor{
+		processorMap: map[string]thrift.TProcessorFunction{
+			"echo": echoFunction("echo", nil),
+			"fail": echoFunction("fail", errors.New("boom")),
+		},
+	}, metrics.Middleware())
+
+	ctx := context.Background()
+	conf := &thrift.TConfiguration{}
+	factory := TransportFactory(thrift.NewTTransportFactory())
+	buf := thrift.NewTMemoryBuffer()
+
+	// The requests are written to and the replies read from the same buffer,
+	// counted from a new transport for every request.
+	call := func(name, value string) {
+		t.Helper()
+		buf.Reset()
+		trans, err := factory.GetTransport(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proto := thrift.NewTBinaryProtocolConf(trans, conf)
+		client := thrift.NewTBinaryProtocolConf(buf, conf)
+		client.WriteMessageBegin(ctx, name, thrift.CALL, 1)
+		client.WriteString(ctx, value)
+		client.WriteMessageEnd(ctx)
+		processor.Process(ctx, proto, proto)
+	}
+	call("echo", "hello")
+	call("echo", "world!")
+	call("fail", "x")
+
+	expected := `
+# HELP thrift_server_errors_total Number of requests failed with an error.
+# TYPE thrift_server_errors_total counter
+thrift_server_errors_total{method="fail",service="Echo",type="unknown"} 1
+# HELP thrift_server_in_flight_requests Number of requests being handled by the server.
+# TYPE thrift_server_in_flight_requests gauge
+thrift_server_in_flight_requests{method="echo",service="Echo"} 0
+thrift_server_in_flight_requests{method="fail",service="Echo"} 0
+# HELP thrift_server_received_bytes_total Size of the requests received by the server.
+# TYPE thrift_server_received_bytes_total counter
+thrift_server_received_bytes_total{method="echo",service="Echo"} 51
+thrift_server_received_bytes_total{method="fail",service="Echo"} 21
+# HELP thrift_server_requests_total Number of requests handled by the server.
+# TYPE thrift_server_requests_total counter
+thrift_server_requests_total{method="echo",service="Echo"} 2
+thrift_server_requests_total{method="fail",service="Echo"} 1
+# HELP thrift_server_sent_bytes_total Size of the responses sent by the server.
+# TYPE thrift_server_sent_bytes_total counter
+thrift_server_sent_bytes_total{method="echo",service="Echo"} 51
+thrift_server_sent_bytes_total{method="fail",service="Echo"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"thrift_server_errors_total",
+		"thrift_server_in_flight_requests",
+		"thrift_server_received_bytes_total",
+		"thrift_server_requests_total",
+		"thrift_server_sent_bytes_total",
+	); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(metrics, "thrift_server_request_duration_seconds"); n != 2 {
+		t.Errorf("expected 2 latency histograms, got %d", n)
+	}
+}
+
+func TestServerMetricsMultiplexed(t *testing.T) {
+	metrics := NewServerMetrics(ServerMetricsOptions{Namespace: "test"})
+	service := &testProcessor{
+		processorMap: map[string]thrift.TProcessorFunction{
+			"echo": echoFunction("echo", nil),
+		},
+	}
+	multiplexed := thrift.NewTMultiplexedProcessor()
+	multiplexed.RegisterProcessor("Echo", service)
+	thrift.WrapProcessor(multiplexed, metrics.Middleware())
+
+	ctx := context.Background()
+	buf := thrift.NewTMemoryBuffer()
+	proto := thrift.NewTBinaryProtocolConf(buf, nil)
+	client := thrift.NewTMultiplexedProtocol(thrift.NewTBinaryProtocolConf(buf, nil), "Echo")
+	client.WriteMessageBegin(ctx, "echo", thrift.CALL, 1)
+	client.WriteString(ctx, "hello")
+	client.WriteMessageEnd(ctx)
+	if _, err := multiplexed.Process(ctx, proto, proto); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(metrics.requests.WithLabelValues("Echo", "echo")); got != 1 {
+		t.Errorf("expected 1 request for Echo.echo, got %v", got)
+	}
+}
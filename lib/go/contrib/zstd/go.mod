@@ -0,0 +1,10 @@
+module github.com/apache/thrift/lib/go/contrib/zstd
+
+go 1.20
+
+require (
+	github.com/apache/thrift v0.0.0-00010101000000-000000000000
+	github.com/klauspost/compress v1.17.9
+)
+
+replace github.com/apache/thrift => ../../../..
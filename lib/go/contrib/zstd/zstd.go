@@ -0,0 +1,111 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftzstd
+
+import (
+	"io"
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor is the thrift.Compressor of the zstd format. It pools its
+// encoders and decoders, and is safe for concurrent use.
+type Compressor struct {
+	level    zstd.EncoderLevel
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+var _ thrift.Compressor = (*Compressor)(nil)
+
+// NewCompressor returns a Compressor compressing at level, e.g.
+// zstd.SpeedDefault.
+func NewCompressor(level zstd.EncoderLevel) *Compressor {
+	return &Compressor{level: level}
+}
+
+// NewWriter implements thrift.Compressor.
+func (c *Compressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if enc, ok := c.encoders.Get().(*zstd.Encoder); ok {
+		enc.Reset(w)
+		return &pooledEncoder{Encoder: enc, c: c}, nil
+	}
+	enc, err := zstd.NewWriter(
+		w,
+		zstd.WithEncoderLevel(c.level),
+		zstd.WithEncoderConcurrency(1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledEncoder{Encoder: enc, c: c}, nil
+}
+
+// NewReader implements thrift.Compressor.
+func (c *Compressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	if dec, ok := c.decoders.Get().(*zstd.Decoder); ok {
+		if err := dec.Reset(r); err != nil {
+			dec.Reset(nil)
+			c.decoders.Put(dec)
+			return nil, err
+		}
+		return &pooledDecoder{Decoder: dec, c: c}, nil
+	}
+	// A single goroutine decodes synchronously, so that the decoders need
+	// not be closed.
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &pooledDecoder{Decoder: dec, c: c}, nil
+}
+
+type pooledEncoder struct {
+	*zstd.Encoder
+	c *Compressor
+}
+
+func (e *pooledEncoder) Close() error {
+	if e.Encoder == nil {
+		return nil
+	}
+	err := e.Encoder.Close()
+	e.Encoder.Reset(nil)
+	e.c.encoders.Put(e.Encoder)
+	e.Encoder = nil
+	return err
+}
+
+type pooledDecoder struct {
+	*zstd.Decoder
+	c *Compressor
+}
+
+func (d *pooledDecoder) Close() error {
+	if d.Decoder == nil {
+		return nil
+	}
+	d.Decoder.Reset(nil)
+	d.c.decoders.Put(d.Decoder)
+	d.Decoder = nil
+	return nil
+}
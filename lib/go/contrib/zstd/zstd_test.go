@@ -0,0 +1,98 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thriftzstd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/klauspost/compress/zstd"
+)
+
+type testEvent struct {
+	Name    string   `thrift:"name,1"`
+	Payload string   `thrift:"payload,2"`
+	Tags    []string `thrift:"tags,3"`
+}
+
+func TestCompressor(t *testing.T) {
+	ctx := context.Background()
+	msg := &testEvent{
+		Name:    "event",
+		Payload: strings.Repeat("compressible ", 1000),
+		Tags:    []string{"a", "b"},
+	}
+	pf := thrift.NewTCompactProtocolFactoryConf(nil)
+	c := NewCompressor(zstd.SpeedFastest)
+	serializers := thrift.NewTSerializerPoolSizeFactory(1024, pf)
+	deserializers := thrift.NewTDeserializerPoolSizeFactory(1024, pf)
+	plain, err := serializers.Write(ctx, thrift.ReflectStruct(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		b, err := serializers.WriteCompressed(ctx, thrift.ReflectStruct(msg), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) >= len(plain)/4 {
+			t.Errorf("expected fewer than %d compressed bytes, got %d", len(plain)/4, len(b))
+		}
+		// The output is a plain zstd frame.
+		dec, err := zstd.NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decompressed, err := io.ReadAll(dec)
+		dec.Close()
+		if err != nil || !bytes.Equal(decompressed, plain) {
+			t.Errorf("expected the bytes of Write once decompressed, got %d bytes, err=%v", len(decompressed), err)
+		}
+
+		var got testEvent
+		if err := deserializers.ReadCompressed(ctx, thrift.ReflectStruct(&got), b, c); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(&got, msg) {
+			t.Errorf("expected %+v, got %+v", msg, got)
+		}
+	}
+
+	d := thrift.NewTDeserializerPoolSizeFactory(1024, thrift.NewTCompactProtocolFactoryConf(&thrift.TConfiguration{
+		MaxMessageSize: 1000,
+	}))
+	b, err := serializers.WriteCompressed(ctx, thrift.ReflectStruct(msg), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var te thrift.TProtocolException
+	if err := d.ReadCompressed(ctx, thrift.ReflectStruct(&testEvent{}), b, c); !errors.As(err, &te) || te.TypeId() != thrift.SIZE_LIMIT {
+		t.Errorf("expected a SIZE_LIMIT TProtocolException, got %v", err)
+	}
+	if err := deserializers.ReadCompressed(ctx, thrift.ReflectStruct(&testEvent{}), []byte("not compressed"), c); err == nil {
+		t.Error("expected an error for a payload not compressed")
+	}
+}
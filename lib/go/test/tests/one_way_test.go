@@ -90,3 +90,42 @@ func TestCallOnewayServer(t *testing.T) {
 		t.Fatal("Unexpected returned value: ", i)
 	}
 }
+
+func TestOnewayMessageType(t *testing.T) {
+	oneway := make(map[string]bool)
+	processor := thrift.WrapProcessor(onewaytest.NewOneWayProcessor(&impl{}), func(name string, next thrift.TProcessorFunction) thrift.TProcessorFunction {
+		return thrift.WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+				var f thrift.OnewayFunc
+				oneway[name] = f.IsOneway(ctx, name)
+				return next.Process(ctx, seqID, in, out)
+			},
+		}
+	})
+
+	for _, call := range []struct {
+		method string
+		typeID thrift.TMessageType
+		args   thrift.TStruct
+	}{
+		{"hi", thrift.ONEWAY, &onewaytest.OneWayHiArgs{I: 1}},
+		{"echo_int", thrift.CALL, &onewaytest.OneWayEchoIntArgs{Param: 42}},
+	} {
+		proto := thrift.NewTBinaryProtocolConf(thrift.NewTMemoryBuffer(), nil)
+		if err := proto.WriteMessageBegin(defaultCtx, call.method, call.typeID, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := call.args.Write(defaultCtx, proto); err != nil {
+			t.Fatal(err)
+		}
+		if err := proto.WriteMessageEnd(defaultCtx); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := processor.Process(defaultCtx, proto, proto); !ok || err != nil {
+			t.Fatalf("%s: unexpected result %v %v", call.method, ok, err)
+		}
+	}
+	if !oneway["hi"] || oneway["echo_int"] {
+		t.Errorf("expected the message types to be set by the processor, got %v", oneway)
+	}
+}
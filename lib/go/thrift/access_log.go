@@ -0,0 +1,283 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Values of AccessLogEntry.Status.
+const (
+	AccessLogStatusOK               = "ok"
+	AccessLogStatusApplicationError = "application_error"
+	AccessLogStatusProtocolError    = "protocol_error"
+	AccessLogStatusTransportError   = "transport_error"
+	AccessLogStatusError            = "error"
+)
+
+// AccessLogEntry describes a request handled by a processor function.
+type AccessLogEntry struct {
+	// Method is the name of the function, as set in the processor map.
+	Method string
+	SeqID  int32
+
+	// RequestID is the id of the request, if any.
+	// See RequestIDFromContext.
+	RequestID string
+
+	// Peer is the remote address of the client, nil if unknown.
+	// See RemoteAddrFromContext.
+	Peer net.Addr
+
+	Start   time.Time
+	Latency time.Duration
+
+	// RequestSize and ResponseSize are the number of bytes read and written
+	// by the request, -1 if unknown. See AccessLogTransportFactory.
+	RequestSize  int64
+	ResponseSize int64
+
+	// Status is one of the AccessLogStatus* constants, derived from Err.
+	Status string
+	// Err is the error returned by the processor function, if any.
+	Err error
+
+	// Headers are the THeaders read with the request, after redaction.
+	Headers map[string]string
+}
+
+// String formats the entry as space separated key=value pairs.
+func (e AccessLogEntry) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "method=%q seqid=%d", e.Method, e.SeqID)
+	if e.RequestID != "" {
+		fmt.Fprintf(&sb, " request_id=%q", e.RequestID)
+	}
+	if e.Peer != nil {
+		fmt.Fprintf(&sb, " peer=%s", e.Peer)
+	}
+	fmt.Fprintf(&sb, " latency=%s", e.Latency)
+	if e.RequestSize >= 0 {
+		fmt.Fprintf(&sb, " request_size=%d", e.RequestSize)
+	}
+	if e.ResponseSize >= 0 {
+		fmt.Fprintf(&sb, " response_size=%d", e.ResponseSize)
+	}
+	fmt.Fprintf(&sb, " status=%s", e.Status)
+	if e.Err != nil {
+		fmt.Fprintf(&sb, " error=%q", e.Err.Error())
+	}
+	keys := make([]string, 0, len(e.Headers))
+	for key := range e.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&sb, " header.%s=%q", key, e.Headers[key])
+	}
+	return sb.String()
+}
+
+// AccessLogOptions configures AccessLogMiddleware.
+type AccessLogOptions struct {
+	// Handler is called with every sampled entry.
+	//
+	// If nil, the entries are formatted with AccessLogEntry.String and passed
+	// to Logger.
+	Handler func(ctx context.Context, entry AccessLogEntry)
+
+	// Logger is used when Handler is nil, StdLogger(nil) if nil as well.
+	Logger Logger
+
+	// Sampler decides whether an entry is logged, after the request is handled
+	// so that it can for example always log the errors.
+	//
+	// If nil, all the entries are logged.
+	Sampler func(ctx context.Context, entry *AccessLogEntry) bool
+
+	// Redact is called with every read THeader and returns the value to log,
+	// or false to omit the header.
+	//
+	// If nil, no header is logged, as they can contain credentials.
+	Redact func(key, value string) (string, bool)
+}
+
+// AccessLogMiddleware returns a ProcessorMiddleware producing an
+// AccessLogEntry for every request.
+func AccessLogMiddleware(opts AccessLogOptions) ProcessorMiddleware {
+	handler := opts.Handler
+	if handler == nil {
+		logger := fallbackLogger(opts.Logger)
+		handler = func(_ context.Context, entry AccessLogEntry) {
+			logger(entry.String())
+		}
+	}
+	return func(name string, next TProcessorFunction) TProcessorFunction {
+		return WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+				start := time.Now()
+				ok, err := next.Process(ctx, seqID, in, out)
+				entry := AccessLogEntry{
+					Method:       name,
+					SeqID:        seqID,
+					Start:        start,
+					Latency:      time.Since(start),
+					RequestSize:  -1,
+					ResponseSize: -1,
+					Status:       accessLogStatus(err),
+					Err:          err,
+				}
+				entry.RequestID, _ = RequestIDFromContext(ctx)
+				entry.Peer, _ = RemoteAddrFromContext(ctx)
+				entry.RequestSize, entry.ResponseSize = PayloadSizes(in, out)
+				if opts.Redact != nil {
+					for _, key := range GetReadHeaderList(ctx) {
+						value, _ := GetHeader(ctx, key)
+						if value, keep := opts.Redact(key, value); keep {
+							if entry.Headers == nil {
+								entry.Headers = make(map[string]string)
+							}
+							entry.Headers[key] = value
+						}
+					}
+				}
+				if opts.Sampler == nil || opts.Sampler(ctx, &entry) {
+					handler(ctx, entry)
+				}
+				return ok, err
+			},
+		}
+	}
+}
+
+func accessLogStatus(err TException) string {
+	if err == nil {
+		return AccessLogStatusOK
+	}
+	switch err.TExceptionType() {
+	case TExceptionTypeApplication:
+		return AccessLogStatusApplicationError
+	case TExceptionTypeProtocol:
+		return AccessLogStatusProtocolError
+	case TExceptionTypeTransport:
+		return AccessLogStatusTransportError
+	default:
+		return AccessLogStatusError
+	}
+}
+
+// AccessLogTransportFactory wraps factory to count the bytes read and written
+// by the server, so that AccessLogMiddleware and the other users of
+// PayloadSizes can report the request and response sizes.
+//
+// It must be used as both the input and output transport factories of the
+// server. Sizes are not reported with THeaderProtocol, as THeaderTransport
+// hides the transports created by factory from the middleware.
+func AccessLogTransportFactory(factory TTransportFactory) TTransportFactory {
+	return tByteCountingTransportFactory{factory}
+}
+
+type tByteCountingTransportFactory struct {
+	factory TTransportFactory
+}
+
+func (f tByteCountingTransportFactory) GetTransport(trans TTransport) (TTransport, error) {
+	t, err := f.factory.GetTransport(trans)
+	if err != nil {
+		return nil, err
+	}
+	return &tByteCountingTransport{TTransport: t}, nil
+}
+
+// NewTByteCountingTransport wraps trans to count the bytes read and written,
+// so that TStandardClient can report the payload sizes to its TStatsHandler.
+func NewTByteCountingTransport(trans TTransport) TTransport {
+	return &tByteCountingTransport{TTransport: trans}
+}
+
+// PayloadSizes returns the sizes of the request read from in and of the
+// response written to out by the current request of a TSimpleServer, -1 for
+// the ones whose transport is not wrapped by AccessLogTransportFactory.
+//
+// It doesn't reset the counts, so that several middlewares can report them.
+func PayloadSizes(in, out TProtocol) (request, response int64) {
+	return countedBytes(in.Transport(), true), countedBytes(out.Transport(), false)
+}
+
+// tByteCountingTransport counts the bytes read and written since the current
+// request began, see beginRequest.
+type tByteCountingTransport struct {
+	TTransport
+
+	read    int64
+	written int64
+	// The counts when the current request began.
+	readMark    int64
+	writtenMark int64
+}
+
+// beginCountedRequest marks the beginning of a request on the transports
+// counting their bytes, the others are ignored.
+func beginCountedRequest(transports ...TTransport) {
+	for _, trans := range transports {
+		if t, ok := trans.(*tByteCountingTransport); ok && t != nil {
+			t.beginRequest()
+		}
+	}
+}
+
+func (t *tByteCountingTransport) Read(p []byte) (int, error) {
+	n, err := t.TTransport.Read(p)
+	atomic.AddInt64(&t.read, int64(n))
+	return n, err
+}
+
+func (t *tByteCountingTransport) Write(p []byte) (int, error) {
+	n, err := t.TTransport.Write(p)
+	atomic.AddInt64(&t.written, int64(n))
+	return n, err
+}
+
+func (t *tByteCountingTransport) SetTConfiguration(conf *TConfiguration) {
+	PropagateTConfiguration(t.TTransport, conf)
+}
+
+func (t *tByteCountingTransport) beginRequest() {
+	atomic.StoreInt64(&t.readMark, atomic.LoadInt64(&t.read))
+	atomic.StoreInt64(&t.writtenMark, atomic.LoadInt64(&t.written))
+}
+
+// requestRead returns the bytes read since the current request began.
+func (t *tByteCountingTransport) requestRead() int64 {
+	return atomic.LoadInt64(&t.read) - atomic.LoadInt64(&t.readMark)
+}
+
+// requestWritten returns the bytes written since the current request began.
+func (t *tByteCountingTransport) requestWritten() int64 {
+	return atomic.LoadInt64(&t.written) - atomic.LoadInt64(&t.writtenMark)
+}
+
+var _ TConfigurationSetter = (*tByteCountingTransport)(nil)
@@ -0,0 +1,166 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	echo := WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			value, err := in.ReadString(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			out.WriteString(ctx, value)
+			return true, WrapTException(out.Flush(ctx))
+		},
+	}
+	fail := WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			return true, WrapTException(errors.New("boom"))
+		},
+	}
+
+	var entries []AccessLogEntry
+	processor := WrapProcessor(&mockWrappableProcessor{
+		ProcessorFuncs: map[string]TProcessorFunction{
+			"echo": echo,
+			"fail": fail,
+		},
+	}, AccessLogMiddleware(AccessLogOptions{
+		Handler: func(ctx context.Context, entry AccessLogEntry) {
+			entries = append(entries, entry)
+		},
+		Sampler: func(ctx context.Context, entry *AccessLogEntry) bool {
+			return entry.SeqID != 2 || entry.Err != nil
+		},
+		Redact: func(key, value string) (string, bool) {
+			switch key {
+			case "authorization":
+				return "REDACTED", true
+			case "secret":
+				return "", false
+			}
+			return value, true
+		},
+	}))
+
+	buf := NewTMemoryBuffer()
+	trans, err := AccessLogTransportFactory(NewTTransportFactory()).GetTransport(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto := NewTBinaryProtocolConf(trans, nil)
+
+	ctx := SetHeader(context.Background(), "authorization", "Bearer token")
+	ctx = SetHeader(ctx, "secret", "value")
+	ctx = SetHeader(ctx, "client", "test")
+	ctx = SetReadHeaderList(ctx, []string{"authorization", "secret", "client"})
+	call := func(name string, seqID int32) {
+		t.Helper()
+		buf.Reset()
+		// As done by TSimpleServer.
+		beginCountedRequest(trans)
+		NewTBinaryProtocolConf(buf, nil).WriteString(ctx, "hello")
+		if _, err := processor.ProcessorMap()[name].Process(ctx, seqID, proto, proto); err != nil && name != "fail" {
+			t.Fatal(err)
+		}
+	}
+	call("echo", 1)
+	// Not sampled.
+	call("echo", 2)
+	call("fail", 2)
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.Method != "echo" || entry.SeqID != 1 || entry.Status != AccessLogStatusOK || entry.Err != nil {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	// 4 bytes of string length followed by "hello".
+	if entry.RequestSize != 9 || entry.ResponseSize != 9 {
+		t.Errorf("expected sizes of 9 bytes, got %d and %d", entry.RequestSize, entry.ResponseSize)
+	}
+	expectedHeaders := map[string]string{
+		"authorization": "REDACTED",
+		"client":        "test",
+	}
+	if len(entry.Headers) != len(expectedHeaders) {
+		t.Errorf("expected headers %v, got %v", expectedHeaders, entry.Headers)
+	}
+	for key, value := range expectedHeaders {
+		if entry.Headers[key] != value {
+			t.Errorf("expected header %q to be %q, got %q", key, value, entry.Headers[key])
+		}
+	}
+
+	entry = entries[1]
+	if entry.Method != "fail" || entry.Status != AccessLogStatusError || entry.Err == nil {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if entry.RequestSize != 0 || entry.ResponseSize != 0 {
+		t.Errorf("expected sizes of 0 bytes, got %d and %d", entry.RequestSize, entry.ResponseSize)
+	}
+	s := entry.String()
+	for _, expected := range []string{`method="fail"`, "seqid=2", "status=error", `error="boom"`, `header.authorization="REDACTED"`} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("expected %q in %q", expected, s)
+		}
+	}
+}
+
+func TestAccessLogMiddlewareLogger(t *testing.T) {
+	var logged []string
+	processor := WrapProcessor(&mockWrappableProcessor{
+		ProcessorFuncs: map[string]TProcessorFunction{
+			"noop": WrappedTProcessorFunction{
+				Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+					return true, nil
+				},
+			},
+		},
+	}, AccessLogMiddleware(AccessLogOptions{
+		Logger: func(msg string) {
+			logged = append(logged, msg)
+		},
+	}))
+	proto := NewTBinaryProtocolConf(NewTMemoryBuffer(), nil)
+	ctx := SetHeader(context.Background(), "authorization", "Bearer token")
+	ctx = SetReadHeaderList(ctx, []string{"authorization"})
+	processor.ProcessorMap()["noop"].Process(ctx, 1, proto, proto)
+
+	if len(logged) != 1 {
+		t.Fatalf("expected 1 log, got %v", logged)
+	}
+	if !strings.HasPrefix(logged[0], `method="noop" seqid=1 latency=`) {
+		t.Errorf("unexpected log %q", logged[0])
+	}
+	for _, unexpected := range []string{"request_size", "header."} {
+		if strings.Contains(logged[0], unexpected) {
+			t.Errorf("unexpected %q in %q", unexpected, logged[0])
+		}
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrThrottled is returned by the calls rejected by an AdaptiveThrottle.
+var ErrThrottled = errors.New("call throttled by the client")
+
+// Default values of AdaptiveThrottleOptions.
+const (
+	DefaultThrottleWindow = 2 * time.Minute
+	DefaultThrottleK      = 2
+)
+
+// throttleBuckets is the number of buckets of the rolling window of an
+// AdaptiveThrottle.
+const throttleBuckets = 12
+
+// AdaptiveThrottleOptions configures NewAdaptiveThrottle.
+type AdaptiveThrottleOptions struct {
+	// Window is the duration of the rolling window of calls the accept
+	// rate is computed on, DefaultThrottleWindow if 0.
+	Window time.Duration
+
+	// K is how many calls the client sends per call accepted by the
+	// server before throttling, DefaultThrottleK if 0. The lower, the
+	// sooner the calls are throttled, 1 rejecting a call as soon as any
+	// call was rejected.
+	K float64
+
+	// IsRejection reports whether the error of a call is a rejection by
+	// the server, e.g. because it's overloaded.
+	//
+	// If nil, the TApplicationExceptions of reason
+	// ExceptionReasonRateLimited, the TTransportExceptions and the calls
+	// whose context deadline was exceeded are rejections, and the other
+	// errors (the exceptions of the handlers, etc.) are accepted calls.
+	IsRejection func(err error) bool
+
+	// OnThrottle is called for every call rejected by the client, for
+	// example to record metrics.
+	OnThrottle func(ctx context.Context, method string)
+}
+
+// AdaptiveThrottle rejects calls locally when the server has been rejecting
+// them, to avoid hammering an overloaded server with calls it would reject
+// anyway (adaptive throttling, from the Site Reliability Engineering book).
+//
+// Over a rolling window, it counts the calls made and the calls accepted by
+// the server, and rejects each new call with the probability
+//
+//	max(0, (requests - K * accepts) / (requests + 1))
+//
+// so that the calls sent exceed the calls the server accepts by K times at
+// most. The calls are sent again as the server accepts them again.
+type AdaptiveThrottle struct {
+	opts   AdaptiveThrottleOptions
+	now    func() time.Time
+	random func() float64
+
+	mu      sync.Mutex
+	buckets [throttleBuckets]tThrottleBucket
+}
+
+type tThrottleBucket struct {
+	// index is the index of the bucket in time, since the zero time.
+	index    int64
+	requests int
+	accepts  int
+}
+
+// NewAdaptiveThrottle returns an AdaptiveThrottle configured with opts.
+func NewAdaptiveThrottle(opts AdaptiveThrottleOptions) *AdaptiveThrottle {
+	if opts.Window <= 0 {
+		opts.Window = DefaultThrottleWindow
+	}
+	if opts.K <= 0 {
+		opts.K = DefaultThrottleK
+	}
+	if opts.IsRejection == nil {
+		opts.IsRejection = isServerRejection
+	}
+	return &AdaptiveThrottle{
+		opts:   opts,
+		now:    time.Now,
+		random: rand.Float64,
+	}
+}
+
+// isServerRejection is the default IsRejection of AdaptiveThrottleOptions.
+func isServerRejection(err error) bool {
+	var ae TApplicationException
+	if errors.As(err, &ae) {
+		return ExceptionReason(err) == ExceptionReasonRateLimited
+	}
+	return errors.As(err, new(TTransportException)) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Middleware returns a ClientMiddleware throttling the calls, which fail
+// with ErrThrottled when rejected. The throttle is shared by all the
+// TClients it wraps, so it's usually used for the calls to a single server
+// or service.
+func (t *AdaptiveThrottle) Middleware() ClientMiddleware {
+	return func(next TClient) TClient {
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				if !t.allow() {
+					if t.opts.OnThrottle != nil {
+						t.opts.OnThrottle(ctx, method)
+					}
+					return ResponseMeta{}, ErrThrottled
+				}
+				meta, err := next.Call(ctx, method, args, result)
+				if err == nil || !t.opts.IsRejection(err) {
+					t.accept()
+				}
+				return meta, err
+			},
+		}
+	}
+}
+
+// RejectProbability returns the current probability of the calls to be
+// rejected.
+func (t *AdaptiveThrottle) RejectProbability() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rejectProbability(t.bucketIndex())
+}
+
+// allow counts a call, and reports whether it can be sent.
+func (t *AdaptiveThrottle) allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	index := t.bucketIndex()
+	p := t.rejectProbability(index)
+	t.bucket(index).requests++
+	return p <= 0 || t.random() >= p
+}
+
+// accept counts a call accepted by the server.
+func (t *AdaptiveThrottle) accept() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bucket(t.bucketIndex()).accepts++
+}
+
+func (t *AdaptiveThrottle) bucketIndex() int64 {
+	return t.now().UnixNano() / int64(t.opts.Window/throttleBuckets)
+}
+
+// bucket returns the bucket of index, reset if it was of an older index.
+// t.mu must be held.
+func (t *AdaptiveThrottle) bucket(index int64) *tThrottleBucket {
+	bucket := &t.buckets[index%throttleBuckets]
+	if bucket.index != index {
+		*bucket = tThrottleBucket{index: index}
+	}
+	return bucket
+}
+
+// rejectProbability returns the probability of a call to be rejected. t.mu
+// must be held.
+func (t *AdaptiveThrottle) rejectProbability(index int64) float64 {
+	var requests, accepts int
+	for _, bucket := range t.buckets {
+		if index-bucket.index < throttleBuckets {
+			requests += bucket.requests
+			accepts += bucket.accepts
+		}
+	}
+	p := (float64(requests) - t.opts.K*float64(accepts)) / float64(requests+1)
+	if p < 0 {
+		return 0
+	}
+	return p
+}
@@ -0,0 +1,86 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveThrottle(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var throttled int
+	throttle := NewAdaptiveThrottle(AdaptiveThrottleOptions{
+		Window: time.Minute,
+		K:      2,
+		OnThrottle: func(ctx context.Context, method string) {
+			throttled++
+		},
+	})
+	throttle.now = func() time.Time {
+		return now
+	}
+	throttle.random = func() float64 {
+		return 0.5
+	}
+	var sent int
+	var overloaded bool
+	client := throttle.Middleware()(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			sent++
+			if overloaded {
+				return ResponseMeta{}, NewTApplicationExceptionWithReason(INTERNAL_ERROR, ExceptionReasonRateLimited, "overloaded")
+			}
+			return ResponseMeta{}, NewTApplicationException(INTERNAL_ERROR, "handler failure")
+		},
+	})
+	call := func() error {
+		_, err := client.Call(context.Background(), "m", nil, nil)
+		return err
+	}
+
+	// The other errors are accepted calls.
+	for i := 0; i < 10; i++ {
+		call()
+	}
+	if p := throttle.RejectProbability(); p != 0 {
+		t.Fatalf("expected no throttling, got %v", p)
+	}
+
+	// 10 accepts allow 2*10 requests before throttling, and the reject
+	// probability exceeds 0.5 after 42 requests.
+	overloaded = true
+	for i := 0; i < 50; i++ {
+		if err := call(); errors.Is(err, ErrThrottled) != (i >= 32) {
+			t.Fatalf("call %d: unexpected error %v", i, err)
+		}
+	}
+	if sent != 42 || throttled != 18 {
+		t.Errorf("expected 42 calls sent and 18 throttled, got %d and %d", sent, throttled)
+	}
+
+	// The calls out of the window are forgotten.
+	now = now.Add(time.Minute)
+	if p := throttle.RejectProbability(); p != 0 {
+		t.Errorf("expected the throttling to stop, got %v", p)
+	}
+}
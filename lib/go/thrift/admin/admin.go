@@ -0,0 +1,173 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Options configures a Handler, all its fields are optional.
+type Options struct {
+	// Server is the server whose connections and requests are reported.
+	//
+	// Its in-flight requests are only reported when it tracks them, see
+	// TSimpleServer.SetRequestTracking.
+	Server *thrift.TSimpleServer
+
+	// Counters are the per-method counters reported.
+	Counters *MethodCounters
+
+	// Config is the configuration reported.
+	Config *thrift.TConfiguration
+
+	// Toggles are the toggles that can be flipped, by name.
+	Toggles map[string]*Toggle
+}
+
+// Stats are the stats reported on /stats.
+type Stats struct {
+	// Connections is the number of open connections, of all the server
+	// transports.
+	Connections int64
+	Listeners   map[string]thrift.ListenerStats
+	Oneway      thrift.OnewayStats
+	InFlight    []thrift.InFlightRequest
+	Methods     map[string]MethodStats
+}
+
+// ConfigValues are the TConfiguration values reported on /config, with the
+// defaults applied.
+type ConfigValues struct {
+	MaxMessageSize     int32
+	MaxFrameSize       int32
+	ConnectTimeout     time.Duration
+	SocketTimeout      time.Duration
+	TLS                bool
+	TBinaryStrictRead  bool
+	TBinaryStrictWrite bool
+	THeaderProtocolID  thrift.THeaderProtocolID
+}
+
+type handler struct {
+	opts Options
+}
+
+// NewHandler returns the admin http.Handler, it serves the paths described in
+// the package documentation under any prefix.
+func NewHandler(opts Options) http.Handler {
+	return &handler{opts: opts}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	if name == "toggles" && r.Method == http.MethodPost {
+		h.setToggle(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch name {
+	case "stats":
+		writeJSON(w, h.stats())
+	case "config":
+		writeJSON(w, configValues(h.opts.Config))
+	case "buildinfo":
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			http.Error(w, "build info not available", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, info)
+	case "toggles":
+		writeJSON(w, h.toggles())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) stats() Stats {
+	var stats Stats
+	if s := h.opts.Server; s != nil {
+		stats.Listeners = s.ListenerStats()
+		for _, l := range stats.Listeners {
+			stats.Connections += l.Active
+		}
+		stats.Oneway = s.OnewayStats()
+		stats.InFlight = s.InFlightRequests()
+	}
+	if h.opts.Counters != nil {
+		stats.Methods = h.opts.Counters.Snapshot()
+	}
+	return stats
+}
+
+func configValues(conf *thrift.TConfiguration) ConfigValues {
+	return ConfigValues{
+		MaxMessageSize:     conf.GetMaxMessageSize(),
+		MaxFrameSize:       conf.GetMaxFrameSize(),
+		ConnectTimeout:     conf.GetConnectTimeout(),
+		SocketTimeout:      conf.GetSocketTimeout(),
+		TLS:                conf.GetTLSConfig() != nil,
+		TBinaryStrictRead:  conf.GetTBinaryStrictRead(),
+		TBinaryStrictWrite: conf.GetTBinaryStrictWrite(),
+		THeaderProtocolID:  conf.GetTHeaderProtocolID(),
+	}
+}
+
+func (h *handler) toggles() map[string]bool {
+	toggles := make(map[string]bool, len(h.opts.Toggles))
+	for name, t := range h.opts.Toggles {
+		toggles[name] = t.Enabled()
+	}
+	return toggles
+}
+
+func (h *handler) setToggle(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	t, ok := h.opts.Toggles[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown toggle %q", name), http.StatusNotFound)
+		return
+	}
+	enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+	if err != nil {
+		http.Error(w, "invalid enabled value: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	t.Set(enabled)
+	writeJSON(w, h.toggles())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,136 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	serverTrans, err := thrift.NewTServerSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters := NewMethodCounters()
+	ping := counters.Middleware()("ping", thrift.WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+			if seqID == 2 {
+				return true, thrift.WrapTException(errors.New("failed"))
+			}
+			return true, nil
+		},
+	})
+	for seqID := int32(1); seqID <= 2; seqID++ {
+		ping.Process(context.Background(), seqID, nil, nil)
+	}
+	debug := new(Toggle)
+	srv := httptest.NewServer(http.StripPrefix("/admin", NewHandler(Options{
+		Server:   thrift.NewTSimpleServer2(nil, serverTrans),
+		Counters: counters,
+		Config:   &thrift.TConfiguration{MaxMessageSize: 42},
+		Toggles:  map[string]*Toggle{"debug": debug},
+	})))
+	defer srv.Close()
+
+	var stats Stats
+	getJSON(t, srv.URL+"/admin/stats", &stats)
+	if _, ok := stats.Listeners[thrift.DefaultListenerName]; !ok || stats.Connections != 0 {
+		t.Errorf("unexpected server stats %+v", stats)
+	}
+	if stats.Methods["ping"] != (MethodStats{Calls: 2, Errors: 1}) {
+		t.Errorf("unexpected method stats %+v", stats.Methods)
+	}
+
+	var config ConfigValues
+	getJSON(t, srv.URL+"/admin/config", &config)
+	if config.MaxMessageSize != 42 || config.TBinaryStrictWrite != thrift.DEFAULT_TBINARY_STRICT_WRITE {
+		t.Errorf("unexpected config values %+v", config)
+	}
+
+	var info map[string]interface{}
+	getJSON(t, srv.URL+"/admin/buildinfo", &info)
+
+	var logged []string
+	logger := debug.Logger(func(msg string) {
+		logged = append(logged, msg)
+	})
+	logger("off")
+	resp, err := http.PostForm(srv.URL+"/admin/toggles", url.Values{"name": {"debug"}, "enabled": {"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !debug.Enabled() {
+		t.Errorf("expected the toggle to be enabled, got %s", resp.Status)
+	}
+	logger("on")
+	if len(logged) != 1 || logged[0] != "on" {
+		t.Errorf("expected only the message logged while enabled, got %q", logged)
+	}
+	var toggles map[string]bool
+	getJSON(t, srv.URL+"/admin/toggles", &toggles)
+	if !toggles["debug"] {
+		t.Errorf("unexpected toggles %v", toggles)
+	}
+
+	resp, err = http.PostForm(srv.URL+"/admin/toggles", url.Values{"name": {"unknown"}, "enabled": {"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected an unknown toggle to be not found, got %s", resp.Status)
+	}
+}
+
+func TestToggleProtocolFactory(t *testing.T) {
+	var dump Toggle
+	factory := dump.ProtocolFactory(thrift.NewTBinaryProtocolFactoryConf(nil), thrift.NopLogger)
+	if _, ok := factory.GetProtocol(thrift.NewTMemoryBuffer()).(*thrift.TDebugProtocol); ok {
+		t.Error("expected no wire dumping while disabled")
+	}
+	dump.Set(true)
+	if _, ok := factory.GetProtocol(thrift.NewTMemoryBuffer()).(*thrift.TDebugProtocol); !ok {
+		t.Error("expected wire dumping while enabled")
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package admin
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// MethodStats are the counters of a method, see MethodCounters.
+type MethodStats struct {
+	// Calls is the number of requests processed.
+	Calls int64
+	// Errors is the number of requests whose processing returned an
+	// error, including the exceptions declared in the IDL.
+	Errors int64
+}
+
+// MethodCounters counts the calls and errors of every method of a processor
+// it's the middleware of.
+type MethodCounters struct {
+	mu      sync.Mutex
+	methods map[string]*MethodStats
+}
+
+// NewMethodCounters creates a MethodCounters.
+func NewMethodCounters() *MethodCounters {
+	return &MethodCounters{
+		methods: make(map[string]*MethodStats),
+	}
+}
+
+// Middleware returns a ProcessorMiddleware counting the requests of the
+// methods it wraps.
+func (c *MethodCounters) Middleware() thrift.ProcessorMiddleware {
+	return func(name string, next thrift.TProcessorFunction) thrift.TProcessorFunction {
+		stats := c.method(name)
+		return thrift.WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+				ok, err := next.Process(ctx, seqID, in, out)
+				atomic.AddInt64(&stats.Calls, 1)
+				if err != nil {
+					atomic.AddInt64(&stats.Errors, 1)
+				}
+				return ok, err
+			},
+		}
+	}
+}
+
+func (c *MethodCounters) method(name string) *MethodStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats, ok := c.methods[name]
+	if !ok {
+		stats = new(MethodStats)
+		c.methods[name] = stats
+	}
+	return stats
+}
+
+// Snapshot returns the current counters by method name.
+func (c *MethodCounters) Snapshot() map[string]MethodStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := make(map[string]MethodStats, len(c.methods))
+	for name, stats := range c.methods {
+		snapshot[name] = MethodStats{
+			Calls:  atomic.LoadInt64(&stats.Calls),
+			Errors: atomic.LoadInt64(&stats.Errors),
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,47 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package admin implements an optional HTTP endpoint for operators to inspect
+// a running thrift server: its connections and in-flight requests, the number
+// of calls and errors per method, its configuration and build info, and
+// toggles to turn debug logging and wire dumping on and off.
+//
+// The Handler is usually served on a separate, non-public port:
+//
+//	counters := admin.NewMethodCounters()
+//	processor := thrift.WrapProcessor(NewMyServiceProcessor(handler), counters.Middleware())
+//	debug := new(admin.Toggle)
+//	server := thrift.NewTSimpleServer4(processor, serverTransport, transportFactory, protocolFactory)
+//	server.SetLogger(debug.Logger(thrift.StdLogger(nil)))
+//
+//	go http.ListenAndServe("localhost:9090", admin.NewHandler(admin.Options{
+//		Server:   server,
+//		Counters: counters,
+//		Toggles:  map[string]*admin.Toggle{"debug": debug},
+//	}))
+//
+// It serves the following paths, as JSON:
+//
+//	GET  /stats      server and per-method stats, see Stats
+//	GET  /config     the TConfiguration values, see ConfigValues
+//	GET  /buildinfo  the build info of the binary, see runtime/debug.BuildInfo
+//	GET  /toggles    the states of the toggles
+//	POST /toggles    sets the toggle of the name form value to the enabled
+//	                 form value, e.g. "name=debug&enabled=true"
+package admin
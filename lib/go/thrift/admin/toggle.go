@@ -0,0 +1,91 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package admin
+
+import (
+	"sync/atomic"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Toggle is a switch that can be flipped at runtime through the Handler.
+//
+// The zero value is a disabled Toggle.
+type Toggle struct {
+	enabled int32
+}
+
+// Enabled reports whether t is on.
+func (t *Toggle) Enabled() bool {
+	return atomic.LoadInt32(&t.enabled) != 0
+}
+
+// Set turns t on or off.
+func (t *Toggle) Set(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&t.enabled, v)
+}
+
+// Logger returns a Logger passing the messages to logger only while t is on,
+// for debug logging.
+func (t *Toggle) Logger(logger thrift.Logger) thrift.Logger {
+	return func(msg string) {
+		if t.Enabled() {
+			logger(msg)
+		}
+	}
+}
+
+// ProtocolFactory returns a TProtocolFactory logging everything read and
+// written to logger, like TDebugProtocolFactory, while t is on, for wire
+// dumping.
+//
+// Whether the protocol of a connection logs is decided when the connection is
+// opened, so turning t on only applies to new connections.
+//
+// As the protocols are wrapped in a TDebugProtocol while t is on, it should
+// not be used with THeaderProtocol on servers, which need to see it to reply
+// in the same format as the request.
+func (t *Toggle) ProtocolFactory(factory thrift.TProtocolFactory, logger thrift.Logger) thrift.TProtocolFactory {
+	return &toggleProtocolFactory{
+		toggle: t,
+		debug: &thrift.TDebugProtocolFactory{
+			Underlying: factory,
+			Logger:     logger,
+		},
+	}
+}
+
+type toggleProtocolFactory struct {
+	toggle *Toggle
+	debug  *thrift.TDebugProtocolFactory
+}
+
+func (f *toggleProtocolFactory) GetProtocol(trans thrift.TTransport) thrift.TProtocol {
+	if f.toggle.Enabled() {
+		return f.debug.GetProtocol(trans)
+	}
+	return f.debug.Underlying.GetProtocol(trans)
+}
+
+var _ thrift.TProtocolFactory = (*toggleProtocolFactory)(nil)
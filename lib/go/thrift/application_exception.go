@@ -21,6 +21,7 @@ package thrift
 
 import (
 	"context"
+	"errors"
 )
 
 const (
@@ -62,6 +63,7 @@ type TApplicationException interface {
 type tApplicationException struct {
 	message string
 	type_   int32
+	reason  string
 }
 
 var _ TApplicationException = (*tApplicationException)(nil)
@@ -78,7 +80,45 @@ func (e tApplicationException) Error() string {
 }
 
 func NewTApplicationException(type_ int32, message string) TApplicationException {
-	return &tApplicationException{message, type_}
+	return &tApplicationException{message: message, type_: type_}
+}
+
+// ExceptionReasonHeader is the THeader response header carrying the reason of
+// a TApplicationException, which its type, shared with the other languages,
+// doesn't tell: for example RateLimiter rejects the requests with
+// INTERNAL_ERROR exceptions of reason ExceptionReasonRateLimited.
+const ExceptionReasonHeader = "thrift-exception-reason"
+
+// The reasons of the TApplicationExceptions of this package.
+const (
+	ExceptionReasonRateLimited      = "rate-limited"
+	ExceptionReasonUnauthenticated  = "unauthenticated"
+	ExceptionReasonPermissionDenied = "permission-denied"
+	ExceptionReasonInvalidArgument  = "invalid-argument"
+)
+
+// NewTApplicationExceptionWithReason returns a TApplicationException with a
+// reason, sent to the clients in the ExceptionReasonHeader of the reply when
+// using THeaderProtocol.
+func NewTApplicationExceptionWithReason(type_ int32, reason, message string) TApplicationException {
+	return &tApplicationException{message: message, type_: type_, reason: reason}
+}
+
+// ExceptionReason returns the reason of the TApplicationException in err's
+// chain, "" if none: set by NewTApplicationExceptionWithReason on the server,
+// or read from the ExceptionReasonHeader of the reply on the client.
+func ExceptionReason(err error) string {
+	var e *tApplicationException
+	if errors.As(err, &e) {
+		return e.reason
+	}
+	return ""
+}
+
+// readExceptionReason sets the reason of e from the headers of the reply it
+// was read from.
+func (e *tApplicationException) readExceptionReason(headers THeaderMap) {
+	e.reason = headers[ExceptionReasonHeader]
 }
 
 func (p *tApplicationException) TypeId() int32 {
@@ -144,6 +184,11 @@ func (p *tApplicationException) Read(ctx context.Context, iprot TProtocol) error
 }
 
 func (p *tApplicationException) Write(ctx context.Context, oprot TProtocol) (err error) {
+	if p.reason != "" {
+		// Not in the struct, whose fields are shared with the other
+		// languages.
+		SetResponseHeader(ctx, ExceptionReasonHeader, p.reason)
+	}
 	err = oprot.WriteStructBegin(ctx, "TApplicationException")
 	if len(p.Error()) > 0 {
 		err = oprot.WriteFieldBegin(ctx, "message", STRING, 1)
@@ -178,3 +223,34 @@ func (p *tApplicationException) Write(ctx context.Context, oprot TProtocol) (err
 	err = oprot.WriteStructEnd(ctx)
 	return
 }
+
+// skipRequestWithException skips the remaining of a request message, whose
+// message begin was already read from in, and replies to it with exc.
+//
+// No reply is written for oneway requests.
+func skipRequestWithException(ctx context.Context, in, out TProtocol, name string, typeID TMessageType, seqID int32, exc TApplicationException) error {
+	if err := in.Skip(ctx, STRUCT); err != nil {
+		return err
+	}
+	if err := in.ReadMessageEnd(ctx); err != nil {
+		return err
+	}
+	if typeID == ONEWAY {
+		return nil
+	}
+	return writeApplicationException(ctx, out, name, seqID, exc)
+}
+
+// writeApplicationException writes exc as the EXCEPTION reply of a request.
+func writeApplicationException(ctx context.Context, out TProtocol, name string, seqID int32, exc TApplicationException) error {
+	if err := out.WriteMessageBegin(ctx, name, EXCEPTION, seqID); err != nil {
+		return err
+	}
+	if err := exc.Write(ctx, out); err != nil {
+		return err
+	}
+	if err := out.WriteMessageEnd(ctx); err != nil {
+		return err
+	}
+	return out.Flush(ctx)
+}
@@ -0,0 +1,218 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// Default values of BatchedOnewayOptions.
+const (
+	DefaultOnewayBatchBytes = 64 * 1024
+	DefaultOnewayBatchDelay = 5 * time.Millisecond
+)
+
+// BatchedOnewayOptions configures NewTBatchedOnewayClient.
+type BatchedOnewayOptions struct {
+	// MaxBytes is the size of the batched messages over which they're
+	// written at once, DefaultOnewayBatchBytes if 0.
+	MaxBytes int
+
+	// MaxDelay is how long the message of a oneway call can wait for the
+	// next ones before being written, DefaultOnewayBatchDelay if 0.
+	MaxDelay time.Duration
+}
+
+// TBatchedOnewayClient is a TClient writing the messages of the oneway calls
+// in batches, with a single write to the connection per batch, to save the
+// system calls of the clients emitting many events.
+//
+// A batch is written once its messages reach MaxBytes, or MaxDelay after its
+// first message, whichever comes first, and before the message of every call
+// with a result, which is sent at once. The messages are framed, with
+// TFramedTransport or THeaderTransport, like with TPipelinedClient.
+//
+// The oneway calls succeed once their message is batched. When writing a
+// batch fails, its calls are lost, and the client fails all the later calls
+// with that error, as the connection can't be used after a partial write.
+//
+// It is safe for concurrent use.
+type TBatchedOnewayClient struct {
+	opts   BatchedOnewayOptions
+	batch  *tBatchingTransport
+	client *TStandardClient
+
+	mu    sync.Mutex
+	timer *time.Timer
+	// err is the error of the last write of a batch.
+	err error
+}
+
+// NewTBatchedOnewayClient returns a TBatchedOnewayClient over conn, an open
+// connection such as a TSocket or a TSSLSocket.
+//
+// protocolFactory is used over conn if it was returned by
+// NewTHeaderProtocolFactoryConf, and over a TFramedTransport configured with
+// conf otherwise.
+//
+// Close must be called to write the last batch and close conn.
+func NewTBatchedOnewayClient(conn TTransport, protocolFactory TProtocolFactory, conf *TConfiguration, opts BatchedOnewayOptions) *TBatchedOnewayClient {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultOnewayBatchBytes
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = DefaultOnewayBatchDelay
+	}
+	batch := &tBatchingTransport{TTransport: conn}
+	var proto TProtocol
+	if _, ok := protocolFactory.(tHeaderProtocolFactory); ok {
+		proto = protocolFactory.GetProtocol(batch)
+	} else {
+		proto = protocolFactory.GetProtocol(NewTFramedTransportConf(batch, conf))
+	}
+	return &TBatchedOnewayClient{
+		opts:   opts,
+		batch:  batch,
+		client: NewTStandardClient(proto, proto),
+	}
+}
+
+// Call implements TClient.
+func (c *TBatchedOnewayClient) Call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return ResponseMeta{}, c.err
+	}
+
+	// method is oneway when result is nil
+	if result != nil {
+		if err := c.flush(ctx); err != nil {
+			return ResponseMeta{}, err
+		}
+		meta, err := c.client.Call(ctx, method, args, result)
+		if isConnectionError(err) {
+			c.err = err
+		}
+		return meta, err
+	}
+
+	c.batch.hold = true
+	_, err := c.client.Call(ctx, method, args, nil)
+	c.batch.hold = false
+	if err != nil {
+		// The partial message can't be sent.
+		c.err = err
+		return ResponseMeta{}, err
+	}
+	if c.batch.buf.Len() >= c.opts.MaxBytes {
+		return ResponseMeta{}, c.flush(ctx)
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.opts.MaxDelay, c.flushBatch)
+	}
+	return ResponseMeta{}, nil
+}
+
+// flushBatch writes the batch once MaxDelay is over.
+func (c *TBatchedOnewayClient) flushBatch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timer = nil
+	if c.err == nil {
+		c.flush(context.Background())
+	}
+}
+
+// Flush writes the messages batched so far.
+func (c *TBatchedOnewayClient) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.flush(ctx)
+}
+
+// flush writes the batch, c.mu must be held.
+func (c *TBatchedOnewayClient) flush(ctx context.Context) error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if err := c.batch.Flush(ctx); err != nil {
+		c.err = err
+		return err
+	}
+	return nil
+}
+
+// Close writes the messages batched so far and closes the connection. The
+// later calls fail.
+func (c *TBatchedOnewayClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	if c.err == nil {
+		err = c.flush(context.Background())
+	}
+	c.err = errBatchedClientClosed
+	if closeErr := c.batch.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// errBatchedClientClosed is returned by the calls of a closed
+// TBatchedOnewayClient.
+var errBatchedClientClosed = NewTTransportException(NOT_OPEN, "batched client closed")
+
+// tBatchingTransport buffers the writes to the wrapped transport until it's
+// flushed, keeping them buffered while hold is set.
+type tBatchingTransport struct {
+	TTransport
+
+	buf  bytes.Buffer
+	hold bool
+}
+
+func (t *tBatchingTransport) Write(p []byte) (int, error) {
+	return t.buf.Write(p)
+}
+
+func (t *tBatchingTransport) Flush(ctx context.Context) error {
+	if t.hold || t.buf.Len() == 0 {
+		return nil
+	}
+	_, err := t.TTransport.Write(t.buf.Bytes())
+	t.buf.Reset()
+	if err != nil {
+		return NewTTransportExceptionFromError(err)
+	}
+	return NewTTransportExceptionFromError(t.TTransport.Flush(ctx))
+}
+
+var (
+	_ TClient    = (*TBatchedOnewayClient)(nil)
+	_ TTransport = (*tBatchingTransport)(nil)
+)
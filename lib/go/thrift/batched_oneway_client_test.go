@@ -0,0 +1,173 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeCountingTransport counts the writes to a TMemoryBuffer.
+type writeCountingTransport struct {
+	*TMemoryBuffer
+	writes int32
+}
+
+func (t *writeCountingTransport) Write(p []byte) (int, error) {
+	atomic.AddInt32(&t.writes, 1)
+	return t.TMemoryBuffer.Write(p)
+}
+
+func TestTBatchedOnewayClient(t *testing.T) {
+	ctx := context.Background()
+	emit := func(t *testing.T, c TClient, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if _, err := c.Call(ctx, "emit", &pipelinedString{Value: fmt.Sprint("event-", i)}, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	newClient := func(opts BatchedOnewayOptions) (*TBatchedOnewayClient, *writeCountingTransport) {
+		conn := &writeCountingTransport{TMemoryBuffer: NewTMemoryBuffer()}
+		return NewTBatchedOnewayClient(conn, NewTBinaryProtocolFactoryConf(nil), nil, opts), conn
+	}
+
+	t.Run("flush", func(t *testing.T) {
+		c, conn := newClient(BatchedOnewayOptions{MaxDelay: time.Hour})
+		emit(t, c, 10)
+		if conn.writes != 0 {
+			t.Fatalf("expected the calls to be batched, got %d writes", conn.writes)
+		}
+		if err := c.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if conn.writes != 1 {
+			t.Errorf("expected a single write, got %d", conn.writes)
+		}
+		in := NewTBinaryProtocolConf(NewTFramedTransportConf(conn.TMemoryBuffer, nil), nil)
+		for i := 0; i < 10; i++ {
+			method, typeID, seqID, err := in.ReadMessageBegin(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := readStringArgs(ctx, in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if method != "emit" || typeID != CALL || seqID != int32(i+1) || value != fmt.Sprint("event-", i) {
+				t.Errorf("unexpected message %d: %s %d %d %q", i, method, typeID, seqID, value)
+			}
+		}
+		c.Close()
+		if _, err := c.Call(ctx, "emit", &pipelinedString{}, nil); err == nil {
+			t.Error("expected the calls to fail once closed")
+		}
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		// Every message is 35 bytes.
+		c, conn := newClient(BatchedOnewayOptions{MaxBytes: 100, MaxDelay: time.Hour})
+		defer c.Close()
+		emit(t, c, 2)
+		if conn.writes != 0 {
+			t.Fatalf("expected the calls to be batched, got %d writes", conn.writes)
+		}
+		emit(t, c, 1)
+		if conn.writes != 1 {
+			t.Errorf("expected the batch to be written once full, got %d writes", conn.writes)
+		}
+	})
+
+	t.Run("max delay", func(t *testing.T) {
+		c, conn := newClient(BatchedOnewayOptions{MaxDelay: 10 * time.Millisecond})
+		defer c.Close()
+		emit(t, c, 3)
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt32(&conn.writes) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("the batch was not written after MaxDelay")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if writes := atomic.LoadInt32(&conn.writes); writes != 1 {
+			t.Errorf("expected a single write, got %d", writes)
+		}
+	})
+}
+
+func TestTBatchedOnewayClientTwoWay(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	c := NewTBatchedOnewayClient(NewTSocketFromConnConf(clientConn, nil), NewTBinaryProtocolFactoryConf(nil), nil, BatchedOnewayOptions{
+		MaxDelay: time.Hour,
+	})
+	t.Cleanup(func() {
+		c.Close()
+		serverConn.Close()
+	})
+	server := NewTBinaryProtocolConf(NewTFramedTransportConf(NewTSocketFromConnConf(serverConn, nil), nil), nil)
+	requests := make(chan pipelinedRequest, 3)
+	go func() {
+		ctx := context.Background()
+		for i := 0; i < 3; i++ {
+			method, _, seqID, err := server.ReadMessageBegin(ctx)
+			if err != nil {
+				return
+			}
+			value, err := readStringArgs(ctx, server)
+			if err != nil {
+				return
+			}
+			req := pipelinedRequest{method, seqID, value}
+			requests <- req
+			if method == "get" {
+				writePipelinedReply(server, req)
+			}
+		}
+	}()
+
+	ctx := context.Background()
+	for _, value := range []string{"a", "b"} {
+		if _, err := c.Call(ctx, "emit", &pipelinedString{Value: value}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The batched calls are written before the calls with a result.
+	var result pipelinedString
+	if _, err := c.Call(ctx, "get", &pipelinedString{Value: "c"}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != "c" {
+		t.Errorf("expected the reply of the call, got %q", result.Value)
+	}
+	var received []pipelinedRequest
+	for i := 0; i < 3; i++ {
+		received = append(received, <-requests)
+	}
+	expected := []pipelinedRequest{{"emit", 1, "a"}, {"emit", 2, "b"}, {"get", 3, "c"}}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected requests %v, got %v", expected, received)
+	}
+}
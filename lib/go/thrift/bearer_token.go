@@ -0,0 +1,157 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AuthorizationHeader is the header the bearer tokens are sent in, both as a
+// THeader header and as an HTTP header.
+const AuthorizationHeader = "authorization"
+
+// DefaultTokenRefreshBefore is the default RefreshBefore of
+// RefreshingTokenOptions.
+const DefaultTokenRefreshBefore = time.Minute
+
+// TokenSource returns the bearer tokens sent by BearerTokenMiddleware.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc is a function implementing TokenSource.
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+// Token calls f.
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticTokenSource returns a TokenSource always returning token.
+func StaticTokenSource(token string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// RefreshingTokenOptions configures NewRefreshingTokenSource.
+type RefreshingTokenOptions struct {
+	// Fetch returns a new token and its expiry, for example from an OAuth2
+	// token endpoint. A zero expiry means the token doesn't expire. It must
+	// not be nil.
+	Fetch func(ctx context.Context) (token string, expiry time.Time, err error)
+
+	// RefreshBefore is how long before its expiry the token is refreshed.
+	// The token is still used if the refresh fails before it expires.
+	//
+	// If <= 0, DefaultTokenRefreshBefore is used.
+	RefreshBefore time.Duration
+}
+
+// RefreshingTokenSource is a TokenSource caching the tokens of a fetch
+// function until they're about to expire.
+//
+// It's safe for concurrent use: the concurrent calls needing a new token
+// wait for a single fetch.
+type RefreshingTokenSource struct {
+	opts RefreshingTokenOptions
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+	valid  bool
+
+	// Mocked in tests.
+	now func() time.Time
+}
+
+// NewRefreshingTokenSource returns a RefreshingTokenSource fetching its tokens
+// with opts.Fetch.
+func NewRefreshingTokenSource(opts RefreshingTokenOptions) *RefreshingTokenSource {
+	if opts.RefreshBefore <= 0 {
+		opts.RefreshBefore = DefaultTokenRefreshBefore
+	}
+	return &RefreshingTokenSource{
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// Token implements TokenSource, returning the cached token, or fetching a new
+// one if it's missing or about to expire.
+func (s *RefreshingTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.valid && (s.expiry.IsZero() || now.Before(s.expiry.Add(-s.opts.RefreshBefore))) {
+		return s.token, nil
+	}
+	token, expiry, err := s.opts.Fetch(ctx)
+	if err != nil {
+		if s.valid && (s.expiry.IsZero() || now.Before(s.expiry)) {
+			return s.token, nil
+		}
+		return "", err
+	}
+	s.token, s.expiry, s.valid = token, expiry, true
+	return token, nil
+}
+
+// Invalidate drops the cached token, so that the next call to Token fetches a
+// new one, for example when the server rejected it.
+func (s *RefreshingTokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token, s.expiry, s.valid = "", time.Time{}, false
+}
+
+// BearerTokenMiddleware returns a ClientMiddleware sending a bearer token of
+// source with every call, as a "Bearer <token>" AuthorizationHeader, both as a
+// THeader header for THeaderProtocol and as an HTTP header for THttpClient,
+// as validated by the JWT validator of lib/go/contrib/auth.
+//
+// The calls fail with the error of source, without being sent, if it fails to
+// return a token. If source has an Invalidate method, like
+// RefreshingTokenSource, it's called when a call is rejected with a
+// TApplicationException of reason ExceptionReasonUnauthenticated, so that the
+// next calls get a new token.
+func BearerTokenMiddleware(source TokenSource) ClientMiddleware {
+	invalidator, _ := source.(interface{ Invalidate() })
+	return func(next TClient) TClient {
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				token, err := source.Token(ctx)
+				if err != nil {
+					return ResponseMeta{}, err
+				}
+				value := "Bearer " + token
+				ctx = addWriteHeader(ctx, AuthorizationHeader, value)
+				ctx = AppendOutgoingHTTPHeader(ctx, AuthorizationHeader, value)
+				meta, err := next.Call(ctx, method, args, result)
+				if invalidator != nil && ExceptionReason(err) == ExceptionReasonUnauthenticated {
+					invalidator.Invalidate()
+				}
+				return meta, err
+			},
+		}
+	}
+}
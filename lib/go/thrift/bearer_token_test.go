@@ -0,0 +1,190 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefreshingTokenSource(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var (
+		fetches  int
+		fetchErr error
+	)
+	source := NewRefreshingTokenSource(RefreshingTokenOptions{
+		Fetch: func(ctx context.Context) (string, time.Time, error) {
+			fetches++
+			if fetchErr != nil {
+				return "", time.Time{}, fetchErr
+			}
+			return string(rune('a' + fetches - 1)), now.Add(10 * time.Minute), nil
+		},
+	})
+	source.now = func() time.Time { return now }
+	token := func(expected string) {
+		t.Helper()
+		tok, err := source.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok != expected {
+			t.Errorf("expected token %q, got %q", expected, tok)
+		}
+	}
+
+	token("a")
+	now = now.Add(8 * time.Minute)
+	token("a")
+	if fetches != 1 {
+		t.Errorf("expected the token to be cached, got %d fetches", fetches)
+	}
+
+	// Refreshed within DefaultTokenRefreshBefore of its expiry.
+	now = now.Add(90 * time.Second)
+	token("b")
+
+	// Still used while the refresh fails, until it expires.
+	fetchErr = errors.New("token endpoint down")
+	now = now.Add(9*time.Minute + 30*time.Second)
+	token("b")
+	now = now.Add(time.Minute)
+	if _, err := source.Token(context.Background()); err != fetchErr {
+		t.Errorf("expected the fetch error once expired, got %v", err)
+	}
+
+	fetchErr = nil
+	token("e")
+	source.Invalidate()
+	token("f")
+}
+
+func TestBearerTokenMiddleware(t *testing.T) {
+	processor := &contextCapturingProcessor{
+		mockProcessor: echoProcessor(),
+		ctxs:          make(chan context.Context, 10),
+	}
+	serv, addr := startTestSocketServer(t, processor, func(s *TSimpleServer) {
+		s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+		s.outputProtocolFactory = s.inputProtocolFactory
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	proto := NewTHeaderProtocolConf(dialTestSocketServer(t, addr), nil)
+	client := WrapClient(NewTStandardClient(proto, proto), BearerTokenMiddleware(StaticTokenSource("secret")))
+	var result echoValue
+	if _, err := client.Call(context.Background(), "echo", &echoValue{value: "hello"}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := GetHeader(<-processor.ctxs, AuthorizationHeader); value != "Bearer secret" {
+		t.Errorf("expected the bearer token header, got %q", value)
+	}
+
+	// The calls are not sent without a token.
+	tokenErr := errors.New("no token")
+	var called bool
+	client = WrapClient(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			called = true
+			return ResponseMeta{}, nil
+		},
+	}, BearerTokenMiddleware(TokenSourceFunc(func(context.Context) (string, error) {
+		return "", tokenErr
+	})))
+	if _, err := client.Call(context.Background(), "echo", &echoValue{}, &echoValue{}); err != tokenErr || called {
+		t.Errorf("expected the token error without a call, got %v (called: %v)", err, called)
+	}
+}
+
+func TestBearerTokenMiddlewareInvalidation(t *testing.T) {
+	var fetches int
+	source := NewRefreshingTokenSource(RefreshingTokenOptions{
+		Fetch: func(ctx context.Context) (string, time.Time, error) {
+			fetches++
+			return "token", time.Time{}, nil
+		},
+	})
+	var exc error
+	client := WrapClient(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			return ResponseMeta{}, exc
+		},
+	}, BearerTokenMiddleware(source))
+	call := func() {
+		t.Helper()
+		client.Call(context.Background(), "echo", &echoValue{}, &echoValue{})
+	}
+
+	call()
+	exc = NewTApplicationExceptionWithReason(INTERNAL_ERROR, ExceptionReasonPermissionDenied, "denied")
+	call()
+	if fetches != 1 {
+		t.Errorf("expected the token to be kept, got %d fetches", fetches)
+	}
+	exc = NewTApplicationExceptionWithReason(INTERNAL_ERROR, ExceptionReasonUnauthenticated, "expired token")
+	call()
+	call()
+	if fetches != 2 {
+		t.Errorf("expected the rejected token to be refetched, got %d fetches", fetches)
+	}
+}
+
+func TestBearerTokenMiddlewareHTTP(t *testing.T) {
+	authorizations := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations <- r.Header.Get("Authorization")
+	}))
+	t.Cleanup(server.Close)
+
+	trans, err := NewTHttpPostClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans.(*THttpClient).SetHeader("X-Client", "test")
+	client := WrapClient(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			return ResponseMeta{}, trans.Flush(ctx)
+		},
+	}, BearerTokenMiddleware(StaticTokenSource("secret")))
+	if _, err := client.Call(context.Background(), "echo", &echoValue{}, &echoValue{}); err != nil {
+		t.Fatal(err)
+	}
+	if authorization := <-authorizations; authorization != "Bearer secret" {
+		t.Errorf("expected the bearer token header, got %q", authorization)
+	}
+
+	// The headers of the calls are not kept by the transport.
+	if err := trans.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if authorization := <-authorizations; authorization != "" {
+		t.Errorf("expected no authorization header, got %q", authorization)
+	}
+	if header := trans.(*THttpClient).GetHeader(AuthorizationHeader); header != "" {
+		t.Errorf("expected the transport headers to be unchanged, got %q", header)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package benchmarks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// newProtocol returns the protocol of codec over mem.
+func newProtocol(tb testing.TB, codec Codec, mem *thrift.TMemoryBuffer) thrift.TProtocol {
+	var trans thrift.TTransport = mem
+	if codec.Transport != nil {
+		var err error
+		if trans, err = codec.Transport.GetTransport(mem); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return codec.Protocol.GetProtocol(trans)
+}
+
+func writeMessage(ctx context.Context, proto thrift.TProtocol, msg thrift.TStruct) error {
+	if err := proto.WriteMessageBegin(ctx, "benchmark", thrift.CALL, 1); err != nil {
+		return err
+	}
+	if err := msg.Write(ctx, proto); err != nil {
+		return err
+	}
+	if err := proto.WriteMessageEnd(ctx); err != nil {
+		return err
+	}
+	return proto.Flush(ctx)
+}
+
+func readMessage(ctx context.Context, proto thrift.TProtocol, msg thrift.TStruct) error {
+	if _, _, _, err := proto.ReadMessageBegin(ctx); err != nil {
+		return err
+	}
+	if err := msg.Read(ctx, proto); err != nil {
+		return err
+	}
+	return proto.ReadMessageEnd(ctx)
+}
+
+// encode returns the message of payload written with codec.
+func encode(tb testing.TB, codec Codec, payload Payload) []byte {
+	mem := thrift.NewTMemoryBuffer()
+	if err := writeMessage(context.Background(), newProtocol(tb, codec, mem), payload.Value); err != nil {
+		tb.Fatal(err)
+	}
+	return mem.Bytes()
+}
+
+func TestPayloads(t *testing.T) {
+	for _, payload := range Payloads() {
+		for _, codec := range Codecs() {
+			mem := thrift.NewTMemoryBuffer()
+			mem.Write(encode(t, codec, payload))
+			msg := payload.New()
+			if err := readMessage(context.Background(), newProtocol(t, codec, mem), msg); err != nil {
+				t.Fatalf("%s/%s: %v", payload.Name, codec.Name, err)
+			}
+			if !thrift.Equal(msg, payload.Value) {
+				t.Errorf("%s/%s: expected %+v, got %+v", payload.Name, codec.Name, payload.Value, msg)
+			}
+		}
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	ctx := context.Background()
+	for _, payload := range Payloads() {
+		for _, codec := range Codecs() {
+			b.Run(payload.Name+"/"+codec.Name, func(b *testing.B) {
+				b.SetBytes(int64(len(encode(b, codec, payload))))
+				b.ReportAllocs()
+				mem := thrift.NewTMemoryBuffer()
+				proto := newProtocol(b, codec, mem)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					mem.Reset()
+					if err := writeMessage(ctx, proto, payload.Value); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	ctx := context.Background()
+	for _, payload := range Payloads() {
+		for _, codec := range Codecs() {
+			b.Run(payload.Name+"/"+codec.Name, func(b *testing.B) {
+				encoded := encode(b, codec, payload)
+				b.SetBytes(int64(len(encoded)))
+				b.ReportAllocs()
+				mem := thrift.NewTMemoryBuffer()
+				proto := newProtocol(b, codec, mem)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					mem.Reset()
+					mem.Write(encoded)
+					if err := readMessage(ctx, proto, payload.New()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,33 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package benchmarks is the standard benchmark suite of the thrift library,
+// writing and reading representative payloads with every protocol and
+// transport combination:
+//
+//	go test -run NONE -bench . -count 10 ./benchmarks > new.txt
+//	benchstat old.txt new.txt
+//
+// The payloads are Go structs implementing TStruct the way the generated
+// code does, in the shapes the performance of the protocols depends on: deep
+// nesting, large strings and binaries, wide maps and many small fields.
+// Payloads and Codecs are exported for the benchmarks of other packages, for
+// example of alternative transports, to be measured against the same
+// baseline.
+package benchmarks
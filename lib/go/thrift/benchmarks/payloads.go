@@ -0,0 +1,446 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package benchmarks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Payload is a representative payload of the benchmarks.
+type Payload struct {
+	Name string
+	// Value is the payload, and New returns an empty struct of the same
+	// type to read it into.
+	Value thrift.TStruct
+	New   func() thrift.TStruct
+}
+
+// Payloads returns the payloads of the benchmarks:
+//
+//   - deep: a Nested struct nested 32 times,
+//   - large: a LargeStrings struct with a 64KiB string and binary,
+//   - wide: a WideMap struct with 1000 map entries,
+//   - small: a SmallFields struct with 16 scalar fields.
+func Payloads() []Payload {
+	return []Payload{
+		{
+			Name:  "deep",
+			Value: newNested(32),
+			New:   func() thrift.TStruct { return &Nested{} },
+		},
+		{
+			Name: "large",
+			Value: &LargeStrings{
+				Text: strings.Repeat("thrift ", 64<<10/7),
+				Blob: make([]byte, 64<<10),
+			},
+			New: func() thrift.TStruct { return &LargeStrings{} },
+		},
+		{
+			Name:  "wide",
+			Value: newWideMap(1000),
+			New:   func() thrift.TStruct { return &WideMap{} },
+		},
+		{
+			Name:  "small",
+			Value: newSmallFields(),
+			New:   func() thrift.TStruct { return &SmallFields{} },
+		},
+	}
+}
+
+// Codec is a protocol and transport combination of the benchmarks.
+type Codec struct {
+	Name string
+	// Transport wraps the in-memory transport the payloads are written to
+	// and read from, nil to use it as is.
+	Transport thrift.TTransportFactory
+	Protocol  thrift.TProtocolFactory
+}
+
+// Codecs returns the protocol and transport combinations of the benchmarks.
+// THeaderProtocol frames the messages itself, so it's only combined with the
+// protocols it wraps.
+func Codecs() []Codec {
+	conf := &thrift.TConfiguration{}
+	protocols := []struct {
+		name    string
+		factory thrift.TProtocolFactory
+	}{
+		{"binary", thrift.NewTBinaryProtocolFactoryConf(conf)},
+		{"compact", thrift.NewTCompactProtocolFactoryConf(conf)},
+		{"json", thrift.NewTJSONProtocolFactory()},
+	}
+	var codecs []Codec
+	for _, p := range protocols {
+		codecs = append(codecs,
+			Codec{Name: p.name, Protocol: p.factory},
+			Codec{
+				Name:      p.name + "/framed",
+				Transport: thrift.NewTFramedTransportFactoryConf(thrift.NewTTransportFactory(), conf),
+				Protocol:  p.factory,
+			},
+			Codec{
+				Name:      p.name + "/buffered",
+				Transport: thrift.NewTBufferedTransportFactory(4096),
+				Protocol:  p.factory,
+			},
+		)
+	}
+	for _, h := range []struct {
+		name string
+		id   thrift.THeaderProtocolID
+	}{
+		{"header/binary", thrift.THeaderProtocolBinary},
+		{"header/compact", thrift.THeaderProtocolCompact},
+	} {
+		codecs = append(codecs, Codec{
+			Name: h.name,
+			Protocol: thrift.NewTHeaderProtocolFactoryConf(&thrift.TConfiguration{
+				THeaderProtocolID: thrift.THeaderProtocolIDPtrMust(h.id),
+			}),
+		})
+	}
+	return codecs
+}
+
+// Nested is the payload nesting structs: Child is nil for the innermost one.
+type Nested struct {
+	Depth int32
+	Child *Nested
+}
+
+func newNested(depth int32) *Nested {
+	var n *Nested
+	for d := int32(0); d < depth; d++ {
+		n = &Nested{Depth: d, Child: n}
+	}
+	return n
+}
+
+func (p *Nested) Read(ctx context.Context, iprot thrift.TProtocol) error {
+	return readStruct(ctx, iprot, p, func(id int16, typeID thrift.TType) (bool, error) {
+		var err error
+		switch {
+		case id == 1 && typeID == thrift.I32:
+			p.Depth, err = iprot.ReadI32(ctx)
+		case id == 2 && typeID == thrift.STRUCT:
+			p.Child = &Nested{}
+			err = p.Child.Read(ctx, iprot)
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (p *Nested) Write(ctx context.Context, oprot thrift.TProtocol) error {
+	return writeStruct(ctx, oprot, "Nested", func() error {
+		if err := writeFieldBegin(ctx, oprot, "depth", thrift.I32, 1); err != nil {
+			return err
+		}
+		if err := oprot.WriteI32(ctx, p.Depth); err != nil {
+			return err
+		}
+		if err := oprot.WriteFieldEnd(ctx); err != nil {
+			return err
+		}
+		if p.Child == nil {
+			return nil
+		}
+		if err := writeFieldBegin(ctx, oprot, "child", thrift.STRUCT, 2); err != nil {
+			return err
+		}
+		if err := p.Child.Write(ctx, oprot); err != nil {
+			return err
+		}
+		return oprot.WriteFieldEnd(ctx)
+	})
+}
+
+// LargeStrings is the payload of large strings and binaries.
+type LargeStrings struct {
+	Text string
+	Blob []byte
+}
+
+func (p *LargeStrings) Read(ctx context.Context, iprot thrift.TProtocol) error {
+	return readStruct(ctx, iprot, p, func(id int16, typeID thrift.TType) (bool, error) {
+		var err error
+		switch {
+		case id == 1 && typeID == thrift.STRING:
+			p.Text, err = iprot.ReadString(ctx)
+		case id == 2 && typeID == thrift.STRING:
+			p.Blob, err = iprot.ReadBinary(ctx)
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (p *LargeStrings) Write(ctx context.Context, oprot thrift.TProtocol) error {
+	return writeStruct(ctx, oprot, "LargeStrings", func() error {
+		if err := writeFieldBegin(ctx, oprot, "text", thrift.STRING, 1); err != nil {
+			return err
+		}
+		if err := oprot.WriteString(ctx, p.Text); err != nil {
+			return err
+		}
+		if err := oprot.WriteFieldEnd(ctx); err != nil {
+			return err
+		}
+		if err := writeFieldBegin(ctx, oprot, "blob", thrift.STRING, 2); err != nil {
+			return err
+		}
+		if err := oprot.WriteBinary(ctx, p.Blob); err != nil {
+			return err
+		}
+		return oprot.WriteFieldEnd(ctx)
+	})
+}
+
+// WideMap is the payload of a wide map.
+type WideMap struct {
+	Counters map[string]int64
+}
+
+func newWideMap(entries int) *WideMap {
+	m := &WideMap{Counters: make(map[string]int64, entries)}
+	for i := 0; i < entries; i++ {
+		m.Counters[fmt.Sprintf("counter.%d", i)] = int64(i) * 1000003
+	}
+	return m
+}
+
+func (p *WideMap) Read(ctx context.Context, iprot thrift.TProtocol) error {
+	return readStruct(ctx, iprot, p, func(id int16, typeID thrift.TType) (bool, error) {
+		if id != 1 || typeID != thrift.MAP {
+			return false, nil
+		}
+		_, _, size, err := iprot.ReadMapBegin(ctx)
+		if err != nil {
+			return true, thrift.PrependError("error reading map begin: ", err)
+		}
+		p.Counters = make(map[string]int64, size)
+		for i := 0; i < size; i++ {
+			k, err := iprot.ReadString(ctx)
+			if err != nil {
+				return true, err
+			}
+			v, err := iprot.ReadI64(ctx)
+			if err != nil {
+				return true, err
+			}
+			p.Counters[k] = v
+		}
+		return true, iprot.ReadMapEnd(ctx)
+	})
+}
+
+func (p *WideMap) Write(ctx context.Context, oprot thrift.TProtocol) error {
+	return writeStruct(ctx, oprot, "WideMap", func() error {
+		if err := writeFieldBegin(ctx, oprot, "counters", thrift.MAP, 1); err != nil {
+			return err
+		}
+		if err := oprot.WriteMapBegin(ctx, thrift.STRING, thrift.I64, len(p.Counters)); err != nil {
+			return thrift.PrependError("error writing map begin: ", err)
+		}
+		for k, v := range p.Counters {
+			if err := oprot.WriteString(ctx, k); err != nil {
+				return err
+			}
+			if err := oprot.WriteI64(ctx, v); err != nil {
+				return err
+			}
+		}
+		if err := oprot.WriteMapEnd(ctx); err != nil {
+			return thrift.PrependError("error writing map end: ", err)
+		}
+		return oprot.WriteFieldEnd(ctx)
+	})
+}
+
+// SmallFields is the payload of many small fields, of every scalar type.
+type SmallFields struct {
+	Bools   [3]bool
+	Bytes   [2]int8
+	I16s    [2]int16
+	I32s    [3]int32
+	I64s    [3]int64
+	Doubles [2]float64
+	Name    string
+}
+
+func newSmallFields() *SmallFields {
+	return &SmallFields{
+		Bools:   [3]bool{true, false, true},
+		Bytes:   [2]int8{7, -7},
+		I16s:    [2]int16{300, -300},
+		I32s:    [3]int32{1, 70000, -70000},
+		I64s:    [3]int64{1, 1 << 40, -1 << 40},
+		Doubles: [2]float64{0.5, 3.14159},
+		Name:    "small",
+	}
+}
+
+// The field ids of SmallFields are consecutive, in the order of the struct
+// fields and of their elements.
+const (
+	smallBools   = 1
+	smallBytes   = smallBools + 3
+	smallI16s    = smallBytes + 2
+	smallI32s    = smallI16s + 2
+	smallI64s    = smallI32s + 3
+	smallDoubles = smallI64s + 3
+	smallName    = smallDoubles + 2
+)
+
+func (p *SmallFields) Read(ctx context.Context, iprot thrift.TProtocol) error {
+	return readStruct(ctx, iprot, p, func(id int16, typeID thrift.TType) (bool, error) {
+		var err error
+		switch {
+		case id >= smallBools && id < smallBytes && typeID == thrift.BOOL:
+			p.Bools[id-smallBools], err = iprot.ReadBool(ctx)
+		case id >= smallBytes && id < smallI16s && typeID == thrift.BYTE:
+			p.Bytes[id-smallBytes], err = iprot.ReadByte(ctx)
+		case id >= smallI16s && id < smallI32s && typeID == thrift.I16:
+			p.I16s[id-smallI16s], err = iprot.ReadI16(ctx)
+		case id >= smallI32s && id < smallI64s && typeID == thrift.I32:
+			p.I32s[id-smallI32s], err = iprot.ReadI32(ctx)
+		case id >= smallI64s && id < smallDoubles && typeID == thrift.I64:
+			p.I64s[id-smallI64s], err = iprot.ReadI64(ctx)
+		case id >= smallDoubles && id < smallName && typeID == thrift.DOUBLE:
+			p.Doubles[id-smallDoubles], err = iprot.ReadDouble(ctx)
+		case id == smallName && typeID == thrift.STRING:
+			p.Name, err = iprot.ReadString(ctx)
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (p *SmallFields) Write(ctx context.Context, oprot thrift.TProtocol) error {
+	return writeStruct(ctx, oprot, "SmallFields", func() error {
+		field := func(typeID thrift.TType, id int, write func() error) error {
+			if err := writeFieldBegin(ctx, oprot, "", typeID, int16(id)); err != nil {
+				return err
+			}
+			if err := write(); err != nil {
+				return err
+			}
+			return oprot.WriteFieldEnd(ctx)
+		}
+		for i, v := range p.Bools {
+			if err := field(thrift.BOOL, smallBools+i, func() error { return oprot.WriteBool(ctx, v) }); err != nil {
+				return err
+			}
+		}
+		for i, v := range p.Bytes {
+			if err := field(thrift.BYTE, smallBytes+i, func() error { return oprot.WriteByte(ctx, v) }); err != nil {
+				return err
+			}
+		}
+		for i, v := range p.I16s {
+			if err := field(thrift.I16, smallI16s+i, func() error { return oprot.WriteI16(ctx, v) }); err != nil {
+				return err
+			}
+		}
+		for i, v := range p.I32s {
+			if err := field(thrift.I32, smallI32s+i, func() error { return oprot.WriteI32(ctx, v) }); err != nil {
+				return err
+			}
+		}
+		for i, v := range p.I64s {
+			if err := field(thrift.I64, smallI64s+i, func() error { return oprot.WriteI64(ctx, v) }); err != nil {
+				return err
+			}
+		}
+		for i, v := range p.Doubles {
+			if err := field(thrift.DOUBLE, smallDoubles+i, func() error { return oprot.WriteDouble(ctx, v) }); err != nil {
+				return err
+			}
+		}
+		return field(thrift.STRING, smallName, func() error { return oprot.WriteString(ctx, p.Name) })
+	})
+}
+
+// readStruct reads a struct, calling readField for each of its fields. The
+// fields readField doesn't read, returning false, are skipped.
+func readStruct(ctx context.Context, iprot thrift.TProtocol, p thrift.TStruct, readField func(id int16, typeID thrift.TType) (bool, error)) error {
+	if _, err := iprot.ReadStructBegin(ctx); err != nil {
+		return thrift.PrependError(fmt.Sprintf("%T read error: ", p), err)
+	}
+	for {
+		_, typeID, id, err := iprot.ReadFieldBegin(ctx)
+		if err != nil {
+			return thrift.PrependError(fmt.Sprintf("%T field %d read error: ", p, id), err)
+		}
+		if typeID == thrift.STOP {
+			break
+		}
+		read, err := readField(id, typeID)
+		if err != nil {
+			return thrift.PrependError(fmt.Sprintf("%T field %d read error: ", p, id), err)
+		}
+		if !read {
+			if err := iprot.Skip(ctx, typeID); err != nil {
+				return err
+			}
+		}
+		if err := iprot.ReadFieldEnd(ctx); err != nil {
+			return err
+		}
+	}
+	if err := iprot.ReadStructEnd(ctx); err != nil {
+		return thrift.PrependError(fmt.Sprintf("%T read struct end error: ", p), err)
+	}
+	return nil
+}
+
+// writeStruct writes a struct named name, whose fields are written by
+// writeFields.
+func writeStruct(ctx context.Context, oprot thrift.TProtocol, name string, writeFields func() error) error {
+	if err := oprot.WriteStructBegin(ctx, name); err != nil {
+		return thrift.PrependError(fmt.Sprintf("%s write struct begin error: ", name), err)
+	}
+	if err := writeFields(); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(ctx); err != nil {
+		return thrift.PrependError("write field stop error: ", err)
+	}
+	if err := oprot.WriteStructEnd(ctx); err != nil {
+		return thrift.PrependError("write struct stop error: ", err)
+	}
+	return nil
+}
+
+func writeFieldBegin(ctx context.Context, oprot thrift.TProtocol, name string, typeID thrift.TType, id int16) error {
+	if err := oprot.WriteFieldBegin(ctx, name, typeID, id); err != nil {
+		return thrift.PrependError(fmt.Sprintf("write field begin error %d:%s: ", id, name), err)
+	}
+	return nil
+}
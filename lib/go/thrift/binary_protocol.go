@@ -33,6 +33,7 @@ type TBinaryProtocol struct {
 	origTransport TTransport
 	cfg           *TConfiguration
 	buffer        [64]byte
+	limits        tFieldSizeLimiter
 }
 
 type TBinaryProtocolFactory struct {
@@ -266,6 +267,7 @@ func (p *TBinaryProtocol) WriteBinary(ctx context.Context, value []byte) error {
  */
 
 func (p *TBinaryProtocol) ReadMessageBegin(ctx context.Context) (name string, typeId TMessageType, seqId int32, err error) {
+	p.limits.reset()
 	size, e := p.ReadI32(ctx)
 	if e != nil {
 		return "", typeId, 0, NewTProtocolException(e)
@@ -276,7 +278,7 @@ func (p *TBinaryProtocol) ReadMessageBegin(ctx context.Context) (name string, ty
 		if version != VERSION_1 {
 			return name, typeId, seqId, NewTProtocolExceptionWithType(BAD_VERSION, fmt.Errorf("Bad version in ReadMessageBegin"))
 		}
-		name, e = p.ReadString(ctx)
+		name, e = p.readString(ctx, false)
 		if e != nil {
 			return name, typeId, seqId, NewTProtocolException(e)
 		}
@@ -309,11 +311,17 @@ func (p *TBinaryProtocol) ReadMessageEnd(ctx context.Context) error {
 	return nil
 }
 
+func (p *TBinaryProtocol) resetFieldSizeLimits() {
+	p.limits.reset()
+}
+
 func (p *TBinaryProtocol) ReadStructBegin(ctx context.Context) (name string, err error) {
+	p.limits.structBegin()
 	return
 }
 
 func (p *TBinaryProtocol) ReadStructEnd(ctx context.Context) error {
+	p.limits.structEnd()
 	return nil
 }
 
@@ -355,12 +363,12 @@ func (p *TBinaryProtocol) ReadMapBegin(ctx context.Context) (kType, vType TType,
 	if err != nil {
 		return
 	}
-	size = int(size32)
-	return kType, vType, size, nil
+	size, err = p.limits.containerBegin(ctx, p.cfg, MAP, kType, vType, int(size32))
+	return kType, vType, size, err
 }
 
 func (p *TBinaryProtocol) ReadMapEnd(ctx context.Context) error {
-	return nil
+	return p.limits.containerEnd(ctx, p)
 }
 
 func (p *TBinaryProtocol) ReadListBegin(ctx context.Context) (elemType TType, size int, err error) {
@@ -379,13 +387,12 @@ func (p *TBinaryProtocol) ReadListBegin(ctx context.Context) (elemType TType, si
 	if err != nil {
 		return
 	}
-	size = int(size32)
-
+	size, err = p.limits.containerBegin(ctx, p.cfg, LIST, STOP, elemType, int(size32))
 	return
 }
 
 func (p *TBinaryProtocol) ReadListEnd(ctx context.Context) error {
-	return nil
+	return p.limits.containerEnd(ctx, p)
 }
 
 func (p *TBinaryProtocol) ReadSetBegin(ctx context.Context) (elemType TType, size int, err error) {
@@ -404,12 +411,12 @@ func (p *TBinaryProtocol) ReadSetBegin(ctx context.Context) (elemType TType, siz
 	if err != nil {
 		return
 	}
-	size = int(size32)
-	return elemType, size, nil
+	size, err = p.limits.containerBegin(ctx, p.cfg, SET, STOP, elemType, int(size32))
+	return elemType, size, err
 }
 
 func (p *TBinaryProtocol) ReadSetEnd(ctx context.Context) error {
-	return nil
+	return p.limits.containerEnd(ctx, p)
 }
 
 func (p *TBinaryProtocol) ReadBool(ctx context.Context) (bool, error) {
@@ -455,6 +462,11 @@ func (p *TBinaryProtocol) ReadDouble(ctx context.Context) (value float64, err er
 }
 
 func (p *TBinaryProtocol) ReadString(ctx context.Context) (value string, err error) {
+	return p.readString(ctx, true)
+}
+
+// readString reads a string, applying the per-field limits if limited.
+func (p *TBinaryProtocol) readString(ctx context.Context, limited bool) (value string, err error) {
 	size, e := p.ReadI32(ctx)
 	if e != nil {
 		return "", e
@@ -466,14 +478,24 @@ func (p *TBinaryProtocol) ReadString(ctx context.Context) (value string, err err
 	if size == 0 {
 		return "", nil
 	}
-	if size < int32(len(p.buffer)) {
+	n := size
+	if limited {
+		if n, err = p.limits.readSize(ctx, p.cfg, false, size); err != nil {
+			return
+		}
+	}
+	if n < int32(len(p.buffer)) {
 		// Avoid allocation on small reads
-		buf := p.buffer[:size]
+		buf := p.buffer[:n]
 		read, e := io.ReadFull(p.trans, buf)
-		return string(buf[:read]), NewTProtocolException(e)
+		value, err = string(buf[:read]), NewTProtocolException(e)
+	} else {
+		value, err = p.readStringBody(n)
 	}
-
-	return p.readStringBody(size)
+	if err != nil || n == size {
+		return
+	}
+	return cutString(value), NewTProtocolException(skipBytes(p.trans, size-n))
 }
 
 func (p *TBinaryProtocol) ReadBinary(ctx context.Context) ([]byte, error) {
@@ -484,8 +506,15 @@ func (p *TBinaryProtocol) ReadBinary(ctx context.Context) ([]byte, error) {
 	if err := checkSizeForProtocol(size, p.cfg); err != nil {
 		return nil, err
 	}
+	n, err := p.limits.readSize(ctx, p.cfg, true, size)
+	if err != nil {
+		return nil, err
+	}
 
-	buf, err := safeReadBytes(size, p.trans)
+	buf, err := safeReadBytes(n, p.trans)
+	if err == nil && n < size {
+		err = skipBytes(p.trans, size-n)
+	}
 	return buf, NewTProtocolException(err)
 }
 
@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"time"
+)
+
+// CallOptions are the options of a single client call, overriding the
+// configuration of the TClient and the ClientMiddlewares it goes through, so
+// that one client can be used for calls needing different options.
+//
+// They're set in the context of the call with WithCallOptions, which works
+// the same for the calls of the generated clients:
+//
+//	ctx = thrift.WithCallOptions(ctx, thrift.CallTimeout(time.Second))
+//	resp, err := client.MyMethod(ctx, req)
+type CallOptions struct {
+	// Timeout is the timeout of the call if positive, applied by
+	// CallOptionsMiddleware.
+	Timeout time.Duration
+
+	// Headers are the THeader headers sent with the call, applied by
+	// CallOptionsMiddleware.
+	Headers THeaderMap
+
+	// Retry overrides the RetryPolicy of RetryMiddleware if non-nil.
+	Retry *RetryPolicy
+
+	// Endpoint is the address of the endpoint a TBalancedClient sends the
+	// call to if it's ready, instead of the one chosen by its policy.
+	Endpoint string
+}
+
+// CallOption sets an option of CallOptions.
+type CallOption func(*CallOptions)
+
+// CallTimeout returns a CallOption setting the Timeout of a call.
+func CallTimeout(timeout time.Duration) CallOption {
+	return func(o *CallOptions) {
+		o.Timeout = timeout
+	}
+}
+
+// CallHeader returns a CallOption adding a header to the Headers of a call.
+func CallHeader(key, value string) CallOption {
+	return func(o *CallOptions) {
+		o.Headers[key] = value
+	}
+}
+
+// CallRetryPolicy returns a CallOption setting the Retry of a call, for
+// example RetryPolicy{MaxAttempts: 1} disables its retries.
+func CallRetryPolicy(policy RetryPolicy) CallOption {
+	return func(o *CallOptions) {
+		o.Retry = &policy
+	}
+}
+
+// CallEndpoint returns a CallOption setting the Endpoint of a call.
+func CallEndpoint(address string) CallOption {
+	return func(o *CallOptions) {
+		o.Endpoint = address
+	}
+}
+
+// See https://godoc.org/context#WithValue on why do we need the unexported typedefs.
+type callOptionsKey struct{}
+
+// WithCallOptions returns a copy of ctx with opts applied to the CallOptions
+// already set in ctx, if any.
+func WithCallOptions(ctx context.Context, opts ...CallOption) context.Context {
+	options := CallOptionsFromContext(ctx)
+	headers := make(THeaderMap, len(options.Headers))
+	for key, value := range options.Headers {
+		headers[key] = value
+	}
+	options.Headers = headers
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return context.WithValue(ctx, callOptionsKey{}, options)
+}
+
+// CallOptionsFromContext returns the CallOptions set in ctx by
+// WithCallOptions, the zero CallOptions if none.
+func CallOptionsFromContext(ctx context.Context) CallOptions {
+	options, _ := ctx.Value(callOptionsKey{}).(CallOptions)
+	return options
+}
+
+// CallOptionsMiddleware is a ClientMiddleware applying the Timeout and the
+// Headers of the CallOptions of the calls. The Headers require
+// THeaderProtocol.
+//
+// It should be the first of the middlewares passed to WrapClient, so that the
+// timeout applies to the others, such as RetryMiddleware.
+func CallOptionsMiddleware(next TClient) TClient {
+	return WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			opts := CallOptionsFromContext(ctx)
+			if opts.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+				defer cancel()
+			}
+			for key, value := range opts.Headers {
+				ctx = addWriteHeader(ctx, key, value)
+			}
+			return next.Call(ctx, method, args, result)
+		},
+	}
+}
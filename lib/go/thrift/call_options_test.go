@@ -0,0 +1,74 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCallOptionsMiddleware(t *testing.T) {
+	base := WithCallOptions(context.Background(), CallHeader("tenant", "a"), CallTimeout(time.Minute))
+	ctx := WithCallOptions(base, CallHeader("tenant", "b"), CallHeader("user", "u"), CallTimeout(time.Second))
+	if tenant := CallOptionsFromContext(base).Headers["tenant"]; tenant != "a" {
+		t.Errorf("expected the options of the parent context to be kept, got tenant %q", tenant)
+	}
+
+	client := WrapClient(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			deadline, ok := ctx.Deadline()
+			if !ok || time.Until(deadline) > time.Second {
+				t.Errorf("expected a deadline within a second, got %v", deadline)
+			}
+			if len(GetWriteHeaderList(ctx)) != 2 {
+				t.Errorf("expected 2 headers to write, got %v", GetWriteHeaderList(ctx))
+			}
+			for key, expected := range map[string]string{"tenant": "b", "user": "u"} {
+				if value, _ := GetHeader(ctx, key); value != expected {
+					t.Errorf("expected header %s to be %q, got %q", key, expected, value)
+				}
+			}
+			return ResponseMeta{}, nil
+		},
+	}, CallOptionsMiddleware)
+	if _, err := client.Call(ctx, "m", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallEndpoint(t *testing.T) {
+	var err error
+	called := make(map[string]int)
+	c := NewTBalancedClient(recordingEndpoints(called, &err, "a", "b", "c"), TBalancedClientOptions{})
+
+	ctx := WithCallOptions(context.Background(), CallEndpoint("c"))
+	for i := 0; i < 3; i++ {
+		c.Call(ctx, "m", nil, nil)
+	}
+	if called["c"] != 3 {
+		t.Errorf("expected the calls to go to the hinted endpoint, got %v", called)
+	}
+
+	c.Call(WithCallOptions(context.Background(), CallEndpoint("unknown")), "m", nil, nil)
+	if called["a"]+called["b"]+called["c"] != 4 {
+		t.Errorf("expected an unknown endpoint to be ignored, got %v", called)
+	}
+}
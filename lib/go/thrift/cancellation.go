@@ -0,0 +1,218 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CancelMethod is the reserved method name of the cancellation notifications
+// sent by CancellationMiddleware, see TSimpleServer.SetCancellationNotifications.
+const CancelMethod = "__thrift_cancel"
+
+// CancelTokenHeader is the THeader carrying the token identifying a call in
+// its cancellation notification.
+const CancelTokenHeader = "thrift-cancel-token"
+
+// DefaultCancelNotifyTimeout is the default Timeout of CancellationOptions.
+const DefaultCancelNotifyTimeout = time.Second
+
+// CancellationOptions configures CancellationMiddleware.
+type CancellationOptions struct {
+	// Notify sends the cancellation notifications, as oneway calls to
+	// CancelMethod. It must not be nil.
+	//
+	// A server processes the requests of a connection in order, so Notify
+	// must use other connections than the calls, e.g. a TConnPool to the
+	// same server.
+	Notify TClient
+
+	// Timeout is the timeout of the notifications,
+	// DefaultCancelNotifyTimeout if 0.
+	Timeout time.Duration
+}
+
+// CancellationMiddleware returns a ClientMiddleware notifying the server when
+// the context of a call is done before the call returns, so that the server
+// cancels the context of its handler, instead of processing a request whose
+// reply is abandoned.
+//
+// Every call is sent with a random token in the CancelTokenHeader, which
+// requires THeaderProtocol, and the notification is a oneway call to
+// CancelMethod with that token. The servers must enable them with
+// SetCancellationNotifications. The notifications are best effort: their
+// errors are ignored, and the requests already processed are not affected.
+func CancellationMiddleware(opts CancellationOptions) ClientMiddleware {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultCancelNotifyTimeout
+	}
+	return func(next TClient) TClient {
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				if ctx.Done() == nil {
+					return next.Call(ctx, method, args, result)
+				}
+				token := newRequestID()
+				returned := make(chan struct{})
+				go func() {
+					select {
+					case <-returned:
+						return
+					case <-ctx.Done():
+					}
+					notifyCtx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+					defer cancel()
+					opts.Notify.Call(notifyCtx, CancelMethod, &tCancelArgs{Token: token}, nil)
+				}()
+				meta, err := next.Call(addWriteHeader(ctx, CancelTokenHeader, token), method, args, result)
+				close(returned)
+				return meta, err
+			},
+		}
+	}
+}
+
+// tCancelArgs are the arguments of the calls to CancelMethod.
+type tCancelArgs struct {
+	Token string
+}
+
+func (a *tCancelArgs) Read(ctx context.Context, in TProtocol) error {
+	if _, err := in.ReadStructBegin(ctx); err != nil {
+		return err
+	}
+	for {
+		_, typeID, id, err := in.ReadFieldBegin(ctx)
+		if err != nil {
+			return err
+		}
+		if typeID == STOP {
+			break
+		}
+		if id == 1 && typeID == STRING {
+			if a.Token, err = in.ReadString(ctx); err != nil {
+				return err
+			}
+		} else if err := in.Skip(ctx, typeID); err != nil {
+			return err
+		}
+		if err := in.ReadFieldEnd(ctx); err != nil {
+			return err
+		}
+	}
+	return in.ReadStructEnd(ctx)
+}
+
+func (a *tCancelArgs) Write(ctx context.Context, out TProtocol) error {
+	if err := out.WriteStructBegin(ctx, "cancel_args"); err != nil {
+		return err
+	}
+	if err := out.WriteFieldBegin(ctx, "token", STRING, 1); err != nil {
+		return err
+	}
+	if err := out.WriteString(ctx, a.Token); err != nil {
+		return err
+	}
+	if err := out.WriteFieldEnd(ctx); err != nil {
+		return err
+	}
+	if err := out.WriteFieldStop(ctx); err != nil {
+		return err
+	}
+	return out.WriteStructEnd(ctx)
+}
+
+// tCancellableRequests are the requests of a server with a CancelTokenHeader
+// being processed, by token.
+type tCancellableRequests struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func (r *tCancellableRequests) add(token string, cancel context.CancelFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancels == nil {
+		r.cancels = make(map[string]context.CancelFunc)
+	}
+	r.cancels[token] = cancel
+}
+
+func (r *tCancellableRequests) remove(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cancels, token)
+}
+
+// cancel cancels the request of token, it returns false if it's not being
+// processed.
+func (r *tCancellableRequests) cancel(token string) bool {
+	r.mu.Lock()
+	cancel, ok := r.cancels[token]
+	r.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// tCancellationProcessor handles the cancellation notifications of a
+// TSimpleServer, and registers the requests they can cancel.
+type tCancellationProcessor struct {
+	TProcessor
+
+	requests *tCancellableRequests
+}
+
+func (p *tCancellationProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	if name == CancelMethod || strings.HasSuffix(name, MULTIPLEXED_SEPARATOR+CancelMethod) {
+		var args tCancelArgs
+		if err := args.Read(ctx, in); err != nil {
+			return false, WrapTException(err)
+		}
+		if err := in.ReadMessageEnd(ctx); err != nil {
+			return false, WrapTException(err)
+		}
+		p.requests.cancel(args.Token)
+		if typeID != ONEWAY {
+			if err := writeEmptyReply(ctx, out, CancelMethod, seqID); err != nil {
+				return false, WrapTException(err)
+			}
+		}
+		return true, nil
+	}
+	if token, _ := GetHeader(ctx, CancelTokenHeader); token != "" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		p.requests.add(token, cancel)
+		defer p.requests.remove(token)
+	}
+	return p.TProcessor.Process(ctx, NewStoredMessageProtocol(in, name, typeID, seqID), out)
+}
+
+var _ TProcessor = (*tCancellationProcessor)(nil)
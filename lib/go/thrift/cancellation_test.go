@@ -0,0 +1,62 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCancellationNotifications(t *testing.T) {
+	serv, addr := startTestSocketServer(t, &cancelWaitingProcessor{}, func(s *TSimpleServer) {
+		s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+		s.outputProtocolFactory = s.inputProtocolFactory
+		s.SetCancellationNotifications(true)
+		s.SetRequestTracking(true)
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	newClient := func() TClient {
+		proto := NewTHeaderProtocolConf(dialTestSocketServer(t, addr), nil)
+		return NewTStandardClient(proto, proto)
+	}
+	client := WrapClient(newClient(), CancellationMiddleware(CancellationOptions{
+		Notify: newClient(),
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for len(serv.InFlightRequests()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	// The handler only replies once its context is canceled.
+	_, err := client.Call(ctx, "wait", &pipelinedString{Value: "a"}, &pipelinedString{})
+	var exc TApplicationException
+	if !errors.As(err, &exc) || exc.Error() != context.Canceled.Error() {
+		t.Errorf("expected the handler to be canceled, got %v", err)
+	}
+}
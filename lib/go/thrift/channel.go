@@ -0,0 +1,279 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"sync"
+)
+
+// errChannelClosed is returned by the calls of a closed Channel.
+var errChannelClosed = NewTTransportException(NOT_OPEN, "channel closed")
+
+// ChannelOptions configures NewChannel.
+type ChannelOptions struct {
+	// Resolver resolves the addresses of the servers, e.g.
+	// NewStaticResolver or NewDNSSRVResolver. It must not be nil.
+	Resolver Resolver
+
+	// Dial opens a new connection to address, with the transports the
+	// server expects (TFramedTransport, etc.).
+	//
+	// If nil, a TSocket configured with Configuration is opened.
+	Dial func(ctx context.Context, address string) (TTransport, error)
+
+	// Configuration configures the TSockets of the default Dial.
+	Configuration *TConfiguration
+
+	// ProtocolFactory is the protocol of the connections, TBinaryProtocol
+	// if nil.
+	ProtocolFactory TProtocolFactory
+
+	// Pool configures the TConnPool of the connections to each address,
+	// whose Dial and ProtocolFactory are set by the Channel. The
+	// connections of its WarmConns are opened in the background as soon as
+	// an address is resolved.
+	Pool ConnPoolOptions
+
+	// Policy, Breaker, OutlierDetector and Healthy configure the
+	// TBalancedClient distributing the calls over the addresses, see
+	// TBalancedClientOptions.
+	Policy          LoadBalancingPolicy
+	Breaker         *CircuitBreaker
+	OutlierDetector *OutlierDetector
+	Healthy         func(address string) bool
+
+	// Retry is the RetryPolicy of the calls, not retried if its
+	// MaxAttempts is 0, see RetryMiddleware.
+	Retry RetryPolicy
+
+	// Hedging, if not nil, hedges the calls, see HedgingMiddleware.
+	Hedging *HedgingOptions
+
+	// RetryBudget, if not nil, is the budget of the retries and hedges,
+	// unless they set their own.
+	RetryBudget *RetryBudget
+
+	// Middlewares wrap the calls of the Channel, before they're retried.
+	Middlewares []ClientMiddleware
+}
+
+// Channel is the single entry point of the clients of the servers of a
+// service, like the ClientConn of gRPC: it resolves their addresses, keeps a
+// pool of connections to each of them, and distributes the calls over them,
+// retried, hedged and broken as configured. It's a TClient safe for
+// concurrent use, shared by the generated clients:
+//
+//	channel := thrift.NewChannel(thrift.ChannelOptions{
+//		Resolver: thrift.NewStaticResolver("backend-1:9090", "backend-2:9090"),
+//		Retry:    thrift.RetryPolicy{MaxAttempts: 3},
+//	})
+//	defer channel.Close()
+//	users := NewUsersClient(channel.Client("Users"))
+//	orders := NewOrdersClient(channel.Client("Orders"))
+//
+// No connection is opened until the addresses are resolved in the
+// background, and the calls made before then wait for them.
+type Channel struct {
+	balanced *TBalancedClient
+	client   TClient
+	cancel   context.CancelFunc
+
+	// ready is closed once the addresses are first resolved, or the watch
+	// of the resolver ended.
+	ready     chan struct{}
+	readyOnce sync.Once
+	// done is closed once the watch of the resolver ended, with err.
+	done chan struct{}
+	err  error
+
+	mu     sync.Mutex
+	pools  map[string]*TConnPool
+	closed bool
+}
+
+// NewChannel returns a Channel configured with opts, watching the addresses
+// of opts.Resolver until it's closed.
+func NewChannel(opts ChannelOptions) *Channel {
+	if opts.Dial == nil {
+		conf := opts.Configuration
+		opts.Dial = func(ctx context.Context, address string) (TTransport, error) {
+			return NewTSocketConf(address, conf)
+		}
+	}
+	if opts.RetryBudget != nil {
+		if opts.Retry.Budget == nil {
+			opts.Retry.Budget = opts.RetryBudget
+		}
+		if opts.Hedging != nil && opts.Hedging.RetryBudget == nil {
+			hedging := *opts.Hedging
+			hedging.RetryBudget = opts.RetryBudget
+			opts.Hedging = &hedging
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Channel{
+		balanced: NewTBalancedClient(nil, TBalancedClientOptions{
+			Policy:          opts.Policy,
+			Breaker:         opts.Breaker,
+			OutlierDetector: opts.OutlierDetector,
+			Healthy:         opts.Healthy,
+		}),
+		cancel: cancel,
+		ready:  make(chan struct{}),
+		done:   make(chan struct{}),
+		pools:  make(map[string]*TConnPool),
+	}
+	middlewares := append([]ClientMiddleware{CallOptionsMiddleware}, opts.Middlewares...)
+	middlewares = append(middlewares, RetryMiddleware(opts.Retry))
+	if opts.Hedging != nil {
+		middlewares = append(middlewares, HedgingMiddleware(*opts.Hedging))
+	}
+	c.client = WrapClient(c.balanced, middlewares...)
+
+	resolver := tChannelResolver{
+		Resolver: opts.Resolver,
+		resolved: c.setReady,
+	}
+	go func() {
+		c.err = c.balanced.Watch(ctx, resolver, func(address string) (TClient, error) {
+			return c.pool(ctx, opts, address), nil
+		})
+		c.setReady()
+		close(c.done)
+	}()
+	return c
+}
+
+// pool returns a new TConnPool of the connections to address.
+func (c *Channel) pool(ctx context.Context, opts ChannelOptions, address string) *TConnPool {
+	poolOpts := opts.Pool
+	poolOpts.Dial = func(ctx context.Context) (TTransport, error) {
+		return opts.Dial(ctx, address)
+	}
+	poolOpts.ProtocolFactory = opts.ProtocolFactory
+	pool := NewTConnPool(poolOpts)
+	if poolOpts.WarmConns > 0 {
+		go pool.Warm(ctx)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if previous := c.pools[address]; previous != nil {
+		// Closed by Watch when its address was removed.
+		previous.Close()
+	}
+	c.pools[address] = pool
+	return pool
+}
+
+func (c *Channel) setReady() {
+	c.readyOnce.Do(func() {
+		close(c.ready)
+	})
+}
+
+// Call implements TClient, waiting for the addresses of the servers to be
+// resolved first.
+func (c *Channel) Call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	select {
+	case <-c.ready:
+	case <-ctx.Done():
+		return ResponseMeta{}, ctx.Err()
+	}
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return ResponseMeta{}, errChannelClosed
+	}
+	return c.client.Call(ctx, method, args, result)
+}
+
+// Client returns a TClient sending the calls of Channel to the service of a
+// TMultiplexedProcessor, for the generated client of the service. The
+// middlewares of the Channel get the method names without the service.
+func (c *Channel) Client(service string) TClient {
+	return WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			return c.Call(withMultiplexedService(ctx, service), method, args, result)
+		},
+	}
+}
+
+// Err returns the error ending the watch of the resolver, nil while it's
+// watched.
+func (c *Channel) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close stops watching the resolver and closes the connections, the calls
+// in progress completing first. The later calls fail.
+func (c *Channel) Close() error {
+	c.cancel()
+	<-c.done
+	c.mu.Lock()
+	pools := c.pools
+	c.pools, c.closed = nil, true
+	c.mu.Unlock()
+	var first error
+	for _, pool := range pools {
+		if err := pool.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// tChannelResolver is the Resolver of a Channel, notifying it once the
+// addresses are resolved.
+type tChannelResolver struct {
+	Resolver
+
+	resolved func()
+}
+
+func (r tChannelResolver) Watch(ctx context.Context, update func([]ResolvedAddress)) error {
+	return r.Resolver.Watch(ctx, func(addresses []ResolvedAddress) {
+		update(addresses)
+		r.resolved()
+	})
+}
+
+type multiplexedServiceKey struct{}
+
+// withMultiplexedService returns a copy of ctx whose calls are sent to the
+// service of a TMultiplexedProcessor, see writeCallMessage.
+func withMultiplexedService(ctx context.Context, service string) context.Context {
+	return context.WithValue(ctx, multiplexedServiceKey{}, service)
+}
+
+// multiplexedService returns the service of the calls of ctx, "" if none.
+func multiplexedService(ctx context.Context) string {
+	service, _ := ctx.Value(multiplexedServiceKey{}).(string)
+	return service
+}
+
+var _ TClient = (*Channel)(nil)
@@ -0,0 +1,113 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChannel(t *testing.T) {
+	newProcessor := func() *contextCapturingProcessor {
+		return &contextCapturingProcessor{
+			mockProcessor: echoProcessor(),
+			ctxs:          make(chan context.Context, 10),
+		}
+	}
+	first, second := newProcessor(), newProcessor()
+	var addrs []string
+	for _, processor := range []TProcessor{first, second} {
+		serv, addr := startTestSocketServer(t, processor, nil)
+		t.Cleanup(func() {
+			serv.Stop()
+		})
+		addrs = append(addrs, addr)
+	}
+	resolver := &watchFuncResolver{updates: make(chan func([]ResolvedAddress), 1)}
+	channel := NewChannel(ChannelOptions{
+		Resolver:      resolver,
+		Configuration: &TConfiguration{SocketTimeout: 5 * time.Second},
+		Retry:         RetryPolicy{MaxAttempts: 2},
+	})
+	t.Cleanup(func() {
+		channel.Close()
+	})
+	call := func(ctx context.Context, client TClient, value string) error {
+		var result echoValue
+		if _, err := client.Call(ctx, "echo", &echoValue{value: value}, &result); err != nil {
+			return err
+		}
+		if result.value != value {
+			t.Errorf("expected result %q, got %q", value, result.value)
+		}
+		return nil
+	}
+
+	// The calls wait for the addresses to be resolved.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := call(ctx, channel, "early"); err != context.DeadlineExceeded {
+		t.Errorf("expected the call to wait for the addresses, got %v", err)
+	}
+	update := <-resolver.updates
+	update([]ResolvedAddress{{Address: addrs[0], Weight: 1}, {Address: addrs[1], Weight: 1}})
+
+	for i := 0; i < 4; i++ {
+		if err := call(context.Background(), channel, "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(first.ctxs) != 2 || len(second.ctxs) != 2 {
+		t.Errorf("expected the calls to be balanced, got %d and %d", len(first.ctxs), len(second.ctxs))
+	}
+	channel.Close()
+	if err := call(context.Background(), channel, "closed"); err == nil {
+		t.Error("expected the calls of a closed channel to fail")
+	}
+}
+
+func TestChannelClient(t *testing.T) {
+	mp := NewTMultiplexedProcessor()
+	mp.RegisterProcessor("Echo", echoProcessor())
+	serv, addr := startTestSocketServer(t, mp, nil)
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	channel := NewChannel(ChannelOptions{
+		Resolver:      NewStaticResolver(addr),
+		Configuration: &TConfiguration{SocketTimeout: 5 * time.Second},
+		Pool:          ConnPoolOptions{WarmConns: 1},
+	})
+	t.Cleanup(func() {
+		channel.Close()
+	})
+
+	var result echoValue
+	if _, err := channel.Client("Echo").Call(context.Background(), "echo", &echoValue{value: "hello"}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.value != "hello" {
+		t.Errorf("unexpected result %q", result.value)
+	}
+	if _, err := channel.Call(context.Background(), "echo", &echoValue{value: "hello"}, &result); err == nil {
+		t.Error("expected the call without a service to fail")
+	}
+}
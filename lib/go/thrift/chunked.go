@@ -0,0 +1,301 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default values of ChunkWriterOptions and ChunkAssemblerOptions.
+const (
+	DefaultChunkSize          = 1024 * 1024
+	DefaultChunkedMaxSize     = 1024 * 1024 * 1024
+	DefaultChunkedTransfers   = 64
+	DefaultChunkedIdleTimeout = time.Minute
+)
+
+// The chunks of TChunkWriter start with chunkMagic and chunkVersion, then
+// their flags as a byte, their sequence number as 4 bytes in big endian, and
+// the id of their transfer as 16 bytes.
+const (
+	chunkMagic      = "TCHK"
+	chunkVersion    = 1
+	chunkHeaderSize = len(chunkMagic) + 1 + 1 + 4 + 16
+
+	// chunkLast flags the last chunk of a transfer.
+	chunkLast = 1
+)
+
+// ChunkWriterOptions configures NewTChunkWriter.
+type ChunkWriterOptions struct {
+	// ChunkSize is the size of the payload in each chunk, DefaultChunkSize
+	// if 0. It must be small enough for the messages carrying the chunks to
+	// be below the MaxMessageSize of the peers.
+	ChunkSize int
+}
+
+// TChunkWriter is an io.WriteCloser splitting a payload into chunks, so that
+// payloads larger than the MaxMessageSize of the peers can be sent across
+// existing methods taking a binary, one chunk per call, to be reassembled by
+// a TChunkAssembler:
+//
+//	w := thrift.NewTChunkWriter(ctx, func(ctx context.Context, chunk []byte) error {
+//		return client.Upload(ctx, chunk)
+//	}, thrift.ChunkWriterOptions{})
+//	if _, err := io.Copy(w, file); err != nil {
+//		return err
+//	}
+//	return w.Close()
+//
+// Each chunk carries the id of the transfer, its sequence number, and whether
+// it's the last one. The last chunk is sent by Close, even if the payload is
+// empty.
+//
+// It is not safe for concurrent use.
+type TChunkWriter struct {
+	ctx  context.Context
+	send func(ctx context.Context, chunk []byte) error
+	id   [16]byte
+	seq  uint32
+	buf  []byte
+	// err is the first error of send.
+	err error
+}
+
+// NewTChunkWriter returns a TChunkWriter sending its chunks with send, called
+// with ctx. The chunks are reused once send returns, so it must not retain
+// them.
+func NewTChunkWriter(ctx context.Context, send func(ctx context.Context, chunk []byte) error, opts ChunkWriterOptions) *TChunkWriter {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	w := &TChunkWriter{
+		ctx:  ctx,
+		send: send,
+		buf:  make([]byte, chunkHeaderSize, chunkHeaderSize+opts.ChunkSize),
+	}
+	rand.Read(w.id[:])
+	return w
+}
+
+// Write buffers p, sending the chunks filled.
+func (w *TChunkWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		free := cap(w.buf) - len(w.buf)
+		if free == 0 {
+			if err := w.flush(0); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if free > len(p) {
+			free = len(p)
+		}
+		w.buf = append(w.buf, p[:free]...)
+		n += free
+		p = p[free:]
+	}
+	return n, nil
+}
+
+// Close sends the last chunk.
+func (w *TChunkWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flush(chunkLast); err != nil {
+		return err
+	}
+	w.err = errors.New("thrift: TChunkWriter closed")
+	return nil
+}
+
+func (w *TChunkWriter) flush(flags byte) error {
+	copy(w.buf, chunkMagic)
+	w.buf[len(chunkMagic)] = chunkVersion
+	w.buf[len(chunkMagic)+1] = flags
+	binary.BigEndian.PutUint32(w.buf[len(chunkMagic)+2:], w.seq)
+	copy(w.buf[len(chunkMagic)+6:], w.id[:])
+	if err := w.send(w.ctx, w.buf); err != nil {
+		w.err = err
+		return err
+	}
+	w.seq++
+	w.buf = w.buf[:chunkHeaderSize]
+	return nil
+}
+
+// ChunkAssemblerOptions configures NewTChunkAssembler.
+type ChunkAssemblerOptions struct {
+	// MaxSize is the maximum size of a payload, DefaultChunkedMaxSize if 0.
+	MaxSize int
+
+	// MaxTransfers is the maximum number of incomplete transfers,
+	// DefaultChunkedTransfers if 0.
+	MaxTransfers int
+
+	// IdleTimeout is how long an incomplete transfer is kept without
+	// receiving a chunk, DefaultChunkedIdleTimeout if 0.
+	IdleTimeout time.Duration
+}
+
+// TChunkAssembler reassembles the payloads split by TChunkWriters, for
+// example in the handler of the method receiving the chunks:
+//
+//	func (h *handler) Upload(ctx context.Context, chunk []byte) error {
+//		payload, err := h.assembler.Add(chunk)
+//		if err != nil || payload == nil {
+//			return err
+//		}
+//		return h.store(ctx, payload)
+//	}
+//
+// The chunks of a transfer must be added in order. The chunk added again
+// right after it was, e.g. when a call is retried after its reply was lost,
+// is ignored, even the last one until the transfer is idle. The transfers exceeding MaxSize, or with a missing chunk, fail,
+// and are then forgotten.
+//
+// It is safe for concurrent use.
+type TChunkAssembler struct {
+	opts ChunkAssemblerOptions
+
+	mu sync.Mutex
+	// transfers are the incomplete transfers, and the completed ones until
+	// they're idle, to ignore their last chunk if it's added again.
+	transfers map[[16]byte]*tChunkTransfer
+	// incomplete is the number of incomplete transfers.
+	incomplete int
+}
+
+type tChunkTransfer struct {
+	// next is the sequence number of the next chunk.
+	next    uint32
+	payload []byte
+	// last is the last chunk added, to recognize it if it's added again.
+	last     []byte
+	updated  time.Time
+	complete bool
+}
+
+// NewTChunkAssembler returns a new TChunkAssembler.
+func NewTChunkAssembler(opts ChunkAssemblerOptions) *TChunkAssembler {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultChunkedMaxSize
+	}
+	if opts.MaxTransfers <= 0 {
+		opts.MaxTransfers = DefaultChunkedTransfers
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultChunkedIdleTimeout
+	}
+	return &TChunkAssembler{
+		opts:      opts,
+		transfers: make(map[[16]byte]*tChunkTransfer),
+	}
+}
+
+// Add adds a chunk of a TChunkWriter, returning the payload of its transfer
+// if it's the last chunk, nil otherwise.
+func (a *TChunkAssembler) Add(chunk []byte) ([]byte, error) {
+	if len(chunk) < chunkHeaderSize || string(chunk[:len(chunkMagic)]) != chunkMagic {
+		return nil, NewTProtocolExceptionWithType(INVALID_DATA, errors.New("not a chunk"))
+	}
+	if version := chunk[len(chunkMagic)]; version != chunkVersion {
+		return nil, NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("unsupported chunk version %d", version))
+	}
+	flags := chunk[len(chunkMagic)+1]
+	seq := binary.BigEndian.Uint32(chunk[len(chunkMagic)+2:])
+	var id [16]byte
+	copy(id[:], chunk[len(chunkMagic)+6:])
+	data := chunk[chunkHeaderSize:]
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	a.expire(now)
+	t := a.transfers[id]
+	if t == nil {
+		if seq != 0 {
+			return nil, NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("chunk %d of an unknown transfer", seq))
+		}
+		if a.incomplete >= a.opts.MaxTransfers {
+			return nil, fmt.Errorf("thrift: too many chunked transfers in progress (%d)", a.incomplete)
+		}
+		t = &tChunkTransfer{}
+		a.transfers[id] = t
+		a.incomplete++
+	}
+	if seq+1 == t.next && string(chunk) == string(t.last) {
+		// The chunk is added again.
+		t.updated = now
+		return nil, nil
+	}
+	if t.complete {
+		return nil, NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("chunk %d of a complete transfer", seq))
+	}
+	if seq != t.next {
+		a.remove(id, t)
+		return nil, NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("chunk %d received instead of chunk %d", seq, t.next))
+	}
+	if len(t.payload)+len(data) > a.opts.MaxSize {
+		a.remove(id, t)
+		return nil, NewTProtocolExceptionWithType(SIZE_LIMIT, fmt.Errorf("chunked payload exceeds %d bytes", a.opts.MaxSize))
+	}
+	t.payload = append(t.payload, data...)
+	t.last = append(t.last[:0], chunk...)
+	t.next++
+	t.updated = now
+	if flags&chunkLast == 0 {
+		return nil, nil
+	}
+	payload := t.payload
+	if payload == nil {
+		payload = []byte{}
+	}
+	t.payload, t.complete = nil, true
+	a.incomplete--
+	return payload, nil
+}
+
+func (a *TChunkAssembler) remove(id [16]byte, t *tChunkTransfer) {
+	delete(a.transfers, id)
+	if !t.complete {
+		a.incomplete--
+	}
+}
+
+// expire forgets the idle transfers.
+func (a *TChunkAssembler) expire(now time.Time) {
+	for id, t := range a.transfers {
+		if now.Sub(t.updated) > a.opts.IdleTimeout {
+			a.remove(id, t)
+		}
+	}
+}
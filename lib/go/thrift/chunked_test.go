@@ -0,0 +1,167 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// chunksOf returns the chunks of payload written to a TChunkWriter.
+func chunksOf(t *testing.T, payload []byte, chunkSize int) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	w := NewTChunkWriter(context.Background(), func(ctx context.Context, chunk []byte) error {
+		chunks = append(chunks, append([]byte(nil), chunk...))
+		return nil
+	}, ChunkWriterOptions{ChunkSize: chunkSize})
+	// Written in pieces not aligned on the chunks.
+	if _, err := io.CopyBuffer(w, bytes.NewReader(payload), make([]byte, 7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("after close")); err == nil {
+		t.Error("expected an error after Close")
+	}
+	return chunks
+}
+
+func TestChunkedTransfer(t *testing.T) {
+	payload := []byte("a payload larger than the chunks")
+	for _, c := range []struct {
+		payload   []byte
+		chunkSize int
+		chunks    int
+	}{
+		{payload, 10, 4},
+		{payload[:20], 10, 2},
+		{nil, 10, 1},
+		{payload, 0, 1},
+	} {
+		chunks := chunksOf(t, c.payload, c.chunkSize)
+		if len(chunks) != c.chunks {
+			t.Errorf("expected %d chunks, got %d", c.chunks, len(chunks))
+		}
+		a := NewTChunkAssembler(ChunkAssemblerOptions{})
+		for i, chunk := range chunks {
+			got, err := a.Add(chunk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := i == len(chunks)-1; last != (got != nil) {
+				t.Fatalf("chunk %d: unexpected payload %q", i, got)
+			}
+			if got != nil && !bytes.Equal(got, c.payload) {
+				t.Errorf("expected %q, got %q", c.payload, got)
+			}
+			// The retried chunks are ignored, even the last one.
+			if got, err := a.Add(chunk); got != nil || err != nil {
+				t.Fatalf("chunk %d added again: unexpected payload %q, error %v", i, got, err)
+			}
+		}
+	}
+}
+
+func TestChunkedTransferInterleaved(t *testing.T) {
+	first, second := chunksOf(t, []byte("first payload"), 4), chunksOf(t, []byte("second payload"), 5)
+	a := NewTChunkAssembler(ChunkAssemblerOptions{})
+	var got [][]byte
+	for i := 0; i < len(first) || i < len(second); i++ {
+		for _, chunks := range [][][]byte{first, second} {
+			if i < len(chunks) {
+				payload, err := a.Add(chunks[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if payload != nil {
+					got = append(got, payload)
+				}
+			}
+		}
+	}
+	if len(got) != 2 || string(got[0]) != "second payload" || string(got[1]) != "first payload" {
+		t.Errorf("unexpected payloads %q", got)
+	}
+}
+
+func TestChunkedTransferErrors(t *testing.T) {
+	payload := []byte("a payload larger than the chunks")
+	isProtocolError := func(err error, typeID int) bool {
+		var pe TProtocolException
+		return errors.As(err, &pe) && pe.TypeId() == typeID
+	}
+
+	a := NewTChunkAssembler(ChunkAssemblerOptions{})
+	for _, invalid := range [][]byte{nil, []byte("TCHK"), bytes.Repeat([]byte{1}, chunkHeaderSize)} {
+		if _, err := a.Add(invalid); !isProtocolError(err, INVALID_DATA) {
+			t.Errorf("expected INVALID_DATA for %q, got %v", invalid, err)
+		}
+	}
+
+	// A missing chunk fails the transfer.
+	chunks := chunksOf(t, payload, 10)
+	if _, err := a.Add(chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Add(chunks[2]); !isProtocolError(err, INVALID_DATA) {
+		t.Errorf("expected INVALID_DATA for a missing chunk, got %v", err)
+	}
+	if _, err := a.Add(chunks[1]); !isProtocolError(err, INVALID_DATA) {
+		t.Errorf("expected the failed transfer to be forgotten, got %v", err)
+	}
+
+	a = NewTChunkAssembler(ChunkAssemblerOptions{MaxSize: 15})
+	a.Add(chunks[0])
+	if _, err := a.Add(chunks[1]); !isProtocolError(err, SIZE_LIMIT) {
+		t.Errorf("expected SIZE_LIMIT, got %v", err)
+	}
+
+	a = NewTChunkAssembler(ChunkAssemblerOptions{MaxTransfers: 1, IdleTimeout: 10 * time.Millisecond})
+	other := chunksOf(t, payload, 10)
+	a.Add(chunks[0])
+	if _, err := a.Add(other[0]); err == nil {
+		t.Error("expected an error for too many transfers")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := a.Add(other[0]); err != nil {
+		t.Errorf("expected the idle transfer to be forgotten, got %v", err)
+	}
+	if _, err := a.Add(chunks[1]); !isProtocolError(err, INVALID_DATA) {
+		t.Errorf("expected the idle transfer to be forgotten, got %v", err)
+	}
+
+	// The errors of send are returned by the next calls.
+	sendErr := errors.New("send failed")
+	w := NewTChunkWriter(context.Background(), func(ctx context.Context, chunk []byte) error {
+		return sendErr
+	}, ChunkWriterOptions{ChunkSize: 10})
+	if n, err := w.Write(payload); !errors.Is(err, sendErr) || n != 10 {
+		t.Errorf("expected the error of send after 10 bytes, got %d, %v", n, err)
+	}
+	if err := w.Close(); !errors.Is(err, sendErr) {
+		t.Errorf("expected the error of send, got %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by the calls rejected by a CircuitBreaker.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of the circuit of an endpoint.
+type CircuitState int
+
+const (
+	// CircuitClosed lets the calls through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects the calls with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a few probe calls through, to decide whether
+	// to close the circuit again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// Default values of CircuitBreakerOptions.
+const (
+	DefaultCircuitWindow        = 10 * time.Second
+	DefaultCircuitMinCalls      = 20
+	DefaultCircuitErrorRate     = 0.5
+	DefaultCircuitOpenDuration  = 5 * time.Second
+	DefaultCircuitHalfOpenCalls = 1
+)
+
+// circuitBuckets is the number of buckets of the rolling window of a circuit.
+const circuitBuckets = 10
+
+// CircuitBreakerOptions configures NewCircuitBreaker.
+type CircuitBreakerOptions struct {
+	// Window is the duration of the rolling window of calls the error and
+	// slow call rates are computed on, DefaultCircuitWindow if 0.
+	Window time.Duration
+
+	// MinCalls is the minimum number of calls in the window for the circuit
+	// to open, DefaultCircuitMinCalls if 0.
+	MinCalls int
+
+	// ErrorRate is the rate of failed calls in the window, between 0 and
+	// 1, over which the circuit opens, DefaultCircuitErrorRate if 0.
+	ErrorRate float64
+
+	// SlowCallDuration is the latency over which a call is slow, and
+	// SlowCallRate the rate of slow calls in the window, between 0 and 1,
+	// over which the circuit opens. The latency is ignored if either is 0.
+	SlowCallDuration time.Duration
+	SlowCallRate     float64
+
+	// OpenDuration is how long the circuit stays open before letting probe
+	// calls through, DefaultCircuitOpenDuration if 0.
+	OpenDuration time.Duration
+
+	// HalfOpenCalls is the number of probe calls let through while
+	// half-open, which all need to succeed for the circuit to close again,
+	// DefaultCircuitHalfOpenCalls if 0.
+	HalfOpenCalls int
+
+	// IsFailure reports whether the error of a call is a failure of the
+	// endpoint.
+	//
+	// If nil, all the errors are failures except the cancellation of the
+	// context of the call.
+	IsFailure func(err error) bool
+
+	// OnStateChange is called when the circuit of an endpoint changes
+	// state, for example to record metrics. It's called with the
+	// CircuitBreaker locked, so it must not call its methods.
+	OnStateChange func(endpoint string, from, to CircuitState)
+
+	// OnReject is called for every call rejected because its circuit is
+	// not closed.
+	OnReject func(ctx context.Context, endpoint, method string)
+}
+
+// CircuitBreaker stops the calls to the failing endpoints for a while, so
+// that they can recover, and the callers fail fast instead of waiting for
+// them.
+//
+// Every endpoint has its own circuit, opening when the rate of failed or slow
+// calls in a rolling window exceeds its threshold. Once open for
+// OpenDuration, the circuit is half-open: a few probe calls are let through,
+// closing the circuit if they all succeed, or opening it again otherwise.
+type CircuitBreaker struct {
+	opts CircuitBreakerOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	circuits map[string]*tCircuit
+}
+
+// NewCircuitBreaker returns a CircuitBreaker configured with opts.
+func NewCircuitBreaker(opts CircuitBreakerOptions) *CircuitBreaker {
+	if opts.Window <= 0 {
+		opts.Window = DefaultCircuitWindow
+	}
+	if opts.MinCalls <= 0 {
+		opts.MinCalls = DefaultCircuitMinCalls
+	}
+	if opts.ErrorRate <= 0 {
+		opts.ErrorRate = DefaultCircuitErrorRate
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = DefaultCircuitOpenDuration
+	}
+	if opts.HalfOpenCalls <= 0 {
+		opts.HalfOpenCalls = DefaultCircuitHalfOpenCalls
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(err error) bool {
+			return !errors.Is(err, context.Canceled)
+		}
+	}
+	return &CircuitBreaker{
+		opts:     opts,
+		now:      time.Now,
+		circuits: make(map[string]*tCircuit),
+	}
+}
+
+// Middleware returns a ClientMiddleware sending the calls through the circuit
+// of endpoint, e.g. the address of the server of the wrapped TClient.
+//
+// The rejected calls fail with an error wrapping ErrCircuitOpen.
+func (b *CircuitBreaker) Middleware(endpoint string) ClientMiddleware {
+	return func(next TClient) TClient {
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				probe, ok := b.allow(endpoint)
+				if !ok {
+					if b.opts.OnReject != nil {
+						b.opts.OnReject(ctx, endpoint, method)
+					}
+					return ResponseMeta{}, fmt.Errorf("%s: %w", endpoint, ErrCircuitOpen)
+				}
+				start := b.now()
+				meta, err := next.Call(ctx, method, args, result)
+				b.record(endpoint, probe, err, b.now().Sub(start))
+				return meta, err
+			},
+		}
+	}
+}
+
+// State returns the state of the circuit of endpoint.
+func (b *CircuitBreaker) State(endpoint string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[endpoint]
+	if !ok {
+		return CircuitClosed
+	}
+	b.expireOpen(endpoint, c)
+	return c.state
+}
+
+// tCircuit is the circuit of an endpoint.
+type tCircuit struct {
+	state CircuitState
+
+	// The rolling window, while closed.
+	buckets [circuitBuckets]tCircuitBucket
+
+	// When the circuit opened, while open.
+	openedAt time.Time
+
+	// The probe calls started and succeeded, while half-open.
+	probes    int
+	successes int
+}
+
+type tCircuitBucket struct {
+	// index is the index of the bucket in time, since the zero time.
+	index    int64
+	calls    int
+	failures int
+	slow     int
+}
+
+func (b *CircuitBreaker) circuit(endpoint string) *tCircuit {
+	c, ok := b.circuits[endpoint]
+	if !ok {
+		c = &tCircuit{}
+		b.circuits[endpoint] = c
+	}
+	return c
+}
+
+// allow reports whether a call to endpoint can be sent, and whether it's a
+// probe call.
+func (b *CircuitBreaker) allow(endpoint string) (probe bool, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuit(endpoint)
+	b.expireOpen(endpoint, c)
+	switch c.state {
+	case CircuitOpen:
+		return false, false
+	case CircuitHalfOpen:
+		if c.probes >= b.opts.HalfOpenCalls {
+			return false, false
+		}
+		c.probes++
+		return true, true
+	default:
+		return false, true
+	}
+}
+
+// expireOpen makes the circuit half-open once it's been open for
+// OpenDuration. b.mu must be held.
+func (b *CircuitBreaker) expireOpen(endpoint string, c *tCircuit) {
+	if c.state == CircuitOpen && b.now().Sub(c.openedAt) >= b.opts.OpenDuration {
+		c.probes = 0
+		c.successes = 0
+		b.setState(endpoint, c, CircuitHalfOpen)
+	}
+}
+
+func (b *CircuitBreaker) record(endpoint string, probe bool, err error, latency time.Duration) {
+	failed := err != nil && b.opts.IsFailure(err)
+	slow := b.opts.SlowCallDuration > 0 && b.opts.SlowCallRate > 0 && latency > b.opts.SlowCallDuration
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuit(endpoint)
+	if probe {
+		if c.state != CircuitHalfOpen {
+			return
+		}
+		if failed || slow {
+			b.open(endpoint, c)
+			return
+		}
+		c.successes++
+		if c.successes >= b.opts.HalfOpenCalls {
+			c.buckets = [circuitBuckets]tCircuitBucket{}
+			b.setState(endpoint, c, CircuitClosed)
+		}
+		return
+	}
+	if c.state != CircuitClosed {
+		return
+	}
+
+	bucketDuration := b.opts.Window / circuitBuckets
+	index := b.now().UnixNano() / int64(bucketDuration)
+	bucket := &c.buckets[index%circuitBuckets]
+	if bucket.index != index {
+		*bucket = tCircuitBucket{index: index}
+	}
+	bucket.calls++
+	if failed {
+		bucket.failures++
+	}
+	if slow {
+		bucket.slow++
+	}
+
+	var calls, failures, slowCalls int
+	for _, bucket := range c.buckets {
+		if index-bucket.index < circuitBuckets {
+			calls += bucket.calls
+			failures += bucket.failures
+			slowCalls += bucket.slow
+		}
+	}
+	if calls < b.opts.MinCalls {
+		return
+	}
+	if float64(failures) >= b.opts.ErrorRate*float64(calls) ||
+		(b.opts.SlowCallRate > 0 && float64(slowCalls) >= b.opts.SlowCallRate*float64(calls)) {
+		b.open(endpoint, c)
+	}
+}
+
+// open opens the circuit. b.mu must be held.
+func (b *CircuitBreaker) open(endpoint string, c *tCircuit) {
+	c.openedAt = b.now()
+	b.setState(endpoint, c, CircuitOpen)
+}
+
+// setState sets the state of the circuit. b.mu must be held.
+func (b *CircuitBreaker) setState(endpoint string, c *tCircuit, state CircuitState) {
+	from := c.state
+	c.state = state
+	if from != state && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(endpoint, from, state)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var transitions []string
+	var rejected int
+	b := NewCircuitBreaker(CircuitBreakerOptions{
+		Window:        time.Second,
+		MinCalls:      4,
+		ErrorRate:     0.5,
+		OpenDuration:  time.Second,
+		HalfOpenCalls: 2,
+		OnStateChange: func(endpoint string, from, to CircuitState) {
+			transitions = append(transitions, endpoint+":"+from.String()+"->"+to.String())
+		},
+		OnReject: func(ctx context.Context, endpoint, method string) {
+			rejected++
+		},
+	})
+	b.now = func() time.Time {
+		return now
+	}
+	failing := errors.New("unavailable")
+	var fail bool
+	var calls int
+	next := WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			calls++
+			if fail {
+				return ResponseMeta{}, failing
+			}
+			return ResponseMeta{}, nil
+		},
+	}
+	client := b.Middleware("a")(next)
+	other := b.Middleware("b")(next)
+	call := func(client TClient) error {
+		_, err := client.Call(context.Background(), "m", nil, nil)
+		return err
+	}
+
+	// 2 failures out of 3 calls, under MinCalls.
+	call(client)
+	fail = true
+	call(client)
+	call(client)
+	if state := b.State("a"); state != CircuitClosed {
+		t.Fatalf("expected the circuit to stay closed under MinCalls, got %v", state)
+	}
+	call(client)
+	if state := b.State("a"); state != CircuitOpen {
+		t.Fatalf("expected the circuit to open, got %v", state)
+	}
+	calls = 0
+	if err := call(client); !errors.Is(err, ErrCircuitOpen) || calls != 0 {
+		t.Errorf("expected the call to be rejected, got %v after %d calls", err, calls)
+	}
+	fail = false
+	if err := call(other); err != nil {
+		t.Errorf("expected the other endpoint to be unaffected, got %v", err)
+	}
+
+	// Half-open, a failed probe opens the circuit again.
+	now = now.Add(time.Second)
+	fail = true
+	if err := call(client); !errors.Is(err, failing) {
+		t.Errorf("expected the probe call to be sent, got %v", err)
+	}
+	if state := b.State("a"); state != CircuitOpen {
+		t.Fatalf("expected the failed probe to open the circuit, got %v", state)
+	}
+
+	// Half-open, the circuit closes once HalfOpenCalls probes succeed.
+	now = now.Add(time.Second)
+	fail = false
+	call(client)
+	if state := b.State("a"); state != CircuitHalfOpen {
+		t.Fatalf("expected the circuit to stay half-open, got %v", state)
+	}
+	call(client)
+	if state := b.State("a"); state != CircuitClosed {
+		t.Fatalf("expected the circuit to close, got %v", state)
+	}
+
+	expected := []string{
+		"a:closed->open",
+		"a:open->half-open",
+		"a:half-open->open",
+		"a:open->half-open",
+		"a:half-open->closed",
+	}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("expected transitions %v, got %v", expected, transitions)
+			break
+		}
+	}
+	if rejected != 1 {
+		t.Errorf("expected 1 rejected call, got %d", rejected)
+	}
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewCircuitBreaker(CircuitBreakerOptions{
+		Window:           time.Second,
+		MinCalls:         2,
+		ErrorRate:        0.5,
+		SlowCallDuration: 100 * time.Millisecond,
+		SlowCallRate:     0.5,
+	})
+	b.now = func() time.Time {
+		return now
+	}
+	var latency time.Duration
+	client := b.Middleware("a")(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			now = now.Add(latency)
+			return ResponseMeta{}, context.Canceled
+		},
+	})
+	call := func() {
+		client.Call(context.Background(), "m", nil, nil)
+	}
+
+	// Canceled calls are not failures.
+	call()
+	call()
+	if state := b.State("a"); state != CircuitClosed {
+		t.Fatalf("expected canceled calls not to open the circuit, got %v", state)
+	}
+
+	// A slow call, then another one once the first left the window.
+	latency = 200 * time.Millisecond
+	call()
+	now = now.Add(2 * time.Second)
+	call()
+	if state := b.State("a"); state != CircuitClosed {
+		t.Fatalf("expected the old calls to leave the window, got %v", state)
+	}
+	call()
+	if state := b.State("a"); state != CircuitOpen {
+		t.Fatalf("expected slow calls to open the circuit, got %v", state)
+	}
+}
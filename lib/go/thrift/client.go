@@ -3,6 +3,7 @@ package thrift
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
 // ResponseMeta represents the metadata attached to the response.
@@ -19,10 +20,16 @@ type TClient interface {
 type TStandardClient struct {
 	seqId        int32
 	iprot, oprot TProtocol
+	stats        TStatsHandler
+	callStats    func(ctx context.Context, stats TClientCallStats)
+	interceptor  TPayloadInterceptor
+	timeouts     TClientTimeouts
+	seqIDPolicy  TSeqIDMismatchPolicy
 }
 
 // TStandardClient implements TClient, and uses the standard message format for Thrift.
-// It is not safe for concurrent use.
+// It is not safe for concurrent use, wrap it with NewTSynchronizedClient to
+// share it, or use a TPipelinedClient instead.
 func NewTStandardClient(inputProtocol, outputProtocol TProtocol) *TStandardClient {
 	return &TStandardClient{
 		iprot: inputProtocol,
@@ -30,7 +37,64 @@ func NewTStandardClient(inputProtocol, outputProtocol TProtocol) *TStandardClien
 	}
 }
 
+// SetStatsHandler sets the TStatsHandler notified of the stats of the calls.
+//
+// The payload sizes are only reported when the transports of the protocols
+// of the client are wrapped with NewTByteCountingTransport.
+func (p *TStandardClient) SetStatsHandler(handler TStatsHandler) {
+	p.stats = handler
+}
+
+// SetCallStatsFunc sets a function called with the TClientCallStats of every
+// call once it completed, to see where the latency of the calls goes.
+//
+// The byte counts are only reported when the transports of the protocols of
+// the client are wrapped with NewTByteCountingTransport.
+func (p *TStandardClient) SetCallStatsFunc(f func(ctx context.Context, stats TClientCallStats)) {
+	p.callStats = f
+}
+
+// SetTimeouts sets the timeouts of the phases of the calls, in addition to the
+// deadline of their context.
+//
+// Whether timeouts are set or not, the deadline of the context of a call is
+// set as the deadline of the reads and writes of the socket of the client,
+// when its transports wrap a TSocket or a TSSLSocket, so that a call fails
+// once its context is done even if the SocketTimeout is longer.
+func (p *TStandardClient) SetTimeouts(timeouts TClientTimeouts) {
+	p.timeouts = timeouts
+}
+
+// SetSeqIDMismatchPolicy sets how the replies with an unexpected seqid are
+// handled, SeqIDMismatchFail by default.
+func (p *TStandardClient) SetSeqIDMismatchPolicy(policy TSeqIDMismatchPolicy) {
+	p.seqIDPolicy = policy
+}
+
+// skipsSeqIDMismatch reports whether the replies with an unexpected seqid are
+// discarded, TStandardClient having a single call waiting for its reply.
+func (p *TStandardClient) skipsSeqIDMismatch() bool {
+	return p.seqIDPolicy == SeqIDMismatchSkip || p.seqIDPolicy == SeqIDMismatchDeliver
+}
+
 func (p *TStandardClient) Send(ctx context.Context, oprot TProtocol, seqId int32, method string, args TStruct) error {
+	return writeCall(ctx, oprot, seqId, method, args)
+}
+
+// writeCall writes and flushes the CALL message of method to oprot.
+func writeCall(ctx context.Context, oprot TProtocol, seqId int32, method string, args TStruct) error {
+	if err := writeCallMessage(ctx, oprot, seqId, method, args); err != nil {
+		return err
+	}
+	return oprot.Flush(ctx)
+}
+
+// writeCallMessage is writeCall without the flush. The method is prefixed
+// with the service of the calls of ctx, if any, see Channel.Client.
+func writeCallMessage(ctx context.Context, oprot TProtocol, seqId int32, method string, args TStruct) error {
+	if service := multiplexedService(ctx); service != "" {
+		method = service + MULTIPLEXED_SEPARATOR + method
+	}
 	// Set headers from context object on THeaderProtocol
 	if headerProt, ok := oprot.(*THeaderProtocol); ok {
 		headerProt.ClearWriteHeaders()
@@ -47,18 +111,38 @@ func (p *TStandardClient) Send(ctx context.Context, oprot TProtocol, seqId int32
 	if err := args.Write(ctx, oprot); err != nil {
 		return err
 	}
-	if err := oprot.WriteMessageEnd(ctx); err != nil {
-		return err
-	}
-	return oprot.Flush(ctx)
+	return oprot.WriteMessageEnd(ctx)
 }
 
 func (p *TStandardClient) Recv(ctx context.Context, iprot TProtocol, seqId int32, method string, result TStruct) error {
+	return p.recvReply(ctx, iprot, seqId, method, result, nil)
+}
+
+// recvReply is Recv, calling received, if not nil, once the message begin of
+// the reply is read.
+func (p *TStandardClient) recvReply(ctx context.Context, iprot TProtocol, seqId int32, method string, result TStruct, received func()) error {
 	rMethod, rTypeId, rSeqId, err := iprot.ReadMessageBegin(ctx)
 	if err != nil {
 		return err
 	}
+	for rSeqId != seqId && p.skipsSeqIDMismatch() {
+		if err := iprot.Skip(ctx, STRUCT); err != nil {
+			return err
+		}
+		if err := iprot.ReadMessageEnd(ctx); err != nil {
+			return err
+		}
+		if rMethod, rTypeId, rSeqId, err = iprot.ReadMessageBegin(ctx); err != nil {
+			return err
+		}
+	}
 
+	if received != nil {
+		received()
+	}
+	if seqId != rSeqId && p.seqIDPolicy == SeqIDMismatchClose {
+		return closeOnSeqIDMismatch(iprot, method, seqId, rSeqId)
+	}
 	if method != rMethod {
 		return NewTApplicationException(WRONG_METHOD_NAME, fmt.Sprintf("%s: wrong method name", method))
 	} else if seqId != rSeqId {
@@ -68,6 +152,7 @@ func (p *TStandardClient) Recv(ctx context.Context, iprot TProtocol, seqId int32
 		if err := exception.Read(ctx, iprot); err != nil {
 			return err
 		}
+		exception.readExceptionReason(p.responseMeta().Headers)
 
 		if err := iprot.ReadMessageEnd(ctx); err != nil {
 			return err
@@ -89,7 +174,19 @@ func (p *TStandardClient) Call(ctx context.Context, method string, args, result
 	p.seqId++
 	seqId := p.seqId
 
-	if err := p.Send(ctx, p.oprot, seqId, method, args); err != nil {
+	var meta ResponseMeta
+	var err error
+	if p.stats != nil || p.callStats != nil {
+		meta, err = p.callWithStats(ctx, seqId, method, args, result)
+	} else {
+		meta, err = p.call(ctx, seqId, method, args, result)
+	}
+	recordIncomingHeaders(ctx, meta.Headers)
+	return meta, err
+}
+
+func (p *TStandardClient) call(ctx context.Context, seqId int32, method string, args, result TStruct) (ResponseMeta, error) {
+	if err := p.send(ctx, seqId, method, args, nil); err != nil {
 		return ResponseMeta{}, err
 	}
 
@@ -98,12 +195,176 @@ func (p *TStandardClient) Call(ctx context.Context, method string, args, result
 		return ResponseMeta{}, nil
 	}
 
-	err := p.Recv(ctx, p.iprot, seqId, method, result)
+	err := p.recv(ctx, seqId, method, result, nil)
+	return p.responseMeta(), err
+}
+
+// send is Send, opening the socket of the client first if it's not open and
+// OpenClosed is set, with the deadlines of the dial and write phases. The
+// durations of the phases are recorded in stats if not nil.
+func (p *TStandardClient) send(ctx context.Context, seqId int32, method string, args TStruct, stats *TClientCallStats) error {
+	begin := time.Now()
+	if p.timeouts.OpenClosed {
+		dialCtx, cancel := withPhaseTimeout(ctx, p.timeouts.Dial)
+		err := openSocket(dialCtx, p.oprot.Transport())
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	ctx, cancel := withPhaseTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+	defer pushPhaseDeadline(ctx, p.oprot.Transport(), false)()
+	if stats == nil && p.interceptor == nil {
+		return p.Send(ctx, p.oprot, seqId, method, args)
+	}
+	written := time.Now()
+	var err error
+	if p.interceptor != nil {
+		err = p.writeInterceptedCall(ctx, seqId, method, args)
+	} else {
+		err = writeCallMessage(ctx, p.oprot, seqId, method, args)
+	}
+	flushed := time.Now()
+	if err == nil {
+		err = p.oprot.Flush(ctx)
+	}
+	if stats != nil {
+		stats.DialTime = written.Sub(begin)
+		stats.SerializeTime = flushed.Sub(written)
+		stats.SendTime = time.Since(flushed)
+	}
+	return err
+}
+
+// recv is Recv, with the deadline of the read phase. The durations of the
+// phases are recorded in stats if not nil.
+func (p *TStandardClient) recv(ctx context.Context, seqId int32, method string, result TStruct, stats *TClientCallStats) error {
+	ctx, cancel := withPhaseTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+	defer pushPhaseDeadline(ctx, p.iprot.Transport(), true)()
+	if stats == nil && p.interceptor == nil {
+		return p.Recv(ctx, p.iprot, seqId, method, result)
+	}
+	begin := time.Now()
+	iprot := p.iprot
+	if p.interceptor != nil {
+		var err error
+		if iprot, err = p.readInterceptedReply(ctx, seqId, method); err != nil {
+			if stats != nil {
+				stats.WaitTime = time.Since(begin)
+			}
+			return err
+		}
+	}
+	if stats == nil {
+		return p.recvReply(ctx, iprot, seqId, method, result, nil)
+	}
+	var received time.Time
+	err := p.recvReply(ctx, iprot, seqId, method, result, func() {
+		received = time.Now()
+	})
+	if received.IsZero() {
+		// The reply was never received.
+		stats.WaitTime = time.Since(begin)
+	} else {
+		stats.WaitTime = received.Sub(begin)
+		stats.DeserializeTime = time.Since(received)
+	}
+	return err
+}
+
+// callWithStats is call, reporting the stats of the call to p.stats and
+// p.callStats.
+func (p *TStandardClient) callWithStats(ctx context.Context, seqId int32, method string, args, result TStruct) (ResponseMeta, error) {
+	if p.stats != nil {
+		ctx = p.stats.TagRequest(ctx, TStatsRequestInfo{
+			Client: true,
+			Method: method,
+			SeqID:  seqId,
+		})
+	}
+	begin := time.Now()
+	p.handleStats(ctx, &TStatsBegin{Client: true, BeginTime: begin})
+	var callStats *TClientCallStats
+	if p.callStats != nil {
+		callStats = &TClientCallStats{
+			Method:  method,
+			SeqID:   seqId,
+			Attempt: CallAttemptFromContext(ctx),
+			Oneway:  result == nil,
+		}
+	}
+	end := func(err error) {
+		now := time.Now()
+		p.handleStats(ctx, &TStatsEnd{
+			Client:    true,
+			BeginTime: begin,
+			EndTime:   now,
+			Err:       err,
+		})
+		if callStats != nil {
+			callStats.Duration = now.Sub(begin)
+			callStats.Err = err
+			p.callStats(ctx, *callStats)
+		}
+	}
+	// Not counting what was left by a previous failed call.
+	beginCountedRequest(p.oprot.Transport(), p.iprot.Transport())
+
+	err := p.send(ctx, seqId, method, args, callStats)
+	sent := countedBytes(p.oprot.Transport(), false)
+	if callStats != nil {
+		callStats.BytesSent = sent
+	}
+	if err != nil {
+		end(err)
+		return ResponseMeta{}, err
+	}
+	p.handleStats(ctx, &TStatsOutPayload{
+		Client:   true,
+		Length:   sent,
+		SentTime: time.Now(),
+	})
+
+	// method is oneway
+	if result == nil {
+		end(nil)
+		return ResponseMeta{}, nil
+	}
+
+	err = p.recv(ctx, seqId, method, result, callStats)
+	received := countedBytes(p.iprot.Transport(), true)
+	if callStats != nil {
+		callStats.BytesReceived = received
+	}
+	p.handleStats(ctx, &TStatsInPayload{
+		Client:   true,
+		Length:   received,
+		RecvTime: time.Now(),
+	})
+	end(err)
+	return p.responseMeta(), err
+}
+
+// handleStats reports stats to p.stats, if set.
+func (p *TStandardClient) handleStats(ctx context.Context, stats TStats) {
+	if p.stats != nil {
+		p.stats.HandleStats(ctx, stats)
+	}
+}
+
+func (p *TStandardClient) responseMeta() ResponseMeta {
+	return readResponseMeta(p.iprot)
+}
+
+// readResponseMeta returns the metadata of the last response read from iprot.
+func readResponseMeta(iprot TProtocol) ResponseMeta {
 	var headers THeaderMap
-	if hp, ok := p.iprot.(*THeaderProtocol); ok {
+	if hp, ok := iprot.(*THeaderProtocol); ok {
 		headers = hp.transport.readHeaders
 	}
 	return ResponseMeta{
 		Headers: headers,
-	}, err
+	}
 }
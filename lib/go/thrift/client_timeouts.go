@@ -0,0 +1,136 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"time"
+)
+
+// TClientTimeouts are the timeouts of the phases of the calls of a
+// TStandardClient, see SetTimeouts. Zero means no timeout.
+//
+// Each phase is bounded by both its timeout and the deadline of the context
+// of the call, whichever comes first, and fails with a timeout
+// TTransportException once it's exceeded.
+type TClientTimeouts struct {
+	// Dial bounds the opening of the socket of the client by Call, with
+	// OpenClosed.
+	Dial time.Duration
+
+	// OpenClosed makes Call open the socket of the client if it's not open,
+	// e.g. once closed by the SeqIDMismatchClose policy. Otherwise the calls
+	// on a closed client fail with a NOT_OPEN TTransportException.
+	OpenClosed bool
+
+	// Write bounds the writing of the request.
+	Write time.Duration
+
+	// Read bounds the wait for the reply and its reading.
+	Read time.Duration
+}
+
+// tDeadlineSocket is implemented by the sockets whose opening, reads and
+// writes can be bounded by a deadline, in addition to their ConnectTimeout
+// and SocketTimeout.
+type tDeadlineSocket interface {
+	TTransport
+
+	openWithDeadline(deadline time.Time) error
+
+	// setReadDeadline and setWriteDeadline set the deadline of the
+	// following reads or writes, zero to clear it.
+	setReadDeadline(t time.Time)
+	setWriteDeadline(t time.Time)
+}
+
+// deadlineSocket returns the tDeadlineSocket trans reads from and writes to,
+// nil if there is none.
+func deadlineSocket(trans TTransport) tDeadlineSocket {
+	for {
+		switch t := trans.(type) {
+		case tDeadlineSocket:
+			return t
+		case *THeaderTransport:
+			trans = t.transport
+		case *TFramedTransport:
+			trans = t.transport
+		case *TBufferedTransport:
+			trans = t.tp
+		case *TZlibTransport:
+			trans = t.transport
+		case *tByteCountingTransport:
+			trans = t.TTransport
+		default:
+			return nil
+		}
+	}
+}
+
+// earliestDeadline returns the earliest of the non-zero deadlines a and b.
+func earliestDeadline(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// withPhaseTimeout returns a copy of ctx bounded by timeout, if not zero, and
+// the CancelFunc releasing it.
+func withPhaseTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// openSocket opens the socket of trans before the deadline of ctx, if it's not
+// open yet.
+func openSocket(ctx context.Context, trans TTransport) error {
+	sock := deadlineSocket(trans)
+	if sock == nil || sock.IsOpen() {
+		return nil
+	}
+	deadline, _ := ctx.Deadline()
+	return sock.openWithDeadline(deadline)
+}
+
+// pushPhaseDeadline sets the deadline of ctx as the read (or write) deadline
+// of the socket of trans, and returns the function clearing it.
+func pushPhaseDeadline(ctx context.Context, trans TTransport, read bool) func() {
+	deadline, ok := ctx.Deadline()
+	sock := deadlineSocket(trans)
+	if !ok || sock == nil {
+		return func() {}
+	}
+	set := sock.setWriteDeadline
+	if read {
+		set = sock.setReadDeadline
+	}
+	set(deadline)
+	return func() {
+		set(time.Time{})
+	}
+}
+
+var (
+	_ tDeadlineSocket = (*TSocket)(nil)
+	_ tDeadlineSocket = (*TSSLSocket)(nil)
+)
@@ -0,0 +1,150 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStandardClientPhaseDeadlines(t *testing.T) {
+	release := make(chan struct{})
+	processor := &mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			ctx := context.Background()
+			name, _, seqID, err := in.ReadMessageBegin(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			value, err := readStringArgs(ctx, in)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			if value == "slow" {
+				<-release
+			}
+			out.WriteMessageBegin(ctx, name, REPLY, seqID)
+			writeStringArgs(ctx, out, value)
+			return true, WrapTException(out.Flush(ctx))
+		},
+	}
+	serv, addr := startTestSocketServer(t, processor, nil)
+	t.Cleanup(func() {
+		close(release)
+		serv.Stop()
+	})
+
+	// The sockets are opened, unless OpenClosed makes Call open them.
+	newClient := func(t *testing.T, timeouts TClientTimeouts) *TStandardClient {
+		t.Helper()
+		sock, err := NewTSocketConf(addr, &TConfiguration{SocketTimeout: 5 * time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !timeouts.OpenClosed {
+			if err := sock.Open(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		t.Cleanup(func() {
+			sock.Close()
+		})
+		proto := NewTBinaryProtocolConf(sock, nil)
+		client := NewTStandardClient(proto, proto)
+		client.SetTimeouts(timeouts)
+		return client
+	}
+	call := func(ctx context.Context, client *TStandardClient, value string) error {
+		var result pipelinedString
+		if _, err := client.Call(ctx, "echo", &pipelinedString{value}, &result); err != nil {
+			return err
+		}
+		if result.Value != value {
+			t.Errorf("expected %q, got %q", value, result.Value)
+		}
+		return nil
+	}
+	expectTimeout := func(t *testing.T, begin time.Time, err error) {
+		t.Helper()
+		if !isTimeoutError(err) {
+			t.Errorf("expected a timeout, got %v", err)
+		}
+		if elapsed := time.Since(begin); elapsed > 2*time.Second {
+			t.Errorf("expected the call to fail before the socket timeout, took %v", elapsed)
+		}
+	}
+
+	t.Run("context deadline", func(t *testing.T) {
+		client := newClient(t, TClientTimeouts{})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		begin := time.Now()
+		expectTimeout(t, begin, call(ctx, client, "slow"))
+	})
+
+	t.Run("read timeout", func(t *testing.T) {
+		client := newClient(t, TClientTimeouts{Read: 100 * time.Millisecond})
+		begin := time.Now()
+		expectTimeout(t, begin, call(context.Background(), client, "slow"))
+	})
+
+	t.Run("deadlines cleared", func(t *testing.T) {
+		client := newClient(t, TClientTimeouts{})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := call(ctx, client, "fast"); err != nil {
+			t.Fatal(err)
+		}
+		<-ctx.Done()
+		if err := call(context.Background(), client, "fast"); err != nil {
+			t.Errorf("expected the deadline of the previous call to be cleared, got %v", err)
+		}
+	})
+
+	t.Run("open closed", func(t *testing.T) {
+		client := newClient(t, TClientTimeouts{OpenClosed: true})
+		if err := call(context.Background(), client, "fast"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		client := newClient(t, TClientTimeouts{})
+		client.oprot.Transport().Close()
+		err := call(context.Background(), client, "fast")
+		var te TTransportException
+		if !errors.As(err, &te) || te.TypeId() != NOT_OPEN {
+			t.Errorf("expected NOT_OPEN, got %v", err)
+		}
+	})
+
+	t.Run("dial deadline", func(t *testing.T) {
+		client := newClient(t, TClientTimeouts{OpenClosed: true})
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		err := call(ctx, client, "fast")
+		var te TTransportException
+		if !errors.As(err, &te) || te.TypeId() != NOT_OPEN {
+			t.Errorf("expected NOT_OPEN, got %v", err)
+		}
+	})
+}
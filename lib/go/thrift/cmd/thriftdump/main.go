@@ -0,0 +1,613 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Command thriftdump prints thrift payloads, e.g. captured from the network,
+// as annotated JSON:
+//
+//	thriftdump [flags] [file]
+//
+// It reads the payload from file, or from the standard input if there's none
+// or it's "-", as raw bytes or, with -hex, as hexadecimal. The payload is a
+// sequence of messages, whose protocol and framing are detected like
+// THeaderTransport does: unframed or framed binary and compact, and THeader.
+// -protocol and -framed set them instead, e.g. for the JSON protocol, and
+// -type, with -protocol, decodes a sequence of structs of this type, without
+// the message envelope, as written by TSerializer.
+//
+// Every message is printed as a JSON object with its method, type, sequence
+// id, THeader headers, and body. The fields of the structs are keyed by their
+// id and type, and with -idl by their name, the IDL then resolving the
+// arguments and results of the functions, and the names of the enum values:
+//
+//	thriftdump -hex -idl user.thrift -service UserService capture.hex
+//
+// With -pcap, the input is a network capture in the pcap or pcapng format,
+// e.g. written by tcpdump, whose TCP connections are reassembled to decode
+// their messages, printed with their time and addresses. -port decodes the
+// connections of the thrift servers only, and the streams which couldn't be
+// decoded until their end are reported after the messages:
+//
+//	tcpdump -i eth0 -w capture.pcap port 9090
+//	thriftdump -pcap -port 9090 -idl user.thrift capture.pcap
+//
+// The binaries and the strings which aren't valid UTF-8 are printed in
+// hexadecimal, prefixed with 0x.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/apache/thrift/lib/go/thrift/idl"
+	"github.com/apache/thrift/lib/go/thrift/pcap"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "thriftdump:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("thriftdump", flag.ContinueOnError)
+	isHex := flags.Bool("hex", false, "read the payload as hexadecimal")
+	protocol := flags.String("protocol", "auto", "protocol of the payload: auto, binary, compact or json")
+	framed := flags.Bool("framed", false, "the messages are framed, with -protocol")
+	idlFile := flags.String("idl", "", "IDL `file` resolving the names of the fields and enum values")
+	includes := flags.String("I", "", "comma-separated `directories` of the files included by the IDL")
+	service := flags.String("service", "", "service of the messages in the IDL, by default the first defining their method")
+	typeName := flags.String("type", "", "struct `type` of the IDL of the payload, which has no message envelope")
+	isPcap := flags.Bool("pcap", false, "read the payload as a pcap or pcapng capture")
+	ports := flags.String("port", "", "comma-separated TCP `ports` of the servers of the connections decoded, with -pcap")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errors.New("too many arguments")
+	}
+
+	d := dumper{
+		protocol: *protocol,
+		framed:   *framed,
+		typeName: *typeName,
+	}
+	switch d.protocol {
+	case "auto":
+		if d.framed || d.typeName != "" {
+			return errors.New("-framed and -type require -protocol")
+		}
+		if *isHex && *isPcap {
+			return errors.New("-hex and -pcap are exclusive")
+		}
+	case "binary", "compact", "json":
+	default:
+		return fmt.Errorf("unknown protocol %q", d.protocol)
+	}
+	if d.protocol != "auto" && *isPcap {
+		return errors.New("-protocol and -pcap are exclusive")
+	}
+	var opts pcap.Options
+	if *ports != "" {
+		if !*isPcap {
+			return errors.New("-port requires -pcap")
+		}
+		for _, port := range strings.Split(*ports, ",") {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				return fmt.Errorf("invalid port %q", port)
+			}
+			opts.Ports = append(opts.Ports, p)
+		}
+	}
+	if *idlFile != "" {
+		var dirs []string
+		if *includes != "" {
+			dirs = strings.Split(*includes, ",")
+		}
+		doc, err := idl.ParseFile(*idlFile, dirs...)
+		if err != nil {
+			return err
+		}
+		d.doc, d.service = doc, *service
+	} else if *service != "" || *typeName != "" {
+		return errors.New("-service and -type require -idl")
+	}
+
+	in := stdin
+	if name := flags.Arg(0); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if *isPcap {
+		return d.dumpCapture(in, &opts, stdout)
+	}
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	if *isHex {
+		if data, err = decodeHex(data); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	enc := newEncoder(stdout)
+	for offset := 0; offset < len(data); {
+		n, out, err := d.read(ctx, data[offset:])
+		if err != nil {
+			return fmt.Errorf("offset %d: %w", offset, err)
+		}
+		if err := enc.Encode(append(object{{"offset", offset}}, out...)); err != nil {
+			return err
+		}
+		offset += n
+	}
+	return nil
+}
+
+func newEncoder(w io.Writer) *json.Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc
+}
+
+// dumpCapture prints the messages of the capture of in, then the errors of
+// its streams.
+func (d *dumper) dumpCapture(in io.Reader, opts *pcap.Options, stdout io.Writer) error {
+	capture, err := pcap.Read(in, opts)
+	if err != nil {
+		return err
+	}
+	enc := newEncoder(stdout)
+	for _, m := range capture.Messages {
+		out := object{
+			{"time", m.Time.UTC().Format(time.RFC3339Nano)},
+			{"src", m.Src},
+			{"dst", m.Dst},
+			{"transport", m.Transport},
+			{"protocol", m.Protocol},
+		}
+		if len(m.Headers) > 0 {
+			out = append(out, member{"headers", m.Headers})
+		}
+		s, doc := d.doc.MessageStruct(d.service, m.Method, m.Type)
+		out = append(out,
+			member{"method", m.Method},
+			member{"type", messageTypeName(m.Type)},
+			member{"seqid", m.SeqID},
+			member{"body", annotateStruct(doc, s, m.Body)},
+		)
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	for _, e := range capture.Errors {
+		if err := enc.Encode(object{
+			{"src", e.Src},
+			{"dst", e.Dst},
+			{"offset", e.Offset},
+			{"error", e.Err.Error()},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeHex decodes data, ignoring the spaces, the colons and a 0x prefix.
+func decodeHex(data []byte) ([]byte, error) {
+	s := strings.Map(func(r rune) rune {
+		if r == ':' || strings.ContainsRune(" \t\r\n", r) {
+			return -1
+		}
+		return r
+	}, string(data))
+	return hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+}
+
+// dumper reads the messages, or the structs of typeName, annotating them
+// with the IDL of doc, if any.
+type dumper struct {
+	protocol string
+	framed   bool
+	typeName string
+
+	doc     *idl.Document
+	service string
+}
+
+// read reads the message at the beginning of data, returning its length.
+//
+// Every message is read with its own protocol, from a buffer of its bytes
+// only, as the transports and protocols buffering their reads, like
+// THeaderTransport, don't tell where the messages end.
+func (d *dumper) read(ctx context.Context, data []byte) (int, object, error) {
+	var out object
+	protocol, framed := d.protocol, d.framed
+	if protocol == "auto" {
+		transport := transportOf(data)
+		out = append(out, member{"transport", transport})
+		framed = transport != "unframed"
+		if transport == "header" {
+			protocol = "header"
+		}
+	}
+
+	msg := data
+	if framed {
+		if len(data) < 4 {
+			return 0, nil, errors.New("truncated frame size")
+		}
+		size := binary.BigEndian.Uint32(data)
+		if uint64(size) > uint64(len(data)-4) {
+			return 0, nil, fmt.Errorf("truncated frame of %d bytes", size)
+		}
+		msg = data[:4+size]
+		if protocol != "header" {
+			// The THeaderTransport reads the frame size itself.
+			msg = msg[4:]
+		}
+	}
+	if protocol == "auto" {
+		switch {
+		case len(msg) >= 2 && msg[0] == 0x80 && msg[1] == 0x01:
+			protocol = "binary"
+		case len(msg) >= 1 && msg[0] == 0x82:
+			protocol = "compact"
+		default:
+			return 0, nil, errors.New("unknown protocol")
+		}
+		out = append(out, member{"protocol", protocol})
+	}
+	if protocol == "json" && !framed {
+		// The JSON protocol buffers its reads, the message ends with its
+		// JSON value.
+		dec := json.NewDecoder(bytes.NewReader(msg))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return 0, nil, err
+		}
+		msg = msg[:dec.InputOffset()]
+	}
+
+	buf := thrift.NewTMemoryBuffer()
+	buf.Write(msg)
+	var proto thrift.TProtocol
+	switch protocol {
+	case "header":
+		proto = thrift.NewTHeaderProtocolConf(buf, nil)
+	case "binary":
+		proto = thrift.NewTBinaryProtocolConf(buf, nil)
+	case "compact":
+		proto = thrift.NewTCompactProtocolConf(buf, nil)
+	case "json":
+		proto = thrift.NewTJSONProtocol(buf)
+	}
+	var body object
+	var err error
+	if d.typeName != "" {
+		body, err = d.readStruct(ctx, proto)
+	} else {
+		body, err = d.readMessage(ctx, proto)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if hp, ok := proto.(*thrift.THeaderProtocol); ok {
+		if trans, ok := hp.Transport().(*thrift.THeaderTransport); ok {
+			out = append(out, member{"protocol", protocolName(trans.Protocol())})
+		}
+		if headers := hp.GetReadHeaders(); len(headers) > 0 {
+			out = append(out, member{"headers", headers})
+		}
+	}
+	var n int
+	switch {
+	case framed:
+		n = len(msg)
+		if protocol != "header" {
+			n += 4
+		}
+	case protocol == "json":
+		// The whitespace between the JSON values is skipped.
+		rest := data[len(msg):]
+		n = len(msg) + len(rest) - len(bytes.TrimLeft(rest, " \t\r\n"))
+	default:
+		n = len(msg) - buf.Len()
+	}
+	return n, append(out, body...), nil
+}
+
+func (d *dumper) readStruct(ctx context.Context, in thrift.TProtocol) (object, error) {
+	r, err := d.doc.Resolve(&idl.Type{Name: d.typeName})
+	if err != nil || r.Struct == nil {
+		return nil, fmt.Errorf("unknown struct %s", d.typeName)
+	}
+	v, err := thrift.ReadValue(ctx, in, thrift.STRUCT)
+	if err != nil {
+		return nil, err
+	}
+	return object{
+		{"type", d.typeName},
+		{"body", annotateStruct(r.Document, r.Struct, v)},
+	}, nil
+}
+
+func (d *dumper) readMessage(ctx context.Context, in thrift.TProtocol) (object, error) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := thrift.ReadValue(ctx, in, thrift.STRUCT)
+	if err != nil {
+		return nil, err
+	}
+	if err := in.ReadMessageEnd(ctx); err != nil {
+		return nil, err
+	}
+	s, doc := d.doc.MessageStruct(d.service, name, typeID)
+	return object{
+		{"method", name},
+		{"type", messageTypeName(typeID)},
+		{"seqid", seqID},
+		{"body", annotateStruct(doc, s, body)},
+	}, nil
+}
+
+// transportOf returns the framing of the message at the beginning of data,
+// as detected by THeaderTransport.
+func transportOf(data []byte) string {
+	switch {
+	case len(data) < 8 || data[0]&0x80 != 0:
+		// The first byte of the binary and compact messages.
+		return "unframed"
+	case data[4] == 0x0f && data[5] == 0xff:
+		return "header"
+	default:
+		return "framed"
+	}
+}
+
+func protocolName(id thrift.THeaderProtocolID) string {
+	switch id {
+	case thrift.THeaderProtocolBinary:
+		return "binary"
+	case thrift.THeaderProtocolCompact:
+		return "compact"
+	default:
+		return strconv.Itoa(int(id))
+	}
+}
+
+func messageTypeName(typeID thrift.TMessageType) string {
+	switch typeID {
+	case thrift.CALL:
+		return "CALL"
+	case thrift.REPLY:
+		return "REPLY"
+	case thrift.EXCEPTION:
+		return "EXCEPTION"
+	case thrift.ONEWAY:
+		return "ONEWAY"
+	default:
+		return strconv.Itoa(int(typeID))
+	}
+}
+
+// annotateStruct returns the fields of v keyed by id, type and name, using
+// the fields of s, a struct of doc, when it's not nil.
+func annotateStruct(doc *idl.Document, s *idl.Struct, v thrift.Value) object {
+	out := make(object, 0, len(v.Fields))
+	for _, f := range v.Fields {
+		var def *idl.Field
+		if s != nil {
+			for _, field := range s.Fields {
+				if field.ID == f.ID {
+					def = field
+				}
+			}
+		}
+		if def == nil {
+			out = append(out, member{fmt.Sprintf("%d: %s", f.ID, typeName(f.Value)), annotate(nil, nil, f.Value)})
+			continue
+		}
+		out = append(out, member{fmt.Sprintf("%d: %s %s", f.ID, def.Type, def.Name), annotate(doc, def.Type, f.Value)})
+	}
+	return out
+}
+
+// annotate converts v, of the type t of doc if t is not nil, to its JSON
+// value.
+func annotate(doc *idl.Document, t *idl.Type, v thrift.Value) interface{} {
+	var r idl.ResolvedType
+	if t != nil {
+		var err error
+		if r, err = doc.Resolve(t); err != nil || r.TType != v.Type {
+			// The values not matching their IDL are printed as is.
+			r = idl.ResolvedType{}
+		}
+	}
+	switch v.Type {
+	case thrift.BOOL:
+		return v.Bool
+	case thrift.BYTE, thrift.I16, thrift.I32, thrift.I64:
+		if r.Enum != nil {
+			for _, ev := range r.Enum.Values {
+				if ev.Value == v.Int {
+					return fmt.Sprintf("%s (%d)", ev.Name, v.Int)
+				}
+			}
+		}
+		return v.Int
+	case thrift.DOUBLE:
+		if math.IsNaN(v.Double) || math.IsInf(v.Double, 0) {
+			return fmt.Sprint(v.Double)
+		}
+		return v.Double
+	case thrift.STRING:
+		if (r.Type == nil || r.Type.Name == "string") && utf8.Valid(v.Binary) {
+			return string(v.Binary)
+		}
+		return "0x" + hex.EncodeToString(v.Binary)
+	case thrift.STRUCT:
+		return annotateStruct(r.Document, r.Struct, v)
+	case thrift.LIST, thrift.SET:
+		var elemType *idl.Type
+		if r.Type != nil {
+			elemType = r.Type.ValueType
+		}
+		elems := make([]interface{}, len(v.Elems))
+		for i, elem := range v.Elems {
+			elems[i] = annotate(r.Document, elemType, elem)
+		}
+		return elems
+	case thrift.MAP:
+		var keyType, valueType *idl.Type
+		if r.Type != nil {
+			keyType, valueType = r.Type.KeyType, r.Type.ValueType
+		}
+		switch v.KeyType {
+		case thrift.STRUCT, thrift.LIST, thrift.SET, thrift.MAP:
+			// The keys which aren't scalars are printed as pairs.
+			entries := make([]interface{}, len(v.Entries))
+			for i, e := range v.Entries {
+				entries[i] = object{
+					{"key", annotate(r.Document, keyType, e.Key)},
+					{"value", annotate(r.Document, valueType, e.Value)},
+				}
+			}
+			return entries
+		}
+		entries := make(object, len(v.Entries))
+		for i, e := range v.Entries {
+			entries[i] = member{fmt.Sprint(annotate(r.Document, keyType, e.Key)), annotate(r.Document, valueType, e.Value)}
+		}
+		return entries
+	default:
+		return nil
+	}
+}
+
+// typeName returns the IDL name of the type of v.
+func typeName(v thrift.Value) string {
+	switch v.Type {
+	case thrift.BOOL:
+		return "bool"
+	case thrift.BYTE:
+		return "i8"
+	case thrift.I16:
+		return "i16"
+	case thrift.I32:
+		return "i32"
+	case thrift.I64:
+		return "i64"
+	case thrift.DOUBLE:
+		return "double"
+	case thrift.STRING:
+		return "string"
+	case thrift.STRUCT:
+		return "struct"
+	case thrift.LIST, thrift.SET:
+		name := strings.ToLower(v.Type.String())
+		if v.ElemType == thrift.STOP {
+			// The element type of the containers nested in containers is
+			// the one of their first element.
+			return name
+		}
+		elem := thrift.Value{Type: v.ElemType}
+		if len(v.Elems) > 0 {
+			elem = v.Elems[0]
+		}
+		return name + "<" + typeName(elem) + ">"
+	case thrift.MAP:
+		if v.KeyType == thrift.STOP {
+			return "map"
+		}
+		key, value := thrift.Value{Type: v.KeyType}, thrift.Value{Type: v.ElemType}
+		if len(v.Entries) > 0 {
+			key, value = v.Entries[0].Key, v.Entries[0].Value
+		}
+		return "map<" + typeName(key) + "," + typeName(value) + ">"
+	default:
+		return strconv.Itoa(int(v.Type))
+	}
+}
+
+// member is a member of an object.
+type member struct {
+	key   string
+	value interface{}
+}
+
+// object is a JSON object keeping the order of its members.
+type object []member
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := marshal(&buf, m.key); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := marshal(&buf, m.value); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshal writes the JSON encoding of v to buf, without escaping the HTML
+// characters of the types like list<string>.
+func marshal(buf *bytes.Buffer, v interface{}) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode ends the value with a newline.
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
@@ -0,0 +1,299 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+const testIDL = `
+enum Status {
+  ACTIVE = 1
+}
+
+struct User {
+  1: i64 id
+  2: Status status
+  3: binary avatar
+}
+
+service Users {
+  User get(1: i64 id, 2: map<string, list<i32>> options)
+}
+`
+
+func writeMessages(t *testing.T, proto thrift.TProtocol, buf *thrift.TMemoryBuffer) []byte {
+	t.Helper()
+	ctx := context.Background()
+	for _, m := range []struct {
+		typeID thrift.TMessageType
+		body   thrift.Value
+	}{
+		{thrift.CALL, thrift.StructValue(
+			thrift.ValueField{ID: 1, Value: thrift.I64Value(42)},
+			thrift.ValueField{ID: 2, Value: thrift.MapValue(thrift.STRING, thrift.LIST, thrift.ValueMapEntry{
+				Key:   thrift.StringValue("a<b"),
+				Value: thrift.ListValue(thrift.I32, thrift.I32Value(1)),
+			})},
+		)},
+		{thrift.REPLY, thrift.StructValue(thrift.ValueField{ID: 0, Value: thrift.StructValue(
+			thrift.ValueField{ID: 1, Value: thrift.I64Value(42)},
+			thrift.ValueField{ID: 2, Value: thrift.I32Value(1)},
+			thrift.ValueField{ID: 3, Value: thrift.BinaryValue([]byte{0xca, 0xfe})},
+			thrift.ValueField{ID: 4, Value: thrift.DoubleValue(0.5)},
+		)})},
+	} {
+		if err := proto.WriteMessageBegin(ctx, "get", m.typeID, 7); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.body.Write(ctx, proto); err != nil {
+			t.Fatal(err)
+		}
+		if err := proto.WriteMessageEnd(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := proto.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func dump(t *testing.T, stdin []byte, args ...string) []map[string]interface{} {
+	t.Helper()
+	var stdout bytes.Buffer
+	if err := run(args, bytes.NewReader(stdin), &stdout); err != nil {
+		t.Fatal(err)
+	}
+	var messages []map[string]interface{}
+	dec := json.NewDecoder(&stdout)
+	for dec.More() {
+		var m map[string]interface{}
+		if err := dec.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+func TestDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thriftdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	idlFile := filepath.Join(dir, "users.thrift")
+	if err := ioutil.WriteFile(idlFile, []byte(testIDL), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := thrift.NewTMemoryBuffer()
+	proto := thrift.NewTHeaderProtocolConf(buf, &thrift.TConfiguration{
+		THeaderProtocolID: thrift.THeaderProtocolIDPtrMust(thrift.THeaderProtocolCompact),
+	})
+	proto.SetWriteHeader("trace", "abc")
+	header := writeMessages(t, proto, buf)
+
+	messages := dump(t, []byte(hex.EncodeToString(header)), "-hex", "-idl", idlFile)
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %v", messages)
+	}
+	call := messages[0]
+	expected := map[string]interface{}{
+		"offset":    0.0,
+		"transport": "header",
+		"protocol":  "compact",
+		"headers":   map[string]interface{}{"trace": "abc"},
+		"method":    "get",
+		"type":      "CALL",
+		"seqid":     7.0,
+		"body": map[string]interface{}{
+			"1: i64 id":                        42.0,
+			"2: map<string,list<i32>> options": map[string]interface{}{"a<b": []interface{}{1.0}},
+		},
+	}
+	if !reflect.DeepEqual(call, expected) {
+		t.Errorf("expected %v, got %v", expected, call)
+	}
+	reply := messages[1]["body"]
+	expectedReply := map[string]interface{}{
+		"0: User success": map[string]interface{}{
+			"1: i64 id":        42.0,
+			"2: Status status": "ACTIVE (1)",
+			"3: binary avatar": "0xcafe",
+			"4: double":        0.5,
+		},
+	}
+	if !reflect.DeepEqual(reply, expectedReply) {
+		t.Errorf("expected %v, got %v", expectedReply, reply)
+	}
+
+	// Without IDL, from a file of unframed binary messages.
+	buf = thrift.NewTMemoryBuffer()
+	binary := writeMessages(t, thrift.NewTBinaryProtocolConf(buf, nil), buf)
+	binaryFile := filepath.Join(dir, "binary.bin")
+	if err := ioutil.WriteFile(binaryFile, binary, 0644); err != nil {
+		t.Fatal(err)
+	}
+	messages = dump(t, nil, binaryFile)
+	if len(messages) != 2 || messages[0]["transport"] != "unframed" || messages[0]["protocol"] != "binary" {
+		t.Fatalf("unexpected messages %v", messages)
+	}
+	if offset := messages[1]["offset"]; offset == 0.0 {
+		t.Errorf("unexpected offset %v", offset)
+	}
+	expectedReply = map[string]interface{}{
+		"0: struct": map[string]interface{}{
+			"1: i64":    42.0,
+			"2: i32":    1.0,
+			"3: string": "0xcafe",
+			"4: double": 0.5,
+		},
+	}
+	if reply := messages[1]["body"]; !reflect.DeepEqual(reply, expectedReply) {
+		t.Errorf("expected %v, got %v", expectedReply, reply)
+	}
+
+	// A struct without message envelope, in framed JSON.
+	buf = thrift.NewTMemoryBuffer()
+	framed := thrift.NewTFramedTransportConf(buf, nil)
+	user := thrift.StructValue(thrift.ValueField{ID: 2, Value: thrift.I32Value(1)})
+	jsonProto := thrift.NewTJSONProtocol(framed)
+	if err := user.Write(context.Background(), jsonProto); err != nil {
+		t.Fatal(err)
+	}
+	if err := jsonProto.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	messages = dump(t, buf.Bytes(), "-protocol", "json", "-framed", "-idl", idlFile, "-type", "User")
+	expectedUser := map[string]interface{}{"2: Status status": "ACTIVE (1)"}
+	if len(messages) != 1 || messages[0]["type"] != "User" || !reflect.DeepEqual(messages[0]["body"], expectedUser) {
+		t.Errorf("unexpected struct %v", messages)
+	}
+
+	messages = dump(t, []byte("[1,\"get\",1,7,{}]\n[1,\"get\",2,7,{}]\n"), "-protocol", "json")
+	if len(messages) != 2 || messages[1]["offset"] != 17.0 || messages[1]["type"] != "REPLY" {
+		t.Errorf("unexpected JSON messages %v", messages)
+	}
+}
+
+func TestDumpErrors(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		stdin string
+		err   string
+	}{
+		{[]string{"-hex"}, "zz", "invalid byte"},
+		{[]string{"-protocol", "xml"}, "", `unknown protocol "xml"`},
+		{[]string{"-type", "User"}, "", "-framed and -type require -protocol"},
+		{[]string{"-protocol", "binary", "-type", "User"}, "", "-service and -type require -idl"},
+		{nil, "\x80\x01\x00\x01\x00\x00\x00\x03get", "offset 0:"},
+		{[]string{"-port", "9090"}, "", "-port requires -pcap"},
+		{[]string{"-pcap", "-port", "http"}, "", `invalid port "http"`},
+		{[]string{"-pcap"}, "not a capture, but a text file", "unknown capture format"},
+	} {
+		err := run(c.args, strings.NewReader(c.stdin), ioutil.Discard)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%v: expected error %q, got %v", c.args, c.err, err)
+		}
+	}
+}
+
+// writePcap returns a capture in the pcap format of the raw IPv4 packets of
+// a TCP segment per payload, sent from 10.0.0.1:50000 to 10.0.0.2:port.
+func writePcap(port uint16, payloads ...[]byte) []byte {
+	le := binary.LittleEndian
+	capture := make([]byte, 24)
+	le.PutUint32(capture[0:], 0xa1b2c3d4)
+	le.PutUint16(capture[4:], 2)
+	le.PutUint16(capture[6:], 4)
+	le.PutUint32(capture[16:], 65535)
+	le.PutUint32(capture[20:], 101)
+	seq := uint32(1)
+	for i, payload := range payloads {
+		packet := make([]byte, 40, 40+len(payload))
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:], uint16(40+len(payload)))
+		packet[9] = 6
+		copy(packet[12:], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+		binary.BigEndian.PutUint16(packet[20:], 50000)
+		binary.BigEndian.PutUint16(packet[22:], port)
+		binary.BigEndian.PutUint32(packet[24:], seq)
+		packet[32] = 5 << 4
+		packet = append(packet, payload...)
+		seq += uint32(len(payload))
+
+		record := make([]byte, 16)
+		le.PutUint32(record[0:], 1700000000)
+		le.PutUint32(record[4:], uint32(i))
+		le.PutUint32(record[8:], uint32(len(packet)))
+		le.PutUint32(record[12:], uint32(len(packet)))
+		capture = append(append(capture, record...), packet...)
+	}
+	return capture
+}
+
+func TestDumpPcap(t *testing.T) {
+	buf := thrift.NewTMemoryBuffer()
+	messages := writeMessages(t, thrift.NewTCompactProtocolConf(buf, nil), buf)
+	// The messages split in two segments, the second one truncated.
+	capture := writePcap(9090, messages[:10], messages[10:len(messages)-1])
+	capture = append(capture, writePcap(80, []byte("GET / HTTP/1.1\r\n\r\n"))[24:]...)
+
+	out := dump(t, capture, "-pcap", "-port", "9090")
+	if len(out) != 2 {
+		t.Fatalf("expected the call and the error, got %v", out)
+	}
+	expected := map[string]interface{}{
+		"time":      "2023-11-14T22:13:20Z",
+		"src":       "10.0.0.1:50000",
+		"dst":       "10.0.0.2:9090",
+		"transport": "unframed",
+		"protocol":  "compact",
+		"method":    "get",
+		"type":      "CALL",
+		"seqid":     7.0,
+		"body": map[string]interface{}{
+			"1: i64":                   42.0,
+			"2: map<string,list<i32>>": map[string]interface{}{"a<b": []interface{}{1.0}},
+		},
+	}
+	if !reflect.DeepEqual(out[0], expected) {
+		t.Errorf("expected %v, got %v", expected, out[0])
+	}
+	if out[1]["src"] != "10.0.0.1:50000" || out[1]["error"] != "truncated message" {
+		t.Errorf("expected the truncated reply, got %v", out[1])
+	}
+}
@@ -0,0 +1,182 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// CoalescingOptions configures CoalescingMiddleware.
+type CoalescingOptions struct {
+	// ReadOnly reports whether the calls of method only read data, without
+	// side effects, only their calls are coalesced. It must not be nil.
+	ReadOnly func(method string) bool
+
+	// Vary returns a string added to the key of a call, for the methods
+	// whose result depends on more than their arguments, e.g. the
+	// authenticated client or a THeader.
+	//
+	// If nil, the key only depends on the method and arguments.
+	Vary func(ctx context.Context, method string) string
+
+	// OnCoalesced is called for every call sharing the result of another
+	// one instead of being sent, for example to record metrics.
+	OnCoalesced func(ctx context.Context, method string)
+}
+
+// CoalescingMiddleware returns a ClientMiddleware coalescing the identical
+// concurrent calls of read-only methods into a single call whose result is
+// shared, so that a burst of requests for the same missing cache entry only
+// loads the servers once.
+//
+// The calls are identical when they have the same method, the same string
+// returned by Vary, and the same arguments, in a canonical form where the
+// fields of the structs are ordered by id (the elements of maps and sets keep
+// their order). The calls sharing the result of another get a copy of it,
+// along with its error and its ResponseMeta.
+//
+// A call waiting for another one returns once its context is done. If the
+// context of the call sent is done first, the calls waiting for it are sent
+// again. The wrapped TClient must be safe for concurrent use.
+func CoalescingMiddleware(opts CoalescingOptions) ClientMiddleware {
+	return func(next TClient) TClient {
+		c := &tCoalescer{
+			opts:  opts,
+			next:  next,
+			calls: make(map[string]*tCoalescedCall),
+		}
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				if result == nil || !opts.ReadOnly(method) {
+					return next.Call(ctx, method, args, result)
+				}
+				return c.call(ctx, method, args, result)
+			},
+		}
+	}
+}
+
+// tCoalescer is the state of a CoalescingMiddleware.
+type tCoalescer struct {
+	opts CoalescingOptions
+	next TClient
+
+	mu sync.Mutex
+	// calls are the calls in flight, by key.
+	calls map[string]*tCoalescedCall
+}
+
+// tCoalescedCall is a call in flight of a CoalescingMiddleware.
+type tCoalescedCall struct {
+	// done is closed once the call returned, the fields below are set
+	// before.
+	done chan struct{}
+
+	// waiters is the number of calls waiting for its result, guarded by
+	// the mu of the tCoalescer.
+	waiters int
+
+	// result is the result struct encoded with TBinaryProtocol, nil if
+	// err is not.
+	result []byte
+	meta   ResponseMeta
+	err    error
+	// retry is true if the call failed because its context was done, in
+	// which case the calls waiting for it are sent again.
+	retry bool
+}
+
+func (c *tCoalescer) call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	key, err := c.key(ctx, method, args)
+	if err != nil {
+		return ResponseMeta{}, err
+	}
+	for {
+		c.mu.Lock()
+		call, ok := c.calls[key]
+		if !ok {
+			call = &tCoalescedCall{done: make(chan struct{})}
+			c.calls[key] = call
+			c.mu.Unlock()
+			return c.send(ctx, key, call, method, args, result)
+		}
+		call.waiters++
+		c.mu.Unlock()
+
+		if c.opts.OnCoalesced != nil {
+			c.opts.OnCoalesced(ctx, method)
+		}
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return ResponseMeta{}, ctx.Err()
+		}
+		if call.retry && ctx.Err() == nil {
+			continue
+		}
+		recordIncomingHeaders(ctx, call.meta.Headers)
+		if call.err != nil {
+			return call.meta, call.err
+		}
+		in := NewTBinaryProtocolConf(&TMemoryBuffer{Buffer: bytes.NewBuffer(call.result)}, nil)
+		return call.meta, result.Read(ctx, in)
+	}
+}
+
+// send sends call, and shares its result with the calls waiting for it.
+func (c *tCoalescer) send(ctx context.Context, key string, call *tCoalescedCall, method string, args, result TStruct) (ResponseMeta, error) {
+	meta, err := c.next.Call(ctx, method, args, result)
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	waiters := call.waiters
+	c.mu.Unlock()
+
+	call.meta, call.err = meta, err
+	if waiters > 0 {
+		call.retry = err != nil && ctx.Err() != nil
+		if err == nil {
+			buf := NewTMemoryBuffer()
+			call.err = result.Write(ctx, NewTBinaryProtocolConf(buf, nil))
+			call.result = buf.Bytes()
+		}
+	}
+	close(call.done)
+	return meta, err
+}
+
+// key returns the key of a call, see CoalescingMiddleware.
+func (c *tCoalescer) key(ctx context.Context, method string, args TStruct) (string, error) {
+	buf := NewTMemoryBuffer()
+	if err := args.Write(ctx, NewTBinaryProtocolConf(buf, nil)); err != nil {
+		return "", err
+	}
+	canonical := NewTMemoryBuffer()
+	if err := copyValue(ctx, NewTBinaryProtocolConf(buf, nil), NewTBinaryProtocolConf(canonical, nil), STRUCT, true, DEFAULT_RECURSION_DEPTH); err != nil {
+		return "", err
+	}
+	var vary string
+	if c.opts.Vary != nil {
+		vary = c.opts.Vary(ctx, method)
+	}
+	return requestKey(method, vary, canonical.Bytes()), nil
+}
@@ -0,0 +1,141 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// coalescingTestClient returns a client with a CoalescingMiddleware coalescing
+// the calls of "get", the channel receiving the calls sent, whose replies are
+// sent once release is closed, and the channel receiving the coalesced calls.
+func coalescingTestClient(release <-chan struct{}) (TClient, <-chan string, <-chan string) {
+	sent := make(chan string, 10)
+	next := WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			sent <- method
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return ResponseMeta{}, ctx.Err()
+			}
+			result.(*pipelinedString).Value = "reply-" + args.(*pipelinedString).Value
+			return ResponseMeta{Headers: THeaderMap{"served-by": "server"}}, nil
+		},
+	}
+	coalesced := make(chan string, 10)
+	client := WrapClient(next, CoalescingMiddleware(CoalescingOptions{
+		ReadOnly: func(method string) bool {
+			return method == "get"
+		},
+		OnCoalesced: func(ctx context.Context, method string) {
+			coalesced <- method
+		},
+	}))
+	return client, sent, coalesced
+}
+
+func coalescingTestCall(ctx context.Context, client TClient, method, value string) (ResponseMeta, error) {
+	var result pipelinedString
+	meta, err := client.Call(ctx, method, &pipelinedString{value}, &result)
+	if err == nil && result.Value != "reply-"+value {
+		err = errors.New("unexpected result " + result.Value)
+	}
+	return meta, err
+}
+
+func TestCoalescingMiddleware(t *testing.T) {
+	release := make(chan struct{})
+	client, sent, coalesced := coalescingTestClient(release)
+
+	const calls = 5
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			meta, err := coalescingTestCall(context.Background(), client, "get", "key")
+			if err != nil {
+				t.Error(err)
+			} else if meta.Headers["served-by"] != "server" {
+				t.Errorf("unexpected headers %v", meta.Headers)
+			}
+		}()
+	}
+	for i := 0; i < calls-1; i++ {
+		<-coalesced
+	}
+	close(release)
+	wg.Wait()
+	if n := len(sent); n != 1 {
+		t.Errorf("expected a single call sent, got %d", n)
+	}
+
+	// The calls with different methods or arguments are not coalesced,
+	// nor the ones of the methods that are not read-only.
+	for _, c := range []struct {
+		method, value string
+	}{
+		{"get", "a"},
+		{"get", "b"},
+		{"set", "a"},
+	} {
+		if _, err := coalescingTestCall(context.Background(), client, c.method, c.value); err != nil {
+			t.Errorf("%s(%s): %v", c.method, c.value, err)
+		}
+	}
+	if n := len(sent); n != 4 {
+		t.Errorf("expected 4 calls sent, got %d", n)
+	}
+}
+
+func TestCoalescingMiddlewareCanceledCall(t *testing.T) {
+	release := make(chan struct{})
+	client, sent, coalesced := coalescingTestClient(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := coalescingTestCall(ctx, client, "get", "key")
+		canceled <- err
+	}()
+	<-sent
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := coalescingTestCall(context.Background(), client, "get", "key")
+		waiting <- err
+	}()
+	<-coalesced
+
+	// The waiting call is sent again once the call it waits for is
+	// canceled.
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	<-sent
+	close(release)
+	if err := <-waiting; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,276 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// columnarMagic starts the batches of MarshalColumnar, followed by
+// columnarVersion.
+const (
+	columnarMagic   = "TCOL"
+	columnarVersion = 1
+)
+
+// ColumnarOptions configures MarshalColumnar and UnmarshalColumnar, which
+// must use the same Compressor.
+type ColumnarOptions struct {
+	// Compressor compresses each column on its own, e.g. the one of
+	// NewZlibCompressor. The columns aren't compressed if it's nil.
+	Compressor Compressor
+
+	// Configuration is the TConfiguration of the compact protocol of the
+	// columns. Its MaxMessageSize also limits the number of rows and the
+	// decompressed size of each column.
+	Configuration *TConfiguration
+}
+
+// MarshalColumnar encodes rows, a slice of generated structs or structs with
+// thrift tags (see EncodeStruct), or of pointers to them, column by column
+// for the analytics pipelines: the values of each field of all the rows are
+// written together, with the compact protocol, and compressed on their own,
+// which compresses the large batches of similar rows much better than
+// serializing them one by one.
+//
+// The columnar encoding is EXPERIMENTAL, and may change in incompatible ways.
+//
+// A column is the id and the type of its field, whether it's compressed, its
+// size as a uvarint and its bytes: a bitmap of the rows where the field is
+// set, then their values. They follow the magic "TCOL", the version 1 as a
+// byte, and the numbers of rows and of columns as uvarints.
+func MarshalColumnar(ctx context.Context, rows interface{}, opts ColumnarOptions) ([]byte, error) {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("cannot encode %T as columns", rows)
+	}
+	elemType, err := columnarElemType(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	info, err := reflectStructInfo(elemType)
+	if err != nil {
+		return nil, err
+	}
+	structs := make([]reflect.Value, v.Len())
+	for i := range structs {
+		row := v.Index(i)
+		if row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				return nil, fmt.Errorf("nil row %d", i)
+			}
+			row = row.Elem()
+		}
+		structs[i] = row
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(columnarMagic)
+	buf.WriteByte(columnarVersion)
+	writeColumnarUvarint(&buf, uint64(len(structs)))
+	writeColumnarUvarint(&buf, uint64(len(info.fields)))
+	column := NewTMemoryBuffer()
+	proto := NewTCompactProtocolConf(column, opts.Configuration)
+	for _, f := range info.fields {
+		column.Reset()
+		bitmap := make([]byte, (len(structs)+7)/8)
+		column.Write(bitmap)
+		for i, row := range structs {
+			fv := row.Field(f.index)
+			switch fv.Kind() {
+			case reflect.Ptr, reflect.Slice, reflect.Map:
+				if fv.IsNil() {
+					if f.required {
+						return nil, NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("required field %s of %s is not set in row %d", f.name, info.name, i))
+					}
+					continue
+				}
+			}
+			bitmap[i/8] |= 1 << (i % 8)
+			if err := writeReflectValue(ctx, proto, fv, f.set); err != nil {
+				return nil, PrependError(fmt.Sprintf("%s.%s: ", info.name, f.name), err)
+			}
+		}
+		if err := proto.Flush(ctx); err != nil {
+			return nil, err
+		}
+		b := column.Bytes()
+		copy(b, bitmap)
+		compressed := byte(0)
+		if opts.Compressor != nil {
+			if b, err = compressBytes(opts.Compressor, b); err != nil {
+				return nil, err
+			}
+			compressed = 1
+		}
+		var header [4]byte
+		binary.BigEndian.PutUint16(header[:], uint16(f.id))
+		header[2] = byte(f.ttype)
+		header[3] = compressed
+		buf.Write(header[:])
+		writeColumnarUvarint(&buf, uint64(len(b)))
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalColumnar decodes the rows encoded by MarshalColumnar into rows, a
+// pointer to a slice of structs or of pointers to structs, replacing its
+// elements.
+//
+// Like DecodeStruct, the columns of the fields not in the rows, or of another
+// type, are skipped, and the decoding fails if a required field isn't set in
+// all the rows.
+func UnmarshalColumnar(ctx context.Context, data []byte, rows interface{}, opts ColumnarOptions) error {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("cannot decode columns into %T", rows)
+	}
+	elemType, err := columnarElemType(v.Elem().Type())
+	if err != nil {
+		return err
+	}
+	info, err := reflectStructInfo(elemType)
+	if err != nil {
+		return err
+	}
+	if len(data) < len(columnarMagic)+1 || string(data[:len(columnarMagic)]) != columnarMagic {
+		return NewTProtocolExceptionWithType(INVALID_DATA, errors.New("not a columnar batch"))
+	}
+	if version := data[len(columnarMagic)]; version != columnarVersion {
+		return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("unsupported columnar version %d", version))
+	}
+	r := bytes.NewReader(data[len(columnarMagic)+1:])
+	max := uint64(opts.Configuration.GetMaxMessageSize())
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return NewTProtocolExceptionWithType(INVALID_DATA, err)
+	}
+	if n > max {
+		return NewTProtocolExceptionWithType(SIZE_LIMIT, fmt.Errorf("%d rows, more than %d", n, max))
+	}
+	columns, err := binary.ReadUvarint(r)
+	if err != nil {
+		return NewTProtocolExceptionWithType(INVALID_DATA, err)
+	}
+
+	slice := reflect.MakeSlice(v.Elem().Type(), int(n), int(n))
+	structs := make([]reflect.Value, n)
+	for i := range structs {
+		row := slice.Index(i)
+		if row.Kind() == reflect.Ptr {
+			row.Set(reflect.New(elemType))
+			row = row.Elem()
+		}
+		structs[i] = row
+	}
+	isset := make([]bool, len(info.fields))
+	seen := make(map[int16]bool)
+	for c := uint64(0); c < columns; c++ {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return NewTProtocolExceptionWithType(INVALID_DATA, err)
+		}
+		id, ttype, compressed := int16(binary.BigEndian.Uint16(header[:])), TType(header[2]), header[3] == 1
+		if seen[id] {
+			return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("duplicate column %d", id))
+		}
+		seen[id] = true
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return NewTProtocolExceptionWithType(INVALID_DATA, err)
+		}
+		if size > uint64(r.Len()) {
+			return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("column %d of %d bytes, only %d left", id, size, r.Len()))
+		}
+		b := make([]byte, size)
+		r.Read(b)
+		fi, ok := info.byID[id]
+		if !ok || info.fields[fi].ttype != ttype {
+			continue
+		}
+		f := info.fields[fi]
+		if compressed {
+			if opts.Compressor == nil {
+				return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("column %d is compressed, without a Compressor", id))
+			}
+			if b, err = decompressBytes(opts.Compressor, b, int64(max)); err != nil {
+				return err
+			}
+		}
+		if err := readColumn(ctx, b, structs, f, opts.Configuration); err != nil {
+			return PrependError(fmt.Sprintf("%s.%s: ", info.name, f.name), err)
+		}
+		isset[fi] = true
+	}
+	for i, f := range info.fields {
+		if f.required && !isset[i] && n > 0 {
+			return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("required field %s of %s is not set", f.name, info.name))
+		}
+	}
+	v.Elem().Set(slice)
+	return nil
+}
+
+// readColumn reads the column b of the field f into the rows.
+func readColumn(ctx context.Context, b []byte, rows []reflect.Value, f tReflectField, conf *TConfiguration) error {
+	bitmapSize := (len(rows) + 7) / 8
+	if len(b) < bitmapSize {
+		return NewTProtocolExceptionWithType(INVALID_DATA, errors.New("truncated column"))
+	}
+	bitmap := b[:bitmapSize]
+	proto := NewTCompactProtocolConf(&TMemoryBuffer{Buffer: bytes.NewBuffer(b[bitmapSize:])}, conf)
+	for i, row := range rows {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			if f.required {
+				return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("required field not set in row %d", i))
+			}
+			continue
+		}
+		if err := readReflectValue(ctx, proto, row.Field(f.index), f.ttype); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnarElemType returns the struct type of the elements of the slice type
+// t.
+func columnarElemType(t reflect.Type) (reflect.Type, error) {
+	elem := t.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot encode the elements of %s as rows", t)
+	}
+	return elem, nil
+}
+
+func writeColumnarUvarint(buf *bytes.Buffer, n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], n)])
+}
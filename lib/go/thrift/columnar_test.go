@@ -0,0 +1,161 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func columnarTestRows(n int) []reflectTestUser {
+	rows := make([]reflectTestUser, n)
+	for i := range rows {
+		rows[i] = reflectTestUser{
+			ID:     int64(1000 + i),
+			Roles:  []string{"reader"},
+			Scores: map[string]float64{"rank": float64(i % 10)},
+			Level:  int8(i % 3),
+		}
+		if i%2 == 0 {
+			name := fmt.Sprintf("user %d", i)
+			rows[i].Name = &name
+			rows[i].Address = &reflectTestAddress{City: "Paris"}
+		}
+	}
+	return rows
+}
+
+func TestColumnar(t *testing.T) {
+	ctx := context.Background()
+	rows := columnarTestRows(1000)
+	zlibCompressor, err := NewZlibCompressor(zlib.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for label, opts := range map[string]ColumnarOptions{
+		"plain":      {},
+		"compressed": {Compressor: zlibCompressor},
+	} {
+		t.Run(label, func(t *testing.T) {
+			data, err := MarshalColumnar(ctx, rows, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded []reflectTestUser
+			if err := UnmarshalColumnar(ctx, data, &decoded, opts); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, rows) {
+				t.Errorf("expected the rows back, got %d rows", len(decoded))
+			}
+
+			pointers := make([]*reflectTestUser, len(rows))
+			for i := range rows {
+				pointers[i] = &rows[i]
+			}
+			pointersData, err := MarshalColumnar(ctx, pointers, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(pointersData, data) {
+				t.Error("expected the same encoding for the rows and the pointers to them")
+			}
+			var decodedPointers []*reflectTestUser
+			if err := UnmarshalColumnar(ctx, data, &decodedPointers, opts); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decodedPointers, pointers) {
+				t.Errorf("expected the pointers back, got %d rows", len(decodedPointers))
+			}
+
+			// The columns of the fields not in the rows are skipped.
+			var ids []struct {
+				ID int64 `thrift:"id,1,required"`
+			}
+			if err := UnmarshalColumnar(ctx, data, &ids, opts); err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) != len(rows) || ids[999].ID != 1999 {
+				t.Errorf("expected the ids of the rows, got %d rows", len(ids))
+			}
+		})
+	}
+
+	t.Run("compression", func(t *testing.T) {
+		serialized := NewTMemoryBuffer()
+		for i := range rows {
+			if err := EncodeStruct(ctx, NewTCompactProtocolConf(serialized, nil), &rows[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rowMajor, err := compressBytes(zlibCompressor, serialized.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		columnar, err := MarshalColumnar(ctx, rows, ColumnarOptions{Compressor: zlibCompressor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(columnar) >= len(rowMajor) {
+			t.Errorf("expected fewer than the %d bytes of the compressed rows, got %d", len(rowMajor), len(columnar))
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		compressed, err := MarshalColumnar(ctx, rows, ColumnarOptions{Compressor: zlibCompressor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded []reflectTestUser
+		for _, c := range []struct {
+			label    string
+			data     []byte
+			opts     ColumnarOptions
+			expected int
+		}{
+			{"not a batch", []byte("TENV"), ColumnarOptions{}, INVALID_DATA},
+			{"truncated", compressed[:len(compressed)-10], ColumnarOptions{Compressor: zlibCompressor}, INVALID_DATA},
+			{"no Compressor", compressed, ColumnarOptions{}, INVALID_DATA},
+			{"too many rows", compressed, ColumnarOptions{Compressor: zlibCompressor, Configuration: &TConfiguration{MaxMessageSize: 100}}, SIZE_LIMIT},
+		} {
+			err := UnmarshalColumnar(ctx, c.data, &decoded, c.opts)
+			var te TProtocolException
+			if !errors.As(err, &te) || te.TypeId() != c.expected {
+				t.Errorf("%s: expected a TProtocolException of type %d, got %v", c.label, c.expected, err)
+			}
+		}
+
+		var addresses []reflectTestAddress
+		if err := UnmarshalColumnar(ctx, compressed, &addresses, ColumnarOptions{Compressor: zlibCompressor}); err == nil {
+			t.Error("expected an error for the missing required column")
+		}
+		if _, err := MarshalColumnar(ctx, []*reflectTestUser{nil}, ColumnarOptions{}); err == nil {
+			t.Error("expected an error for a nil row")
+		}
+		if _, err := MarshalColumnar(ctx, []int{1}, ColumnarOptions{}); err == nil {
+			t.Error("expected an error for rows which aren't structs")
+		}
+	})
+}
@@ -122,6 +122,8 @@ type TCompactProtocol struct {
 	boolValue          bool
 	boolValueIsNotNull bool
 	buffer             [64]byte
+
+	limits tFieldSizeLimiter
 }
 
 // Deprecated: Use NewTCompactProtocolConf instead.
@@ -360,6 +362,7 @@ func (p *TCompactProtocol) WriteBinary(ctx context.Context, bin []byte) error {
 // Read a message header.
 func (p *TCompactProtocol) ReadMessageBegin(ctx context.Context) (name string, typeId TMessageType, seqId int32, err error) {
 	var protocolId byte
+	p.limits.reset()
 
 	_, deadlineSet := ctx.Deadline()
 	for {
@@ -398,7 +401,7 @@ func (p *TCompactProtocol) ReadMessageBegin(ctx context.Context) (name string, t
 		err = NewTProtocolException(e)
 		return
 	}
-	name, err = p.ReadString(ctx)
+	name, err = p.readString(ctx, false)
 	return
 }
 
@@ -406,7 +409,12 @@ func (p *TCompactProtocol) ReadMessageEnd(ctx context.Context) error { return ni
 
 // Read a struct begin. There's nothing on the wire for this, but it is our
 // opportunity to push a new struct begin marker onto the field stack.
+func (p *TCompactProtocol) resetFieldSizeLimits() {
+	p.limits.reset()
+}
+
 func (p *TCompactProtocol) ReadStructBegin(ctx context.Context) (name string, err error) {
+	p.limits.structBegin()
 	p.lastField = append(p.lastField, p.lastFieldId)
 	p.lastFieldId = 0
 	return
@@ -421,6 +429,7 @@ func (p *TCompactProtocol) ReadStructEnd(ctx context.Context) error {
 	}
 	p.lastFieldId = p.lastField[len(p.lastField)-1]
 	p.lastField = p.lastField[:len(p.lastField)-1]
+	p.limits.structEnd()
 	return nil
 }
 
@@ -492,16 +501,28 @@ func (p *TCompactProtocol) ReadMapBegin(ctx context.Context) (keyType TType, val
 	}
 	keyType, _ = p.getTType(tCompactType(keyAndValueType >> 4))
 	valueType, _ = p.getTType(tCompactType(keyAndValueType & 0xf))
+	size, err = p.limits.containerBegin(ctx, p.cfg, MAP, keyType, valueType, size)
 	return
 }
 
-func (p *TCompactProtocol) ReadMapEnd(ctx context.Context) error { return nil }
+func (p *TCompactProtocol) ReadMapEnd(ctx context.Context) error {
+	return p.limits.containerEnd(ctx, p)
+}
 
 // Read a list header off the wire. If the list size is 0-14, the size will
 // be packed into the element type header. If it's a longer list, the 4 MSB
 // of the element type header will be 0xF, and a varint will follow with the
 // true size.
 func (p *TCompactProtocol) ReadListBegin(ctx context.Context) (elemType TType, size int, err error) {
+	elemType, size, err = p.readListBegin()
+	if err != nil {
+		return
+	}
+	size, err = p.limits.containerBegin(ctx, p.cfg, LIST, STOP, elemType, size)
+	return
+}
+
+func (p *TCompactProtocol) readListBegin() (elemType TType, size int, err error) {
 	size_and_type, err := p.readByteDirect()
 	if err != nil {
 		return
@@ -515,7 +536,7 @@ func (p *TCompactProtocol) ReadListBegin(ctx context.Context) (elemType TType, s
 		}
 		size = int(size2)
 	}
-	err = checkSizeForProtocol(size32, p.cfg)
+	err = checkSizeForProtocol(int32(size), p.cfg)
 	if err != nil {
 		return
 	}
@@ -527,17 +548,26 @@ func (p *TCompactProtocol) ReadListBegin(ctx context.Context) (elemType TType, s
 	return
 }
 
-func (p *TCompactProtocol) ReadListEnd(ctx context.Context) error { return nil }
+func (p *TCompactProtocol) ReadListEnd(ctx context.Context) error {
+	return p.limits.containerEnd(ctx, p)
+}
 
 // Read a set header off the wire. If the set size is 0-14, the size will
 // be packed into the element type header. If it's a longer set, the 4 MSB
 // of the element type header will be 0xF, and a varint will follow with the
 // true size.
 func (p *TCompactProtocol) ReadSetBegin(ctx context.Context) (elemType TType, size int, err error) {
-	return p.ReadListBegin(ctx)
+	elemType, size, err = p.readListBegin()
+	if err != nil {
+		return
+	}
+	size, err = p.limits.containerBegin(ctx, p.cfg, SET, STOP, elemType, size)
+	return
 }
 
-func (p *TCompactProtocol) ReadSetEnd(ctx context.Context) error { return nil }
+func (p *TCompactProtocol) ReadSetEnd(ctx context.Context) error {
+	return p.limits.containerEnd(ctx, p)
+}
 
 // Read a boolean off the wire. If this is a boolean field, the value should
 // already have been read during readFieldBegin, so we'll just consume the
@@ -598,6 +628,11 @@ func (p *TCompactProtocol) ReadDouble(ctx context.Context) (value float64, err e
 
 // Reads a []byte (via readBinary), and then UTF-8 decodes it.
 func (p *TCompactProtocol) ReadString(ctx context.Context) (value string, err error) {
+	return p.readString(ctx, true)
+}
+
+// readString reads a string, applying the per-field limits if limited.
+func (p *TCompactProtocol) readString(ctx context.Context, limited bool) (value string, err error) {
 	length, e := p.readVarint32()
 	if e != nil {
 		return "", NewTProtocolException(e)
@@ -609,15 +644,25 @@ func (p *TCompactProtocol) ReadString(ctx context.Context) (value string, err er
 	if length == 0 {
 		return "", nil
 	}
-	if length < int32(len(p.buffer)) {
+	n := length
+	if limited {
+		if n, err = p.limits.readSize(ctx, p.cfg, false, length); err != nil {
+			return
+		}
+	}
+	if n < int32(len(p.buffer)) {
 		// Avoid allocation on small reads
-		buf := p.buffer[:length]
+		buf := p.buffer[:n]
 		read, e := io.ReadFull(p.trans, buf)
-		return string(buf[:read]), NewTProtocolException(e)
+		value, err = string(buf[:read]), NewTProtocolException(e)
+	} else {
+		buf, e := safeReadBytes(n, p.trans)
+		value, err = string(buf), NewTProtocolException(e)
 	}
-
-	buf, e := safeReadBytes(length, p.trans)
-	return string(buf), NewTProtocolException(e)
+	if err != nil || n == length {
+		return
+	}
+	return cutString(value), NewTProtocolException(skipBytes(p.trans, length-n))
 }
 
 // Read a []byte from the wire.
@@ -633,8 +678,15 @@ func (p *TCompactProtocol) ReadBinary(ctx context.Context) (value []byte, err er
 	if length == 0 {
 		return []byte{}, nil
 	}
+	n, err := p.limits.readSize(ctx, p.cfg, true, length)
+	if err != nil {
+		return nil, err
+	}
 
-	buf, e := safeReadBytes(length, p.trans)
+	buf, e := safeReadBytes(n, p.trans)
+	if e == nil && n < length {
+		e = skipBytes(p.trans, length-n)
+	}
 	return buf, NewTProtocolException(e)
 }
 
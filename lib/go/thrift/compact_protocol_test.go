@@ -21,6 +21,8 @@ package thrift
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"testing"
 )
 
@@ -58,3 +60,26 @@ func TestReadWriteCompactProtocol(t *testing.T) {
 		trans.Close()
 	}
 }
+
+func TestCompactProtocolListSize(t *testing.T) {
+	ctx := context.Background()
+	buf := NewTMemoryBuffer()
+	if err := NewTCompactProtocolConf(buf, nil).WriteListBegin(ctx, I32, 1000); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		label    string
+		data     []byte
+		expected int
+	}{
+		{"over MaxMessageSize", buf.Bytes(), SIZE_LIMIT},
+		{"negative", []byte{0xf5, 0xff, 0xff, 0xff, 0xff, 0x0f}, NEGATIVE_SIZE},
+	} {
+		p := NewTCompactProtocolConf(&TMemoryBuffer{Buffer: bytes.NewBuffer(c.data)}, &TConfiguration{MaxMessageSize: 100})
+		_, _, err := p.ReadListBegin(ctx)
+		var te TProtocolException
+		if !errors.As(err, &te) || te.TypeId() != c.expected {
+			t.Errorf("%s: expected a TProtocolException of type %d, got %v", c.label, c.expected, err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Compressor is a compression format of TSerializer.WriteCompressed and
+// TDeserializer.ReadCompressed, e.g. the one of NewZlibCompressor, or the zstd
+// one of the thriftzstd contrib module.
+//
+// It should pool its encoders and decoders, as they're expensive to create.
+type Compressor interface {
+	// NewWriter returns a writer compressing into w. Closing it flushes the
+	// end of the compressed stream and releases the writer, without closing
+	// w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader returns a reader decompressing r. Closing it releases the
+	// reader, without closing r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// WriteCompressed writes msg and compresses it with c in a single step,
+// without materializing the uncompressed bytes, see WriteTo.
+func (t *TSerializer) WriteCompressed(ctx context.Context, msg TStruct, c Compressor) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.WriteTo(ctx, w, msg)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteCompressed writes msg with a pooled TSerializer, see
+// TSerializer.WriteCompressed.
+func (t *TSerializerPool) WriteCompressed(ctx context.Context, msg TStruct, c Compressor) ([]byte, error) {
+	s := t.pool.Get().(*TSerializer)
+	defer t.pool.Put(s)
+	return s.WriteCompressed(ctx, msg, c)
+}
+
+var errCompressedTrailingData = errors.New("trailing data after the struct")
+
+var compressedReaderPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReader(nil)
+	},
+}
+
+// ReadCompressed decompresses b with c and reads msg from it in a single step.
+//
+// Like ReadFrom, it fails once the decompressed bytes exceed the
+// MaxMessageSize of the TConfiguration of the protocol, so that the
+// decompression of the untrusted inputs is bounded. The decompressed bytes
+// must hold msg only.
+func (t *TDeserializer) ReadCompressed(ctx context.Context, msg TStruct, b []byte, c Compressor) error {
+	r, err := c.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return NewTProtocolExceptionWithType(INVALID_DATA, err)
+	}
+	defer r.Close()
+	br := compressedReaderPool.Get().(*bufio.Reader)
+	br.Reset(r)
+	defer func() {
+		br.Reset(nil)
+		compressedReaderPool.Put(br)
+	}()
+	if _, err := t.ReadFrom(ctx, br, msg); err != nil {
+		return err
+	}
+	// Reading up to the end also verifies the checksums of the formats
+	// having one at the end of their streams.
+	switch _, err := br.ReadByte(); err {
+	case io.EOF:
+		return nil
+	case nil:
+		return NewTProtocolExceptionWithType(INVALID_DATA, errCompressedTrailingData)
+	default:
+		return NewTProtocolExceptionWithType(INVALID_DATA, err)
+	}
+}
+
+// ReadCompressed reads msg with a pooled TDeserializer, see
+// TDeserializer.ReadCompressed.
+func (t *TDeserializerPool) ReadCompressed(ctx context.Context, msg TStruct, b []byte, c Compressor) error {
+	d := t.pool.Get().(*TDeserializer)
+	defer t.pool.Put(d)
+	return d.ReadCompressed(ctx, msg, b, c)
+}
+
+// compressBytes returns b compressed with c.
+func compressBytes(c Compressor, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(b)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressBytes returns b decompressed with c, failing with a SIZE_LIMIT
+// TProtocolException if that's more than max bytes.
+func decompressBytes(c Compressor, b []byte, max int64) ([]byte, error) {
+	r, err := c.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, NewTProtocolExceptionWithType(INVALID_DATA, err)
+	}
+	defer r.Close()
+	b, err = ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, NewTProtocolExceptionWithType(INVALID_DATA, err)
+	}
+	if int64(len(b)) > max {
+		return nil, NewTProtocolExceptionWithType(SIZE_LIMIT, fmt.Errorf("decompressed to more than %d bytes", max))
+	}
+	return b, nil
+}
+
+// NewZlibCompressor returns the Compressor of the zlib format, compressing at
+// level, one of the levels of compress/zlib, e.g. zlib.BestSpeed.
+func NewZlibCompressor(level int) (Compressor, error) {
+	// Creating the first writer validates level.
+	w, err := zlib.NewWriterLevel(nil, level)
+	if err != nil {
+		return nil, err
+	}
+	c := &tZlibCompressor{level: level}
+	c.writers.Put(w)
+	return c, nil
+}
+
+type tZlibCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (c *tZlibCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if zw, ok := c.writers.Get().(*zlib.Writer); ok {
+		zw.Reset(w)
+		return &tPooledZlibWriter{Writer: zw, c: c}, nil
+	}
+	zw, err := zlib.NewWriterLevel(w, c.level)
+	if err != nil {
+		return nil, err
+	}
+	return &tPooledZlibWriter{Writer: zw, c: c}, nil
+}
+
+func (c *tZlibCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	if zr, ok := c.readers.Get().(io.ReadCloser); ok {
+		if err := zr.(zlib.Resetter).Reset(r, nil); err != nil {
+			c.readers.Put(zr)
+			return nil, err
+		}
+		return &tPooledZlibReader{ReadCloser: zr, c: c}, nil
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &tPooledZlibReader{ReadCloser: zr, c: c}, nil
+}
+
+type tPooledZlibWriter struct {
+	*zlib.Writer
+	c *tZlibCompressor
+}
+
+func (w *tPooledZlibWriter) Close() error {
+	if w.Writer == nil {
+		return nil
+	}
+	err := w.Writer.Close()
+	w.Writer.Reset(nil)
+	w.c.writers.Put(w.Writer)
+	w.Writer = nil
+	return err
+}
+
+type tPooledZlibReader struct {
+	io.ReadCloser
+	c *tZlibCompressor
+}
+
+func (r *tPooledZlibReader) Close() error {
+	if r.ReadCloser == nil {
+		return nil
+	}
+	err := r.ReadCloser.Close()
+	r.c.readers.Put(r.ReadCloser)
+	r.ReadCloser = nil
+	return err
+}
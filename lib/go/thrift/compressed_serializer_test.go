@@ -0,0 +1,129 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCompressedSerializer(t *testing.T) {
+	ctx := context.Background()
+	msg := &MyTestStruct{St: strings.Repeat("compressible ", 1000), E: MyTestEnum_SECOND}
+	for i := 0; i < 100; i++ {
+		msg.StringList = append(msg.StringList, fmt.Sprintf("element %d", i))
+	}
+	zlibCompressor, err := NewZlibCompressor(zlib.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pf := range map[string]TProtocolFactory{
+		"Binary":  NewTBinaryProtocolFactoryConf(nil),
+		"Compact": NewTCompactProtocolFactoryConf(nil),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := plainSerializer(pf).(*TSerializer)
+			plain, err := s.Write(ctx, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			serializers := NewTSerializerPoolSizeFactory(1024, pf)
+			deserializers := NewTDeserializerPoolSizeFactory(1024, pf)
+			for i := 0; i < 3; i++ {
+				b, err := serializers.WriteCompressed(ctx, msg, zlibCompressor)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(b) >= len(plain)/4 {
+					t.Errorf("expected fewer than %d compressed bytes, got %d", len(plain)/4, len(b))
+				}
+				// The output is a plain zlib stream.
+				zr, err := zlib.NewReader(bytes.NewReader(b))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if decompressed, err := ioutil.ReadAll(zr); err != nil || !bytes.Equal(decompressed, plain) {
+					t.Errorf("expected the bytes of Write once decompressed, got %d bytes, err=%v", len(decompressed), err)
+				}
+
+				got := &MyTestStruct{}
+				if err := deserializers.ReadCompressed(ctx, got, b, zlibCompressor); err != nil {
+					t.Fatal(err)
+				}
+				if !Equal(got, msg) {
+					t.Errorf("expected %v, got %v", msg, got)
+				}
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		pf := NewTCompactProtocolFactoryConf(nil)
+		s := plainSerializer(pf).(*TSerializer)
+		b, err := s.WriteCompressed(ctx, msg, zlibCompressor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range []struct {
+			label    string
+			b        []byte
+			conf     *TConfiguration
+			expected int
+		}{
+			{"not compressed", []byte("not compressed"), nil, INVALID_DATA},
+			{"corrupted", append(b[:len(b)-1:len(b)-1], b[len(b)-1]^0xff), nil, INVALID_DATA},
+			{"over MaxMessageSize", b, &TConfiguration{MaxMessageSize: 1000}, SIZE_LIMIT},
+		} {
+			d := plainDeserializer(NewTCompactProtocolFactoryConf(c.conf)).(*TDeserializer)
+			err := d.ReadCompressed(ctx, &MyTestStruct{}, c.b, zlibCompressor)
+			var te TProtocolException
+			if !errors.As(err, &te) || te.TypeId() != c.expected {
+				t.Errorf("%s: expected a TProtocolException of type %d, got %v", c.label, c.expected, err)
+			}
+		}
+
+		var buf bytes.Buffer
+		w, err := zlibCompressor.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := s.Write(ctx, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(append(plain, "trailing"...))
+		w.Close()
+		d := plainDeserializer(pf).(*TDeserializer)
+		if err := d.ReadCompressed(ctx, &MyTestStruct{}, buf.Bytes(), zlibCompressor); !errors.Is(err, errCompressedTrailingData) {
+			t.Errorf("expected errCompressedTrailingData, got %v", err)
+		}
+	})
+
+	if _, err := NewZlibCompressor(42); err == nil {
+		t.Error("expected an error for an invalid level")
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"strings"
+)
+
+// AcceptTransformsHeader is the THeader listing the transforms a peer can
+// read, by name ("zlib"), comma separated, in order of preference, see
+// CompressionNegotiationMiddleware.
+const AcceptTransformsHeader = "thrift-accept-transforms"
+
+// transformNames are the names of the transforms in AcceptTransformsHeader.
+var transformNames = map[THeaderTransformID]string{
+	TransformZlib: "zlib",
+}
+
+// formatTransforms returns the AcceptTransformsHeader of transforms.
+func formatTransforms(transforms []THeaderTransformID) string {
+	names := make([]string, 0, len(transforms))
+	for _, transform := range transforms {
+		if name, ok := transformNames[transform]; ok {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// parseTransforms returns the transforms of an AcceptTransformsHeader,
+// skipping the unknown ones.
+func parseTransforms(header string) []THeaderTransformID {
+	var transforms []THeaderTransformID
+	for _, name := range strings.Split(header, ",") {
+		name = strings.TrimSpace(name)
+		for transform, known := range transformNames {
+			if name == known {
+				transforms = append(transforms, transform)
+			}
+		}
+	}
+	return transforms
+}
+
+// negotiateTransform returns the first of preferred in supported, or
+// TransformNone if there's none.
+func negotiateTransform(preferred, supported []THeaderTransformID) THeaderTransformID {
+	for _, p := range preferred {
+		for _, s := range supported {
+			if p == s {
+				return p
+			}
+		}
+	}
+	return TransformNone
+}
+
+// writeTransformsOf returns the write transforms of a negotiated transform.
+func writeTransformsOf(transform THeaderTransformID) []THeaderTransformID {
+	if transform == TransformNone {
+		return nil
+	}
+	return []THeaderTransformID{transform}
+}
+
+// CompressionNegotiationMiddleware returns a ClientMiddleware negotiating the
+// compression of the messages with the server, so that it can be rolled out
+// on the clients and the servers independently.
+//
+// proto is the THeaderProtocol of the wrapped client, and transforms are the
+// transforms the client supports, in order of preference. Every call
+// advertises them in the AcceptTransformsHeader, and the servers enabling
+// SetCompressionNegotiation compress their replies with the first one they
+// also support, and advertise their own transforms in the
+// AcceptTransformsHeader of the replies. The next requests are then
+// compressed with the first of transforms the server supports, and not
+// compressed if there's none.
+func CompressionNegotiationMiddleware(proto *THeaderProtocol, transforms ...THeaderTransformID) ClientMiddleware {
+	accept := formatTransforms(transforms)
+	return func(next TClient) TClient {
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				meta, err := next.Call(addWriteHeader(ctx, AcceptTransformsHeader, accept), method, args, result)
+				if header, ok := meta.Headers[AcceptTransformsHeader]; ok {
+					transform := negotiateTransform(transforms, parseTransforms(header))
+					proto.SetWriteTransforms(writeTransformsOf(transform))
+				}
+				return meta, err
+			},
+		}
+	}
+}
+
+// negotiateCompression sets the write transforms of the reply of the request
+// whose headers were just read by proto, for a TSimpleServer supporting
+// transforms, see SetCompressionNegotiation.
+func negotiateCompression(proto *THeaderProtocol, transforms []THeaderTransformID) {
+	var transform THeaderTransformID
+	if header, ok := proto.GetReadHeaders()[AcceptTransformsHeader]; ok {
+		transform = negotiateTransform(parseTransforms(header), transforms)
+	}
+	proto.SetWriteTransforms(writeTransformsOf(transform))
+	proto.SetWriteHeader(AcceptTransformsHeader, formatTransforms(transforms))
+}
@@ -0,0 +1,73 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCompressionNegotiation(t *testing.T) {
+	serv, addr := startTestSocketServer(t, echoProcessor(), func(s *TSimpleServer) {
+		s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+		s.outputProtocolFactory = s.inputProtocolFactory
+		s.SetCompressionNegotiation(TransformZlib)
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	value := strings.Repeat("compressible ", 1000)
+	// call returns the bytes written and read by a call of client over
+	// counting.
+	call := func(t *testing.T, client TClient, counting *tByteCountingTransport) (written, read int64) {
+		t.Helper()
+		written, read = atomic.LoadInt64(&counting.written), atomic.LoadInt64(&counting.read)
+		var result echoValue
+		if _, err := client.Call(context.Background(), "echo", &echoValue{value: value}, &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.value != value {
+			t.Fatalf("unexpected result of %d bytes", len(result.value))
+		}
+		return atomic.LoadInt64(&counting.written) - written, atomic.LoadInt64(&counting.read) - read
+	}
+
+	counting := &tByteCountingTransport{TTransport: dialTestSocketServer(t, addr)}
+	proto := NewTHeaderProtocolConf(counting, nil)
+	client := WrapClient(NewTStandardClient(proto, proto), CompressionNegotiationMiddleware(proto, TransformZlib))
+	written, read := call(t, client, counting)
+	if written < int64(len(value)) || read > int64(len(value))/10 {
+		t.Errorf("expected only the reply to be compressed, got %d bytes written and %d read", written, read)
+	}
+	written, read = call(t, client, counting)
+	if written > int64(len(value))/10 || read > int64(len(value))/10 {
+		t.Errorf("expected the next request to be compressed, got %d bytes written and %d read", written, read)
+	}
+
+	// The replies to the clients not advertising any transform are not
+	// compressed.
+	counting = &tByteCountingTransport{TTransport: dialTestSocketServer(t, addr)}
+	proto = NewTHeaderProtocolConf(counting, nil)
+	if _, read := call(t, NewTStandardClient(proto, proto), counting); read < int64(len(value)) {
+		t.Errorf("expected the reply not to be compressed, got %d bytes read", read)
+	}
+}
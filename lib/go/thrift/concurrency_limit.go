@@ -0,0 +1,146 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrencyLimit is the limit of concurrent executions of a method.
+type ConcurrencyLimit struct {
+	// MaxConcurrent is the maximum number of concurrent executions, no limit
+	// if less than 1.
+	MaxConcurrent int
+
+	// MaxQueued is the maximum number of requests waiting for an execution
+	// slot, the requests over it are rejected right away. If less than 1,
+	// the requests are rejected as soon as all the slots are taken.
+	MaxQueued int
+
+	// QueueTimeout is the maximum time a request waits for an execution
+	// slot before being rejected, no timeout if 0.
+	QueueTimeout time.Duration
+}
+
+// ConcurrencyLimitOptions configures ConcurrencyLimitMiddleware.
+type ConcurrencyLimitOptions struct {
+	// Limits are the limits per method name, as set in the processor map.
+	Limits map[string]ConcurrencyLimit
+
+	// Default is the limit of the methods not in Limits.
+	//
+	// Every method has its own slots, Default is not shared between them.
+	Default ConcurrencyLimit
+
+	// OnReject is called for every rejected request, for example to record
+	// metrics.
+	OnReject func(ctx context.Context, method string)
+
+	// RetryAfter, if not 0, is the delay the clients are asked to wait before
+	// retrying the rejected requests, see SetRetryAfter. If negative, they
+	// are asked not to retry them.
+	RetryAfter time.Duration
+
+	// Oneway reports the oneway methods, see OnewayFunc.
+	Oneway OnewayFunc
+}
+
+// ConcurrencyLimitMiddleware returns a ProcessorMiddleware limiting the
+// number of concurrent executions of every method, so that a slow method
+// can't take all the resources of the server from the other ones.
+//
+// The requests rejected, because the wait queue of the method is full or
+// they timed out waiting in it, are replied with a TApplicationException of
+// type INTERNAL_ERROR, and the connection is kept open.
+func ConcurrencyLimitMiddleware(opts ConcurrencyLimitOptions) ProcessorMiddleware {
+	return func(name string, next TProcessorFunction) TProcessorFunction {
+		limit, ok := opts.Limits[name]
+		if !ok {
+			limit = opts.Default
+		}
+		if limit.MaxConcurrent < 1 {
+			return next
+		}
+		l := &tConcurrencyLimiter{
+			limit: limit,
+			slots: make(chan struct{}, limit.MaxConcurrent),
+		}
+		return WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+				if l.acquire(ctx) {
+					defer l.release()
+					return next.Process(ctx, seqID, in, out)
+				}
+				if opts.OnReject != nil {
+					opts.OnReject(ctx, name)
+				}
+				if opts.RetryAfter != 0 {
+					SetRetryAfter(ctx, opts.RetryAfter)
+				}
+				exc := NewTApplicationException(INTERNAL_ERROR, "too many concurrent requests for "+name)
+				if err := skipRequestWithException(ctx, in, out, name, opts.Oneway.messageType(ctx, name), seqID, exc); err != nil {
+					return false, WrapTException(err)
+				}
+				return true, exc
+			},
+		}
+	}
+}
+
+// tConcurrencyLimiter holds the execution slots of a method.
+type tConcurrencyLimiter struct {
+	limit  ConcurrencyLimit
+	slots  chan struct{}
+	queued int32
+}
+
+func (l *tConcurrencyLimiter) acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if atomic.AddInt32(&l.queued, 1) > int32(l.limit.MaxQueued) {
+		atomic.AddInt32(&l.queued, -1)
+		return false
+	}
+	defer atomic.AddInt32(&l.queued, -1)
+
+	var timeout <-chan time.Time
+	if l.limit.QueueTimeout > 0 {
+		timer := time.NewTimer(l.limit.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *tConcurrencyLimiter) release() {
+	<-l.slots
+}
@@ -0,0 +1,166 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// callWithEmptyArgs calls f with a request of empty args on a new buffer.
+func callWithEmptyArgs(f TProcessorFunction) (bool, TException) {
+	ctx := context.Background()
+	proto := NewTBinaryProtocolConf(NewTMemoryBuffer(), nil)
+	proto.WriteStructBegin(ctx, "args")
+	proto.WriteFieldStop(ctx)
+	proto.WriteStructEnd(ctx)
+	return f.Process(ctx, 1, proto, proto)
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	slow := WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			started <- struct{}{}
+			<-release
+			return true, nil
+		},
+	}
+	cheap := WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			return true, nil
+		},
+	}
+	newProcessor := func(limit ConcurrencyLimit, onReject func(context.Context, string)) map[string]TProcessorFunction {
+		return WrapProcessor(&mockWrappableProcessor{
+			ProcessorFuncs: map[string]TProcessorFunction{
+				"slow":  slow,
+				"cheap": cheap,
+			},
+		}, ConcurrencyLimitMiddleware(ConcurrencyLimitOptions{
+			Limits:   map[string]ConcurrencyLimit{"slow": limit},
+			OnReject: onReject,
+		})).ProcessorMap()
+	}
+	expectRejected := func(t *testing.T, ok bool, err TException) {
+		t.Helper()
+		if !ok {
+			t.Error("expected the connection to be kept open")
+		}
+		var tae TApplicationException
+		if !errors.As(err, &tae) || tae.TypeId() != INTERNAL_ERROR {
+			t.Errorf("expected INTERNAL_ERROR, got %v", err)
+		}
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		var rejected int32
+		funcs := newProcessor(ConcurrencyLimit{MaxConcurrent: 1}, func(ctx context.Context, method string) {
+			atomic.AddInt32(&rejected, 1)
+		})
+		first := make(chan TException, 1)
+		go func() {
+			_, err := callWithEmptyArgs(funcs["slow"])
+			first <- err
+		}()
+		<-started
+
+		ok, err := callWithEmptyArgs(funcs["slow"])
+		expectRejected(t, ok, err)
+		if atomic.LoadInt32(&rejected) != 1 {
+			t.Errorf("expected 1 rejected request, got %d", rejected)
+		}
+		// Other methods are not limited by the slow one.
+		if _, err := callWithEmptyArgs(funcs["cheap"]); err != nil {
+			t.Error(err)
+		}
+
+		release <- struct{}{}
+		if err := <-first; err != nil {
+			t.Error(err)
+		}
+		go func() {
+			<-started
+			release <- struct{}{}
+		}()
+		if _, err := callWithEmptyArgs(funcs["slow"]); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		funcs := newProcessor(ConcurrencyLimit{MaxConcurrent: 1, MaxQueued: 1}, nil)
+		first := make(chan TException, 1)
+		go func() {
+			_, err := callWithEmptyArgs(funcs["slow"])
+			first <- err
+		}()
+		<-started
+
+		second := make(chan TException, 1)
+		go func() {
+			_, err := callWithEmptyArgs(funcs["slow"])
+			second <- err
+		}()
+		select {
+		case <-started:
+			t.Fatal("queued request should wait for the first one")
+		case <-time.After(50 * time.Millisecond):
+		}
+		release <- struct{}{}
+		<-started
+		release <- struct{}{}
+		if err := <-first; err != nil {
+			t.Error(err)
+		}
+		if err := <-second; err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		funcs := newProcessor(ConcurrencyLimit{
+			MaxConcurrent: 1,
+			MaxQueued:     1,
+			QueueTimeout:  20 * time.Millisecond,
+		}, nil)
+		first := make(chan TException, 1)
+		go func() {
+			_, err := callWithEmptyArgs(funcs["slow"])
+			first <- err
+		}()
+		<-started
+
+		start := time.Now()
+		ok, err := callWithEmptyArgs(funcs["slow"])
+		expectRejected(t, ok, err)
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+			t.Errorf("expected request to wait for the queue timeout, waited %v", elapsed)
+		}
+		release <- struct{}{}
+		if err := <-first; err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -121,6 +121,10 @@ type TConfiguration struct {
 	// TLS config to be used by TSSLSocket.
 	TLSConfig *tls.Config
 
+	// Listener of the events of the connections opened by TSocket and
+	// TSSLSocket, nil for none.
+	ConnectionListener TConnectionListener
+
 	// Strict read/write configurations for TBinaryProtocol.
 	//
 	// BoolPtr helper function is available to use literal values.
@@ -133,6 +137,25 @@ type TConfiguration struct {
 	// are provided to help filling this value.
 	THeaderProtocolID *THeaderProtocolID
 
+	// Per-field limits of TProtocol implementations: the max size in bytes
+	// of every string and binary value read, and the max number of elements
+	// of every list, set, and map read.
+	//
+	// If <= 0, only MaxMessageSize applies.
+	//
+	// TBinaryProtocol and TCompactProtocol check the size of the strings and
+	// binaries before reading them, so that the values over the limit are
+	// never buffered whole. The JSON protocols, whose values are not prefixed
+	// with their size, check it once read.
+	//
+	// FieldSizePolicy is what happens to the values over their limit,
+	// FieldSizeError by default. FieldSizeTruncate allows a message with one
+	// oversized field, such as a log line, to still be read.
+	MaxStringSize    int32
+	MaxBinarySize    int32
+	MaxContainerSize int32
+	FieldSizePolicy  TFieldSizePolicy
+
 	// Used internally by deprecated constructors, to avoid overriding
 	// underlying TTransport/TProtocol's cfg by accidental propagations.
 	//
@@ -203,6 +226,17 @@ func (tc *TConfiguration) GetTLSConfig() *tls.Config {
 	return tc.TLSConfig
 }
 
+// GetConnectionListener returns the TConnectionListener should be notified by
+// TSocket and TSSLSocket.
+//
+// It's nil-safe. If tc is nil, nil will be returned instead.
+func (tc *TConfiguration) GetConnectionListener() TConnectionListener {
+	if tc == nil {
+		return nil
+	}
+	return tc.ConnectionListener
+}
+
 // GetTBinaryStrictRead returns the strict read configuration TBinaryProtocol
 // should follow.
 //
@@ -245,6 +279,51 @@ func (tc *TConfiguration) GetTHeaderProtocolID() THeaderProtocolID {
 	return protoID
 }
 
+// GetMaxStringSize returns the max size of the string values a TProtocol
+// implementation should read, 0 for no limit other than MaxMessageSize.
+//
+// It's nil-safe. 0 will be returned if tc is nil.
+func (tc *TConfiguration) GetMaxStringSize() int32 {
+	if tc == nil || tc.MaxStringSize <= 0 {
+		return 0
+	}
+	return tc.MaxStringSize
+}
+
+// GetMaxBinarySize returns the max size of the binary values a TProtocol
+// implementation should read, 0 for no limit other than MaxMessageSize.
+//
+// It's nil-safe. 0 will be returned if tc is nil.
+func (tc *TConfiguration) GetMaxBinarySize() int32 {
+	if tc == nil || tc.MaxBinarySize <= 0 {
+		return 0
+	}
+	return tc.MaxBinarySize
+}
+
+// GetMaxContainerSize returns the max number of elements of the containers a
+// TProtocol implementation should read, 0 for no limit other than
+// MaxMessageSize.
+//
+// It's nil-safe. 0 will be returned if tc is nil.
+func (tc *TConfiguration) GetMaxContainerSize() int32 {
+	if tc == nil || tc.MaxContainerSize <= 0 {
+		return 0
+	}
+	return tc.MaxContainerSize
+}
+
+// GetFieldSizePolicy returns the policy for the values over MaxStringSize,
+// MaxBinarySize, and MaxContainerSize.
+//
+// It's nil-safe. FieldSizeError will be returned if tc is nil.
+func (tc *TConfiguration) GetFieldSizePolicy() TFieldSizePolicy {
+	if tc == nil {
+		return FieldSizeError
+	}
+	return tc.FieldSizePolicy
+}
+
 // THeaderProtocolIDPtr validates and returns the pointer to id.
 //
 // If id is not a valid THeaderProtocolID, a pointer to THeaderProtocolDefault
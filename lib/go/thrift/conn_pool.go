@@ -0,0 +1,239 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultConnPoolMaxIdle is the default MaxIdle of ConnPoolOptions.
+const DefaultConnPoolMaxIdle = 8
+
+// errConnPoolClosed is returned by the calls of a closed TConnPool.
+var errConnPoolClosed = NewTTransportException(NOT_OPEN, "connection pool closed")
+
+// ConnPoolOptions configures NewTConnPool.
+type ConnPoolOptions struct {
+	// Dial opens a new connection to the server, e.g. a TSocket, with the
+	// transports the server expects (TFramedTransport, etc.). It must not
+	// be nil. The transport returned may be closed, in which case the call
+	// opens its TSocket or TSSLSocket before the deadline of its context.
+	Dial func(ctx context.Context) (TTransport, error)
+
+	// ProtocolFactory is the protocol of the connections,
+	// TBinaryProtocol if nil.
+	ProtocolFactory TProtocolFactory
+
+	// MaxIdle is the maximum number of idle connections kept open,
+	// DefaultConnPoolMaxIdle if 0.
+	MaxIdle int
+
+	// WarmConns is the number of connections opened by Warm, so that the
+	// first calls don't wait for them to be dialed, up to MaxIdle.
+	WarmConns int
+
+	// PingOnWarm pings the connections opened by Warm, see Ping, so that
+	// they're known to work, and their server is ready, before the first
+	// calls.
+	PingOnWarm bool
+}
+
+// TConnPool is a TClient sending every call over an idle connection of the
+// pool, dialing a new one when there's none, so that concurrent calls use
+// their own connection. It is safe for concurrent use.
+//
+// A connection is put back in the pool after its call unless the call failed
+// with a transport or protocol error, or ctx was done, in which case it's
+// closed.
+type TConnPool struct {
+	opts ConnPoolOptions
+
+	mu     sync.Mutex
+	idle   []*tPooledConn
+	closed bool
+}
+
+// tPooledConn is a connection of a TConnPool.
+type tPooledConn struct {
+	trans  TTransport
+	client *TStandardClient
+}
+
+// NewTConnPool returns a TConnPool configured with opts. It opens no
+// connection, see Warm.
+func NewTConnPool(opts ConnPoolOptions) *TConnPool {
+	if opts.ProtocolFactory == nil {
+		opts.ProtocolFactory = NewTBinaryProtocolFactoryConf(nil)
+	}
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = DefaultConnPoolMaxIdle
+	}
+	if opts.WarmConns > opts.MaxIdle {
+		opts.WarmConns = opts.MaxIdle
+	}
+	return &TConnPool{opts: opts}
+}
+
+// Call implements TClient.
+func (p *TConnPool) Call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	conn, err := p.get(ctx)
+	if err != nil {
+		return ResponseMeta{}, err
+	}
+	meta, err := conn.client.Call(ctx, method, args, result)
+	if reusableConn(ctx, err) {
+		p.put(conn)
+	} else {
+		conn.trans.Close()
+	}
+	return meta, err
+}
+
+// reusableConn reports whether a connection can be reused after a call
+// failing with err.
+func reusableConn(ctx context.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	if ctx.Err() != nil {
+		// The reply may still be on its way.
+		return false
+	}
+	var exc TApplicationException
+	return errors.As(err, &exc) && exc.TypeId() != BAD_SEQUENCE_ID
+}
+
+// Warm opens connections until WarmConns are idle, concurrently, and pings
+// them if PingOnWarm is set, so that the first calls don't pay for the dial
+// and TLS handshake latencies. It's usually called at startup, and when the
+// pool is created for a new address, e.g. in the dial of
+// TBalancedClient.Watch.
+//
+// It returns the first error of the dials or pings, the connections failing
+// being closed.
+func (p *TConnPool) Warm(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errConnPoolClosed
+	}
+	missing := p.opts.WarmConns - len(p.idle)
+	p.mu.Unlock()
+	if missing <= 0 {
+		return nil
+	}
+
+	errs := make(chan error, missing)
+	for i := 0; i < missing; i++ {
+		go func() {
+			conn, err := p.dial(ctx)
+			if err == nil && p.opts.PingOnWarm {
+				if err = Ping(ctx, conn.client); err != nil {
+					conn.trans.Close()
+				}
+			}
+			if err == nil {
+				p.put(conn)
+			}
+			errs <- err
+		}()
+	}
+	var first error
+	for i := 0; i < missing; i++ {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Idle returns the number of idle connections.
+func (p *TConnPool) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// get returns an idle connection, or a new one.
+func (p *TConnPool) get(ctx context.Context) (*tPooledConn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errConnPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		// The most recently used, the least likely to be closed by the
+		// server.
+		conn := p.idle[n-1]
+		p.idle[n-1] = nil
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return conn, nil
+	}
+	p.mu.Unlock()
+	return p.dial(ctx)
+}
+
+func (p *TConnPool) dial(ctx context.Context) (*tPooledConn, error) {
+	trans, err := p.opts.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	proto := p.opts.ProtocolFactory.GetProtocol(trans)
+	client := NewTStandardClient(proto, proto)
+	client.SetTimeouts(TClientTimeouts{OpenClosed: true})
+	return &tPooledConn{
+		trans:  trans,
+		client: client,
+	}, nil
+}
+
+// put puts conn back in the pool, or closes it if the pool is full.
+func (p *TConnPool) put(conn *tPooledConn) {
+	p.mu.Lock()
+	if !p.closed && len(p.idle) < p.opts.MaxIdle {
+		p.idle = append(p.idle, conn)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	conn.trans.Close()
+}
+
+// Close closes the idle connections, and the other ones once their call
+// completes. The later calls fail.
+func (p *TConnPool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+	var first error
+	for _, conn := range idle {
+		if err := conn.trans.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+var _ TClient = (*TConnPool)(nil)
@@ -0,0 +1,102 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTConnPool(t *testing.T) {
+	var conns int32
+	serv, addr := startTestSocketServer(t, echoProcessor(), func(s *TSimpleServer) {
+		s.SetPingReplies(true)
+		s.SetConnContext(func(ctx context.Context, conn TTransport) context.Context {
+			atomic.AddInt32(&conns, 1)
+			return ctx
+		})
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	pool := NewTConnPool(ConnPoolOptions{
+		Dial: func(ctx context.Context) (TTransport, error) {
+			sock, err := NewTSocketConf(addr, &TConfiguration{SocketTimeout: 5 * time.Second})
+			if err != nil {
+				return nil, err
+			}
+			return sock, sock.Open()
+		},
+		WarmConns:  3,
+		PingOnWarm: true,
+	})
+	defer pool.Close()
+	ctx := context.Background()
+
+	if err := pool.Warm(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The pings are replied once the connections are served.
+	if n := atomic.LoadInt32(&conns); n != 3 || pool.Idle() != 3 {
+		t.Fatalf("expected 3 warm connections, got %d served and %d idle", n, pool.Idle())
+	}
+	if err := pool.Warm(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		var result echoValue
+		if _, err := pool.Call(ctx, "echo", &echoValue{value: "warm"}, &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.value != "warm" {
+			t.Errorf("unexpected result %q", result.value)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 3 || pool.Idle() != 3 {
+		t.Errorf("expected the calls to reuse the warm connections, got %d served and %d idle", n, pool.Idle())
+	}
+
+	pool.Close()
+	if pool.Idle() != 0 {
+		t.Errorf("expected the idle connections to be closed, got %d", pool.Idle())
+	}
+	if _, err := pool.Call(ctx, "echo", &echoValue{}, &echoValue{}); err == nil {
+		t.Error("expected the calls to fail once closed")
+	}
+}
+
+func TestTConnPoolWarmError(t *testing.T) {
+	dialErr := errors.New("connection refused")
+	pool := NewTConnPool(ConnPoolOptions{
+		Dial: func(ctx context.Context) (TTransport, error) {
+			return nil, dialErr
+		},
+		WarmConns: 2,
+	})
+	if err := pool.Warm(context.Background()); err != dialErr {
+		t.Errorf("expected the error of the dial, got %v", err)
+	}
+	if pool.Idle() != 0 {
+		t.Errorf("expected no idle connection, got %d", pool.Idle())
+	}
+}
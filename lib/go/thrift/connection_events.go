@@ -0,0 +1,177 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+)
+
+// TConnectionEventType is the type of a TConnectionEvent.
+type TConnectionEventType int
+
+// The types of TConnectionEvent.
+const (
+	// ConnectionDialStart is sent before dialing.
+	ConnectionDialStart TConnectionEventType = iota
+	// ConnectionDialSuccess is sent once connected, with the duration of
+	// the dial.
+	ConnectionDialSuccess
+	// ConnectionDialFailure is sent when the dial fails, with its error
+	// and duration.
+	ConnectionDialFailure
+	// ConnectionHandshake is sent after the TLS handshake of a TSSLSocket,
+	// with its duration, and its error if it failed.
+	ConnectionHandshake
+	// ConnectionClose is sent when the connection is closed, by Close or
+	// by the peer, with how long it was open.
+	ConnectionClose
+	// ConnectionError is sent for every failed read or write of the
+	// connection, including timeouts, but not for the io.EOF of the peer
+	// closing the connection.
+	ConnectionError
+)
+
+func (t TConnectionEventType) String() string {
+	switch t {
+	case ConnectionDialStart:
+		return "dial_start"
+	case ConnectionDialSuccess:
+		return "dial_success"
+	case ConnectionDialFailure:
+		return "dial_failure"
+	case ConnectionHandshake:
+		return "handshake"
+	case ConnectionClose:
+		return "close"
+	case ConnectionError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// TConnectionEvent is an event of a connection opened by a TSocket or a
+// TSSLSocket.
+type TConnectionEvent struct {
+	Type TConnectionEventType
+
+	// Address is the address dialed.
+	Address string
+
+	// Time is when the event happened.
+	Time time.Time
+
+	// Duration is the duration of the dial or of the handshake, or how long
+	// the connection was open for ConnectionClose.
+	Duration time.Duration
+
+	// Err is the error of ConnectionDialFailure, ConnectionHandshake and
+	// ConnectionError.
+	Err error
+}
+
+// TConnectionListener is notified of the events of the connections opened by
+// the TSockets and TSSLSockets whose TConfiguration has it, so that the
+// connection churn of the clients can be observed, for example in metrics.
+//
+// The connections wrapping an existing net.Conn, such as the ones accepted by
+// the servers, have no events.
+//
+// OnConnectionEvent is called synchronously, so it must not block, and
+// concurrently for the different connections.
+type TConnectionListener interface {
+	OnConnectionEvent(event TConnectionEvent)
+}
+
+// TConnectionListenerFunc is a function implementing TConnectionListener.
+type TConnectionListenerFunc func(event TConnectionEvent)
+
+// OnConnectionEvent calls f.
+func (f TConnectionListenerFunc) OnConnectionEvent(event TConnectionEvent) {
+	f(event)
+}
+
+// notifyConnectionEvent notifies listener of the event of the connection to
+// address, if it's non-nil.
+func notifyConnectionEvent(listener TConnectionListener, typ TConnectionEventType, address string, duration time.Duration, err error) {
+	if listener == nil {
+		return
+	}
+	listener.OnConnectionEvent(TConnectionEvent{
+		Type:     typ,
+		Address:  address,
+		Time:     time.Now(),
+		Duration: duration,
+		Err:      err,
+	})
+}
+
+// dialWithEvents dials address, notifying listener of the dial.
+func dialWithEvents(listener TConnectionListener, network, address string, deadline time.Time) (net.Conn, error) {
+	notifyConnectionEvent(listener, ConnectionDialStart, address, 0, nil)
+	start := time.Now()
+	conn, err := (&net.Dialer{Deadline: deadline}).Dial(network, address)
+	if err != nil {
+		notifyConnectionEvent(listener, ConnectionDialFailure, address, time.Since(start), err)
+		return nil, err
+	}
+	notifyConnectionEvent(listener, ConnectionDialSuccess, address, time.Since(start), nil)
+	return conn, nil
+}
+
+// dialTLSWithEvents is tls.DialWithDialer, notifying listener of the dial and
+// of the handshake separately.
+func dialTLSWithEvents(listener TConnectionListener, network, address string, deadline time.Time, config *tls.Config) (net.Conn, error) {
+	rawConn, err := dialWithEvents(listener, network, address, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		config = &tls.Config{}
+	}
+	// As tls.DialWithDialer, verify the host name dialed by default.
+	if config.ServerName == "" {
+		hostname := address
+		if colon := strings.LastIndex(address, ":"); colon >= 0 {
+			hostname = address[:colon]
+		}
+		config = config.Clone()
+		config.ServerName = hostname
+	}
+	conn := tls.Client(rawConn, config)
+	start := time.Now()
+	if !deadline.IsZero() {
+		rawConn.SetDeadline(deadline)
+	}
+	err = conn.Handshake()
+	notifyConnectionEvent(listener, ConnectionHandshake, address, time.Since(start), err)
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	if !deadline.IsZero() {
+		rawConn.SetDeadline(time.Time{})
+	}
+	return conn, nil
+}
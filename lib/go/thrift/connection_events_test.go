@@ -0,0 +1,156 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"crypto/tls"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// connectionEventRecorder is a TConnectionListener recording the events.
+type connectionEventRecorder struct {
+	mu     sync.Mutex
+	events []TConnectionEvent
+}
+
+func (r *connectionEventRecorder) OnConnectionEvent(event TConnectionEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// take returns the types of the events recorded since the last call.
+func (r *connectionEventRecorder) take() []TConnectionEventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := make([]TConnectionEventType, 0, len(r.events))
+	for _, event := range r.events {
+		types = append(types, event.Type)
+	}
+	r.events = nil
+	return types
+}
+
+func TestTSocketConnectionEvents(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	recorder := &connectionEventRecorder{}
+	sock, err := NewTSocketConf(ln.Addr().String(), &TConfiguration{ConnectionListener: recorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sock.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := recorder.take(), []TConnectionEventType{ConnectionDialStart, ConnectionDialSuccess}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected events %v, got %v", expected, got)
+	}
+
+	// The server closing the connection fails the reads with io.EOF, which is
+	// reported as the close of the connection.
+	(<-accepted).Close()
+	if _, err := sock.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the read to fail")
+	}
+	if got, expected := recorder.take(), []TConnectionEventType{ConnectionClose}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected events %v, got %v", expected, got)
+	}
+	sock.Close()
+	if got := recorder.take(); len(got) != 0 {
+		t.Errorf("expected no events, got %v", got)
+	}
+
+	ln.Close()
+	if err := sock.Open(); err == nil {
+		t.Fatal("expected the dial to fail")
+	}
+	if got, expected := recorder.take(), []TConnectionEventType{ConnectionDialStart, ConnectionDialFailure}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected events %v, got %v", expected, got)
+	}
+}
+
+func TestTSSLSocketConnectionEvents(t *testing.T) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCertificate(t)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+
+	recorder := &connectionEventRecorder{}
+	open := func(conf *tls.Config) error {
+		t.Helper()
+		sock, err := NewTSSLSocketConf(ln.Addr().String(), &TConfiguration{
+			TLSConfig:          conf,
+			ConnectionListener: recorder,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sock.Open(); err != nil {
+			return err
+		}
+		return sock.Close()
+	}
+
+	if err := open(&tls.Config{InsecureSkipVerify: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := recorder.take(), []TConnectionEventType{ConnectionDialStart, ConnectionDialSuccess, ConnectionHandshake, ConnectionClose}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected events %v, got %v", expected, got)
+	}
+
+	// The self-signed certificate fails the verification.
+	if err := open(&tls.Config{}); err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+	recorder.mu.Lock()
+	events := recorder.events
+	recorder.mu.Unlock()
+	if len(events) != 3 || events[2].Type != ConnectionHandshake || events[2].Err == nil {
+		t.Errorf("expected a failed handshake, got %+v", events)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// TimeoutHeader is the THeader carrying the time left before the deadline of
+// a call, in milliseconds.
+//
+// The time left is sent instead of the deadline itself, so that it doesn't
+// depend on the clocks of the client and server being in sync.
+const TimeoutHeader = "thrift-timeout-ms"
+
+// DeadlinePropagationMiddleware is a ClientMiddleware sending the deadline of
+// the context of the calls to the server in the TimeoutHeader, so that the
+// server can stop working on them once the client has given up.
+//
+// It requires THeaderProtocol. The calls whose deadline is already exceeded
+// fail with context.DeadlineExceeded without being sent.
+func DeadlinePropagationMiddleware(next TClient) TClient {
+	return WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				return next.Call(ctx, method, args, result)
+			}
+			timeout := time.Until(deadline)
+			if timeout <= 0 {
+				return ResponseMeta{}, context.DeadlineExceeded
+			}
+			// Round up, so that a deadline less than 1ms away isn't sent as
+			// an exceeded one.
+			ms := (timeout + time.Millisecond - 1) / time.Millisecond
+			ctx = addWriteHeader(ctx, TimeoutHeader, strconv.FormatInt(int64(ms), 10))
+			return next.Call(ctx, method, args, result)
+		},
+	}
+}
+
+// ContextWithDeadlineFromHeader returns a copy of ctx with the deadline read
+// from its TimeoutHeader, if any, and the CancelFunc releasing it.
+//
+// TSimpleServer calls it on every request, so it's only needed by custom
+// TServer implementations.
+func ContextWithDeadlineFromHeader(ctx context.Context) (context.Context, context.CancelFunc) {
+	value, ok := GetHeader(ctx, TimeoutHeader)
+	if !ok {
+		return ctx, func() {}
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms < 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+}
@@ -0,0 +1,141 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeadlinePropagationMiddleware(t *testing.T) {
+	var headers map[string]string
+	client := WrapClient(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			headers = make(map[string]string)
+			for _, key := range GetWriteHeaderList(ctx) {
+				headers[key], _ = GetHeader(ctx, key)
+			}
+			return ResponseMeta{}, nil
+		},
+	}, DeadlinePropagationMiddleware)
+
+	ctx := SetHeader(context.Background(), "other", "value")
+	ctx = SetWriteHeaderList(ctx, []string{"other"})
+	if _, err := client.Call(ctx, "method", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := headers[TimeoutHeader]; ok || headers["other"] != "value" {
+		t.Errorf("unexpected headers without deadline: %v", headers)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if _, err := client.Call(timeoutCtx, "method", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := strconv.ParseInt(headers[TimeoutHeader], 10, 64)
+	if err != nil {
+		t.Fatalf("invalid timeout header %q: %v", headers[TimeoutHeader], err)
+	}
+	if ms <= 59000 || ms > 60000 {
+		t.Errorf("expected timeout of about a minute, got %dms", ms)
+	}
+	if headers["other"] != "value" {
+		t.Errorf("expected other headers to be kept, got %v", headers)
+	}
+
+	expiredCtx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	headers = nil
+	if _, err := client.Call(expiredCtx, "method", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if headers != nil {
+		t.Error("expected call with exceeded deadline not to be sent")
+	}
+}
+
+func TestContextWithDeadlineFromHeader(t *testing.T) {
+	for _, c := range []struct {
+		label       string
+		header      string
+		hasDeadline bool
+	}{
+		{"none", "", false},
+		{"valid", "1000", true},
+		{"invalid", "soon", false},
+		{"negative", "-1", false},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			ctx := context.Background()
+			if c.header != "" {
+				ctx = SetHeader(ctx, TimeoutHeader, c.header)
+			}
+			start := time.Now()
+			ctx, cancel := ContextWithDeadlineFromHeader(ctx)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			if ok != c.hasDeadline {
+				t.Fatalf("expected deadline %v, got %v", c.hasDeadline, ok)
+			}
+			if ok && (deadline.Before(start.Add(time.Second)) || deadline.After(time.Now().Add(time.Second))) {
+				t.Errorf("expected deadline in a second, got %v", deadline.Sub(start))
+			}
+		})
+	}
+}
+
+func TestServerDeadlineFromHeader(t *testing.T) {
+	processor := &contextCapturingProcessor{
+		mockProcessor: echoProcessor(),
+		ctxs:          make(chan context.Context, 1),
+	}
+	serv, addr := startTestSocketServer(t, processor, func(s *TSimpleServer) {
+		s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+		s.outputProtocolFactory = s.inputProtocolFactory
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	proto := NewTHeaderProtocolConf(dialTestSocketServer(t, addr), nil)
+	proto.SetWriteHeader(TimeoutHeader, "5000")
+	start := time.Now()
+	if err := echoCall(t, proto, 1, "deadline"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := <-processor.ctxs
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("expected deadline in request context")
+	}
+	if deadline.Before(start.Add(4*time.Second)) || deadline.After(time.Now().Add(5*time.Second)) {
+		t.Errorf("expected deadline in 5s, got %v", deadline.Sub(start))
+	}
+	// The deadline is released once the request is processed.
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("expected request context to be canceled after processing")
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// DeepCopy copies src into dst, pointers to structs of the same type like
+// the generated ones, without sharing their pointers, slices and maps, for
+// example to clone a request before a retry modifies it, or to mirror it:
+//
+//	clone := &MyServiceGetUserArgs{}
+//	if err := thrift.DeepCopy(clone, args); err != nil {
+//		return err
+//	}
+//
+// It copies the fields with reflection instead of serializing src, so the
+// nil pointers, slices and maps stay nil, and the empty ones empty. The
+// unexported fields are copied as is.
+func DeepCopy(dst, src TStruct) error {
+	dv, sv := reflect.ValueOf(dst), reflect.ValueOf(src)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || sv.Kind() != reflect.Ptr || sv.IsNil() {
+		return errors.New("DeepCopy requires non-nil pointers")
+	}
+	if dv.Type() != sv.Type() {
+		return fmt.Errorf("cannot copy %T into %T", src, dst)
+	}
+	if dv == sv {
+		return nil
+	}
+	return deepCopyValue(dv.Elem(), sv.Elem(), DEFAULT_RECURSION_DEPTH)
+}
+
+// deepCopyValue copies src into dst, which must be settable, with at most
+// maxDepth levels of nesting, as the structs with pointers may be cyclic.
+func deepCopyValue(dst, src reflect.Value, maxDepth int) error {
+	if !needsDeepCopy(src.Type()) {
+		dst.Set(src)
+		return nil
+	}
+	if maxDepth <= 0 {
+		return NewTProtocolExceptionWithType(DEPTH_LIMIT, errors.New("Depth limit exceeded"))
+	}
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(src.Type()))
+			return nil
+		}
+		elem := reflect.New(src.Type().Elem())
+		if err := deepCopyValue(elem.Elem(), src.Elem(), maxDepth); err != nil {
+			return err
+		}
+		dst.Set(elem)
+	case reflect.Interface:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(src.Type()))
+			return nil
+		}
+		elem := reflect.New(src.Elem().Type()).Elem()
+		if err := deepCopyValue(elem, src.Elem(), maxDepth); err != nil {
+			return err
+		}
+		dst.Set(elem)
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(src.Type()))
+			return nil
+		}
+		s := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			if err := deepCopyValue(s.Index(i), src.Index(i), maxDepth-1); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			if err := deepCopyValue(dst.Index(i), src.Index(i), maxDepth-1); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(src.Type()))
+			return nil
+		}
+		m := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			key := reflect.New(src.Type().Key()).Elem()
+			if err := deepCopyValue(key, iter.Key(), maxDepth-1); err != nil {
+				return err
+			}
+			value := reflect.New(src.Type().Elem()).Elem()
+			if err := deepCopyValue(value, iter.Value(), maxDepth-1); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		dst.Set(m)
+	case reflect.Struct:
+		// The unexported fields can't be set by reflection, they're copied
+		// with the struct.
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if src.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if err := deepCopyValue(dst.Field(i), src.Field(i), maxDepth-1); err != nil {
+				return err
+			}
+		}
+	default:
+		dst.Set(src)
+	}
+	return nil
+}
+
+// deepCopyTypes caches whether the types need a deep copy.
+var deepCopyTypes sync.Map
+
+// needsDeepCopy reports whether the values of t may share memory, through
+// pointers, slices, maps or interfaces, and so can't just be assigned.
+func needsDeepCopy(t reflect.Type) bool {
+	if needs, ok := deepCopyTypes.Load(t); ok {
+		return needs.(bool)
+	}
+	// The recursive types are through pointers, slices or maps, which need
+	// a deep copy, so the recursion on t stops with true.
+	deepCopyTypes.Store(t, true)
+	needs := true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+	case reflect.Array:
+		needs = needsDeepCopy(t.Elem())
+	case reflect.Struct:
+		needs = false
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" && needsDeepCopy(t.Field(i).Type) {
+				needs = true
+				break
+			}
+		}
+	default:
+		// The scalars, and the channels and functions, which are shared.
+		needs = false
+	}
+	deepCopyTypes.Store(t, needs)
+	return needs
+}
@@ -0,0 +1,129 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type deepCopyTestArgs struct {
+	User  *reflectTestUser `thrift:"user,1"`
+	Empty []string         `thrift:"empty,2"`
+	Nil   map[string]int32 `thrift:"nil,3"`
+}
+
+func (a *deepCopyTestArgs) Read(ctx context.Context, in TProtocol) error {
+	return DecodeStruct(ctx, in, a)
+}
+
+func (a *deepCopyTestArgs) Write(ctx context.Context, out TProtocol) error {
+	return EncodeStruct(ctx, out, a)
+}
+
+func deepCopyTestUser() *reflectTestUser {
+	name := "alice"
+	return &reflectTestUser{
+		ID:        1,
+		Name:      &name,
+		Roles:     []string{"admin"},
+		Scores:    map[string]float64{"math": 9.5},
+		Address:   &reflectTestAddress{City: "Paris"},
+		Previous:  []reflectTestAddress{{City: "Lyon"}},
+		Avatar:    []byte{1, 2},
+		Flags:     map[int16][]bool{1: {true}},
+		Generated: &MyTestStruct{St: "hello", StringSet: map[string]struct{}{"a": {}}},
+		Friends:   []*reflectTestUser{{ID: 2}},
+		Labels:    map[reflectTestAddress]float32{{City: "Nice"}: 1},
+		Cache:     "cached",
+	}
+}
+
+func TestDeepCopy(t *testing.T) {
+	src := &deepCopyTestArgs{
+		User:  deepCopyTestUser(),
+		Empty: []string{},
+	}
+	dst := &deepCopyTestArgs{}
+	if err := DeepCopy(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, src) {
+		t.Fatalf("expected %+v, got %+v", src, dst)
+	}
+	if dst.Empty == nil || dst.Nil != nil {
+		t.Errorf("expected the empty and nil fields to stay so, got %#v, %#v", dst.Empty, dst.Nil)
+	}
+
+	// The copy shares nothing with src.
+	u := src.User
+	*u.Name = "bob"
+	u.Roles[0] = "guest"
+	u.Scores["math"] = 0
+	u.Address.City = "Rome"
+	u.Previous[0].City = "Milan"
+	u.Avatar[0] = 0
+	u.Flags[1][0] = false
+	u.Generated.StringSet["b"] = struct{}{}
+	u.Friends[0].ID = 3
+	if expected := deepCopyTestUser(); !reflect.DeepEqual(dst.User, expected) {
+		t.Errorf("the copy changed with its source: expected %+v, got %+v", expected, dst.User)
+	}
+}
+
+func TestDeepCopyErrors(t *testing.T) {
+	if err := DeepCopy(&deepCopyTestArgs{}, &MyTestStruct{}); err == nil {
+		t.Error("expected an error for structs of different types")
+	}
+	if err := DeepCopy(&deepCopyTestArgs{}, (*deepCopyTestArgs)(nil)); err == nil {
+		t.Error("expected an error for a nil source")
+	}
+
+	cyclic := &reflectTestUser{ID: 1}
+	cyclic.Friends = []*reflectTestUser{cyclic}
+	err := DeepCopy(&deepCopyTestArgs{}, &deepCopyTestArgs{User: cyclic})
+	if e, ok := err.(TProtocolException); !ok || e.TypeId() != DEPTH_LIMIT {
+		t.Errorf("expected a DEPTH_LIMIT error for a cyclic struct, got %v", err)
+	}
+}
+
+func BenchmarkDeepCopy(b *testing.B) {
+	src := &deepCopyTestArgs{User: deepCopyTestUser()}
+	b.Run("DeepCopy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := DeepCopy(&deepCopyTestArgs{}, src); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Serializer", func(b *testing.B) {
+		ctx := context.Background()
+		for i := 0; i < b.N; i++ {
+			buf := NewTMemoryBuffer()
+			if err := src.Write(ctx, NewTBinaryProtocolConf(buf, nil)); err != nil {
+				b.Fatal(err)
+			}
+			if err := (&deepCopyTestArgs{}).Read(ctx, NewTBinaryProtocolConf(buf, nil)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -20,13 +20,23 @@
 package thrift
 
 import (
+	"bufio"
 	"context"
+	"fmt"
+	"io"
 	"sync"
 )
 
 type TDeserializer struct {
 	Transport *TMemoryBuffer
 	Protocol  TProtocol
+
+	// ProtocolFactory creates the protocol of ReadFrom. If nil, the factory
+	// of Protocol is used, if it's one of the protocols of this package.
+	ProtocolFactory TProtocolFactory
+
+	// The transport of ReadFrom, reused between calls.
+	in *tLimitedReadTransport
 }
 
 func NewTDeserializer() *TDeserializer {
@@ -47,6 +57,7 @@ func (t *TDeserializer) ReadString(ctx context.Context, msg TStruct, s string) (
 		return
 	}
 	if err = msg.Read(ctx, t.Protocol); err != nil {
+		resetFieldSizeLimits(t.Protocol)
 		return
 	}
 	return
@@ -60,11 +71,112 @@ func (t *TDeserializer) Read(ctx context.Context, msg TStruct, b []byte) (err er
 		return
 	}
 	if err = msg.Read(ctx, t.Protocol); err != nil {
+		resetFieldSizeLimits(t.Protocol)
 		return
 	}
 	return
 }
 
+// ReadFrom reads msg from r as it's decoded, instead of requiring the whole
+// input in memory like Read, for the untrusted or very large inputs. It
+// returns the number of bytes of r consumed.
+//
+// The TConfiguration of the protocol, created with ProtocolFactory, is
+// applied to the whole input: ReadFrom fails with a SIZE_LIMIT
+// TProtocolException once it has read MaxMessageSize bytes, besides the limits
+// on the sizes of the strings and containers checked by the protocols.
+//
+// If r doesn't implement io.ByteReader, it's buffered, so that ReadFrom may
+// read past the end of msg. To read consecutive structs from r, wrap it in a
+// bufio.Reader first, and don't use the JSON protocols, which buffer their
+// input too.
+func (t *TDeserializer) ReadFrom(ctx context.Context, r io.Reader, msg TStruct) (int64, error) {
+	factory := t.ProtocolFactory
+	if factory == nil {
+		if factory = protocolFactoryOf(t.Protocol); factory == nil {
+			return 0, fmt.Errorf("thrift: TDeserializer.ReadFrom requires a ProtocolFactory for %T", t.Protocol)
+		}
+	}
+	if t.in == nil {
+		t.in = &tLimitedReadTransport{}
+	}
+	t.in.reset(r)
+	defer t.in.reset(nil)
+	// The protocol isn't reused, as the JSON ones keep the bytes they read
+	// ahead.
+	err := msg.Read(ctx, factory.GetProtocol(t.in))
+	return t.in.n, err
+}
+
+// tLimitedReadTransport is the transport of TDeserializer.ReadFrom, reading at
+// most the MaxMessageSize of its TConfiguration.
+type tLimitedReadTransport struct {
+	r   io.Reader
+	buf *bufio.Reader
+	n   int64
+	cfg *TConfiguration
+}
+
+func (t *tLimitedReadTransport) reset(r io.Reader) {
+	t.n, t.cfg = 0, nil
+	if _, ok := r.(io.ByteReader); ok || r == nil {
+		t.r = r
+		return
+	}
+	if t.buf == nil {
+		t.buf = bufio.NewReader(r)
+	} else {
+		t.buf.Reset(r)
+	}
+	t.r = t.buf
+}
+
+func (t *tLimitedReadTransport) Read(p []byte) (int, error) {
+	remaining := int64(t.cfg.GetMaxMessageSize()) - t.n
+	if remaining <= 0 {
+		return 0, NewTProtocolExceptionWithType(
+			SIZE_LIMIT,
+			fmt.Errorf("message exceeded max allowed size: %d", t.cfg.GetMaxMessageSize()),
+		)
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	return n, err
+}
+
+func (t *tLimitedReadTransport) Write(p []byte) (int, error) {
+	return 0, NewTTransportException(NOT_OPEN, "cannot write to a TDeserializer")
+}
+
+func (t *tLimitedReadTransport) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (t *tLimitedReadTransport) RemainingBytes() uint64 {
+	return uint64(int64(t.cfg.GetMaxMessageSize()) - t.n)
+}
+
+func (t *tLimitedReadTransport) Open() error {
+	return nil
+}
+
+func (t *tLimitedReadTransport) IsOpen() bool {
+	return true
+}
+
+func (t *tLimitedReadTransport) Close() error {
+	return nil
+}
+
+// SetTConfiguration implements TConfigurationSetter, for the protocols to
+// propagate their TConfiguration.
+func (t *tLimitedReadTransport) SetTConfiguration(cfg *TConfiguration) {
+	t.cfg = cfg
+}
+
 // TDeserializerPool is the thread-safe version of TDeserializer,
 // it uses resource pool of TDeserializer under the hood.
 //
@@ -100,8 +212,9 @@ func NewTDeserializerPoolSizeFactory(size int, factory TProtocolFactory) *TDeser
 				protocol := factory.GetProtocol(transport)
 
 				return &TDeserializer{
-					Transport: transport,
-					Protocol:  protocol,
+					Transport:       transport,
+					Protocol:        protocol,
+					ProtocolFactory: factory,
 				}
 			},
 		},
@@ -119,3 +232,11 @@ func (t *TDeserializerPool) Read(ctx context.Context, msg TStruct, b []byte) err
 	defer t.pool.Put(d)
 	return d.Read(ctx, msg, b)
 }
+
+// ReadFrom reads msg from r with a pooled TDeserializer, see
+// TDeserializer.ReadFrom.
+func (t *TDeserializerPool) ReadFrom(ctx context.Context, r io.Reader, msg TStruct) (int64, error) {
+	d := t.pool.Get().(*TDeserializer)
+	defer t.pool.Put(d)
+	return d.ReadFrom(ctx, r, msg)
+}
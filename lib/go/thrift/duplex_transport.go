@@ -0,0 +1,426 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// THeaderFlagDuplexReverse is the THeader flag set on the frames of the calls
+// initiated by the server side of a TDuplexTransport connection, and of their
+// replies, as in the duplex mode of fbthrift.
+const THeaderFlagDuplexReverse uint32 = 0x0008
+
+// TDuplexTransport multiplexes two independent call directions over a single
+// connection, so that a server can call back into the connected client.
+//
+// Each side of the connection sees two logical transports:
+//
+// * CallTransport, for the calls initiated by this side (and their replies);
+//
+// * ServeTransport, for the calls initiated by the peer (and our replies).
+//
+// The frames are THeader frames, to be read and written with THeaderProtocol,
+// routed between the two transports by THeaderFlagDuplexReverse, so calls in
+// both directions can be in progress at the same time. The frames of the
+// calls initiated by the client side can also be plain framed messages.
+//
+// The frames read are queued until read from their transport, so that the
+// calls of the peer waiting to be served never hold back the replies to our
+// own calls, for example the ones made by the handler of a call of the peer.
+//
+// On the client side, the usual setup is:
+//
+//	duplex := thrift.NewTDuplexTransport(socket, false, conf)
+//	if err := duplex.Open(); err != nil { ... }
+//	protoFactory := thrift.NewTHeaderProtocolFactoryConf(conf)
+//	duplex.SetProcessor(callbackProcessor, protoFactory)
+//	proto := protoFactory.GetProtocol(duplex.CallTransport())
+//	client := NewMyServiceClient(thrift.NewTStandardClient(proto, proto))
+//
+// On the server side, wrap the server transport with
+// NewTDuplexServerTransport and use TDuplexTransportFromTransport in a
+// TProcessorFactory to get the reverse client for each connection.
+type TDuplexTransport struct {
+	trans    TTransport
+	isServer bool
+	cfg      *TConfiguration
+
+	writeMu sync.Mutex
+
+	startOnce sync.Once
+	started   int32
+	readDone  chan struct{}
+
+	callView  *tDuplexView
+	serveView *tDuplexView
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewTDuplexTransport creates a TDuplexTransport on top of trans, which should
+// be a raw socket transport (TSocket, TSSLSocket, etc.).
+//
+// isServer should be true for the side of the connection that accepted it.
+func NewTDuplexTransport(trans TTransport, isServer bool, conf *TConfiguration) *TDuplexTransport {
+	PropagateTConfiguration(trans, conf)
+	d := &TDuplexTransport{
+		trans:    trans,
+		isServer: isServer,
+		cfg:      conf,
+		readDone: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	d.callView = newTDuplexView(d, isServer)
+	d.serveView = newTDuplexView(d, !isServer)
+	return d
+}
+
+// CallTransport returns the TTransport used to make calls to the peer.
+func (d *TDuplexTransport) CallTransport() TTransport {
+	return d.callView
+}
+
+// ServeTransport returns the TTransport used to serve the calls made by the
+// peer.
+func (d *TDuplexTransport) ServeTransport() TTransport {
+	return d.serveView
+}
+
+// SetProcessor starts serving the calls made by the peer with processor, in a
+// separated goroutine, factory being usually a THeaderProtocolFactory.
+//
+// It's usually used on the client side, to answer the calls made by the
+// server.
+// The goroutine exits when the connection is closed.
+//
+// If the peer makes calls on this connection, a processor must be set,
+// otherwise its calls are queued until the connection is closed.
+func (d *TDuplexTransport) SetProcessor(processor TProcessor, factory TProtocolFactory) {
+	go d.Serve(defaultCtx, processor, factory)
+}
+
+// Serve serves the calls made by the peer with processor, until the connection
+// is closed or a transport error happens.
+func (d *TDuplexTransport) Serve(ctx context.Context, processor TProcessor, factory TProtocolFactory) error {
+	proto := factory.GetProtocol(d.serveView)
+	for {
+		ok, err := processor.Process(ctx, proto, proto)
+		if errors.As(err, new(TTransportException)) {
+			return treatEOFErrorsAsNil(err)
+		}
+		if !ok {
+			return err
+		}
+	}
+}
+
+// Open opens the underlying transport.
+func (d *TDuplexTransport) Open() error {
+	return d.trans.Open()
+}
+
+// IsOpen returns whether the underlying transport is open.
+func (d *TDuplexTransport) IsOpen() bool {
+	return d.trans.IsOpen()
+}
+
+// Close closes the underlying transport, which stops both directions.
+func (d *TDuplexTransport) Close() error {
+	var err error
+	d.closeOnce.Do(func() {
+		close(d.closed)
+		// Interrupt the read loop and wait for it to exit before closing,
+		// as TSocket.Close is not safe to call concurrently with Read.
+		if atomic.LoadInt32(&d.started) != 0 {
+			if i, ok := d.trans.(interface{ Interrupt() error }); ok {
+				i.Interrupt()
+				<-d.readDone
+			}
+		}
+		err = d.trans.Close()
+	})
+	return err
+}
+
+// SetTConfiguration implements TConfigurationSetter.
+func (d *TDuplexTransport) SetTConfiguration(conf *TConfiguration) {
+	PropagateTConfiguration(d.trans, conf)
+	d.cfg = conf
+}
+
+func (d *TDuplexTransport) start() {
+	d.startOnce.Do(func() {
+		atomic.StoreInt32(&d.started, 1)
+		go d.readLoop()
+	})
+}
+
+// readLoop reads the frames and queues them on their view, without ever
+// waiting for them to be read.
+func (d *TDuplexTransport) readLoop() {
+	defer close(d.readDone)
+	for {
+		frame, reverse, err := d.readFrame()
+		if err != nil {
+			// Deliver the error to both directions.
+			d.callView.push(nil, err)
+			d.serveView.push(nil, err)
+			return
+		}
+		// Reverse frames on the server are replies to its calls, and on
+		// the client are calls from the server.
+		if reverse == d.isServer {
+			d.callView.push(frame, nil)
+		} else {
+			d.serveView.push(frame, nil)
+		}
+	}
+}
+
+// readFrame reads a frame, along with its size, and reports whether it's a
+// THeader frame with THeaderFlagDuplexReverse.
+func (d *TDuplexTransport) readFrame() (frame []byte, reverse bool, err error) {
+	var sizeBuf [size32]byte
+	if _, err = io.ReadFull(d.trans, sizeBuf[:]); err != nil {
+		return nil, false, NewTTransportExceptionFromError(err)
+	}
+	size := binary.BigEndian.Uint32(sizeBuf[:])
+	if size < 1 || size > THeaderMaxFrameSize || size > uint32(d.cfg.GetMaxFrameSize()) {
+		return nil, false, NewTTransportException(
+			UNKNOWN_TRANSPORT_EXCEPTION,
+			fmt.Sprintf("Incorrect duplex frame size (%d)", size),
+		)
+	}
+	frame = make([]byte, size32+int(size))
+	copy(frame, sizeBuf[:])
+	if _, err = io.ReadFull(d.trans, frame[size32:]); err != nil {
+		return nil, false, NewTTransportExceptionFromError(err)
+	}
+	if magicFlags, ok := duplexMagicFlags(frame); ok {
+		reverse = magicFlags&THeaderFlagDuplexReverse != 0
+	}
+	return frame, reverse, nil
+}
+
+// duplexMagicFlags returns the magic and flags of frame, if it's a THeader
+// frame.
+func duplexMagicFlags(frame []byte) (uint32, bool) {
+	if len(frame) < 2*size32 {
+		return 0, false
+	}
+	magicFlags := binary.BigEndian.Uint32(frame[size32:])
+	return magicFlags, magicFlags&THeaderHeaderMask == THeaderHeaderMagic
+}
+
+// writeFrame writes frame, a frame along with its size, setting its
+// THeaderFlagDuplexReverse if reverse.
+func (d *TDuplexTransport) writeFrame(ctx context.Context, reverse bool, frame []byte) error {
+	magicFlags, ok := duplexMagicFlags(frame)
+	switch {
+	case ok && reverse:
+		magicFlags |= THeaderFlagDuplexReverse
+	case ok:
+		magicFlags &^= THeaderFlagDuplexReverse
+	case reverse:
+		return NewTTransportException(
+			UNKNOWN_TRANSPORT_EXCEPTION,
+			"duplex calls from the server side must be THeader frames",
+		)
+	}
+	if ok {
+		binary.BigEndian.PutUint32(frame[size32:], magicFlags)
+	}
+
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	if _, err := d.trans.Write(frame); err != nil {
+		return NewTTransportExceptionFromError(err)
+	}
+	return NewTTransportExceptionFromError(d.trans.Flush(ctx))
+}
+
+// tDuplexView is one direction of a TDuplexTransport.
+type tDuplexView struct {
+	duplex  *TDuplexTransport
+	reverse bool
+
+	// The frames read for this direction, and the error ending them.
+	mu      sync.Mutex
+	frames  [][]byte
+	readErr error
+	// Signaled when a frame or the error is pushed.
+	pushed chan struct{}
+
+	readBuf  bytes.Reader
+	writeBuf bytes.Buffer
+}
+
+func newTDuplexView(duplex *TDuplexTransport, reverse bool) *tDuplexView {
+	return &tDuplexView{
+		duplex:  duplex,
+		reverse: reverse,
+		pushed:  make(chan struct{}, 1),
+	}
+}
+
+// push queues a frame read, or the error ending them if err is not nil.
+func (v *tDuplexView) push(frame []byte, err error) {
+	v.mu.Lock()
+	if err != nil {
+		v.readErr = err
+	} else {
+		v.frames = append(v.frames, frame)
+	}
+	v.mu.Unlock()
+	select {
+	case v.pushed <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next frame, waiting for it if none is queued.
+func (v *tDuplexView) next() ([]byte, error) {
+	for {
+		v.mu.Lock()
+		if len(v.frames) > 0 {
+			frame := v.frames[0]
+			v.frames[0] = nil
+			v.frames = v.frames[1:]
+			v.mu.Unlock()
+			return frame, nil
+		}
+		err := v.readErr
+		v.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-v.pushed:
+		case <-v.duplex.closed:
+			return nil, NewTTransportException(END_OF_FILE, "duplex transport closed")
+		}
+	}
+}
+
+func (v *tDuplexView) Open() error {
+	return v.duplex.Open()
+}
+
+func (v *tDuplexView) IsOpen() bool {
+	return v.duplex.IsOpen()
+}
+
+func (v *tDuplexView) Close() error {
+	return v.duplex.Close()
+}
+
+func (v *tDuplexView) Read(buf []byte) (int, error) {
+	if v.readBuf.Len() == 0 {
+		v.duplex.start()
+		frame, err := v.next()
+		if err != nil {
+			return 0, err
+		}
+		v.readBuf.Reset(frame)
+	}
+	return v.readBuf.Read(buf)
+}
+
+func (v *tDuplexView) Write(buf []byte) (int, error) {
+	return v.writeBuf.Write(buf)
+}
+
+// Flush writes the frame written since the last call, along with its size, as
+// written by THeaderTransport.
+func (v *tDuplexView) Flush(ctx context.Context) error {
+	if v.writeBuf.Len() == 0 {
+		return nil
+	}
+	defer v.writeBuf.Reset()
+	v.duplex.start()
+	return v.duplex.writeFrame(ctx, v.reverse, v.writeBuf.Bytes())
+}
+
+func (v *tDuplexView) RemainingBytes() (num_bytes uint64) {
+	return uint64(v.readBuf.Len())
+}
+
+// TDuplexTransportFromTransport returns the TDuplexTransport trans belongs to,
+// if trans is one of the views returned by CallTransport or ServeTransport.
+//
+// It's usually used in a TProcessorFactory to get the reverse client of the
+// connection:
+//
+//	func (f *myProcessorFactory) GetProcessor(trans thrift.TTransport) thrift.TProcessor {
+//		duplex, _ := thrift.TDuplexTransportFromTransport(trans)
+//		proto := thrift.NewTHeaderProtocolConf(duplex.CallTransport(), f.conf)
+//		callback := NewCallbackClient(thrift.NewTStandardClient(proto, proto))
+//		return NewMyServiceProcessor(&handler{callback: callback})
+//	}
+func TDuplexTransportFromTransport(trans TTransport) (*TDuplexTransport, bool) {
+	if v, ok := trans.(*tDuplexView); ok {
+		return v.duplex, true
+	}
+	return nil, false
+}
+
+// tDuplexServerTransport wraps a TServerTransport to accept TDuplexTransports.
+type tDuplexServerTransport struct {
+	TServerTransport
+
+	cfg *TConfiguration
+}
+
+// NewTDuplexServerTransport wraps trans so that every accepted connection is
+// a TDuplexTransport.
+//
+// The TTransport returned by Accept is the ServeTransport of the connection.
+// The server should be configured with plain (non-framed) transport factories
+// and a THeaderProtocolFactory, as the THeader frames are routed by
+// TDuplexTransport.
+func NewTDuplexServerTransport(trans TServerTransport, conf *TConfiguration) TServerTransport {
+	return &tDuplexServerTransport{
+		TServerTransport: trans,
+		cfg:              conf,
+	}
+}
+
+func (t *tDuplexServerTransport) Accept() (TTransport, error) {
+	trans, err := t.TServerTransport.Accept()
+	if err != nil || trans == nil {
+		return trans, err
+	}
+	return NewTDuplexTransport(trans, true, t.cfg).ServeTransport(), nil
+}
+
+var (
+	_ TTransport           = (*tDuplexView)(nil)
+	_ TConfigurationSetter = (*TDuplexTransport)(nil)
+	_ TServerTransport     = (*tDuplexServerTransport)(nil)
+)
@@ -0,0 +1,252 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoCall makes an "echo" call over proto and checks the reply.
+func echoCall(t *testing.T, proto TProtocol, seqID int32, value string) error {
+	t.Helper()
+	ctx := context.Background()
+	proto.WriteMessageBegin(ctx, "echo", CALL, seqID)
+	proto.WriteString(ctx, value)
+	proto.WriteMessageEnd(ctx)
+	if err := proto.Flush(ctx); err != nil {
+		return err
+	}
+	_, _, rSeqID, err := proto.ReadMessageBegin(ctx)
+	if err != nil {
+		return err
+	}
+	got, err := proto.ReadString(ctx)
+	if err != nil {
+		return err
+	}
+	proto.ReadMessageEnd(ctx)
+	if rSeqID != seqID || got != value {
+		return fmt.Errorf("expected %q (%d), got %q (%d)", value, seqID, got, rSeqID)
+	}
+	return nil
+}
+
+func TestDuplexTransportBothDirections(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	factory := NewTHeaderProtocolFactoryConf(nil)
+
+	client := NewTDuplexTransport(NewTSocketFromConnConf(clientConn, nil), false, nil)
+	defer client.Close()
+	server := NewTDuplexTransport(NewTSocketFromConnConf(serverConn, nil), true, nil)
+	defer server.Close()
+
+	client.SetProcessor(echoProcessor(), factory)
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- server.Serve(context.Background(), echoProcessor(), factory)
+	}()
+
+	var wg sync.WaitGroup
+	for _, side := range []*TDuplexTransport{client, server} {
+		wg.Add(1)
+		go func(d *TDuplexTransport) {
+			defer wg.Done()
+			proto := factory.GetProtocol(d.CallTransport())
+			for i := int32(1); i <= 50; i++ {
+				if err := echoCall(t, proto, i, fmt.Sprintf("value-%d", i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(side)
+	}
+	wg.Wait()
+
+	client.Close()
+	if err := <-serveDone; err != nil {
+		t.Errorf("expected Serve to exit cleanly on EOF, got %v", err)
+	}
+}
+
+func TestDuplexServerTransport(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	serverTrans := NewTDuplexServerTransport(&mockServerTransport{
+		ListenFunc: func() error { return nil },
+		AcceptFunc: func() (TTransport, error) {
+			return NewTSocketFromConnConf(serverConn, nil), nil
+		},
+		CloseFunc:     func() error { return nil },
+		InterruptFunc: func() error { return nil },
+	}, nil)
+
+	trans, err := serverTrans.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplex, ok := TDuplexTransportFromTransport(trans)
+	if !ok {
+		t.Fatal("expected accepted transport to belong to a TDuplexTransport")
+	}
+	if _, ok := TDuplexTransportFromTransport(NewTMemoryBuffer()); ok {
+		t.Error("expected plain transport not to belong to a TDuplexTransport")
+	}
+
+	factory := NewTHeaderProtocolFactoryConf(nil)
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- duplex.Serve(context.Background(), echoProcessor(), factory)
+	}()
+
+	client := NewTDuplexTransport(NewTSocketFromConnConf(clientConn, nil), false, nil)
+	client.SetProcessor(echoProcessor(), factory)
+	if err := echoCall(t, factory.GetProtocol(client.CallTransport()), 1, "forward"); err != nil {
+		t.Error(err)
+	}
+	if err := echoCall(t, factory.GetProtocol(duplex.CallTransport()), 1, "reverse"); err != nil {
+		t.Error(err)
+	}
+
+	// Close the client first so the server read loop exits before its
+	// socket is closed.
+	client.Close()
+	<-serveDone
+	duplex.Close()
+}
+
+func TestDuplexTransportTHeaderPeer(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	server := NewTDuplexTransport(NewTSocketFromConnConf(serverConn, nil), true, nil)
+	defer server.Close()
+	factory := NewTHeaderProtocolFactoryConf(nil)
+	go server.Serve(context.Background(), echoProcessor(), factory)
+
+	// A plain THeader client, unaware of the duplex framing.
+	clientTrans := NewTHeaderTransportConf(NewTSocketFromConnConf(clientConn, nil), nil)
+	defer clientTrans.Close()
+	clientProto := NewTHeaderProtocolConf(clientTrans, nil)
+	if err := echoCall(t, clientProto, 1, "forward"); err != nil {
+		t.Fatal(err)
+	}
+	if clientTrans.Flags&THeaderFlagDuplexReverse != 0 {
+		t.Error("expected the reply to a client call not to have the duplex reverse flag")
+	}
+
+	reverseDone := make(chan error, 1)
+	go func() {
+		reverseDone <- echoCall(t, factory.GetProtocol(server.CallTransport()), 1, "reverse")
+	}()
+	ctx := context.Background()
+	name, _, seqID, err := clientProto.ReadMessageBegin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientTrans.Flags&THeaderFlagDuplexReverse == 0 {
+		t.Error("expected the server call to have the duplex reverse flag")
+	}
+	value, _ := clientProto.ReadString(ctx)
+	clientProto.ReadMessageEnd(ctx)
+	// The THeader transport keeps the flags of the call in its reply.
+	clientProto.WriteMessageBegin(ctx, name, REPLY, seqID)
+	clientProto.WriteString(ctx, value)
+	clientProto.WriteMessageEnd(ctx)
+	if err := clientProto.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-reverseDone; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDuplexTransportCallbackFromHandler(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	factory := NewTHeaderProtocolFactoryConf(nil)
+
+	client := NewTDuplexTransport(NewTSocketFromConnConf(clientConn, nil), false, nil)
+	defer client.Close()
+	server := NewTDuplexTransport(NewTSocketFromConnConf(serverConn, nil), true, nil)
+	defer server.Close()
+
+	// The server calls back into the client before replying to each of
+	// its calls, while the client keeps calling.
+	callback := factory.GetProtocol(server.CallTransport())
+	go server.Serve(context.Background(), &mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			ctx := context.Background()
+			name, _, seqID, err := in.ReadMessageBegin(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			value, _ := in.ReadString(ctx)
+			in.ReadMessageEnd(ctx)
+			if err := echoCall(t, callback, seqID, "callback-"+value); err != nil {
+				return false, WrapTException(err)
+			}
+			out.WriteMessageBegin(ctx, name, REPLY, seqID)
+			out.WriteString(ctx, value)
+			out.WriteMessageEnd(ctx)
+			return true, WrapTException(out.Flush(ctx))
+		},
+	}, factory)
+	client.SetProcessor(echoProcessor(), factory)
+
+	const calls = 3
+	done := make(chan error, 1)
+	go func() {
+		ctx := context.Background()
+		proto := factory.GetProtocol(client.CallTransport())
+		for i := int32(1); i <= calls; i++ {
+			proto.WriteMessageBegin(ctx, "echo", CALL, i)
+			proto.WriteString(ctx, fmt.Sprintf("value-%d", i))
+			proto.WriteMessageEnd(ctx)
+			if err := proto.Flush(ctx); err != nil {
+				done <- err
+				return
+			}
+		}
+		for i := int32(1); i <= calls; i++ {
+			_, _, seqID, err := proto.ReadMessageBegin(ctx)
+			if err != nil {
+				done <- err
+				return
+			}
+			value, _ := proto.ReadString(ctx)
+			proto.ReadMessageEnd(ctx)
+			if expected := fmt.Sprintf("value-%d", i); seqID != i || value != expected {
+				done <- fmt.Errorf("expected %q (%d), got %q (%d)", expected, i, value, seqID)
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("calls deadlocked with the callbacks of the server")
+	}
+}
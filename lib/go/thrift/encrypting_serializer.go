@@ -0,0 +1,202 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// The payloads of TEncryptingSerializer start with encryptedMagic and
+// encryptedVersion, then the length of the key id as a byte, the key id, the
+// nonce, and the ciphertext. The bytes before the nonce are authenticated
+// with the ciphertext.
+const (
+	encryptedMagic   = "TENC"
+	encryptedVersion = 1
+)
+
+// KeyProvider provides the keys of TEncryptingSerializer and
+// TDecryptingDeserializer, for example from a KMS.
+type KeyProvider interface {
+	// CurrentKey returns the key encrypting the new payloads, and its id,
+	// at most 255 bytes long.
+	CurrentKey(ctx context.Context) (keyID string, aead cipher.AEAD, err error)
+
+	// Key returns the key keyID, to decrypt the payloads it encrypted.
+	Key(ctx context.Context, keyID string) (cipher.AEAD, error)
+}
+
+// StaticKeyProvider is a KeyProvider of fixed AES-GCM keys, encrypting with
+// the key Current, and decrypting with any of Keys, so that the keys can be
+// rotated.
+type StaticKeyProvider struct {
+	Current string
+	Keys    map[string]cipher.AEAD
+}
+
+// NewStaticKeyProvider returns a StaticKeyProvider of the AES keys by id,
+// of 16, 24 or 32 bytes for AES-128, AES-192 or AES-256, used with GCM.
+func NewStaticKeyProvider(current string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	p := &StaticKeyProvider{
+		Current: current,
+		Keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		if p.Keys[id], err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+	}
+	if _, ok := p.Keys[current]; !ok {
+		return nil, fmt.Errorf("missing current key %q", current)
+	}
+	return p, nil
+}
+
+// CurrentKey implements KeyProvider.
+func (p *StaticKeyProvider) CurrentKey(ctx context.Context) (string, cipher.AEAD, error) {
+	aead, err := p.Key(ctx, p.Current)
+	return p.Current, aead, err
+}
+
+// Key implements KeyProvider.
+func (p *StaticKeyProvider) Key(ctx context.Context, keyID string) (cipher.AEAD, error) {
+	aead, ok := p.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("thrift: unknown key %q", keyID)
+	}
+	return aead, nil
+}
+
+// TEncryptingSerializer serializes the structs encrypted, with an AEAD such as
+// AES-GCM and a random nonce, for example to store them in queues or
+// databases. The payloads carry the id of their key, so that
+// TDecryptingDeserializer finds it after the keys are rotated.
+//
+// It is safe for concurrent use.
+type TEncryptingSerializer struct {
+	serializer *TSerializerPool
+	keys       KeyProvider
+}
+
+// NewTEncryptingSerializer returns a TEncryptingSerializer writing the structs
+// with the protocols of factory, and encrypting them with the current key of
+// keys.
+func NewTEncryptingSerializer(factory TProtocolFactory, keys KeyProvider) *TEncryptingSerializer {
+	return &TEncryptingSerializer{
+		serializer: NewTSerializerPoolSizeFactory(1024, factory),
+		keys:       keys,
+	}
+}
+
+// Write returns msg serialized and encrypted.
+func (s *TEncryptingSerializer) Write(ctx context.Context, msg TStruct) ([]byte, error) {
+	keyID, aead, err := s.keys.CurrentKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyID) > 255 {
+		return nil, fmt.Errorf("thrift: key id %q longer than 255 bytes", keyID)
+	}
+	plaintext, err := s.serializer.Write(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	header := len(encryptedMagic) + 2 + len(keyID)
+	b := make([]byte, header+aead.NonceSize(), header+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(b, encryptedMagic)
+	b[len(encryptedMagic)] = encryptedVersion
+	b[len(encryptedMagic)+1] = byte(len(keyID))
+	copy(b[len(encryptedMagic)+2:], keyID)
+	nonce := b[header:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(b, nonce, plaintext, b[:header]), nil
+}
+
+// TDecryptingDeserializer deserializes the structs encrypted by a
+// TEncryptingSerializer.
+//
+// It is safe for concurrent use.
+type TDecryptingDeserializer struct {
+	deserializer *TDeserializerPool
+	keys         KeyProvider
+}
+
+// NewTDecryptingDeserializer returns a TDecryptingDeserializer decrypting the
+// payloads with the keys of keys, and reading the structs with the protocols
+// of factory.
+func NewTDecryptingDeserializer(factory TProtocolFactory, keys KeyProvider) *TDecryptingDeserializer {
+	return &TDecryptingDeserializer{
+		deserializer: NewTDeserializerPoolSizeFactory(1024, factory),
+		keys:         keys,
+	}
+}
+
+// Read decrypts b and reads it into msg. It fails with an INVALID_DATA
+// TProtocolException if b isn't an encrypted payload, or was altered.
+func (d *TDecryptingDeserializer) Read(ctx context.Context, msg TStruct, b []byte) error {
+	keyID, err := EncryptedKeyID(b)
+	if err != nil {
+		return err
+	}
+	aead, err := d.keys.Key(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	header := len(encryptedMagic) + 2 + len(keyID)
+	if len(b) < header+aead.NonceSize() {
+		return NewTProtocolExceptionWithType(INVALID_DATA, errors.New("truncated encrypted payload"))
+	}
+	nonce := b[header : header+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, b[header+aead.NonceSize():], b[:header])
+	if err != nil {
+		return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("encrypted payload: %w", err))
+	}
+	return d.deserializer.Read(ctx, msg, plaintext)
+}
+
+// EncryptedKeyID returns the id of the key of the payload b of a
+// TEncryptingSerializer, for example to re-encrypt the payloads of a retired
+// key.
+func EncryptedKeyID(b []byte) (string, error) {
+	if len(b) < len(encryptedMagic)+2 || string(b[:len(encryptedMagic)]) != encryptedMagic {
+		return "", NewTProtocolExceptionWithType(INVALID_DATA, errors.New("not an encrypted payload"))
+	}
+	if version := b[len(encryptedMagic)]; version != encryptedVersion {
+		return "", NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("unsupported encrypted payload version %d", version))
+	}
+	n := int(b[len(encryptedMagic)+1])
+	if len(b) < len(encryptedMagic)+2+n {
+		return "", NewTProtocolExceptionWithType(INVALID_DATA, errors.New("truncated encrypted payload"))
+	}
+	return string(b[len(encryptedMagic)+2 : len(encryptedMagic)+2+n]), nil
+}
+
+var _ KeyProvider = (*StaticKeyProvider)(nil)
@@ -0,0 +1,103 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEncryptingSerializer(t *testing.T) {
+	ctx := context.Background()
+	oldKeys, err := NewStaticKeyProvider("2023", map[string][]byte{
+		"2023": bytes.Repeat([]byte{1}, 16),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewStaticKeyProvider("2024", map[string][]byte{
+		"2023": bytes.Repeat([]byte{1}, 16),
+		"2024": bytes.Repeat([]byte{2}, 32),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := NewTCompactProtocolFactoryConf(nil)
+	msg := &MyTestStruct{St: "secret", Int64: 42}
+
+	old, err := NewTEncryptingSerializer(factory, oldKeys).Write(ctx, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := NewTEncryptingSerializer(factory, keys).Write(ctx, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(current, []byte("secret")) {
+		t.Error("expected the payload to be encrypted")
+	}
+	again, _ := NewTEncryptingSerializer(factory, keys).Write(ctx, msg)
+	if bytes.Equal(current, again) {
+		t.Error("expected random nonces")
+	}
+
+	// The payloads of the rotated keys can still be read.
+	d := NewTDecryptingDeserializer(factory, keys)
+	for keyID, b := range map[string][]byte{"2023": old, "2024": current} {
+		if id, err := EncryptedKeyID(b); err != nil || id != keyID {
+			t.Errorf("expected key %q, got %q, error %v", keyID, id, err)
+		}
+		var got MyTestStruct
+		if err := d.Read(ctx, &got, b); err != nil {
+			t.Fatal(err)
+		}
+		if !Equal(&got, msg) {
+			t.Errorf("unexpected struct %v", &got)
+		}
+	}
+
+	isInvalidData := func(err error) bool {
+		var pe TProtocolException
+		return errors.As(err, &pe) && pe.TypeId() == INVALID_DATA
+	}
+	var got MyTestStruct
+	altered := append([]byte(nil), current...)
+	altered[len(altered)-1] ^= 1
+	// The key id is authenticated too.
+	renamed := append([]byte(nil), old...)
+	copy(renamed[6:], "2024")
+	for _, b := range [][]byte{nil, []byte("TENC\x02"), altered, renamed, current[:12]} {
+		if err := d.Read(ctx, &got, b); !isInvalidData(err) {
+			t.Errorf("expected INVALID_DATA for %q, got %v", b, err)
+		}
+	}
+	if err := NewTDecryptingDeserializer(factory, oldKeys).Read(ctx, &got, current); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+
+	if _, err := NewStaticKeyProvider("missing", map[string][]byte{"k": make([]byte, 16)}); err == nil {
+		t.Error("expected an error for a missing current key")
+	}
+	if _, err := NewStaticKeyProvider("k", map[string][]byte{"k": make([]byte, 5)}); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// envelopeMagic starts the envelopes, followed by envelopeVersion.
+const (
+	envelopeMagic   = "TENV"
+	envelopeVersion = 1
+
+	// envelopeHeaderSize is the size of the magic, the version, the protocol
+	// id and the fingerprint.
+	envelopeHeaderSize = len(envelopeMagic) + 1 + 1 + 8
+)
+
+// ErrEnvelopeSchemaMismatch is returned by UnmarshalEnvelope for the
+// envelopes written with another schema than the one of the struct to decode.
+var ErrEnvelopeSchemaMismatch = errors.New("thrift: envelope schema mismatch")
+
+// Envelope is a payload made self-describing by MarshalEnvelope, for example
+// to be persisted.
+//
+// Its encoding is the magic "TENV", the version 1 as a byte, the protocol id
+// as a byte, the fingerprint as 8 bytes in big endian, and the payload.
+type Envelope struct {
+	// Protocol is the protocol of the payload.
+	Protocol THeaderProtocolID
+
+	// Fingerprint is the SchemaFingerprint of the struct in the payload.
+	Fingerprint uint64
+
+	Payload []byte
+}
+
+// MarshalEnvelope returns the Envelope of msg, a generated struct or the
+// TStruct of ReflectStruct, written with protocol.
+func MarshalEnvelope(ctx context.Context, msg TStruct, protocol THeaderProtocolID) ([]byte, error) {
+	fingerprint, err := SchemaFingerprint(msg)
+	if err != nil {
+		return nil, err
+	}
+	buf := NewTMemoryBuffer()
+	buf.WriteString(envelopeMagic)
+	buf.WriteByte(envelopeVersion)
+	buf.WriteByte(byte(protocol))
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], fingerprint)
+	buf.Write(b[:])
+	proto, err := protocol.GetProtocol(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := msg.Write(ctx, proto); err != nil {
+		return nil, err
+	}
+	if err := proto.Flush(ctx); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseEnvelope parses the Envelope data, without decoding its payload.
+func ParseEnvelope(data []byte) (Envelope, error) {
+	if len(data) < envelopeHeaderSize || string(data[:len(envelopeMagic)]) != envelopeMagic {
+		return Envelope{}, NewTProtocolExceptionWithType(INVALID_DATA, errors.New("not a thrift envelope"))
+	}
+	if version := data[len(envelopeMagic)]; version != envelopeVersion {
+		return Envelope{}, NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("unsupported envelope version %d", version))
+	}
+	e := Envelope{
+		Protocol:    THeaderProtocolID(data[len(envelopeMagic)+1]),
+		Fingerprint: binary.BigEndian.Uint64(data[len(envelopeMagic)+2:]),
+		Payload:     data[envelopeHeaderSize:],
+	}
+	if err := e.Protocol.Validate(); err != nil {
+		return Envelope{}, err
+	}
+	return e, nil
+}
+
+// Decode decodes the payload of e into msg, whatever its fingerprint, for
+// example to read the envelopes of a compatible older schema.
+func (e Envelope) Decode(ctx context.Context, msg TStruct) error {
+	proto, err := e.Protocol.GetProtocol(&TMemoryBuffer{Buffer: bytes.NewBuffer(e.Payload)})
+	if err != nil {
+		return err
+	}
+	return msg.Read(ctx, proto)
+}
+
+// UnmarshalEnvelope decodes the Envelope data into msg, with the protocol it
+// was written with.
+//
+// It fails with ErrEnvelopeSchemaMismatch, without decoding the payload, if
+// the envelope has another fingerprint than msg. Use ParseEnvelope and
+// Envelope.Decode to decode it anyway.
+func UnmarshalEnvelope(ctx context.Context, data []byte, msg TStruct) error {
+	e, err := ParseEnvelope(data)
+	if err != nil {
+		return err
+	}
+	fingerprint, err := SchemaFingerprint(msg)
+	if err != nil {
+		return err
+	}
+	if e.Fingerprint != fingerprint {
+		return fmt.Errorf("%w: got %016x, expected %016x", ErrEnvelopeSchemaMismatch, e.Fingerprint, fingerprint)
+	}
+	return e.Decode(ctx, msg)
+}
+
+// schemaFingerprints caches the fingerprints of the struct types.
+var schemaFingerprints sync.Map
+
+// SchemaFingerprint returns the fingerprint of the schema of v, a generated
+// struct or a struct with thrift tags (see EncodeStruct), or the TStruct of
+// ReflectStruct.
+//
+// The fingerprint covers what the compatibility of the encoded structs
+// depends on: the ids, the types and the requiredness of their fields,
+// recursively, but not their names. Any change of the schema, even a
+// compatible one like a new optional field, changes the fingerprint.
+func SchemaFingerprint(v interface{}) (uint64, error) {
+	if s, ok := v.(tReflectStruct); ok {
+		v = s.v
+	}
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return 0, fmt.Errorf("cannot fingerprint %T as a struct", v)
+	}
+	if fingerprint, ok := schemaFingerprints.Load(t); ok {
+		return fingerprint.(uint64), nil
+	}
+	h := sha256.New()
+	if err := writeSchema(h, t, nil); err != nil {
+		return 0, err
+	}
+	fingerprint := binary.BigEndian.Uint64(h.Sum(nil))
+	schemaFingerprints.Store(t, fingerprint)
+	return fingerprint, nil
+}
+
+// writeSchema writes the canonical description of the type t to w, stack
+// being the structs being described, to refer to the recursive ones.
+func writeSchema(w io.Writer, t reflect.Type, stack []reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return writeSchema(w, t.Elem(), stack)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			io.WriteString(w, "string")
+			return nil
+		}
+		io.WriteString(w, "list<")
+		if err := writeSchema(w, t.Elem(), stack); err != nil {
+			return err
+		}
+		io.WriteString(w, ">")
+		return nil
+	case reflect.Map:
+		if isJSONSet(t) {
+			io.WriteString(w, "set<")
+		} else {
+			io.WriteString(w, "map<")
+		}
+		if err := writeSchema(w, t.Key(), stack); err != nil {
+			return err
+		}
+		if !isJSONSet(t) {
+			io.WriteString(w, ",")
+			if err := writeSchema(w, t.Elem(), stack); err != nil {
+				return err
+			}
+		}
+		io.WriteString(w, ">")
+		return nil
+	case reflect.Struct:
+		for i, s := range stack {
+			if s == t {
+				fmt.Fprintf(w, "ref(%d)", i)
+				return nil
+			}
+		}
+		info, err := reflectStructInfo(t)
+		if err != nil {
+			return err
+		}
+		fields := append([]tReflectField(nil), info.fields...)
+		sort.Slice(fields, func(i, j int) bool {
+			return fields[i].id < fields[j].id
+		})
+		stack = append(stack, t)
+		io.WriteString(w, "struct{")
+		for _, f := range fields {
+			fmt.Fprintf(w, "%d:", f.id)
+			if f.required {
+				io.WriteString(w, "required ")
+			}
+			ft := t.Field(f.index).Type
+			if f.set {
+				io.WriteString(w, "set<")
+				if err := writeSchema(w, ft.Elem(), stack); err != nil {
+					return err
+				}
+				io.WriteString(w, ">")
+			} else if err := writeSchema(w, ft, stack); err != nil {
+				return err
+			}
+			io.WriteString(w, ";")
+		}
+		io.WriteString(w, "}")
+		return nil
+	default:
+		ttype, err := reflectTType(t, false)
+		if err != nil {
+			return err
+		}
+		io.WriteString(w, ttype.String())
+		return nil
+	}
+}
@@ -0,0 +1,137 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type envelopeTestV1 struct {
+	ID   int64   `thrift:"id,1,required"`
+	Name *string `thrift:"name,2"`
+}
+
+// envelopeTestRenamed only renames the fields of envelopeTestV1.
+type envelopeTestRenamed struct {
+	Key   int64   `thrift:"key,1,required"`
+	Label *string `thrift:"label,2"`
+}
+
+type envelopeTestV2 struct {
+	ID    int64    `thrift:"id,1,required"`
+	Name  *string  `thrift:"name,2"`
+	Roles []string `thrift:"roles,3,set"`
+}
+
+func TestSchemaFingerprint(t *testing.T) {
+	fingerprint := func(v interface{}) uint64 {
+		t.Helper()
+		f, err := SchemaFingerprint(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	v1 := fingerprint(&envelopeTestV1{})
+	// The fingerprints are persisted, so they must never change.
+	if v1 != 0x36f5a3c8b629512e {
+		t.Errorf("unexpected fingerprint %016x", v1)
+	}
+	if f := fingerprint(envelopeTestV1{}); f != v1 {
+		t.Errorf("expected the fingerprint of the pointer, got %016x", f)
+	}
+	if f := fingerprint(ReflectStruct(&envelopeTestV1{})); f != v1 {
+		t.Errorf("expected the fingerprint of the wrapped struct, got %016x", f)
+	}
+	if f := fingerprint(&envelopeTestRenamed{}); f != v1 {
+		t.Errorf("expected the names to be ignored, got %016x", f)
+	}
+	if f := fingerprint(&envelopeTestV2{}); f == v1 {
+		t.Error("expected a new field to change the fingerprint")
+	}
+	if fingerprint(&reflectTestUser{}) == fingerprint(&reflectTestAddress{}) {
+		t.Error("expected different fingerprints")
+	}
+	if _, err := SchemaFingerprint(42); err == nil {
+		t.Error("expected an error for a non struct")
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	name := "alice"
+	for _, protocol := range []THeaderProtocolID{THeaderProtocolBinary, THeaderProtocolCompact} {
+		data, err := MarshalEnvelope(ctx, ReflectStruct(&envelopeTestV1{ID: 1, Name: &name}), protocol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := ParseEnvelope(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Protocol != protocol || e.Fingerprint != 0x36f5a3c8b629512e || len(e.Payload) != len(data)-envelopeHeaderSize {
+			t.Errorf("unexpected envelope %+v", e)
+		}
+
+		var v1 envelopeTestV1
+		if err := UnmarshalEnvelope(ctx, data, ReflectStruct(&v1)); err != nil {
+			t.Fatal(err)
+		}
+		if v1.ID != 1 || v1.Name == nil || *v1.Name != "alice" {
+			t.Errorf("unexpected struct %+v", v1)
+		}
+
+		// The envelopes of other schemas are detected, and can be decoded
+		// anyway.
+		var v2 envelopeTestV2
+		if err := UnmarshalEnvelope(ctx, data, ReflectStruct(&v2)); !errors.Is(err, ErrEnvelopeSchemaMismatch) {
+			t.Errorf("expected a schema mismatch, got %v", err)
+		}
+		if err := e.Decode(ctx, ReflectStruct(&v2)); err != nil || v2.ID != 1 {
+			t.Errorf("unexpected struct %+v, error %v", v2, err)
+		}
+	}
+
+	data, err := MarshalEnvelope(ctx, &MyTestStruct{St: "generated", E: MyTestEnum_SECOND}, THeaderProtocolCompact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m MyTestStruct
+	if err := UnmarshalEnvelope(ctx, data, &m); err != nil || m.St != "generated" || m.E != MyTestEnum_SECOND {
+		t.Errorf("unexpected struct %v, error %v", &m, err)
+	}
+
+	if _, err := MarshalEnvelope(ctx, &m, THeaderProtocolID(7)); err == nil {
+		t.Error("expected an error for an unsupported protocol")
+	}
+	for _, invalid := range [][]byte{
+		nil,
+		[]byte("TENV"),
+		append([]byte("XENV"), data[4:]...),
+		append([]byte("TENV\x02"), data[5:]...),
+		append([]byte("TENV\x01\x07"), data[6:]...),
+	} {
+		if _, err := ParseEnvelope(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// EqualOptions configures the comparison of the structs by Equal.
+type EqualOptions struct {
+	// NaNEqual makes the NaN doubles equal to each other, instead of
+	// different from every double like in Go.
+	NaNEqual bool
+}
+
+// Equal reports whether a and b are equal with the default EqualOptions, see
+// EqualOptions.Equal.
+func Equal(a, b TStruct) bool {
+	return EqualOptions{}.Equal(a, b)
+}
+
+// Equal reports whether the structs a and b, like the generated ones, are
+// equal by the semantics of thrift, which reflect.DeepEqual gets wrong:
+//
+//   - the unset fields are equal, whatever their Go value, e.g. the optional
+//     fields with a default value, but differ from the set ones, so a nil
+//     slice differs from an empty one,
+//   - the elements of the sets, and the entries of the maps, are compared
+//     regardless of their order, even the slices of the generated sets, and
+//     the maps with pointer keys by the values they point to,
+//   - the doubles are compared with ==, NaN being equal to NaN only with
+//     NaNEqual.
+//
+// The structs are compared as they're encoded, so structs of different types
+// with the same fields are equal. The ones failing to be encoded, e.g. with
+// a required field unset, are compared with reflect.DeepEqual.
+func (o EqualOptions) Equal(a, b TStruct) bool {
+	va, errA := structValue(a)
+	vb, errB := structValue(b)
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return o.equalValues(va, vb)
+}
+
+// structValue returns the Value of s, as encoded.
+func structValue(s TStruct) (Value, error) {
+	ctx := context.Background()
+	buf := NewTMemoryBuffer()
+	proto := NewTBinaryProtocolConf(buf, nil)
+	if err := s.Write(ctx, proto); err != nil {
+		return Value{}, err
+	}
+	var v Value
+	err := v.Read(ctx, proto)
+	return v, err
+}
+
+func (o EqualOptions) equalValues(a, b Value) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case BOOL:
+		return a.Bool == b.Bool
+	case BYTE, I16, I32, I64:
+		return a.Int == b.Int
+	case DOUBLE:
+		return a.Double == b.Double || (o.NaNEqual && math.IsNaN(a.Double) && math.IsNaN(b.Double))
+	case STRING:
+		return bytes.Equal(a.Binary, b.Binary)
+	case STRUCT:
+		if len(a.Fields) != len(b.Fields) {
+			return false
+		}
+		// The fields are compared by id, the ones repeated in the Values
+		// decoded from the inputs in their order.
+		af, bf := sortedValueFields(a.Fields), sortedValueFields(b.Fields)
+		for i := range af {
+			if af[i].ID != bf[i].ID || !o.equalValues(af[i].Value, bf[i].Value) {
+				return false
+			}
+		}
+		return true
+	case LIST:
+		if len(a.Elems) != len(b.Elems) {
+			return false
+		}
+		for i := range a.Elems {
+			if !o.equalValues(a.Elems[i], b.Elems[i]) {
+				return false
+			}
+		}
+		return true
+	case SET:
+		if len(a.Elems) != len(b.Elems) {
+			return false
+		}
+		matched := make([]bool, len(b.Elems))
+		for _, elem := range a.Elems {
+			if o.match(elem, matched, func(i int) Value { return b.Elems[i] }) < 0 {
+				return false
+			}
+		}
+		return true
+	case MAP:
+		if len(a.Entries) != len(b.Entries) {
+			return false
+		}
+		matched := make([]bool, len(b.Entries))
+		for _, e := range a.Entries {
+			i := o.match(e.Key, matched, func(i int) Value { return b.Entries[i].Key })
+			if i < 0 || !o.equalValues(e.Value, b.Entries[i].Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// match returns the index of the first element not matched yet equal to v,
+// marking it as matched, or -1 if there's none. value returns the compared
+// Value of the element i.
+func (o EqualOptions) match(v Value, matched []bool, value func(i int) Value) int {
+	for i := range matched {
+		if !matched[i] && o.equalValues(v, value(i)) {
+			matched[i] = true
+			return i
+		}
+	}
+	return -1
+}
+
+// sortedValueFields returns a copy of fields sorted by id, stably.
+func sortedValueFields(fields []ValueField) []ValueField {
+	sorted := append([]ValueField(nil), fields...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
@@ -0,0 +1,108 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	name := "alice"
+	otherName := "alice"
+	user := func() *reflectTestUser {
+		return &reflectTestUser{
+			ID:      1,
+			Name:    &name,
+			Roles:   []string{"admin", "dev", "dev"},
+			Scores:  map[string]float64{"go": 1.5, "nan": math.NaN()},
+			Address: &reflectTestAddress{City: "Paris"},
+			Labels: map[reflectTestAddress]float32{
+				{City: "Paris"}: 1,
+				{City: "Rome"}:  2,
+			},
+		}
+	}
+
+	for _, c := range []struct {
+		label    string
+		mutate   func(u *reflectTestUser)
+		equal    bool
+		nanEqual bool
+	}{
+		{"identical with a NaN", func(u *reflectTestUser) {}, false, true},
+		{"pointer to an equal value", func(u *reflectTestUser) { u.Name = &otherName }, false, true},
+		{"set order", func(u *reflectTestUser) { u.Roles = []string{"dev", "admin", "dev"} }, false, true},
+		{"set elements", func(u *reflectTestUser) { u.Roles = []string{"admin", "admin", "dev"} }, false, false},
+		{"unset field", func(u *reflectTestUser) { u.Address = nil }, false, false},
+		{"empty list", func(u *reflectTestUser) { u.Previous = []reflectTestAddress{} }, false, false},
+		{"map value", func(u *reflectTestUser) { u.Scores["go"] = 2 }, false, false},
+		{"map key", func(u *reflectTestUser) {
+			u.Labels = map[reflectTestAddress]float32{{City: "Paris"}: 1, {City: "Oslo"}: 2}
+		}, false, false},
+	} {
+		a, b := user(), user()
+		c.mutate(b)
+		if got := Equal(ReflectStruct(a), ReflectStruct(b)); got != c.equal {
+			t.Errorf("%s: Equal returned %v", c.label, got)
+		}
+		if got := (EqualOptions{NaNEqual: true}).Equal(ReflectStruct(a), ReflectStruct(b)); got != c.nanEqual {
+			t.Errorf("%s: Equal with NaNEqual returned %v", c.label, got)
+		}
+	}
+
+	// The sets of the generated structs can't be told from the lists by
+	// reflection, but they are written as sets.
+	a := &MyTestStruct{StringList: []string{"a", "b"}, StringSet: map[string]struct{}{"a": {}, "b": {}}}
+	b := &MyTestStruct{StringList: []string{"a", "b"}, StringSet: map[string]struct{}{"b": {}, "a": {}}}
+	if !Equal(a, b) {
+		t.Error("expected equal generated structs")
+	}
+	b.StringList = []string{"b", "a"}
+	if Equal(a, b) {
+		t.Error("expected the list order to matter")
+	}
+
+	// The fields repeated in the Values are compared in their order.
+	repeated := func(second Value) *Value {
+		v := StructValue(
+			ValueField{ID: 1, Value: BoolValue(false)},
+			ValueField{ID: 2, Value: I32Value(2)},
+			ValueField{ID: 1, Value: second},
+		)
+		return &v
+	}
+	if !Equal(repeated(I32Value(1)), repeated(I32Value(1))) {
+		t.Error("expected equal Values with a repeated field")
+	}
+	if Equal(repeated(I32Value(1)), repeated(I32Value(3))) {
+		t.Error("expected the second occurrence of a repeated field to matter")
+	}
+
+	// The structs failing to be written are compared with reflect.DeepEqual.
+	invalid := func() TStruct {
+		return ReflectStruct(&struct {
+			C complex64 `thrift:"c,1"`
+		}{1})
+	}
+	if !Equal(invalid(), invalid()) {
+		t.Error("expected equal structs failing to be written")
+	}
+}
@@ -0,0 +1,83 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrorClassifier maps the errors returned by the handler of method to the
+// errors replied to the client, so that the clients get consistent error
+// categories instead of INTERNAL_ERROR TApplicationExceptions.
+//
+// It can return:
+//
+//   - A TApplicationException, replied as-is, e.g. with a different type.
+//   - An exception declared by method in the IDL, replied like if the handler
+//     returned it.
+//   - err itself, or nil, to keep the default: an INTERNAL_ERROR
+//     TApplicationException.
+//
+// ErrAbandonRequest is never passed to it.
+//
+// The processors must be generated by a compiler version supporting it.
+type ErrorClassifier func(ctx context.Context, method string, err error) error
+
+// See https://godoc.org/context#WithValue on why do we need the unexported typedefs.
+type errorClassifierKey struct{}
+
+// AddErrorClassifierToContext sets the ErrorClassifier used by the generated
+// processors for the requests of ctx.
+//
+// TSimpleServer calls it on every accepted connection when set with
+// SetErrorClassifier, so it's only needed by custom TServer implementations.
+func AddErrorClassifierToContext(ctx context.Context, classifier ErrorClassifier) context.Context {
+	return context.WithValue(ctx, errorClassifierKey{}, classifier)
+}
+
+// ClassifyHandlerError passes err, returned by the handler of method, to the
+// ErrorClassifier of ctx, if any. It's used by the generated processors.
+func ClassifyHandlerError(ctx context.Context, method string, err error) error {
+	classifier, ok := ctx.Value(errorClassifierKey{}).(ErrorClassifier)
+	if !ok || classifier == nil || err == nil || errors.Is(err, ErrAbandonRequest) {
+		return err
+	}
+	if classified := classifier(ctx, method, err); classified != nil {
+		return classified
+	}
+	return err
+}
+
+// HandlerErrorApplicationException returns the TApplicationException replied
+// for err, returned by the handler of method and not declared in the IDL. It's
+// used by the generated processors.
+//
+// It's an INTERNAL_ERROR, unless ctx has an ErrorClassifier and err is a
+// TApplicationException, which is replied as-is.
+func HandlerErrorApplicationException(ctx context.Context, method string, err error) TApplicationException {
+	if classifier, ok := ctx.Value(errorClassifierKey{}).(ErrorClassifier); ok && classifier != nil {
+		var tae TApplicationException
+		if errors.As(err, &tae) {
+			return tae
+		}
+	}
+	return NewTApplicationException(INTERNAL_ERROR, "Internal error processing "+method+": "+err.Error())
+}
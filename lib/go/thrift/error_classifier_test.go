@@ -0,0 +1,77 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var errNotFound = errors.New("not found")
+
+// declaredException stands for an exception declared in the IDL.
+type declaredException struct {
+	msg string
+}
+
+func (e *declaredException) Error() string {
+	return e.msg
+}
+
+func TestErrorClassifier(t *testing.T) {
+	serv := NewTSimpleServer2(nil, nil)
+	serv.SetErrorClassifier(func(ctx context.Context, method string, err error) error {
+		switch {
+		case errors.Is(err, errNotFound):
+			return NewTApplicationException(UNKNOWN_METHOD, method+": "+err.Error())
+		case errors.Is(err, context.DeadlineExceeded):
+			return &declaredException{msg: "timeout"}
+		}
+		return nil
+	})
+	ctx := serv.newConnContext(NewTMemoryBuffer(), serv.listener)
+
+	err := ClassifyHandlerError(ctx, "get", errNotFound)
+	if exc := HandlerErrorApplicationException(ctx, "get", err); exc.TypeId() != UNKNOWN_METHOD || exc.Error() != "get: not found" {
+		t.Errorf("expected the classified exception, got %v", exc)
+	}
+	var declared *declaredException
+	if err := ClassifyHandlerError(ctx, "get", context.DeadlineExceeded); !errors.As(err, &declared) {
+		t.Errorf("expected the declared exception, got %v", err)
+	}
+	unknown := errors.New("unknown")
+	if err := ClassifyHandlerError(ctx, "get", unknown); err != unknown {
+		t.Errorf("expected the unclassified error unchanged, got %v", err)
+	}
+	if exc := HandlerErrorApplicationException(ctx, "get", unknown); exc.TypeId() != INTERNAL_ERROR {
+		t.Errorf("expected INTERNAL_ERROR, got %v", exc)
+	}
+	if err := ClassifyHandlerError(ctx, "get", ErrAbandonRequest); err != ErrAbandonRequest {
+		t.Errorf("expected ErrAbandonRequest unchanged, got %v", err)
+	}
+
+	// Without a classifier, TApplicationExceptions are INTERNAL_ERRORs as
+	// before.
+	exc := HandlerErrorApplicationException(context.Background(), "get", NewTApplicationException(UNKNOWN_METHOD, "not found"))
+	if exc.TypeId() != INTERNAL_ERROR || exc.Error() != "Internal error processing get: not found" {
+		t.Errorf("expected INTERNAL_ERROR, got %v", exc)
+	}
+}
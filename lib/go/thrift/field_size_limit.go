@@ -0,0 +1,299 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"unicode/utf8"
+)
+
+// TFieldSizePolicy is what the TProtocol implementations do with the string,
+// binary, and container values over the MaxStringSize, MaxBinarySize, and
+// MaxContainerSize of their TConfiguration.
+type TFieldSizePolicy int
+
+const (
+	// FieldSizeError fails the read of the values over their limit with a
+	// SIZE_LIMIT TProtocolException, rejecting the whole message.
+	FieldSizeError TFieldSizePolicy = iota
+
+	// FieldSizeTruncate keeps the first bytes of the strings and binaries,
+	// and the first elements of the containers, up to their limit, skipping
+	// the rest. The strings are cut at a UTF-8 character boundary.
+	//
+	// The truncations are recorded in the context of the reads, see
+	// WithFieldTruncations.
+	FieldSizeTruncate
+)
+
+// FieldTruncation describes a value truncated under the FieldSizeTruncate
+// policy.
+type FieldTruncation struct {
+	// STRING for the strings and binaries, LIST, SET, or MAP for the
+	// containers.
+	Type TType
+
+	// The size on the wire and the limit it was truncated to, in bytes for
+	// the strings and binaries, in elements for the containers.
+	Size    int
+	MaxSize int
+}
+
+// See https://godoc.org/context#WithValue on why do we need the unexported typedefs.
+type fieldTruncationsKey struct{}
+
+type fieldTruncations struct {
+	mu          sync.Mutex
+	truncations []FieldTruncation
+}
+
+// WithFieldTruncations returns a copy of ctx recording the values truncated
+// by the reads made with it, returned by FieldTruncationsFromContext.
+func WithFieldTruncations(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fieldTruncationsKey{}, &fieldTruncations{})
+}
+
+// FieldTruncationsFromContext returns the values truncated by the reads made
+// with ctx, or ctx's parents, since WithFieldTruncations, nil for none.
+func FieldTruncationsFromContext(ctx context.Context) []FieldTruncation {
+	t, ok := ctx.Value(fieldTruncationsKey{}).(*fieldTruncations)
+	if !ok {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]FieldTruncation(nil), t.truncations...)
+}
+
+func recordFieldTruncation(ctx context.Context, truncation FieldTruncation) {
+	if t, ok := ctx.Value(fieldTruncationsKey{}).(*fieldTruncations); ok {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.truncations = append(t.truncations, truncation)
+	}
+}
+
+// FieldTruncationProcessorMiddleware is a ProcessorMiddleware recording the
+// values of the requests truncated under the FieldSizeTruncate policy, so
+// that the handlers can tell them with FieldTruncationsFromContext.
+func FieldTruncationProcessorMiddleware(name string, next TProcessorFunction) TProcessorFunction {
+	return WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			return next.Process(WithFieldTruncations(ctx), seqID, in, out)
+		},
+	}
+}
+
+// tFieldSizeLimiter applies the per-field limits of a TConfiguration to the
+// values read by a TProtocol.
+type tFieldSizeLimiter struct {
+	// The containers being read under the FieldSizeTruncate policy, with the
+	// number of elements to skip at their end.
+	containers []tTruncatedContainer
+
+	// Non-zero while skipping the elements of a truncated container, which
+	// aren't subject to the limits.
+	skipping int
+
+	// The depth of the structs being read, the state being reset at the
+	// start of the top-level ones.
+	depth int
+}
+
+type tTruncatedContainer struct {
+	keyType, elemType TType
+	skip              int
+}
+
+// reset drops the state left by a read failing in the middle of a struct or
+// container.
+func (l *tFieldSizeLimiter) reset() {
+	l.containers = l.containers[:0]
+	l.skipping = 0
+	l.depth = 0
+}
+
+// fieldSizeLimitsResetter is implemented by the protocols applying the
+// per-field limits, for TDeserializer to reset them after a failed read.
+type fieldSizeLimitsResetter interface {
+	resetFieldSizeLimits()
+}
+
+func resetFieldSizeLimits(p TProtocol) {
+	if r, ok := p.(fieldSizeLimitsResetter); ok {
+		r.resetFieldSizeLimits()
+	}
+}
+
+func (l *tFieldSizeLimiter) structBegin() {
+	if l.depth == 0 {
+		l.reset()
+	}
+	l.depth++
+}
+
+func (l *tFieldSizeLimiter) structEnd() {
+	if l.depth > 0 {
+		l.depth--
+	}
+}
+
+// readSize returns the number of bytes to read of a string, or binary if
+// binary, of size bytes, checked before reading it so that the values over the
+// limit are never buffered. The size-n bytes left of a truncated value are
+// skipped with skipBytes.
+func (l *tFieldSizeLimiter) readSize(ctx context.Context, cfg *TConfiguration, binary bool, size int32) (int32, error) {
+	kind, max := "string", cfg.GetMaxStringSize()
+	if binary {
+		kind, max = "binary", cfg.GetMaxBinarySize()
+	}
+	if max <= 0 || size <= max || l.skipping > 0 {
+		return size, nil
+	}
+	if err := l.exceeded(ctx, cfg, kind, STRING, int(size), int(max)); err != nil {
+		return 0, err
+	}
+	return max, nil
+}
+
+// skipBytes skips the n bytes left of a truncated value.
+func skipBytes(r io.Reader, n int32) error {
+	_, err := io.CopyN(ioutil.Discard, r, int64(n))
+	return err
+}
+
+// limitString applies the limits to a string s already read, for the
+// protocols not knowing its size beforehand.
+func (l *tFieldSizeLimiter) limitString(ctx context.Context, cfg *TConfiguration, s string) (string, error) {
+	max := int(cfg.GetMaxStringSize())
+	if max <= 0 || len(s) <= max || l.skipping > 0 {
+		return s, nil
+	}
+	if err := l.exceeded(ctx, cfg, "string", STRING, len(s), max); err != nil {
+		return "", err
+	}
+	// Copied, not to keep the whole string in memory.
+	return cutString(string([]byte(s[:max]))), nil
+}
+
+// limitBinary is limitString for binaries.
+func (l *tFieldSizeLimiter) limitBinary(ctx context.Context, cfg *TConfiguration, b []byte) ([]byte, error) {
+	max := int(cfg.GetMaxBinarySize())
+	if max <= 0 || len(b) <= max || l.skipping > 0 {
+		return b, nil
+	}
+	if err := l.exceeded(ctx, cfg, "binary", STRING, len(b), max); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b[:max]...), nil
+}
+
+// cutString drops the UTF-8 character cut by the truncation of s, if any.
+func cutString(s string) string {
+	for i := len(s) - 1; i >= 0 && i >= len(s)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			if !utf8.FullRuneInString(s[i:]) {
+				return s[:i]
+			}
+			break
+		}
+	}
+	return s
+}
+
+// containerBegin returns the number of elements to read of a container of
+// size elements, to be followed by a call to containerEnd once read.
+//
+// keyType is only used for maps.
+func (l *tFieldSizeLimiter) containerBegin(ctx context.Context, cfg *TConfiguration, containerType, keyType, elemType TType, size int) (int, error) {
+	max := int(cfg.GetMaxContainerSize())
+	if max <= 0 || l.skipping > 0 {
+		return size, nil
+	}
+	if cfg.GetFieldSizePolicy() != FieldSizeTruncate {
+		if size > max {
+			return 0, l.exceeded(ctx, cfg, "container", containerType, size, max)
+		}
+		return size, nil
+	}
+	c := tTruncatedContainer{keyType: STOP, elemType: elemType}
+	if containerType == MAP {
+		c.keyType = keyType
+	}
+	if size > max {
+		recordFieldTruncation(ctx, FieldTruncation{
+			Type:    containerType,
+			Size:    size,
+			MaxSize: max,
+		})
+		c.skip = size - max
+		size = max
+	}
+	l.containers = append(l.containers, c)
+	return size, nil
+}
+
+// containerEnd skips the elements of the container being read left by its
+// truncation, if any.
+func (l *tFieldSizeLimiter) containerEnd(ctx context.Context, p TProtocol) error {
+	if l.skipping > 0 || len(l.containers) == 0 {
+		return nil
+	}
+	c := l.containers[len(l.containers)-1]
+	l.containers = l.containers[:len(l.containers)-1]
+
+	l.skipping++
+	defer func() {
+		l.skipping--
+	}()
+	for i := 0; i < c.skip; i++ {
+		if c.keyType != STOP {
+			if err := SkipDefaultDepth(ctx, p, c.keyType); err != nil {
+				l.reset()
+				return err
+			}
+		}
+		if err := SkipDefaultDepth(ctx, p, c.elemType); err != nil {
+			l.reset()
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *tFieldSizeLimiter) exceeded(ctx context.Context, cfg *TConfiguration, kind string, typ TType, size, max int) error {
+	if cfg.GetFieldSizePolicy() != FieldSizeTruncate {
+		l.reset()
+		return NewTProtocolExceptionWithType(
+			SIZE_LIMIT,
+			fmt.Errorf("%s size %d exceeded max allowed: %d", kind, size, max),
+		)
+	}
+	recordFieldTruncation(ctx, FieldTruncation{
+		Type:    typ,
+		Size:    size,
+		MaxSize: max,
+	})
+	return nil
+}
@@ -0,0 +1,312 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func fieldSizeLimitProtocols() map[string]TProtocolFactory {
+	return map[string]TProtocolFactory{
+		"binary":  NewTBinaryProtocolFactoryConf(nil),
+		"compact": NewTCompactProtocolFactoryConf(nil),
+		"json":    NewTJSONProtocolFactory(),
+	}
+}
+
+func TestFieldSizeLimits(t *testing.T) {
+	in := StructValue(
+		ValueField{ID: 1, Value: StringValue("hello, 世界")},
+		ValueField{ID: 2, Value: ListValue(I32, I32Value(1), I32Value(2), I32Value(3), I32Value(4), I32Value(5))},
+		ValueField{ID: 3, Value: MapValue(
+			STRING, LIST,
+			ValueMapEntry{Key: StringValue("a"), Value: ListValue(I32, I32Value(1), I32Value(2), I32Value(3))},
+			ValueMapEntry{Key: StringValue("b"), Value: ListValue(I32, I32Value(4))},
+			ValueMapEntry{Key: StringValue("c"), Value: ListValue(I32)},
+		)},
+		ValueField{ID: 4, Value: I64Value(42)},
+	)
+	truncated := StructValue(
+		ValueField{ID: 1, Value: StringValue("hello, ")},
+		ValueField{ID: 2, Value: ListValue(I32, I32Value(1), I32Value(2))},
+		ValueField{ID: 3, Value: MapValue(
+			STRING, LIST,
+			ValueMapEntry{Key: StringValue("a"), Value: ListValue(I32, I32Value(1), I32Value(2))},
+			ValueMapEntry{Key: StringValue("b"), Value: ListValue(I32, I32Value(4))},
+		)},
+		ValueField{ID: 4, Value: I64Value(42)},
+	)
+	expectedTruncations := []FieldTruncation{
+		{Type: STRING, Size: 13, MaxSize: 8},
+		{Type: LIST, Size: 5, MaxSize: 2},
+		{Type: MAP, Size: 3, MaxSize: 2},
+		{Type: LIST, Size: 3, MaxSize: 2},
+	}
+
+	for name, factory := range fieldSizeLimitProtocols() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			buf := NewTMemoryBuffer()
+			out := factory.GetProtocol(buf)
+			if err := in.Write(ctx, out); err != nil {
+				t.Fatal(err)
+			}
+			if err := out.Flush(ctx); err != nil {
+				t.Fatal(err)
+			}
+			data := buf.Bytes()
+
+			read := func(cfg *TConfiguration) (Value, []FieldTruncation, error) {
+				ctx := WithFieldTruncations(context.Background())
+				buf := NewTMemoryBuffer()
+				buf.Write(data)
+				v, err := ReadValue(ctx, TProtocolFactoryConf(factory, cfg).GetProtocol(buf), STRUCT)
+				return v, FieldTruncationsFromContext(ctx), err
+			}
+
+			v, truncations, err := read(&TConfiguration{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Equal(&v, &in) || len(truncations) != 0 {
+				t.Errorf("Without limits, got %v truncated %v, want %v", v, truncations, in)
+			}
+
+			cfg := &TConfiguration{
+				MaxStringSize:    8,
+				MaxContainerSize: 2,
+				FieldSizePolicy:  FieldSizeTruncate,
+			}
+			v, truncations, err = read(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Equal(&v, &truncated) {
+				t.Errorf("Got %v, want %v", v, truncated)
+			}
+			if !reflect.DeepEqual(truncations, expectedTruncations) {
+				t.Errorf("Got truncations %+v, want %+v", truncations, expectedTruncations)
+			}
+
+			for _, cfg := range []*TConfiguration{
+				{MaxStringSize: 8},
+				{MaxContainerSize: 2},
+			} {
+				_, _, err = read(cfg)
+				var te TProtocolException
+				if !errors.As(err, &te) || te.TypeId() != SIZE_LIMIT {
+					t.Errorf("With %+v, got error %v, want a SIZE_LIMIT TProtocolException", cfg, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldSizeLimitsBinary(t *testing.T) {
+	for name, factory := range fieldSizeLimitProtocols() {
+		t.Run(name, func(t *testing.T) {
+			ctx := WithFieldTruncations(context.Background())
+			buf := NewTMemoryBuffer()
+			out := factory.GetProtocol(buf)
+			out.WriteBinary(ctx, []byte("0123456789"))
+			out.WriteI32(ctx, 42)
+			out.Flush(ctx)
+
+			in := TProtocolFactoryConf(factory, &TConfiguration{
+				MaxBinarySize:   4,
+				FieldSizePolicy: FieldSizeTruncate,
+			}).GetProtocol(buf)
+			b, err := in.ReadBinary(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "0123" {
+				t.Errorf("Got %q, want %q", b, "0123")
+			}
+			if i, err := in.ReadI32(ctx); err != nil || i != 42 {
+				t.Errorf("Got %d, %v after the binary, want 42", i, err)
+			}
+			expected := []FieldTruncation{{Type: STRING, Size: 10, MaxSize: 4}}
+			if truncations := FieldTruncationsFromContext(ctx); !reflect.DeepEqual(truncations, expected) {
+				t.Errorf("Got truncations %+v, want %+v", truncations, expected)
+			}
+		})
+	}
+}
+
+func TestFieldTruncationProcessorMiddleware(t *testing.T) {
+	ctx := context.Background()
+	buf := NewTMemoryBuffer()
+	proto := NewTBinaryProtocolConf(buf, &TConfiguration{
+		MaxStringSize:   2,
+		FieldSizePolicy: FieldSizeTruncate,
+	})
+	v := StructValue(ValueField{ID: 1, Value: StringValue("abc")})
+	if err := v.Write(ctx, proto); err != nil {
+		t.Fatal(err)
+	}
+
+	var truncations []FieldTruncation
+	process := FieldTruncationProcessorMiddleware("test", WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			if _, err := ReadValue(ctx, in, STRUCT); err != nil {
+				t.Fatal(err)
+			}
+			truncations = FieldTruncationsFromContext(ctx)
+			return true, nil
+		},
+	})
+	process.Process(ctx, 1, proto, proto)
+	expected := []FieldTruncation{{Type: STRING, Size: 3, MaxSize: 2}}
+	if !reflect.DeepEqual(truncations, expected) {
+		t.Errorf("Got truncations %+v, want %+v", truncations, expected)
+	}
+	if truncations := FieldTruncationsFromContext(ctx); truncations != nil {
+		t.Errorf("Got truncations %+v outside of the middleware", truncations)
+	}
+}
+
+func TestFieldSizeLimitsAfterFailedRead(t *testing.T) {
+	ctx := context.Background()
+	in := StructValue(ValueField{ID: 1, Value: ListValue(I32, I32Value(1), I32Value(2), I32Value(3), I32Value(4), I32Value(5))})
+	data, err := NewTSerializer().Write(ctx, &in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewTDeserializer()
+	d.Protocol = NewTBinaryProtocolConf(d.Transport, &TConfiguration{
+		MaxContainerSize: 2,
+		FieldSizePolicy:  FieldSizeTruncate,
+	})
+	// Cut in the middle of the elements kept of the truncated list.
+	var v Value
+	if err := d.Read(ctx, &v, data[:3+5+6]); err == nil {
+		t.Fatal("Expected the read of the cut struct to fail")
+	}
+
+	// The truncated list of the failed read is not skipped anymore.
+	PropagateTConfiguration(d.Protocol, &TConfiguration{})
+	if err := d.Read(ctx, &v, data); err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(&v, &in) {
+		t.Errorf("Got %v, want %v", v, in)
+	}
+}
+
+// sizeLimitReader fails the reads past its first left bytes if left >= 0,
+// and records the size of its largest read.
+type sizeLimitReader struct {
+	TTransport
+
+	left    int
+	maxRead int
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	if r.left >= 0 {
+		if r.left == 0 {
+			return 0, errors.New("read past the limit")
+		}
+		if len(p) > r.left {
+			p = p[:r.left]
+		}
+	}
+	n, err := r.TTransport.Read(p)
+	if r.left >= 0 {
+		r.left -= n
+	}
+	if n > r.maxRead {
+		r.maxRead = n
+	}
+	return n, err
+}
+
+func TestFieldSizeLimitsNotBuffered(t *testing.T) {
+	const size, max = 1 << 20, 16
+	value := strings.Repeat("x", size)
+	for name, factory := range map[string]TProtocolFactory{
+		"binary":  NewTBinaryProtocolFactoryConf(nil),
+		"compact": NewTCompactProtocolFactoryConf(nil),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			buf := NewTMemoryBuffer()
+			out := factory.GetProtocol(buf)
+			out.WriteString(ctx, value)
+			out.Flush(ctx)
+			// The size of the length prefix.
+			prefix := buf.Len() - size
+			out.WriteBinary(ctx, []byte(value))
+			out.WriteI32(ctx, 42)
+			out.Flush(ctx)
+			data := buf.Bytes()
+
+			// read returns a protocol reading data from offset, allowed to
+			// read left bytes if left >= 0.
+			read := func(policy TFieldSizePolicy, offset, left int) (*sizeLimitReader, TProtocol) {
+				r := &sizeLimitReader{TTransport: NewTMemoryBuffer(), left: left}
+				r.TTransport.Write(data[offset:])
+				return r, TProtocolFactoryConf(factory, &TConfiguration{
+					MaxStringSize:   max,
+					MaxBinarySize:   max,
+					FieldSizePolicy: policy,
+				}).GetProtocol(r)
+			}
+
+			// The values over the limit are rejected from their size,
+			// without reading them.
+			_, in := read(FieldSizeError, 0, prefix)
+			_, err := in.ReadString(ctx)
+			var te TProtocolException
+			if !errors.As(err, &te) || te.TypeId() != SIZE_LIMIT {
+				t.Errorf("Got string error %v, want a SIZE_LIMIT TProtocolException", err)
+			}
+			_, in = read(FieldSizeError, prefix+size, prefix)
+			_, err = in.ReadBinary(ctx)
+			if !errors.As(err, &te) || te.TypeId() != SIZE_LIMIT {
+				t.Errorf("Got binary error %v, want a SIZE_LIMIT TProtocolException", err)
+			}
+
+			// Only the bytes kept of the truncated value are buffered, the
+			// rest is skipped in small reads.
+			r, in := read(FieldSizeTruncate, 0, -1)
+			s, err := in.ReadString(ctx)
+			if err != nil || s != value[:max] {
+				t.Errorf("Got string %q, %v, want %q", s, err, value[:max])
+			}
+			b, err := in.ReadBinary(ctx)
+			if err != nil || string(b) != value[:max] {
+				t.Errorf("Got binary %q, %v, want %q", b, err, value[:max])
+			}
+			if i, err := in.ReadI32(ctx); err != nil || i != 42 {
+				t.Errorf("Got %d, %v after the values, want 42", i, err)
+			}
+			if r.maxRead >= size/4 {
+				t.Errorf("Got a read of %d bytes, want less than %d", r.maxRead, size/4)
+			}
+		})
+	}
+}
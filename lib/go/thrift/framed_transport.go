@@ -125,6 +125,10 @@ func (p *TFramedTransport) IsOpen() bool {
 }
 
 func (p *TFramedTransport) Close() error {
+	// Discard what's left of the frame read, so that the transport can be
+	// reopened.
+	p.readBuf.Reset()
+	p.reader.Reset(p.transport)
 	return p.transport.Close()
 }
 
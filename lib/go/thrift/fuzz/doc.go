@@ -0,0 +1,44 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package fuzz provides native Go fuzz targets for the read paths of the
+// thrift protocols, and helpers to fuzz the Read methods of the generated
+// structs with them.
+//
+// FuzzRead and FuzzReadMessage check that the structs, or messages, decoded
+// from the fuzzed inputs don't panic and round trip: written back and read
+// again, they must be equal to the ones first read. Their seeds are added
+// with AddCorpus and AddMessageCorpus:
+//
+//	func FuzzUserRead(f *testing.F) {
+//		factory := thrift.NewTCompactProtocolFactoryConf(&thrift.TConfiguration{MaxMessageSize: 1 << 20})
+//		fuzz.AddCorpus(f, factory, &User{ID: 1}, &User{ID: 2, Name: thrift.StringPtr("alice")})
+//		fuzz.FuzzRead(f, factory, func() thrift.TStruct { return NewUser() })
+//	}
+//
+// Then run it with:
+//
+//	go test -fuzz FuzzUserRead
+//
+// The protocol factories should limit the MaxMessageSize of their
+// TConfiguration, so that the fuzzed sizes of the strings and containers
+// don't exhaust the memory.
+//
+// The package requires Go 1.18 or later.
+package fuzz
@@ -0,0 +1,214 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package fuzz
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Corpus returns msgs written with the protocols of factory, the seeds of
+// FuzzRead, for example to save them as the corpus of another fuzzer.
+func Corpus(factory thrift.TProtocolFactory, msgs ...thrift.TStruct) ([][]byte, error) {
+	ctx := context.Background()
+	corpus := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		buf := thrift.NewTMemoryBuffer()
+		out := factory.GetProtocol(buf)
+		if err := msg.Write(ctx, out); err != nil {
+			return nil, err
+		}
+		if err := out.Flush(ctx); err != nil {
+			return nil, err
+		}
+		corpus = append(corpus, buf.Bytes())
+	}
+	return corpus, nil
+}
+
+// MessageCorpus returns msgs written as the arguments of calls to name with
+// the protocols of factory, the seeds of FuzzReadMessage.
+func MessageCorpus(factory thrift.TProtocolFactory, name string, msgs ...thrift.TStruct) ([][]byte, error) {
+	ctx := context.Background()
+	corpus := make([][]byte, 0, len(msgs))
+	for i, msg := range msgs {
+		buf := thrift.NewTMemoryBuffer()
+		if err := writeMessage(ctx, factory.GetProtocol(buf), name, thrift.CALL, int32(i), msg); err != nil {
+			return nil, err
+		}
+		corpus = append(corpus, buf.Bytes())
+	}
+	return corpus, nil
+}
+
+// AddCorpus adds the Corpus of msgs to the seeds of f.
+func AddCorpus(f *testing.F, factory thrift.TProtocolFactory, msgs ...thrift.TStruct) {
+	f.Helper()
+	corpus, err := Corpus(factory, msgs...)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, data := range corpus {
+		f.Add(data)
+	}
+}
+
+// AddMessageCorpus adds the MessageCorpus of msgs to the seeds of f.
+func AddMessageCorpus(f *testing.F, factory thrift.TProtocolFactory, name string, msgs ...thrift.TStruct) {
+	f.Helper()
+	corpus, err := MessageCorpus(factory, name, msgs...)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, data := range corpus {
+		f.Add(data)
+	}
+}
+
+// FuzzRead fuzzes the Read method of the structs of newMsg with the
+// protocols of factory, see Read.
+func FuzzRead(f *testing.F, factory thrift.TProtocolFactory, newMsg func() thrift.TStruct) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Read(t, factory, newMsg, data)
+	})
+}
+
+// FuzzReadMessage fuzzes the reading of the messages with the protocols of
+// factory, their structs being the ones of newMsg, see ReadMessage.
+func FuzzReadMessage(f *testing.F, factory thrift.TProtocolFactory, newMsg func() thrift.TStruct) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ReadMessage(t, factory, newMsg, data)
+	})
+}
+
+// Read reads the struct of newMsg from data with a protocol of factory. If
+// that succeeds, it writes the struct back, and fails t if it can't be read
+// again, or if it isn't equal to the struct first read.
+//
+// The structs which can't be written back, like the unions with several
+// fields set, aren't checked.
+func Read(t *testing.T, factory thrift.TProtocolFactory, newMsg func() thrift.TStruct, data []byte) {
+	t.Helper()
+	if err := roundTrip(factory, newMsg, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ReadMessage is Read for a message, whose arguments or result is the struct
+// of newMsg, also checking that its name, type and sequence id round trip.
+func ReadMessage(t *testing.T, factory thrift.TProtocolFactory, newMsg func() thrift.TStruct, data []byte) {
+	t.Helper()
+	if err := roundTripMessage(factory, newMsg, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// roundTrip returns the failure of Read, nil if there's none.
+func roundTrip(factory thrift.TProtocolFactory, newMsg func() thrift.TStruct, data []byte) error {
+	ctx := context.Background()
+	msg := newMsg()
+	if err := msg.Read(ctx, factory.GetProtocol(memoryBuffer(data))); err != nil {
+		return nil
+	}
+	buf := thrift.NewTMemoryBuffer()
+	out := factory.GetProtocol(buf)
+	if err := msg.Write(ctx, out); err != nil {
+		return nil
+	}
+	if err := out.Flush(ctx); err != nil {
+		return fmt.Errorf("flushing the struct read: %w", err)
+	}
+	again := newMsg()
+	if err := again.Read(ctx, factory.GetProtocol(buf)); err != nil {
+		return fmt.Errorf("reading the struct written back: %w", err)
+	}
+	if !equal(msg, again) {
+		return fmt.Errorf("read %v, then %v once written back", msg, again)
+	}
+	return nil
+}
+
+// roundTripMessage returns the failure of ReadMessage, nil if there's none.
+func roundTripMessage(factory thrift.TProtocolFactory, newMsg func() thrift.TStruct, data []byte) error {
+	ctx := context.Background()
+	name, typeID, seqID, msg, err := readMessage(ctx, factory.GetProtocol(memoryBuffer(data)), newMsg)
+	if err != nil {
+		return nil
+	}
+	buf := thrift.NewTMemoryBuffer()
+	if err := writeMessage(ctx, factory.GetProtocol(buf), name, typeID, seqID, msg); err != nil {
+		return nil
+	}
+	name2, typeID2, seqID2, again, err := readMessage(ctx, factory.GetProtocol(buf), newMsg)
+	if err != nil {
+		return fmt.Errorf("reading the message written back: %w", err)
+	}
+	if name2 != name || typeID2 != typeID || seqID2 != seqID {
+		return fmt.Errorf("read the message %q of type %d and sequence id %d, then %q, %d and %d once written back", name, typeID, seqID, name2, typeID2, seqID2)
+	}
+	if !equal(msg, again) {
+		return fmt.Errorf("read %v, then %v once written back", msg, again)
+	}
+	return nil
+}
+
+func readMessage(ctx context.Context, in thrift.TProtocol, newMsg func() thrift.TStruct) (string, thrift.TMessageType, int32, thrift.TStruct, error) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return "", 0, 0, nil, err
+	}
+	msg := newMsg()
+	if err := msg.Read(ctx, in); err != nil {
+		return "", 0, 0, nil, err
+	}
+	if err := in.ReadMessageEnd(ctx); err != nil {
+		return "", 0, 0, nil, err
+	}
+	return name, typeID, seqID, msg, nil
+}
+
+func writeMessage(ctx context.Context, out thrift.TProtocol, name string, typeID thrift.TMessageType, seqID int32, msg thrift.TStruct) error {
+	if err := out.WriteMessageBegin(ctx, name, typeID, seqID); err != nil {
+		return err
+	}
+	if err := msg.Write(ctx, out); err != nil {
+		return err
+	}
+	if err := out.WriteMessageEnd(ctx); err != nil {
+		return err
+	}
+	return out.Flush(ctx)
+}
+
+func memoryBuffer(data []byte) *thrift.TMemoryBuffer {
+	return &thrift.TMemoryBuffer{Buffer: bytes.NewBuffer(data)}
+}
+
+// equal compares the structs read by the fuzzers, whose doubles may be NaN.
+func equal(a, b thrift.TStruct) bool {
+	return thrift.EqualOptions{NaNEqual: true}.Equal(a, b)
+}
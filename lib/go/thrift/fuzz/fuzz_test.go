@@ -0,0 +1,154 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package fuzz
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// fuzzConf limits the sizes decoded by the fuzz targets.
+var fuzzConf = &thrift.TConfiguration{
+	MaxMessageSize: 1 << 20,
+	MaxFrameSize:   1 << 20,
+}
+
+// seeds are structs of every type, as Values.
+func seeds() []thrift.TStruct {
+	scalars := thrift.StructValue(
+		thrift.ValueField{ID: 1, Value: thrift.BoolValue(true)},
+		thrift.ValueField{ID: 2, Value: thrift.ByteValue(-1)},
+		thrift.ValueField{ID: 3, Value: thrift.I16Value(300)},
+		thrift.ValueField{ID: 4, Value: thrift.I32Value(-70000)},
+		thrift.ValueField{ID: 5, Value: thrift.I64Value(math.MaxInt64)},
+		thrift.ValueField{ID: 6, Value: thrift.DoubleValue(math.Pi)},
+		thrift.ValueField{ID: 7, Value: thrift.StringValue("seed")},
+	)
+	containers := thrift.StructValue(
+		thrift.ValueField{ID: 1, Value: thrift.ListValue(thrift.I32, thrift.I32Value(1), thrift.I32Value(2))},
+		thrift.ValueField{ID: 2, Value: thrift.SetValue(thrift.STRING, thrift.StringValue("a"), thrift.StringValue("b"))},
+		thrift.ValueField{ID: 3, Value: thrift.MapValue(thrift.STRING, thrift.STRUCT, thrift.ValueMapEntry{
+			Key:   thrift.StringValue("nested"),
+			Value: scalars,
+		})},
+		thrift.ValueField{ID: 4, Value: thrift.ListValue(thrift.BOOL, thrift.BoolValue(false), thrift.BoolValue(true))},
+	)
+	return []thrift.TStruct{&thrift.Value{Type: thrift.STRUCT}, &scalars, &containers}
+}
+
+func newValue() thrift.TStruct {
+	return &thrift.Value{}
+}
+
+func FuzzBinary(f *testing.F) {
+	factory := thrift.NewTBinaryProtocolFactoryConf(fuzzConf)
+	AddCorpus(f, factory, seeds()...)
+	FuzzRead(f, factory, newValue)
+}
+
+func FuzzCompact(f *testing.F) {
+	factory := thrift.NewTCompactProtocolFactoryConf(fuzzConf)
+	AddCorpus(f, factory, seeds()...)
+	FuzzRead(f, factory, newValue)
+}
+
+// jsonProtocolFactory creates the TJSONProtocols of fuzzConf, which
+// TJSONProtocolFactory has no constructor for.
+type jsonProtocolFactory struct{}
+
+func (jsonProtocolFactory) GetProtocol(trans thrift.TTransport) thrift.TProtocol {
+	p := thrift.NewTJSONProtocol(trans)
+	p.SetTConfiguration(fuzzConf)
+	return p
+}
+
+func FuzzJSON(f *testing.F) {
+	factory := jsonProtocolFactory{}
+	AddCorpus(f, factory, seeds()...)
+	FuzzRead(f, factory, newValue)
+}
+
+func FuzzHeader(f *testing.F) {
+	for _, protocol := range []thrift.THeaderProtocolID{thrift.THeaderProtocolBinary, thrift.THeaderProtocolCompact} {
+		protocol := protocol
+		conf := *fuzzConf
+		conf.THeaderProtocolID = &protocol
+		AddMessageCorpus(f, thrift.NewTHeaderProtocolFactoryConf(&conf), "call", seeds()...)
+	}
+	FuzzReadMessage(f, thrift.NewTHeaderProtocolFactoryConf(fuzzConf), newValue)
+}
+
+func TestCorpus(t *testing.T) {
+	factory := thrift.NewTCompactProtocolFactoryConf(fuzzConf)
+	corpus, err := Corpus(factory, seeds()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corpus) != len(seeds()) {
+		t.Fatalf("expected %d seeds, got %d", len(seeds()), len(corpus))
+	}
+	for _, data := range corpus {
+		Read(t, factory, newValue, data)
+	}
+
+	// The structs which don't round trip fail the tests.
+	notRoundTripping := func() thrift.TStruct { return &tNotRoundTripping{} }
+	if err := roundTrip(factory, notRoundTripping, corpus[1]); err == nil {
+		t.Error("expected a failure for a struct not round tripping")
+	}
+	header := thrift.NewTHeaderProtocolFactoryConf(fuzzConf)
+	messages, err := MessageCorpus(header, "call", seeds()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range messages {
+		ReadMessage(t, header, newValue, data)
+	}
+	if err := roundTripMessage(header, notRoundTripping, messages[1]); err == nil {
+		t.Error("expected a failure for a message not round tripping")
+	}
+}
+
+// tNotRoundTripping reads the structs with fields only, and writes empty
+// ones.
+type tNotRoundTripping struct {
+	thrift.Value
+}
+
+func (s *tNotRoundTripping) Read(ctx context.Context, in thrift.TProtocol) error {
+	if err := s.Value.Read(ctx, in); err != nil {
+		return err
+	}
+	if len(s.Fields) == 0 {
+		return errors.New("empty struct")
+	}
+	return nil
+}
+
+func (s *tNotRoundTripping) Write(ctx context.Context, out thrift.TProtocol) error {
+	return (&thrift.Value{Type: thrift.STRUCT}).Write(ctx, out)
+}
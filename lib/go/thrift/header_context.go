@@ -21,6 +21,7 @@ package thrift
 
 import (
 	"context"
+	"sync"
 )
 
 // See https://godoc.org/context#WithValue on why do we need the unexported typedefs.
@@ -108,3 +109,107 @@ func AddReadTHeaderToContext(ctx context.Context, headers THeaderMap) context.Co
 	}
 	return SetReadHeaderList(ctx, keys)
 }
+
+// addWriteHeader sets a header in the context and adds its key to the key list
+// of THeaders to write, if not already there.
+func addWriteHeader(ctx context.Context, key, value string) context.Context {
+	ctx = SetHeader(ctx, key, value)
+	existing := GetWriteHeaderList(ctx)
+	for _, k := range existing {
+		if k == key {
+			return ctx
+		}
+	}
+	keys := make([]string, len(existing), len(existing)+1)
+	copy(keys, existing)
+	return SetWriteHeaderList(ctx, append(keys, key))
+}
+
+// AppendOutgoingHeader returns a copy of ctx with a THeader header sent with
+// the calls made with it, in addition to the ones already set in ctx. A header
+// already set with the same key is replaced.
+//
+// It's meant for the client middlewares and applications attaching headers to
+// a call, and requires THeaderProtocol.
+func AppendOutgoingHeader(ctx context.Context, key, value string) context.Context {
+	return addWriteHeader(ctx, key, value)
+}
+
+// OutgoingHeadersFromContext returns the THeader headers sent with the calls
+// made with ctx, nil if none.
+func OutgoingHeadersFromContext(ctx context.Context) THeaderMap {
+	keys := GetWriteHeaderList(ctx)
+	if len(keys) == 0 {
+		return nil
+	}
+	headers := make(THeaderMap, len(keys))
+	for _, key := range keys {
+		if value, ok := GetHeader(ctx, key); ok {
+			headers[key] = value
+		}
+	}
+	return headers
+}
+
+// tIncomingHeaders records the headers of the response of a call, see
+// WithIncomingHeaders.
+type tIncomingHeaders struct {
+	mu      sync.Mutex
+	headers THeaderMap
+}
+
+type incomingHeadersKey struct{}
+
+// WithIncomingHeaders returns a copy of ctx recording the THeader headers of
+// the response of the call made with it, returned by
+// IncomingHeadersFromContext once the call completes, so that they're
+// available to the callers of the generated clients.
+//
+// The headers are recorded by TStandardClient and TPipelinedClient. With
+// HedgingMiddleware, they're the ones of the winning attempt.
+func WithIncomingHeaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, incomingHeadersKey{}, &tIncomingHeaders{})
+}
+
+// IncomingHeadersFromContext returns the THeader headers received with ctx,
+// nil if none: the headers of the response of the call made with ctx if it
+// was returned by WithIncomingHeaders, or the headers of the request in the
+// context of a server handler otherwise.
+func IncomingHeadersFromContext(ctx context.Context) THeaderMap {
+	if recorded, ok := ctx.Value(incomingHeadersKey{}).(*tIncomingHeaders); ok && recorded != nil {
+		recorded.mu.Lock()
+		defer recorded.mu.Unlock()
+		return recorded.headers
+	}
+	keys := GetReadHeaderList(ctx)
+	if len(keys) == 0 {
+		return nil
+	}
+	headers := make(THeaderMap, len(keys))
+	for _, key := range keys {
+		if value, ok := GetHeader(ctx, key); ok {
+			headers[key] = value
+		}
+	}
+	return headers
+}
+
+// recordIncomingHeaders records the headers of the response of the call made
+// with ctx, if ctx was returned by WithIncomingHeaders.
+func recordIncomingHeaders(ctx context.Context, headers THeaderMap) {
+	if recorded, ok := ctx.Value(incomingHeadersKey{}).(*tIncomingHeaders); ok && recorded != nil {
+		recorded.mu.Lock()
+		defer recorded.mu.Unlock()
+		recorded.headers = headers
+	}
+}
+
+// withoutIncomingHeaders returns a copy of ctx whose calls don't record the
+// headers of their responses, for the calls whose headers are recorded by
+// their caller instead.
+func withoutIncomingHeaders(ctx context.Context) context.Context {
+	if ctx.Value(incomingHeadersKey{}) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, incomingHeadersKey{}, (*tIncomingHeaders)(nil))
+}
@@ -21,6 +21,7 @@ package thrift
 
 import (
 	"context"
+	"net"
 	"reflect"
 	"testing"
 )
@@ -137,3 +138,54 @@ func TestWriteKeyList(t *testing.T) {
 		)
 	}
 }
+
+func TestOutgoingHeaders(t *testing.T) {
+	ctx := AppendOutgoingHeader(context.Background(), "tenant", "a")
+	ctx = AppendOutgoingHeader(ctx, "user", "u")
+	ctx = AppendOutgoingHeader(ctx, "tenant", "b")
+	expected := THeaderMap{"tenant": "b", "user": "u"}
+	if got := OutgoingHeadersFromContext(ctx); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected outgoing headers %+v, got %+v", expected, got)
+	}
+	if got := OutgoingHeadersFromContext(context.Background()); got != nil {
+		t.Errorf("Expected no outgoing headers, got %+v", got)
+	}
+}
+
+func TestIncomingHeaders(t *testing.T) {
+	// The headers of the request in a server handler.
+	request := THeaderMap{"tenant": "a"}
+	if got := IncomingHeadersFromContext(AddReadTHeaderToContext(context.Background(), request)); !reflect.DeepEqual(got, request) {
+		t.Errorf("Expected request headers %+v, got %+v", request, got)
+	}
+
+	// The headers of the response of a call.
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go func() {
+		ctx := context.Background()
+		proto := NewTHeaderProtocolConf(NewTSocketFromConnConf(serverConn, nil), nil)
+		name, _, seqID, err := proto.ReadMessageBegin(ctx)
+		if err != nil {
+			return
+		}
+		value, _ := readStringArgs(ctx, proto)
+		proto.SetWriteHeader("served-by", "s1")
+		proto.SetWriteHeader("tenant", proto.GetReadHeaders()["tenant"])
+		proto.WriteMessageBegin(ctx, name, REPLY, seqID)
+		writeStringArgs(ctx, proto, value)
+		proto.Flush(ctx)
+	}()
+	proto := NewTHeaderProtocolConf(NewTSocketFromConnConf(clientConn, nil), nil)
+	defer proto.Transport().Close()
+	client := NewTStandardClient(proto, proto)
+
+	ctx := WithIncomingHeaders(AppendOutgoingHeader(context.Background(), "tenant", "b"))
+	if _, err := client.Call(ctx, "echo", &pipelinedString{"value"}, &pipelinedString{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := THeaderMap{"served-by": "s1", "tenant": "b"}
+	if got := IncomingHeadersFromContext(ctx); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected response headers %+v, got %+v", expected, got)
+	}
+}
@@ -123,6 +123,11 @@ func (p *THeaderProtocol) AddTransform(transform THeaderTransformID) error {
 	return p.transport.AddTransform(transform)
 }
 
+// SetWriteTransforms replaces the transforms for writing, nil removing them.
+func (p *THeaderProtocol) SetWriteTransforms(transforms []THeaderTransformID) error {
+	return p.transport.SetWriteTransforms(transforms)
+}
+
 func (p *THeaderProtocol) Flush(ctx context.Context) error {
 	return p.transport.Flush(ctx)
 }
@@ -262,6 +267,10 @@ func (p *THeaderProtocol) ReadMessageEnd(ctx context.Context) error {
 	return p.protocol.ReadMessageEnd(ctx)
 }
 
+func (p *THeaderProtocol) resetFieldSizeLimits() {
+	resetFieldSizeLimits(p.protocol)
+}
+
 func (p *THeaderProtocol) ReadStructBegin(ctx context.Context) (name string, err error) {
 	return p.protocol.ReadStructBegin(ctx)
 }
@@ -690,6 +690,11 @@ func (t *THeaderTransport) Close() error {
 	if err := t.Flush(context.Background()); err != nil {
 		return err
 	}
+	// Discard what's left of the frame read, so that the transport can be
+	// reopened.
+	t.frameReader = nil
+	t.frameBuffer.Reset()
+	t.reader.Reset(t.transport)
 	return t.transport.Close()
 }
 
@@ -731,6 +736,20 @@ func (t *THeaderTransport) AddTransform(transform THeaderTransformID) error {
 	return nil
 }
 
+// SetWriteTransforms replaces the transforms for writing, nil removing them.
+func (t *THeaderTransport) SetWriteTransforms(transforms []THeaderTransformID) error {
+	for _, transform := range transforms {
+		if !supportedTransformIDs[transform] {
+			return NewTProtocolExceptionWithType(
+				NOT_IMPLEMENTED,
+				fmt.Errorf("THeaderTransformID %d not supported", transform),
+			)
+		}
+	}
+	t.writeTransforms = append(t.writeTransforms[:0], transforms...)
+	return nil
+}
+
 // Protocol returns the wrapped protocol id used in this THeaderTransport.
 func (t *THeaderTransport) Protocol() THeaderProtocolID {
 	switch t.clientType {
@@ -0,0 +1,36 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package health
+
+import (
+	"context"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Check returns the serving status of service, or of the whole server if
+// service is empty, from the Health service client is connected to.
+func Check(ctx context.Context, client thrift.TClient, service string) (ServingStatus, error) {
+	response, err := NewHealthClient(client).Check(ctx, &HealthCheckRequest{Service: service})
+	if err != nil {
+		return ServingStatus_UNKNOWN, err
+	}
+	return response.GetStatus(), nil
+}
@@ -0,0 +1,38 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package health implements the standard thrift health checking service,
+// defined in health.thrift, so that load balancers and orchestrators can
+// probe thrift servers uniformly.
+//
+// Servers register a Server handler, usually in a TMultiplexedProcessor
+// under ServiceName, and set the serving status of their services with
+// SetServingStatus:
+//
+//	healthServer := health.NewServer()
+//	processor.RegisterProcessor(health.ServiceName, health.NewHealthProcessor(healthServer))
+//	healthServer.SetServingStatus("MyService", health.ServingStatus_SERVING)
+//
+// Clients check them with the Check function:
+//
+//	oprot := thrift.NewTMultiplexedProtocol(protocol, health.ServiceName)
+//	status, err := health.Check(ctx, thrift.NewTStandardClient(protocol, oprot), "MyService")
+package health
+
+//go:generate thrift -out .. --gen go:thrift_import=github.com/apache/thrift/lib/go/thrift,package_prefix=github.com/apache/thrift/lib/go/thrift/ health.thrift
@@ -0,0 +1,56 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Health checking service, modeled after the gRPC health checking protocol.
+//
+// The service is usually registered in a TMultiplexedProcessor under the
+// name "Health", next to the services it reports the status of.
+
+namespace go health
+
+/**
+ * The serving status of a service.
+ */
+enum ServingStatus {
+  UNKNOWN = 0,
+  SERVING = 1,
+  NOT_SERVING = 2,
+  /**
+   * Returned when the requested service is not registered.
+   */
+  SERVICE_UNKNOWN = 3,
+}
+
+struct HealthCheckRequest {
+  /**
+   * The name of the service to check, empty for the whole server.
+   */
+  1: string service
+}
+
+struct HealthCheckResponse {
+  1: ServingStatus status
+}
+
+service Health {
+  /**
+   * Returns the serving status of the requested service.
+   */
+  HealthCheckResponse check(1: HealthCheckRequest request)
+}
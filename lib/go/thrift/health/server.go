@@ -0,0 +1,97 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package health
+
+import (
+	"context"
+	"sync"
+)
+
+// ServiceName is the name the Health service is usually registered under in
+// a TMultiplexedProcessor.
+const ServiceName = "Health"
+
+// Server is a Health handler reporting the serving statuses set with
+// SetServingStatus.
+//
+// The whole server, checked with an empty service name, is SERVING
+// initially. The services never set are SERVICE_UNKNOWN.
+type Server struct {
+	mu       sync.RWMutex
+	statuses map[string]ServingStatus
+	shutdown bool
+}
+
+// NewServer creates a Server.
+func NewServer() *Server {
+	return &Server{
+		statuses: map[string]ServingStatus{
+			"": ServingStatus_SERVING,
+		},
+	}
+}
+
+// SetServingStatus sets the serving status of service, or of the whole server
+// if service is empty.
+//
+// It's ignored after Shutdown, until Resume is called.
+func (s *Server) SetServingStatus(service string, status ServingStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return
+	}
+	s.statuses[service] = status
+}
+
+// Shutdown sets all the statuses to NOT_SERVING, for example for load
+// balancers to stop sending new requests before the server is stopped.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	for service := range s.statuses {
+		s.statuses[service] = ServingStatus_NOT_SERVING
+	}
+}
+
+// Resume sets all the statuses to SERVING, and lets SetServingStatus update
+// them again.
+func (s *Server) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = false
+	for service := range s.statuses {
+		s.statuses[service] = ServingStatus_SERVING
+	}
+}
+
+// Check implements Health.
+func (s *Server) Check(ctx context.Context, request *HealthCheckRequest) (*HealthCheckResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status, ok := s.statuses[request.GetService()]
+	if !ok {
+		status = ServingStatus_SERVICE_UNKNOWN
+	}
+	return &HealthCheckResponse{Status: status}, nil
+}
+
+var _ Health = (*Server)(nil)
@@ -0,0 +1,110 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package health
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// newTestClient serves processor over a pipe and returns a client of the
+// Health service multiplexed in it.
+func newTestClient(t *testing.T, processor thrift.TProcessor) thrift.TClient {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+	})
+	go func() {
+		defer serverConn.Close()
+		proto := thrift.NewTBinaryProtocolConf(thrift.NewTFramedTransportConf(thrift.NewTSocketFromConnConf(serverConn, nil), nil), nil)
+		for {
+			if ok, err := processor.Process(context.Background(), proto, proto); !ok || err != nil {
+				return
+			}
+		}
+	}()
+	proto := thrift.NewTBinaryProtocolConf(thrift.NewTFramedTransportConf(thrift.NewTSocketFromConnConf(clientConn, nil), nil), nil)
+	return thrift.NewTStandardClient(proto, thrift.NewTMultiplexedProtocol(proto, ServiceName))
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer()
+	multiplexed := thrift.NewTMultiplexedProcessor()
+	multiplexed.RegisterProcessor(ServiceName, NewHealthProcessor(server))
+	client := newTestClient(t, multiplexed)
+
+	ctx := context.Background()
+	check := func(service string, expected ServingStatus) {
+		t.Helper()
+		status, err := Check(ctx, client, service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != expected {
+			t.Errorf("expected %q to be %v, got %v", service, expected, status)
+		}
+	}
+
+	check("", ServingStatus_SERVING)
+	check("MyService", ServingStatus_SERVICE_UNKNOWN)
+	server.SetServingStatus("MyService", ServingStatus_SERVING)
+	check("MyService", ServingStatus_SERVING)
+	server.SetServingStatus("MyService", ServingStatus_NOT_SERVING)
+	check("MyService", ServingStatus_NOT_SERVING)
+
+	server.Shutdown()
+	check("", ServingStatus_NOT_SERVING)
+	check("MyService", ServingStatus_NOT_SERVING)
+	server.SetServingStatus("MyService", ServingStatus_SERVING)
+	check("MyService", ServingStatus_NOT_SERVING)
+
+	server.Resume()
+	check("", ServingStatus_SERVING)
+	check("MyService", ServingStatus_SERVING)
+}
+
+func TestSharedClient(t *testing.T) {
+	multiplexed := thrift.NewTMultiplexedProcessor()
+	multiplexed.RegisterProcessor(ServiceName, NewHealthProcessor(NewServer()))
+	client := NewHealthClient(thrift.NewTSynchronizedClient(newTestClient(t, multiplexed)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Check(context.Background(), &HealthCheckRequest{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if resp.Status != ServingStatus_SERVING {
+				t.Errorf("expected SERVING, got %v", resp.Status)
+			}
+			client.LastResponseMeta_()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,188 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Default values of HedgingOptions.
+const (
+	DefaultMaxHedges     = 1
+	DefaultHedgingBudget = 0.1
+)
+
+// hedgingBurst is the maximum number of hedges banked by the budget of a
+// HedgingMiddleware.
+const hedgingBurst = 10
+
+// HedgingOptions configures HedgingMiddleware.
+type HedgingOptions struct {
+	// Idempotent reports whether method can be sent more than once, only
+	// the calls of these methods are hedged. It must not be nil.
+	Idempotent func(method string) bool
+
+	// Delay is how long to wait for a reply before sending another
+	// attempt of the call, usually around the 95th percentile latency of
+	// the method.
+	Delay time.Duration
+
+	// MaxHedges is the maximum number of additional attempts of a call,
+	// DefaultMaxHedges if 0.
+	MaxHedges int
+
+	// Budget is the number of hedges earned by every call of an idempotent
+	// method, between 0 and 1, so that the hedges can't add more than this
+	// fraction of load to the servers, DefaultHedgingBudget if 0. Up to
+	// 10 unused hedges are kept for the bursts of slow calls. It's ignored
+	// if RetryBudget is set.
+	Budget float64
+
+	// RetryBudget, if not nil, is the budget of the hedges instead of
+	// Budget, shared with the other middlewares using it, e.g. a
+	// RetryMiddleware.
+	RetryBudget *RetryBudget
+
+	// OnHedge is called for every additional attempt sent, for example to
+	// record metrics.
+	OnHedge func(ctx context.Context, method string)
+}
+
+// HedgingMiddleware returns a ClientMiddleware sending another attempt of the
+// calls of idempotent methods when they haven't been replied after a delay,
+// taking the first successful reply and canceling the other attempts, to cut
+// the tail latency.
+//
+// With a TBalancedClient, the attempts are sent to different endpoints when
+// possible. The wrapped TClient must be safe for concurrent use, as the
+// attempts are sent concurrently. The calls fail with the error of their
+// last attempt if none of them succeed.
+func HedgingMiddleware(opts HedgingOptions) ClientMiddleware {
+	if opts.MaxHedges <= 0 {
+		opts.MaxHedges = DefaultMaxHedges
+	}
+	if opts.Budget <= 0 {
+		opts.Budget = DefaultHedgingBudget
+	}
+	return func(next TClient) TClient {
+		budget := &tHedgingBudget{}
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				if opts.RetryBudget != nil {
+					ctx = opts.RetryBudget.deposit(ctx)
+				}
+				if result == nil || !opts.Idempotent(method) {
+					return next.Call(ctx, method, args, result)
+				}
+				spend := budget.spend
+				if opts.RetryBudget != nil {
+					spend = opts.RetryBudget.withdraw
+				} else {
+					budget.earn(opts.Budget)
+				}
+				return hedgedCall(ctx, next, opts, spend, method, args, result)
+			},
+		}
+	}
+}
+
+// tHedgingBudget is the budget of hedges of a HedgingMiddleware.
+type tHedgingBudget struct {
+	mu     sync.Mutex
+	tokens float64
+}
+
+func (b *tHedgingBudget) earn(tokens float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += tokens
+	if b.tokens > hedgingBurst {
+		b.tokens = hedgingBurst
+	}
+}
+
+func (b *tHedgingBudget) spend() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// hedgedCall sends the attempts of a call, the hedges being allowed by spend.
+func hedgedCall(ctx context.Context, next TClient, opts HedgingOptions, spend func() bool, method string, args, result TStruct) (ResponseMeta, error) {
+	// The headers of the winning attempt are recorded once it won.
+	parent := ctx
+	ctx, cancel := context.WithCancel(withoutIncomingHeaders(withBalancerAttempts(ctx)))
+	defer cancel()
+
+	type attempt struct {
+		result TStruct
+		meta   ResponseMeta
+		err    error
+	}
+	// Buffered, so that the losing attempts don't block once the call
+	// returned.
+	attempts := make(chan attempt, 1+opts.MaxHedges)
+	pending, hedges := 1, 0
+	send := func() {
+		// Every attempt decodes its own result, copied to result if it
+		// wins.
+		r := reflect.New(reflect.TypeOf(result).Elem()).Interface().(TStruct)
+		attemptCtx := withCallAttempt(ctx, 1+hedges)
+		go func() {
+			meta, err := next.Call(attemptCtx, method, args, r)
+			attempts <- attempt{r, meta, err}
+		}()
+	}
+	send()
+	timer := time.NewTimer(opts.Delay)
+	defer timer.Stop()
+	for {
+		select {
+		case a := <-attempts:
+			pending--
+			if a.err == nil {
+				reflect.ValueOf(result).Elem().Set(reflect.ValueOf(a.result).Elem())
+				recordIncomingHeaders(parent, a.meta.Headers)
+				return a.meta, nil
+			}
+			if pending == 0 {
+				recordIncomingHeaders(parent, a.meta.Headers)
+				return a.meta, a.err
+			}
+		case <-timer.C:
+			if hedges < opts.MaxHedges && spend() {
+				hedges++
+				pending++
+				if opts.OnHedge != nil {
+					opts.OnHedge(ctx, method)
+				}
+				send()
+				timer.Reset(opts.Delay)
+			}
+		}
+	}
+}
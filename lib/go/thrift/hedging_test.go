@@ -0,0 +1,135 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hedgingResult is the result struct of the hedged calls.
+type hedgingResult struct {
+	Value string
+}
+
+func (*hedgingResult) Read(ctx context.Context, in TProtocol) error {
+	return nil
+}
+
+func (*hedgingResult) Write(ctx context.Context, out TProtocol) error {
+	return nil
+}
+
+func TestHedgingMiddleware(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	endpoint := func(address string, reply func(ctx context.Context) error) TEndpoint {
+		return TEndpoint{
+			Address: address,
+			Client: WrappedTClient{
+				Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+					if err := reply(ctx); err != nil {
+						return ResponseMeta{}, err
+					}
+					result.(*hedgingResult).Value = address
+					return ResponseMeta{}, nil
+				},
+			},
+		}
+	}
+	balanced := NewTBalancedClient([]TEndpoint{
+		endpoint("slow", func(ctx context.Context) error {
+			<-ctx.Done()
+			canceled <- struct{}{}
+			return ctx.Err()
+		}),
+		endpoint("fast", func(ctx context.Context) error {
+			return nil
+		}),
+	}, TBalancedClientOptions{
+		// Always picks the first endpoint, the hedges still go to the
+		// other one.
+		Policy: LoadBalancingPolicyFunc(func(ctx context.Context, method string, endpoints []EndpointStatus) int {
+			return 0
+		}),
+	})
+	var hedged int
+	client := WrapClient(balanced, HedgingMiddleware(HedgingOptions{
+		Idempotent: func(method string) bool {
+			return method == "get"
+		},
+		Delay:  10 * time.Millisecond,
+		Budget: 1,
+		OnHedge: func(ctx context.Context, method string) {
+			hedged++
+		},
+	}))
+
+	var result hedgingResult
+	if _, err := client.Call(context.Background(), "get", nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != "fast" || hedged != 1 {
+		t.Errorf("expected the hedge to win, got %q after %d hedges", result.Value, hedged)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("expected the losing attempt to be canceled")
+	}
+}
+
+func TestHedgingBudget(t *testing.T) {
+	var calls int32
+	client := WrapClient(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			atomic.AddInt32(&calls, 1)
+			select {
+			case <-time.After(20 * time.Millisecond):
+			case <-ctx.Done():
+			}
+			return ResponseMeta{}, nil
+		},
+	}, HedgingMiddleware(HedgingOptions{
+		Idempotent: func(method string) bool {
+			return method == "get"
+		},
+		Delay:  time.Millisecond,
+		Budget: 0.5,
+	}))
+	call := func(method string) int32 {
+		atomic.StoreInt32(&calls, 0)
+		if _, err := client.Call(context.Background(), method, nil, &hedgingResult{}); err != nil {
+			t.Fatal(err)
+		}
+		return atomic.LoadInt32(&calls)
+	}
+
+	if n := call("get"); n != 1 {
+		t.Errorf("expected no hedge before earning one, got %d attempts", n)
+	}
+	if n := call("get"); n != 2 {
+		t.Errorf("expected a hedge once earned, got %d attempts", n)
+	}
+	if n := call("put"); n != 1 {
+		t.Errorf("expected the non-idempotent calls not to be hedged, got %d attempts", n)
+	}
+}
@@ -121,6 +121,31 @@ func (p *THttpClient) DelHeader(key string) {
 	p.header.Del(key)
 }
 
+type outgoingHTTPHeadersKey struct{}
+
+// AppendOutgoingHTTPHeader returns a copy of ctx with an HTTP header sent by
+// THttpClient with the requests of the calls made with it, in addition to the
+// headers set with SetHeader and the ones already set in ctx. A header
+// already set in ctx with the same key is replaced.
+func AppendOutgoingHTTPHeader(ctx context.Context, key, value string) context.Context {
+	header := make(http.Header)
+	for k, v := range outgoingHTTPHeaders(ctx) {
+		header[k] = v
+	}
+	header.Set(key, value)
+	return context.WithValue(ctx, outgoingHTTPHeadersKey{}, header)
+}
+
+// outgoingHTTPHeaders returns the headers set in ctx with
+// AppendOutgoingHTTPHeader, nil if none.
+func outgoingHTTPHeaders(ctx context.Context) http.Header {
+	if ctx == nil {
+		return nil
+	}
+	header, _ := ctx.Value(outgoingHTTPHeadersKey{}).(http.Header)
+	return header
+}
+
 func (p *THttpClient) Open() error {
 	// do nothing
 	return nil
@@ -207,6 +232,12 @@ func (p *THttpClient) Flush(ctx context.Context) error {
 		return NewTTransportExceptionFromError(err)
 	}
 	req.Header = p.header
+	if header := outgoingHTTPHeaders(ctx); len(header) > 0 {
+		req.Header = p.header.Clone()
+		for k, v := range header {
+			req.Header[k] = v
+		}
+	}
 	if ctx != nil {
 		req = req.WithContext(ctx)
 	}
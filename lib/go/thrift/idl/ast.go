@@ -0,0 +1,585 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package idl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Pos is a position in an IDL file.
+type Pos struct {
+	Filename string
+	Line     int
+	Column   int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// Error is a syntax error, or an include which can't be parsed.
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// Annotations are the annotations of a definition, e.g.
+// (cpp.type = "DenseFoo", deprecated), by key. The annotations without a
+// value have the value "1", as with the compiler.
+type Annotations map[string]string
+
+// Document is a parsed IDL file.
+type Document struct {
+	Filename string
+
+	// Includes are the included files, in order.
+	Includes []*Include
+
+	// CppIncludes are the files of the cpp_include directives.
+	CppIncludes []string
+
+	// Namespaces are the namespaces by scope, e.g. "go" or "*".
+	Namespaces map[string]string
+
+	Typedefs  []*Typedef
+	Constants []*Constant
+	Enums     []*Enum
+	Structs   []*Struct
+	Services  []*Service
+}
+
+// Include is an include directive.
+type Include struct {
+	Pos  Pos
+	Path string
+
+	// Name is the prefix of the definitions of the included file, its
+	// base name without extension.
+	Name string
+
+	// Document is the included file, nil if the includes were not parsed,
+	// see Parse.
+	Document *Document
+}
+
+// Type is the type of a field, a constant, a typedef or a function result.
+type Type struct {
+	Pos Pos
+
+	// Name is the name of the base type (bool, byte, i8, i16, i32, i64,
+	// double, string, binary or uuid), of the container (map, set or list),
+	// or the name of a definition, prefixed with the name of its include if
+	// it's in an included file, e.g. "shared.User".
+	Name string
+
+	// KeyType is the type of the keys of a map.
+	KeyType *Type
+
+	// ValueType is the type of the values of a map, or of the elements of a
+	// list or a set.
+	ValueType *Type
+
+	Annotations Annotations
+}
+
+// IsBase reports whether t is a base type.
+func (t *Type) IsBase() bool {
+	_, ok := baseTypes[t.Name]
+	return ok
+}
+
+// IsContainer reports whether t is a map, a set or a list.
+func (t *Type) IsContainer() bool {
+	return t.ValueType != nil
+}
+
+func (t *Type) String() string {
+	switch {
+	case t.KeyType != nil:
+		return fmt.Sprintf("map<%s,%s>", t.KeyType, t.ValueType)
+	case t.ValueType != nil:
+		return fmt.Sprintf("%s<%s>", t.Name, t.ValueType)
+	default:
+		return t.Name
+	}
+}
+
+var baseTypes = map[string]thrift.TType{
+	"bool":   thrift.BOOL,
+	"byte":   thrift.BYTE,
+	"i8":     thrift.BYTE,
+	"i16":    thrift.I16,
+	"i32":    thrift.I32,
+	"i64":    thrift.I64,
+	"double": thrift.DOUBLE,
+	"string": thrift.STRING,
+	"binary": thrift.STRING,
+	// The UUIDs are 16 bytes binaries with the protocols of this version.
+	"uuid": thrift.STRING,
+}
+
+// Requiredness is the requiredness of a field.
+type Requiredness int
+
+const (
+	// Default is the requiredness of the fields that are neither required
+	// nor optional.
+	Default Requiredness = iota
+	Required
+	Optional
+)
+
+func (r Requiredness) String() string {
+	switch r {
+	case Required:
+		return "required"
+	case Optional:
+		return "optional"
+	default:
+		return "default"
+	}
+}
+
+// Field is a field of a struct, or an argument or an exception of a
+// function.
+type Field struct {
+	Pos Pos
+	Doc string
+
+	// ID is the id of the field. The fields without an explicit id get
+	// negative ids, -1 for the first one, then -2, etc., as with the
+	// compiler.
+	ID           int16
+	Name         string
+	Type         *Type
+	Requiredness Requiredness
+
+	// Default is the default value of the field, nil if none.
+	Default *ConstValue
+
+	// Reference is set for the fields declared as references with "&".
+	Reference bool
+
+	Annotations Annotations
+}
+
+// ConstKind is the kind of a ConstValue.
+type ConstKind int
+
+const (
+	ConstInt ConstKind = iota
+	ConstDouble
+	ConstString
+	// ConstIdentifier is a reference to a constant or an enum value, e.g.
+	// "Color.RED".
+	ConstIdentifier
+	ConstList
+	ConstMap
+)
+
+// ConstValue is the value of a constant, or the default value of a field.
+type ConstValue struct {
+	Pos  Pos
+	Kind ConstKind
+
+	Int        int64
+	Double     float64
+	String     string
+	Identifier string
+	List       []*ConstValue
+	Map        []ConstMapEntry
+}
+
+// ConstMapEntry is an entry of a ConstMap ConstValue, also used for the
+// values of the fields of the struct constants.
+type ConstMapEntry struct {
+	Key   *ConstValue
+	Value *ConstValue
+}
+
+// Typedef is a typedef definition.
+type Typedef struct {
+	Pos         Pos
+	Doc         string
+	Name        string
+	Type        *Type
+	Annotations Annotations
+}
+
+// Constant is a const definition.
+type Constant struct {
+	Pos   Pos
+	Doc   string
+	Name  string
+	Type  *Type
+	Value *ConstValue
+}
+
+// Enum is an enum definition.
+type Enum struct {
+	Pos         Pos
+	Doc         string
+	Name        string
+	Values      []*EnumValue
+	Annotations Annotations
+}
+
+// EnumValue is a value of an enum. The values without an explicit value get
+// the value of the previous one plus one, starting at 0.
+type EnumValue struct {
+	Pos         Pos
+	Doc         string
+	Name        string
+	Value       int64
+	Annotations Annotations
+}
+
+// StructKind is the kind of a Struct.
+type StructKind int
+
+const (
+	StructKindStruct StructKind = iota
+	StructKindUnion
+	StructKindException
+)
+
+func (k StructKind) String() string {
+	switch k {
+	case StructKindUnion:
+		return "union"
+	case StructKindException:
+		return "exception"
+	default:
+		return "struct"
+	}
+}
+
+// Struct is a struct, union or exception definition.
+type Struct struct {
+	Pos         Pos
+	Doc         string
+	Kind        StructKind
+	Name        string
+	Fields      []*Field
+	Annotations Annotations
+}
+
+// Field returns the field id of s, nil if there's none.
+func (s *Struct) Field(id int16) *Field {
+	for _, f := range s.Fields {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// Service is a service definition.
+type Service struct {
+	Pos Pos
+	Doc string
+
+	Name string
+
+	// Extends is the name of the service extended, "" if none, prefixed
+	// with the name of its include if it's in an included file.
+	Extends     string
+	Functions   []*Function
+	Annotations Annotations
+}
+
+// Function is a function of a service.
+type Function struct {
+	Pos    Pos
+	Doc    string
+	Name   string
+	Oneway bool
+
+	// ReturnType is the type of the result, nil for void.
+	ReturnType  *Type
+	Arguments   []*Field
+	Exceptions  []*Field
+	Annotations Annotations
+}
+
+// Typedef returns the typedef name of d, nil if there's none, see Struct.
+func (d *Document) Typedef(name string) *Typedef {
+	d, name = d.scope(name)
+	if d == nil {
+		return nil
+	}
+	for _, t := range d.Typedefs {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Constant returns the constant name of d, nil if there's none, see Struct.
+func (d *Document) Constant(name string) *Constant {
+	d, name = d.scope(name)
+	if d == nil {
+		return nil
+	}
+	for _, c := range d.Constants {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Enum returns the enum name of d, nil if there's none, see Struct.
+func (d *Document) Enum(name string) *Enum {
+	d, name = d.scope(name)
+	if d == nil {
+		return nil
+	}
+	for _, e := range d.Enums {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// Struct returns the struct, union or exception name of d, nil if there's
+// none. The names of the definitions of the included files are prefixed
+// with the name of their include, e.g. "shared.User".
+func (d *Document) Struct(name string) *Struct {
+	d, name = d.scope(name)
+	if d == nil {
+		return nil
+	}
+	for _, s := range d.Structs {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Service returns the service name of d, nil if there's none, see Struct.
+func (d *Document) Service(name string) *Service {
+	d, name = d.scope(name)
+	if d == nil {
+		return nil
+	}
+	for _, s := range d.Services {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// scope returns the document defining name, and the name in it.
+func (d *Document) scope(name string) (*Document, string) {
+	i := strings.IndexByte(name, '.')
+	if i < 0 {
+		return d, name
+	}
+	for _, inc := range d.Includes {
+		if inc.Name == name[:i] {
+			return inc.Document, name[i+1:]
+		}
+	}
+	return d, name
+}
+
+// Functions returns the functions of the service name of d, including the
+// ones of the services it extends, nil if there's no such service.
+func (d *Document) Functions(name string) []*Function {
+	var functions []*Function
+	for _, f := range d.scopedFunctions(name) {
+		functions = append(functions, f.Function)
+	}
+	return functions
+}
+
+// Function returns the function name of the service of d, or of the
+// services it extends, with the document its types are relative to. It
+// returns nil if there's no such function.
+func (d *Document) Function(service, name string) (*Function, *Document) {
+	for _, f := range d.scopedFunctions(service) {
+		if f.Name == name {
+			return f.Function, f.doc
+		}
+	}
+	return nil, nil
+}
+
+// MessageStruct returns the struct of the body of the messages of the
+// function name of type typeID, with the document its types are relative to:
+// the arguments of the function for the calls, its result for the replies,
+// and TApplicationException for the exceptions, even if d is nil.
+//
+// The function is the one of service, or of the first service of d defining
+// it if service is empty, and name may be prefixed by its service like the
+// names of TMultiplexedProtocol, e.g. "UserService:getUser". It returns nil
+// if the function is unknown.
+func (d *Document) MessageStruct(service, name string, typeID thrift.TMessageType) (*Struct, *Document) {
+	if typeID == thrift.EXCEPTION {
+		return applicationException, nil
+	}
+	if d == nil {
+		return nil, nil
+	}
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		service, name = name[:i], name[i+1:]
+	}
+	var services []string
+	if service != "" {
+		services = []string{service}
+	} else {
+		for _, s := range d.Services {
+			services = append(services, s.Name)
+		}
+	}
+	for _, service := range services {
+		f, doc := d.Function(service, name)
+		if f == nil {
+			continue
+		}
+		switch typeID {
+		case thrift.CALL, thrift.ONEWAY:
+			return &Struct{Name: name + "_args", Fields: f.Arguments}, doc
+		case thrift.REPLY:
+			s := &Struct{Name: name + "_result", Fields: f.Exceptions}
+			if f.ReturnType != nil {
+				s.Fields = append([]*Field{{ID: 0, Name: "success", Type: f.ReturnType}}, s.Fields...)
+			}
+			return s, doc
+		}
+	}
+	return nil, nil
+}
+
+// applicationException is the struct of the TApplicationExceptions.
+var applicationException = &Struct{
+	Name: "TApplicationException",
+	Fields: []*Field{
+		{ID: 1, Name: "message", Type: &Type{Name: "string"}},
+		{ID: 2, Name: "type", Type: &Type{Name: "i32"}},
+	},
+}
+
+// scopedFunction is a function with the document its types are relative to.
+type scopedFunction struct {
+	*Function
+	doc *Document
+}
+
+func (d *Document) scopedFunctions(name string) []scopedFunction {
+	var functions []scopedFunction
+	seen := make(map[*Service]bool)
+	for name != "" {
+		scope, local := d.scope(name)
+		if scope == nil {
+			break
+		}
+		s := scope.Service(local)
+		if s == nil || seen[s] {
+			break
+		}
+		seen[s] = true
+		for _, f := range s.Functions {
+			functions = append(functions, scopedFunction{f, scope})
+		}
+		// The service extended is relative to the file of s.
+		d, name = scope, s.Extends
+	}
+	return functions
+}
+
+// TType returns the thrift.TType of the values of t, t being a type of d,
+// following the typedefs. The enums are I32, and the structs, unions and
+// exceptions are STRUCT.
+func (d *Document) TType(t *Type) (thrift.TType, error) {
+	r, err := d.Resolve(t)
+	return r.TType, err
+}
+
+// ResolvedType is a type with its typedefs followed, see Document.Resolve.
+type ResolvedType struct {
+	// Document is the document Type is relative to, the one defining it for
+	// the enums and structs.
+	Document *Document
+	Type     *Type
+	TType    thrift.TType
+
+	// Enum and Struct are the definitions of the enum and struct types.
+	Enum   *Enum
+	Struct *Struct
+}
+
+// Resolve follows the typedefs of t, a type of d, up to a base type, a
+// container, an enum or a struct.
+func (d *Document) Resolve(t *Type) (ResolvedType, error) {
+	for i := 0; i < maxTypedefDepth; i++ {
+		r := ResolvedType{Document: d, Type: t}
+		if ttype, ok := baseTypes[t.Name]; ok && !t.IsContainer() {
+			r.TType = ttype
+			return r, nil
+		}
+		switch {
+		case t.KeyType != nil:
+			r.TType = thrift.MAP
+			return r, nil
+		case t.Name == "set" && t.ValueType != nil:
+			r.TType = thrift.SET
+			return r, nil
+		case t.Name == "list" && t.ValueType != nil:
+			r.TType = thrift.LIST
+			return r, nil
+		}
+		scope, name := d.scope(t.Name)
+		if scope == nil {
+			break
+		}
+		if r.Enum = scope.Enum(name); r.Enum != nil {
+			r.Document, r.TType = scope, thrift.I32
+			return r, nil
+		}
+		if r.Struct = scope.Struct(name); r.Struct != nil {
+			r.Document, r.TType = scope, thrift.STRUCT
+			return r, nil
+		}
+		typedef := scope.Typedef(name)
+		if typedef == nil {
+			break
+		}
+		// The type of the typedef is relative to its file.
+		d, t = scope, typedef.Type
+	}
+	return ResolvedType{}, fmt.Errorf("%s: unknown type %s", t.Pos, t.Name)
+}
+
+// maxTypedefDepth bounds the typedefs followed by Resolve, which may be
+// cyclic.
+const maxTypedefDepth = 64
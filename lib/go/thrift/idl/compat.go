@@ -0,0 +1,341 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package idl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Change is a difference between two versions of an IDL, see Compare.
+type Change struct {
+	// Pos is the position of the changed definition in the new version, or
+	// in the old one if it was removed.
+	Pos Pos
+
+	// Breaking reports whether the change breaks the compatibility between
+	// the peers using the old version and the ones using the new one, e.g. a
+	// removed required field or a field whose type changed.
+	Breaking bool
+
+	// Message describes the change, e.g.
+	// "struct User: required field 3 (email) removed".
+	Message string
+}
+
+func (c Change) String() string {
+	return c.Pos.String() + ": " + c.Message
+}
+
+// CompatibilityError is returned by CheckCompatibility for the breaking
+// changes.
+type CompatibilityError struct {
+	Changes []Change
+}
+
+func (e *CompatibilityError) Error() string {
+	messages := make([]string, len(e.Changes))
+	for i, c := range e.Changes {
+		messages[i] = c.String()
+	}
+	return "incompatible IDL changes: " + strings.Join(messages, "; ")
+}
+
+// CheckCompatibility returns a *CompatibilityError with the breaking changes
+// between the old and the new versions of an IDL, nil if there's none, for
+// example to fail a CI job, or the startup of a server whose IDL is
+// incompatible with the one of its peers:
+//
+//	old, err := idl.Parse("user.thrift", previous)
+//	...
+//	if err := idl.CheckCompatibility(old, doc); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// See Compare for the changes reported.
+func CheckCompatibility(old, new *Document) error {
+	changes, err := Compare(old, new)
+	if err != nil {
+		return err
+	}
+	var breaking []Change
+	for _, c := range changes {
+		if c.Breaking {
+			breaking = append(breaking, c)
+		}
+	}
+	if len(breaking) > 0 {
+		return &CompatibilityError{breaking}
+	}
+	return nil
+}
+
+// Compare returns the changes between the old and the new versions of an
+// IDL, the definitions being matched by name, the fields by id, and the
+// included documents by include name.
+//
+// The changes breaking the compatibility on the wire are:
+//
+//   - the removed required fields, and the added ones,
+//   - the fields becoming required, or no longer required,
+//   - the fields whose type changed, including the ids reused by new fields,
+//     the typedefs being followed and the enums being i32,
+//   - the structs becoming unions or exceptions, and conversely,
+//   - the enum values whose number changed,
+//   - the removed services and functions, including the inherited ones, and
+//     the functions whose result type or oneway flag changed.
+//
+// The other changes, like the removed optional fields or the renamed ones,
+// are reported as not breaking. The added definitions aren't reported.
+func Compare(old, new *Document) ([]Change, error) {
+	c := &comparison{seen: make(map[*Document]bool)}
+	if err := c.documents("", old, new); err != nil {
+		return nil, err
+	}
+	return c.changes, nil
+}
+
+type comparison struct {
+	changes []Change
+	seen    map[*Document]bool
+}
+
+func (c *comparison) add(pos Pos, breaking bool, format string, args ...interface{}) {
+	c.changes = append(c.changes, Change{
+		Pos:      pos,
+		Breaking: breaking,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// documents compares the definitions of the old and new documents, prefix
+// being their include name followed by a dot, if they're included.
+func (c *comparison) documents(prefix string, old, new *Document) error {
+	if c.seen[old] {
+		return nil
+	}
+	c.seen[old] = true
+
+	for _, os := range old.Structs {
+		ns := new.Struct(os.Name)
+		if ns == nil {
+			c.add(os.Pos, false, "%s %s%s removed", os.Kind, prefix, os.Name)
+			continue
+		}
+		what := fmt.Sprintf("%s %s%s", ns.Kind, prefix, ns.Name)
+		if os.Kind != ns.Kind {
+			c.add(ns.Pos, true, "%s changed from a %s", what, os.Kind)
+			continue
+		}
+		if err := c.fields(what, "field", old, new, os.Fields, ns.Fields); err != nil {
+			return err
+		}
+	}
+
+	for _, oe := range old.Enums {
+		ne := new.Enum(oe.Name)
+		if ne == nil {
+			c.add(oe.Pos, false, "enum %s%s removed", prefix, oe.Name)
+			continue
+		}
+		c.enumValues(fmt.Sprintf("enum %s%s", prefix, ne.Name), oe, ne)
+	}
+
+	for _, os := range old.Services {
+		ns := new.Service(os.Name)
+		if ns == nil {
+			c.add(os.Pos, true, "service %s%s removed", prefix, os.Name)
+			continue
+		}
+		if err := c.functions(fmt.Sprintf("service %s%s", prefix, ns.Name), old, new, os, ns); err != nil {
+			return err
+		}
+	}
+
+	for _, oi := range old.Includes {
+		for _, ni := range new.Includes {
+			if oi.Name == ni.Name && oi.Document != nil && ni.Document != nil {
+				if err := c.documents(prefix+ni.Name+".", oi.Document, ni.Document); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fields compares the fields of a struct, or the arguments or exceptions of
+// a function, of the types relative to the old and new documents.
+func (c *comparison) fields(what, kind string, oldDoc, newDoc *Document, old, new []*Field) error {
+	for _, of := range old {
+		nf := fieldByID(new, of.ID)
+		if nf == nil {
+			if of.Requiredness == Required {
+				c.add(of.Pos, true, "%s: required %s %d (%s) removed", what, kind, of.ID, of.Name)
+			} else {
+				c.add(of.Pos, false, "%s: %s %d (%s) removed, its id must not be reused", what, kind, of.ID, of.Name)
+			}
+			continue
+		}
+		ot, err := wireType(oldDoc, of.Type)
+		if err != nil {
+			return err
+		}
+		nt, err := wireType(newDoc, nf.Type)
+		if err != nil {
+			return err
+		}
+		if ot != nt {
+			if of.Name != nf.Name {
+				c.add(nf.Pos, true, "%s: %s id %d reused, %s %s replaced by %s %s", what, kind, nf.ID, ot, of.Name, nt, nf.Name)
+			} else {
+				c.add(nf.Pos, true, "%s: %s %d (%s) changed type from %s to %s", what, kind, nf.ID, nf.Name, ot, nt)
+			}
+			continue
+		}
+		if of.Name != nf.Name {
+			c.add(nf.Pos, false, "%s: %s %d renamed from %s to %s", what, kind, nf.ID, of.Name, nf.Name)
+		}
+		if of.Requiredness != nf.Requiredness {
+			breaking := of.Requiredness == Required || nf.Requiredness == Required
+			c.add(nf.Pos, breaking, "%s: %s %d (%s) changed from %s to %s", what, kind, nf.ID, nf.Name, of.Requiredness, nf.Requiredness)
+		}
+	}
+	for _, nf := range new {
+		if fieldByID(old, nf.ID) != nil {
+			continue
+		}
+		if nf.Requiredness == Required {
+			c.add(nf.Pos, true, "%s: required %s %d (%s) added", what, kind, nf.ID, nf.Name)
+		} else {
+			c.add(nf.Pos, false, "%s: %s %d (%s) added", what, kind, nf.ID, nf.Name)
+		}
+	}
+	return nil
+}
+
+func (c *comparison) enumValues(what string, old, new *Enum) {
+	for _, ov := range old.Values {
+		var nv *EnumValue
+		for _, v := range new.Values {
+			if v.Name == ov.Name {
+				nv = v
+				break
+			}
+		}
+		switch {
+		case nv == nil:
+			c.add(ov.Pos, false, "%s: value %s (%d) removed", what, ov.Name, ov.Value)
+		case nv.Value != ov.Value:
+			c.add(nv.Pos, true, "%s: value %s changed from %d to %d", what, nv.Name, ov.Value, nv.Value)
+		}
+	}
+}
+
+// functions compares the functions of the services, including the inherited
+// ones.
+func (c *comparison) functions(what string, oldDoc, newDoc *Document, old, new *Service) error {
+	for _, of := range oldDoc.scopedFunctions(old.Name) {
+		nf, nDoc := newDoc.Function(new.Name, of.Name)
+		if nf == nil {
+			c.add(of.Pos, true, "%s: function %s removed", what, of.Name)
+			continue
+		}
+		fwhat := fmt.Sprintf("%s: function %s", what, nf.Name)
+		if of.Oneway != nf.Oneway {
+			c.add(nf.Pos, true, "%s changed oneway from %v to %v", fwhat, of.Oneway, nf.Oneway)
+			continue
+		}
+		ot, err := returnWireType(of.doc, of.ReturnType)
+		if err != nil {
+			return err
+		}
+		nt, err := returnWireType(nDoc, nf.ReturnType)
+		if err != nil {
+			return err
+		}
+		if ot != nt {
+			c.add(nf.Pos, true, "%s changed result type from %s to %s", fwhat, ot, nt)
+		}
+		if err := c.fields(fwhat, "argument", of.doc, nDoc, of.Arguments, nf.Arguments); err != nil {
+			return err
+		}
+		if err := c.fields(fwhat, "exception", of.doc, nDoc, of.Exceptions, nf.Exceptions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fieldByID(fields []*Field, id int16) *Field {
+	for _, f := range fields {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+func returnWireType(d *Document, t *Type) (string, error) {
+	if t == nil {
+		return "void", nil
+	}
+	return wireType(d, t)
+}
+
+// wireType describes the type t of d as encoded: with its typedefs followed,
+// the enums as i32, and the structs by name.
+func wireType(d *Document, t *Type) (string, error) {
+	r, err := d.Resolve(t)
+	if err != nil {
+		return "", err
+	}
+	switch r.TType {
+	case thrift.MAP:
+		key, err := wireType(r.Document, r.Type.KeyType)
+		if err != nil {
+			return "", err
+		}
+		value, err := wireType(r.Document, r.Type.ValueType)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("map<%s,%s>", key, value), nil
+	case thrift.SET, thrift.LIST:
+		elem, err := wireType(r.Document, r.Type.ValueType)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s<%s>", r.Type.Name, elem), nil
+	case thrift.STRUCT:
+		return r.Struct.Name, nil
+	case thrift.I32:
+		return "i32", nil
+	case thrift.STRING:
+		// The strings and the binaries are the same on the wire.
+		return "string", nil
+	case thrift.BYTE:
+		return "byte", nil
+	default:
+		return r.Type.Name, nil
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package idl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testCompatOld = `
+typedef i64 UserID
+
+enum Role {
+  READER = 1,
+  WRITER = 2,
+  GUEST = 3
+}
+
+struct User {
+  1: required UserID id
+  2: string name
+  3: optional string email
+  4: required string password
+  5: Role role
+  6: optional list<string> tags
+  7: i32 age
+}
+
+struct Account {
+  1: required UserID id
+  2: string name
+  3: optional string email
+  4: required string password
+  5: Role role
+  6: optional list<string> tags
+  7: i32 age
+  10: double score
+}
+
+struct Removed {}
+
+service Base {
+  void ping()
+  void reset()
+}
+
+service Users extends Base {
+  User get(1: UserID id)
+  oneway void touch(1: UserID id)
+  void delete(1: UserID id)
+}
+
+service Legacy {}
+`
+
+const testCompatNew = `
+typedef i64 UserID
+typedef string Email
+
+enum Role {
+  READER = 1,
+  WRITER = 4
+}
+
+union User {
+  1: i64 id
+}
+
+struct Account {
+  1: required i64 id
+  2: string fullName
+  3: optional Email email
+  5: i32 role
+  6: required list<binary> tags
+  7: i64 birth
+  8: required string token
+  9: optional string nickname
+  10: i32 score
+}
+
+service Base {
+  void ping()
+}
+
+service Users extends Base {
+  Account get(1: i64 id, 2: bool verbose) throws (1: Error error)
+  void touch(1: UserID id)
+}
+
+exception Error {}
+`
+
+func TestCompare(t *testing.T) {
+	old, err := Parse("old.thrift", []byte(testCompatOld))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := Parse("new.thrift", []byte(testCompatNew))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Compare(old, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{
+		"union User changed from a struct":                                     true,
+		"struct Removed removed":                                               false,
+		"struct Account: field 2 renamed from name to fullName":                false,
+		"struct Account: required field 4 (password) removed":                  true,
+		"struct Account: field 6 (tags) changed from optional to required":     true,
+		"struct Account: field id 7 reused, i32 age replaced by i64 birth":     true,
+		"struct Account: field 10 (score) changed type from double to i32":     true,
+		"struct Account: required field 8 (token) added":                       true,
+		"struct Account: field 9 (nickname) added":                             false,
+		"enum Role: value WRITER changed from 2 to 4":                          true,
+		"enum Role: value GUEST (3) removed":                                   false,
+		"service Base: function reset removed":                                 true,
+		"service Users: function get changed result type from User to Account": true,
+		"service Users: function get: argument 2 (verbose) added":              false,
+		"service Users: function get: exception 1 (error) added":               false,
+		"service Users: function touch changed oneway from true to false":      true,
+		"service Users: function delete removed":                               true,
+		"service Users: function reset removed":                                true,
+		"service Legacy removed":                                               true,
+	}
+	got := make(map[string]bool)
+	for _, c := range changes {
+		got[c.Message] = c.Breaking
+		if c.Pos.Filename == "" {
+			t.Errorf("missing position of %s", c.Message)
+		}
+	}
+	for message, breaking := range expected {
+		if b, ok := got[message]; !ok {
+			t.Errorf("missing change %q", message)
+		} else if b != breaking {
+			t.Errorf("expected %q to be breaking=%v", message, breaking)
+		}
+	}
+	for message := range got {
+		if _, ok := expected[message]; !ok {
+			t.Errorf("unexpected change %q", message)
+		}
+	}
+
+	err = CheckCompatibility(old, doc)
+	var ce *CompatibilityError
+	if !errors.As(err, &ce) || len(ce.Changes) != 13 || !strings.Contains(err.Error(), "new.thrift:") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := CheckCompatibility(old, old); err != nil {
+		t.Errorf("expected no change, got %v", err)
+	}
+}
+
+func TestCompareIncludes(t *testing.T) {
+	doc := parseTestFiles(t)
+	changes, err := Compare(doc, doc)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("unexpected changes %v, error %v", changes, err)
+	}
+
+	// The included documents are compared too.
+	old := parseTestFiles(t)
+	user := old.Includes[0].Document.Struct("User")
+	user.Fields = append(user.Fields, &Field{ID: 99, Name: "legacy", Type: &Type{Name: "string"}, Requiredness: Required})
+	changes, err = Compare(old, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Message != "struct shared.User: required field 99 (legacy) removed" || !changes[0].Breaking {
+		t.Errorf("unexpected changes %v", changes)
+	}
+}
@@ -0,0 +1,45 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package idl parses thrift IDL files at runtime, for the programs working
+// with services unknown when they're built, like dynamic clients, gateways or
+// validators, without running the compiler:
+//
+//	doc, err := idl.ParseFile("user.thrift")
+//	if err != nil {
+//		return err
+//	}
+//	for _, f := range doc.Service("UserService").Functions {
+//		fmt.Println(f.Name, f.Arguments, f.ReturnType)
+//	}
+//
+// ParseFile parses the included files too, which are then resolved by the
+// lookups of the Document, e.g. Struct("shared.User"), and TType maps the
+// types of the IDL to the types of the thrift protocols, to encode and decode
+// the values of the IDL with a thrift.Value. Client calls the functions of
+// the services with such Values, or with plain Go values converted by
+// Document.ValueOf and Document.Interface, and Processor serves them with
+// functions handling such Values. Compare and CheckCompatibility report the
+// changes between two versions of an IDL which break the compatibility of
+// their peers.
+//
+// The parser accepts the grammar of the compiler, including the annotations
+// and the doc comments, but doesn't check the semantics of the definitions,
+// e.g. that the types used are defined, beyond what the lookups need.
+package idl
@@ -0,0 +1,490 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package idl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Client calls the functions of a service described by a Document, with
+// their arguments and results as thrift.Values or as plain Go values, for
+// the generic tools like a command line client or scripts:
+//
+//	doc, err := idl.ParseFile("user.thrift")
+//	...
+//	users, err := idl.NewClient(doc, "UserService", thrift.NewTStandardClient(proto, proto))
+//	...
+//	user, err := users.CallMap(ctx, "getUser", map[string]interface{}{"id": 42})
+type Client struct {
+	client    thrift.TClient
+	service   string
+	functions map[string]scopedFunction
+}
+
+// NewClient returns a Client calling the functions of the service of doc, or
+// of the services it extends, with client.
+func NewClient(doc *Document, service string, client thrift.TClient) (*Client, error) {
+	functions := doc.scopedFunctions(service)
+	if len(functions) == 0 && doc.Service(service) == nil {
+		return nil, fmt.Errorf("unknown service %s", service)
+	}
+	c := &Client{
+		client:    client,
+		service:   service,
+		functions: make(map[string]scopedFunction, len(functions)),
+	}
+	for _, f := range functions {
+		// The functions of the service override the ones it extends.
+		if _, ok := c.functions[f.Name]; !ok {
+			c.functions[f.Name] = f
+		}
+	}
+	return c, nil
+}
+
+// Exception is a declared exception of a function, returned by the calls of
+// Client failing with it, and by the HandlerFuncs of Processor to fail with
+// it.
+type Exception struct {
+	// Field is the name of the exception in the throws clause of the
+	// function, and Type its type.
+	Field string
+	Type  string
+	Value thrift.Value
+
+	doc *Document
+	typ *Type
+}
+
+func (e *Exception) Error() string {
+	return fmt.Sprintf("%s: %v", e.Type, e.Value)
+}
+
+// Interface returns the fields of the exception by name, see
+// Document.Interface.
+func (e *Exception) Interface() (interface{}, error) {
+	return e.doc.Interface(e.typ, e.Value)
+}
+
+// Call calls method with args, a STRUCT Value of the arguments by id, and
+// returns its result, a zero Value for the void and oneway functions.
+//
+// The calls failing with an exception declared by the function return an
+// *Exception, and the other errors are the ones of the TClient.
+func (c *Client) Call(ctx context.Context, method string, args thrift.Value) (thrift.Value, error) {
+	f, ok := c.functions[method]
+	if !ok {
+		return thrift.Value{}, fmt.Errorf("unknown function %s of service %s", method, c.service)
+	}
+	if args.Type != thrift.STRUCT {
+		return thrift.Value{}, fmt.Errorf("arguments of %s must be a struct, got %v", method, args.Type)
+	}
+	if f.Oneway {
+		_, err := c.client.Call(ctx, method, &args, nil)
+		return thrift.Value{}, err
+	}
+	var result thrift.Value
+	if _, err := c.client.Call(ctx, method, &args, &result); err != nil {
+		return thrift.Value{}, err
+	}
+	for _, e := range f.Exceptions {
+		if v, ok := result.Field(e.ID); ok {
+			return thrift.Value{}, &Exception{
+				Field: e.Name,
+				Type:  e.Type.Name,
+				Value: v,
+				doc:   f.doc,
+				typ:   e.Type,
+			}
+		}
+	}
+	if v, ok := result.Field(0); ok || f.ReturnType == nil {
+		return v, nil
+	}
+	return thrift.Value{}, thrift.NewTApplicationException(thrift.MISSING_RESULT, method+" failed: unknown result")
+}
+
+// CallMap calls method with the arguments of args by name, converted with
+// Document.ValueOf, and returns its result converted with
+// Document.Interface, nil for the void and oneway functions.
+func (c *Client) CallMap(ctx context.Context, method string, args map[string]interface{}) (interface{}, error) {
+	f, ok := c.functions[method]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s of service %s", method, c.service)
+	}
+	values, err := fieldValues(f.doc, method, f.Arguments, args)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.Call(ctx, method, values)
+	if err != nil || f.ReturnType == nil || f.Oneway {
+		return nil, err
+	}
+	return f.doc.Interface(f.ReturnType, result)
+}
+
+// fieldValues returns the STRUCT Value of the fields of values by name.
+func fieldValues(doc *Document, name string, fields []*Field, values map[string]interface{}) (thrift.Value, error) {
+	s := thrift.StructValue()
+	for key := range values {
+		if fieldByName(fields, key) == nil {
+			return thrift.Value{}, fmt.Errorf("unknown field %s of %s", key, name)
+		}
+	}
+	for _, f := range fields {
+		v, ok := values[f.Name]
+		if !ok || v == nil {
+			if f.Requiredness == Required {
+				return thrift.Value{}, fmt.Errorf("required field %s of %s is not set", f.Name, name)
+			}
+			continue
+		}
+		value, err := doc.ValueOf(f.Type, v)
+		if err != nil {
+			return thrift.Value{}, fmt.Errorf("field %s of %s: %w", f.Name, name, err)
+		}
+		s.SetField(f.ID, f.Name, value)
+	}
+	return s, nil
+}
+
+func fieldByName(fields []*Field, name string) *Field {
+	for _, f := range fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// ValueOf converts v to a thrift.Value of the type t of d:
+//
+//   - the bools from bool or a string like "true",
+//   - the integers from any Go integer, an integral float64 or json.Number
+//     (as decoded by encoding/json), or a decimal string,
+//   - the doubles from any Go number, a json.Number or a string,
+//   - the strings from string, and the binaries from []byte or string,
+//   - the enums from the name of a value, or an integer,
+//   - the structs from a map[string]interface{} of the fields by name,
+//   - the lists and sets from a slice, and the maps from a map, whose keys
+//     may be strings for the non-string types, as with JSON objects.
+//
+// A v which is already a thrift.Value is returned as is.
+func (d *Document) ValueOf(t *Type, v interface{}) (thrift.Value, error) {
+	if value, ok := v.(thrift.Value); ok {
+		return value, nil
+	}
+	r, err := d.Resolve(t)
+	if err != nil {
+		return thrift.Value{}, err
+	}
+	switch r.TType {
+	case thrift.BOOL:
+		switch b := v.(type) {
+		case bool:
+			return thrift.BoolValue(b), nil
+		case string:
+			if parsed, err := strconv.ParseBool(b); err == nil {
+				return thrift.BoolValue(parsed), nil
+			}
+		}
+	case thrift.BYTE, thrift.I16, thrift.I32, thrift.I64:
+		var i int64
+		var ok bool
+		if s, isString := v.(string); isString && r.Enum != nil {
+			for _, ev := range r.Enum.Values {
+				if ev.Name == s {
+					i, ok = ev.Value, true
+				}
+			}
+		}
+		if !ok {
+			i, ok = toInt(v)
+		}
+		if ok && fitsInt(i, r.TType) {
+			return thrift.Value{Type: r.TType, Int: i}, nil
+		}
+	case thrift.DOUBLE:
+		if f, ok := toFloat(v); ok {
+			return thrift.DoubleValue(f), nil
+		}
+	case thrift.STRING:
+		switch s := v.(type) {
+		case string:
+			return thrift.StringValue(s), nil
+		case []byte:
+			return thrift.BinaryValue(s), nil
+		}
+	case thrift.STRUCT:
+		if fields, ok := toStringMap(v); ok {
+			return fieldValues(r.Document, r.Struct.Name, r.Struct.Fields, fields)
+		}
+	case thrift.LIST, thrift.SET:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			break
+		}
+		elemType, err := r.Document.TType(r.Type.ValueType)
+		if err != nil {
+			return thrift.Value{}, err
+		}
+		value := thrift.Value{Type: r.TType, ElemType: elemType}
+		for i := 0; i < rv.Len(); i++ {
+			elem, err := r.Document.ValueOf(r.Type.ValueType, rv.Index(i).Interface())
+			if err != nil {
+				return thrift.Value{}, fmt.Errorf("element %d: %w", i, err)
+			}
+			value.Elems = append(value.Elems, elem)
+		}
+		return value, nil
+	case thrift.MAP:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Map {
+			break
+		}
+		keyType, err := r.Document.TType(r.Type.KeyType)
+		if err != nil {
+			return thrift.Value{}, err
+		}
+		valueType, err := r.Document.TType(r.Type.ValueType)
+		if err != nil {
+			return thrift.Value{}, err
+		}
+		value := thrift.MapValue(keyType, valueType)
+		iter := rv.MapRange()
+		for iter.Next() {
+			var e thrift.ValueMapEntry
+			if e.Key, err = r.Document.ValueOf(r.Type.KeyType, iter.Key().Interface()); err != nil {
+				return thrift.Value{}, fmt.Errorf("key %v: %w", iter.Key(), err)
+			}
+			if e.Value, err = r.Document.ValueOf(r.Type.ValueType, iter.Value().Interface()); err != nil {
+				return thrift.Value{}, fmt.Errorf("value of %v: %w", iter.Key(), err)
+			}
+			value.Entries = append(value.Entries, e)
+		}
+		return value, nil
+	}
+	return thrift.Value{}, fmt.Errorf("cannot convert %T %v to %s", v, v, t)
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		return i, err == nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	if i, ok := toInt(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+// fitsInt reports whether i is in the range of the integer type ttype.
+func fitsInt(i int64, ttype thrift.TType) bool {
+	switch ttype {
+	case thrift.BYTE:
+		return i >= math.MinInt8 && i <= math.MaxInt8
+	case thrift.I16:
+		return i >= math.MinInt16 && i <= math.MaxInt16
+	case thrift.I32:
+		return i >= math.MinInt32 && i <= math.MaxInt32
+	default:
+		return true
+	}
+}
+
+// toStringMap returns v, a map with string keys, as a map[string]interface{}.
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	m := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		m[iter.Key().String()] = iter.Value().Interface()
+	}
+	return m, true
+}
+
+// Interface converts v, a thrift.Value of the type t of d, to a plain Go
+// value which can be encoded with encoding/json:
+//
+//   - the bools to bool, the integers to int64, and the doubles to float64,
+//   - the strings to string, and the binaries to []byte,
+//   - the enums to the name of their value, or to int64 if it's unknown,
+//   - the structs to a map[string]interface{} of their fields by name, the
+//     fields unknown to the IDL being named by their id,
+//   - the lists and sets to []interface{},
+//   - the maps to a map[string]interface{}, with their keys formatted with
+//     fmt.Sprint.
+//
+// The values whose type doesn't match t are converted according to their
+// own type only.
+func (d *Document) Interface(t *Type, v thrift.Value) (interface{}, error) {
+	r, err := d.Resolve(t)
+	if err != nil {
+		return nil, err
+	}
+	if v.Type != r.TType {
+		return genericInterface(v), nil
+	}
+	switch r.TType {
+	case thrift.BYTE, thrift.I16, thrift.I32, thrift.I64:
+		if r.Enum != nil {
+			for _, ev := range r.Enum.Values {
+				if ev.Value == v.Int {
+					return ev.Name, nil
+				}
+			}
+		}
+	case thrift.STRING:
+		if r.Type.Name == "string" {
+			return string(v.Binary), nil
+		}
+		return v.Binary, nil
+	case thrift.STRUCT:
+		return r.Document.StructInterface(r.Struct, v)
+	case thrift.LIST, thrift.SET:
+		elems := make([]interface{}, len(v.Elems))
+		for i, elem := range v.Elems {
+			if elems[i], err = r.Document.Interface(r.Type.ValueType, elem); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	case thrift.MAP:
+		entries := make(map[string]interface{}, len(v.Entries))
+		for _, e := range v.Entries {
+			key, err := r.Document.Interface(r.Type.KeyType, e.Key)
+			if err != nil {
+				return nil, err
+			}
+			if entries[fmt.Sprint(key)], err = r.Document.Interface(r.Type.ValueType, e.Value); err != nil {
+				return nil, err
+			}
+		}
+		return entries, nil
+	}
+	return genericInterface(v), nil
+}
+
+// StructInterface converts v, a STRUCT thrift.Value of the struct s of d, to
+// the map of its fields by name like Interface. The fields are named by id if
+// s is nil, e.g. for the messages unknown to MessageStruct.
+func (d *Document) StructInterface(s *Struct, v thrift.Value) (map[string]interface{}, error) {
+	fields := make(map[string]interface{}, len(v.Fields))
+	for _, f := range v.Fields {
+		var def *Field
+		if s != nil {
+			def = s.Field(f.ID)
+		}
+		if def == nil {
+			fields[strconv.Itoa(int(f.ID))] = genericInterface(f.Value)
+			continue
+		}
+		var err error
+		if fields[def.Name], err = d.Interface(def.Type, f.Value); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// genericInterface converts v like Interface, without its IDL type: the
+// strings are converted to string, and the struct fields are named by id.
+func genericInterface(v thrift.Value) interface{} {
+	switch v.Type {
+	case thrift.BOOL:
+		return v.Bool
+	case thrift.BYTE, thrift.I16, thrift.I32, thrift.I64:
+		return v.Int
+	case thrift.DOUBLE:
+		return v.Double
+	case thrift.STRING:
+		return string(v.Binary)
+	case thrift.STRUCT:
+		fields := make(map[string]interface{}, len(v.Fields))
+		for _, f := range v.Fields {
+			fields[strconv.Itoa(int(f.ID))] = genericInterface(f.Value)
+		}
+		return fields
+	case thrift.LIST, thrift.SET:
+		elems := make([]interface{}, len(v.Elems))
+		for i, elem := range v.Elems {
+			elems[i] = genericInterface(elem)
+		}
+		return elems
+	case thrift.MAP:
+		entries := make(map[string]interface{}, len(v.Entries))
+		for _, e := range v.Entries {
+			entries[fmt.Sprint(genericInterface(e.Key))] = genericInterface(e.Value)
+		}
+		return entries
+	default:
+		return nil
+	}
+}
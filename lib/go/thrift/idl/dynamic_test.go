@@ -0,0 +1,294 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package idl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+const testDynamic = `
+enum Status {
+  ACTIVE = 1,
+  BANNED
+}
+
+struct User {
+  1: required i64 id
+  2: string name
+  3: Status status
+  4: map<i32, list<string>> groups
+  5: binary avatar
+}
+
+exception NotFound {
+  1: string message
+}
+
+service Base {
+  void ping()
+}
+
+service Users extends Base {
+  User get(1: i64 id) throws (1: NotFound notFound)
+  User put(1: User user, 2: double weight)
+  oneway void touch(1: i64 id)
+}
+`
+
+// valueClient is a thrift.TClient passing the arguments of the calls as
+// Values to handler, and reading the result it returns.
+type valueClient struct {
+	handler func(method string, args thrift.Value) thrift.Value
+}
+
+func (c *valueClient) Call(ctx context.Context, method string, args, result thrift.TStruct) (thrift.ResponseMeta, error) {
+	proto := thrift.NewTBinaryProtocolConf(thrift.NewTMemoryBuffer(), nil)
+	if err := args.Write(ctx, proto); err != nil {
+		return thrift.ResponseMeta{}, err
+	}
+	var in thrift.Value
+	if err := in.Read(ctx, proto); err != nil {
+		return thrift.ResponseMeta{}, err
+	}
+	out := c.handler(method, in)
+	if result == nil {
+		return thrift.ResponseMeta{}, nil
+	}
+	if err := out.Write(ctx, proto); err != nil {
+		return thrift.ResponseMeta{}, err
+	}
+	return thrift.ResponseMeta{}, result.Read(ctx, proto)
+}
+
+func TestClient(t *testing.T) {
+	doc, err := Parse("users.thrift", []byte(testDynamic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	users, err := NewClient(doc, "Users", &valueClient{
+		handler: func(method string, args thrift.Value) thrift.Value {
+			calls = append(calls, method+" "+args.String())
+			switch method {
+			case "get":
+				if id, _ := args.Field(1); id.Int != 42 {
+					return thrift.StructValue(thrift.ValueField{ID: 1, Value: thrift.StructValue(
+						thrift.ValueField{ID: 1, Value: thrift.StringValue("no such user")},
+					)})
+				}
+				return thrift.StructValue(thrift.ValueField{ID: 0, Value: thrift.StructValue(
+					thrift.ValueField{ID: 1, Value: thrift.I64Value(42)},
+					thrift.ValueField{ID: 3, Value: thrift.I32Value(2)},
+					thrift.ValueField{ID: 4, Value: thrift.MapValue(thrift.I32, thrift.LIST, thrift.ValueMapEntry{
+						Key:   thrift.I32Value(7),
+						Value: thrift.ListValue(thrift.STRING, thrift.StringValue("admins")),
+					})},
+					thrift.ValueField{ID: 5, Value: thrift.BinaryValue([]byte{0xff})},
+					thrift.ValueField{ID: 9, Value: thrift.I16Value(1)},
+				)})
+			case "put":
+				user, _ := args.Field(1)
+				return thrift.StructValue(thrift.ValueField{ID: 0, Value: user})
+			default:
+				return thrift.StructValue()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	user, err := users.CallMap(ctx, "get", map[string]interface{}{"id": json.Number("42")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"id":     int64(42),
+		"status": "BANNED",
+		"groups": map[string]interface{}{"7": []interface{}{"admins"}},
+		"avatar": []byte{0xff},
+		"9":      int64(1),
+	}
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("expected %#v, got %#v", expected, user)
+	}
+
+	_, err = users.CallMap(ctx, "get", map[string]interface{}{"id": 1})
+	var exc *Exception
+	if !errors.As(err, &exc) || exc.Field != "notFound" || exc.Type != "NotFound" {
+		t.Fatalf("expected a NotFound exception, got %v", err)
+	}
+	if fields, err := exc.Interface(); err != nil || !reflect.DeepEqual(fields, map[string]interface{}{"message": "no such user"}) {
+		t.Errorf("unexpected exception fields %v, %v", fields, err)
+	}
+
+	user, err = users.CallMap(ctx, "put", map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":     7.0,
+			"name":   "alice",
+			"status": "ACTIVE",
+			"groups": map[string][]string{"1": {"a", "b"}},
+		},
+		"weight": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]interface{}{
+		"id":     int64(7),
+		"name":   "alice",
+		"status": "ACTIVE",
+		"groups": map[string]interface{}{"1": []interface{}{"a", "b"}},
+	}
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("expected %#v, got %#v", expected, user)
+	}
+
+	calls = nil
+	for _, method := range []string{"ping", "touch"} {
+		result, err := users.CallMap(ctx, method, map[string]interface{}{})
+		if err != nil || result != nil {
+			t.Errorf("%s: unexpected result %v, %v", method, result, err)
+		}
+	}
+	if expected := []string{"ping {}", "touch {}"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %q, got %q", expected, calls)
+	}
+
+	result, err := users.Call(ctx, "get", thrift.StructValue(thrift.ValueField{ID: 1, Value: thrift.I64Value(42)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := result.Field(1); id.Int != 42 {
+		t.Errorf("unexpected result %v", result)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	doc, err := Parse("users.thrift", []byte(testDynamic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(doc, "Unknown", nil); err == nil {
+		t.Error("expected an error for an unknown service")
+	}
+	users, err := NewClient(doc, "Users", &valueClient{
+		handler: func(method string, args thrift.Value) thrift.Value {
+			return thrift.StructValue()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, c := range []struct {
+		method string
+		args   map[string]interface{}
+		err    string
+	}{
+		{"delete", nil, "unknown function delete of service Users"},
+		{"get", map[string]interface{}{"uid": 1}, "unknown field uid of get"},
+		{"get", map[string]interface{}{"id": "x"}, "field id of get: cannot convert string x to i64"},
+		{"put", map[string]interface{}{"user": map[string]interface{}{}}, "field user of put: required field id of User is not set"},
+		{"put", map[string]interface{}{"user": map[string]interface{}{"id": 1, "status": "GONE"}}, "field user of put: field status of User: cannot convert string GONE to Status"},
+		{"put", map[string]interface{}{"user": map[string]interface{}{"id": 1, "groups": map[int64][]string{1 << 40: nil}}}, "key 1099511627776: cannot convert int64 1099511627776 to i32"},
+		{"get", map[string]interface{}{"id": 1}, "get failed: unknown result"},
+	} {
+		_, err := users.CallMap(ctx, c.method, c.args)
+		if err == nil || !strings.HasSuffix(err.Error(), c.err) {
+			t.Errorf("%s %v: expected error %q, got %v", c.method, c.args, c.err, err)
+		}
+	}
+}
+
+func TestMessageStruct(t *testing.T) {
+	doc, err := Parse("users.thrift", []byte(testDynamic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := thrift.StructValue(
+		thrift.ValueField{ID: 0, Value: thrift.StructValue(
+			thrift.ValueField{ID: 1, Value: thrift.I64Value(42)},
+			thrift.ValueField{ID: 3, Value: thrift.I32Value(2)},
+		)},
+		thrift.ValueField{ID: 9, Value: thrift.BoolValue(true)},
+	)
+	for _, c := range []struct {
+		service, name string
+		typeID        thrift.TMessageType
+		body          thrift.Value
+		expected      map[string]interface{}
+	}{
+		{
+			name:     "get",
+			typeID:   thrift.CALL,
+			body:     thrift.StructValue(thrift.ValueField{ID: 1, Value: thrift.I64Value(42)}),
+			expected: map[string]interface{}{"id": int64(42)},
+		},
+		{
+			name:   "Users:get",
+			typeID: thrift.REPLY,
+			body:   reply,
+			expected: map[string]interface{}{
+				"success": map[string]interface{}{"id": int64(42), "status": "BANNED"},
+				"9":       true,
+			},
+		},
+		{
+			service:  "Users",
+			name:     "ping",
+			typeID:   thrift.CALL,
+			body:     thrift.StructValue(),
+			expected: map[string]interface{}{},
+		},
+		{
+			name:     "unknown",
+			typeID:   thrift.REPLY,
+			body:     reply,
+			expected: map[string]interface{}{"0": map[string]interface{}{"1": int64(42), "3": int64(2)}, "9": true},
+		},
+		{
+			name:   "get",
+			typeID: thrift.EXCEPTION,
+			body: thrift.StructValue(
+				thrift.ValueField{ID: 1, Value: thrift.StringValue("failed")},
+				thrift.ValueField{ID: 2, Value: thrift.I32Value(thrift.INTERNAL_ERROR)},
+			),
+			expected: map[string]interface{}{"message": "failed", "type": int64(thrift.INTERNAL_ERROR)},
+		},
+	} {
+		s, sdoc := doc.MessageStruct(c.service, c.name, c.typeID)
+		fields, err := sdoc.StructInterface(s, c.body)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(fields, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, fields)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package idl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+const testShared = `
+namespace go shared
+
+/** A user of the service. */
+struct User {
+  1: required i64 id
+  2: optional string name = "anonymous"
+}
+
+service Base {
+  void ping()
+}
+`
+
+const testService = `
+include "shared.thrift"
+namespace * example.users
+namespace go users
+
+typedef i64 ( cpp.type = "int64_t" ) UserID
+typedef list<shared.User> Users
+
+const i32 MAX_USERS = 0x10;
+const map<string, list<double>> WEIGHTS = {"a": [1.5, -2e3], 'b': []}
+
+enum Role {
+  READER,
+  WRITER = 5 (deprecated),
+  ADMIN
+} (java.final = "")
+
+union Credentials {
+  1: string password
+  2: binary token
+}
+
+/**
+ * Raised for the unknown users.
+ */
+exception NotFound {
+  string message,
+  i32 code;
+}
+
+struct Tree {
+  1: list<Tree> & children
+  2: map<Role, set<UserID>> members
+}
+
+service Users extends shared.Base {
+  /** Returns the user. */
+  shared.User get(1: UserID id) throws (1: NotFound notFound)
+  oneway void touch(1: UserID id, 2: Credentials credentials) ( idempotent )
+  Users list()
+} (a.b = "c\"d")
+`
+
+func parseTestFiles(t *testing.T) *Document {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "idl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	for name, src := range map[string]string{
+		"shared.thrift":  testShared,
+		"service.thrift": testService,
+		"unused.thrift":  "struct Unused {}",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc, err := ParseFile(filepath.Join(dir, "service.thrift"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestParseFile(t *testing.T) {
+	doc := parseTestFiles(t)
+
+	if len(doc.Includes) != 1 || doc.Includes[0].Name != "shared" || doc.Includes[0].Document == nil {
+		t.Fatalf("unexpected includes %+v", doc.Includes)
+	}
+	if doc.Namespaces["*"] != "example.users" || doc.Namespaces["go"] != "users" {
+		t.Errorf("unexpected namespaces %v", doc.Namespaces)
+	}
+
+	if c := doc.Constant("MAX_USERS"); c == nil || c.Value.Kind != ConstInt || c.Value.Int != 16 {
+		t.Errorf("unexpected constant %+v", c)
+	}
+	weights := doc.Constant("WEIGHTS").Value
+	if weights.Kind != ConstMap || len(weights.Map) != 2 || weights.Map[1].Key.String != "b" ||
+		weights.Map[0].Value.List[1].Double != -2000 {
+		t.Errorf("unexpected map constant %+v", weights)
+	}
+
+	role := doc.Enum("Role")
+	var values []int64
+	for _, v := range role.Values {
+		values = append(values, v.Value)
+	}
+	if len(values) != 3 || values[0] != 0 || values[1] != 5 || values[2] != 6 {
+		t.Errorf("unexpected enum values %v", values)
+	}
+	if role.Values[1].Annotations["deprecated"] != "1" || role.Annotations["java.final"] != "" {
+		t.Errorf("unexpected enum annotations %v, %v", role.Values[1].Annotations, role.Annotations)
+	}
+
+	notFound := doc.Struct("NotFound")
+	if notFound.Kind != StructKindException || notFound.Doc != "Raised for the unknown users." {
+		t.Errorf("unexpected exception %+v", notFound)
+	}
+	if notFound.Field(-1).Name != "message" || notFound.Field(-2).Name != "code" {
+		t.Errorf("expected implicit field ids, got %+v", notFound.Fields)
+	}
+	if doc.Struct("Credentials").Kind != StructKindUnion || !doc.Struct("Tree").Field(1).Reference {
+		t.Error("unexpected union or reference field")
+	}
+
+	user := doc.Struct("shared.User")
+	if user == nil || user.Doc != "A user of the service." {
+		t.Fatalf("unexpected included struct %+v", user)
+	}
+	name := user.Field(2)
+	if name.Requiredness != Optional || name.Default.String != "anonymous" || user.Field(1).Requiredness != Required {
+		t.Errorf("unexpected fields %+v, %+v", user.Field(1), name)
+	}
+
+	service := doc.Service("Users")
+	if service.Extends != "shared.Base" || service.Annotations["a.b"] != `c"d` {
+		t.Errorf("unexpected service %+v", service)
+	}
+	get := service.Functions[0]
+	if get.Doc != "Returns the user." || get.ReturnType.Name != "shared.User" ||
+		get.Arguments[0].Type.Name != "UserID" || get.Exceptions[0].Type.Name != "NotFound" {
+		t.Errorf("unexpected function %+v", get)
+	}
+	touch := service.Functions[1]
+	if !touch.Oneway || touch.ReturnType != nil || touch.Annotations["idempotent"] != "1" {
+		t.Errorf("unexpected oneway function %+v", touch)
+	}
+	var functions []string
+	for _, f := range doc.Functions("Users") {
+		functions = append(functions, f.Name)
+	}
+	if strings.Join(functions, ",") != "get,touch,list,ping" {
+		t.Errorf("unexpected functions %v", functions)
+	}
+	if ping, scope := doc.Function("Users", "ping"); ping == nil || scope != doc.Includes[0].Document {
+		t.Errorf("unexpected inherited function %+v in %v", ping, scope)
+	}
+}
+
+func TestDocumentTType(t *testing.T) {
+	doc := parseTestFiles(t)
+	for _, c := range []struct {
+		typ      *Type
+		expected thrift.TType
+	}{
+		{&Type{Name: "binary"}, thrift.STRING},
+		{&Type{Name: "i8"}, thrift.BYTE},
+		{doc.Typedefs[0].Type, thrift.I64},
+		{&Type{Name: "UserID"}, thrift.I64},
+		{&Type{Name: "Users"}, thrift.LIST},
+		{&Type{Name: "Role"}, thrift.I32},
+		{&Type{Name: "Credentials"}, thrift.STRUCT},
+		{&Type{Name: "shared.User"}, thrift.STRUCT},
+		{doc.Struct("Tree").Field(2).Type, thrift.MAP},
+		{doc.Struct("Tree").Field(2).Type.ValueType, thrift.SET},
+	} {
+		ttype, err := doc.TType(c.typ)
+		if err != nil || ttype != c.expected {
+			t.Errorf("%v: expected %v, got %v, %v", c.typ, c.expected, ttype, err)
+		}
+	}
+	r, err := doc.Resolve(doc.Service("Users").Functions[0].ReturnType)
+	if err != nil || r.Struct == nil || r.Document != doc.Includes[0].Document {
+		t.Errorf("unexpected resolved type %+v, %v", r, err)
+	}
+	for _, name := range []string{"Unknown", "shared.Unknown", "unused.Unused"} {
+		if _, err := doc.TType(&Type{Name: name}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, c := range []struct {
+		src      string
+		expected string
+	}{
+		{"struct {}", "test.thrift:1:8: unexpected \"{\", expected an identifier"},
+		{"struct A {\n  1: i32 a\n  1: i32 b\n}", "test.thrift:3:3: duplicate field id 1"},
+		{"struct A {\n  0: i32 a\n}", "test.thrift:2:3: field id 0 out of range"},
+		{"enum E { A = 4294967296 }", "test.thrift:1:10: value 4294967296 of E.A out of the range of i32"},
+		{"const string S = \"unterminated", "test.thrift:1:18: unterminated literal"},
+		{"/* unterminated", "test.thrift:1:1: unterminated comment"},
+		{"service S {\n  void f(1: map<i32> m)\n}", "test.thrift:2:20: unexpected \">\", expected \",\""},
+		{"typedef i32 T\n@", "test.thrift:2:1: unexpected character '@'"},
+		{"service S {", "test.thrift:1:12: unexpected end of file, expected a type"},
+		{"union U { 1: void v }", "test.thrift:1:14: unexpected \"void\", expected a type"},
+	} {
+		_, err := Parse("test.thrift", []byte(c.src))
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("%q: expected %q, got %v", c.src, c.expected, err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "idl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	for name, src := range map[string]string{
+		"a.thrift":       `include "b.thrift"`,
+		"b.thrift":       `include "a.thrift"`,
+		"missing.thrift": `include "none.thrift"`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ParseFile(filepath.Join(dir, "a.thrift")); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("expected an include cycle, got %v", err)
+	}
+	if _, err := ParseFile(filepath.Join(dir, "missing.thrift")); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a missing include, got %v", err)
+	}
+}
+
+func TestParseRepositoryFiles(t *testing.T) {
+	doc, err := ParseFile("../../../../test/Include.thrift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bools := doc.Struct("IncludeTest").Field(1)
+	if ttype, err := doc.TType(bools.Type); err != nil || ttype != thrift.STRUCT {
+		t.Errorf("expected %s to be a struct, got %v, %v", bools.Type, ttype, err)
+	}
+	thriftTest := doc.Includes[0].Document
+	if f := thriftTest.Functions("ThriftTest"); len(f) == 0 || f[0].Name != "testVoid" {
+		t.Errorf("unexpected ThriftTest functions %v", f)
+	}
+	six := thriftTest.Enum("Numberz").Values[4]
+	if six.Name != "SIX" || six.Value != 6 {
+		t.Errorf("unexpected enum value %+v", six)
+	}
+
+	// Its field named service is not mistaken for a service.
+	if _, err := ParseFile("../health/health.thrift"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,279 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package idl
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// tokenKind is the kind of a token.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenInt
+	tokenDouble
+	tokenLiteral
+	// tokenSymbol is a single character, one of {}()[]<>,;:=*&.
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	pos  Pos
+
+	// text is the identifier, the number or the symbol, or the value of
+	// the literal, unescaped.
+	text string
+
+	// doc is the doc comment (/** ... */) right before the token, if any.
+	doc string
+}
+
+// lexer splits an IDL file into tokens.
+type lexer struct {
+	filename string
+	src      string
+	offset   int
+	line     int
+	column   int
+}
+
+func newLexer(filename string, src []byte) *lexer {
+	return &lexer{
+		filename: filename,
+		src:      string(src),
+		line:     1,
+		column:   1,
+	}
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{Filename: l.filename, Line: l.line, Column: l.column}
+}
+
+func (l *lexer) errorf(pos Pos, msg string) *Error {
+	return &Error{Pos: pos, Msg: msg}
+}
+
+// advance consumes n bytes.
+func (l *lexer) advance(n int) {
+	for _, c := range l.src[l.offset : l.offset+n] {
+		if c == '\n' {
+			l.line++
+			l.column = 1
+		} else {
+			l.column++
+		}
+	}
+	l.offset += n
+}
+
+func (l *lexer) peekByte(i int) byte {
+	if l.offset+i < len(l.src) {
+		return l.src[l.offset+i]
+	}
+	return 0
+}
+
+// next returns the next token.
+func (l *lexer) next() (token, error) {
+	doc, err := l.skipSpaceAndComments()
+	if err != nil {
+		return token{}, err
+	}
+	tok := token{pos: l.pos(), doc: doc}
+	if l.offset >= len(l.src) {
+		tok.kind = tokenEOF
+		return tok, nil
+	}
+	c := l.src[l.offset]
+	switch {
+	case isIdentifierStart(c):
+		n := 1
+		for l.offset+n < len(l.src) && isIdentifierPart(l.src[l.offset+n]) {
+			n++
+		}
+		tok.kind, tok.text = tokenIdentifier, l.src[l.offset:l.offset+n]
+		l.advance(n)
+	case isDigit(c) || ((c == '+' || c == '-') && (isDigit(l.peekByte(1)) || l.peekByte(1) == '.')) || (c == '.' && isDigit(l.peekByte(1))):
+		tok.kind, tok.text = l.number()
+	case c == '"' || c == '\'':
+		tok.kind = tokenLiteral
+		if tok.text, err = l.literal(c); err != nil {
+			return token{}, err
+		}
+	case strings.IndexByte("{}()[]<>,;:=*&", c) >= 0:
+		tok.kind, tok.text = tokenSymbol, string(c)
+		l.advance(1)
+	default:
+		r, _ := utf8.DecodeRuneInString(l.src[l.offset:])
+		return token{}, l.errorf(tok.pos, "unexpected character "+quoteRune(r))
+	}
+	return tok, nil
+}
+
+// skipSpaceAndComments skips the spaces and the comments before the next
+// token, and returns the last doc comment, if it's right before the token.
+func (l *lexer) skipSpaceAndComments() (string, error) {
+	var doc string
+	for l.offset < len(l.src) {
+		c := l.src[l.offset]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			l.advance(1)
+		case c == '#' || (c == '/' && l.peekByte(1) == '/'):
+			n := strings.IndexByte(l.src[l.offset:], '\n')
+			if n < 0 {
+				n = len(l.src) - l.offset
+			}
+			l.advance(n)
+			doc = ""
+		case c == '/' && l.peekByte(1) == '*':
+			pos := l.pos()
+			end := strings.Index(l.src[l.offset+2:], "*/")
+			if end < 0 {
+				return "", l.errorf(pos, "unterminated comment")
+			}
+			comment := l.src[l.offset : l.offset+end+4]
+			l.advance(len(comment))
+			doc = ""
+			if strings.HasPrefix(comment, "/**") && comment != "/**/" {
+				doc = cleanDoc(comment)
+			}
+		default:
+			return doc, nil
+		}
+	}
+	return doc, nil
+}
+
+// cleanDoc returns the text of a doc comment, without its delimiters nor the
+// stars at the beginning of its lines.
+func cleanDoc(comment string) string {
+	comment = strings.TrimSuffix(strings.TrimPrefix(comment, "/**"), "*/")
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "*") {
+			line = strings.TrimSpace(line[1:])
+		}
+		lines[i] = line
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// number lexes an integer, decimal or hexadecimal, or a double.
+func (l *lexer) number() (tokenKind, string) {
+	start := l.offset
+	n := 0
+	if c := l.peekByte(0); c == '+' || c == '-' {
+		n++
+	}
+	if l.src[start+n] == '0' && (l.peekByte(n+1) == 'x' || l.peekByte(n+1) == 'X') {
+		n += 2
+		for isHexDigit(l.peekByte(n)) {
+			n++
+		}
+		l.advance(n)
+		return tokenInt, l.src[start : start+n]
+	}
+	kind := tokenInt
+	for isDigit(l.peekByte(n)) {
+		n++
+	}
+	if l.peekByte(n) == '.' && isDigit(l.peekByte(n+1)) {
+		kind = tokenDouble
+		n++
+		for isDigit(l.peekByte(n)) {
+			n++
+		}
+	}
+	if c := l.peekByte(n); c == 'e' || c == 'E' {
+		m := n + 1
+		if c := l.peekByte(m); c == '+' || c == '-' {
+			m++
+		}
+		if isDigit(l.peekByte(m)) {
+			kind = tokenDouble
+			n = m
+			for isDigit(l.peekByte(n)) {
+				n++
+			}
+		}
+	}
+	l.advance(n)
+	return kind, l.src[start : start+n]
+}
+
+// literal lexes a string literal delimited by quote, with the escapes of
+// the compiler.
+func (l *lexer) literal(quote byte) (string, error) {
+	pos := l.pos()
+	var sb strings.Builder
+	for n := 1; l.offset+n < len(l.src); n++ {
+		c := l.src[l.offset+n]
+		switch c {
+		case quote:
+			l.advance(n + 1)
+			return sb.String(), nil
+		case '\\':
+			n++
+			switch l.peekByte(n) {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case '\\', '"', '\'':
+				sb.WriteByte(l.peekByte(n))
+			default:
+				l.advance(n - 1)
+				return "", l.errorf(l.pos(), "invalid escape sequence in literal")
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", l.errorf(pos, "unterminated literal")
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierPart(c byte) bool {
+	return isIdentifierStart(c) || isDigit(c) || c == '.'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func quoteRune(r rune) string {
+	return "'" + string(r) + "'"
+}
@@ -0,0 +1,750 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package idl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Parse parses the IDL src of the file filename, without parsing its
+// includes, so the Document of its Includes are nil.
+func Parse(filename string, src []byte) (*Document, error) {
+	p := &parser{lexer: newLexer(filename, src)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return p.document()
+}
+
+// ParseFile parses the IDL file path, and its includes, recursively. The
+// includes are looked up relatively to the file including them, then in
+// includeDirs, like with the -I option of the compiler.
+//
+// The files included multiple times are parsed once, and share the same
+// Document.
+func ParseFile(path string, includeDirs ...string) (*Document, error) {
+	r := &includeResolver{
+		includeDirs: includeDirs,
+		documents:   make(map[string]*Document),
+		parsing:     make(map[string]bool),
+	}
+	return r.parse(path)
+}
+
+type includeResolver struct {
+	includeDirs []string
+	documents   map[string]*Document
+	parsing     map[string]bool
+}
+
+func (r *includeResolver) parse(path string) (*Document, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := r.documents[abs]; ok {
+		return d, nil
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := Parse(path, src)
+	if err != nil {
+		return nil, err
+	}
+	r.parsing[abs] = true
+	defer delete(r.parsing, abs)
+	for _, inc := range d.Includes {
+		incPath, ok := r.lookup(filepath.Dir(path), inc.Path)
+		if !ok {
+			return nil, &Error{Pos: inc.Pos, Msg: fmt.Sprintf("included file %q not found", inc.Path)}
+		}
+		if incAbs, _ := filepath.Abs(incPath); r.parsing[incAbs] {
+			return nil, &Error{Pos: inc.Pos, Msg: fmt.Sprintf("include cycle through %q", inc.Path)}
+		}
+		if inc.Document, err = r.parse(incPath); err != nil {
+			return nil, err
+		}
+	}
+	r.documents[abs] = d
+	return d, nil
+}
+
+// lookup returns the path of the file included by a file of dir.
+func (r *includeResolver) lookup(dir, include string) (string, bool) {
+	if filepath.IsAbs(include) {
+		return include, fileExists(include)
+	}
+	for _, d := range append([]string{dir}, r.includeDirs...) {
+		if path := filepath.Join(d, include); fileExists(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// parser is a recursive descent parser of the grammar of the compiler, with
+// a single token of lookahead.
+type parser struct {
+	lexer *lexer
+	tok   token
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Pos: p.tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// unexpected returns the error of an unexpected token, expected describing
+// what was expected instead.
+func (p *parser) unexpected(expected string) error {
+	switch p.tok.kind {
+	case tokenEOF:
+		return p.errorf("unexpected end of file, expected %s", expected)
+	case tokenLiteral:
+		return p.errorf("unexpected literal %q, expected %s", p.tok.text, expected)
+	default:
+		return p.errorf("unexpected %q, expected %s", p.tok.text, expected)
+	}
+}
+
+// is reports whether the token is the symbol or keyword s.
+func (p *parser) is(s string) bool {
+	return (p.tok.kind == tokenSymbol || p.tok.kind == tokenIdentifier) && p.tok.text == s
+}
+
+// accept consumes the token if it's the symbol or keyword s.
+func (p *parser) accept(s string) (bool, error) {
+	if !p.is(s) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+// expect consumes the token, which must be the symbol or keyword s.
+func (p *parser) expect(s string) error {
+	if !p.is(s) {
+		return p.unexpected(strconv.Quote(s))
+	}
+	return p.advance()
+}
+
+// identifier consumes an identifier, which must not be a keyword.
+func (p *parser) identifier() (string, error) {
+	if p.tok.kind != tokenIdentifier || keywords[p.tok.text] {
+		return "", p.unexpected("an identifier")
+	}
+	name := p.tok.text
+	return name, p.advance()
+}
+
+// name consumes the name of a field, a function or an enum value, which can
+// be a keyword, as it can't be mistaken for one where names are expected.
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenIdentifier {
+		return "", p.unexpected("a name")
+	}
+	name := p.tok.text
+	return name, p.advance()
+}
+
+// literal consumes a string literal.
+func (p *parser) literal() (string, error) {
+	if p.tok.kind != tokenLiteral {
+		return "", p.unexpected("a literal")
+	}
+	s := p.tok.text
+	return s, p.advance()
+}
+
+// integer consumes an integer.
+func (p *parser) integer() (int64, error) {
+	if p.tok.kind != tokenInt {
+		return 0, p.unexpected("an integer")
+	}
+	i, err := parseInt(p.tok.text)
+	if err != nil {
+		return 0, p.errorf("invalid integer %s", p.tok.text)
+	}
+	return i, p.advance()
+}
+
+// parseInt parses a decimal or hexadecimal integer, the decimal integers
+// with leading zeros not being octal.
+func parseInt(s string) (int64, error) {
+	sign := ""
+	if s[0] == '+' || s[0] == '-' {
+		sign, s = s[:1], s[1:]
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseInt(sign+s[2:], 16, 64)
+	}
+	return strconv.ParseInt(sign+s, 10, 64)
+}
+
+// separator consumes the optional "," or ";" after a definition.
+func (p *parser) separator() error {
+	if p.is(",") || p.is(";") {
+		return p.advance()
+	}
+	return nil
+}
+
+var keywords = map[string]bool{
+	"include": true, "cpp_include": true, "namespace": true, "const": true,
+	"typedef": true, "enum": true, "senum": true, "struct": true,
+	"union": true, "exception": true, "service": true, "extends": true,
+	"required": true, "optional": true, "oneway": true, "async": true,
+	"void": true, "throws": true, "map": true, "set": true, "list": true,
+	"cpp_type": true, "bool": true, "byte": true, "i8": true, "i16": true,
+	"i32": true, "i64": true, "double": true, "string": true,
+	"binary": true, "uuid": true, "slist": true,
+}
+
+func (p *parser) document() (*Document, error) {
+	d := &Document{
+		Filename:   p.lexer.filename,
+		Namespaces: make(map[string]string),
+	}
+	for p.tok.kind != tokenEOF {
+		if err := p.definition(d); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (p *parser) definition(d *Document) error {
+	pos, doc := p.tok.pos, p.tok.doc
+	if p.tok.kind != tokenIdentifier {
+		return p.unexpected("a definition")
+	}
+	keyword := p.tok.text
+	switch keyword {
+	case "include", "cpp_include", "namespace", "const", "typedef", "enum", "senum", "struct", "union", "exception", "service":
+	default:
+		return p.unexpected("a definition")
+	}
+	if err := p.advance(); err != nil {
+		return err
+	}
+	switch keyword {
+	case "include":
+		path, err := p.literal()
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(path)
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		d.Includes = append(d.Includes, &Include{Pos: pos, Path: path, Name: name})
+	case "cpp_include":
+		path, err := p.literal()
+		if err != nil {
+			return err
+		}
+		d.CppIncludes = append(d.CppIncludes, path)
+	case "namespace":
+		scope := p.tok.text
+		if p.is("*") {
+			if err := p.advance(); err != nil {
+				return err
+			}
+		} else if _, err := p.identifier(); err != nil {
+			return err
+		}
+		var name string
+		var err error
+		if p.tok.kind == tokenLiteral {
+			name, err = p.literal()
+		} else {
+			name, err = p.identifier()
+		}
+		if err != nil {
+			return err
+		}
+		d.Namespaces[scope] = name
+		if _, err := p.annotations(); err != nil {
+			return err
+		}
+	case "const":
+		c := &Constant{Pos: pos, Doc: doc}
+		var err error
+		if c.Type, err = p.fieldType(); err != nil {
+			return err
+		}
+		if c.Name, err = p.identifier(); err != nil {
+			return err
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		if c.Value, err = p.constValue(); err != nil {
+			return err
+		}
+		d.Constants = append(d.Constants, c)
+	case "typedef":
+		t := &Typedef{Pos: pos, Doc: doc}
+		var err error
+		if t.Type, err = p.fieldType(); err != nil {
+			return err
+		}
+		if t.Name, err = p.identifier(); err != nil {
+			return err
+		}
+		if t.Annotations, err = p.annotations(); err != nil {
+			return err
+		}
+		d.Typedefs = append(d.Typedefs, t)
+	case "enum":
+		e, err := p.enum(pos, doc)
+		if err != nil {
+			return err
+		}
+		d.Enums = append(d.Enums, e)
+	case "senum":
+		t, err := p.senum(pos, doc)
+		if err != nil {
+			return err
+		}
+		d.Typedefs = append(d.Typedefs, t)
+	case "struct", "union", "exception":
+		s, err := p.structDefinition(pos, doc, keyword)
+		if err != nil {
+			return err
+		}
+		d.Structs = append(d.Structs, s)
+	case "service":
+		s, err := p.service(pos, doc)
+		if err != nil {
+			return err
+		}
+		d.Services = append(d.Services, s)
+	}
+	return p.separator()
+}
+
+func (p *parser) enum(pos Pos, doc string) (*Enum, error) {
+	e := &Enum{Pos: pos, Doc: doc}
+	var err error
+	if e.Name, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	next := int64(0)
+	for !p.is("}") {
+		v := &EnumValue{Pos: p.tok.pos, Doc: p.tok.doc}
+		if v.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		v.Value = next
+		if ok, err := p.accept("="); err != nil {
+			return nil, err
+		} else if ok {
+			if v.Value, err = p.integer(); err != nil {
+				return nil, err
+			}
+		}
+		if v.Value < math.MinInt32 || v.Value > math.MaxInt32 {
+			return nil, &Error{Pos: v.Pos, Msg: fmt.Sprintf("value %d of %s.%s out of the range of i32", v.Value, e.Name, v.Name)}
+		}
+		next = v.Value + 1
+		if v.Annotations, err = p.annotations(); err != nil {
+			return nil, err
+		}
+		if err := p.separator(); err != nil {
+			return nil, err
+		}
+		e.Values = append(e.Values, v)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if e.Annotations, err = p.annotations(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// senum parses a deprecated senum, as a typedef of string.
+func (p *parser) senum(pos Pos, doc string) (*Typedef, error) {
+	t := &Typedef{Pos: pos, Doc: doc, Type: &Type{Pos: pos, Name: "string"}}
+	var err error
+	if t.Name, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.is("}") {
+		if _, err := p.literal(); err != nil {
+			return nil, err
+		}
+		if err := p.separator(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if t.Annotations, err = p.annotations(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (p *parser) structDefinition(pos Pos, doc, keyword string) (*Struct, error) {
+	s := &Struct{Pos: pos, Doc: doc}
+	switch keyword {
+	case "union":
+		s.Kind = StructKindUnion
+	case "exception":
+		s.Kind = StructKindException
+	}
+	var err error
+	if s.Name, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if _, err := p.accept("xsd_all"); err != nil {
+		return nil, err
+	}
+	if s.Fields, err = p.fields("{", "}"); err != nil {
+		return nil, err
+	}
+	if s.Annotations, err = p.annotations(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *parser) service(pos Pos, doc string) (*Service, error) {
+	s := &Service{Pos: pos, Doc: doc}
+	var err error
+	if s.Name, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.accept("extends"); err != nil {
+		return nil, err
+	} else if ok {
+		if s.Extends, err = p.identifier(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.is("}") {
+		f, err := p.function()
+		if err != nil {
+			return nil, err
+		}
+		s.Functions = append(s.Functions, f)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if s.Annotations, err = p.annotations(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *parser) function() (*Function, error) {
+	f := &Function{Pos: p.tok.pos, Doc: p.tok.doc}
+	if p.is("oneway") || p.is("async") {
+		f.Oneway = true
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if ok, err := p.accept("void"); err != nil {
+		return nil, err
+	} else if !ok {
+		if f.ReturnType, err = p.fieldType(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.Arguments, err = p.fields("(", ")"); err != nil {
+		return nil, err
+	}
+	if ok, err := p.accept("throws"); err != nil {
+		return nil, err
+	} else if ok {
+		if f.Exceptions, err = p.fields("(", ")"); err != nil {
+			return nil, err
+		}
+	}
+	if f.Annotations, err = p.annotations(); err != nil {
+		return nil, err
+	}
+	return f, p.separator()
+}
+
+// fields parses the fields of a struct or the arguments or exceptions of a
+// function, between open and close.
+func (p *parser) fields(open, close string) ([]*Field, error) {
+	if err := p.expect(open); err != nil {
+		return nil, err
+	}
+	var fields []*Field
+	implicitID := int16(-1)
+	for !p.is(close) {
+		f, err := p.field(&implicitID)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range fields {
+			if other.ID == f.ID {
+				return nil, &Error{Pos: f.Pos, Msg: fmt.Sprintf("duplicate field id %d", f.ID)}
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, p.advance()
+}
+
+func (p *parser) field(implicitID *int16) (*Field, error) {
+	f := &Field{Pos: p.tok.pos, Doc: p.tok.doc}
+	if p.tok.kind == tokenInt {
+		id, err := p.integer()
+		if err != nil {
+			return nil, err
+		}
+		if id < 1 || id > math.MaxInt16 {
+			return nil, &Error{Pos: f.Pos, Msg: fmt.Sprintf("field id %d out of range", id)}
+		}
+		f.ID = int16(id)
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+	} else {
+		f.ID = *implicitID
+		*implicitID--
+	}
+	switch {
+	case p.is("required"):
+		f.Requiredness = Required
+	case p.is("optional"):
+		f.Requiredness = Optional
+	}
+	if f.Requiredness != Default {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if f.Type, err = p.fieldType(); err != nil {
+		return nil, err
+	}
+	if f.Reference, err = p.accept("&"); err != nil {
+		return nil, err
+	}
+	if f.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.accept("="); err != nil {
+		return nil, err
+	} else if ok {
+		if f.Default, err = p.constValue(); err != nil {
+			return nil, err
+		}
+	}
+	for _, option := range []string{"xsd_optional", "xsd_nillable"} {
+		if _, err := p.accept(option); err != nil {
+			return nil, err
+		}
+	}
+	if f.Annotations, err = p.annotations(); err != nil {
+		return nil, err
+	}
+	return f, p.separator()
+}
+
+func (p *parser) fieldType() (*Type, error) {
+	t := &Type{Pos: p.tok.pos}
+	if p.tok.kind != tokenIdentifier {
+		return nil, p.unexpected("a type")
+	}
+	t.Name = p.tok.text
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var err error
+	switch t.Name {
+	case "map", "set", "list":
+		if ok, err := p.accept("cpp_type"); err != nil {
+			return nil, err
+		} else if ok {
+			if _, err := p.literal(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("<"); err != nil {
+			return nil, err
+		}
+		if t.Name == "map" {
+			if t.KeyType, err = p.fieldType(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if t.ValueType, err = p.fieldType(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(">"); err != nil {
+			return nil, err
+		}
+	case "slist":
+		t.Name = "string"
+	default:
+		if keywords[t.Name] && !t.IsBase() {
+			return nil, &Error{Pos: t.Pos, Msg: fmt.Sprintf("unexpected %q, expected a type", t.Name)}
+		}
+	}
+	if t.Annotations, err = p.annotations(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (p *parser) constValue() (*ConstValue, error) {
+	v := &ConstValue{Pos: p.tok.pos}
+	var err error
+	switch {
+	case p.tok.kind == tokenInt:
+		v.Kind = ConstInt
+		v.Int, err = p.integer()
+		return v, err
+	case p.tok.kind == tokenDouble:
+		v.Kind = ConstDouble
+		if v.Double, err = strconv.ParseFloat(p.tok.text, 64); err != nil {
+			return nil, p.errorf("invalid double %s", p.tok.text)
+		}
+		return v, p.advance()
+	case p.tok.kind == tokenLiteral:
+		v.Kind = ConstString
+		v.String, err = p.literal()
+		return v, err
+	case p.tok.kind == tokenIdentifier:
+		v.Kind = ConstIdentifier
+		v.Identifier, err = p.identifier()
+		return v, err
+	case p.is("["):
+		v.Kind = ConstList
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		for !p.is("]") {
+			elem, err := p.constValue()
+			if err != nil {
+				return nil, err
+			}
+			v.List = append(v.List, elem)
+			if err := p.separator(); err != nil {
+				return nil, err
+			}
+		}
+		return v, p.advance()
+	case p.is("{"):
+		v.Kind = ConstMap
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		for !p.is("}") {
+			var e ConstMapEntry
+			if e.Key, err = p.constValue(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if e.Value, err = p.constValue(); err != nil {
+				return nil, err
+			}
+			v.Map = append(v.Map, e)
+			if err := p.separator(); err != nil {
+				return nil, err
+			}
+		}
+		return v, p.advance()
+	default:
+		return nil, p.unexpected("a value")
+	}
+}
+
+// annotations parses the optional annotations of a definition.
+func (p *parser) annotations() (Annotations, error) {
+	if !p.is("(") {
+		return nil, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	annotations := make(Annotations)
+	for !p.is(")") {
+		if p.tok.kind != tokenIdentifier {
+			return nil, p.unexpected("an annotation")
+		}
+		key := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		value := "1"
+		if ok, err := p.accept("="); err != nil {
+			return nil, err
+		} else if ok {
+			if value, err = p.literal(); err != nil {
+				return nil, err
+			}
+		}
+		annotations[key] = value
+		if err := p.separator(); err != nil {
+			return nil, err
+		}
+	}
+	return annotations, p.advance()
+}
@@ -0,0 +1,224 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package idl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// HandlerFunc handles the calls of a function of a Processor.
+//
+// args is the STRUCT Value of the arguments of the call by id, and the
+// returned Value is the result of the function, ignored for the void and
+// oneway functions. A zero Value is replied as an unset result, which the
+// clients fail with MISSING_RESULT.
+//
+// The *Exceptions whose Field is the name of an exception of the function,
+// or whose Type is the type of one if Field is empty, are replied as this
+// exception, and the other errors as the errors of the handlers of the
+// generated processors, see thrift.HandlerErrorApplicationException.
+type HandlerFunc func(ctx context.Context, args thrift.Value) (thrift.Value, error)
+
+// Processor is a thrift.TProcessor serving the functions of a service
+// described by a Document with HandlerFuncs, for example to mock a server or
+// to forward the calls of a gateway without generated code:
+//
+//	processor, err := idl.NewProcessor(doc, "UserService", map[string]idl.HandlerFunc{
+//		"getUser": func(ctx context.Context, args thrift.Value) (thrift.Value, error) {
+//			id, _ := args.Field(1)
+//			return thrift.StructValue(thrift.ValueField{ID: 1, Value: id}), nil
+//		},
+//	})
+//
+// The calls of the functions without a HandlerFunc are replied with an
+// UNKNOWN_METHOD TApplicationException.
+type Processor struct {
+	processorMap map[string]thrift.TProcessorFunction
+}
+
+// NewProcessor returns a Processor serving the functions of the service of
+// doc, or of the services it extends, with the handlers by function name.
+func NewProcessor(doc *Document, service string, handlers map[string]HandlerFunc) (*Processor, error) {
+	if doc.Service(service) == nil {
+		return nil, fmt.Errorf("unknown service %s", service)
+	}
+	functions := make(map[string]scopedFunction)
+	for _, f := range doc.scopedFunctions(service) {
+		if _, ok := functions[f.Name]; !ok {
+			functions[f.Name] = f
+		}
+	}
+	p := &Processor{processorMap: make(map[string]thrift.TProcessorFunction, len(handlers))}
+	for name, handler := range handlers {
+		f, ok := functions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s of service %s", name, service)
+		}
+		var returnType thrift.TType
+		if f.ReturnType != nil {
+			var err error
+			if returnType, err = f.doc.TType(f.ReturnType); err != nil {
+				return nil, fmt.Errorf("function %s: %w", name, err)
+			}
+		}
+		p.processorMap[name] = &processorFunction{
+			function:   f,
+			returnType: returnType,
+			handler:    handler,
+		}
+	}
+	return p, nil
+}
+
+// Process implements thrift.TProcessor.
+func (p *Processor) Process(ctx context.Context, in, out thrift.TProtocol) (bool, thrift.TException) {
+	name, _, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, thrift.WrapTException(err)
+	}
+	if f, ok := p.processorMap[name]; ok {
+		return f.Process(ctx, seqID, in, out)
+	}
+	in.Skip(ctx, thrift.STRUCT)
+	in.ReadMessageEnd(ctx)
+	exc := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function "+name)
+	writeException(ctx, out, name, seqID, exc)
+	return false, exc
+}
+
+// ProcessorMap implements thrift.TProcessor.
+func (p *Processor) ProcessorMap() map[string]thrift.TProcessorFunction {
+	return p.processorMap
+}
+
+// AddToProcessorMap implements thrift.TProcessor.
+func (p *Processor) AddToProcessorMap(name string, f thrift.TProcessorFunction) {
+	p.processorMap[name] = f
+}
+
+// processorFunction is the thrift.TProcessorFunction of a HandlerFunc.
+type processorFunction struct {
+	function   scopedFunction
+	returnType thrift.TType
+	handler    HandlerFunc
+}
+
+func (p *processorFunction) Process(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+	name, oneway := p.function.Name, p.function.Oneway
+	var args thrift.Value
+	err := args.Read(ctx, in)
+	if err == nil {
+		err = checkRequired(name, p.function.Arguments, args)
+	}
+	if err != nil {
+		in.ReadMessageEnd(ctx)
+		if !oneway {
+			writeException(ctx, out, name, seqID, thrift.NewTApplicationException(thrift.PROTOCOL_ERROR, err.Error()))
+		}
+		return false, thrift.WrapTException(err)
+	}
+	in.ReadMessageEnd(ctx)
+	if err := thrift.ValidateArguments(ctx, name, &args); err != nil {
+		if !oneway {
+			writeException(ctx, out, name, seqID, thrift.NewTApplicationExceptionWithReason(thrift.PROTOCOL_ERROR, thrift.ExceptionReasonInvalidArgument, err.Error()))
+		}
+		return true, thrift.WrapTException(err)
+	}
+
+	retval, err := p.handler(ctx, args)
+	if oneway {
+		if err != nil {
+			return true, thrift.WrapTException(err)
+		}
+		return true, nil
+	}
+	result := thrift.StructValue()
+	if err == nil && p.returnType != thrift.STOP && retval.Type != thrift.STOP {
+		if retval.Type != p.returnType {
+			err = fmt.Errorf("handler of %s returned a %v, expected a %v", name, retval.Type, p.returnType)
+		} else {
+			result.SetField(0, "success", retval)
+		}
+	}
+	if err != nil {
+		err = thrift.ClassifyHandlerError(ctx, name, err)
+		if e := p.exception(err); e != nil {
+			result.SetField(e.ID, e.Name, err.(*Exception).Value)
+		} else {
+			if err == thrift.ErrAbandonRequest {
+				return false, thrift.WrapTException(err)
+			}
+			writeException(ctx, out, name, seqID, thrift.HandlerErrorApplicationException(ctx, name, err))
+			return true, thrift.WrapTException(err)
+		}
+	}
+	if err := out.WriteMessageBegin(ctx, name, thrift.REPLY, seqID); err != nil {
+		return false, thrift.WrapTException(err)
+	}
+	if err := result.Write(ctx, out); err != nil {
+		return false, thrift.WrapTException(err)
+	}
+	if err := out.WriteMessageEnd(ctx); err != nil {
+		return false, thrift.WrapTException(err)
+	}
+	if err := out.Flush(ctx); err != nil {
+		return false, thrift.WrapTException(err)
+	}
+	return true, nil
+}
+
+// exception returns the exception of the function err is, nil if it's not
+// one.
+func (p *processorFunction) exception(err error) *Field {
+	exc, ok := err.(*Exception)
+	if !ok || exc.Value.Type != thrift.STRUCT {
+		return nil
+	}
+	for _, e := range p.function.Exceptions {
+		if exc.Field == e.Name || (exc.Field == "" && exc.Type == e.Type.Name) {
+			return e
+		}
+	}
+	return nil
+}
+
+// checkRequired returns an INVALID_DATA TProtocolException if a required
+// field of fields is not set in s, as the Read of the generated structs.
+func checkRequired(name string, fields []*Field, s thrift.Value) error {
+	for _, f := range fields {
+		if _, ok := s.Field(f.ID); !ok && f.Requiredness == Required {
+			return thrift.NewTProtocolExceptionWithType(thrift.INVALID_DATA, fmt.Errorf("required field %s of %s is not set", f.Name, name))
+		}
+	}
+	return nil
+}
+
+// writeException writes exc as the EXCEPTION reply of a call.
+func writeException(ctx context.Context, out thrift.TProtocol, name string, seqID int32, exc thrift.TApplicationException) {
+	out.WriteMessageBegin(ctx, name, thrift.EXCEPTION, seqID)
+	exc.Write(ctx, out)
+	out.WriteMessageEnd(ctx)
+	out.Flush(ctx)
+}
+
+var _ thrift.TProcessor = (*Processor)(nil)
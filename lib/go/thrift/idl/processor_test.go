@@ -0,0 +1,137 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package idl
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// processorClient is a thrift.TClient calling a thrift.TProcessor through
+// memory buffers.
+type processorClient struct {
+	processor thrift.TProcessor
+}
+
+func (c *processorClient) Call(ctx context.Context, method string, args, result thrift.TStruct) (thrift.ResponseMeta, error) {
+	req := thrift.NewTBinaryProtocolConf(thrift.NewTMemoryBuffer(), nil)
+	resp := thrift.NewTBinaryProtocolConf(thrift.NewTMemoryBuffer(), nil)
+	client := thrift.NewTStandardClient(resp, req)
+	if err := client.Send(ctx, req, 1, method, args); err != nil {
+		return thrift.ResponseMeta{}, err
+	}
+	c.processor.Process(ctx, req, resp)
+	if result == nil {
+		return thrift.ResponseMeta{}, nil
+	}
+	return thrift.ResponseMeta{}, client.Recv(ctx, resp, 1, method, result)
+}
+
+func TestProcessor(t *testing.T) {
+	doc, err := Parse("users.thrift", []byte(testDynamic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var touched []int64
+	processor, err := NewProcessor(doc, "Users", map[string]HandlerFunc{
+		"ping": func(ctx context.Context, args thrift.Value) (thrift.Value, error) {
+			return thrift.Value{}, nil
+		},
+		"get": func(ctx context.Context, args thrift.Value) (thrift.Value, error) {
+			id, _ := args.Field(1)
+			switch id.Int {
+			case 1:
+				return thrift.Value{}, &Exception{
+					Type:  "NotFound",
+					Value: thrift.StructValue(thrift.ValueField{ID: 1, Value: thrift.StringValue("no such user")}),
+				}
+			case 2:
+				return thrift.StringValue("not a user"), nil
+			case 3:
+				return thrift.Value{}, errors.New("database is down")
+			}
+			return thrift.StructValue(
+				thrift.ValueField{ID: 1, Value: id},
+				thrift.ValueField{ID: 2, Value: thrift.StringValue("alice")},
+			), nil
+		},
+		"touch": func(ctx context.Context, args thrift.Value) (thrift.Value, error) {
+			id, _ := args.Field(1)
+			touched = append(touched, id.Int)
+			return thrift.Value{}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := NewClient(doc, "Users", &processorClient{processor: processor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	user, err := users.CallMap(ctx, "get", map[string]interface{}{"id": 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]interface{}{"id": int64(42), "name": "alice"}; !reflect.DeepEqual(user, expected) {
+		t.Errorf("expected %v, got %v", expected, user)
+	}
+
+	_, err = users.CallMap(ctx, "get", map[string]interface{}{"id": 1})
+	var exc *Exception
+	if !errors.As(err, &exc) || exc.Field != "notFound" {
+		t.Errorf("expected a NotFound exception, got %v", err)
+	}
+
+	for _, c := range []struct {
+		method string
+		args   map[string]interface{}
+		typeID int32
+	}{
+		{"get", map[string]interface{}{"id": 2}, thrift.INTERNAL_ERROR},
+		{"get", map[string]interface{}{"id": 3}, thrift.INTERNAL_ERROR},
+		{"put", map[string]interface{}{"weight": 1}, thrift.UNKNOWN_METHOD},
+	} {
+		_, err := users.CallMap(ctx, c.method, c.args)
+		var appExc thrift.TApplicationException
+		if !errors.As(err, &appExc) || appExc.TypeId() != c.typeID {
+			t.Errorf("%s %v: expected exception type %d, got %v", c.method, c.args, c.typeID, err)
+		}
+	}
+
+	if _, err := users.CallMap(ctx, "ping", nil); err != nil {
+		t.Error(err)
+	}
+	if _, err := users.CallMap(ctx, "touch", map[string]interface{}{"id": 7}); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(touched, []int64{7}) {
+		t.Errorf("expected touch(7), got %v", touched)
+	}
+
+	if _, err := NewProcessor(doc, "Users", map[string]HandlerFunc{"delete": nil}); err == nil {
+		t.Error("expected an error for an unknown function")
+	}
+}
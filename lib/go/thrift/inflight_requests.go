@@ -0,0 +1,127 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// InFlightRequest is a request being processed by a TSimpleServer, see
+// TSimpleServer.InFlightRequests.
+type InFlightRequest struct {
+	// ID identifies the request among the ones of the server, see
+	// TSimpleServer.CancelRequest.
+	ID     int64
+	Method string
+	TypeID TMessageType
+	SeqID  int32
+
+	// Peer is the address of the client, nil if unknown.
+	Peer net.Addr
+
+	// Start is when the processing of the request started, after its
+	// message begin was read and it got through the server limits.
+	Start time.Time
+}
+
+type tInFlightRequest struct {
+	InFlightRequest
+
+	cancel context.CancelFunc
+}
+
+// tRequestTrackingProcessor registers the requests of a connection in the
+// in-flight requests of a TSimpleServer while they're processed.
+type tRequestTrackingProcessor struct {
+	TProcessor
+
+	server *TSimpleServer
+}
+
+func (p *tRequestTrackingProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s := p.server
+	req := &tInFlightRequest{
+		InFlightRequest: InFlightRequest{
+			ID:     atomic.AddInt64(&s.lastRequestID, 1),
+			Method: name,
+			TypeID: typeID,
+			SeqID:  seqID,
+			Start:  time.Now(),
+		},
+		cancel: cancel,
+	}
+	req.Peer, _ = RemoteAddrFromContext(ctx)
+	s.requestsMu.Lock()
+	if s.requests == nil {
+		s.requests = make(map[int64]*tInFlightRequest)
+	}
+	s.requests[req.ID] = req
+	s.requestsMu.Unlock()
+	defer func() {
+		s.requestsMu.Lock()
+		delete(s.requests, req.ID)
+		s.requestsMu.Unlock()
+	}()
+	return p.TProcessor.Process(ctx, NewStoredMessageProtocol(in, name, typeID, seqID), out)
+}
+
+// InFlightRequests returns the requests being processed by the server, oldest
+// first, e.g. to find the handlers that are stuck. It's always empty unless
+// enabled with SetRequestTracking.
+func (p *TSimpleServer) InFlightRequests() []InFlightRequest {
+	p.requestsMu.Lock()
+	requests := make([]InFlightRequest, 0, len(p.requests))
+	for _, req := range p.requests {
+		requests = append(requests, req.InFlightRequest)
+	}
+	p.requestsMu.Unlock()
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].ID < requests[j].ID
+	})
+	return requests
+}
+
+// CancelRequest cancels the context of the in-flight request id, it returns
+// false if the request is not being processed anymore.
+//
+// It's up to the handler to return once its context is done, the client then
+// gets the error returned by the handler like for any other failure.
+func (p *TSimpleServer) CancelRequest(id int64) bool {
+	p.requestsMu.Lock()
+	req, ok := p.requests[id]
+	p.requestsMu.Unlock()
+	if ok {
+		req.cancel()
+	}
+	return ok
+}
+
+var _ TProcessor = (*tRequestTrackingProcessor)(nil)
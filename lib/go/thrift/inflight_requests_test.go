@@ -0,0 +1,105 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// cancelWaitingProcessor replies to every request with an exception once
+// the context of the request is done.
+type cancelWaitingProcessor struct {
+	mockProcessor
+}
+
+func (p *cancelWaitingProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, _, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	if err := in.Skip(ctx, STRUCT); err != nil {
+		return false, WrapTException(err)
+	}
+	in.ReadMessageEnd(ctx)
+	<-ctx.Done()
+	exc := NewTApplicationException(INTERNAL_ERROR, ctx.Err().Error())
+	out.WriteMessageBegin(ctx, name, EXCEPTION, seqID)
+	exc.Write(ctx, out)
+	out.WriteMessageEnd(ctx)
+	return true, WrapTException(out.Flush(ctx))
+}
+
+func TestInFlightRequests(t *testing.T) {
+	serv, addr := startTestSocketServer(t, &cancelWaitingProcessor{}, func(s *TSimpleServer) {
+		s.SetRequestTracking(true)
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	sock := dialTestSocketServer(t, addr)
+	result := make(chan error, 1)
+	go func() {
+		exc, err := callExpectingException(NewTBinaryProtocolConf(sock, nil), 7)
+		if err == nil && exc.TypeId() != INTERNAL_ERROR {
+			t.Errorf("expected INTERNAL_ERROR, got %v", exc)
+		}
+		result <- err
+	}()
+
+	var requests []InFlightRequest
+	deadline := time.Now().Add(5 * time.Second)
+	for len(requests) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		requests = serv.InFlightRequests()
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 in-flight request, got %+v", requests)
+	}
+	req := requests[0]
+	if req.Method != "echo" || req.TypeID != CALL || req.SeqID != 7 {
+		t.Errorf("unexpected in-flight request %+v", req)
+	}
+	if req.Peer == nil || req.Peer.String() != sock.Conn().LocalAddr().String() {
+		t.Errorf("expected peer %v, got %v", sock.Conn().LocalAddr(), req.Peer)
+	}
+	if req.Start.IsZero() {
+		t.Error("expected the start time to be set")
+	}
+
+	if !serv.CancelRequest(req.ID) {
+		t.Fatal("expected the request to be cancelled")
+	}
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	// The request is untracked right after its reply is sent.
+	for len(requests) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		requests = serv.InFlightRequests()
+	}
+	if len(requests) != 0 {
+		t.Errorf("expected no in-flight requests, got %+v", requests)
+	}
+	if serv.CancelRequest(req.ID) {
+		t.Error("expected a done request not to be cancelled")
+	}
+}
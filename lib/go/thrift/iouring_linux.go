@@ -0,0 +1,685 @@
+//go:build linux && thrift_iouring
+// +build linux,thrift_iouring
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The io_uring support is EXPERIMENTAL, and only built when the
+// thrift_iouring build tag is set:
+//
+//	go build -tags thrift_iouring
+//
+// It requires Linux 5.6 or later (for IORING_OP_SEND/IORING_OP_RECV).
+
+// io_uring syscall numbers, they are the same on all architectures.
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+)
+
+// io_uring constants from linux/io_uring.h.
+const (
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringEnterGetEvents = 1 << 0
+
+	ioringOpNop         = 0
+	ioringOpPollAdd     = 6
+	ioringOpAsyncCancel = 14
+	ioringOpSend        = 26
+	ioringOpRecv        = 27
+
+	ioringPollIn  = 0x1
+	ioringPollOut = 0x4
+
+	// DefaultIOUringEntries is the default submission queue size used by
+	// NewTIOUringServerSocket.
+	DefaultIOUringEntries = 256
+)
+
+// ioUringWakeup is the user data of the NOP submitted by Close to wake up
+// the completion reaper.
+const ioUringWakeup = ^uint64(0)
+
+type ioSQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+type ioCQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        ioSQRingOffsets
+	cqOff        ioCQRingOffsets
+}
+
+type ioUringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	pad         [2]uint64
+}
+
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type ioUringRequest struct {
+	sqe ioUringSQE
+	// wakeup marks the last request, submitted by Close.
+	wakeup bool
+}
+
+// TIOUring is a shared io_uring instance used by TIOUringSockets.
+//
+// All the reads and writes from all the sockets sharing the same TIOUring are
+// batched into as few io_uring_enter syscalls as possible, which reduces the
+// syscall overhead on servers with a lot of concurrent connections.
+//
+// It's safe for concurrent use.
+type TIOUring struct {
+	fd     int
+	params ioUringParams
+
+	sqRing []byte
+	cqRing []byte
+	sqeMem []byte
+
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray []uint32
+	sqes    []ioUringSQE
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []ioUringCQE
+
+	requests chan ioUringRequest
+	inflight chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	nextID  uint64
+	pending map[uint64]chan int32
+	wg      sync.WaitGroup
+}
+
+// NewTIOUring sets up a new io_uring with the given submission queue size.
+//
+// It returns an error if io_uring is not supported by the running kernel, or
+// disabled by seccomp/sysctl.
+func NewTIOUring(entries uint32) (*TIOUring, error) {
+	if entries == 0 {
+		entries = DefaultIOUringEntries
+	}
+	r := &TIOUring{
+		pending: make(map[uint64]chan int32),
+	}
+	fd, _, errno := syscall.Syscall(
+		sysIOUringSetup,
+		uintptr(entries),
+		uintptr(unsafe.Pointer(&r.params)),
+		0,
+	)
+	if errno != 0 {
+		return nil, NewTTransportExceptionFromError(errno)
+	}
+	r.fd = int(fd)
+	if err := r.mmap(); err != nil {
+		r.unmap()
+		syscall.Close(r.fd)
+		return nil, NewTTransportExceptionFromError(err)
+	}
+	r.requests = make(chan ioUringRequest, r.params.sqEntries)
+	r.inflight = make(chan struct{}, r.params.cqEntries)
+	r.wg.Add(2)
+	go r.submitLoop()
+	go r.reapLoop()
+	return r, nil
+}
+
+func (r *TIOUring) mmap() (err error) {
+	p := &r.params
+	const prot = syscall.PROT_READ | syscall.PROT_WRITE
+	const flags = syscall.MAP_SHARED | syscall.MAP_POPULATE
+
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	if r.sqRing, err = syscall.Mmap(r.fd, ioringOffSQRing, sqSize, prot, flags); err != nil {
+		return err
+	}
+	cqSize := int(p.cqOff.cqes) + int(p.cqEntries)*int(unsafe.Sizeof(ioUringCQE{}))
+	if r.cqRing, err = syscall.Mmap(r.fd, ioringOffCQRing, cqSize, prot, flags); err != nil {
+		return err
+	}
+	sqeSize := int(p.sqEntries) * int(unsafe.Sizeof(ioUringSQE{}))
+	if r.sqeMem, err = syscall.Mmap(r.fd, ioringOffSQEs, sqeSize, prot, flags); err != nil {
+		return err
+	}
+
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = (*[1 << 20]uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array]))[:p.sqEntries:p.sqEntries]
+	r.sqes = (*[1 << 16]ioUringSQE)(unsafe.Pointer(&r.sqeMem[0]))[:p.sqEntries:p.sqEntries]
+
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = (*[1 << 20]ioUringCQE)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes]))[:p.cqEntries:p.cqEntries]
+	return nil
+}
+
+func (r *TIOUring) unmap() {
+	for _, mem := range [][]byte{r.sqRing, r.cqRing, r.sqeMem} {
+		if mem != nil {
+			syscall.Munmap(mem)
+		}
+	}
+	r.sqRing, r.cqRing, r.sqeMem = nil, nil, nil
+}
+
+func (r *TIOUring) enter(toSubmit, minComplete, flags uint32) error {
+	for {
+		_, _, errno := syscall.Syscall6(
+			sysIOUringEnter,
+			uintptr(r.fd),
+			uintptr(toSubmit),
+			uintptr(minComplete),
+			uintptr(flags),
+			0,
+			0,
+		)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+}
+
+// submitLoop is the only goroutine writing to the submission queue.
+//
+// It batches all the requests queued at the time into a single
+// io_uring_enter call.
+func (r *TIOUring) submitLoop() {
+	defer r.wg.Done()
+	batch := make([]ioUringRequest, 0, r.params.sqEntries)
+	for {
+		batch = append(batch[:0], <-r.requests)
+	drain:
+		for len(batch) < cap(batch) {
+			select {
+			case req := <-r.requests:
+				batch = append(batch, req)
+			default:
+				break drain
+			}
+		}
+
+		tail := *r.sqTail
+		wakeup := false
+		for _, req := range batch {
+			idx := tail & r.sqMask
+			r.sqes[idx] = req.sqe
+			r.sqArray[idx] = idx
+			tail++
+			wakeup = wakeup || req.wakeup
+		}
+		atomic.StoreUint32(r.sqTail, tail)
+		if err := r.enter(uint32(len(batch)), 0, 0); err != nil {
+			for _, req := range batch {
+				r.complete(req.sqe.userData, -int32(errnoOf(err)))
+			}
+		}
+		if wakeup {
+			return
+		}
+	}
+}
+
+// reapLoop is the only goroutine reading from the completion queue.
+func (r *TIOUring) reapLoop() {
+	defer r.wg.Done()
+	for {
+		if err := r.enter(0, 1, ioringEnterGetEvents); err != nil && err != syscall.EBUSY {
+			r.failPending(err)
+			return
+		}
+		head := *r.cqHead
+		tail := atomic.LoadUint32(r.cqTail)
+		done := false
+		for ; head != tail; head++ {
+			cqe := r.cqes[head&r.cqMask]
+			if cqe.userData == ioUringWakeup {
+				done = true
+				continue
+			}
+			r.complete(cqe.userData, cqe.res)
+		}
+		atomic.StoreUint32(r.cqHead, head)
+		if done {
+			r.failPending(syscall.ECANCELED)
+			return
+		}
+	}
+}
+
+func errnoOf(err error) syscall.Errno {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	return syscall.EIO
+}
+
+func (r *TIOUring) complete(id uint64, res int32) {
+	r.mu.Lock()
+	ch, ok := r.pending[id]
+	delete(r.pending, id)
+	r.mu.Unlock()
+	if ok {
+		<-r.inflight
+		ch <- res
+	}
+}
+
+func (r *TIOUring) failPending(err error) {
+	res := -int32(errnoOf(err))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, ch := range r.pending {
+		delete(r.pending, id)
+		ch <- res
+	}
+}
+
+// do submits sqe and waits for its completion.
+//
+// If timeout > 0 and it expires before the completion, the request is
+// cancelled and a TIMED_OUT TTransportException is returned.
+func (r *TIOUring) do(sqe ioUringSQE, timeout time.Duration) (int32, error) {
+	ch := make(chan int32, 1)
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return 0, NewTTransportException(NOT_OPEN, "io_uring closed")
+	}
+	r.nextID++
+	id := r.nextID
+	r.pending[id] = ch
+	r.mu.Unlock()
+
+	r.inflight <- struct{}{}
+	sqe.userData = id
+	r.requests <- ioUringRequest{sqe: sqe}
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	var res int32
+	select {
+	case res = <-ch:
+	case <-timer:
+		r.cancel(id)
+		res = <-ch
+		if res == -int32(syscall.ECANCELED) || res == -int32(syscall.EINTR) {
+			return 0, NewTTransportException(TIMED_OUT, "io_uring request timed out")
+		}
+	}
+	if res < 0 {
+		return 0, NewTTransportExceptionFromError(syscall.Errno(-res))
+	}
+	return res, nil
+}
+
+// cancel asks the kernel to cancel the in-flight request with the given id.
+//
+// The completion of the cancel request itself is ignored.
+func (r *TIOUring) cancel(id uint64) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.nextID++
+	cancelID := r.nextID
+	r.pending[cancelID] = make(chan int32, 1)
+	r.mu.Unlock()
+
+	r.inflight <- struct{}{}
+	r.requests <- ioUringRequest{sqe: ioUringSQE{
+		opcode:   ioringOpAsyncCancel,
+		fd:       -1,
+		addr:     id,
+		userData: cancelID,
+	}}
+}
+
+// Close stops the io_uring after failing all the in-flight requests.
+func (r *TIOUring) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.mu.Unlock()
+
+	r.requests <- ioUringRequest{
+		sqe: ioUringSQE{
+			opcode:   ioringOpNop,
+			fd:       -1,
+			userData: ioUringWakeup,
+		},
+		wakeup: true,
+	}
+	r.wg.Wait()
+	r.unmap()
+	return syscall.Close(r.fd)
+}
+
+// TIOUringSocket is a TTransport doing all its reads and writes through a
+// shared TIOUring instead of the go runtime's netpoller.
+//
+// The socket timeout from TConfiguration applies to every single read and
+// write.
+//
+// Like TSocket, it's not safe for concurrent use.
+type TIOUringSocket struct {
+	conn net.Conn
+	fd   int
+	ring *TIOUring
+	cfg  *TConfiguration
+
+	// The kernel reads from/writes into these buffers asynchronously, so they
+	// must be heap allocated and owned by the socket.
+	readBuf  []byte
+	writeBuf []byte
+}
+
+// ioUringSocketBufferSize is the size of the per socket read and write
+// buffers handed to the kernel.
+const ioUringSocketBufferSize = 64 * 1024
+
+// NewTIOUringSocketFromConnConf creates a TIOUringSocket from an existing
+// connection, which must be backed by a file descriptor (e.g. *net.TCPConn or
+// *net.UnixConn).
+//
+// The TIOUringSocket takes the ownership of conn, but not the ownership of
+// ring, which should be closed separately after all its sockets are closed.
+func NewTIOUringSocketFromConnConf(ring *TIOUring, conn net.Conn, conf *TConfiguration) (*TIOUringSocket, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, NewTTransportException(NOT_OPEN, "connection is not backed by a file descriptor")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, NewTTransportExceptionFromError(err)
+	}
+	var fd int
+	if err := rc.Control(func(f uintptr) {
+		fd = int(f)
+	}); err != nil {
+		return nil, NewTTransportExceptionFromError(err)
+	}
+	return &TIOUringSocket{
+		conn:     conn,
+		fd:       fd,
+		ring:     ring,
+		cfg:      conf,
+		readBuf:  make([]byte, ioUringSocketBufferSize),
+		writeBuf: make([]byte, ioUringSocketBufferSize),
+	}, nil
+}
+
+// SetTConfiguration implements TConfigurationSetter.
+func (p *TIOUringSocket) SetTConfiguration(conf *TConfiguration) {
+	p.cfg = conf
+}
+
+// Conn returns the underlying net.Conn.
+func (p *TIOUringSocket) Conn() net.Conn {
+	return p.conn
+}
+
+// Open always returns ALREADY_OPEN, as TIOUringSocket can only be created
+// from an established connection.
+func (p *TIOUringSocket) Open() error {
+	return NewTTransportException(ALREADY_OPEN, "io_uring socket already connected")
+}
+
+func (p *TIOUringSocket) IsOpen() bool {
+	return p.conn != nil
+}
+
+func (p *TIOUringSocket) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// poll waits until the socket is readable (or writable) for sockets in
+// non-blocking mode, on which the kernel may complete recv/send with EAGAIN.
+func (p *TIOUringSocket) poll(events uint32) error {
+	_, err := p.ring.do(ioUringSQE{
+		opcode:  ioringOpPollAdd,
+		fd:      int32(p.fd),
+		opFlags: events,
+	}, p.cfg.GetSocketTimeout())
+	return err
+}
+
+func (p *TIOUringSocket) Read(buf []byte) (int, error) {
+	if p.conn == nil {
+		return 0, NewTTransportException(NOT_OPEN, "Connection not open")
+	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	size := len(buf)
+	if size > len(p.readBuf) {
+		size = len(p.readBuf)
+	}
+	for {
+		n, err := p.ring.do(ioUringSQE{
+			opcode: ioringOpRecv,
+			fd:     int32(p.fd),
+			addr:   uint64(uintptr(unsafe.Pointer(&p.readBuf[0]))),
+			len:    uint32(size),
+		}, p.cfg.GetSocketTimeout())
+		if errors.Is(err, syscall.EAGAIN) {
+			if err := p.poll(ioringPollIn); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, NewTTransportException(END_OF_FILE, "EOF")
+		}
+		return copy(buf, p.readBuf[:n]), nil
+	}
+}
+
+func (p *TIOUringSocket) Write(buf []byte) (int, error) {
+	if p.conn == nil {
+		return 0, NewTTransportException(NOT_OPEN, "Connection not open")
+	}
+	written := 0
+	for written < len(buf) {
+		size := copy(p.writeBuf, buf[written:])
+		sent := 0
+		for sent < size {
+			n, err := p.ring.do(ioUringSQE{
+				opcode:  ioringOpSend,
+				fd:      int32(p.fd),
+				addr:    uint64(uintptr(unsafe.Pointer(&p.writeBuf[sent]))),
+				len:     uint32(size - sent),
+				opFlags: uint32(syscall.MSG_NOSIGNAL),
+			}, p.cfg.GetSocketTimeout())
+			if errors.Is(err, syscall.EAGAIN) {
+				if err := p.poll(ioringPollOut); err != nil {
+					return written + sent, err
+				}
+				continue
+			}
+			if err != nil {
+				return written + sent, err
+			}
+			sent += int(n)
+		}
+		written += size
+	}
+	return written, nil
+}
+
+func (p *TIOUringSocket) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (p *TIOUringSocket) RemainingBytes() (num_bytes uint64) {
+	const maxSize = ^uint64(0)
+	return maxSize // the truth is, we just don't know unless framed is used
+}
+
+// TIOUringServerSocket is a TServerTransport accepting connections as
+// TIOUringSockets sharing the same TIOUring.
+type TIOUringServerSocket struct {
+	*TServerSocket
+
+	ring *TIOUring
+	cfg  *TConfiguration
+}
+
+// NewTIOUringServerSocket creates a new TIOUringServerSocket listening on the
+// given address, with its own TIOUring of the given size (0 means
+// DefaultIOUringEntries).
+func NewTIOUringServerSocket(listenAddr string, entries uint32, conf *TConfiguration) (*TIOUringServerSocket, error) {
+	socket, err := NewTServerSocket(listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	ring, err := NewTIOUring(entries)
+	if err != nil {
+		return nil, err
+	}
+	return &TIOUringServerSocket{
+		TServerSocket: socket,
+		ring:          ring,
+		cfg:           conf,
+	}, nil
+}
+
+// Ring returns the TIOUring shared by all the accepted connections.
+func (p *TIOUringServerSocket) Ring() *TIOUring {
+	return p.ring
+}
+
+func (p *TIOUringServerSocket) Accept() (TTransport, error) {
+	trans, err := p.TServerSocket.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conn := trans.(*TSocket).Conn().(*socketConn).Conn
+	socket, err := NewTIOUringSocketFromConnConf(p.ring, conn, p.cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return socket, nil
+}
+
+// Close closes the listener, the TIOUring is closed by Interrupt.
+func (p *TIOUringServerSocket) Close() error {
+	return p.TServerSocket.Close()
+}
+
+// Interrupt interrupts the listener and closes the shared TIOUring.
+func (p *TIOUringServerSocket) Interrupt() error {
+	err := p.TServerSocket.Interrupt()
+	p.ring.Close()
+	return err
+}
+
+var (
+	_ TTransport           = (*TIOUringSocket)(nil)
+	_ TConfigurationSetter = (*TIOUringSocket)(nil)
+	_ TServerTransport     = (*TIOUringServerSocket)(nil)
+)
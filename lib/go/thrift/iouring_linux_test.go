@@ -0,0 +1,187 @@
+//go:build linux && thrift_iouring
+// +build linux,thrift_iouring
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestIOUring(tb testing.TB) *TIOUring {
+	tb.Helper()
+	ring, err := NewTIOUring(64)
+	if err != nil {
+		tb.Skipf("io_uring not available: %v", err)
+	}
+	tb.Cleanup(func() {
+		ring.Close()
+	})
+	return ring
+}
+
+// tcpConnPair returns both ends of a loopback TCP connection.
+func tcpConnPair(tb testing.TB) (client, server net.Conn) {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server = <-accepted
+	if server == nil {
+		tb.Fatal("accept failed")
+	}
+	return client, server
+}
+
+func TestIOUringSocketReadWrite(t *testing.T) {
+	ring := newTestIOUring(t)
+	clientConn, serverConn := tcpConnPair(t)
+	client, err := NewTIOUringSocketFromConnConf(ring, clientConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := NewTIOUringSocketFromConnConf(ring, serverConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// Bigger than the internal buffers to exercise the chunking.
+	payload := bytes.Repeat([]byte("thrift"), ioUringSocketBufferSize/3)
+	go func() {
+		if _, err := client.Write(payload); err != nil {
+			t.Error(err)
+		}
+	}()
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("payload mismatch")
+	}
+}
+
+func TestIOUringSocketTimeout(t *testing.T) {
+	ring := newTestIOUring(t)
+	clientConn, serverConn := tcpConnPair(t)
+	defer clientConn.Close()
+	server, err := NewTIOUringSocketFromConnConf(ring, serverConn, &TConfiguration{
+		SocketTimeout: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	_, err = server.Read(make([]byte, 1))
+	if !isTimeoutError(err) {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestIOUringSocketEOF(t *testing.T) {
+	ring := newTestIOUring(t)
+	clientConn, serverConn := tcpConnPair(t)
+	server, err := NewTIOUringSocketFromConnConf(ring, serverConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	clientConn.Close()
+
+	_, err = server.Read(make([]byte, 1))
+	if treatEOFErrorsAsNil(err) != nil {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+// benchmarkRoundTrip measures 1KiB echo round trips, it closes both client and
+// server.
+func benchmarkRoundTrip(b *testing.B, client, server TTransport) {
+	request := bytes.Repeat([]byte{'x'}, 1024)
+	done := make(chan struct{})
+	defer func() {
+		client.Close()
+		<-done
+		server.Close()
+	}()
+	go func() {
+		defer close(done)
+		buf := make([]byte, len(request))
+		for {
+			if _, err := io.ReadFull(server, buf); err != nil {
+				return
+			}
+			if _, err := server.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+	response := make([]byte, len(request))
+	b.SetBytes(int64(len(request)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Write(request); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(client, response); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTSocketRoundTrip(b *testing.B) {
+	clientConn, serverConn := tcpConnPair(b)
+	client := NewTSocketFromConnConf(clientConn, nil)
+	server := NewTSocketFromConnConf(serverConn, nil)
+	benchmarkRoundTrip(b, client, server)
+}
+
+func BenchmarkIOUringSocketRoundTrip(b *testing.B) {
+	ring := newTestIOUring(b)
+	clientConn, serverConn := tcpConnPair(b)
+	client, err := NewTIOUringSocketFromConnConf(ring, clientConn, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	server, err := NewTIOUringSocketFromConnConf(ring, serverConn, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkRoundTrip(b, client, server)
+}
@@ -221,6 +221,7 @@ func (p *TJSONProtocol) WriteBinary(ctx context.Context, v []byte) error {
 // Reading methods.
 func (p *TJSONProtocol) ReadMessageBegin(ctx context.Context) (name string, typeId TMessageType, seqId int32, err error) {
 	p.resetContextStack() // THRIFT-3735
+	p.limits.reset()
 	if isNull, err := p.ParseListBegin(); isNull || err != nil {
 		return name, typeId, seqId, err
 	}
@@ -233,7 +234,7 @@ func (p *TJSONProtocol) ReadMessageBegin(ctx context.Context) (name string, type
 		return name, typeId, seqId, NewTProtocolExceptionWithType(INVALID_DATA, e)
 
 	}
-	if name, err = p.ReadString(ctx); err != nil {
+	if name, err = p.readString(); err != nil {
 		return name, typeId, seqId, err
 	}
 	bTypeId, err := p.ReadByte(ctx)
@@ -253,11 +254,13 @@ func (p *TJSONProtocol) ReadMessageEnd(ctx context.Context) error {
 }
 
 func (p *TJSONProtocol) ReadStructBegin(ctx context.Context) (name string, err error) {
+	p.limits.structBegin()
 	_, err = p.ParseObjectStart()
 	return "", err
 }
 
 func (p *TJSONProtocol) ReadStructEnd(ctx context.Context) error {
+	p.limits.structEnd()
 	return p.ParseObjectEnd()
 }
 
@@ -273,7 +276,7 @@ func (p *TJSONProtocol) ReadFieldBegin(ctx context.Context) (string, TType, int1
 	if _, err = p.ParseObjectStart(); err != nil {
 		return "", STOP, fieldId, err
 	}
-	sType, err := p.ReadString(ctx)
+	sType, err := p.readString()
 	if err != nil {
 		return "", STOP, fieldId, err
 	}
@@ -291,7 +294,7 @@ func (p *TJSONProtocol) ReadMapBegin(ctx context.Context) (keyType TType, valueT
 	}
 
 	// read keyType
-	sKeyType, e := p.ReadString(ctx)
+	sKeyType, e := p.readString()
 	if e != nil {
 		return keyType, valueType, size, e
 	}
@@ -301,7 +304,7 @@ func (p *TJSONProtocol) ReadMapBegin(ctx context.Context) (keyType TType, valueT
 	}
 
 	// read valueType
-	sValueType, e := p.ReadString(ctx)
+	sValueType, e := p.readString()
 	if e != nil {
 		return keyType, valueType, size, e
 	}
@@ -319,13 +322,17 @@ func (p *TJSONProtocol) ReadMapBegin(ctx context.Context) (keyType TType, valueT
 	if err != nil {
 		return keyType, valueType, 0, err
 	}
-	size = int(iSize)
-
-	_, e = p.ParseObjectStart()
+	if _, e = p.ParseObjectStart(); e != nil {
+		return keyType, valueType, size, e
+	}
+	size, e = p.limits.containerBegin(ctx, p.cfg, MAP, keyType, valueType, int(iSize))
 	return keyType, valueType, size, e
 }
 
 func (p *TJSONProtocol) ReadMapEnd(ctx context.Context) error {
+	if e := p.limits.containerEnd(ctx, p); e != nil {
+		return e
+	}
 	e := p.ParseObjectEnd()
 	if e != nil {
 		return e
@@ -334,18 +341,34 @@ func (p *TJSONProtocol) ReadMapEnd(ctx context.Context) error {
 }
 
 func (p *TJSONProtocol) ReadListBegin(ctx context.Context) (elemType TType, size int, e error) {
-	return p.ParseElemListBegin()
+	elemType, size, e = p.ParseElemListBegin()
+	if e != nil {
+		return elemType, size, e
+	}
+	size, e = p.limits.containerBegin(ctx, p.cfg, LIST, STOP, elemType, size)
+	return elemType, size, e
 }
 
 func (p *TJSONProtocol) ReadListEnd(ctx context.Context) error {
+	if e := p.limits.containerEnd(ctx, p); e != nil {
+		return e
+	}
 	return p.ParseListEnd()
 }
 
 func (p *TJSONProtocol) ReadSetBegin(ctx context.Context) (elemType TType, size int, e error) {
-	return p.ParseElemListBegin()
+	elemType, size, e = p.ParseElemListBegin()
+	if e != nil {
+		return elemType, size, e
+	}
+	size, e = p.limits.containerBegin(ctx, p.cfg, SET, STOP, elemType, size)
+	return elemType, size, e
 }
 
 func (p *TJSONProtocol) ReadSetEnd(ctx context.Context) error {
+	if e := p.limits.containerEnd(ctx, p); e != nil {
+		return e
+	}
 	return p.ParseListEnd()
 }
 
@@ -380,6 +403,14 @@ func (p *TJSONProtocol) ReadDouble(ctx context.Context) (float64, error) {
 }
 
 func (p *TJSONProtocol) ReadString(ctx context.Context) (string, error) {
+	v, err := p.readString()
+	if err != nil {
+		return v, err
+	}
+	return p.limits.limitString(ctx, p.cfg, v)
+}
+
+func (p *TJSONProtocol) readString() (string, error) {
 	var v string
 	if err := p.ParsePreValue(); err != nil {
 		return v, err
@@ -410,6 +441,14 @@ func (p *TJSONProtocol) ReadString(ctx context.Context) (string, error) {
 }
 
 func (p *TJSONProtocol) ReadBinary(ctx context.Context) ([]byte, error) {
+	v, err := p.readBinary()
+	if err != nil {
+		return v, err
+	}
+	return p.limits.limitBinary(ctx, p.cfg, v)
+}
+
+func (p *TJSONProtocol) readBinary() ([]byte, error) {
 	var v []byte
 	if err := p.ParsePreValue(); err != nil {
 		return nil, err
@@ -477,11 +516,7 @@ func (p *TJSONProtocol) ParseElemListBegin() (elemType TType, size int, e error)
 	if isNull, e := p.ParseListBegin(); isNull || e != nil {
 		return VOID, 0, e
 	}
-	// We don't really use the ctx in ReadString implementation,
-	// so this is safe for now.
-	// We might want to add context to ParseElemListBegin if we start to use
-	// ctx in ReadString implementation in the future.
-	sElemType, err := p.ReadString(context.Background())
+	sElemType, err := p.readString()
 	if err != nil {
 		return VOID, size, err
 	}
@@ -505,11 +540,7 @@ func (p *TJSONProtocol) readElemListBegin() (elemType TType, size int, e error)
 	if isNull, e := p.ParseListBegin(); isNull || e != nil {
 		return VOID, 0, e
 	}
-	// We don't really use the ctx in ReadString implementation,
-	// so this is safe for now.
-	// We might want to add context to ParseElemListBegin if we start to use
-	// ctx in ReadString implementation in the future.
-	sElemType, err := p.ReadString(context.Background())
+	sElemType, err := p.readString()
 	if err != nil {
 		return VOID, size, err
 	}
@@ -0,0 +1,256 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// jsonSchemaDialect is the $schema of the documents of JSONSchema.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema returns the JSON Schema document of the JSON objects written by
+// MarshalStructJSON for v, a generated struct or a struct with thrift tags, or
+// a pointer to one, possibly nil, e.g. for the HTTP gateways and the
+// validation of the JSON inputs:
+//
+//	schema, err := thrift.JSONSchema((*User)(nil))
+//
+// The structs are defined in $defs, keyed by the names of their Go types, the
+// root one being the $ref of the document. Like MarshalStructJSON:
+//
+//   - the required fields are required, and the unions have exactly one field,
+//   - the integers are bounded by the range of their type, the doubles may be
+//     the strings "NaN", "Infinity" and "-Infinity" too, and the binaries are
+//     base64 strings,
+//   - the sets are arrays of unique items, and the maps objects, whose
+//     property names are constrained for the enum and integer keys,
+//   - the enums are the names of their values, as listed by the Values method
+//     of the generated enums. The enums without one, generated by an older
+//     compiler, may be any string or integer.
+func JSONSchema(v interface{}) ([]byte, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot describe %T as a struct", v)
+	}
+	s := &tJSONSchema{
+		defs:  make(map[string]interface{}),
+		names: make(map[reflect.Type]string),
+	}
+	ref, err := s.structRef(t)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"$schema": jsonSchemaDialect,
+		"$ref":    ref["$ref"],
+		"$defs":   s.defs,
+	})
+}
+
+// tJSONSchema collects the definitions of the structs of a JSON Schema.
+type tJSONSchema struct {
+	defs  map[string]interface{}
+	names map[reflect.Type]string
+}
+
+// structRef returns the reference to the definition of the struct type t,
+// defining it first if needed.
+func (s *tJSONSchema) structRef(t reflect.Type) (map[string]interface{}, error) {
+	name, ok := s.names[t]
+	if !ok {
+		base := t.Name()
+		if base == "" {
+			base = "struct"
+		}
+		name = base
+		for i := 2; ; i++ {
+			if _, taken := s.defs[name]; !taken {
+				break
+			}
+			name = fmt.Sprintf("%s%d", base, i)
+		}
+		// The name is reserved before the fields are described, for the
+		// recursive structs.
+		s.names[t] = name
+		s.defs[name] = nil
+		def, err := s.structSchema(t)
+		if err != nil {
+			return nil, err
+		}
+		s.defs[name] = def
+	}
+	return map[string]interface{}{"$ref": "#/$defs/" + name}, nil
+}
+
+func (s *tJSONSchema) structSchema(t reflect.Type) (map[string]interface{}, error) {
+	info, err := reflectStructInfo(t)
+	if err != nil {
+		return nil, err
+	}
+	properties := make(map[string]interface{}, len(info.fields))
+	var required []string
+	for _, f := range info.fields {
+		schema, err := s.valueSchema(t.Field(f.index).Type, f.set)
+		if err != nil {
+			return nil, PrependError(fmt.Sprintf("%s.%s: ", info.name, f.name), err)
+		}
+		properties[f.name] = schema
+		if f.required {
+			required = append(required, f.name)
+		}
+	}
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if isUnion(t) {
+		schema["minProperties"] = 1
+		schema["maxProperties"] = 1
+	}
+	return schema, nil
+}
+
+// valueSchema returns the schema of the values of the Go type t, a set
+// instead of a list if set is true.
+func (s *tJSONSchema) valueSchema(t reflect.Type, set bool) (map[string]interface{}, error) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.valueSchema(t.Elem(), set)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Int8:
+		return jsonSchemaInteger(math.MinInt8, math.MaxInt8), nil
+	case reflect.Uint8:
+		return jsonSchemaInteger(0, math.MaxUint8), nil
+	case reflect.Int16:
+		return jsonSchemaInteger(math.MinInt16, math.MaxInt16), nil
+	case reflect.Int32:
+		return jsonSchemaInteger(math.MinInt32, math.MaxInt32), nil
+	case reflect.Int64, reflect.Int:
+		if isReflectEnum(t) {
+			names, ok := jsonSchemaEnumNames(t)
+			if !ok {
+				return map[string]interface{}{"type": []string{"string", "integer"}}, nil
+			}
+			return map[string]interface{}{"enum": names}, nil
+		}
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{
+			"anyOf": []interface{}{
+				map[string]interface{}{"type": "number"},
+				map[string]interface{}{"enum": []string{"NaN", "Infinity", "-Infinity"}},
+			},
+		}, nil
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}, nil
+		}
+		items, err := s.valueSchema(t.Elem(), false)
+		if err != nil {
+			return nil, err
+		}
+		schema := map[string]interface{}{"type": "array", "items": items}
+		if set {
+			schema["uniqueItems"] = true
+		}
+		return schema, nil
+	case reflect.Map:
+		if isJSONSet(t) {
+			items, err := s.valueSchema(t.Key(), false)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"type": "array", "items": items, "uniqueItems": true}, nil
+		}
+		if _, err := reflectTType(t.Key(), false); err != nil {
+			return nil, err
+		}
+		values, err := s.valueSchema(t.Elem(), false)
+		if err != nil {
+			return nil, err
+		}
+		schema := map[string]interface{}{"type": "object", "additionalProperties": values}
+		if names := jsonSchemaKeyNames(t.Key()); names != nil {
+			schema["propertyNames"] = names
+		}
+		return schema, nil
+	case reflect.Struct:
+		return s.structRef(t)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+func jsonSchemaInteger(min, max int64) map[string]interface{} {
+	return map[string]interface{}{"type": "integer", "minimum": min, "maximum": max}
+}
+
+// jsonSchemaKeyNames returns the schema of the JSON object keys of the map
+// keys of type t, nil if they may be any string.
+func jsonSchemaKeyNames(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"enum": []string{"true", "false"}}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int, reflect.Uint8:
+		if isReflectEnum(t) {
+			if names, ok := jsonSchemaEnumNames(t); ok {
+				return map[string]interface{}{"enum": names}
+			}
+			return nil
+		}
+		return map[string]interface{}{"pattern": "^-?[0-9]+$"}
+	}
+	return nil
+}
+
+// jsonSchemaEnumNames returns the names of the values of the enum type t,
+// listed by its Values method, false if it has none.
+func jsonSchemaEnumNames(t reflect.Type) ([]string, bool) {
+	m, ok := t.MethodByName("Values")
+	if !ok || m.Type.NumIn() != 1 || m.Type.NumOut() != 1 || m.Type.Out(0) != reflect.SliceOf(t) {
+		return nil, false
+	}
+	values := m.Func.Call([]reflect.Value{reflect.Zero(t)})[0]
+	names := make([]string, 0, values.Len())
+	for i := 0; i < values.Len(); i++ {
+		name, err := jsonScalar(values.Index(i))
+		if err != nil {
+			return nil, false
+		}
+		names = append(names, name)
+	}
+	return names, true
+}
@@ -0,0 +1,113 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type jsonSchemaTestNode struct {
+	Value    string                `thrift:"value,1,required"`
+	Children []*jsonSchemaTestNode `thrift:"children,2,set"`
+	Ranks    map[int16]int8        `thrift:"ranks,3"`
+}
+
+// jsonSchemaTestLegacyEnum is an enum generated without a Values method.
+type jsonSchemaTestLegacyEnum int64
+
+func (p jsonSchemaTestLegacyEnum) MarshalText() ([]byte, error) {
+	return []byte("<UNSET>"), nil
+}
+
+func TestJSONSchema(t *testing.T) {
+	data, err := JSONSchema((*jsonSchemaTestNode)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"$defs":{"jsonSchemaTestNode":{"properties":{` +
+		`"children":{"items":{"$ref":"#/$defs/jsonSchemaTestNode"},"type":"array","uniqueItems":true},` +
+		`"ranks":{"additionalProperties":{"maximum":127,"minimum":-128,"type":"integer"},"propertyNames":{"pattern":"^-?[0-9]+$"},"type":"object"},` +
+		`"value":{"type":"string"}},"required":["value"],"type":"object"}},` +
+		`"$ref":"#/$defs/jsonSchemaTestNode","$schema":"https://json-schema.org/draft/2020-12/schema"}`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+
+	data, err = JSONSchema(JSONTestUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Ref  string `json:"$ref"`
+		Defs map[string]struct {
+			Properties    map[string]json.RawMessage `json:"properties"`
+			Required      []string                   `json:"required"`
+			MinProperties int                        `json:"minProperties"`
+			MaxProperties int                        `json:"maxProperties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	user, login := schema.Defs["JSONTestUser"], schema.Defs["JSONTestLogin"]
+	if schema.Ref != "#/$defs/JSONTestUser" || !reflect.DeepEqual(user.Required, []string{"id"}) {
+		t.Errorf("unexpected root %s, required %v", schema.Ref, user.Required)
+	}
+	if login.MinProperties != 1 || login.MaxProperties != 1 {
+		t.Errorf("expected a union of exactly one property, got %d to %d", login.MinProperties, login.MaxProperties)
+	}
+	for name, expected := range map[string]string{
+		"id":      `{"type":"integer"}`,
+		"status":  `{"enum":["ACTIVE","BANNED"]}`,
+		"weights": `{"additionalProperties":{"anyOf":[{"type":"number"},{"enum":["NaN","Infinity","-Infinity"]}]},"propertyNames":{"enum":["ACTIVE","BANNED"]},"type":"object"}`,
+		"avatar":  `{"contentEncoding":"base64","type":"string"}`,
+		"login":   `{"$ref":"#/$defs/JSONTestLogin"}`,
+		"tags":    `{"items":{"type":"string"},"type":"array","uniqueItems":true}`,
+		"count":   `{"maximum":2147483647,"minimum":-2147483648,"type":"integer"}`,
+	} {
+		if got := string(user.Properties[name]); got != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, got)
+		}
+	}
+
+	// The enums without a Values method may be any string or integer.
+	data, err = JSONSchema(&struct {
+		E jsonSchemaTestLegacyEnum `thrift:"e,1"`
+	}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(schema.Defs["struct"].Properties["e"]); got != `{"type":["string","integer"]}` {
+		t.Errorf("expected any string or integer, got %s", got)
+	}
+
+	for _, v := range []interface{}{nil, 42, struct {
+		C complex64 `thrift:"c,1"`
+	}{}} {
+		if _, err := JSONSchema(v); err == nil {
+			t.Errorf("%T: expected an error", v)
+		}
+	}
+}
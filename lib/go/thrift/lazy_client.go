@@ -0,0 +1,142 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"sync"
+)
+
+// errLazyClientClosed is returned by the calls of a closed TLazyClient.
+var errLazyClientClosed = NewTTransportException(NOT_OPEN, "client closed")
+
+// TLazyClient is a TClient connecting to its server on its first call instead
+// of when it's created, so that the clients of all the dependencies of a
+// program can be built at startup without waiting for them, or failing when
+// some of them are unreachable.
+//
+// The connection is dialed by the first call, under its context, and dialed
+// again by the next call once a call failed with a transport or protocol
+// error, or its context was done. The calls are serialized, as with
+// TSynchronizedClient, so it's safe for concurrent use.
+type TLazyClient struct {
+	dial            func(ctx context.Context) (TTransport, error)
+	protocolFactory TProtocolFactory
+	sem             chan struct{}
+
+	mu     sync.Mutex
+	trans  TTransport
+	client *TStandardClient
+	closed bool
+}
+
+// NewTLazyClient returns a TLazyClient connecting with dial, which opens a
+// new connection to the server with the transports the server expects
+// (TFramedTransport, etc.), using protocolFactory, TBinaryProtocol if nil:
+//
+//	client := NewMyServiceClient(thrift.NewTLazyClient(func(ctx context.Context) (thrift.TTransport, error) {
+//		return thrift.NewTSocketConf("backend:9090", conf)
+//	}, protocolFactory))
+//
+// The transport returned by dial may be closed, in which case the call opens
+// its TSocket or TSSLSocket before the deadline of its context.
+func NewTLazyClient(dial func(ctx context.Context) (TTransport, error), protocolFactory TProtocolFactory) *TLazyClient {
+	if protocolFactory == nil {
+		protocolFactory = NewTBinaryProtocolFactoryConf(nil)
+	}
+	return &TLazyClient{
+		dial:            dial,
+		protocolFactory: protocolFactory,
+		sem:             make(chan struct{}, 1),
+	}
+}
+
+// Call implements TClient, connecting first if the client is not connected.
+// A failed dial fails the call, and is tried again by the next one.
+func (c *TLazyClient) Call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ResponseMeta{}, ctx.Err()
+	}
+	defer func() {
+		<-c.sem
+	}()
+
+	trans, client, err := c.connect(ctx)
+	if err != nil {
+		return ResponseMeta{}, err
+	}
+	meta, err := client.Call(ctx, method, args, result)
+	if !reusableConn(ctx, err) {
+		c.mu.Lock()
+		if c.trans == trans {
+			c.trans, c.client = nil, nil
+		}
+		c.mu.Unlock()
+		trans.Close()
+	}
+	return meta, err
+}
+
+// connect returns the connection of the client, dialing it if there's none.
+func (c *TLazyClient) connect(ctx context.Context) (TTransport, *TStandardClient, error) {
+	c.mu.Lock()
+	trans, client, closed := c.trans, c.client, c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, nil, errLazyClientClosed
+	}
+	if client != nil {
+		return trans, client, nil
+	}
+
+	trans, err := c.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	proto := c.protocolFactory.GetProtocol(trans)
+	client = NewTStandardClient(proto, proto)
+	// The transports returned by dial may be closed.
+	client.SetTimeouts(TClientTimeouts{OpenClosed: true})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		trans.Close()
+		return nil, nil, errLazyClientClosed
+	}
+	c.trans, c.client = trans, client
+	return trans, client, nil
+}
+
+// Close closes the connection of the client, if any, failing the call in
+// progress. The next calls fail without dialing.
+func (c *TLazyClient) Close() error {
+	c.mu.Lock()
+	trans := c.trans
+	c.trans, c.client, c.closed = nil, nil, true
+	c.mu.Unlock()
+	if trans == nil {
+		return nil
+	}
+	return trans.Close()
+}
+
+var _ TClient = (*TLazyClient)(nil)
@@ -0,0 +1,108 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLazyClient(t *testing.T) {
+	serv, addr := startTestSocketServer(t, echoProcessor(), nil)
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	type ctxKey struct{}
+	var (
+		dials   int
+		dialErr error
+		socks   []*TSocket
+	)
+	client := NewTLazyClient(func(ctx context.Context) (TTransport, error) {
+		dials++
+		if ctx.Value(ctxKey{}) == nil {
+			t.Error("expected the dial to get the context of the call")
+		}
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		// Opened by the call.
+		sock, err := NewTSocketConf(addr, &TConfiguration{SocketTimeout: 5 * time.Second})
+		if err != nil {
+			return nil, err
+		}
+		socks = append(socks, sock)
+		return sock, nil
+	}, nil)
+	t.Cleanup(func() {
+		client.Close()
+	})
+	if dials != 0 {
+		t.Fatalf("expected no dial before the first call, got %d", dials)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, true)
+	call := func(value string) error {
+		var result echoValue
+		if _, err := client.Call(ctx, "echo", &echoValue{value: value}, &result); err != nil {
+			return err
+		}
+		if result.value != value {
+			t.Errorf("expected result %q, got %q", value, result.value)
+		}
+		return nil
+	}
+
+	dialErr = errors.New("unreachable")
+	if err := call("hello"); err != dialErr {
+		t.Errorf("expected the dial error, got %v", err)
+	}
+	dialErr = nil
+	for _, value := range []string{"hello", "world"} {
+		if err := call(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dials != 2 {
+		t.Errorf("expected the connection to be reused, got %d dials", dials)
+	}
+
+	// A broken connection is dialed again by the next call.
+	socks[0].Close()
+	socks[0].addr = nil
+	if err := call("broken"); err == nil {
+		t.Error("expected the call over the broken connection to fail")
+	}
+	if err := call("redialed"); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 3 {
+		t.Errorf("expected the connection to be dialed again, got %d dials", dials)
+	}
+
+	client.Close()
+	if err := call("closed"); err == nil {
+		t.Error("expected the calls of a closed client to fail")
+	}
+	if dials != 3 {
+		t.Errorf("expected no dial once closed, got %d dials", dials)
+	}
+}
@@ -0,0 +1,351 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNoReadyEndpoint is returned by the calls of a TBalancedClient when none
+// of its endpoints is ready.
+var ErrNoReadyEndpoint = errors.New("no ready endpoint")
+
+// TEndpoint is an endpoint of a TBalancedClient.
+type TEndpoint struct {
+	// Address identifies the endpoint, for the policies, the health checks
+	// and the CircuitBreaker.
+	Address string
+
+	// Weight is the weight of the endpoint with WeightedPolicy and
+	// ConsistentHashPolicy, 1 if <= 0.
+	Weight int
+
+	// Client sends the calls to the endpoint. It's called concurrently if
+	// the TBalancedClient is, so it must then be safe for concurrent use.
+	Client TClient
+}
+
+// EndpointStatus is the status of a ready endpoint, passed to the
+// LoadBalancingPolicy.
+type EndpointStatus struct {
+	Address string
+	Weight  int
+
+	// Outstanding is the number of calls being sent to the endpoint by the
+	// TBalancedClient.
+	Outstanding int64
+}
+
+// LoadBalancingPolicy chooses the endpoint of the calls of a TBalancedClient.
+type LoadBalancingPolicy interface {
+	// Pick returns the index in endpoints of the endpoint of a call.
+	// endpoints are the ready endpoints, in the order they were set, and
+	// there's at least one.
+	Pick(ctx context.Context, method string, endpoints []EndpointStatus) int
+}
+
+// LoadBalancingPolicyFunc is a function implementing LoadBalancingPolicy.
+type LoadBalancingPolicyFunc func(ctx context.Context, method string, endpoints []EndpointStatus) int
+
+// Pick calls f.
+func (f LoadBalancingPolicyFunc) Pick(ctx context.Context, method string, endpoints []EndpointStatus) int {
+	return f(ctx, method, endpoints)
+}
+
+// RoundRobinPolicy returns a LoadBalancingPolicy sending the calls to every
+// endpoint in turn.
+func RoundRobinPolicy() LoadBalancingPolicy {
+	var next uint64
+	return LoadBalancingPolicyFunc(func(ctx context.Context, method string, endpoints []EndpointStatus) int {
+		return int((atomic.AddUint64(&next, 1) - 1) % uint64(len(endpoints)))
+	})
+}
+
+// LeastOutstandingPolicy returns a LoadBalancingPolicy sending the calls to
+// the endpoint with the fewest calls outstanding, so that the slower
+// endpoints get fewer calls. The ties are broken in turn.
+func LeastOutstandingPolicy() LoadBalancingPolicy {
+	var next uint64
+	return LoadBalancingPolicyFunc(func(ctx context.Context, method string, endpoints []EndpointStatus) int {
+		offset := int(atomic.AddUint64(&next, 1) % uint64(len(endpoints)))
+		best := offset
+		for i := range endpoints {
+			j := (offset + i) % len(endpoints)
+			if endpoints[j].Outstanding < endpoints[best].Outstanding {
+				best = j
+			}
+		}
+		return best
+	})
+}
+
+// WeightedPolicy returns a LoadBalancingPolicy sending the calls to every
+// endpoint in turn, in proportion to their weights.
+func WeightedPolicy() LoadBalancingPolicy {
+	var next uint64
+	return LoadBalancingPolicyFunc(func(ctx context.Context, method string, endpoints []EndpointStatus) int {
+		var total uint64
+		for _, e := range endpoints {
+			total += uint64(e.Weight)
+		}
+		n := (atomic.AddUint64(&next, 1) - 1) % total
+		for i, e := range endpoints {
+			if n < uint64(e.Weight) {
+				return i
+			}
+			n -= uint64(e.Weight)
+		}
+		return len(endpoints) - 1
+	})
+}
+
+// ConsistentHashPolicy returns a LoadBalancingPolicy sending the calls with
+// the same key, e.g. the id of the entity they're about, to the same
+// endpoint, so that the endpoints can cache them. When an endpoint is added
+// or removed, only the keys of that endpoint move (rendezvous hashing),
+// spread over the endpoints in proportion to their weights.
+//
+// The calls whose key is "" are sent to the endpoints in turn.
+func ConsistentHashPolicy(key func(ctx context.Context, method string) string) LoadBalancingPolicy {
+	roundRobin := RoundRobinPolicy()
+	return LoadBalancingPolicyFunc(func(ctx context.Context, method string, endpoints []EndpointStatus) int {
+		k := key(ctx, method)
+		if k == "" {
+			return roundRobin.Pick(ctx, method, endpoints)
+		}
+		best, bestScore := 0, math.Inf(-1)
+		for i, e := range endpoints {
+			h := fnv.New64a()
+			h.Write([]byte(k))
+			h.Write([]byte{0})
+			h.Write([]byte(e.Address))
+			// Weighted rendezvous hashing: the hash mapped to (0, 1).
+			u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+			if score := float64(e.Weight) / -math.Log(u); score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		return best
+	})
+}
+
+// TBalancedClientOptions configures NewTBalancedClient.
+type TBalancedClientOptions struct {
+	// Policy chooses the endpoint of every call, RoundRobinPolicy if nil.
+	Policy LoadBalancingPolicy
+
+	// Breaker, if set, sends the calls through the circuits of their
+	// endpoints, and the endpoints whose circuit is open are not ready.
+	Breaker *CircuitBreaker
+
+	// OutlierDetector, if set, records the outcome of the calls of the
+	// endpoints, and the ejected endpoints are not ready.
+	OutlierDetector *OutlierDetector
+
+	// Healthy reports whether the endpoint address is healthy, e.g. from
+	// the health checks of the service, the unhealthy endpoints being not
+	// ready.
+	//
+	// If nil, all the endpoints are healthy.
+	Healthy func(address string) bool
+}
+
+// TBalancedClient is a TClient distributing the calls over a set of
+// endpoints, for example for the generated clients:
+//
+//	client := NewMyServiceClient(thrift.NewTBalancedClient(endpoints, opts))
+type TBalancedClient struct {
+	opts TBalancedClientOptions
+
+	mu        sync.RWMutex
+	endpoints []*tBalancedEndpoint
+}
+
+type tBalancedEndpoint struct {
+	TEndpoint
+
+	// outstanding is shared with the previous endpoints of the same
+	// address, see SetEndpoints.
+	outstanding *int64
+}
+
+// NewTBalancedClient returns a TBalancedClient over endpoints.
+func NewTBalancedClient(endpoints []TEndpoint, opts TBalancedClientOptions) *TBalancedClient {
+	if opts.Policy == nil {
+		opts.Policy = RoundRobinPolicy()
+	}
+	c := &TBalancedClient{opts: opts}
+	c.SetEndpoints(endpoints)
+	return c
+}
+
+// SetEndpoints replaces the endpoints of c, e.g. when discovered again. The
+// calls in progress are not affected.
+func (c *TBalancedClient) SetEndpoints(endpoints []TEndpoint) {
+	balanced := make([]*tBalancedEndpoint, 0, len(endpoints))
+	addresses := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		if e.Weight <= 0 {
+			e.Weight = 1
+		}
+		if c.opts.OutlierDetector != nil {
+			e.Client = c.opts.OutlierDetector.Middleware(e.Address)(e.Client)
+		}
+		if c.opts.Breaker != nil {
+			e.Client = c.opts.Breaker.Middleware(e.Address)(e.Client)
+		}
+		addresses = append(addresses, e.Address)
+		balanced = append(balanced, &tBalancedEndpoint{
+			TEndpoint:   e,
+			outstanding: new(int64),
+		})
+	}
+	if c.opts.OutlierDetector != nil {
+		c.opts.OutlierDetector.retain(addresses)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Keep counting the outstanding calls of the endpoints still there.
+	for _, e := range c.endpoints {
+		for _, b := range balanced {
+			if b.Address == e.Address {
+				b.outstanding = e.outstanding
+			}
+		}
+	}
+	c.endpoints = balanced
+}
+
+// Call sends the call to the endpoint chosen by the policy among the ready
+// ones, failing with ErrNoReadyEndpoint if there's none. The Endpoint of the
+// CallOptions of the call is chosen instead if it's ready.
+func (c *TBalancedClient) Call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	e := c.pick(ctx, method)
+	if e == nil {
+		return ResponseMeta{}, ErrNoReadyEndpoint
+	}
+	atomic.AddInt64(e.outstanding, 1)
+	defer atomic.AddInt64(e.outstanding, -1)
+	return e.Client.Call(ctx, method, args, result)
+}
+
+func (c *TBalancedClient) pick(ctx context.Context, method string) *tBalancedEndpoint {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ready := make([]*tBalancedEndpoint, 0, len(c.endpoints))
+	statuses := make([]EndpointStatus, 0, len(c.endpoints))
+	for _, e := range c.endpoints {
+		if c.opts.Healthy != nil && !c.opts.Healthy(e.Address) {
+			continue
+		}
+		if c.opts.Breaker != nil && c.opts.Breaker.State(e.Address) == CircuitOpen {
+			continue
+		}
+		if c.opts.OutlierDetector != nil && c.opts.OutlierDetector.Ejected(e.Address) {
+			continue
+		}
+		ready = append(ready, e)
+		statuses = append(statuses, EndpointStatus{
+			Address:     e.Address,
+			Weight:      e.Weight,
+			Outstanding: atomic.LoadInt64(e.outstanding),
+		})
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+	attempts, _ := ctx.Value(balancerAttemptsKey{}).(*tBalancerAttempts)
+	if attempts != nil {
+		ready, statuses = attempts.exclude(ready, statuses)
+	}
+	i := -1
+	if address := CallOptionsFromContext(ctx).Endpoint; address != "" {
+		for j, e := range ready {
+			if e.Address == address {
+				i = j
+				break
+			}
+		}
+	}
+	if i < 0 {
+		i = c.opts.Policy.Pick(ctx, method, statuses)
+	}
+	if i < 0 || i >= len(ready) {
+		i = 0
+	}
+	if attempts != nil {
+		attempts.add(ready[i].Address)
+	}
+	return ready[i]
+}
+
+type balancerAttemptsKey struct{}
+
+// tBalancerAttempts are the endpoints the attempts of a call were sent to,
+// so that a TBalancedClient sends the next attempts to other endpoints, see
+// HedgingMiddleware and RetryMiddleware.
+type tBalancerAttempts struct {
+	mu        sync.Mutex
+	addresses []string
+}
+
+// withBalancerAttempts returns a copy of ctx whose calls to a
+// TBalancedClient are sent to different endpoints when possible.
+func withBalancerAttempts(ctx context.Context) context.Context {
+	return context.WithValue(ctx, balancerAttemptsKey{}, &tBalancerAttempts{})
+}
+
+func (a *tBalancerAttempts) add(address string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.addresses = append(a.addresses, address)
+}
+
+// exclude removes the endpoints already attempted, unless they're all.
+func (a *tBalancerAttempts) exclude(ready []*tBalancedEndpoint, statuses []EndpointStatus) ([]*tBalancedEndpoint, []EndpointStatus) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var untried []*tBalancedEndpoint
+	var untriedStatuses []EndpointStatus
+	for i, e := range ready {
+		tried := false
+		for _, address := range a.addresses {
+			if address == e.Address {
+				tried = true
+				break
+			}
+		}
+		if !tried {
+			untried = append(untried, e)
+			untriedStatuses = append(untriedStatuses, statuses[i])
+		}
+	}
+	if len(untried) == 0 {
+		return ready, statuses
+	}
+	return untried, untriedStatuses
+}
+
+var _ TClient = (*TBalancedClient)(nil)
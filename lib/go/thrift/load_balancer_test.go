@@ -0,0 +1,145 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// recordingEndpoints returns endpoints recording the calls they get in
+// called.
+func recordingEndpoints(called map[string]int, err *error, addresses ...string) []TEndpoint {
+	endpoints := make([]TEndpoint, 0, len(addresses))
+	for _, address := range addresses {
+		address := address
+		endpoints = append(endpoints, TEndpoint{
+			Address: address,
+			Client: WrappedTClient{
+				Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+					called[address]++
+					return ResponseMeta{}, *err
+				},
+			},
+		})
+	}
+	return endpoints
+}
+
+func TestTBalancedClientPolicies(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	called := make(map[string]int)
+	c := NewTBalancedClient(recordingEndpoints(called, &err, "a", "b", "c"), TBalancedClientOptions{})
+	for i := 0; i < 6; i++ {
+		c.Call(ctx, "m", nil, nil)
+	}
+	if called["a"] != 2 || called["b"] != 2 || called["c"] != 2 {
+		t.Errorf("expected round-robin calls, got %v", called)
+	}
+
+	called = make(map[string]int)
+	endpoints := recordingEndpoints(called, &err, "a", "b")
+	endpoints[0].Weight = 3
+	c = NewTBalancedClient(endpoints, TBalancedClientOptions{Policy: WeightedPolicy()})
+	for i := 0; i < 8; i++ {
+		c.Call(ctx, "m", nil, nil)
+	}
+	if called["a"] != 6 || called["b"] != 2 {
+		t.Errorf("expected weighted calls, got %v", called)
+	}
+
+	if i := LeastOutstandingPolicy().Pick(ctx, "m", []EndpointStatus{
+		{Address: "a", Outstanding: 2},
+		{Address: "b", Outstanding: 0},
+		{Address: "c", Outstanding: 1},
+	}); i != 1 {
+		t.Errorf("expected the endpoint with the fewest outstanding calls, got %d", i)
+	}
+}
+
+func TestConsistentHashPolicy(t *testing.T) {
+	type keyCtx struct{}
+	policy := ConsistentHashPolicy(func(ctx context.Context, method string) string {
+		key, _ := ctx.Value(keyCtx{}).(string)
+		return key
+	})
+	statuses := func(addresses ...string) []EndpointStatus {
+		s := make([]EndpointStatus, 0, len(addresses))
+		for _, address := range addresses {
+			s = append(s, EndpointStatus{Address: address, Weight: 1})
+		}
+		return s
+	}
+	all := statuses("a", "b", "c")
+	without := statuses("a", "b")
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		ctx := context.WithValue(context.Background(), keyCtx{}, fmt.Sprint("key", i))
+		picked := all[policy.Pick(ctx, "m", all)].Address
+		if again := all[policy.Pick(ctx, "m", all)].Address; again != picked {
+			t.Fatalf("expected the same endpoint for the same key, got %s and %s", picked, again)
+		}
+		counts[picked]++
+		if after := without[policy.Pick(ctx, "m", without)].Address; picked != "c" && after != picked {
+			t.Errorf("expected key%d to stay on %s when removing c, moved to %s", i, picked, after)
+		}
+	}
+	for _, address := range []string{"a", "b", "c"} {
+		if counts[address] < 50 {
+			t.Errorf("expected the keys to be spread over the endpoints, got %v", counts)
+		}
+	}
+}
+
+func TestTBalancedClientHealth(t *testing.T) {
+	err := errors.New("unavailable")
+	called := make(map[string]int)
+	healthy := map[string]bool{"a": false, "b": true, "c": true}
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{MinCalls: 1})
+	c := NewTBalancedClient(recordingEndpoints(called, &err, "a", "b", "c"), TBalancedClientOptions{
+		Breaker: breaker,
+		Healthy: func(address string) bool {
+			return healthy[address]
+		},
+	})
+	ctx := context.Background()
+
+	// The first failing call of b and c opens their circuits.
+	for i := 0; i < 4; i++ {
+		c.Call(ctx, "m", nil, nil)
+	}
+	if called["a"] != 0 || called["b"] != 1 || called["c"] != 1 {
+		t.Errorf("expected the unhealthy and open endpoints to be skipped, got %v", called)
+	}
+	if _, err := c.Call(ctx, "m", nil, nil); !errors.Is(err, ErrNoReadyEndpoint) {
+		t.Errorf("expected ErrNoReadyEndpoint, got %v", err)
+	}
+
+	err = nil
+	c.SetEndpoints(recordingEndpoints(called, &err, "a", "d"))
+	healthy["d"] = true
+	if _, err := c.Call(ctx, "m", nil, nil); err != nil || called["d"] != 1 {
+		t.Errorf("expected the call to go to the new endpoint, got %v, %v", err, called)
+	}
+}
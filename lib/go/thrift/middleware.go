@@ -69,6 +69,43 @@ var (
 	_ TProcessorFunction = (*WrappedTProcessorFunction)(nil)
 )
 
+// OnewayFunc reports whether method is oneway. The middlewares replying to
+// the requests they reject, like RateLimitMiddleware, use it to not write any
+// reply to the oneway requests, whose clients don't read any.
+//
+// It's only used when the message type of the request is not in its context,
+// see WithMessageType, which the generated processors set. A nil OnewayFunc
+// reports all the methods as two-way.
+type OnewayFunc func(method string) bool
+
+// IsOneway reports whether the request of ctx, to method, is oneway: from
+// its message type if known, from f otherwise.
+func (f OnewayFunc) IsOneway(ctx context.Context, method string) bool {
+	if typeID, ok := ctx.Value(messageTypeKey{}).(TMessageType); ok {
+		return typeID == ONEWAY
+	}
+	return f != nil && f(method)
+}
+
+// messageType returns the message type of the request of ctx, to method,
+// ONEWAY or CALL.
+func (f OnewayFunc) messageType(ctx context.Context, method string) TMessageType {
+	if f.IsOneway(ctx, method) {
+		return ONEWAY
+	}
+	return CALL
+}
+
+type messageTypeKey struct{}
+
+// WithMessageType sets the message type of the request in its context, for
+// the processor functions and their middlewares.
+//
+// It's called by the TProcessors after reading the message begin.
+func WithMessageType(ctx context.Context, typeID TMessageType) context.Context {
+	return context.WithValue(ctx, messageTypeKey{}, typeID)
+}
+
 // ClientMiddleware can be passed to WrapClient in order to wrap TClient calls
 // with custom middleware.
 type ClientMiddleware func(TClient) TClient
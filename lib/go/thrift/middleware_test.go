@@ -133,3 +133,64 @@ func TestWrapClient(t *testing.T) {
 		t.Fatalf("Unexpected count value %v", c.count)
 	}
 }
+
+func TestTMultiplexedProcessorServiceMiddleware(t *testing.T) {
+	const name = "test"
+	newService := func() TProcessor {
+		return &mockWrappableProcessor{
+			ProcessorFuncs: map[string]TProcessorFunction{
+				name: WrappedTProcessorFunction{
+					Wrapped: func(ctx context.Context, seqId int32, in, out TProtocol) (bool, TException) {
+						return true, nil
+					},
+				},
+			},
+		}
+	}
+	global, foo := newCounter(t), newCounter(t)
+	var names []string
+	processor := NewTMultiplexedProcessor()
+	processor.RegisterProcessorWithMiddleware("foo", newService(), testProcessorMiddleware(foo),
+		func(name string, next TProcessorFunction) TProcessorFunction {
+			names = append(names, name)
+			return next
+		},
+	)
+	processor.RegisterProcessor("bar", newService())
+	wrapped := WrapProcessor(processor, testProcessorMiddleware(global))
+
+	ctx := setMockWrappableProcessorName(context.Background(), name)
+	wrapped.Process(ctx, NewStoredMessageProtocol(nil, "foo"+MULTIPLEXED_SEPARATOR+name, 1, 1), nil)
+	if global.count != 1 || foo.count != 1 {
+		t.Errorf("Unexpected count values %v, %v", global.count, foo.count)
+	}
+	wrapped.Process(ctx, NewStoredMessageProtocol(nil, "bar"+MULTIPLEXED_SEPARATOR+name, 1, 1), nil)
+	if global.count != 2 || foo.count != 1 {
+		t.Errorf("Expected the foo middleware not to apply to bar, got %v, %v", global.count, foo.count)
+	}
+	if len(names) != 1 || names[0] != name {
+		t.Errorf("Expected service middlewares to get the method name without the service name, got %v", names)
+	}
+}
+
+func TestOnewayFunc(t *testing.T) {
+	ctx := context.Background()
+	var f OnewayFunc
+	if f.IsOneway(ctx, "notify") {
+		t.Error("expected a nil OnewayFunc to report all the methods as two-way")
+	}
+	f = func(method string) bool {
+		return method == "notify"
+	}
+	if !f.IsOneway(ctx, "notify") || f.IsOneway(ctx, "call") {
+		t.Error("expected the OnewayFunc to be used without a message type")
+	}
+
+	// The message type of the request takes precedence over f.
+	if !f.IsOneway(WithMessageType(ctx, ONEWAY), "call") {
+		t.Error("expected a ONEWAY request to be reported as oneway")
+	}
+	if f.IsOneway(WithMessageType(ctx, CALL), "notify") {
+		t.Error("expected a CALL request to be reported as two-way")
+	}
+}
@@ -0,0 +1,508 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MQTTClient is the minimal subset of an MQTT client used by the MQTT
+// transports.
+//
+// This library does not ship an MQTT client implementation. MQTTClient is meant
+// to be a simple common ground that it's easy to wrap whatever MQTT library
+// (paho, etc.) the users already use into.
+type MQTTClient interface {
+	// Publish publishes payload to topic with the given QoS level and
+	// retained flag.
+	Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error
+
+	// Subscribe subscribes to topic, handler should be called for every
+	// message received on that topic.
+	Subscribe(ctx context.Context, topic string, qos byte, handler func(topic string, payload []byte)) error
+
+	// Unsubscribe removes a subscription previously added by Subscribe.
+	Unsubscribe(ctx context.Context, topic string) error
+}
+
+// TMQTTOptions defines the options used by TMQTTClientTransport and
+// TMQTTServerTransport.
+type TMQTTOptions struct {
+	// The topic requests are published to, and the server subscribes to.
+	//
+	// Required.
+	RequestTopic string
+
+	// The topic responses are published to, and the client subscribes to.
+	//
+	// It's only used by the client side, as the server always replies to the
+	// topic carried by the request.
+	// If empty, RequestTopic + "/reply/" + a random id will be used.
+	ResponseTopic string
+
+	// The QoS level to publish and subscribe with (0, 1, or 2).
+	QoS byte
+
+	// Whether published messages should be retained by the broker.
+	//
+	// Retaining requests is rarely what you want for RPCs, but it can be
+	// useful for oneway calls from devices that connect to the broker
+	// before the server does.
+	Retained bool
+}
+
+// mqttEnvelopeVersion is the first byte of every message published by the
+// MQTT transports.
+const mqttEnvelopeVersion = 1
+
+// mqttEnvelopeHeaderSize is the size of the fixed part of the envelope:
+// version (1) + correlation id (8) + reply topic length (2).
+const mqttEnvelopeHeaderSize = 11
+
+// mqttEnvelope is the wire format of the messages published by the MQTT
+// transports.
+//
+// MQTT 3.1.1 has no notion of request/response, so the correlation id and the
+// topic to reply to are carried in front of the thrift payload.
+type mqttEnvelope struct {
+	id         uint64
+	replyTopic string
+	payload    []byte
+}
+
+func (e mqttEnvelope) encode() []byte {
+	buf := make([]byte, mqttEnvelopeHeaderSize, mqttEnvelopeHeaderSize+len(e.replyTopic)+len(e.payload))
+	buf[0] = mqttEnvelopeVersion
+	binary.BigEndian.PutUint64(buf[1:9], e.id)
+	binary.BigEndian.PutUint16(buf[9:11], uint16(len(e.replyTopic)))
+	buf = append(buf, e.replyTopic...)
+	return append(buf, e.payload...)
+}
+
+func decodeMQTTEnvelope(data []byte) (mqttEnvelope, error) {
+	if len(data) < mqttEnvelopeHeaderSize {
+		return mqttEnvelope{}, NewTTransportException(UNKNOWN_TRANSPORT_EXCEPTION, "mqtt message too short")
+	}
+	if data[0] != mqttEnvelopeVersion {
+		return mqttEnvelope{}, NewTTransportException(
+			UNKNOWN_TRANSPORT_EXCEPTION,
+			fmt.Sprintf("unsupported mqtt envelope version %d", data[0]),
+		)
+	}
+	topicLen := int(binary.BigEndian.Uint16(data[9:11]))
+	if len(data) < mqttEnvelopeHeaderSize+topicLen {
+		return mqttEnvelope{}, NewTTransportException(UNKNOWN_TRANSPORT_EXCEPTION, "mqtt message reply topic truncated")
+	}
+	return mqttEnvelope{
+		id:         binary.BigEndian.Uint64(data[1:9]),
+		replyTopic: string(data[mqttEnvelopeHeaderSize : mqttEnvelopeHeaderSize+topicLen]),
+		payload:    data[mqttEnvelopeHeaderSize+topicLen:],
+	}, nil
+}
+
+func validateMQTTOptions(opts TMQTTOptions) error {
+	if opts.RequestTopic == "" {
+		return NewTTransportException(NOT_OPEN, "mqtt request topic is required")
+	}
+	if opts.QoS > 2 {
+		return NewTTransportException(NOT_OPEN, fmt.Sprintf("invalid mqtt qos level %d", opts.QoS))
+	}
+	return nil
+}
+
+// TMQTTClientTransport is a client side TTransport that publishes requests to
+// an MQTT topic and reads the responses from another one.
+//
+// Every Flush publishes the buffered request as a single MQTT message, the
+// following Reads block until the response carrying the same correlation id
+// arrives, or the socket timeout from TConfiguration expires.
+//
+// It's aimed at constrained devices, so it's usually used together with
+// TCompactProtocol:
+//
+//	conf := &thrift.TConfiguration{
+//		SocketTimeout: 5 * time.Second,
+//	}
+//	trans, err := thrift.NewTMQTTClientTransport(mqttClient, thrift.TMQTTOptions{
+//		RequestTopic: "devices/calculator",
+//		QoS:          1,
+//	}, conf)
+//	proto := thrift.NewTCompactProtocolConf(trans, conf)
+//
+// Like TStandardClient, it's not safe for concurrent use.
+type TMQTTClientTransport struct {
+	client MQTTClient
+	opts   TMQTTOptions
+	cfg    *TConfiguration
+
+	mu        sync.Mutex
+	open      bool
+	nextID    uint64
+	pendingID uint64
+	responses chan []byte
+	// ctx is the context of the last flushed request, see Read.
+	ctx context.Context
+
+	writeBuf bytes.Buffer
+	readBuf  bytes.Buffer
+}
+
+// NewTMQTTClientTransport creates a new TMQTTClientTransport.
+//
+// Open must be called before use to subscribe to the response topic.
+func NewTMQTTClientTransport(client MQTTClient, opts TMQTTOptions, conf *TConfiguration) (*TMQTTClientTransport, error) {
+	if err := validateMQTTOptions(opts); err != nil {
+		return nil, err
+	}
+	if opts.ResponseTopic == "" {
+		var id [8]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return nil, err
+		}
+		opts.ResponseTopic = opts.RequestTopic + "/reply/" + hex.EncodeToString(id[:])
+	}
+	return &TMQTTClientTransport{
+		client:    client,
+		opts:      opts,
+		cfg:       conf,
+		responses: make(chan []byte, 1),
+	}, nil
+}
+
+// ResponseTopic returns the topic this transport reads responses from.
+func (p *TMQTTClientTransport) ResponseTopic() string {
+	return p.opts.ResponseTopic
+}
+
+// Open subscribes to the response topic.
+func (p *TMQTTClientTransport) Open() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.open {
+		return NewTTransportException(ALREADY_OPEN, "MQTT transport already open")
+	}
+	if err := p.client.Subscribe(
+		context.Background(),
+		p.opts.ResponseTopic,
+		p.opts.QoS,
+		p.onResponse,
+	); err != nil {
+		return NewTTransportExceptionFromError(err)
+	}
+	p.open = true
+	return nil
+}
+
+func (p *TMQTTClientTransport) onResponse(topic string, data []byte) {
+	env, err := decodeMQTTEnvelope(data)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if env.id == 0 || env.id != p.pendingID {
+		// Stale or unknown response, most likely for a request we already
+		// gave up on.
+		return
+	}
+	p.pendingID = 0
+	select {
+	case p.responses <- env.payload:
+	default:
+	}
+}
+
+// IsOpen returns true if the transport is subscribed to the response topic.
+func (p *TMQTTClientTransport) IsOpen() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.open
+}
+
+// Close unsubscribes from the response topic.
+func (p *TMQTTClientTransport) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.open {
+		return nil
+	}
+	p.open = false
+	p.pendingID = 0
+	p.writeBuf.Reset()
+	p.readBuf.Reset()
+	return p.client.Unsubscribe(context.Background(), p.opts.ResponseTopic)
+}
+
+func (p *TMQTTClientTransport) Write(buf []byte) (int, error) {
+	return p.writeBuf.Write(buf)
+}
+
+// Flush publishes the buffered request to the request topic.
+func (p *TMQTTClientTransport) Flush(ctx context.Context) error {
+	if p.writeBuf.Len() == 0 {
+		return nil
+	}
+	defer p.writeBuf.Reset()
+
+	p.mu.Lock()
+	if !p.open {
+		p.mu.Unlock()
+		return NewTTransportException(NOT_OPEN, "MQTT transport not open")
+	}
+	p.nextID++
+	if p.nextID == 0 {
+		// 0 is reserved for "no pending request".
+		p.nextID++
+	}
+	id := p.nextID
+	p.pendingID = id
+	p.mu.Unlock()
+
+	// Drop any response left over from an abandoned request.
+	select {
+	case <-p.responses:
+	default:
+	}
+	p.readBuf.Reset()
+
+	env := mqttEnvelope{
+		id:         id,
+		replyTopic: p.opts.ResponseTopic,
+		payload:    p.writeBuf.Bytes(),
+	}
+	if err := p.client.Publish(ctx, p.opts.RequestTopic, p.opts.QoS, p.opts.Retained, env.encode()); err != nil {
+		return NewTTransportExceptionFromError(err)
+	}
+	p.ctx = ctx
+	return nil
+}
+
+// Read reads the response of the last flushed request.
+//
+// If the response hasn't arrived yet, it blocks until it does, the socket
+// timeout configured in TConfiguration expires, or the context passed to Flush
+// is done.
+func (p *TMQTTClientTransport) Read(buf []byte) (int, error) {
+	if p.readBuf.Len() == 0 {
+		if err := p.waitResponse(); err != nil {
+			return 0, err
+		}
+	}
+	return p.readBuf.Read(buf)
+}
+
+func (p *TMQTTClientTransport) waitResponse() error {
+	var timeout <-chan time.Time
+	if d := p.cfg.GetSocketTimeout(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var done <-chan struct{}
+	if p.ctx != nil {
+		done = p.ctx.Done()
+	}
+	select {
+	case payload := <-p.responses:
+		if len(payload) == 0 {
+			return NewTTransportException(END_OF_FILE, "empty MQTT response")
+		}
+		p.readBuf.Write(payload)
+		return nil
+	case <-timeout:
+		return NewTTransportException(TIMED_OUT, "timed out waiting for MQTT response")
+	case <-done:
+		return NewTTransportExceptionFromError(p.ctx.Err())
+	}
+}
+
+func (p *TMQTTClientTransport) RemainingBytes() (num_bytes uint64) {
+	return uint64(p.readBuf.Len())
+}
+
+// SetTConfiguration implements TConfigurationSetter.
+func (p *TMQTTClientTransport) SetTConfiguration(conf *TConfiguration) {
+	p.cfg = conf
+}
+
+// TMQTTServerTransport is a TServerTransport that accepts requests published to
+// an MQTT topic.
+//
+// Every request message is handed out by Accept as a separate TTransport, and
+// the response written to it is published to the reply topic carried by the
+// request.
+type TMQTTServerTransport struct {
+	client MQTTClient
+	opts   TMQTTOptions
+
+	mu          sync.Mutex
+	listening   bool
+	interrupted bool
+	requests    chan mqttEnvelope
+	stop        chan struct{}
+}
+
+// NewTMQTTServerTransport creates a new TMQTTServerTransport.
+func NewTMQTTServerTransport(client MQTTClient, opts TMQTTOptions) (*TMQTTServerTransport, error) {
+	if err := validateMQTTOptions(opts); err != nil {
+		return nil, err
+	}
+	return &TMQTTServerTransport{
+		client:   client,
+		opts:     opts,
+		requests: make(chan mqttEnvelope, 64),
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Listen subscribes to the request topic.
+func (p *TMQTTServerTransport) Listen() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listening {
+		return nil
+	}
+	if err := p.client.Subscribe(
+		context.Background(),
+		p.opts.RequestTopic,
+		p.opts.QoS,
+		p.onRequest,
+	); err != nil {
+		return err
+	}
+	p.listening = true
+	return nil
+}
+
+func (p *TMQTTServerTransport) onRequest(topic string, data []byte) {
+	env, err := decodeMQTTEnvelope(data)
+	if err != nil {
+		return
+	}
+	select {
+	case p.requests <- env:
+	case <-p.stop:
+	}
+}
+
+// Accept blocks until the next request arrives.
+func (p *TMQTTServerTransport) Accept() (TTransport, error) {
+	select {
+	case env := <-p.requests:
+		return &tMQTTRequestTransport{
+			server:  p,
+			env:     env,
+			readBuf: bytes.NewBuffer(env.payload),
+		}, nil
+	case <-p.stop:
+		return nil, errTransportInterrupted
+	}
+}
+
+// Close unsubscribes from the request topic.
+func (p *TMQTTServerTransport) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.listening {
+		return nil
+	}
+	p.listening = false
+	return p.client.Unsubscribe(context.Background(), p.opts.RequestTopic)
+}
+
+// Interrupt breaks out of any pending Accept calls.
+func (p *TMQTTServerTransport) Interrupt() error {
+	p.mu.Lock()
+	if !p.interrupted {
+		p.interrupted = true
+		close(p.stop)
+	}
+	p.mu.Unlock()
+	return p.Close()
+}
+
+// tMQTTRequestTransport is the TTransport for a single request received by
+// TMQTTServerTransport.
+type tMQTTRequestTransport struct {
+	server   *TMQTTServerTransport
+	env      mqttEnvelope
+	readBuf  *bytes.Buffer
+	writeBuf bytes.Buffer
+}
+
+func (p *tMQTTRequestTransport) Open() error {
+	return nil
+}
+
+func (p *tMQTTRequestTransport) IsOpen() bool {
+	return true
+}
+
+func (p *tMQTTRequestTransport) Close() error {
+	return nil
+}
+
+func (p *tMQTTRequestTransport) Read(buf []byte) (int, error) {
+	n, err := p.readBuf.Read(buf)
+	return n, NewTTransportExceptionFromError(err)
+}
+
+func (p *tMQTTRequestTransport) Write(buf []byte) (int, error) {
+	return p.writeBuf.Write(buf)
+}
+
+// Flush publishes the response to the reply topic of the request.
+func (p *tMQTTRequestTransport) Flush(ctx context.Context) error {
+	if p.writeBuf.Len() == 0 {
+		return nil
+	}
+	defer p.writeBuf.Reset()
+	if p.env.replyTopic == "" {
+		return NewTTransportExceptionFromError(errors.New("mqtt request carries no reply topic"))
+	}
+	env := mqttEnvelope{
+		id:      p.env.id,
+		payload: p.writeBuf.Bytes(),
+	}
+	opts := p.server.opts
+	if err := p.server.client.Publish(ctx, p.env.replyTopic, opts.QoS, false, env.encode()); err != nil {
+		return NewTTransportExceptionFromError(err)
+	}
+	return nil
+}
+
+func (p *tMQTTRequestTransport) RemainingBytes() (num_bytes uint64) {
+	return uint64(p.readBuf.Len())
+}
+
+var (
+	_ TTransport           = (*TMQTTClientTransport)(nil)
+	_ TConfigurationSetter = (*TMQTTClientTransport)(nil)
+	_ TServerTransport     = (*TMQTTServerTransport)(nil)
+	_ TTransport           = (*tMQTTRequestTransport)(nil)
+)
@@ -0,0 +1,206 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMQTTBroker is an in-memory MQTTClient delivering messages to the
+// subscribers of the same broker.
+type fakeMQTTBroker struct {
+	mu       sync.Mutex
+	handlers map[string]func(topic string, payload []byte)
+	retained map[string]bool
+}
+
+func newFakeMQTTBroker() *fakeMQTTBroker {
+	return &fakeMQTTBroker{
+		handlers: make(map[string]func(string, []byte)),
+		retained: make(map[string]bool),
+	}
+}
+
+func (b *fakeMQTTBroker) Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error {
+	b.mu.Lock()
+	handler := b.handlers[topic]
+	b.retained[topic] = retained
+	b.mu.Unlock()
+	if handler != nil {
+		data := append([]byte(nil), payload...)
+		go handler(topic, data)
+	}
+	return nil
+}
+
+func (b *fakeMQTTBroker) Subscribe(ctx context.Context, topic string, qos byte, handler func(topic string, payload []byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[topic] = handler
+	return nil
+}
+
+func (b *fakeMQTTBroker) Unsubscribe(ctx context.Context, topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.handlers, topic)
+	return nil
+}
+
+func echoProcessor() *mockProcessor {
+	return &mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			ctx := context.Background()
+			name, _, seqID, err := in.ReadMessageBegin(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			value, err := in.ReadString(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			in.ReadMessageEnd(ctx)
+			out.WriteMessageBegin(ctx, name, REPLY, seqID)
+			out.WriteString(ctx, value)
+			out.WriteMessageEnd(ctx)
+			return true, WrapTException(out.Flush(ctx))
+		},
+	}
+}
+
+func TestMQTTTransportRoundTrip(t *testing.T) {
+	broker := newFakeMQTTBroker()
+	opts := TMQTTOptions{
+		RequestTopic: "test/echo",
+		QoS:          1,
+	}
+	serverTrans, err := NewTMQTTServerTransport(broker, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := NewTCompactProtocolFactoryConf(nil)
+	server := NewTSimpleServer4(echoProcessor(), serverTrans, NewTTransportFactory(), factory)
+	server.SetLogger(TestLogger(t))
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go server.AcceptLoop()
+	defer server.Stop()
+
+	trans, err := NewTMQTTClientTransport(broker, opts, &TConfiguration{
+		SocketTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trans.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Close()
+	proto := factory.GetProtocol(trans)
+
+	ctx := context.Background()
+	for i, value := range []string{"foo", "bar"} {
+		seqID := int32(i + 1)
+		proto.WriteMessageBegin(ctx, "echo", CALL, seqID)
+		proto.WriteString(ctx, value)
+		proto.WriteMessageEnd(ctx)
+		if err := proto.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		name, typeID, rSeqID, err := proto.ReadMessageBegin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "echo" || typeID != REPLY || rSeqID != seqID {
+			t.Errorf("unexpected message begin: %q %v %d", name, typeID, rSeqID)
+		}
+		got, err := proto.ReadString(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != value {
+			t.Errorf("expected %q, got %q", value, got)
+		}
+		proto.ReadMessageEnd(ctx)
+	}
+}
+
+func TestMQTTClientTransportTimeout(t *testing.T) {
+	broker := newFakeMQTTBroker()
+	trans, err := NewTMQTTClientTransport(broker, TMQTTOptions{
+		RequestTopic: "test/nobody",
+	}, &TConfiguration{
+		SocketTimeout: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trans.Open(); err != nil {
+		t.Fatal(err)
+	}
+	trans.Write([]byte("request"))
+	if err := trans.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, err = trans.Read(make([]byte, 1))
+	if !isTimeoutError(err) {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestMQTTClientTransportContext(t *testing.T) {
+	// Without a socket timeout, the read is interrupted by the context.
+	broker := newFakeMQTTBroker()
+	trans, err := NewTMQTTClientTransport(broker, TMQTTOptions{
+		RequestTopic: "test/nobody",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trans.Open(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	trans.Write([]byte("request"))
+	if err := trans.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, err = trans.Read(make([]byte, 1))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestMQTTOptionsValidation(t *testing.T) {
+	broker := newFakeMQTTBroker()
+	if _, err := NewTMQTTClientTransport(broker, TMQTTOptions{}, nil); err == nil {
+		t.Error("expected error on missing request topic")
+	}
+	if _, err := NewTMQTTServerTransport(broker, TMQTTOptions{RequestTopic: "a", QoS: 3}); err == nil {
+		t.Error("expected error on invalid qos")
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"sync"
+)
+
+// TMultiplexedClients builds the TClients of several services of a server
+// using a TMultiplexedProcessor, sharing a single connection:
+//
+//	clients := thrift.NewTMultiplexedClients(protocol, protocol, metrics.Middleware())
+//	calculator := NewCalculatorClient(clients.Client("Calculator"))
+//	weather := NewWeatherReportClient(clients.Client("WeatherReport"))
+//
+// The calls of all the services are serialized, as with TSynchronizedClient,
+// so the clients are safe for concurrent use.
+type TMultiplexedClients struct {
+	iprot, oprot TProtocol
+	middlewares  []ClientMiddleware
+
+	// sem is the semaphore of the TSynchronizedClients of all the
+	// services.
+	sem chan struct{}
+
+	mu      sync.Mutex
+	clients map[string]TClient
+}
+
+// NewTMultiplexedClients returns a TMultiplexedClients over the protocols of
+// a connection, the clients of the services being wrapped with middlewares.
+func NewTMultiplexedClients(inputProtocol, outputProtocol TProtocol, middlewares ...ClientMiddleware) *TMultiplexedClients {
+	return &TMultiplexedClients{
+		iprot:       inputProtocol,
+		oprot:       outputProtocol,
+		middlewares: middlewares,
+		sem:         make(chan struct{}, 1),
+		clients:     make(map[string]TClient),
+	}
+}
+
+// Client returns the TClient of service, sending its calls with a
+// TMultiplexedProtocol. The middlewares get the method names without the
+// service.
+func (m *TMultiplexedClients) Client(service string) TClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if client, ok := m.clients[service]; ok {
+		return client
+	}
+	var client TClient = &TSynchronizedClient{
+		client: NewTStandardClient(m.iprot, NewTMultiplexedProtocol(m.oprot, service)),
+		sem:    m.sem,
+	}
+	client = WrapClient(client, m.middlewares...)
+	m.clients[service] = client
+	return client
+}
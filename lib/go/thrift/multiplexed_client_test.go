@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestMultiplexedClients(t *testing.T) {
+	processors := make(map[string]*contextCapturingProcessor)
+	mp := NewTMultiplexedProcessor()
+	for _, service := range []string{"First", "Second"} {
+		processors[service] = &contextCapturingProcessor{
+			mockProcessor: echoProcessor(),
+			ctxs:          make(chan context.Context, 100),
+		}
+		mp.RegisterProcessor(service, processors[service])
+	}
+	serv, addr := startTestSocketServer(t, mp, nil)
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	var (
+		mu      sync.Mutex
+		methods = make(map[string]int)
+	)
+	proto := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+	clients := NewTMultiplexedClients(proto, proto, func(next TClient) TClient {
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				mu.Lock()
+				methods[method]++
+				mu.Unlock()
+				return next.Call(ctx, method, args, result)
+			},
+		}
+	})
+	// The calls of the services are serialized on the connection.
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		for _, service := range []string{"First", "Second"} {
+			wg.Add(1)
+			go func(client TClient, value string) {
+				defer wg.Done()
+				var result echoValue
+				if _, err := client.Call(context.Background(), "echo", &echoValue{value: value}, &result); err != nil {
+					errs <- err
+				} else if result.value != value {
+					errs <- fmt.Errorf("expected result %q, got %q", value, result.value)
+				}
+			}(clients.Client(service), fmt.Sprintf("%s-%d", service, i))
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	for service, processor := range processors {
+		if n := len(processor.ctxs); n != 10 {
+			t.Errorf("%s: expected 10 calls, got %d", service, n)
+		}
+	}
+	if methods["echo"] != 20 {
+		t.Errorf("expected the middleware to get the method names without the service, got %v", methods)
+	}
+}
@@ -21,7 +21,9 @@ package thrift
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -52,6 +54,9 @@ if err != nil {
 
 fmt.Println(service.Add(2,2))
 fmt.Println(service2.GetTemperature())
+
+The clients of the example are not safe for concurrent use, NewTMultiplexedClients
+builds the clients of the services sharing a connection which are.
 */
 
 type TMultiplexedProtocol struct {
@@ -106,9 +111,20 @@ server := thrift.NewTSimpleServer2(processor, serverTransport)
 server.Serve();
 */
 
+// ErrUnknownService is wrapped in the error returned by
+// TMultiplexedProcessor.Process for a request of a service that is not
+// registered, when it has no FallbackProcessor.
+var ErrUnknownService = errors.New("unknown service")
+
 type TMultiplexedProcessor struct {
 	serviceProcessorMap map[string]TProcessor
 	DefaultProcessor    TProcessor
+
+	// FallbackProcessor, if set, processes the requests of the services
+	// that are not registered, e.g. to forward them to another server
+	// during a migration. Its messages keep their full
+	// "{ServiceName}{MULTIPLEXED_SEPARATOR}{FunctionName}" names.
+	FallbackProcessor TProcessor
 }
 
 func NewTMultiplexedProcessor() *TMultiplexedProcessor {
@@ -182,6 +198,11 @@ func (t *TMultiplexedProcessor) RegisterDefault(processor TProcessor) {
 	t.DefaultProcessor = processor
 }
 
+// RegisterFallback sets the FallbackProcessor of t.
+func (t *TMultiplexedProcessor) RegisterFallback(processor TProcessor) {
+	t.FallbackProcessor = processor
+}
+
 func (t *TMultiplexedProcessor) RegisterProcessor(name string, processor TProcessor) {
 	if t.serviceProcessorMap == nil {
 		t.serviceProcessorMap = make(map[string]TProcessor)
@@ -189,6 +210,29 @@ func (t *TMultiplexedProcessor) RegisterProcessor(name string, processor TProces
 	t.serviceProcessorMap[name] = processor
 }
 
+// RegisterProcessorWithMiddleware registers processor as the service name,
+// with middlewares only applying to the requests of this service, for example
+// so that authentication or quota policies differ between services.
+//
+// The middlewares are passed in the method names as set in the processor map
+// of processor, without the service name. Like with WrapProcessor, the
+// TProcessorFunctions of processor are wrapped in place. The middlewares set on
+// the TMultiplexedProcessor itself with WrapProcessor wrap the ones of the
+// services registered before, so they should be registered first.
+func (t *TMultiplexedProcessor) RegisterProcessorWithMiddleware(name string, processor TProcessor, middlewares ...ProcessorMiddleware) {
+	t.RegisterProcessor(name, WrapProcessor(processor, middlewares...))
+}
+
+// Services returns the sorted names of the registered services.
+func (t *TMultiplexedProcessor) Services() []string {
+	services := make([]string, 0, len(t.serviceProcessorMap))
+	for name := range t.serviceProcessorMap {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	return services
+}
+
 func (t *TMultiplexedProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
 	name, typeId, seqid, err := in.ReadMessageBegin(ctx)
 	if err != nil {
@@ -211,8 +255,13 @@ func (t *TMultiplexedProcessor) Process(ctx context.Context, in, out TProtocol)
 	}
 	actualProcessor, ok := t.serviceProcessorMap[v[0]]
 	if !ok {
+		if t.FallbackProcessor != nil {
+			smb := NewStoredMessageProtocol(in, name, typeId, seqid)
+			return t.FallbackProcessor.Process(ctx, smb, out)
+		}
 		return false, NewTProtocolException(fmt.Errorf(
-			"Service name not found: %s.  Did you forget to call registerProcessor()?",
+			"%w: %s.  Did you forget to call registerProcessor()?",
+			ErrUnknownService,
 			v[0],
 		))
 	}
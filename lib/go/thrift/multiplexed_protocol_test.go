@@ -2,6 +2,8 @@ package thrift
 
 import (
 	"context"
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -51,3 +53,35 @@ func TestMultiplexedProcessorMap(t *testing.T) {
 		}
 	}
 }
+
+func TestMultiplexedProcessorUnknownService(t *testing.T) {
+	processor := NewTMultiplexedProcessor()
+	for _, name := range []string{"foo", "bar"} {
+		processor.RegisterProcessor(name, &mockProcessor{})
+	}
+	if services := processor.Services(); !reflect.DeepEqual(services, []string{"bar", "foo"}) {
+		t.Errorf("Wrong services %v", services)
+	}
+
+	ctx := context.Background()
+	name := "baz" + MULTIPLEXED_SEPARATOR + "test"
+	ok, err := processor.Process(ctx, NewStoredMessageProtocol(nil, name, CALL, 1), nil)
+	if ok || !errors.Is(err, ErrUnknownService) {
+		t.Errorf("Expected ErrUnknownService closing the connection, got %v, %v", ok, err)
+	}
+
+	var fallbackName string
+	processor.RegisterFallback(&mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			fallbackName, _, _, _ = in.ReadMessageBegin(ctx)
+			return true, nil
+		},
+	})
+	ok, err = processor.Process(ctx, NewStoredMessageProtocol(nil, name, CALL, 1), nil)
+	if !ok || err != nil {
+		t.Errorf("Unexpected fallback result %v, %v", ok, err)
+	}
+	if fallbackName != name {
+		t.Errorf("Expected the fallback processor to get %q, got %q", name, fallbackName)
+	}
+}
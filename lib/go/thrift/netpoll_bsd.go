@@ -0,0 +1,99 @@
+//go:build (darwin || dragonfly || freebsd || netbsd || openbsd) && thrift_netpoll
+// +build darwin dragonfly freebsd netbsd openbsd
+// +build thrift_netpoll
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"syscall"
+)
+
+// tKqueue is the tPoller of macOS and the BSDs, level-triggered.
+type tKqueue struct {
+	fd     int
+	events []syscall.Kevent_t
+}
+
+func newTPoller() (tPoller, error) {
+	fd, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	return &tKqueue{fd: fd}, nil
+}
+
+func (p *tKqueue) add(fd int) error {
+	return p.watch(fd, true, false)
+}
+
+func (p *tKqueue) watch(fd int, read, write bool) error {
+	// EV_ADD is set in both cases, so that a filter can be disabled before
+	// it was ever enabled.
+	flags := func(enable bool) int {
+		if enable {
+			return syscall.EV_ADD | syscall.EV_ENABLE
+		}
+		return syscall.EV_ADD | syscall.EV_DISABLE
+	}
+	changes := make([]syscall.Kevent_t, 2)
+	syscall.SetKevent(&changes[0], fd, syscall.EVFILT_READ, flags(read))
+	syscall.SetKevent(&changes[1], fd, syscall.EVFILT_WRITE, flags(write))
+	_, err := syscall.Kevent(p.fd, changes, nil, nil)
+	return err
+}
+
+func (p *tKqueue) remove(fd int) error {
+	changes := make([]syscall.Kevent_t, 2)
+	syscall.SetKevent(&changes[0], fd, syscall.EVFILT_READ, syscall.EV_DELETE)
+	syscall.SetKevent(&changes[1], fd, syscall.EVFILT_WRITE, syscall.EV_DELETE)
+	_, err := syscall.Kevent(p.fd, changes, nil, nil)
+	return err
+}
+
+func (p *tKqueue) wait(events []tPollEvent) (int, error) {
+	if len(p.events) < len(events) {
+		p.events = make([]syscall.Kevent_t, len(events))
+	}
+	for {
+		n, err := syscall.Kevent(p.fd, nil, p.events[:len(events)], nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		for i, ev := range p.events[:n] {
+			events[i] = tPollEvent{
+				fd:       int(ev.Ident),
+				readable: ev.Filter == syscall.EVFILT_READ,
+				writable: ev.Filter == syscall.EVFILT_WRITE,
+				hangup:   ev.Flags&syscall.EV_ERROR != 0,
+			}
+		}
+		return n, nil
+	}
+}
+
+func (p *tKqueue) close() error {
+	return syscall.Close(p.fd)
+}
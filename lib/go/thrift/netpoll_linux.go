@@ -0,0 +1,94 @@
+//go:build linux && thrift_netpoll
+// +build linux,thrift_netpoll
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"syscall"
+)
+
+// tEpoll is the tPoller of Linux, level-triggered.
+type tEpoll struct {
+	fd     int
+	events []syscall.EpollEvent
+}
+
+func newTPoller() (tPoller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &tEpoll{fd: fd}, nil
+}
+
+func (p *tEpoll) add(fd int) error {
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
+		Events: syscall.EPOLLIN,
+		Fd:     int32(fd),
+	})
+}
+
+func (p *tEpoll) watch(fd int, read, write bool) error {
+	var events uint32
+	if read {
+		events |= syscall.EPOLLIN
+	}
+	if write {
+		events |= syscall.EPOLLOUT
+	}
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{
+		Events: events,
+		Fd:     int32(fd),
+	})
+}
+
+func (p *tEpoll) remove(fd int) error {
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, &syscall.EpollEvent{})
+}
+
+func (p *tEpoll) wait(events []tPollEvent) (int, error) {
+	if len(p.events) < len(events) {
+		p.events = make([]syscall.EpollEvent, len(events))
+	}
+	for {
+		n, err := syscall.EpollWait(p.fd, p.events[:len(events)], -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		for i, ev := range p.events[:n] {
+			events[i] = tPollEvent{
+				fd:       int(ev.Fd),
+				readable: ev.Events&syscall.EPOLLIN != 0,
+				writable: ev.Events&syscall.EPOLLOUT != 0,
+				hangup:   ev.Events&(syscall.EPOLLHUP|syscall.EPOLLERR) != 0,
+			}
+		}
+		return n, nil
+	}
+}
+
+func (p *tEpoll) close() error {
+	return syscall.Close(p.fd)
+}
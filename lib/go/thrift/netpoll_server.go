@@ -0,0 +1,631 @@
+//go:build (linux || darwin || dragonfly || freebsd || netbsd || openbsd) && thrift_netpoll
+// +build linux darwin dragonfly freebsd netbsd openbsd
+// +build thrift_netpoll
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// The netpoll server is EXPERIMENTAL, and only built when the thrift_netpoll
+// build tag is set:
+//
+//	go build -tags thrift_netpoll
+//
+// It uses epoll on Linux and kqueue on macOS and the BSDs.
+
+// tPollEvent is an event reported by a tPoller.
+type tPollEvent struct {
+	fd       int
+	readable bool
+	writable bool
+	// hangup is set when the connection is closed or in error, it's
+	// reported even for file descriptors not watched for anything.
+	hangup bool
+}
+
+// tPoller is a level-triggered epoll or kqueue instance.
+//
+// add, watch and remove are safe to call concurrently with wait.
+type tPoller interface {
+	// add starts watching fd for readability.
+	add(fd int) error
+	// watch changes the events fd is watched for.
+	watch(fd int, read, write bool) error
+	// remove stops watching fd.
+	remove(fd int) error
+	// wait blocks until there are events, and fills events with them.
+	wait(events []tPollEvent) (int, error)
+	close() error
+}
+
+// tNetpollReadBufferSize is the size of the buffer shared by all the
+// connections of an event loop to read from.
+const tNetpollReadBufferSize = 64 * 1024
+
+// TNetpollServer is a nonblocking TServer multiplexing all its connections on
+// a small number of event loops, instead of having one goroutine per
+// connection like TSimpleServer, for servers with a large number of mostly
+// idle connections.
+//
+// The event loops read whole frames before dispatching them, so the clients
+// must use TFramedTransport. A goroutine is only started for every request
+// being processed, and a connection doesn't hold any buffer while it's idle.
+// The requests of a connection are processed one at a time, in order.
+//
+// The connections accepted by the server transport must be backed by a file
+// descriptor, like the TSockets accepted by TServerSocket. TLS connections are
+// not supported.
+type TNetpollServer struct {
+	processorFactory TProcessorFactory
+	serverTransport  TServerTransport
+	protocolFactory  TProtocolFactory
+	cfg              *TConfiguration
+
+	logger      Logger
+	eventLoops  int
+	pingReplies bool
+
+	closed  int32
+	mu      sync.Mutex
+	loops   []*tNetpollLoop
+	loopsWG sync.WaitGroup
+	wg      sync.WaitGroup
+	next    uint32
+}
+
+// NewTNetpollServer creates a TNetpollServer serving processor, with the
+// connections accepted by serverTransport.
+//
+// conf is propagated to the protocols of every request, its MaxFrameSize
+// limits the size of the frames read from the clients.
+func NewTNetpollServer(processor TProcessor, serverTransport TServerTransport, protocolFactory TProtocolFactory, conf *TConfiguration) *TNetpollServer {
+	return &TNetpollServer{
+		processorFactory: NewTProcessorFactory(processor),
+		serverTransport:  serverTransport,
+		protocolFactory:  protocolFactory,
+		cfg:              conf,
+		eventLoops:       runtime.GOMAXPROCS(0),
+	}
+}
+
+func (p *TNetpollServer) ProcessorFactory() TProcessorFactory {
+	return p.processorFactory
+}
+
+func (p *TNetpollServer) ServerTransport() TServerTransport {
+	return p.serverTransport
+}
+
+// InputTransportFactory returns the TFramedTransportFactory the server reads
+// the requests with.
+func (p *TNetpollServer) InputTransportFactory() TTransportFactory {
+	return NewTFramedTransportFactoryConf(NewTTransportFactory(), p.cfg)
+}
+
+// OutputTransportFactory returns the TFramedTransportFactory the server writes
+// the responses with.
+func (p *TNetpollServer) OutputTransportFactory() TTransportFactory {
+	return NewTFramedTransportFactoryConf(NewTTransportFactory(), p.cfg)
+}
+
+func (p *TNetpollServer) InputProtocolFactory() TProtocolFactory {
+	return p.protocolFactory
+}
+
+func (p *TNetpollServer) OutputProtocolFactory() TProtocolFactory {
+	return p.protocolFactory
+}
+
+// SetLogger sets the logger used by this server.
+//
+// If no logger was set before Serve is called, a default logger using standard
+// log library will be used instead.
+func (p *TNetpollServer) SetLogger(logger Logger) {
+	p.logger = logger
+}
+
+// SetEventLoops sets the number of event loops, runtime.GOMAXPROCS(0) by
+// default.
+//
+// It must be called before Serve or AcceptLoop.
+func (p *TNetpollServer) SetEventLoops(n int) {
+	if n < 1 {
+		n = 1
+	}
+	p.eventLoops = n
+}
+
+// SetPingReplies makes the server reply to the pings of the clients, see
+// Ping, without calling its processor.
+//
+// It must be called before Serve or AcceptLoop.
+func (p *TNetpollServer) SetPingReplies(enabled bool) {
+	p.pingReplies = enabled
+}
+
+// Connections returns the number of open connections.
+func (p *TNetpollServer) Connections() int {
+	p.mu.Lock()
+	loops := p.loops
+	p.mu.Unlock()
+	var n int
+	for _, l := range loops {
+		l.mu.Lock()
+		n += len(l.conns)
+		l.mu.Unlock()
+	}
+	return n
+}
+
+func (p *TNetpollServer) Listen() error {
+	return p.serverTransport.Listen()
+}
+
+// AcceptLoop starts the event loops, and hands them the connections accepted
+// by the server transport until the server is stopped.
+func (p *TNetpollServer) AcceptLoop() error {
+	p.logger = fallbackLogger(p.logger)
+	if err := p.startLoops(); err != nil {
+		return err
+	}
+	for {
+		client, err := p.serverTransport.Accept()
+		if atomic.LoadInt32(&p.closed) != 0 {
+			if client != nil {
+				client.Close()
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if client == nil {
+			continue
+		}
+		if err := p.register(client); err != nil {
+			p.logger(fmt.Sprintf("error registering connection: %v", err))
+			client.Close()
+		}
+	}
+}
+
+func (p *TNetpollServer) Serve() error {
+	err := p.Listen()
+	if err != nil {
+		return err
+	}
+	return p.AcceptLoop()
+}
+
+// Stop stops accepting connections, closes all the connections and waits for
+// the requests being processed to return.
+func (p *TNetpollServer) Stop() error {
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		return nil
+	}
+	p.serverTransport.Interrupt()
+
+	p.mu.Lock()
+	loops := p.loops
+	p.mu.Unlock()
+	for _, l := range loops {
+		l.wakeup()
+	}
+	p.loopsWG.Wait()
+	for _, l := range loops {
+		l.closeConns()
+	}
+	p.wg.Wait()
+	for _, l := range loops {
+		l.close()
+	}
+	return nil
+}
+
+func (p *TNetpollServer) startLoops() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.loops != nil {
+		return nil
+	}
+	loops := make([]*tNetpollLoop, 0, p.eventLoops)
+	for i := 0; i < p.eventLoops; i++ {
+		l, err := newTNetpollLoop(p)
+		if err != nil {
+			for _, l := range loops {
+				l.close()
+			}
+			return err
+		}
+		loops = append(loops, l)
+	}
+	p.loops = loops
+	for _, l := range loops {
+		p.loopsWG.Add(1)
+		go func(l *tNetpollLoop) {
+			defer p.loopsWG.Done()
+			if err := l.run(); err != nil {
+				p.logger(fmt.Sprintf("netpoll event loop error: %v", err))
+			}
+		}(l)
+	}
+	return nil
+}
+
+// register hands client to one of the event loops.
+func (p *TNetpollServer) register(client TTransport) error {
+	var conn net.Conn
+	if c, ok := client.(interface{ Conn() net.Conn }); ok {
+		conn = c.Conn()
+	}
+	if sc, ok := conn.(*socketConn); ok {
+		conn = sc.Conn
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return NewTTransportException(NOT_OPEN, "connection is not backed by a file descriptor")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fd int
+	if err := rc.Control(func(f uintptr) {
+		fd = int(f)
+	}); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	loops := p.loops
+	p.mu.Unlock()
+	l := loops[atomic.AddUint32(&p.next, 1)%uint32(len(loops))]
+	return l.add(&tNetpollConn{
+		loop:   l,
+		client: client,
+		fd:     fd,
+		ctx:    AddPeerToContext(defaultCtx, client),
+	})
+}
+
+// tNetpollLoop is an event loop, reading the requests of its connections.
+type tNetpollLoop struct {
+	server *TNetpollServer
+	poller tPoller
+	wakeR  int
+	wakeW  int
+	buf    []byte
+
+	mu    sync.Mutex
+	conns map[int]*tNetpollConn
+}
+
+func newTNetpollLoop(server *TNetpollServer) (*tNetpollLoop, error) {
+	poller, err := newTPoller()
+	if err != nil {
+		return nil, err
+	}
+	var wake [2]int
+	if err := syscall.Pipe(wake[:]); err != nil {
+		poller.close()
+		return nil, err
+	}
+	l := &tNetpollLoop{
+		server: server,
+		poller: poller,
+		wakeR:  wake[0],
+		wakeW:  wake[1],
+		buf:    make([]byte, tNetpollReadBufferSize),
+		conns:  make(map[int]*tNetpollConn),
+	}
+	for _, fd := range wake {
+		syscall.CloseOnExec(fd)
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			l.close()
+			return nil, err
+		}
+	}
+	if err := poller.add(l.wakeR); err != nil {
+		l.close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *tNetpollLoop) add(c *tNetpollConn) error {
+	l.mu.Lock()
+	l.conns[c.fd] = c
+	l.mu.Unlock()
+	if err := l.poller.add(c.fd); err != nil {
+		l.mu.Lock()
+		delete(l.conns, c.fd)
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// remove must be called before closing the file descriptor of c, so that it
+// can't be reused by another connection in the meantime.
+func (l *tNetpollLoop) remove(c *tNetpollConn) {
+	l.poller.remove(c.fd)
+	l.mu.Lock()
+	if l.conns[c.fd] == c {
+		delete(l.conns, c.fd)
+	}
+	l.mu.Unlock()
+}
+
+func (l *tNetpollLoop) run() error {
+	events := make([]tPollEvent, 256)
+	for {
+		n, err := l.poller.wait(events)
+		if err != nil {
+			return err
+		}
+		for _, ev := range events[:n] {
+			if ev.fd == l.wakeR {
+				if atomic.LoadInt32(&l.server.closed) != 0 {
+					return nil
+				}
+				syscall.Read(l.wakeR, l.buf)
+				continue
+			}
+			l.mu.Lock()
+			c := l.conns[ev.fd]
+			l.mu.Unlock()
+			if c == nil {
+				continue
+			}
+			switch {
+			case ev.writable:
+				c.handleWritable()
+			case ev.readable:
+				c.handleReadable(l.buf)
+			case ev.hangup:
+				c.close()
+			}
+		}
+	}
+}
+
+// wakeup interrupts the pending wait of the event loop.
+func (l *tNetpollLoop) wakeup() {
+	syscall.Write(l.wakeW, []byte{0})
+}
+
+func (l *tNetpollLoop) closeConns() {
+	l.mu.Lock()
+	conns := make([]*tNetpollConn, 0, len(l.conns))
+	for _, c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.mu.Unlock()
+	for _, c := range conns {
+		c.close()
+	}
+}
+
+func (l *tNetpollLoop) close() {
+	l.poller.close()
+	syscall.Close(l.wakeR)
+	syscall.Close(l.wakeW)
+}
+
+// tNetpollConn is a connection of a tNetpollLoop.
+type tNetpollConn struct {
+	loop   *tNetpollLoop
+	client TTransport
+	fd     int
+	ctx    context.Context
+
+	mu sync.Mutex
+	// in holds the bytes read and not processed yet, out the bytes of the
+	// response not written yet. They are nil while the connection is idle.
+	in     []byte
+	out    []byte
+	busy   bool
+	closed bool
+}
+
+// handleReadable reads from the connection until there's a whole frame or
+// nothing left to read, and dispatches the frame if any.
+//
+// It's only called by the event loop, buf is its read buffer.
+func (c *tNetpollConn) handleReadable(buf []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.busy {
+		return
+	}
+	for {
+		if size, err := c.frameSize(); err != nil {
+			c.loop.server.logger(err.Error())
+			c.closeLocked()
+			return
+		} else if size >= 0 {
+			break
+		}
+		n, err := syscall.Read(c.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil || n == 0 {
+			c.closeLocked()
+			return
+		}
+		c.in = append(c.in, buf[:n]...)
+	}
+	c.dispatchLocked()
+}
+
+// frameSize returns the size of the first frame in c.in, or -1 if it's not
+// complete yet.
+func (c *tNetpollConn) frameSize() (int, error) {
+	if len(c.in) < 4 {
+		return -1, nil
+	}
+	size := int32(binary.BigEndian.Uint32(c.in))
+	if size < 0 || size > c.loop.server.cfg.GetMaxFrameSize() {
+		return -1, NewTTransportException(UNKNOWN_TRANSPORT_EXCEPTION, fmt.Sprintf("invalid frame size: %d", size))
+	}
+	if len(c.in) < 4+int(size) {
+		return -1, nil
+	}
+	return int(size), nil
+}
+
+// dispatchLocked processes the first frame in c.in if it's complete,
+// otherwise it watches the connection for the rest of it.
+func (c *tNetpollConn) dispatchLocked() {
+	size, _ := c.frameSize()
+	if size < 0 {
+		if err := c.loop.poller.watch(c.fd, true, false); err != nil {
+			c.closeLocked()
+		}
+		return
+	}
+	frame := make([]byte, size)
+	copy(frame, c.in[4:])
+	c.in = c.in[4+size:]
+	if len(c.in) == 0 {
+		c.in = nil
+	}
+	if err := c.loop.poller.watch(c.fd, false, false); err != nil {
+		c.closeLocked()
+		return
+	}
+	c.busy = true
+	c.loop.server.wg.Add(1)
+	go func() {
+		defer c.loop.server.wg.Done()
+		c.process(frame)
+	}()
+}
+
+// process processes a request and writes its response.
+func (c *tNetpollConn) process(frame []byte) {
+	server := c.loop.server
+	in := &TMemoryBuffer{Buffer: bytes.NewBuffer(frame)}
+	out := NewTMemoryBuffer()
+	// Room for the frame size.
+	out.Write(make([]byte, 4))
+	inputProtocol := server.protocolFactory.GetProtocol(in)
+	outputProtocol := server.protocolFactory.GetProtocol(out)
+	PropagateTConfiguration(inputProtocol, server.cfg)
+	PropagateTConfiguration(outputProtocol, server.cfg)
+
+	processor := server.processorFactory.GetProcessor(c.client)
+	if server.pingReplies {
+		processor = &tPingProcessor{TProcessor: processor}
+	}
+	ok, err := processor.Process(c.ctx, inputProtocol, outputProtocol)
+	if errors.Is(err, ErrAbandonRequest) || errors.As(err, new(TTransportException)) || !ok {
+		c.close()
+		return
+	}
+
+	resp := out.Bytes()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if len(resp) > 4 {
+		binary.BigEndian.PutUint32(resp, uint32(len(resp)-4))
+		c.out = resp
+	}
+	c.flushLocked()
+}
+
+// handleWritable writes the rest of the response.
+//
+// It's only called by the event loop.
+func (c *tNetpollConn) handleWritable() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.flushLocked()
+}
+
+// flushLocked writes c.out until it's all written, in which case the next
+// request is read, or the connection is not writable anymore, in which case
+// the connection is watched for writability.
+func (c *tNetpollConn) flushLocked() {
+	for len(c.out) > 0 {
+		n, err := syscall.Write(c.fd, c.out)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			if err := c.loop.poller.watch(c.fd, false, true); err != nil {
+				c.closeLocked()
+			}
+			return
+		}
+		if err != nil {
+			c.closeLocked()
+			return
+		}
+		c.out = c.out[n:]
+	}
+	c.out = nil
+	c.busy = false
+	c.dispatchLocked()
+}
+
+func (c *tNetpollConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked()
+}
+
+func (c *tNetpollConn) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.in = nil
+	c.out = nil
+	c.loop.remove(c)
+	c.client.Close()
+}
+
+var (
+	_ TServer = (*TNetpollServer)(nil)
+)
@@ -0,0 +1,122 @@
+//go:build (linux || darwin || dragonfly || freebsd || netbsd || openbsd) && thrift_netpoll
+// +build linux darwin dragonfly freebsd netbsd openbsd
+// +build thrift_netpoll
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startTestNetpollServer(t *testing.T, conf *TConfiguration) (*TNetpollServer, string) {
+	t.Helper()
+	serverTrans, err := NewTServerSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serv := NewTNetpollServer(echoProcessor(), serverTrans, NewTBinaryProtocolFactoryConf(nil), conf)
+	serv.SetLogger(NopLogger)
+	serv.SetEventLoops(2)
+	if err := serv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go serv.AcceptLoop()
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	return serv, serverTrans.Addr().String()
+}
+
+func dialTestNetpollServer(t *testing.T, addr string) TProtocol {
+	t.Helper()
+	return NewTBinaryProtocolConf(NewTFramedTransportConf(dialTestSocketServer(t, addr), nil), nil)
+}
+
+func waitForNetpollConnections(t *testing.T, serv *TNetpollServer, expected int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for serv.Connections() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d connections, got %d", expected, serv.Connections())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNetpollServerIdleConnections(t *testing.T) {
+	serv, addr := startTestNetpollServer(t, nil)
+
+	const conns = 200
+	goroutines := runtime.NumGoroutine()
+	protos := make([]TProtocol, conns)
+	for i := range protos {
+		protos[i] = dialTestNetpollServer(t, addr)
+	}
+	waitForNetpollConnections(t, serv, conns)
+	// Idle connections don't have their own goroutine.
+	if n := runtime.NumGoroutine(); n > goroutines+conns/10 {
+		t.Errorf("expected idle connections not to start goroutines, got %d more", n-goroutines)
+	}
+
+	for seqID := int32(1); seqID <= 2; seqID++ {
+		for i, proto := range protos {
+			if err := echoCall(t, proto, seqID, strings.Repeat("x", i)); err != nil {
+				t.Fatalf("connection %d: %v", i, err)
+			}
+		}
+	}
+	// A large request, read and written in multiple parts.
+	if err := echoCall(t, protos[0], 3, strings.Repeat("y", 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetpollServerMaxFrameSize(t *testing.T) {
+	serv, addr := startTestNetpollServer(t, &TConfiguration{MaxFrameSize: 100})
+
+	if err := echoCall(t, dialTestNetpollServer(t, addr), 1, "small"); err != nil {
+		t.Fatal(err)
+	}
+	if err := echoCall(t, dialTestNetpollServer(t, addr), 1, strings.Repeat("x", 200)); err == nil {
+		t.Error("expected the connection to be closed on a frame over MaxFrameSize")
+	}
+	waitForNetpollConnections(t, serv, 1)
+}
+
+func TestNetpollServerStop(t *testing.T) {
+	serv, addr := startTestNetpollServer(t, nil)
+
+	proto := dialTestNetpollServer(t, addr)
+	if err := echoCall(t, proto, 1, "before"); err != nil {
+		t.Fatal(err)
+	}
+	serv.Stop()
+	if serv.Connections() != 0 {
+		t.Errorf("expected all connections to be closed, got %d", serv.Connections())
+	}
+	if err := echoCall(t, proto, 2, "after"); err == nil {
+		t.Error("expected the connection to be closed by Stop")
+	}
+}
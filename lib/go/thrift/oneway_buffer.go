@@ -0,0 +1,395 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Default values of BufferedOnewayOptions.
+const (
+	DefaultOnewayBufferSize     = 10000
+	DefaultOnewayReplayInterval = time.Second
+)
+
+// OnewayDropPolicy is which call a TBufferedOnewayClient drops when its
+// buffer is full.
+type OnewayDropPolicy int
+
+const (
+	// OnewayDropOldest drops the oldest call buffered to buffer the new
+	// one, the default.
+	OnewayDropOldest OnewayDropPolicy = iota
+
+	// OnewayDropNewest drops the new call.
+	OnewayDropNewest
+)
+
+// TOnewayCall is a oneway call buffered by a TBufferedOnewayClient.
+type TOnewayCall struct {
+	Method string `json:"method"`
+
+	// Args is the argument struct of the call, encoded with
+	// TBinaryProtocol.
+	Args []byte `json:"args"`
+
+	// Headers are the THeader headers sent with the call, see
+	// AppendOutgoingHeader.
+	Headers THeaderMap `json:"headers,omitempty"`
+}
+
+// TOnewayStore stores the calls buffered by a TBufferedOnewayClient, in the
+// order they were made. Its methods are not called concurrently.
+type TOnewayStore interface {
+	// Push appends call.
+	Push(call TOnewayCall) error
+
+	// Front returns the oldest call, false if there is none.
+	Front() (TOnewayCall, bool)
+
+	// Pop removes the oldest call.
+	Pop() error
+
+	// Len returns the number of calls.
+	Len() int
+
+	Close() error
+}
+
+// BufferedOnewayOptions configures NewTBufferedOnewayClient.
+type BufferedOnewayOptions struct {
+	// MaxCalls is the maximum number of calls buffered,
+	// DefaultOnewayBufferSize if 0.
+	MaxCalls int
+
+	// Drop is the call dropped when MaxCalls calls are buffered.
+	Drop OnewayDropPolicy
+
+	// Store stores the buffered calls, NewTMemoryOnewayStore() if nil. It
+	// is closed by Close.
+	Store TOnewayStore
+
+	// ReplayInterval is the interval between the attempts to replay the
+	// buffered calls, DefaultOnewayReplayInterval if 0.
+	ReplayInterval time.Duration
+
+	// Reconnect is called before replaying the buffered calls once a call
+	// failed, to reopen the connection of the client, e.g. by closing and
+	// opening its socket. The calls are replayed if it returns nil.
+	//
+	// If nil, the calls are replayed with the client as is, e.g. a
+	// TStandardClient opening its socket when it's closed.
+	Reconnect func() error
+
+	// OnDrop is called for every call dropped, for example to record
+	// metrics.
+	OnDrop func(call TOnewayCall)
+}
+
+// OnewayBufferStats are the counters of a TBufferedOnewayClient.
+type OnewayBufferStats struct {
+	// Buffered is the number of calls currently buffered.
+	Buffered int
+
+	// Sent is the number of calls sent without being buffered, and
+	// Replayed the number of buffered calls sent.
+	Sent     int64
+	Replayed int64
+
+	// Dropped is the number of calls dropped because the buffer was full.
+	Dropped int64
+
+	// Failed is the number of buffered calls dropped because they failed
+	// with an error other than a connection error when replayed.
+	Failed int64
+}
+
+// TBufferedOnewayClient is a TClient buffering the oneway calls while the
+// connection of the wrapped client is down, and replaying them in the
+// background once it's back, for the clients that can't block nor fail on
+// every outage, such as telemetry emitters.
+//
+// A oneway call is buffered when it fails with a TTransportException or
+// another error IsRetryableError accepts, and while other calls are
+// buffered, to keep them in order. The buffered calls succeed. The other
+// errors are returned, and the calls with a result are not buffered.
+//
+// The calls are sent with the lock of the client held, so the wrapped client
+// doesn't need to be safe for concurrent use.
+type TBufferedOnewayClient struct {
+	client TClient
+	opts   BufferedOnewayOptions
+
+	// sendMu serializes the calls of client.
+	sendMu sync.Mutex
+
+	mu    sync.Mutex
+	store TOnewayStore
+	// down is true once a call failed with a connection error, until the
+	// buffered calls are replayed.
+	down bool
+	// frontDropped is set when the oldest call is dropped, which is the
+	// call being replayed if any.
+	frontDropped bool
+	stats        OnewayBufferStats
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTBufferedOnewayClient returns a TBufferedOnewayClient wrapping client,
+// and starts the goroutine replaying the buffered calls, stopped by Close.
+//
+// The calls already in the Store of opts, e.g. a file store written by a
+// previous process, are replayed.
+func NewTBufferedOnewayClient(client TClient, opts BufferedOnewayOptions) *TBufferedOnewayClient {
+	if opts.MaxCalls <= 0 {
+		opts.MaxCalls = DefaultOnewayBufferSize
+	}
+	if opts.ReplayInterval <= 0 {
+		opts.ReplayInterval = DefaultOnewayReplayInterval
+	}
+	if opts.Store == nil {
+		opts.Store = NewTMemoryOnewayStore()
+	}
+	c := &TBufferedOnewayClient{
+		client: client,
+		opts:   opts,
+		store:  opts.Store,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.replayLoop()
+	return c
+}
+
+// Call implements TClient.
+func (c *TBufferedOnewayClient) Call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	if result != nil {
+		c.sendMu.Lock()
+		defer c.sendMu.Unlock()
+		return c.client.Call(ctx, method, args, result)
+	}
+
+	c.mu.Lock()
+	direct := !c.down && c.store.Len() == 0
+	c.mu.Unlock()
+	if direct {
+		c.sendMu.Lock()
+		_, err := c.client.Call(ctx, method, args, nil)
+		c.sendMu.Unlock()
+		if !isConnectionError(err) {
+			if err == nil {
+				c.mu.Lock()
+				c.stats.Sent++
+				c.mu.Unlock()
+			}
+			return ResponseMeta{}, err
+		}
+	}
+
+	call := TOnewayCall{
+		Method:  method,
+		Headers: OutgoingHeadersFromContext(ctx),
+	}
+	buf := NewTMemoryBuffer()
+	if err := args.Write(ctx, NewTBinaryProtocolConf(buf, nil)); err != nil {
+		return ResponseMeta{}, err
+	}
+	call.Args = buf.Bytes()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if direct {
+		c.down = true
+	}
+	return ResponseMeta{}, c.push(call)
+}
+
+// push buffers call, c.mu must be held.
+func (c *TBufferedOnewayClient) push(call TOnewayCall) error {
+	if c.store.Len() >= c.opts.MaxCalls {
+		dropped := call
+		if c.opts.Drop == OnewayDropOldest {
+			dropped, _ = c.store.Front()
+			if err := c.store.Pop(); err != nil {
+				return err
+			}
+			c.frontDropped = true
+		}
+		c.stats.Dropped++
+		if c.opts.OnDrop != nil {
+			c.opts.OnDrop(dropped)
+		}
+		if c.opts.Drop != OnewayDropOldest {
+			return nil
+		}
+	}
+	return c.store.Push(call)
+}
+
+// Flush replays the buffered calls now, returning the error of the call that
+// couldn't be sent if any, or of the Reconnect.
+func (c *TBufferedOnewayClient) Flush(ctx context.Context) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.mu.Lock()
+	down := c.down
+	c.mu.Unlock()
+	if down && c.opts.Reconnect != nil {
+		if err := c.opts.Reconnect(); err != nil {
+			return err
+		}
+	}
+	for {
+		c.mu.Lock()
+		call, ok := c.store.Front()
+		if !ok {
+			c.down = false
+			c.mu.Unlock()
+			return nil
+		}
+		c.frontDropped = false
+		c.mu.Unlock()
+
+		replayCtx := ctx
+		for key, value := range call.Headers {
+			replayCtx = AppendOutgoingHeader(replayCtx, key, value)
+		}
+		_, err := c.client.Call(replayCtx, call.Method, &tRawStruct{data: call.Args}, nil)
+
+		c.mu.Lock()
+		if isConnectionError(err) {
+			c.down = true
+			c.mu.Unlock()
+			return err
+		}
+		if err != nil {
+			c.stats.Failed++
+		} else {
+			c.stats.Replayed++
+		}
+		// The call may have been dropped meanwhile, in which case the
+		// store has a new front.
+		if !c.frontDropped {
+			if err := c.store.Pop(); err != nil {
+				c.mu.Unlock()
+				return err
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// replayLoop replays the buffered calls every ReplayInterval until Close is
+// called.
+func (c *TBufferedOnewayClient) replayLoop() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.opts.ReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		buffered := c.store.Len()
+		c.mu.Unlock()
+		if buffered > 0 {
+			c.Flush(context.Background())
+		}
+	}
+}
+
+// Stats returns the counters of c.
+func (c *TBufferedOnewayClient) Stats() OnewayBufferStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Buffered = c.store.Len()
+	return stats
+}
+
+// Close stops replaying the buffered calls and closes the Store. The calls
+// still buffered in memory are lost, Flush can be called first to send them.
+// It doesn't close the wrapped client.
+func (c *TBufferedOnewayClient) Close() error {
+	close(c.stop)
+	<-c.done
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.store.Close()
+}
+
+// isConnectionError reports whether err is the error of a call that couldn't
+// be sent because of its connection.
+func isConnectionError(err error) bool {
+	return err != nil && (errors.As(err, new(TTransportException)) || IsRetryableError(err))
+}
+
+// NewTMemoryOnewayStore returns a TOnewayStore keeping the calls in memory.
+func NewTMemoryOnewayStore() TOnewayStore {
+	return &tMemoryOnewayStore{}
+}
+
+// tMemoryOnewayStore is the TOnewayStore returned by NewTMemoryOnewayStore.
+type tMemoryOnewayStore struct {
+	calls []TOnewayCall
+}
+
+func (s *tMemoryOnewayStore) Push(call TOnewayCall) error {
+	s.calls = append(s.calls, call)
+	return nil
+}
+
+func (s *tMemoryOnewayStore) Front() (TOnewayCall, bool) {
+	if len(s.calls) == 0 {
+		return TOnewayCall{}, false
+	}
+	return s.calls[0], true
+}
+
+func (s *tMemoryOnewayStore) Pop() error {
+	if len(s.calls) > 0 {
+		s.calls[0] = TOnewayCall{}
+		s.calls = s.calls[1:]
+	}
+	return nil
+}
+
+func (s *tMemoryOnewayStore) Len() int {
+	return len(s.calls)
+}
+
+func (s *tMemoryOnewayStore) Close() error {
+	return nil
+}
+
+var (
+	_ TClient      = (*TBufferedOnewayClient)(nil)
+	_ TOnewayStore = (*tMemoryOnewayStore)(nil)
+)
@@ -0,0 +1,263 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// onewayTestClient records the values of the pipelinedString arguments of
+// its calls, and fails them with err.
+type onewayTestClient struct {
+	mu      sync.Mutex
+	err     error
+	values  []string
+	headers []THeaderMap
+}
+
+func (c *onewayTestClient) Call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return ResponseMeta{}, c.err
+	}
+	buf := NewTMemoryBuffer()
+	if err := args.Write(ctx, NewTBinaryProtocolConf(buf, nil)); err != nil {
+		return ResponseMeta{}, err
+	}
+	var value pipelinedString
+	if err := value.Read(ctx, NewTBinaryProtocolConf(buf, nil)); err != nil {
+		return ResponseMeta{}, err
+	}
+	c.values = append(c.values, value.Value)
+	c.headers = append(c.headers, OutgoingHeadersFromContext(ctx))
+	return ResponseMeta{}, nil
+}
+
+func (c *onewayTestClient) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func (c *onewayTestClient) sent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.values...)
+}
+
+func onewayCall(t *testing.T, c TClient, value string) {
+	t.Helper()
+	if _, err := c.Call(context.Background(), "emit", &pipelinedString{Value: value}, nil); err != nil {
+		t.Fatalf("%s: %v", value, err)
+	}
+}
+
+var errTestNotOpen = NewTTransportException(NOT_OPEN, "connection down")
+
+func TestBufferedOnewayClient(t *testing.T) {
+	inner := &onewayTestClient{}
+	c := NewTBufferedOnewayClient(inner, BufferedOnewayOptions{
+		ReplayInterval: time.Hour,
+	})
+	defer c.Close()
+
+	onewayCall(t, c, "a")
+	inner.setErr(errTestNotOpen)
+	ctx := AppendOutgoingHeader(context.Background(), "k", "v")
+	if _, err := c.Call(ctx, "emit", &pipelinedString{Value: "b"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	onewayCall(t, c, "c")
+
+	// The calls with a result are not buffered.
+	if _, err := c.Call(context.Background(), "get", &pipelinedString{}, &pipelinedString{}); err != errTestNotOpen {
+		t.Errorf("expected the error of the two-way call, got %v", err)
+	}
+	if err := c.Flush(context.Background()); err != errTestNotOpen {
+		t.Errorf("expected Flush to fail with the connection error, got %v", err)
+	}
+
+	inner.setErr(nil)
+	// Calls are buffered while the connection is considered down, to keep
+	// them in order.
+	onewayCall(t, c, "d")
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	onewayCall(t, c, "e")
+	if sent := inner.sent(); !reflect.DeepEqual(sent, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("unexpected calls %q", sent)
+	}
+	if inner.headers[1]["k"] != "v" {
+		t.Errorf("expected the headers of the replayed call, got %v", inner.headers[1])
+	}
+	expected := OnewayBufferStats{Sent: 2, Replayed: 3}
+	if stats := c.Stats(); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+
+	// The other errors are returned.
+	inner.setErr(errors.New("bad args"))
+	if _, err := c.Call(context.Background(), "emit", &pipelinedString{}, nil); err == nil {
+		t.Error("expected the error of the call")
+	}
+}
+
+func TestBufferedOnewayClientDrop(t *testing.T) {
+	for _, c := range []struct {
+		policy   OnewayDropPolicy
+		dropped  []string
+		replayed []string
+	}{
+		{OnewayDropOldest, []string{"a", "b"}, []string{"c", "d"}},
+		{OnewayDropNewest, []string{"c", "d"}, []string{"a", "b"}},
+	} {
+		inner := &onewayTestClient{err: errTestNotOpen}
+		var dropped []string
+		client := NewTBufferedOnewayClient(inner, BufferedOnewayOptions{
+			MaxCalls:       2,
+			Drop:           c.policy,
+			ReplayInterval: time.Hour,
+			OnDrop: func(call TOnewayCall) {
+				var value pipelinedString
+				buf := NewTMemoryBuffer()
+				buf.Write(call.Args)
+				value.Read(context.Background(), NewTBinaryProtocolConf(buf, nil))
+				dropped = append(dropped, value.Value)
+			},
+		})
+		for _, value := range []string{"a", "b", "c", "d"} {
+			onewayCall(t, client, value)
+		}
+		inner.setErr(nil)
+		if err := client.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+		if !reflect.DeepEqual(dropped, c.dropped) {
+			t.Errorf("policy %d: expected %q to be dropped, got %q", c.policy, c.dropped, dropped)
+		}
+		if sent := inner.sent(); !reflect.DeepEqual(sent, c.replayed) {
+			t.Errorf("policy %d: expected %q to be replayed, got %q", c.policy, c.replayed, sent)
+		}
+		if stats := client.Stats(); stats.Dropped != 2 || stats.Replayed != 2 {
+			t.Errorf("policy %d: unexpected stats %+v", c.policy, stats)
+		}
+	}
+}
+
+func TestBufferedOnewayClientReplay(t *testing.T) {
+	inner := &onewayTestClient{err: errTestNotOpen}
+	reconnects := make(chan struct{}, 100)
+	c := NewTBufferedOnewayClient(inner, BufferedOnewayOptions{
+		ReplayInterval: 5 * time.Millisecond,
+		Reconnect: func() error {
+			reconnects <- struct{}{}
+			return nil
+		},
+	})
+	defer c.Close()
+
+	onewayCall(t, c, "a")
+	<-reconnects
+	inner.setErr(errors.New("rejected"))
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Stats().Buffered > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the buffered call was not replayed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The calls failing with other errors are dropped.
+	if stats := c.Stats(); stats.Failed != 1 || stats.Replayed != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestFileOnewayStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oneway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "calls")
+
+	store, err := NewTFileOnewayStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"a", "b", "c"} {
+		if err := store.Push(TOnewayCall{Method: method, Args: []byte(method), Headers: THeaderMap{"k": method}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// A partially written record is ignored.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"method":"d"`)
+	f.Close()
+
+	store, err = NewTFileOnewayStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.Len() != 2 {
+		t.Fatalf("expected 2 calls, got %d", store.Len())
+	}
+	call, _ := store.Front()
+	expected := TOnewayCall{Method: "b", Args: []byte("b"), Headers: THeaderMap{"k": "b"}}
+	if !reflect.DeepEqual(call, expected) {
+		t.Errorf("expected %+v, got %+v", expected, call)
+	}
+
+	for i := 0; i < 2*onewayFileCompactRecords; i++ {
+		store.Push(TOnewayCall{Method: "e"})
+		store.Pop()
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 100*onewayFileCompactRecords {
+		t.Errorf("the file was not compacted, %d bytes", info.Size())
+	}
+	store.Pop()
+	store.Pop()
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Errorf("expected an empty file, got %d bytes", info.Size())
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// onewayFileCompactRecords is the number of records over which the file of a
+// file store is rewritten without the records of the popped calls, when it
+// has more than twice as many records as calls.
+const onewayFileCompactRecords = 1024
+
+// tFileOnewayStore is the TOnewayStore returned by NewTFileOnewayStore.
+//
+// The file is a log of JSON records, one per line: a TOnewayCall for every
+// Push, and null for every Pop.
+type tFileOnewayStore struct {
+	tMemoryOnewayStore
+
+	path    string
+	file    *os.File
+	records int
+}
+
+// NewTFileOnewayStore returns a TOnewayStore keeping the calls both in memory
+// and in the file at path, created if needed, so that the calls still
+// buffered when the process exits are replayed by the next one. The calls
+// already in the file are loaded.
+//
+// The file is not synced after every call, so the calls buffered right
+// before a crash of the machine can be lost.
+func NewTFileOnewayStore(path string) (TOnewayStore, error) {
+	s := &tFileOnewayStore{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.rewrite(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the calls of the file, ignoring its last record if it was only
+// partially written.
+func (s *tFileOnewayStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimSpace(line)
+		if bytes.Equal(line, []byte("null")) {
+			s.tMemoryOnewayStore.Pop()
+			continue
+		}
+		var call TOnewayCall
+		if err := json.Unmarshal(line, &call); err != nil {
+			return err
+		}
+		s.tMemoryOnewayStore.Push(call)
+	}
+}
+
+// rewrite replaces the file with one holding only the current calls, and
+// opens it for appending.
+func (s *tFileOnewayStore) rewrite() error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, call := range s.calls {
+		if err := writeOnewayRecord(w, call); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.records = len(s.calls)
+	return nil
+}
+
+func writeOnewayRecord(w io.Writer, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (s *tFileOnewayStore) Push(call TOnewayCall) error {
+	if err := writeOnewayRecord(s.file, call); err != nil {
+		return err
+	}
+	s.records++
+	return s.tMemoryOnewayStore.Push(call)
+}
+
+func (s *tFileOnewayStore) Pop() error {
+	if s.Len() == 0 {
+		return nil
+	}
+	s.tMemoryOnewayStore.Pop()
+	if s.Len() == 0 {
+		// Nothing left to replay, start over with an empty file.
+		if err := s.file.Truncate(0); err != nil {
+			return err
+		}
+		s.records = 0
+		return nil
+	}
+	if s.records > onewayFileCompactRecords && s.records > 2*s.Len() {
+		return s.rewrite()
+	}
+	if err := writeOnewayRecord(s.file, nil); err != nil {
+		return err
+	}
+	s.records++
+	return nil
+}
+
+func (s *tFileOnewayStore) Close() error {
+	return s.file.Close()
+}
+
+var _ TOnewayStore = (*tFileOnewayStore)(nil)
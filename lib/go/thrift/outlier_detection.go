@@ -0,0 +1,361 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutlierReason is why an OutlierDetector ejected an endpoint.
+type OutlierReason int
+
+const (
+	// OutlierConsecutiveFailures is the ejection of an endpoint whose last
+	// calls failed.
+	OutlierConsecutiveFailures OutlierReason = iota
+	// OutlierErrorRate is the ejection of an endpoint whose rate of failed
+	// calls in an interval is too high.
+	OutlierErrorRate
+	// OutlierLatency is the ejection of an endpoint much slower than the
+	// others in an interval.
+	OutlierLatency
+	// OutlierProbe is the ejection of an endpoint whose first call after
+	// an ejection failed.
+	OutlierProbe
+)
+
+func (r OutlierReason) String() string {
+	switch r {
+	case OutlierConsecutiveFailures:
+		return "consecutive failures"
+	case OutlierErrorRate:
+		return "error rate"
+	case OutlierLatency:
+		return "latency"
+	case OutlierProbe:
+		return "probe"
+	default:
+		return fmt.Sprintf("OutlierReason(%d)", int(r))
+	}
+}
+
+// Default values of OutlierDetectionOptions.
+const (
+	DefaultOutlierInterval            = 10 * time.Second
+	DefaultOutlierConsecutiveFailures = 5
+	DefaultOutlierErrorRate           = 0.5
+	DefaultOutlierMinCalls            = 10
+	DefaultOutlierEjectionDuration    = 30 * time.Second
+	DefaultOutlierMaxEjectionDuration = 5 * time.Minute
+	DefaultOutlierMaxEjectedRate      = 0.5
+)
+
+// OutlierDetectionOptions configures NewOutlierDetector.
+type OutlierDetectionOptions struct {
+	// Interval is the interval the error rates and latencies of the
+	// endpoints are computed on, DefaultOutlierInterval if 0.
+	Interval time.Duration
+
+	// ConsecutiveFailures is the number of consecutive failed calls
+	// ejecting an endpoint, DefaultOutlierConsecutiveFailures if 0.
+	ConsecutiveFailures int
+
+	// ErrorRate is the rate of failed calls in an interval, between 0 and
+	// 1, over which an endpoint is ejected, DefaultOutlierErrorRate if 0.
+	ErrorRate float64
+
+	// MinCalls is the minimum number of calls of an endpoint in an
+	// interval for its error rate and latency to be considered,
+	// DefaultOutlierMinCalls if 0.
+	MinCalls int
+
+	// LatencyFactor ejects the endpoints whose mean latency in an interval
+	// is over LatencyFactor times the median of the mean latencies of the
+	// endpoints, e.g. 3. The latency is ignored if 0, or with less than 3
+	// endpoints having MinCalls calls.
+	LatencyFactor float64
+
+	// EjectionDuration is how long an endpoint is ejected the first time,
+	// DefaultOutlierEjectionDuration if 0. It's multiplied by the number of
+	// times the endpoint was ejected recently, up to MaxEjectionDuration,
+	// DefaultOutlierMaxEjectionDuration if 0.
+	EjectionDuration    time.Duration
+	MaxEjectionDuration time.Duration
+
+	// MaxEjectedRate is the maximum rate of the endpoints ejected at once,
+	// between 0 and 1, DefaultOutlierMaxEjectedRate if 0, so that the
+	// remaining endpoints are not overloaded.
+	MaxEjectedRate float64
+
+	// IsFailure reports whether the error of a call is a failure of the
+	// endpoint.
+	//
+	// If nil, all the errors are failures except the cancellation of the
+	// context of the call.
+	IsFailure func(err error) bool
+
+	// OnEject is called when an endpoint is ejected, and OnReturn when its
+	// ejection expires, for example to record metrics. They're called with
+	// the OutlierDetector locked, so they must not call its methods.
+	OnEject  func(endpoint string, reason OutlierReason, duration time.Duration)
+	OnReturn func(endpoint string)
+}
+
+// OutlierDetector ejects the endpoints of a TBalancedClient failing or
+// responding much slower than the others for a while, so that the calls go
+// to the other endpoints.
+//
+// Unlike a CircuitBreaker, the ejected endpoints don't fail the calls sent to
+// them, the TBalancedClient just stops choosing them. Once the ejection
+// expires, the endpoint is probed by its next call: if it fails, the endpoint
+// is ejected again, for longer.
+type OutlierDetector struct {
+	opts OutlierDetectionOptions
+	now  func() time.Time
+
+	mu        sync.Mutex
+	endpoints map[string]*tOutlierEndpoint
+	// intervalStart is the start of the current interval.
+	intervalStart time.Time
+}
+
+// tOutlierEndpoint is the state of an endpoint of an OutlierDetector.
+type tOutlierEndpoint struct {
+	// The calls of the current interval.
+	calls    int
+	failures int
+	latency  time.Duration
+
+	consecutiveFailures int
+
+	// ejections is the number of recent ejections, decremented for every
+	// interval without ejection.
+	ejections int
+	// ejectedUntil is the end of the ejection, zero if not ejected.
+	ejectedUntil time.Time
+	// probing is true once the ejection expired, until the next call.
+	probing bool
+}
+
+// NewOutlierDetector returns an OutlierDetector configured with opts.
+func NewOutlierDetector(opts OutlierDetectionOptions) *OutlierDetector {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultOutlierInterval
+	}
+	if opts.ConsecutiveFailures <= 0 {
+		opts.ConsecutiveFailures = DefaultOutlierConsecutiveFailures
+	}
+	if opts.ErrorRate <= 0 {
+		opts.ErrorRate = DefaultOutlierErrorRate
+	}
+	if opts.MinCalls <= 0 {
+		opts.MinCalls = DefaultOutlierMinCalls
+	}
+	if opts.EjectionDuration <= 0 {
+		opts.EjectionDuration = DefaultOutlierEjectionDuration
+	}
+	if opts.MaxEjectionDuration <= 0 {
+		opts.MaxEjectionDuration = DefaultOutlierMaxEjectionDuration
+	}
+	if opts.MaxEjectedRate <= 0 {
+		opts.MaxEjectedRate = DefaultOutlierMaxEjectedRate
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(err error) bool {
+			return !errors.Is(err, context.Canceled)
+		}
+	}
+	return &OutlierDetector{
+		opts:      opts,
+		now:       time.Now,
+		endpoints: make(map[string]*tOutlierEndpoint),
+	}
+}
+
+// Middleware returns a ClientMiddleware recording the outcome of the calls to
+// endpoint, e.g. the address of the server of the wrapped TClient. The calls
+// are sent even if endpoint is ejected.
+func (d *OutlierDetector) Middleware(endpoint string) ClientMiddleware {
+	d.mu.Lock()
+	d.endpoint(endpoint)
+	d.mu.Unlock()
+	return func(next TClient) TClient {
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				start := d.now()
+				meta, err := next.Call(ctx, method, args, result)
+				d.record(endpoint, err, d.now().Sub(start))
+				return meta, err
+			},
+		}
+	}
+}
+
+// Ejected reports whether endpoint is currently ejected.
+func (d *OutlierDetector) Ejected(endpoint string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.evaluate()
+	e, ok := d.endpoints[endpoint]
+	return ok && !e.ejectedUntil.IsZero()
+}
+
+// retain forgets the endpoints not in addresses, which are no longer counted
+// for MaxEjectedRate.
+func (d *OutlierDetector) retain(addresses []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	kept := make(map[string]*tOutlierEndpoint, len(addresses))
+	for _, address := range addresses {
+		kept[address] = d.endpoint(address)
+	}
+	d.endpoints = kept
+}
+
+// endpoint returns the state of endpoint. d.mu must be held.
+func (d *OutlierDetector) endpoint(endpoint string) *tOutlierEndpoint {
+	e, ok := d.endpoints[endpoint]
+	if !ok {
+		e = &tOutlierEndpoint{}
+		d.endpoints[endpoint] = e
+	}
+	return e
+}
+
+func (d *OutlierDetector) record(endpoint string, err error, latency time.Duration) {
+	failed := err != nil && d.opts.IsFailure(err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.evaluate()
+	e := d.endpoint(endpoint)
+	if !e.ejectedUntil.IsZero() {
+		// A call chosen before the ejection.
+		return
+	}
+	if e.probing {
+		e.probing = false
+		if failed {
+			d.eject(endpoint, e, OutlierProbe, true)
+			return
+		}
+	}
+	e.calls++
+	e.latency += latency
+	if !failed {
+		e.consecutiveFailures = 0
+		return
+	}
+	e.failures++
+	e.consecutiveFailures++
+	if e.consecutiveFailures >= d.opts.ConsecutiveFailures {
+		d.eject(endpoint, e, OutlierConsecutiveFailures, false)
+	}
+}
+
+// evaluate ends the ejections that expired, and ejects the outliers of the interval once it's over. d.mu must be held.
+func (d *OutlierDetector) evaluate() {
+	now := d.now()
+	for endpoint, e := range d.endpoints {
+		if !e.ejectedUntil.IsZero() && !now.Before(e.ejectedUntil) {
+			e.ejectedUntil = time.Time{}
+			e.probing = true
+			e.consecutiveFailures = 0
+			if d.opts.OnReturn != nil {
+				d.opts.OnReturn(endpoint)
+			}
+		}
+	}
+	if d.intervalStart.IsZero() {
+		d.intervalStart = now
+	}
+	if now.Sub(d.intervalStart) < d.opts.Interval {
+		return
+	}
+	d.intervalStart = now
+
+	var latencies []time.Duration
+	for _, e := range d.endpoints {
+		if e.ejectedUntil.IsZero() && e.calls >= d.opts.MinCalls {
+			latencies = append(latencies, e.latency/time.Duration(e.calls))
+		}
+	}
+	var median time.Duration
+	if d.opts.LatencyFactor > 0 && len(latencies) >= 3 {
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+		median = latencies[len(latencies)/2]
+	}
+	for endpoint, e := range d.endpoints {
+		calls, failures, latency := e.calls, e.failures, e.latency
+		e.calls, e.failures, e.latency = 0, 0, 0
+		if !e.ejectedUntil.IsZero() {
+			continue
+		}
+		switch {
+		case calls < d.opts.MinCalls:
+		case float64(failures) >= d.opts.ErrorRate*float64(calls):
+			if d.eject(endpoint, e, OutlierErrorRate, false) {
+				continue
+			}
+		case median > 0 && float64(latency/time.Duration(calls)) > d.opts.LatencyFactor*float64(median):
+			if d.eject(endpoint, e, OutlierLatency, false) {
+				continue
+			}
+		}
+		// The endpoints just returned are not known to be healthy yet.
+		if e.ejections > 0 && !e.probing {
+			e.ejections--
+		}
+	}
+}
+
+// eject ejects endpoint unless MaxEjectedRate of the endpoints already are,
+// or force is set, and reports whether it did. d.mu must be held.
+func (d *OutlierDetector) eject(endpoint string, e *tOutlierEndpoint, reason OutlierReason, force bool) bool {
+	if !force {
+		ejected := 1
+		for _, other := range d.endpoints {
+			if !other.ejectedUntil.IsZero() {
+				ejected++
+			}
+		}
+		if float64(ejected) > d.opts.MaxEjectedRate*float64(len(d.endpoints)) {
+			return false
+		}
+	}
+	e.ejections++
+	duration := d.opts.EjectionDuration * time.Duration(e.ejections)
+	if duration > d.opts.MaxEjectionDuration {
+		duration = d.opts.MaxEjectionDuration
+	}
+	e.ejectedUntil = d.now().Add(duration)
+	e.consecutiveFailures = 0
+	if d.opts.OnEject != nil {
+		d.opts.OnEject(endpoint, reason, duration)
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestOutlierDetector(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var events []string
+	d := NewOutlierDetector(OutlierDetectionOptions{
+		Interval:            10 * time.Second,
+		ConsecutiveFailures: 3,
+		MinCalls:            4,
+		ErrorRate:           0.5,
+		LatencyFactor:       3,
+		EjectionDuration:    time.Minute,
+		MaxEjectionDuration: 90 * time.Second,
+		OnEject: func(endpoint string, reason OutlierReason, duration time.Duration) {
+			events = append(events, fmt.Sprintf("%s ejected: %v, %v", endpoint, reason, duration))
+		},
+		OnReturn: func(endpoint string) {
+			events = append(events, endpoint+" returned")
+		},
+	})
+	d.now = func() time.Time {
+		return now
+	}
+	failing := errors.New("unavailable")
+	errs := make(map[string]error)
+	latencies := make(map[string]time.Duration)
+	clients := make(map[string]TClient)
+	for _, address := range []string{"a", "b", "c", "d"} {
+		address := address
+		clients[address] = d.Middleware(address)(WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				now = now.Add(latencies[address])
+				return ResponseMeta{}, errs[address]
+			},
+		})
+	}
+	call := func(address string, n int) {
+		for i := 0; i < n; i++ {
+			clients[address].Call(context.Background(), "m", nil, nil)
+		}
+	}
+	expectEvents := func(expected ...string) {
+		t.Helper()
+		sort.Strings(events)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(events, expected) {
+			t.Errorf("expected events %q, got %q", expected, events)
+		}
+		events = nil
+	}
+
+	errs["a"] = failing
+	call("a", 2)
+	if d.Ejected("a") {
+		t.Fatal("expected a not to be ejected before ConsecutiveFailures")
+	}
+	call("a", 1)
+	if !d.Ejected("a") {
+		t.Fatal("expected a to be ejected")
+	}
+	expectEvents("a ejected: consecutive failures, 1m0s")
+
+	// The first call after the ejection is a probe.
+	now = now.Add(time.Minute)
+	if d.Ejected("a") {
+		t.Fatal("expected the ejection of a to expire")
+	}
+	call("a", 1)
+	expectEvents("a returned", "a ejected: probe, 1m30s")
+
+	// At most half of the endpoints are ejected.
+	errs["b"], errs["c"] = failing, failing
+	call("b", 3)
+	call("c", 3)
+	if !d.Ejected("b") || d.Ejected("c") {
+		t.Errorf("expected b to be ejected and not c, got %v and %v", d.Ejected("b"), d.Ejected("c"))
+	}
+	expectEvents("b ejected: consecutive failures, 1m0s")
+
+	// A successful probe returns the endpoint.
+	now = now.Add(90 * time.Second)
+	errs["a"], errs["b"], errs["c"] = nil, nil, nil
+	call("a", 1)
+	call("b", 1)
+	if d.Ejected("a") || d.Ejected("b") {
+		t.Fatal("expected a and b to be returned")
+	}
+	expectEvents("a returned", "b returned")
+
+	// The error rate and latency over an interval.
+	now = now.Add(10 * time.Second)
+	d.Ejected("a")
+	for _, address := range []string{"a", "b", "c", "d"} {
+		latencies[address] = 10 * time.Millisecond
+	}
+	latencies["d"] = 100 * time.Millisecond
+	for i := 0; i < 4; i++ {
+		if i%2 == 0 {
+			errs["b"] = failing
+		} else {
+			errs["b"] = nil
+		}
+		call("a", 1)
+		call("b", 1)
+		call("c", 1)
+		call("d", 1)
+	}
+	now = now.Add(10 * time.Second)
+	if !d.Ejected("b") || !d.Ejected("d") || d.Ejected("a") || d.Ejected("c") {
+		t.Errorf("expected b and d to be ejected, got %v", events)
+	}
+	if len(events) != 2 {
+		t.Errorf("expected 2 ejections, got %q", events)
+	}
+}
+
+func TestTBalancedClientOutlierDetection(t *testing.T) {
+	err := errors.New("unavailable")
+	called := make(map[string]int)
+	c := NewTBalancedClient(recordingEndpoints(called, &err, "a", "b"), TBalancedClientOptions{
+		OutlierDetector: NewOutlierDetector(OutlierDetectionOptions{ConsecutiveFailures: 1}),
+	})
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		c.Call(ctx, "m", nil, nil)
+	}
+	// a is ejected by its first failure, and b is kept as the last endpoint.
+	if called["a"] != 1 || called["b"] != 3 {
+		t.Errorf("expected the calls to avoid the ejected endpoint, got %v", called)
+	}
+}
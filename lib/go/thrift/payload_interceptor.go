@@ -0,0 +1,213 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// TPayload is a serialized message of a call, passed to a
+// TPayloadInterceptor.
+type TPayload struct {
+	Method string
+	SeqID  int32
+
+	// Data is the message encoded with the protocol of the client, from its
+	// message begin to its message end, without the framing of the
+	// transport. The interceptors can replace it, e.g. by a transcoded
+	// message, but must not modify it in place.
+	Data []byte
+
+	// Headers are the THeader headers of the message, nil if the client
+	// doesn't use THeaderProtocol. The interceptors can set the headers of
+	// the requests, e.g. to a signature of Data.
+	Headers THeaderMap
+}
+
+// TPayloadInterceptor sees the serialized messages of the calls of a
+// TStandardClient, e.g. to sign them, to account for their sizes or to
+// capture them, see TStandardClient.SetPayloadInterceptor.
+type TPayloadInterceptor interface {
+	// InterceptRequest is called with every request before it's sent. The
+	// call fails with its error, if any, without being sent.
+	InterceptRequest(ctx context.Context, payload *TPayload) error
+
+	// InterceptResponse is called with every reply, including the
+	// exceptions, before it's decoded. The call fails with its error, if
+	// any.
+	InterceptResponse(ctx context.Context, payload *TPayload) error
+}
+
+// SetPayloadInterceptor sets the TPayloadInterceptor of the messages of the
+// calls. It's supported with TBinaryProtocol, TCompactProtocol and
+// THeaderProtocol, possibly wrapped by a TMultiplexedProtocol, and the calls
+// fail with other protocols.
+//
+// The messages are encoded in memory first, and then copied to the
+// transports of the client.
+func (p *TStandardClient) SetPayloadInterceptor(interceptor TPayloadInterceptor) {
+	p.interceptor = interceptor
+}
+
+// writeInterceptedCall is writeCallMessage through the TPayloadInterceptor
+// of the client.
+func (p *TStandardClient) writeInterceptedCall(ctx context.Context, seqId int32, method string, args TStruct) error {
+	oprot, service := unwrapMultiplexedProtocol(p.oprot)
+	buf := NewTMemoryBuffer()
+	proto, err := payloadProtocol(oprot, buf)
+	if err != nil {
+		return err
+	}
+	if service == "" {
+		service = multiplexedService(ctx)
+	}
+	if service != "" {
+		method = service + MULTIPLEXED_SEPARATOR + method
+	}
+	if err := proto.WriteMessageBegin(ctx, method, CALL, seqId); err != nil {
+		return err
+	}
+	if err := args.Write(ctx, proto); err != nil {
+		return err
+	}
+	if err := proto.WriteMessageEnd(ctx); err != nil {
+		return err
+	}
+	if err := proto.Flush(ctx); err != nil {
+		return err
+	}
+
+	payload := &TPayload{
+		Method: method,
+		SeqID:  seqId,
+		Data:   buf.Bytes(),
+	}
+	headerProt, isHeader := oprot.(*THeaderProtocol)
+	if isHeader {
+		payload.Headers = OutgoingHeadersFromContext(ctx)
+		if payload.Headers == nil {
+			payload.Headers = make(THeaderMap)
+		}
+	}
+	if err := p.interceptor.InterceptRequest(ctx, payload); err != nil {
+		return err
+	}
+	if !isHeader {
+		_, err := oprot.Transport().Write(payload.Data)
+		return err
+	}
+	headerProt.ClearWriteHeaders()
+	for key, value := range payload.Headers {
+		headerProt.SetWriteHeader(key, value)
+	}
+	headerProt.transport.SequenceID = seqId
+	if _, err := headerProt.transport.Write(payload.Data); err != nil {
+		return err
+	}
+	return headerProt.transport.Flush(ctx)
+}
+
+// readInterceptedReply reads the reply of the call seqId from the input
+// protocol of the client, passes it through its TPayloadInterceptor, and
+// returns the protocol to decode it from.
+func (p *TStandardClient) readInterceptedReply(ctx context.Context, seqId int32, method string) (TProtocol, error) {
+	rMethod, rTypeId, rSeqId, err := p.iprot.ReadMessageBegin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for rSeqId != seqId && p.skipsSeqIDMismatch() {
+		if err := p.iprot.Skip(ctx, STRUCT); err != nil {
+			return nil, err
+		}
+		if err := p.iprot.ReadMessageEnd(ctx); err != nil {
+			return nil, err
+		}
+		if rMethod, rTypeId, rSeqId, err = p.iprot.ReadMessageBegin(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if rSeqId != seqId && p.seqIDPolicy == SeqIDMismatchClose {
+		return nil, closeOnSeqIDMismatch(p.iprot, method, seqId, rSeqId)
+	}
+
+	iprot, _ := unwrapMultiplexedProtocol(p.iprot)
+	buf := NewTMemoryBuffer()
+	proto, err := payloadProtocol(iprot, buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := proto.WriteMessageBegin(ctx, rMethod, rTypeId, rSeqId); err != nil {
+		return nil, err
+	}
+	if err := copyValue(ctx, p.iprot, proto, STRUCT, false, DEFAULT_RECURSION_DEPTH); err != nil {
+		return nil, err
+	}
+	if err := p.iprot.ReadMessageEnd(ctx); err != nil {
+		return nil, err
+	}
+	if err := proto.WriteMessageEnd(ctx); err != nil {
+		return nil, err
+	}
+	if err := proto.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	payload := &TPayload{
+		Method:  rMethod,
+		SeqID:   rSeqId,
+		Data:    buf.Bytes(),
+		Headers: p.responseMeta().Headers,
+	}
+	if err := p.interceptor.InterceptResponse(ctx, payload); err != nil {
+		return nil, err
+	}
+	return payloadProtocol(iprot, &TMemoryBuffer{Buffer: bytes.NewBuffer(payload.Data)})
+}
+
+// unwrapMultiplexedProtocol returns the protocol wrapped by proto and its
+// service if it's a TMultiplexedProtocol, proto otherwise.
+func unwrapMultiplexedProtocol(proto TProtocol) (TProtocol, string) {
+	if mp, ok := proto.(*TMultiplexedProtocol); ok {
+		return mp.TProtocol, mp.serviceName
+	}
+	return proto, ""
+}
+
+// payloadProtocol returns a protocol encoding the messages as proto does
+// over trans, without the framing of THeaderProtocol.
+func payloadProtocol(proto TProtocol, trans TTransport) (TProtocol, error) {
+	switch proto := proto.(type) {
+	case *TBinaryProtocol:
+		return NewTBinaryProtocolConf(trans, proto.cfg), nil
+	case *TCompactProtocol:
+		return NewTCompactProtocolConf(trans, proto.cfg), nil
+	case *THeaderProtocol:
+		payloadProto, err := proto.transport.Protocol().GetProtocol(trans)
+		if err != nil {
+			return nil, err
+		}
+		PropagateTConfiguration(payloadProto, proto.cfg)
+		return payloadProto, nil
+	default:
+		return nil, NewTProtocolExceptionWithType(NOT_IMPLEMENTED, fmt.Errorf("payload interceptor: unsupported protocol %T", proto))
+	}
+}
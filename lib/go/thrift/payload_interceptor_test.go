@@ -0,0 +1,153 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// recordingPayloadInterceptor records the payloads, and calls the optional
+// functions.
+type recordingPayloadInterceptor struct {
+	requests, responses []TPayload
+	request, response   func(payload *TPayload) error
+}
+
+func (r *recordingPayloadInterceptor) InterceptRequest(ctx context.Context, payload *TPayload) error {
+	r.requests = append(r.requests, *payload)
+	if r.request != nil {
+		return r.request(payload)
+	}
+	return nil
+}
+
+func (r *recordingPayloadInterceptor) InterceptResponse(ctx context.Context, payload *TPayload) error {
+	r.responses = append(r.responses, *payload)
+	if r.response != nil {
+		return r.response(payload)
+	}
+	return nil
+}
+
+// encodeEchoMessage returns the message of the pipelinedString value
+// encoded with TBinaryProtocol.
+func encodeEchoMessage(t *testing.T, name string, typeID TMessageType, seqID int32, value string) []byte {
+	t.Helper()
+	buf := NewTMemoryBuffer()
+	proto := NewTBinaryProtocolConf(buf, nil)
+	ctx := context.Background()
+	if err := proto.WriteMessageBegin(ctx, name, typeID, seqID); err != nil {
+		t.Fatal(err)
+	}
+	writeStringArgs(ctx, proto, value)
+	return buf.Bytes()
+}
+
+func TestPayloadInterceptor(t *testing.T) {
+	mp := NewTMultiplexedProcessor()
+	mp.RegisterProcessor("Echo", headerEchoProcessor{})
+	serv, addr := startTestSocketServer(t, mp, nil)
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	proto := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+	client := NewTStandardClient(proto, NewTMultiplexedProtocol(proto, "Echo"))
+	interceptor := new(recordingPayloadInterceptor)
+	client.SetPayloadInterceptor(interceptor)
+	call := func(value string) (string, error) {
+		var result pipelinedString
+		_, err := client.Call(context.Background(), "echo", &pipelinedString{value}, &result)
+		return result.Value, err
+	}
+
+	if result, err := call("hello"); err != nil || result != "hello" {
+		t.Fatalf("unexpected result %q (%v)", result, err)
+	}
+	request, response := interceptor.requests[0], interceptor.responses[0]
+	if expected := encodeEchoMessage(t, "Echo:echo", CALL, 1, "hello"); request.Method != "Echo:echo" || request.SeqID != 1 || !bytes.Equal(request.Data, expected) {
+		t.Errorf("unexpected request payload %+v", request)
+	}
+	if expected := encodeEchoMessage(t, "echo", REPLY, 1, "hello"); response.Method != "echo" || response.SeqID != 1 || !bytes.Equal(response.Data, expected) {
+		t.Errorf("unexpected response payload %+v", response)
+	}
+
+	// The requests failing to be intercepted are not sent.
+	interceptErr := errors.New("rejected")
+	interceptor.request = func(payload *TPayload) error {
+		return interceptErr
+	}
+	if _, err := call("rejected"); err != interceptErr {
+		t.Errorf("expected the interceptor error, got %v", err)
+	}
+
+	// The intercepted replies can be replaced.
+	interceptor.request = nil
+	interceptor.response = func(payload *TPayload) error {
+		payload.Data = encodeEchoMessage(t, "echo", REPLY, payload.SeqID, "replaced")
+		return nil
+	}
+	if result, err := call("hello"); err != nil || result != "replaced" {
+		t.Errorf("expected the replaced result, got %q (%v)", result, err)
+	}
+}
+
+func TestPayloadInterceptorHeaders(t *testing.T) {
+	serv, addr := startTestSocketServer(t, headerEchoProcessor{}, func(s *TSimpleServer) {
+		s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+		s.outputProtocolFactory = s.inputProtocolFactory
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	proto := NewTHeaderProtocolConf(dialTestSocketServer(t, addr), nil)
+	client := NewTStandardClient(proto, proto)
+	sign := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	// The server echoes the "trace" header in the "served-trace" one.
+	interceptor := &recordingPayloadInterceptor{
+		request: func(payload *TPayload) error {
+			payload.Headers["trace"] = sign(payload.Data)
+			return nil
+		},
+	}
+	client.SetPayloadInterceptor(interceptor)
+
+	var result pipelinedString
+	if _, err := client.Call(context.Background(), "echo", &pipelinedString{"hello"}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != "hello" {
+		t.Errorf("unexpected result %q", result.Value)
+	}
+	response := interceptor.responses[0]
+	if signature := response.Headers["served-trace"]; signature != sign(interceptor.requests[0].Data) {
+		t.Errorf("expected the signature header of the request to be sent, got %q", signature)
+	}
+	if expected := encodeEchoMessage(t, "echo", REPLY, 1, "hello"); !bytes.Equal(response.Data, expected) {
+		t.Errorf("unexpected response payload %+v", response)
+	}
+}
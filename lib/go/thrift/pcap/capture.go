@@ -0,0 +1,291 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxPacketSize bounds the size of the packets and of the pcapng blocks read,
+// to not allocate the sizes of corrupted captures.
+const maxPacketSize = 16 << 20
+
+// The magic numbers of the capture formats, as read in big endian.
+const (
+	pcapMicros   = 0xa1b2c3d4
+	pcapNanos    = 0xa1b23c4d
+	pcapngHeader = 0x0a0d0d0a
+	pcapngOrder  = 0x1a2b3c4d
+)
+
+// The pcapng blocks read.
+const (
+	blockInterface      = 1
+	blockSimplePacket   = 3
+	blockEnhancedPacket = 6
+)
+
+// packet is a packet of a capture, with the link layer header of its link
+// type.
+type packet struct {
+	time     time.Time
+	linkType uint32
+	data     []byte
+}
+
+// packetReader reads the packets of a capture, returning io.EOF at its end.
+type packetReader interface {
+	next() (packet, error)
+}
+
+// newPacketReader returns the packetReader of the capture of r, in the pcap
+// or pcapng format.
+func newPacketReader(r io.Reader) (packetReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("pcap: reading the capture header: %w", noEOF(err))
+	}
+	if binary.BigEndian.Uint32(magic) == pcapngHeader {
+		return &pcapngReader{r: br}, nil
+	}
+	return newPcapReader(br)
+}
+
+// noEOF returns io.ErrUnexpectedEOF for io.EOF, when the capture ends in the
+// middle of a header.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// pcapReader reads the captures of the pcap format.
+type pcapReader struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+	header   [16]byte
+}
+
+func newPcapReader(r *bufio.Reader) (*pcapReader, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("pcap: reading the capture header: %w", noEOF(err))
+	}
+	p := &pcapReader{r: r}
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		switch order.Uint32(header[:]) {
+		case pcapMicros:
+			p.order = order
+		case pcapNanos:
+			p.order, p.nanos = order, true
+		}
+	}
+	if p.order == nil {
+		return nil, errors.New("pcap: unknown capture format")
+	}
+	p.linkType = p.order.Uint32(header[20:])
+	return p, nil
+}
+
+func (p *pcapReader) next() (packet, error) {
+	if _, err := io.ReadFull(p.r, p.header[:]); err != nil {
+		if err == io.EOF {
+			return packet{}, io.EOF
+		}
+		return packet{}, fmt.Errorf("pcap: reading a packet header: %w", err)
+	}
+	sec := p.order.Uint32(p.header[0:])
+	frac := p.order.Uint32(p.header[4:])
+	size := p.order.Uint32(p.header[8:])
+	if size > maxPacketSize {
+		return packet{}, fmt.Errorf("pcap: packet of %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return packet{}, fmt.Errorf("pcap: reading a packet: %w", noEOF(err))
+	}
+	nsec := int64(frac)
+	if !p.nanos {
+		nsec *= int64(time.Microsecond)
+	}
+	return packet{
+		time:     time.Unix(int64(sec), nsec),
+		linkType: p.linkType,
+		data:     data,
+	}, nil
+}
+
+// pcapngReader reads the captures of the pcapng format.
+type pcapngReader struct {
+	r          *bufio.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterface
+}
+
+// pcapngInterface is an interface of a section of a pcapng capture.
+type pcapngInterface struct {
+	linkType uint32
+	// unitsPerSecond is the resolution of the timestamps of the packets.
+	unitsPerSecond uint64
+}
+
+func (p *pcapngReader) next() (packet, error) {
+	for {
+		blockType, body, err := p.readBlock()
+		if err != nil {
+			return packet{}, err
+		}
+		switch blockType {
+		case pcapngHeader:
+			// A new section, whose interfaces are numbered from 0.
+			p.interfaces = nil
+		case blockInterface:
+			if len(body) < 8 {
+				return packet{}, errors.New("pcap: truncated interface description block")
+			}
+			p.interfaces = append(p.interfaces, pcapngInterface{
+				linkType:       uint32(p.order.Uint16(body)),
+				unitsPerSecond: p.unitsPerSecond(body[8:]),
+			})
+		case blockEnhancedPacket:
+			if len(body) < 20 {
+				return packet{}, errors.New("pcap: truncated enhanced packet block")
+			}
+			id := p.order.Uint32(body)
+			size := p.order.Uint32(body[12:])
+			if id >= uint32(len(p.interfaces)) || uint64(size) > uint64(len(body)-20) {
+				return packet{}, errors.New("pcap: invalid enhanced packet block")
+			}
+			ts := uint64(p.order.Uint32(body[4:]))<<32 | uint64(p.order.Uint32(body[8:]))
+			iface := p.interfaces[id]
+			ups := iface.unitsPerSecond
+			nsec := float64(ts%ups) * float64(time.Second) / float64(ups)
+			return packet{
+				time:     time.Unix(int64(ts/ups), int64(nsec)),
+				linkType: iface.linkType,
+				data:     body[20 : 20+size],
+			}, nil
+		case blockSimplePacket:
+			if len(body) < 4 || len(p.interfaces) == 0 {
+				return packet{}, errors.New("pcap: invalid simple packet block")
+			}
+			// The packets are padded to 32 bits, and truncated to the
+			// snapshot length, which both can only be told apart with the
+			// original length.
+			data := body[4:]
+			if size := p.order.Uint32(body); uint64(size) < uint64(len(data)) {
+				data = data[:size]
+			}
+			return packet{
+				linkType: p.interfaces[0].linkType,
+				data:     data,
+			}, nil
+		}
+	}
+}
+
+// readBlock reads a block, returning its type and its body.
+func (p *pcapngReader) readBlock() (uint32, []byte, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(p.r, header[:8]); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, fmt.Errorf("pcap: reading a block header: %w", err)
+	}
+	// The type of the section header block is a palindrome, its byte order
+	// is the one of the section.
+	if binary.BigEndian.Uint32(header[:]) == pcapngHeader {
+		if _, err := io.ReadFull(p.r, header[8:]); err != nil {
+			return 0, nil, fmt.Errorf("pcap: reading a section header: %w", noEOF(err))
+		}
+		p.order = nil
+		for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			if order.Uint32(header[8:]) == pcapngOrder {
+				p.order = order
+			}
+		}
+	}
+	if p.order == nil {
+		return 0, nil, errors.New("pcap: unknown byte order of the section")
+	}
+	blockType := p.order.Uint32(header[:])
+	size := p.order.Uint32(header[4:])
+	read := uint32(8)
+	if blockType == pcapngHeader {
+		read = 12
+	}
+	if size < read+4 || size%4 != 0 || size > maxPacketSize {
+		return 0, nil, fmt.Errorf("pcap: invalid block of %d bytes", size)
+	}
+	// The body, and the size of the block repeated.
+	body := make([]byte, size-read)
+	if _, err := io.ReadFull(p.r, body); err != nil {
+		return 0, nil, fmt.Errorf("pcap: reading a block: %w", noEOF(err))
+	}
+	return blockType, body[:len(body)-4], nil
+}
+
+// unitsPerSecond returns the resolution of the timestamps of an interface,
+// with the if_tsresol option of its options, microseconds by default.
+func (p *pcapngReader) unitsPerSecond(options []byte) uint64 {
+	const (
+		optEnd     = 0
+		optTSResol = 9
+	)
+	for len(options) >= 4 {
+		code := p.order.Uint16(options)
+		size := int(p.order.Uint16(options[2:]))
+		options = options[4:]
+		if code == optEnd || size > len(options) {
+			break
+		}
+		if code == optTSResol && size == 1 {
+			resol := options[0]
+			if resol&0x80 != 0 {
+				if shift := resol &^ 0x80; shift < 64 {
+					return 1 << shift
+				}
+				break
+			}
+			ups := uint64(1)
+			for i := byte(0); i < resol && i < 19; i++ {
+				ups *= 10
+			}
+			return ups
+		}
+		// The values are padded to 32 bits.
+		if padded := (size + 3) &^ 3; padded <= len(options) {
+			options = options[padded:]
+		} else {
+			break
+		}
+	}
+	return uint64(time.Second / time.Microsecond)
+}
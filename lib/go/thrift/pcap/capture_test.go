@@ -0,0 +1,182 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func appendUint16(order binary.ByteOrder, b []byte, v uint16) []byte {
+	var buf [2]byte
+	order.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint32(order binary.ByteOrder, b []byte, v uint32) []byte {
+	var buf [4]byte
+	order.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// pcapngBlock returns a pcapng block of body, padded to 32 bits.
+func pcapngBlock(order binary.ByteOrder, blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	block := make([]byte, 8, 12+len(body))
+	order.PutUint32(block[0:], blockType)
+	order.PutUint32(block[4:], uint32(12+len(body)))
+	block = append(block, body...)
+	return appendUint32(order, block, uint32(12+len(body)))
+}
+
+func TestPcapng(t *testing.T) {
+	src, dst := "[2001:db8::1]:50000", "[2001:db8::2]:9090"
+	data := ipv6(src, dst, tcp(src, dst, 1, false, []byte("payload")))
+	ts := time.Unix(1700000000, 123456789)
+
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		var capture []byte
+		shb := appendUint32(order, nil, pcapngOrder)
+		shb = appendUint16(order, shb, 1)
+		shb = appendUint16(order, shb, 0)
+		shb = append(shb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+		capture = append(capture, pcapngBlock(order, pcapngHeader, shb)...)
+
+		// A raw IP interface with nanosecond timestamps.
+		idb := appendUint16(order, nil, linkRaw)
+		idb = appendUint16(order, idb, 0)
+		idb = appendUint32(order, idb, 65535)
+		idb = appendUint16(order, idb, 9)
+		idb = appendUint16(order, idb, 1)
+		idb = append(idb, 9, 0, 0, 0)
+		idb = append(idb, 0, 0, 0, 0)
+		capture = append(capture, pcapngBlock(order, blockInterface, idb)...)
+
+		// A block to skip, then the packets.
+		capture = append(capture, pcapngBlock(order, 0x0bad, []byte("skipped"))...)
+		nanos := uint64(ts.UnixNano())
+		epb := appendUint32(order, nil, 0)
+		epb = appendUint32(order, epb, uint32(nanos>>32))
+		epb = appendUint32(order, epb, uint32(nanos))
+		epb = appendUint32(order, epb, uint32(len(data)))
+		epb = appendUint32(order, epb, uint32(len(data)))
+		epb = append(epb, data...)
+		capture = append(capture, pcapngBlock(order, blockEnhancedPacket, epb)...)
+		spb := appendUint32(order, nil, uint32(len(data)))
+		spb = append(spb, data...)
+		capture = append(capture, pcapngBlock(order, blockSimplePacket, spb)...)
+
+		r, err := newPacketReader(bytes.NewReader(capture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []time.Time{ts, {}} {
+			p, err := r.next()
+			if err != nil {
+				t.Fatalf("%v: %v", order, err)
+			}
+			if !p.time.Equal(expected) || p.linkType != linkRaw || !bytes.Equal(p.data, data) {
+				t.Errorf("%v: expected a raw packet at %v, got %+v", order, expected, p)
+			}
+			seg, ok := parseSegment(p)
+			if !ok || seg.srcPort != 50000 || string(seg.payload) != "payload" {
+				t.Errorf("%v: expected the TCP segment, got %+v", order, seg)
+			}
+		}
+		if _, err := r.next(); err != io.EOF {
+			t.Errorf("%v: expected io.EOF, got %v", order, err)
+		}
+	}
+}
+
+func TestPcapFormats(t *testing.T) {
+	src, dst := "10.0.0.1:50000", "10.0.0.2:9090"
+	ip := ipv4(src, dst, tcp(src, dst, 1, false, []byte("payload")))
+	// A Linux cooked capture in big endian with nanosecond timestamps.
+	sll := make([]byte, 16, 16+len(ip))
+	binary.BigEndian.PutUint16(sll[14:], etherIPv4)
+	sll = append(sll, ip...)
+	// The Ethernet frames are padded to 60 bytes.
+	padded := append(ethernet(etherIPv4, ip), make([]byte, 10)...)
+
+	for _, c := range []struct {
+		linkType uint32
+		data     []byte
+	}{
+		{linkSLL, sll},
+		{linkEthernet, padded},
+		{linkNull, append([]byte{2, 0, 0, 0}, ip...)},
+	} {
+		var capture []byte
+		capture = appendUint32(binary.BigEndian, capture, pcapNanos)
+		capture = appendUint16(binary.BigEndian, capture, 2)
+		capture = appendUint16(binary.BigEndian, capture, 4)
+		capture = append(capture, make([]byte, 8)...)
+		capture = appendUint32(binary.BigEndian, capture, 65535)
+		capture = appendUint32(binary.BigEndian, capture, c.linkType)
+		capture = appendUint32(binary.BigEndian, capture, 1700000000)
+		capture = appendUint32(binary.BigEndian, capture, 5)
+		capture = appendUint32(binary.BigEndian, capture, uint32(len(c.data)))
+		capture = appendUint32(binary.BigEndian, capture, uint32(len(c.data)))
+		capture = append(capture, c.data...)
+
+		r, err := newPacketReader(bytes.NewReader(capture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := r.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !p.time.Equal(time.Unix(1700000000, 5)) {
+			t.Errorf("link type %d: expected the nanosecond timestamp, got %v", c.linkType, p.time)
+		}
+		seg, ok := parseSegment(p)
+		if !ok || seg.dstPort != 9090 || string(seg.payload) != "payload" {
+			t.Errorf("link type %d: expected the TCP segment, got %+v", c.linkType, seg)
+		}
+	}
+}
+
+func TestCaptureErrors(t *testing.T) {
+	valid := newTestCapture()
+	valid.segment("10.0.0.1:50000", "10.0.0.2:9090", 1, false, []byte("payload"))
+	for _, c := range []struct {
+		name    string
+		capture []byte
+		err     string
+	}{
+		{"empty", nil, "unexpected EOF"},
+		{"unknown", []byte("not a capture, but a text file"), "unknown capture format"},
+		{"truncated header", valid.buf.Bytes()[:10], "unexpected EOF"},
+		{"truncated packet", valid.buf.Bytes()[:valid.buf.Len()-1], "unexpected EOF"},
+		{"invalid block", pcapngBlock(binary.LittleEndian, pcapngHeader, []byte{0x4d, 0x3c, 0x2b, 0x1a})[:11], "unexpected EOF"},
+	} {
+		if _, err := Read(bytes.NewReader(c.capture), nil); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", c.name, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package pcap decodes the thrift traffic of network captures, e.g. written
+// by tcpdump or Wireshark, for the forensics of production traffic:
+//
+//	capture, err := pcap.ReadFile("capture.pcap", &pcap.Options{Ports: []int{9090}})
+//	if err != nil {
+//		return err
+//	}
+//	for _, m := range capture.Messages {
+//		fmt.Println(m.Time, m.Src, m.Dst, m.Method, m.Body)
+//	}
+//
+// The TCP connections are reassembled from the packets of the capture, and
+// their messages decoded without their IDL into thrift.Values, whose fields
+// are then named with the IDL by Message.Interface. The thriftdump command
+// prints the messages of captures with -pcap.
+package pcap
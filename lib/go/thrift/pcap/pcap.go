@@ -0,0 +1,327 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package pcap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/apache/thrift/lib/go/thrift/idl"
+)
+
+// errTruncated is the error of the streams ending in the middle of a
+// message, e.g. when the capture stopped before the end of the connection.
+var errTruncated = errors.New("truncated message")
+
+// errMissingPackets is the error of the streams missing packets, e.g. dropped
+// by the capture.
+var errMissingPackets = errors.New("packets missing from the capture")
+
+// Options are the options of Read.
+type Options struct {
+	// Ports are the TCP ports of the thrift servers, whose connections are
+	// decoded. All the connections are decoded if it's empty.
+	Ports []int
+
+	// Configuration bounds the sizes of the messages decoded, see
+	// TConfiguration.MaxMessageSize and MaxFrameSize.
+	Configuration *thrift.TConfiguration
+}
+
+// Message is a thrift message of a capture.
+type Message struct {
+	// Time is the time the packet of the first byte of the message was
+	// captured, zero if the capture doesn't have it. Src and Dst are the addresses of the
+	// sender and the receiver, as host:port.
+	Time     time.Time
+	Src, Dst string
+
+	// Transport is the framing of the message: "unframed", "framed" or
+	// "header", and Protocol its protocol: "binary" or "compact".
+	Transport string
+	Protocol  string
+
+	Method string
+	Type   thrift.TMessageType
+	SeqID  int32
+	// Headers are the THeader headers of the message.
+	Headers thrift.THeaderMap
+
+	// Body is the arguments of the calls, the result of the replies, and
+	// the TApplicationException of the exceptions.
+	Body thrift.Value
+}
+
+// Interface returns the fields of the body of m by name, resolved by the
+// arguments or the result of its function in doc, with idl.Document's
+// MessageStruct and StructInterface. The function is the one of service, or
+// of the first service defining it if service is empty. The fields are named
+// by id if doc is nil or doesn't define the function.
+func (m *Message) Interface(doc *idl.Document, service string) (map[string]interface{}, error) {
+	s, sdoc := doc.MessageStruct(service, m.Method, m.Type)
+	return sdoc.StructInterface(s, m.Body)
+}
+
+// StreamError is the error a direction of a TCP connection couldn't be
+// decoded further with, e.g. because it's not thrift, or because packets are
+// missing from the capture.
+type StreamError struct {
+	Src, Dst string
+	// Offset is the offset in the stream of the message which couldn't be
+	// decoded.
+	Offset int
+	Err    error
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("%s -> %s: offset %d: %v", e.Src, e.Dst, e.Offset, e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// Capture is the thrift traffic of a capture.
+type Capture struct {
+	// Messages are the messages of all the connections, by Time.
+	Messages []Message
+	// Errors are the errors of the streams which couldn't be decoded until
+	// their end, by the time of their first packet.
+	Errors []*StreamError
+}
+
+// ReadFile reads the capture of the file name, see Read.
+func ReadFile(name string, opts *Options) (*Capture, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f, opts)
+}
+
+// Read reads a capture in the pcap or pcapng format, e.g. written by
+// tcpdump, and decodes the thrift messages of its TCP connections.
+//
+// Both directions of the connections are reassembled from their packets, and
+// their messages are decoded like THeaderTransport detects them: unframed,
+// framed, and THeader, with the binary and compact protocols. The IPv4 and
+// IPv6 packets are read from Ethernet, raw IP, loopback and Linux cooked
+// captures, without the fragmented ones.
+//
+// It returns an error if the capture is malformed, the errors of the streams
+// which couldn't be decoded, e.g. because packets are missing, being reported
+// in Capture.Errors.
+func Read(r io.Reader, opts *Options) (*Capture, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	packets, err := newPacketReader(r)
+	if err != nil {
+		return nil, err
+	}
+	ports := make(map[int]bool, len(opts.Ports))
+	for _, port := range opts.Ports {
+		ports[port] = true
+	}
+	// The streams by direction of the connections.
+	type flow struct {
+		src, dst         [net.IPv6len]byte
+		srcPort, dstPort int
+	}
+	streams := make(map[flow]*stream)
+	var order []*stream
+	for {
+		p, err := packets.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		seg, ok := parseSegment(p)
+		if !ok || len(ports) > 0 && !ports[seg.srcPort] && !ports[seg.dstPort] {
+			continue
+		}
+		key := flow{srcPort: seg.srcPort, dstPort: seg.dstPort}
+		copy(key.src[:], seg.src.To16())
+		copy(key.dst[:], seg.dst.To16())
+		s := streams[key]
+		if s == nil || seg.syn && s.started && len(s.data) > 0 {
+			// A new connection, possibly reusing the ports of a previous
+			// one.
+			s = newStream(seg)
+			streams[key] = s
+			order = append(order, s)
+		}
+		s.add(seg, p.time)
+	}
+
+	c := &Capture{}
+	for _, s := range order {
+		messages, err := decodeStream(s, opts.Configuration)
+		c.Messages = append(c.Messages, messages...)
+		if err != nil {
+			c.Errors = append(c.Errors, err)
+		}
+	}
+	sort.SliceStable(c.Messages, func(i, j int) bool {
+		return c.Messages[i].Time.Before(c.Messages[j].Time)
+	})
+	return c, nil
+}
+
+// decodeStream decodes the messages of a stream, until its end or a message
+// which can't be decoded.
+func decodeStream(s *stream, conf *thrift.TConfiguration) ([]Message, *StreamError) {
+	var messages []Message
+	offset := 0
+	for offset < len(s.data) {
+		m, n, err := decodeMessage(s.data[offset:], conf)
+		if err == errTruncated && len(s.pending) > 0 {
+			err = errMissingPackets
+		}
+		if err != nil {
+			return messages, &StreamError{Src: s.src, Dst: s.dst, Offset: offset, Err: err}
+		}
+		m.Time, m.Src, m.Dst = s.timeAt(offset), s.src, s.dst
+		messages = append(messages, m)
+		offset += n
+	}
+	if len(s.pending) > 0 {
+		return messages, &StreamError{
+			Src:    s.src,
+			Dst:    s.dst,
+			Offset: offset,
+			Err:    errMissingPackets,
+		}
+	}
+	return messages, nil
+}
+
+// decodeMessage decodes the message at the beginning of data, returning its
+// length.
+//
+// The message is read from a buffer of its bytes only, as the transports and
+// protocols buffering their reads, like THeaderTransport, don't tell where
+// the messages end.
+func decodeMessage(data []byte, conf *thrift.TConfiguration) (Message, int, error) {
+	if len(data) < 8 && data[0]&0x80 == 0 {
+		// Shorter than a frame size and a message.
+		return Message{}, 0, errTruncated
+	}
+	m := Message{Transport: transportOf(data)}
+	msg, n := data, 0
+	if m.Transport != "unframed" {
+		if len(data) < 4 {
+			return Message{}, 0, errTruncated
+		}
+		size := binary.BigEndian.Uint32(data)
+		if size > uint32(conf.GetMaxFrameSize()) {
+			return Message{}, 0, fmt.Errorf("frame of %d bytes exceeds the max frame size", size)
+		}
+		if uint64(size) > uint64(len(data)-4) {
+			return Message{}, 0, errTruncated
+		}
+		msg, n = data[:4+size], 4+int(size)
+		if m.Transport == "framed" {
+			// The THeaderTransport reads the frame size itself.
+			msg = msg[4:]
+		}
+	}
+
+	buf := thrift.NewTMemoryBuffer()
+	buf.Write(msg)
+	var proto thrift.TProtocol
+	switch {
+	case m.Transport == "header":
+		proto = thrift.NewTHeaderProtocolConf(buf, conf)
+	case len(msg) >= 2 && msg[0] == 0x80 && msg[1] == 0x01:
+		m.Protocol = "binary"
+		proto = thrift.NewTBinaryProtocolConf(buf, conf)
+	case len(msg) >= 1 && msg[0] == 0x82:
+		m.Protocol = "compact"
+		proto = thrift.NewTCompactProtocolConf(buf, conf)
+	case len(msg) < 2:
+		return Message{}, 0, errTruncated
+	default:
+		return Message{}, 0, errors.New("unknown protocol")
+	}
+
+	ctx := context.Background()
+	var err error
+	if m.Method, m.Type, m.SeqID, err = proto.ReadMessageBegin(ctx); err == nil {
+		if m.Body, err = thrift.ReadValue(ctx, proto, thrift.STRUCT); err == nil {
+			err = proto.ReadMessageEnd(ctx)
+		}
+	}
+	if err != nil {
+		if m.Transport == "unframed" && errors.Is(err, io.EOF) {
+			// The unframed messages end with their body.
+			return Message{}, 0, errTruncated
+		}
+		return Message{}, 0, err
+	}
+	if hp, ok := proto.(*thrift.THeaderProtocol); ok {
+		if trans, ok := hp.Transport().(*thrift.THeaderTransport); ok {
+			m.Protocol = protocolName(trans.Protocol())
+		}
+		if headers := hp.GetReadHeaders(); len(headers) > 0 {
+			m.Headers = headers
+		}
+	}
+	if m.Transport == "unframed" {
+		n = len(msg) - buf.Len()
+	}
+	return m, n, nil
+}
+
+// transportOf returns the framing of the message at the beginning of data,
+// as detected by THeaderTransport.
+func transportOf(data []byte) string {
+	switch {
+	case len(data) < 8 || data[0]&0x80 != 0:
+		// The first byte of the binary and compact messages.
+		return "unframed"
+	case data[4] == 0x0f && data[5] == 0xff:
+		return "header"
+	default:
+		return "framed"
+	}
+}
+
+func protocolName(id thrift.THeaderProtocolID) string {
+	switch id {
+	case thrift.THeaderProtocolBinary:
+		return "binary"
+	case thrift.THeaderProtocolCompact:
+		return "compact"
+	default:
+		return fmt.Sprint(id)
+	}
+}
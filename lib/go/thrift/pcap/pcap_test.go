@@ -0,0 +1,304 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/apache/thrift/lib/go/thrift/idl"
+)
+
+// testCapture writes a capture in the pcap format, of Ethernet packets.
+type testCapture struct {
+	buf  bytes.Buffer
+	time time.Time
+}
+
+func newTestCapture() *testCapture {
+	c := &testCapture{time: time.Unix(1700000000, 0)}
+	var header [24]byte
+	binary.LittleEndian.PutUint32(header[0:], pcapMicros)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], linkEthernet)
+	c.buf.Write(header[:])
+	return c
+}
+
+// segment writes a TCP segment over IPv4, a millisecond after the previous
+// one.
+func (c *testCapture) segment(src, dst string, seq uint32, syn bool, payload []byte) {
+	c.time = c.time.Add(time.Millisecond)
+	c.packet(ethernet(etherIPv4, ipv4(src, dst, tcp(src, dst, seq, syn, payload))))
+}
+
+func (c *testCapture) packet(data []byte) {
+	var header [16]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(c.time.Unix()))
+	binary.LittleEndian.PutUint32(header[4:], uint32(c.time.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(data)))
+	c.buf.Write(header[:])
+	c.buf.Write(data)
+}
+
+func ethernet(etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14, 14+len(payload))
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+	return append(frame, payload...)
+}
+
+func splitHostPort(addr string) (net.IP, int) {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := net.LookupPort("tcp", port)
+	return net.ParseIP(host), p
+}
+
+func ipv4(src, dst string, payload []byte) []byte {
+	srcIP, _ := splitHostPort(src)
+	dstIP, _ := splitHostPort(dst)
+	packet := make([]byte, 20, 20+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:], uint16(20+len(payload)))
+	packet[8] = 64
+	packet[9] = protocolTCP
+	copy(packet[12:], srcIP.To4())
+	copy(packet[16:], dstIP.To4())
+	return append(packet, payload...)
+}
+
+func ipv6(src, dst string, payload []byte) []byte {
+	srcIP, _ := splitHostPort(src)
+	dstIP, _ := splitHostPort(dst)
+	packet := make([]byte, 40, 40+len(payload))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:], uint16(len(payload)))
+	packet[6] = protocolTCP
+	copy(packet[8:], srcIP.To16())
+	copy(packet[24:], dstIP.To16())
+	return append(packet, payload...)
+}
+
+func tcp(src, dst string, seq uint32, syn bool, payload []byte) []byte {
+	_, srcPort := splitHostPort(src)
+	_, dstPort := splitHostPort(dst)
+	segment := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(segment[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(segment[2:], uint16(dstPort))
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	segment[12] = 5 << 4
+	segment[13] = 0x10
+	if syn {
+		segment[13] |= 0x02
+	}
+	return append(segment, payload...)
+}
+
+// encode returns a message written with proto over trans, from buf.
+func encode(t *testing.T, buf *thrift.TMemoryBuffer, proto thrift.TProtocol, name string, typeID thrift.TMessageType, seqID int32, body thrift.Value) []byte {
+	ctx := context.Background()
+	if err := proto.WriteMessageBegin(ctx, name, typeID, seqID); err != nil {
+		t.Fatal(err)
+	}
+	if err := body.Write(ctx, proto); err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.WriteMessageEnd(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+func framedBinary(t *testing.T, name string, typeID thrift.TMessageType, seqID int32, body thrift.Value) []byte {
+	buf := thrift.NewTMemoryBuffer()
+	proto := thrift.NewTBinaryProtocolConf(thrift.NewTFramedTransportConf(buf, nil), nil)
+	return encode(t, buf, proto, name, typeID, seqID, body)
+}
+
+func unframedCompact(t *testing.T, name string, typeID thrift.TMessageType, seqID int32, body thrift.Value) []byte {
+	buf := thrift.NewTMemoryBuffer()
+	return encode(t, buf, thrift.NewTCompactProtocolConf(buf, nil), name, typeID, seqID, body)
+}
+
+func headerCompact(t *testing.T, name string, typeID thrift.TMessageType, seqID int32, body thrift.Value, headers map[string]string) []byte {
+	buf := thrift.NewTMemoryBuffer()
+	proto := thrift.NewTHeaderProtocolConf(buf, &thrift.TConfiguration{
+		THeaderProtocolID: thrift.THeaderProtocolIDPtrMust(thrift.THeaderProtocolCompact),
+	})
+	for k, v := range headers {
+		proto.SetWriteHeader(k, v)
+	}
+	return encode(t, buf, proto, name, typeID, seqID, body)
+}
+
+const testIDL = `
+enum Status {
+  ACTIVE = 1,
+  BANNED = 2
+}
+
+struct User {
+  1: i64 id
+  2: Status status
+}
+
+service Users {
+  User get(1: i64 id)
+}
+`
+
+func TestRead(t *testing.T) {
+	const (
+		client1 = "10.0.0.1:50000"
+		client2 = "10.0.0.3:50001"
+		server  = "10.0.0.2:9090"
+		other   = "10.0.0.2:80"
+	)
+	args := thrift.StructValue(thrift.ValueField{ID: 1, Value: thrift.I64Value(42)})
+	result := thrift.StructValue(thrift.ValueField{ID: 0, Value: thrift.StructValue(
+		thrift.ValueField{ID: 1, Value: thrift.I64Value(42)},
+		thrift.ValueField{ID: 2, Value: thrift.I32Value(2)},
+	)})
+	call := framedBinary(t, "get", thrift.CALL, 1, args)
+	reply := headerCompact(t, "get", thrift.REPLY, 1, result, map[string]string{"server": "a"})
+	call2 := unframedCompact(t, "get", thrift.CALL, 7, args)
+	exception := unframedCompact(t, "get", thrift.EXCEPTION, 7, thrift.StructValue(
+		thrift.ValueField{ID: 1, Value: thrift.StringValue("failed")},
+		thrift.ValueField{ID: 2, Value: thrift.I32Value(thrift.INTERNAL_ERROR)},
+	))
+
+	c := newTestCapture()
+	// The first connection, with a call split in two segments, the second
+	// one first, and retransmitted.
+	c.segment(client1, server, 1000, true, nil)
+	c.segment(server, client1, 5000, true, nil)
+	c.segment(client1, server, 1001+5, false, call[5:])
+	c.segment(client1, server, 1001, false, call[:5])
+	c.segment(client1, server, 1001+5, false, call[5:])
+	c.segment(server, client1, 5001, false, reply)
+	// The second connection, opened before the capture, whose sequence
+	// numbers wrap around.
+	c.segment(client2, server, 0xffffffff-3, false, call2)
+	c.segment(server, client2, 77, false, exception)
+	// Traffic of another port.
+	c.segment(client1, other, 1, false, []byte("GET / HTTP/1.1\r\n\r\n"))
+
+	capture, err := Read(bytes.NewReader(c.buf.Bytes()), &Options{Ports: []int{9090}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(capture.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", capture.Errors)
+	}
+	start := time.Unix(1700000000, 0)
+	expected := []Message{
+		{Time: start.Add(4 * time.Millisecond), Src: client1, Dst: server, Transport: "framed", Protocol: "binary", Method: "get", Type: thrift.CALL, SeqID: 1, Body: args},
+		{Time: start.Add(6 * time.Millisecond), Src: server, Dst: client1, Transport: "header", Protocol: "compact", Method: "get", Type: thrift.REPLY, SeqID: 1, Headers: thrift.THeaderMap{"server": "a"}, Body: result},
+		{Time: start.Add(7 * time.Millisecond), Src: client2, Dst: server, Transport: "unframed", Protocol: "compact", Method: "get", Type: thrift.CALL, SeqID: 7, Body: args},
+		{Time: start.Add(8 * time.Millisecond), Src: server, Dst: client2, Transport: "unframed", Protocol: "compact", Method: "get", Type: thrift.EXCEPTION, SeqID: 7, Body: capture.Messages[3].Body},
+	}
+	if len(capture.Messages) != len(expected) {
+		t.Fatalf("expected %d messages, got %+v", len(expected), capture.Messages)
+	}
+	for i, m := range capture.Messages {
+		if !thrift.Equal(&m.Body, &expected[i].Body) {
+			t.Errorf("message %d: expected the body %v, got %v", i, expected[i].Body, m.Body)
+		}
+		m.Body, expected[i].Body = thrift.Value{}, thrift.Value{}
+		if !reflect.DeepEqual(m, expected[i]) {
+			t.Errorf("message %d: expected %+v, got %+v", i, expected[i], m)
+		}
+	}
+
+	doc, err := idl.Parse("users.thrift", []byte(testIDL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, fields := range []map[string]interface{}{
+		{"id": int64(42)},
+		{"success": map[string]interface{}{"id": int64(42), "status": "BANNED"}},
+		{"id": int64(42)},
+		{"message": "failed", "type": int64(thrift.INTERNAL_ERROR)},
+	} {
+		if got, err := capture.Messages[i].Interface(doc, ""); err != nil || !reflect.DeepEqual(got, fields) {
+			t.Errorf("message %d: expected the fields %v, got %v (%v)", i, fields, got, err)
+		}
+	}
+	if got, err := capture.Messages[0].Interface(nil, ""); err != nil || !reflect.DeepEqual(got, map[string]interface{}{"1": int64(42)}) {
+		t.Errorf("expected the fields by id without IDL, got %v (%v)", got, err)
+	}
+}
+
+func TestReadStreamErrors(t *testing.T) {
+	const (
+		client = "[2001:db8::1]:50000"
+		server = "[2001:db8::2]:9090"
+	)
+	args := thrift.StructValue(thrift.ValueField{ID: 1, Value: thrift.I64Value(42)})
+	call := framedBinary(t, "get", thrift.CALL, 1, args)
+	reply := unframedCompact(t, "get", thrift.REPLY, 1, args)
+
+	c := newTestCapture()
+	segment := func(src, dst string, seq uint32, payload []byte) {
+		c.time = c.time.Add(time.Millisecond)
+		c.packet(ethernet(etherIPv6, ipv6(src, dst, tcp(src, dst, seq, false, payload))))
+	}
+	// A complete call, then one missing its middle packet.
+	segment(client, server, 1, call)
+	segment(client, server, 1+uint32(len(call)), call[:4])
+	segment(client, server, 1+uint32(len(call))+8, call[8:])
+	// A reply truncated by the end of the capture.
+	segment(server, client, 1, reply[:len(reply)-1])
+	// Not thrift.
+	segment("[2001:db8::3]:50001", server, 1, []byte("GET / HTTP/1.1\r\n\r\n"))
+
+	capture, err := Read(bytes.NewReader(c.buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(capture.Messages) != 1 || capture.Messages[0].Src != client || capture.Messages[0].Method != "get" {
+		t.Errorf("expected the complete call only, got %+v", capture.Messages)
+	}
+	if len(capture.Errors) != 3 {
+		t.Fatalf("expected 3 stream errors, got %v", capture.Errors)
+	}
+	for i, expected := range []StreamError{
+		{Src: client, Dst: server, Offset: len(call), Err: errMissingPackets},
+		{Src: server, Dst: client, Offset: 0, Err: errTruncated},
+		{Src: "[2001:db8::3]:50001", Dst: server, Offset: 0},
+	} {
+		got := capture.Errors[i]
+		if got.Src != expected.Src || got.Dst != expected.Dst || got.Offset != expected.Offset || expected.Err != nil && !errors.Is(got, expected.Err) {
+			t.Errorf("error %d: expected %v, got %v", i, &expected, got)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package pcap
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The link types of the packets decoded.
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkSLL2     = 276
+)
+
+// The ether types of the packets decoded.
+const (
+	etherIPv4 = 0x0800
+	etherIPv6 = 0x86dd
+	etherVLAN = 0x8100
+	etherQinQ = 0x88a8
+)
+
+const protocolTCP = 6
+
+// tcpSegment is a TCP segment of a packet.
+type tcpSegment struct {
+	src, dst         net.IP
+	srcPort, dstPort int
+	seq              uint32
+	syn              bool
+	payload          []byte
+}
+
+// parseSegment returns the TCP segment of a packet, false if it's not a TCP
+// segment over IPv4 or IPv6, or a fragment of it.
+func parseSegment(p packet) (tcpSegment, bool) {
+	ip, ok := linkPayload(p.linkType, p.data)
+	if !ok || len(ip) < 1 {
+		return tcpSegment{}, false
+	}
+	var seg tcpSegment
+	var tcp []byte
+	switch ip[0] >> 4 {
+	case 4:
+		if len(ip) < 20 {
+			return tcpSegment{}, false
+		}
+		headerSize := int(ip[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(ip[2:]))
+		// The fragments have the more fragments flag or an offset.
+		if ip[9] != protocolTCP || binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 || headerSize < 20 || total < headerSize {
+			return tcpSegment{}, false
+		}
+		// The link layer may pad the packet, or the capture truncate it.
+		if total < len(ip) {
+			ip = ip[:total]
+		}
+		if headerSize > len(ip) {
+			return tcpSegment{}, false
+		}
+		seg.src, seg.dst = net.IP(ip[12:16]), net.IP(ip[16:20])
+		tcp = ip[headerSize:]
+	case 6:
+		if len(ip) < 40 {
+			return tcpSegment{}, false
+		}
+		if total := 40 + int(binary.BigEndian.Uint16(ip[4:])); total < len(ip) {
+			ip = ip[:total]
+		}
+		seg.src, seg.dst = net.IP(ip[8:24]), net.IP(ip[24:40])
+		next, rest := ip[6], ip[40:]
+		for next != protocolTCP {
+			switch next {
+			case 0, 43, 60:
+				// The hop-by-hop, routing and destination options
+				// extension headers.
+				if len(rest) < 8 || len(rest) < (int(rest[1])+1)*8 {
+					return tcpSegment{}, false
+				}
+				next, rest = rest[0], rest[(int(rest[1])+1)*8:]
+			default:
+				return tcpSegment{}, false
+			}
+		}
+		tcp = rest
+	default:
+		return tcpSegment{}, false
+	}
+	if len(tcp) < 20 {
+		return tcpSegment{}, false
+	}
+	headerSize := int(tcp[12]>>4) * 4
+	if headerSize < 20 || headerSize > len(tcp) {
+		return tcpSegment{}, false
+	}
+	seg.srcPort = int(binary.BigEndian.Uint16(tcp[0:]))
+	seg.dstPort = int(binary.BigEndian.Uint16(tcp[2:]))
+	seg.seq = binary.BigEndian.Uint32(tcp[4:])
+	seg.syn = tcp[13]&0x02 != 0
+	seg.payload = tcp[headerSize:]
+	return seg, true
+}
+
+// linkPayload returns the IP packet of a packet of the link type.
+func linkPayload(linkType uint32, data []byte) ([]byte, bool) {
+	switch linkType {
+	case linkNull, linkLoop:
+		// The address family, in the byte order of the host for the null
+		// link type. The IP version is read from the IP header anyway.
+		if len(data) < 4 {
+			return nil, false
+		}
+		return data[4:], true
+	case linkEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType, data := binary.BigEndian.Uint16(data[12:]), data[14:]
+		for etherType == etherVLAN || etherType == etherQinQ {
+			if len(data) < 4 {
+				return nil, false
+			}
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+		return data, etherType == etherIPv4 || etherType == etherIPv6
+	case linkRaw:
+		return data, true
+	case linkSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(data[14:])
+		return data[16:], etherType == etherIPv4 || etherType == etherIPv6
+	case linkSLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(data)
+		return data[20:], etherType == etherIPv4 || etherType == etherIPv6
+	default:
+		return nil, false
+	}
+}
+
+// stream is a direction of a TCP connection, reassembled from its segments.
+type stream struct {
+	src, dst string
+
+	// started is set once the initial sequence number is known, from the
+	// SYN segment, or from the first segment for the connections opened
+	// before the capture. next is the sequence number of the byte following
+	// data.
+	started bool
+	next    uint32
+
+	data   []byte
+	chunks []chunk
+	// pending are the segments received out of order.
+	pending []pendingSegment
+}
+
+// chunk is the time the bytes of a stream from offset were received.
+type chunk struct {
+	offset int
+	time   time.Time
+}
+
+type pendingSegment struct {
+	seq     uint32
+	payload []byte
+	time    time.Time
+}
+
+func newStream(seg tcpSegment) *stream {
+	return &stream{
+		src: net.JoinHostPort(seg.src.String(), strconv.Itoa(seg.srcPort)),
+		dst: net.JoinHostPort(seg.dst.String(), strconv.Itoa(seg.dstPort)),
+	}
+}
+
+// add adds a segment of the stream, received at t.
+func (s *stream) add(seg tcpSegment, t time.Time) {
+	seq := seg.seq
+	if seg.syn {
+		// The SYN takes a sequence number.
+		seq++
+		s.started, s.next = true, seq
+	} else if !s.started {
+		s.started, s.next = true, seq
+	}
+	if len(seg.payload) == 0 {
+		return
+	}
+	s.pending = append(s.pending, pendingSegment{
+		seq:     seq,
+		payload: seg.payload,
+		time:    t,
+	})
+	for s.deliver() {
+	}
+}
+
+// deliver appends the pending segment continuing the data to it, dropping
+// the retransmitted segments. It returns false if there's none.
+func (s *stream) deliver() bool {
+	for i, p := range s.pending {
+		// The distance handles the wraparound of the sequence numbers.
+		before := int32(s.next - p.seq)
+		if before < 0 {
+			continue
+		}
+		s.pending = append(s.pending[:i], s.pending[i+1:]...)
+		if int(before) < len(p.payload) {
+			s.chunks = append(s.chunks, chunk{offset: len(s.data), time: p.time})
+			s.data = append(s.data, p.payload[before:]...)
+			s.next += uint32(len(p.payload) - int(before))
+		}
+		return true
+	}
+	return false
+}
+
+// timeAt returns the time the byte of the stream at offset was received.
+func (s *stream) timeAt(offset int) time.Time {
+	i := sort.Search(len(s.chunks), func(i int) bool {
+		return s.chunks[i].offset > offset
+	})
+	if i == 0 {
+		return time.Time{}
+	}
+	return s.chunks[i-1].time
+}
@@ -0,0 +1,92 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// See https://godoc.org/context#WithValue on why do we need the unexported typedefs.
+type peerKey struct{}
+
+// tPeer is the information about the remote end of a server connection.
+type tPeer struct {
+	addr net.Addr
+	conn net.Conn
+}
+
+// AddPeerToContext adds the remote address and, for TLS connections, the TLS
+// connection state of trans into the context.
+//
+// trans is usually a TSocket or TSSLSocket accepted by a TServerSocket or
+// TSSLServerSocket. Other TTransport implementations are supported as long as
+// they have a Conn() net.Conn function.
+//
+// TSimpleServer calls it on every accepted connection, so it's only needed by
+// custom TServer implementations.
+func AddPeerToContext(ctx context.Context, trans TTransport) context.Context {
+	c, ok := trans.(interface{ Conn() net.Conn })
+	if !ok {
+		return ctx
+	}
+	conn := c.Conn()
+	if sc, isSocketConn := conn.(*socketConn); isSocketConn {
+		if sc == nil {
+			return ctx
+		}
+		conn = sc.Conn
+	}
+	if conn == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerKey{}, tPeer{
+		addr: conn.RemoteAddr(),
+		conn: conn,
+	})
+}
+
+// RemoteAddrFromContext returns the address of the client of the request
+// being handled, if known.
+func RemoteAddrFromContext(ctx context.Context) (addr net.Addr, ok bool) {
+	peer, ok := ctx.Value(peerKey{}).(tPeer)
+	if !ok || peer.addr == nil {
+		return nil, false
+	}
+	return peer.addr, true
+}
+
+// TLSStateFromContext returns the TLS connection state of the client of the
+// request being handled, if it's connected over TLS.
+func TLSStateFromContext(ctx context.Context) (state *tls.ConnectionState, ok bool) {
+	peer, ok := ctx.Value(peerKey{}).(tPeer)
+	if !ok {
+		return nil, false
+	}
+	tlsConn, ok := peer.conn.(interface{ ConnectionState() tls.ConnectionState })
+	if !ok {
+		return nil, false
+	}
+	// The handshake is done on the first read of the connection, so the
+	// state is read lazily, instead of when the connection is accepted.
+	s := tlsConn.ConnectionState()
+	return &s, true
+}
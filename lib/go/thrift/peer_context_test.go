@@ -0,0 +1,170 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// selfSignedCertificate generates a self-signed certificate for tests.
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "thrift test"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func TestRemoteAddrFromContext(t *testing.T) {
+	processor := &contextCapturingProcessor{
+		mockProcessor: echoProcessor(),
+		ctxs:          make(chan context.Context, 1),
+	}
+	serv, addr := startTestSocketServer(t, processor, nil)
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	sock := dialTestSocketServer(t, addr)
+	if err := echoCall(t, NewTBinaryProtocolConf(sock, nil), 1, "addr"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := <-processor.ctxs
+	remote, ok := RemoteAddrFromContext(ctx)
+	if !ok {
+		t.Fatal("expected remote address in context")
+	}
+	if remote.String() != sock.Conn().LocalAddr().String() {
+		t.Errorf("expected remote address %v, got %v", sock.Conn().LocalAddr(), remote)
+	}
+	if _, ok := TLSStateFromContext(ctx); ok {
+		t.Error("expected no TLS state for plain TCP connection")
+	}
+
+	if _, ok := RemoteAddrFromContext(context.Background()); ok {
+		t.Error("expected no remote address in background context")
+	}
+	if ctx := AddPeerToContext(context.Background(), NewTMemoryBuffer()); ctx != context.Background() {
+		t.Error("expected context to be unchanged for transports without Conn")
+	}
+	if _, ok := RemoteAddrFromContext(AddPeerToContext(context.Background(), &TSocket{})); ok {
+		t.Error("expected no remote address for unopened socket")
+	}
+}
+
+func TestTLSStateFromContext(t *testing.T) {
+	cert := selfSignedCertificate(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTrans := &mockServerTransport{
+		ListenFunc: func() error {
+			return nil
+		},
+		AcceptFunc: func() (TTransport, error) {
+			conn, err := listener.Accept()
+			if err != nil {
+				return nil, err
+			}
+			return NewTSSLSocketFromConnConf(conn, nil), nil
+		},
+		CloseFunc: func() error {
+			return listener.Close()
+		},
+		InterruptFunc: func() error {
+			return listener.Close()
+		},
+	}
+	processor := &contextCapturingProcessor{
+		mockProcessor: echoProcessor(),
+		ctxs:          make(chan context.Context, 1),
+	}
+	serv := NewTSimpleServer2(processor, serverTrans)
+	serv.SetLogger(func(string) {})
+	go serv.AcceptLoop()
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	sock, err := NewTSSLSocketConf(listener.Addr().String(), &TConfiguration{
+		SocketTimeout: 5 * time.Second,
+		TLSConfig: &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sock.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sock.Close()
+	})
+	if err := echoCall(t, NewTBinaryProtocolConf(sock, nil), 1, "tls"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := <-processor.ctxs
+	if _, ok := RemoteAddrFromContext(ctx); !ok {
+		t.Error("expected remote address in context")
+	}
+	state, ok := TLSStateFromContext(ctx)
+	if !ok {
+		t.Fatal("expected TLS state in context")
+	}
+	if !state.HandshakeComplete {
+		t.Error("expected handshake to be complete")
+	}
+	if len(state.PeerCertificates) != 1 || state.PeerCertificates[0].Subject.CommonName != "thrift test" {
+		t.Errorf("unexpected peer certificates: %v", state.PeerCertificates)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+)
+
+// TPeerIdentity is the identity of a client authenticated by a verified TLS
+// client certificate (mTLS).
+type TPeerIdentity struct {
+	// CommonName is the Common Name of the subject of the certificate.
+	CommonName string
+
+	// The Subject Alternative Names of the certificate.
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+
+	// SPIFFEID is the SPIFFE ID of the client, the URI SAN with the
+	// "spiffe" scheme, "" if the certificate is not a valid X509-SVID (with
+	// exactly one URI SAN, of the "spiffe" scheme).
+	SPIFFEID string
+
+	// Certificate is the client certificate.
+	Certificate *x509.Certificate
+
+	// Chain is the verified chain of Certificate, from Certificate to the
+	// root.
+	Chain []*x509.Certificate
+}
+
+// PeerIdentityFromTLSState returns the identity of the client of a TLS
+// connection, if its certificate is verified.
+//
+// The client certificates are only verified when the ClientAuth of the
+// tls.Config of the server is tls.RequireAndVerifyClientCert or
+// tls.VerifyClientCertIfGiven.
+func PeerIdentityFromTLSState(state *tls.ConnectionState) (*TPeerIdentity, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	chain := state.VerifiedChains[0]
+	cert := chain[0]
+	id := &TPeerIdentity{
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+		Certificate:    cert,
+		Chain:          chain,
+	}
+	if len(cert.URIs) == 1 && cert.URIs[0].Scheme == "spiffe" {
+		id.SPIFFEID = cert.URIs[0].String()
+	}
+	return id, true
+}
+
+type peerIdentityKey struct{}
+
+// AddPeerIdentityToContext adds the identity of the client into the context,
+// for the servers whose connections are not known to AddPeerToContext, e.g.
+// HTTP servers.
+func AddPeerIdentityToContext(ctx context.Context, id *TPeerIdentity) context.Context {
+	return context.WithValue(ctx, peerIdentityKey{}, id)
+}
+
+// PeerIdentityFromContext returns the identity of the client of the request
+// being handled, if it's connected over TLS with a verified client
+// certificate, see PeerIdentityFromTLSState.
+func PeerIdentityFromContext(ctx context.Context) (*TPeerIdentity, bool) {
+	if id, ok := ctx.Value(peerIdentityKey{}).(*TPeerIdentity); ok && id != nil {
+		return id, true
+	}
+	state, ok := TLSStateFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return PeerIdentityFromTLSState(state)
+}
@@ -0,0 +1,80 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+)
+
+// tlsStateConn is a net.Conn with a TLS connection state.
+type tlsStateConn struct {
+	net.Conn
+
+	state tls.ConnectionState
+}
+
+func (c tlsStateConn) ConnectionState() tls.ConnectionState {
+	return c.state
+}
+
+func TestPeerIdentityFromContext(t *testing.T) {
+	spiffeID, _ := url.Parse("spiffe://example.com/ns/prod/sa/batch")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "batch"},
+		DNSNames: []string{"batch.example.com"},
+		URIs:     []*url.URL{spiffeID},
+	}
+	root := &x509.Certificate{Subject: pkix.Name{CommonName: "root"}}
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	ctx := context.WithValue(context.Background(), peerKey{}, tPeer{conn: tlsStateConn{state: state}})
+	if _, ok := PeerIdentityFromContext(ctx); ok {
+		t.Error("expected no identity for an unverified certificate")
+	}
+
+	state.VerifiedChains = [][]*x509.Certificate{{cert, root}}
+	ctx = context.WithValue(context.Background(), peerKey{}, tPeer{conn: tlsStateConn{state: state}})
+	id, ok := PeerIdentityFromContext(ctx)
+	if !ok {
+		t.Fatal("expected an identity for a verified certificate")
+	}
+	if id.CommonName != "batch" || len(id.DNSNames) != 1 || id.SPIFFEID != spiffeID.String() || id.Certificate != cert || len(id.Chain) != 2 {
+		t.Errorf("unexpected identity %+v", id)
+	}
+
+	other, _ := url.Parse("https://example.com/batch")
+	cert.URIs = append(cert.URIs, other)
+	if id, _ := PeerIdentityFromTLSState(&state); id.SPIFFEID != "" {
+		t.Errorf("expected no SPIFFE ID with multiple URI SANs, got %q", id.SPIFFEID)
+	}
+
+	if _, ok := PeerIdentityFromContext(context.Background()); ok {
+		t.Error("expected no identity in background context")
+	}
+	explicit := &TPeerIdentity{CommonName: "http client"}
+	if id, _ := PeerIdentityFromContext(AddPeerIdentityToContext(ctx, explicit)); id != explicit {
+		t.Errorf("expected the identity added to the context, got %+v", id)
+	}
+}
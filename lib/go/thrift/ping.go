@@ -0,0 +1,238 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PingMethod is the reserved method name of the pings sent by Ping, which
+// TSimpleServer and TNetpollServer reply to themselves, without calling
+// their processor, see their SetPingReplies.
+const PingMethod = "__thrift_ping"
+
+// Ping checks that the connection of client works, with a call to PingMethod
+// cheaper than any call to the service: its arguments and result are empty,
+// and the servers with SetPingReplies enabled reply to it without calling
+// their processor, their hooks or their limits.
+//
+// The UNKNOWN_METHOD TApplicationException replied by the servers not
+// supporting pings are not errors, as they show that the connection works.
+func Ping(ctx context.Context, client TClient) error {
+	_, err := client.Call(ctx, PingMethod, &tPingStruct{}, &tPingStruct{})
+	var exc TApplicationException
+	if errors.As(err, &exc) && exc.TypeId() == UNKNOWN_METHOD {
+		return nil
+	}
+	return err
+}
+
+// tPingStruct is the empty arguments and result of the pings.
+type tPingStruct struct{}
+
+func (*tPingStruct) Read(ctx context.Context, in TProtocol) error {
+	return in.Skip(ctx, STRUCT)
+}
+
+func (*tPingStruct) Write(ctx context.Context, out TProtocol) error {
+	if err := out.WriteStructBegin(ctx, "ping"); err != nil {
+		return err
+	}
+	if err := out.WriteFieldStop(ctx); err != nil {
+		return err
+	}
+	return out.WriteStructEnd(ctx)
+}
+
+// tPingProcessor replies to the pings of a server, and passes the other
+// requests to the wrapped processor.
+type tPingProcessor struct {
+	TProcessor
+}
+
+func (p *tPingProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	// A multiplexed client prefixes the method with the service.
+	if name != PingMethod && !strings.HasSuffix(name, MULTIPLEXED_SEPARATOR+PingMethod) {
+		return p.TProcessor.Process(ctx, NewStoredMessageProtocol(in, name, typeID, seqID), out)
+	}
+	if err := in.Skip(ctx, STRUCT); err != nil {
+		return false, WrapTException(err)
+	}
+	if err := in.ReadMessageEnd(ctx); err != nil {
+		return false, WrapTException(err)
+	}
+	if typeID == ONEWAY {
+		return true, nil
+	}
+	// Replied without the service, like TMultiplexedProcessor does.
+	if err := writeEmptyReply(ctx, out, PingMethod, seqID); err != nil {
+		return false, WrapTException(err)
+	}
+	return true, nil
+}
+
+// writeEmptyReply writes the empty REPLY of a request to a reserved method.
+func writeEmptyReply(ctx context.Context, out TProtocol, method string, seqID int32) error {
+	if err := out.WriteMessageBegin(ctx, method, REPLY, seqID); err != nil {
+		return err
+	}
+	if err := (&tPingStruct{}).Write(ctx, out); err != nil {
+		return err
+	}
+	if err := out.WriteMessageEnd(ctx); err != nil {
+		return err
+	}
+	return out.Flush(ctx)
+}
+
+// Default values of KeepaliveOptions.
+const (
+	DefaultKeepaliveInterval = 30 * time.Second
+	DefaultKeepaliveTimeout  = 5 * time.Second
+)
+
+// KeepaliveOptions configures NewTKeepaliveClient.
+type KeepaliveOptions struct {
+	// Interval is how long the client can be idle before being pinged,
+	// DefaultKeepaliveInterval if 0.
+	Interval time.Duration
+
+	// Timeout is the timeout of the pings, DefaultKeepaliveTimeout if 0.
+	Timeout time.Duration
+
+	// OnFailure is called with the error of every failed ping, for example
+	// to close the connection so that it's reopened, or to remove it from
+	// a pool.
+	OnFailure func(err error)
+}
+
+// TKeepaliveClient is a TClient pinging the server when it's idle, to keep
+// its connection open through the proxies and firewalls closing the idle
+// connections, and to detect the broken connections before the next call.
+//
+// The calls and the pings are serialized, so the wrapped client doesn't need
+// to be safe for concurrent use.
+type TKeepaliveClient struct {
+	client TClient
+	opts   KeepaliveOptions
+
+	mu sync.Mutex
+	// last is the end of the last call or ping.
+	last time.Time
+	// err is the error of the last ping.
+	err error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTKeepaliveClient returns a TKeepaliveClient wrapping client, and starts
+// the goroutine pinging it, stopped by Close.
+func NewTKeepaliveClient(client TClient, opts KeepaliveOptions) *TKeepaliveClient {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultKeepaliveInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultKeepaliveTimeout
+	}
+	c := &TKeepaliveClient{
+		client: client,
+		opts:   opts,
+		last:   time.Now(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.keepalive()
+	return c
+}
+
+// Call implements TClient.
+func (c *TKeepaliveClient) Call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	meta, err := c.client.Call(ctx, method, args, result)
+	c.last = time.Now()
+	return meta, err
+}
+
+// Ping pings the server now, see Ping.
+func (c *TKeepaliveClient) Ping(ctx context.Context) error {
+	c.mu.Lock()
+	err := Ping(ctx, c.client)
+	c.last = time.Now()
+	c.err = err
+	c.mu.Unlock()
+	if err != nil && c.opts.OnFailure != nil {
+		c.opts.OnFailure(err)
+	}
+	return err
+}
+
+// Err returns the error of the last ping, nil if it succeeded.
+func (c *TKeepaliveClient) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// keepalive pings the server once idle for Interval, until Close is called.
+func (c *TKeepaliveClient) keepalive() {
+	defer close(c.done)
+	timer := time.NewTimer(c.opts.Interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-timer.C:
+		}
+		c.mu.Lock()
+		idle := time.Since(c.last)
+		c.mu.Unlock()
+		if idle < c.opts.Interval {
+			timer.Reset(c.opts.Interval - idle)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+		c.Ping(ctx)
+		cancel()
+		timer.Reset(c.opts.Interval)
+	}
+}
+
+// Close stops pinging the server. It doesn't close the wrapped client.
+func (c *TKeepaliveClient) Close() error {
+	close(c.stop)
+	<-c.done
+	return nil
+}
+
+var (
+	_ TClient    = (*TKeepaliveClient)(nil)
+	_ TProcessor = (*tPingProcessor)(nil)
+)
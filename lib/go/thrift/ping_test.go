@@ -0,0 +1,129 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	serv, addr := startTestSocketServer(t, echoProcessor(), func(s *TSimpleServer) {
+		s.SetPingReplies(true)
+		s.SetPreDecodeHook(func(ctx context.Context, info TMessageInfo) error {
+			return NewTApplicationException(PROTOCOL_ERROR, "rejected")
+		})
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	proto := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+
+	// The pings are replied without calling the hook.
+	if err := Ping(ctx, NewTStandardClient(proto, proto)); err != nil {
+		t.Fatal(err)
+	}
+	multiplexed := NewTMultiplexedProtocol(proto, "MyService")
+	if err := Ping(ctx, NewTStandardClient(proto, multiplexed)); err != nil {
+		t.Fatalf("multiplexed: %v", err)
+	}
+	exc, err := callExpectingException(proto, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exc.TypeId() != PROTOCOL_ERROR {
+		t.Errorf("expected the other calls to be rejected by the hook, got %v", exc)
+	}
+
+	// The servers not replying to pings reply UNKNOWN_METHOD.
+	serv, addr = startTestSocketServer(t, &mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			ctx := context.Background()
+			name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			exc := NewTApplicationException(UNKNOWN_METHOD, "unknown method "+name)
+			return true, WrapTException(skipRequestWithException(ctx, in, out, name, typeID, seqID, exc))
+		},
+	}, nil)
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	proto = NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+	if err := Ping(ctx, NewTStandardClient(proto, proto)); err != nil {
+		t.Errorf("expected UNKNOWN_METHOD not to fail the ping, got %v", err)
+	}
+}
+
+func TestTKeepaliveClient(t *testing.T) {
+	var mu sync.Mutex
+	var pingErr error
+	pings := make(chan struct{}, 100)
+	client := WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			if method != PingMethod {
+				return ResponseMeta{}, nil
+			}
+			pings <- struct{}{}
+			mu.Lock()
+			defer mu.Unlock()
+			return ResponseMeta{}, pingErr
+		},
+	}
+	failures := make(chan error, 100)
+	c := NewTKeepaliveClient(client, KeepaliveOptions{
+		Interval: 10 * time.Millisecond,
+		OnFailure: func(err error) {
+			failures <- err
+		},
+	})
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pings:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the idle client was not pinged")
+		}
+	}
+	if err := c.Err(); err != nil {
+		t.Errorf("expected the pings to succeed, got %v", err)
+	}
+
+	mu.Lock()
+	pingErr = errors.New("connection reset")
+	mu.Unlock()
+	select {
+	case err := <-failures:
+		if err.Error() != "connection reset" {
+			t.Errorf("unexpected failure %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the failed ping was not reported")
+	}
+	if c.Err() == nil {
+		t.Error("expected the error of the last ping")
+	}
+}
@@ -0,0 +1,337 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// errPipelinedClientClosed is returned by the calls of a closed
+// TPipelinedClient.
+var errPipelinedClientClosed = NewTTransportException(NOT_OPEN, "pipelined client closed")
+
+// TPipelinedClient is a TClient sending the concurrent calls of multiple
+// goroutines over a single connection, without waiting for the replies of the
+// previous calls. The replies are matched to their calls by seqid, so they can
+// be received in any order, unless another TSeqIDMismatchPolicy is set.
+//
+// The messages are framed, with TFramedTransport or THeaderTransport, so that
+// the servers processing the requests of a connection concurrently can split
+// them. A TSimpleServer processes them in order, which still saves a round
+// trip per call.
+//
+// Unlike TStandardClient, it is safe for concurrent use.
+type TPipelinedClient struct {
+	conn         TTransport
+	iprot, oprot TProtocol
+
+	// writeMu serializes the writes of the calls.
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	seqID   int32
+	pending map[int32]*tPipelinedCall
+	// outstanding are the seqids of the calls whose reply wasn't read yet,
+	// in the order they were sent, including the canceled calls.
+	outstanding []int32
+	seqIDPolicy TSeqIDMismatchPolicy
+	// err is the error closing the client, nil while it's open.
+	err error
+
+	// done is closed when the reading goroutine returns.
+	done chan struct{}
+}
+
+// tPipelinedCall is a call of a TPipelinedClient waiting for its reply.
+type tPipelinedCall struct {
+	method string
+	result TStruct
+	meta   ResponseMeta
+
+	// done receives the error of the call once its reply is read.
+	done chan error
+}
+
+// NewTPipelinedClient returns a TPipelinedClient over conn, an open
+// connection such as a TSocket or a TSSLSocket.
+//
+// protocolFactory is used over conn if it was returned by
+// NewTHeaderProtocolFactoryConf, and over a TFramedTransport configured with
+// conf otherwise.
+//
+// It starts a goroutine reading the replies until the connection fails or
+// Close is called, after which all the calls fail. Close must be called to
+// close conn.
+func NewTPipelinedClient(conn TTransport, protocolFactory TProtocolFactory, conf *TConfiguration) *TPipelinedClient {
+	// The reads and the writes are concurrent, so they don't share their
+	// transport and protocol.
+	newProtocol := func() TProtocol {
+		if _, ok := protocolFactory.(tHeaderProtocolFactory); ok {
+			return protocolFactory.GetProtocol(conn)
+		}
+		return protocolFactory.GetProtocol(NewTFramedTransportConf(conn, conf))
+	}
+	p := &TPipelinedClient{
+		conn:    conn,
+		iprot:   newProtocol(),
+		oprot:   newProtocol(),
+		pending: make(map[int32]*tPipelinedCall),
+		done:    make(chan struct{}),
+	}
+	go p.readLoop()
+	return p
+}
+
+// SetSeqIDMismatchPolicy sets how the replies received out of order are
+// handled, SeqIDMismatchDeliver by default.
+func (p *TPipelinedClient) SetSeqIDMismatchPolicy(policy TSeqIDMismatchPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seqIDPolicy = policy
+}
+
+// Call implements TClient.
+//
+// When ctx is done before the reply is received, Call returns ctx.Err() and
+// the reply is discarded when it arrives, unless it is already being read into
+// result, in which case Call waits for it.
+func (p *TPipelinedClient) Call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	var call *tPipelinedCall
+	// method is oneway when result is nil
+	if result != nil {
+		call = &tPipelinedCall{
+			method: method,
+			result: result,
+			done:   make(chan error, 1),
+		}
+	}
+	seqID, err := p.send(ctx, method, args, call)
+	if err != nil || call == nil {
+		return ResponseMeta{}, err
+	}
+
+	select {
+	case err := <-call.done:
+		recordIncomingHeaders(ctx, call.meta.Headers)
+		return call.meta, err
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	_, waiting := p.pending[seqID]
+	delete(p.pending, seqID)
+	p.mu.Unlock()
+	if !waiting {
+		err := <-call.done
+		recordIncomingHeaders(ctx, call.meta.Headers)
+		return call.meta, err
+	}
+	return ResponseMeta{}, ctx.Err()
+}
+
+// send writes the message of a call, registering call to receive its reply.
+func (p *TPipelinedClient) send(ctx context.Context, method string, args TStruct, call *tPipelinedCall) (int32, error) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	p.mu.Lock()
+	if p.err != nil {
+		err := p.err
+		p.mu.Unlock()
+		return 0, err
+	}
+	p.seqID++
+	seqID := p.seqID
+	// The reply can be read before writeCall returns.
+	if call != nil {
+		p.pending[seqID] = call
+		p.outstanding = append(p.outstanding, seqID)
+	}
+	p.mu.Unlock()
+
+	if err := writeCall(ctx, p.oprot, seqID, method, args); err != nil {
+		// The connection can't be used anymore after a partial write.
+		p.shutdown(err)
+		return 0, err
+	}
+	return seqID, nil
+}
+
+// readLoop reads the replies until the connection fails.
+func (p *TPipelinedClient) readLoop() {
+	defer close(p.done)
+	for {
+		if err := p.readReply(context.Background()); err != nil {
+			p.shutdown(err)
+			return
+		}
+	}
+}
+
+// readReply reads a reply and completes its call. The returned error is only
+// non-nil if the connection can't be read anymore.
+func (p *TPipelinedClient) readReply(ctx context.Context) error {
+	var (
+		name   string
+		typeID TMessageType
+		seqID  int32
+		err    error
+	)
+	for {
+		name, typeID, seqID, err = p.iprot.ReadMessageBegin(ctx)
+		// Timeouts without any pending call only mean the connection
+		// is idle.
+		if err != nil && isTimeoutError(err) && p.idle() {
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return err
+	}
+
+	call, err := p.takeCall(name, seqID)
+	if err != nil {
+		return err
+	}
+	if call == nil {
+		// The call was canceled, or its reply is discarded.
+		if err := p.iprot.Skip(ctx, STRUCT); err != nil {
+			return err
+		}
+		return p.iprot.ReadMessageEnd(ctx)
+	}
+
+	var callErr error
+	switch {
+	case name != call.method:
+		callErr = NewTApplicationException(WRONG_METHOD_NAME, fmt.Sprintf("%s: wrong method name", call.method))
+		err = p.iprot.Skip(ctx, STRUCT)
+	case typeID == EXCEPTION:
+		var exception tApplicationException
+		err = exception.Read(ctx, p.iprot)
+		exception.readExceptionReason(readResponseMeta(p.iprot).Headers)
+		callErr = &exception
+	case typeID != REPLY:
+		callErr = NewTApplicationException(INVALID_MESSAGE_TYPE_EXCEPTION, fmt.Sprintf("%s: invalid message type", call.method))
+		err = p.iprot.Skip(ctx, STRUCT)
+	default:
+		err = call.result.Read(ctx, p.iprot)
+	}
+	if err == nil {
+		err = p.iprot.ReadMessageEnd(ctx)
+	}
+	if err != nil {
+		call.done <- err
+		return err
+	}
+	call.meta = readResponseMeta(p.iprot)
+	call.done <- callErr
+	return nil
+}
+
+// takeCall removes the call the reply with seqID is for from the pending
+// calls and returns it, nil if it was canceled or if its reply is discarded.
+// It returns a *TSeqIDMismatchError if the reply isn't the one expected next
+// and the client must be closed.
+func (p *TPipelinedClient) takeCall(name string, seqID int32) (*tPipelinedCall, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	inOrder := len(p.outstanding) > 0 && p.outstanding[0] == seqID
+	policy := p.seqIDPolicy.orDefault(SeqIDMismatchDeliver)
+	if !inOrder && (policy == SeqIDMismatchClose || policy == SeqIDMismatchFail) {
+		return nil, p.seqIDMismatch(name, seqID)
+	}
+
+	for i, id := range p.outstanding {
+		if id == seqID {
+			p.outstanding = append(p.outstanding[:i], p.outstanding[i+1:]...)
+			break
+		}
+	}
+	call := p.pending[seqID]
+	delete(p.pending, seqID)
+	if !inOrder && policy == SeqIDMismatchSkip && call != nil {
+		call.done <- p.seqIDMismatch(name, seqID)
+		return nil, nil
+	}
+	return call, nil
+}
+
+// seqIDMismatch returns the error of the reply with seqID received out of
+// order.
+func (p *TPipelinedClient) seqIDMismatch(name string, seqID int32) *TSeqIDMismatchError {
+	err := &TSeqIDMismatchError{
+		Method:   name,
+		Received: seqID,
+	}
+	if len(p.outstanding) > 0 {
+		err.Expected = p.outstanding[0]
+		if call := p.pending[err.Expected]; call != nil {
+			err.Method = call.method
+		}
+	}
+	return err
+}
+
+// idle reports whether no call is waiting for its reply.
+func (p *TPipelinedClient) idle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending) == 0 && p.err == nil
+}
+
+// shutdown closes the client with err, failing the pending calls, and
+// interrupts the reads and the writes of its connection.
+func (p *TPipelinedClient) shutdown(err error) {
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return
+	}
+	p.err = err
+	pending := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+
+	for _, call := range pending {
+		call.done <- err
+	}
+	// TSocket.Close is not safe to call concurrently with Read and
+	// Write, the connection is only closed by Close once they return.
+	if i, ok := p.conn.(interface{ Interrupt() error }); ok {
+		i.Interrupt()
+	} else {
+		p.conn.Close()
+	}
+}
+
+// Close closes the connection of the client. The pending calls fail, and so
+// do the later ones.
+func (p *TPipelinedClient) Close() error {
+	p.shutdown(errPipelinedClientClosed)
+	<-p.done
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.conn.Close()
+}
+
+var _ TClient = (*TPipelinedClient)(nil)
@@ -0,0 +1,243 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipelinedString is the args and result struct of the pipelined calls.
+type pipelinedString struct {
+	Value string
+}
+
+func (s *pipelinedString) Read(ctx context.Context, in TProtocol) error {
+	if _, err := in.ReadStructBegin(ctx); err != nil {
+		return err
+	}
+	for {
+		_, typeID, _, err := in.ReadFieldBegin(ctx)
+		if err != nil {
+			return err
+		}
+		if typeID == STOP {
+			break
+		}
+		if s.Value, err = in.ReadString(ctx); err != nil {
+			return err
+		}
+		in.ReadFieldEnd(ctx)
+	}
+	return in.ReadStructEnd(ctx)
+}
+
+func (s *pipelinedString) Write(ctx context.Context, out TProtocol) error {
+	out.WriteStructBegin(ctx, "args")
+	out.WriteFieldBegin(ctx, "value", STRING, 1)
+	out.WriteString(ctx, s.Value)
+	out.WriteFieldEnd(ctx)
+	out.WriteFieldStop(ctx)
+	return out.WriteStructEnd(ctx)
+}
+
+// pipelinedRequest is a request read by the server of the pipelined calls.
+type pipelinedRequest struct {
+	method string
+	seqID  int32
+	value  string
+}
+
+// startPipelinedServer returns a TPipelinedClient, and the protocol of its
+// server side reading the requests and writing the replies.
+func startPipelinedServer(t *testing.T) (*TPipelinedClient, TProtocol) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	client := NewTPipelinedClient(NewTSocketFromConnConf(clientConn, nil), NewTBinaryProtocolFactoryConf(nil), nil)
+	t.Cleanup(func() {
+		client.Close()
+		serverConn.Close()
+	})
+	return client, NewTBinaryProtocolConf(NewTFramedTransportConf(NewTSocketFromConnConf(serverConn, nil), nil), nil)
+}
+
+func readPipelinedRequest(t *testing.T, proto TProtocol) pipelinedRequest {
+	t.Helper()
+	ctx := context.Background()
+	method, _, seqID, err := proto.ReadMessageBegin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := readStringArgs(ctx, proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pipelinedRequest{method, seqID, value}
+}
+
+func writePipelinedReply(proto TProtocol, req pipelinedRequest) error {
+	ctx := context.Background()
+	proto.WriteMessageBegin(ctx, req.method, REPLY, req.seqID)
+	writeStringArgs(ctx, proto, req.value)
+	return proto.Flush(ctx)
+}
+
+func TestPipelinedClientOutOfOrder(t *testing.T) {
+	client, server := startPipelinedServer(t)
+
+	const calls = 3
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := fmt.Sprintf("value-%d", i)
+			var result pipelinedString
+			if _, err := client.Call(context.Background(), "echo", &pipelinedString{value}, &result); err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Value != value {
+				t.Errorf("expected %q, got %q", value, result.Value)
+			}
+		}(i)
+	}
+
+	// All the requests are received before any reply is sent, which are
+	// then sent in the reverse order.
+	requests := make([]pipelinedRequest, calls)
+	for i := range requests {
+		requests[i] = readPipelinedRequest(t, server)
+	}
+	for i := calls - 1; i >= 0; i-- {
+		if err := writePipelinedReply(server, requests[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestPipelinedClientCanceledCall(t *testing.T) {
+	client, server := startPipelinedServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := client.Call(ctx, "echo", &pipelinedString{"canceled"}, &pipelinedString{})
+		canceled <- err
+	}()
+	req := readPipelinedRequest(t, server)
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// The late reply of the canceled call is skipped.
+	if err := writePipelinedReply(server, req); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		req := readPipelinedRequest(t, server)
+		writePipelinedReply(server, req)
+	}()
+	var result pipelinedString
+	if _, err := client.Call(context.Background(), "echo", &pipelinedString{"next"}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != "next" {
+		t.Errorf("expected %q, got %q", "next", result.Value)
+	}
+}
+
+func TestPipelinedClientClose(t *testing.T) {
+	client, server := startPipelinedServer(t)
+
+	pending := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), "echo", &pipelinedString{"pending"}, &pipelinedString{})
+		pending <- err
+	}()
+	readPipelinedRequest(t, server)
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-pending:
+		if !errors.Is(err, errPipelinedClientClosed) {
+			t.Errorf("expected the pending call to fail with %v, got %v", errPipelinedClientClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending call not failed")
+	}
+	if _, err := client.Call(context.Background(), "echo", &pipelinedString{"closed"}, &pipelinedString{}); !errors.Is(err, errPipelinedClientClosed) {
+		t.Errorf("expected %v, got %v", errPipelinedClientClosed, err)
+	}
+}
+
+func TestPipelinedClientTHeader(t *testing.T) {
+	processor := &mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			ctx := context.Background()
+			name, _, seqID, err := in.ReadMessageBegin(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			value, err := readStringArgs(ctx, in)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			out.WriteMessageBegin(ctx, name, REPLY, seqID)
+			writeStringArgs(ctx, out, value)
+			return true, WrapTException(out.Flush(ctx))
+		},
+	}
+	serv, addr := startTestSocketServer(t, processor, func(s *TSimpleServer) {
+		s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+		s.outputProtocolFactory = s.inputProtocolFactory
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	client := NewTPipelinedClient(dialTestSocketServer(t, addr), NewTHeaderProtocolFactoryConf(nil), nil)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := fmt.Sprintf("value-%d", i)
+			var result pipelinedString
+			if _, err := client.Call(context.Background(), "echo", &pipelinedString{value}, &result); err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Value != value {
+				t.Errorf("expected %q, got %q", value, result.Value)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
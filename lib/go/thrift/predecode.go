@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+)
+
+// TMessageInfo is the information about a request available before its
+// arguments are decoded.
+type TMessageInfo struct {
+	Method string
+	TypeID TMessageType
+	SeqID  int32
+
+	// Headers are the THeader headers of the request, nil if the server
+	// doesn't use THeaderProtocol.
+	Headers THeaderMap
+}
+
+// PreDecodeHook is called for every request after its message begin is read,
+// but before its arguments are decoded, so that calls can be rejected cheaply
+// (authentication failure, unknown method, overload, etc.).
+//
+// Returning a non-nil error rejects the call: its arguments are skipped
+// without being decoded, and it's replied with the error if it's a
+// TApplicationException, or with an INTERNAL_ERROR TApplicationException
+// otherwise. The connection is kept open. Returning ErrAbandonRequest closes
+// the connection instead.
+type PreDecodeHook func(ctx context.Context, info TMessageInfo) error
+
+// tPreDecodeProcessor calls the PreDecodeHook of a TSimpleServer.
+type tPreDecodeProcessor struct {
+	TProcessor
+
+	hook PreDecodeHook
+}
+
+func (p *tPreDecodeProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	info := TMessageInfo{
+		Method: name,
+		TypeID: typeID,
+		SeqID:  seqID,
+	}
+	if keys := GetReadHeaderList(ctx); len(keys) > 0 {
+		info.Headers = make(THeaderMap, len(keys))
+		for _, key := range keys {
+			info.Headers[key], _ = GetHeader(ctx, key)
+		}
+	}
+	if err := p.hook(ctx, info); err != nil {
+		if errors.Is(err, ErrAbandonRequest) {
+			return false, WrapTException(err)
+		}
+		var exc TApplicationException
+		if !errors.As(err, &exc) {
+			exc = NewTApplicationException(INTERNAL_ERROR, err.Error())
+		}
+		if err := skipRequestWithException(ctx, in, out, name, typeID, seqID, exc); err != nil {
+			return false, WrapTException(err)
+		}
+		return true, nil
+	}
+	return p.TProcessor.Process(ctx, NewStoredMessageProtocol(in, name, typeID, seqID), out)
+}
+
+var _ TProcessor = (*tPreDecodeProcessor)(nil)
@@ -0,0 +1,88 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPreDecodeHook(t *testing.T) {
+	infos := make(chan TMessageInfo, 10)
+	serv, addr := startTestSocketServer(t, echoProcessor(), func(s *TSimpleServer) {
+		s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+		s.outputProtocolFactory = s.inputProtocolFactory
+		s.SetPreDecodeHook(func(ctx context.Context, info TMessageInfo) error {
+			infos <- info
+			switch info.Headers["token"] {
+			case "secret":
+				return nil
+			case "":
+				return NewTApplicationException(PROTOCOL_ERROR, "missing token")
+			case "abandon":
+				return ErrAbandonRequest
+			default:
+				return errors.New("bad token")
+			}
+		})
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	proto := NewTHeaderProtocolConf(dialTestSocketServer(t, addr), nil)
+	proto.SetWriteHeader("token", "secret")
+	if err := echoCall(t, proto, 1, "allowed"); err != nil {
+		t.Fatal(err)
+	}
+	info := <-infos
+	if info.Method != "echo" || info.TypeID != CALL || info.SeqID != 1 || info.Headers["token"] != "secret" {
+		t.Errorf("unexpected message info %+v", info)
+	}
+
+	// The rejected calls are replied without decoding their arguments,
+	// which the echo processor would fail to read.
+	for _, c := range []struct {
+		token  string
+		typeID int32
+	}{
+		{"", PROTOCOL_ERROR},
+		{"wrong", INTERNAL_ERROR},
+	} {
+		proto.ClearWriteHeaders()
+		if c.token != "" {
+			proto.SetWriteHeader("token", c.token)
+		}
+		exc, err := callExpectingException(proto, 2)
+		if err != nil {
+			t.Fatalf("token %q: %v", c.token, err)
+		}
+		if exc.TypeId() != c.typeID {
+			t.Errorf("token %q: expected exception type %d, got %v", c.token, c.typeID, exc)
+		}
+		<-infos
+	}
+
+	proto.SetWriteHeader("token", "abandon")
+	if err := echoCall(t, proto, 3, "abandoned"); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"sync"
+)
+
+// PriorityClassifier returns the name of the priority class of a request.
+type PriorityClassifier func(ctx context.Context, method string) string
+
+// PriorityByMethod returns a PriorityClassifier classifying the requests by
+// method name, the methods not in classes get the default class.
+func PriorityByMethod(classes map[string]string) PriorityClassifier {
+	return func(ctx context.Context, method string) string {
+		return classes[method]
+	}
+}
+
+// PriorityByHeader returns a PriorityClassifier classifying the requests by
+// the value of a THeader header set by the client, the requests without it get
+// the default class.
+func PriorityByHeader(key string) PriorityClassifier {
+	return func(ctx context.Context, method string) string {
+		value, _ := GetHeader(ctx, key)
+		return value
+	}
+}
+
+// PriorityClass is a class of requests of a PriorityScheduler.
+type PriorityClass struct {
+	// Name is the name returned by the PriorityClassifier for the requests
+	// of this class.
+	Name string
+
+	// Weight is the share of the execution slots this class gets when all
+	// the classes have requests waiting, relative to the other classes.
+	// 1 if less than 1.
+	Weight int
+
+	// MaxQueued is the maximum number of requests of this class waiting for
+	// an execution slot, the requests over it are rejected right away.
+	// No limit if less than 1.
+	MaxQueued int
+}
+
+// PrioritySchedulerOptions configures a PriorityScheduler.
+type PrioritySchedulerOptions struct {
+	// MaxConcurrent is the maximum number of requests processed at once,
+	// across all the classes. It must be at least 1.
+	MaxConcurrent int
+
+	// Classes are the priority classes.
+	Classes []PriorityClass
+
+	// Default is the name of the class of the requests classified in none
+	// of Classes. If it's not in Classes either, it's a class of weight 1.
+	Default string
+
+	// Classify returns the class of a request.
+	//
+	// If nil, all the requests get the default class.
+	Classify PriorityClassifier
+
+	// OnReject is called for every rejected request, for example to record
+	// metrics by class.
+	OnReject func(ctx context.Context, method, class string)
+
+	// Oneway reports the oneway methods, see OnewayFunc.
+	Oneway OnewayFunc
+}
+
+// PriorityScheduler is a weighted scheduler for servers, so that low priority
+// traffic (e.g. batch jobs) can't starve high priority traffic (e.g.
+// interactive requests) of a shared server.
+//
+// The requests are classified into priority classes, and wait in a queue per
+// class for one of the execution slots. When a slot is available, the classes
+// with requests waiting get it in proportion to their weights, a class without
+// waiting requests doesn't get any credit for later.
+//
+// The requests rejected, because the queue of their class is full or their
+// context is done while they wait, are replied with a TApplicationException of
+// type INTERNAL_ERROR, and the connection is kept open.
+type PriorityScheduler struct {
+	opts PrioritySchedulerOptions
+
+	mu      sync.Mutex
+	running int
+	classes map[string]*tPriorityQueue
+	// vtime is the pass of the last class that got a slot.
+	vtime uint64
+}
+
+// priorityStride1 is the stride of a class of weight 1, the higher the weight
+// the shorter the stride.
+const priorityStride1 = 1 << 20
+
+// tPriorityQueue is the queue of a priority class.
+type tPriorityQueue struct {
+	class   PriorityClass
+	stride  uint64
+	pass    uint64
+	waiters []chan struct{}
+}
+
+// NewPriorityScheduler creates a PriorityScheduler.
+func NewPriorityScheduler(opts PrioritySchedulerOptions) *PriorityScheduler {
+	if opts.MaxConcurrent < 1 {
+		opts.MaxConcurrent = 1
+	}
+	s := &PriorityScheduler{
+		opts:    opts,
+		classes: make(map[string]*tPriorityQueue, len(opts.Classes)+1),
+	}
+	for _, class := range opts.Classes {
+		s.addClass(class)
+	}
+	if _, ok := s.classes[opts.Default]; !ok {
+		s.addClass(PriorityClass{Name: opts.Default})
+	}
+	return s
+}
+
+func (s *PriorityScheduler) addClass(class PriorityClass) {
+	if class.Weight < 1 {
+		class.Weight = 1
+	}
+	s.classes[class.Name] = &tPriorityQueue{
+		class:  class,
+		stride: priorityStride1 / uint64(class.Weight),
+	}
+}
+
+// Queued returns the number of requests of class waiting for an execution
+// slot.
+func (s *PriorityScheduler) Queued(class string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.classes[class]; ok {
+		return len(q.waiters)
+	}
+	return 0
+}
+
+// Middleware returns the ProcessorMiddleware scheduling the requests.
+func (s *PriorityScheduler) Middleware() ProcessorMiddleware {
+	return func(name string, next TProcessorFunction) TProcessorFunction {
+		return WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+				q := s.classify(ctx, name)
+				if s.acquire(ctx, q) {
+					defer s.release()
+					return next.Process(ctx, seqID, in, out)
+				}
+				if s.opts.OnReject != nil {
+					s.opts.OnReject(ctx, name, q.class.Name)
+				}
+				exc := NewTApplicationException(INTERNAL_ERROR, "too many queued requests of priority class "+q.class.Name)
+				if err := skipRequestWithException(ctx, in, out, name, s.opts.Oneway.messageType(ctx, name), seqID, exc); err != nil {
+					return false, WrapTException(err)
+				}
+				return true, exc
+			},
+		}
+	}
+}
+
+func (s *PriorityScheduler) classify(ctx context.Context, method string) *tPriorityQueue {
+	if s.opts.Classify != nil {
+		if q, ok := s.classes[s.opts.Classify(ctx, method)]; ok {
+			return q
+		}
+	}
+	return s.classes[s.opts.Default]
+}
+
+func (s *PriorityScheduler) acquire(ctx context.Context, q *tPriorityQueue) bool {
+	s.mu.Lock()
+	if s.running < s.opts.MaxConcurrent && !s.waitingLocked() {
+		s.running++
+		s.chargeLocked(q)
+		s.mu.Unlock()
+		return true
+	}
+	if q.class.MaxQueued > 0 && len(q.waiters) >= q.class.MaxQueued {
+		s.mu.Unlock()
+		return false
+	}
+	if len(q.waiters) == 0 && q.pass < s.vtime {
+		// No credit for the time the class had nothing waiting.
+		q.pass = s.vtime
+	}
+	ready := make(chan struct{})
+	q.waiters = append(q.waiters, ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return true
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range q.waiters {
+		if w == ready {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return false
+		}
+	}
+	// The slot was given to the request in the meantime.
+	return true
+}
+
+func (s *PriorityScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	var next *tPriorityQueue
+	for _, q := range s.classes {
+		if len(q.waiters) > 0 && (next == nil || q.pass < next.pass) {
+			next = q
+		}
+	}
+	if next == nil {
+		return
+	}
+	ready := next.waiters[0]
+	next.waiters = next.waiters[1:]
+	s.running++
+	s.chargeLocked(next)
+	close(ready)
+}
+
+// waitingLocked reports whether any class has requests waiting.
+func (s *PriorityScheduler) waitingLocked() bool {
+	for _, q := range s.classes {
+		if len(q.waiters) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// chargeLocked advances the pass of q, which just got a slot.
+func (s *PriorityScheduler) chargeLocked(q *tPriorityQueue) {
+	if q.pass < s.vtime {
+		q.pass = s.vtime
+	}
+	s.vtime = q.pass
+	q.pass += q.stride
+}
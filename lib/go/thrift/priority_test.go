@@ -0,0 +1,166 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrioritySchedulerWeights(t *testing.T) {
+	s := NewPriorityScheduler(PrioritySchedulerOptions{
+		MaxConcurrent: 1,
+		Classes: []PriorityClass{
+			{Name: "interactive", Weight: 3},
+			{Name: "batch", Weight: 1},
+		},
+		Default: "batch",
+	})
+	ctx := context.Background()
+	if !s.acquire(ctx, s.classes["batch"]) {
+		t.Fatal("expected a free slot")
+	}
+
+	const perClass = 8
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for _, class := range []string{"batch", "interactive"} {
+		for i := 0; i < perClass; i++ {
+			wg.Add(1)
+			go func(class string) {
+				defer wg.Done()
+				if !s.acquire(ctx, s.classes[class]) {
+					t.Error("unexpected rejection")
+					return
+				}
+				mu.Lock()
+				order = append(order, class)
+				mu.Unlock()
+				s.release()
+			}(class)
+		}
+	}
+	for s.Queued("batch")+s.Queued("interactive") < 2*perClass {
+		time.Sleep(time.Millisecond)
+	}
+	s.release()
+	wg.Wait()
+
+	var interactive int
+	for _, class := range order[:perClass] {
+		if class == "interactive" {
+			interactive++
+		}
+	}
+	// With weights 3:1, interactive requests get about 6 of the first 8 slots.
+	if interactive < 5 || interactive > 7 {
+		t.Errorf("expected interactive requests to get 3/4 of the slots, got %d of %d: %v", interactive, perClass, order)
+	}
+}
+
+func TestPrioritySchedulerMiddleware(t *testing.T) {
+	var rejected []string
+	s := NewPriorityScheduler(PrioritySchedulerOptions{
+		MaxConcurrent: 1,
+		Classes: []PriorityClass{
+			{Name: "batch", MaxQueued: 1},
+		},
+		Classify: PriorityByHeader("priority"),
+		OnReject: func(ctx context.Context, method, class string) {
+			rejected = append(rejected, class)
+		},
+	})
+	var processed int
+	processor := WrapProcessor(&mockWrappableProcessor{
+		ProcessorFuncs: map[string]TProcessorFunction{
+			"m": WrappedTProcessorFunction{
+				Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+					processed++
+					return true, nil
+				},
+			},
+		},
+	}, s.Middleware())
+	f := processor.ProcessorMap()["m"]
+
+	if _, err := callWithEmptyArgs(f); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the slot and the batch queue, the next batch request is rejected.
+	if !s.acquire(context.Background(), s.classes[""]) {
+		t.Fatal("expected a free slot")
+	}
+	queued := make(chan bool, 1)
+	go func() {
+		queued <- s.acquire(context.Background(), s.classes["batch"])
+	}()
+	for s.Queued("batch") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx := AddReadTHeaderToContext(context.Background(), THeaderMap{"priority": "batch"})
+	proto := NewTBinaryProtocolConf(NewTMemoryBuffer(), nil)
+	proto.WriteStructBegin(ctx, "args")
+	proto.WriteFieldStop(ctx)
+	proto.WriteStructEnd(ctx)
+	ok, err := f.Process(ctx, 1, proto, proto)
+	if !ok {
+		t.Error("expected the connection to be kept open")
+	}
+	var tae TApplicationException
+	if !errors.As(err, &tae) || tae.TypeId() != INTERNAL_ERROR {
+		t.Errorf("expected INTERNAL_ERROR, got %v", err)
+	}
+	if len(rejected) != 1 || rejected[0] != "batch" {
+		t.Errorf("unexpected rejected classes %v", rejected)
+	}
+
+	s.release()
+	if !<-queued {
+		t.Error("expected the queued request to get the slot")
+	}
+	s.release()
+	if processed != 1 {
+		t.Errorf("expected 1 processed request, got %d", processed)
+	}
+}
+
+func TestPrioritySchedulerContextDone(t *testing.T) {
+	s := NewPriorityScheduler(PrioritySchedulerOptions{MaxConcurrent: 1})
+	if !s.acquire(context.Background(), s.classes[""]) {
+		t.Fatal("expected a free slot")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if s.acquire(ctx, s.classes[""]) {
+		t.Error("expected the request to give up when its context is done")
+	}
+	if s.Queued("") != 0 {
+		t.Errorf("expected the request to leave the queue, got %d queued", s.Queued(""))
+	}
+	s.release()
+	if !s.acquire(context.Background(), s.classes[""]) {
+		t.Error("expected the slot to be free again")
+	}
+}
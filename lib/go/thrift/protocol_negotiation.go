@@ -0,0 +1,252 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// NegotiableProtocol is a protocol a client can negotiate with its server,
+// see NegotiateProtocol.
+type NegotiableProtocol int
+
+const (
+	// ProtocolTHeader is THeaderProtocol, wrapping the protocol of the
+	// THeaderProtocolID of the TConfiguration.
+	ProtocolTHeader NegotiableProtocol = iota
+	// ProtocolCompact is TCompactProtocol.
+	ProtocolCompact
+	// ProtocolBinary is TBinaryProtocol.
+	ProtocolBinary
+)
+
+func (p NegotiableProtocol) String() string {
+	switch p {
+	case ProtocolTHeader:
+		return "header"
+	case ProtocolCompact:
+		return "compact"
+	case ProtocolBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("NegotiableProtocol(%d)", int(p))
+	}
+}
+
+// DefaultNegotiableProtocols are the protocols negotiated by default, from
+// the richest to the most widely supported.
+var DefaultNegotiableProtocols = []NegotiableProtocol{ProtocolTHeader, ProtocolCompact, ProtocolBinary}
+
+// DefaultProbeTimeout is the default ProbeTimeout of NegotiationOptions.
+const DefaultProbeTimeout = time.Second
+
+// NegotiationOptions configures NegotiateProtocol and TNegotiatingClient.
+type NegotiationOptions struct {
+	// Protocols are the protocols tried, in order of preference.
+	//
+	// If empty, DefaultNegotiableProtocols are used.
+	Protocols []NegotiableProtocol
+
+	// Framed wraps the connections of ProtocolCompact and ProtocolBinary in
+	// a TFramedTransport, for the servers using TFramedTransport. The
+	// THeaderProtocol frames its messages itself, so the connections are
+	// always dialed without TFramedTransport.
+	Framed bool
+
+	// Configuration is the TConfiguration of the protocols.
+	Configuration *TConfiguration
+
+	// ProbeTimeout is the time a server has to reply to the probe of a
+	// protocol before the protocol is considered unsupported, as some
+	// servers wait for more data rather than failing on the messages they
+	// can't decode.
+	//
+	// If 0, DefaultProbeTimeout is used.
+	ProbeTimeout time.Duration
+
+	// Service, if set, is the service of the probes, for the servers with a
+	// TMultiplexedProcessor without a DefaultProcessor, which close the
+	// connections of the requests to no service.
+	Service string
+
+	// OnNegotiated is called with the protocol selected by every
+	// negotiation, for example to record the progress of a migration.
+	OnNegotiated func(p NegotiableProtocol)
+}
+
+// transport returns the transport of the protocol p over trans.
+func (p NegotiableProtocol) transport(trans TTransport, opts NegotiationOptions) TTransport {
+	if opts.Framed && p != ProtocolTHeader {
+		return NewTFramedTransportConf(trans, opts.Configuration)
+	}
+	return trans
+}
+
+// protocol returns the protocol p over trans, as returned by transport.
+func (p NegotiableProtocol) protocol(trans TTransport, conf *TConfiguration) TProtocol {
+	switch p {
+	case ProtocolTHeader:
+		return NewTHeaderProtocolConf(trans, conf)
+	case ProtocolCompact:
+		return NewTCompactProtocolConf(trans, conf)
+	default:
+		return NewTBinaryProtocolConf(trans, conf)
+	}
+}
+
+// NegotiateProtocol selects the first protocol of opts supported by the
+// server, with a Ping of each protocol in turn on a new connection opened by
+// dial, as with NewTLazyClient.
+//
+// The servers replying to the pings with either SetPingReplies or an
+// UNKNOWN_METHOD TApplicationException support the protocol, so do all the
+// servers using THeaderProtocol, which detects the protocol of the clients.
+// The other ones close the connection, or don't reply before ProbeTimeout.
+//
+// It returns the protocol over the connection of the successful probe, ready
+// to be used by a TStandardClient. A failed dial fails the negotiation, and
+// it fails with the error of the last probe if no protocol is supported.
+func NegotiateProtocol(ctx context.Context, dial func(ctx context.Context) (TTransport, error), opts NegotiationOptions) (TProtocol, NegotiableProtocol, error) {
+	protocols := opts.Protocols
+	if len(protocols) == 0 {
+		protocols = DefaultNegotiableProtocols
+	}
+	probeTimeout := opts.ProbeTimeout
+	if probeTimeout <= 0 {
+		probeTimeout = DefaultProbeTimeout
+	}
+
+	var err error
+	for _, p := range protocols {
+		var trans TTransport
+		if trans, err = dial(ctx); err != nil {
+			return nil, 0, err
+		}
+		proto := p.protocol(p.transport(trans, opts), opts.Configuration)
+		var probe TProtocol = proto
+		if opts.Service != "" {
+			probe = NewTMultiplexedProtocol(proto, opts.Service)
+		}
+		probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		// The transports returned by dial may be closed.
+		client := NewTStandardClient(probe, probe)
+		client.SetTimeouts(TClientTimeouts{OpenClosed: true})
+		err = Ping(probeCtx, client)
+		cancel()
+		if err == nil {
+			if opts.OnNegotiated != nil {
+				opts.OnNegotiated(p)
+			}
+			return proto, p, nil
+		}
+		trans.Close()
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+	}
+	return nil, 0, fmt.Errorf("no protocol supported by the server: %w", err)
+}
+
+// TNegotiatingClient is a TLazyClient negotiating its protocol with its
+// server when it connects, see NegotiateProtocol, to migrate the clients of a
+// fleet of servers from a protocol to another without coordinating them.
+//
+// The protocol is negotiated by the first call. The next connections try the
+// selected protocol first, so that the client finds a new protocol only once
+// the servers stop supporting the previous one.
+type TNegotiatingClient struct {
+	*TLazyClient
+
+	dial func(ctx context.Context) (TTransport, error)
+	opts NegotiationOptions
+
+	mu         sync.Mutex
+	selected   NegotiableProtocol
+	negotiated bool
+}
+
+// NewTNegotiatingClient returns a TNegotiatingClient connecting with dial,
+// which opens a new connection to the server, without the TFramedTransport
+// of the servers using it, see the Framed of opts.
+func NewTNegotiatingClient(dial func(ctx context.Context) (TTransport, error), opts NegotiationOptions) *TNegotiatingClient {
+	c := &TNegotiatingClient{
+		dial: dial,
+		opts: opts,
+	}
+	c.TLazyClient = NewTLazyClient(c.connect, tNegotiatedProtocolFactory{c})
+	return c
+}
+
+// Protocol returns the protocol selected by the last negotiation, false if
+// the client never connected.
+func (c *TNegotiatingClient) Protocol() (NegotiableProtocol, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.selected, c.negotiated
+}
+
+// connect negotiates the protocol of a new connection, and returns its
+// transport.
+func (c *TNegotiatingClient) connect(ctx context.Context) (TTransport, error) {
+	opts := c.opts
+	if selected, ok := c.Protocol(); ok {
+		protocols := []NegotiableProtocol{selected}
+		for _, p := range c.protocols() {
+			if p != selected {
+				protocols = append(protocols, p)
+			}
+		}
+		opts.Protocols = protocols
+	}
+	proto, selected, err := NegotiateProtocol(ctx, c.dial, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.selected, c.negotiated = selected, true
+	c.mu.Unlock()
+	return proto.Transport(), nil
+}
+
+func (c *TNegotiatingClient) protocols() []NegotiableProtocol {
+	if len(c.opts.Protocols) == 0 {
+		return DefaultNegotiableProtocols
+	}
+	return c.opts.Protocols
+}
+
+// tNegotiatedProtocolFactory returns the protocol selected by a
+// TNegotiatingClient for the transports it connected.
+type tNegotiatedProtocolFactory struct {
+	c *TNegotiatingClient
+}
+
+func (f tNegotiatedProtocolFactory) GetProtocol(trans TTransport) TProtocol {
+	selected, _ := f.c.Protocol()
+	return selected.protocol(trans, f.c.opts.Configuration)
+}
+
+var (
+	_ TClient          = (*TNegotiatingClient)(nil)
+	_ TProtocolFactory = tNegotiatedProtocolFactory{}
+)
@@ -0,0 +1,103 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTNegotiatingClient(t *testing.T) {
+	for _, c := range []struct {
+		label    string
+		factory  TProtocolFactory
+		expected NegotiableProtocol
+	}{
+		{"header", NewTHeaderProtocolFactoryConf(nil), ProtocolTHeader},
+		{"compact", NewTCompactProtocolFactoryConf(nil), ProtocolCompact},
+		// The non-strict TBinaryProtocol waits for the rest of the THeader
+		// probe, which times out.
+		{"binary", NewTBinaryProtocolFactoryConf(nil), ProtocolBinary},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			serv, addr := startTestSocketServer(t, headerEchoProcessor{}, func(s *TSimpleServer) {
+				s.inputProtocolFactory = c.factory
+				s.outputProtocolFactory = c.factory
+				s.SetPingReplies(true)
+			})
+			t.Cleanup(func() {
+				serv.Stop()
+			})
+
+			var negotiated []NegotiableProtocol
+			client := NewTNegotiatingClient(func(ctx context.Context) (TTransport, error) {
+				return NewTSocketConf(addr, &TConfiguration{SocketTimeout: 5 * time.Second})
+			}, NegotiationOptions{
+				ProbeTimeout: 100 * time.Millisecond,
+				OnNegotiated: func(p NegotiableProtocol) {
+					negotiated = append(negotiated, p)
+				},
+			})
+			t.Cleanup(func() {
+				client.Close()
+			})
+			if _, ok := client.Protocol(); ok {
+				t.Error("expected no protocol before the first call")
+			}
+
+			for _, value := range []string{"hello", "world"} {
+				var result pipelinedString
+				if _, err := client.Call(context.Background(), "echo", &pipelinedString{value}, &result); err != nil {
+					t.Fatal(err)
+				}
+				if result.Value != value {
+					t.Errorf("expected %q, got %q", value, result.Value)
+				}
+			}
+			if p, ok := client.Protocol(); !ok || p != c.expected {
+				t.Errorf("expected %v, got %v", c.expected, p)
+			}
+			if len(negotiated) != 1 {
+				t.Errorf("expected a single negotiation, got %v", negotiated)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		serv, addr := startTestSocketServer(t, headerEchoProcessor{}, func(s *TSimpleServer) {
+			s.inputProtocolFactory = NewTCompactProtocolFactoryConf(nil)
+			s.outputProtocolFactory = s.inputProtocolFactory
+		})
+		t.Cleanup(func() {
+			serv.Stop()
+		})
+		dial := func(ctx context.Context) (TTransport, error) {
+			return NewTSocketConf(addr, &TConfiguration{SocketTimeout: 5 * time.Second})
+		}
+		_, _, err := NegotiateProtocol(context.Background(), dial, NegotiationOptions{
+			Protocols:    []NegotiableProtocol{ProtocolTHeader, ProtocolBinary},
+			ProbeTimeout: 100 * time.Millisecond,
+		})
+		if err == nil {
+			t.Error("expected the negotiation to fail")
+		}
+	})
+}
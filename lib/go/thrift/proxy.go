@@ -0,0 +1,211 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ProxyDestinationHeader is the THeader carrying the address of the server a
+// call is sent to through a proxy, see ProxyDestinationMiddleware and
+// NewTProxyProcessor.
+const ProxyDestinationHeader = "thrift-destination"
+
+// ProxyDestinationMiddleware returns a ClientMiddleware sending the calls to
+// destination through a proxy built with NewTProxyProcessor: the client is
+// connected to the proxy, and destination is sent in the
+// ProxyDestinationHeader. It requires THeaderProtocol.
+//
+// The destination of a single call can also be set with AppendOutgoingHeader.
+func ProxyDestinationMiddleware(destination string) ClientMiddleware {
+	return func(next TClient) TClient {
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				ctx = addWriteHeader(ctx, ProxyDestinationHeader, destination)
+				return next.Call(ctx, method, args, result)
+			},
+		}
+	}
+}
+
+// ProxyOptions configures NewTProxyProcessor.
+type ProxyOptions struct {
+	// Client returns the TClient the requests to destination, the value of
+	// their ProxyDestinationHeader, are forwarded with, usually a cached
+	// TPipelinedClient or TSynchronizedClient connected to it. It must not
+	// be nil.
+	//
+	// It returns an error to reject the requests, for example for the
+	// destinations that are not allowed, in which case they're replied
+	// with the error if it's a TApplicationException, or with an
+	// INTERNAL_ERROR TApplicationException of reason
+	// ExceptionReasonPermissionDenied otherwise.
+	Client func(ctx context.Context, destination string) (TClient, error)
+
+	// Fallback processes the requests without a ProxyDestinationHeader. If
+	// nil, they're rejected with an INTERNAL_ERROR TApplicationException.
+	Fallback TProcessor
+}
+
+// tProxyProcessor is the TProcessor returned by NewTProxyProcessor.
+type tProxyProcessor struct {
+	opts ProxyOptions
+}
+
+// NewTProxyProcessor returns a TProcessor forwarding every request to the
+// server in its ProxyDestinationHeader and replying with its reply, without
+// decoding the arguments and the results, so that a TSimpleServer using
+// THeaderProtocol can be used as an L7 proxy for any service.
+//
+// The headers of the requests are forwarded, except ProxyDestinationHeader
+// and TimeoutHeader, as the deadline of the request is set in its context
+// instead (see DeadlinePropagationMiddleware), and the headers of the replies
+// are sent back. The errors of the forwarded calls are replied with an
+// INTERNAL_ERROR TApplicationException, unless they're
+// TApplicationExceptions, and the connection to the client is kept open.
+func NewTProxyProcessor(opts ProxyOptions) TProcessor {
+	return &tProxyProcessor{opts: opts}
+}
+
+func (p *tProxyProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	destination, _ := GetHeader(ctx, ProxyDestinationHeader)
+	if destination == "" {
+		if p.opts.Fallback != nil {
+			return p.opts.Fallback.Process(ctx, NewStoredMessageProtocol(in, name, typeID, seqID), out)
+		}
+		exc := NewTApplicationException(INTERNAL_ERROR, "missing proxy destination")
+		if err := skipRequestWithException(ctx, in, out, name, typeID, seqID, exc); err != nil {
+			return false, WrapTException(err)
+		}
+		return true, nil
+	}
+
+	client, err := p.opts.Client(ctx, destination)
+	if err != nil {
+		var exc TApplicationException
+		if !errors.As(err, &exc) {
+			exc = NewTApplicationExceptionWithReason(INTERNAL_ERROR, ExceptionReasonPermissionDenied, fmt.Sprintf("proxy destination %q: %v", destination, err))
+		}
+		if err := skipRequestWithException(ctx, in, out, name, typeID, seqID, exc); err != nil {
+			return false, WrapTException(err)
+		}
+		return true, nil
+	}
+
+	var args tRawStruct
+	if err := args.Read(ctx, in); err != nil {
+		return false, WrapTException(err)
+	}
+	if err := in.ReadMessageEnd(ctx); err != nil {
+		return false, WrapTException(err)
+	}
+	ctx = SetWriteHeaderList(ctx, forwardedProxyHeaders(ctx))
+	if typeID == ONEWAY {
+		// There is no reply to report the errors in.
+		client.Call(ctx, name, &args, nil)
+		return true, nil
+	}
+
+	var result tRawStruct
+	meta, err := client.Call(ctx, name, &args, &result)
+	if err != nil {
+		var exc TApplicationException
+		if !errors.As(err, &exc) {
+			exc = NewTApplicationException(INTERNAL_ERROR, fmt.Sprintf("proxy destination %q: %v", destination, err))
+		}
+		if err := writeApplicationException(ctx, out, name, seqID, exc); err != nil {
+			return false, WrapTException(err)
+		}
+		return true, nil
+	}
+	for key, value := range meta.Headers {
+		SetResponseHeader(ctx, key, value)
+	}
+	if err := out.WriteMessageBegin(ctx, name, REPLY, seqID); err != nil {
+		return false, WrapTException(err)
+	}
+	if err := result.Write(ctx, out); err != nil {
+		return false, WrapTException(err)
+	}
+	if err := out.WriteMessageEnd(ctx); err != nil {
+		return false, WrapTException(err)
+	}
+	return true, WrapTException(out.Flush(ctx))
+}
+
+// forwardedProxyHeaders returns the keys of the headers of the request of ctx
+// forwarded by a tProxyProcessor.
+func forwardedProxyHeaders(ctx context.Context) []string {
+	read := GetReadHeaderList(ctx)
+	keys := make([]string, 0, len(read))
+	for _, key := range read {
+		if key != ProxyDestinationHeader && key != TimeoutHeader {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (p *tProxyProcessor) ProcessorMap() map[string]TProcessorFunction {
+	if p.opts.Fallback == nil {
+		return nil
+	}
+	return p.opts.Fallback.ProcessorMap()
+}
+
+func (p *tProxyProcessor) AddToProcessorMap(name string, f TProcessorFunction) {
+	if p.opts.Fallback != nil {
+		p.opts.Fallback.AddToProcessorMap(name, f)
+	}
+}
+
+// tRawStruct is a TStruct kept encoded, so that it's forwarded without
+// being decoded.
+type tRawStruct struct {
+	// data is the struct encoded with TBinaryProtocol.
+	data []byte
+}
+
+func (s *tRawStruct) Read(ctx context.Context, in TProtocol) error {
+	buf := NewTMemoryBuffer()
+	if err := copyValue(ctx, in, NewTBinaryProtocolConf(buf, nil), STRUCT, false, DEFAULT_RECURSION_DEPTH); err != nil {
+		return err
+	}
+	s.data = buf.Bytes()
+	return nil
+}
+
+// Write can be called multiple times, e.g. for the retries of a call.
+func (s *tRawStruct) Write(ctx context.Context, out TProtocol) error {
+	in := NewTBinaryProtocolConf(&TMemoryBuffer{Buffer: bytes.NewBuffer(s.data)}, nil)
+	return copyValue(ctx, in, out, STRUCT, false, DEFAULT_RECURSION_DEPTH)
+}
+
+var (
+	_ TProcessor = (*tProxyProcessor)(nil)
+	_ TStruct    = (*tRawStruct)(nil)
+)
@@ -0,0 +1,152 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// headerEchoProcessor echoes the string arguments of the requests, and sends
+// their "trace" header back in the "served-trace" response header. The
+// requests with the value "fail" are replied with an INTERNAL_ERROR
+// TApplicationException.
+type headerEchoProcessor struct {
+	*mockProcessor
+}
+
+func (p headerEchoProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, _, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	value, err := readStringArgs(ctx, in)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	if _, ok := GetHeader(ctx, ProxyDestinationHeader); ok {
+		value = "destination header forwarded"
+	}
+	trace, _ := GetHeader(ctx, "trace")
+	SetResponseHeader(ctx, "served-trace", trace)
+	if value == "fail" {
+		exc := NewTApplicationException(INTERNAL_ERROR, "upstream failure")
+		return true, WrapTException(writeApplicationException(ctx, out, name, seqID, exc))
+	}
+	out.WriteMessageBegin(ctx, name, REPLY, seqID)
+	writeStringArgs(ctx, out, value)
+	return true, WrapTException(out.Flush(ctx))
+}
+
+func TestProxyProcessor(t *testing.T) {
+	withTHeader := func(s *TSimpleServer) {
+		s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+		s.outputProtocolFactory = s.inputProtocolFactory
+	}
+	upstream, upstreamAddr := startTestSocketServer(t, headerEchoProcessor{}, withTHeader)
+	t.Cleanup(func() {
+		upstream.Stop()
+	})
+	upstreamClient := NewTPipelinedClient(dialTestSocketServer(t, upstreamAddr), NewTHeaderProtocolFactoryConf(nil), nil)
+	t.Cleanup(func() {
+		upstreamClient.Close()
+	})
+
+	proxy, proxyAddr := startTestSocketServer(t, NewTProxyProcessor(ProxyOptions{
+		Client: func(ctx context.Context, destination string) (TClient, error) {
+			if destination != upstreamAddr {
+				return nil, errors.New("destination not allowed")
+			}
+			return upstreamClient, nil
+		},
+	}), withTHeader)
+	t.Cleanup(func() {
+		proxy.Stop()
+	})
+	proto := NewTHeaderProtocolConf(dialTestSocketServer(t, proxyAddr), nil)
+	client := NewTStandardClient(proto, proto)
+
+	call := func(client TClient, value string) (string, ResponseMeta, error) {
+		ctx := AppendOutgoingHeader(context.Background(), "trace", "trace-"+value)
+		var result pipelinedString
+		meta, err := client.Call(ctx, "echo", &pipelinedString{value}, &result)
+		return result.Value, meta, err
+	}
+
+	proxied := WrapClient(client, ProxyDestinationMiddleware(upstreamAddr))
+	for _, value := range []string{"hello", "world"} {
+		got, meta, err := call(proxied, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != value {
+			t.Errorf("expected %q, got %q", value, got)
+		}
+		if trace := meta.Headers["served-trace"]; trace != "trace-"+value {
+			t.Errorf("expected the trace header to be forwarded both ways, got %q", trace)
+		}
+	}
+
+	_, _, err := call(proxied, "fail")
+	var exc TApplicationException
+	if !errors.As(err, &exc) || exc.TypeId() != INTERNAL_ERROR || exc.Error() != "upstream failure" {
+		t.Errorf("expected the upstream exception, got %v", err)
+	}
+
+	_, _, err = call(WrapClient(client, ProxyDestinationMiddleware("127.0.0.1:1")), "denied")
+	if !errors.As(err, &exc) || ExceptionReason(err) != ExceptionReasonPermissionDenied || !strings.Contains(exc.Error(), "not allowed") {
+		t.Errorf("expected a permission denied TApplicationException, got %v", err)
+	}
+
+	_, _, err = call(client, "direct")
+	if !errors.As(err, &exc) || exc.TypeId() != INTERNAL_ERROR {
+		t.Errorf("expected INTERNAL_ERROR without destination, got %v", err)
+	}
+
+	// The connection is still usable after the rejected calls.
+	if got, _, err := call(proxied, "again"); err != nil || got != "again" {
+		t.Errorf("expected %q, got %q, %v", "again", got, err)
+	}
+}
+
+func TestProxyProcessorFallback(t *testing.T) {
+	serv, addr := startTestSocketServer(t, NewTProxyProcessor(ProxyOptions{
+		Client: func(ctx context.Context, destination string) (TClient, error) {
+			return nil, errors.New("unexpected proxied call")
+		},
+		Fallback: headerEchoProcessor{},
+	}), func(s *TSimpleServer) {
+		s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+		s.outputProtocolFactory = s.inputProtocolFactory
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	proto := NewTHeaderProtocolConf(dialTestSocketServer(t, addr), nil)
+	var result pipelinedString
+	if _, err := NewTStandardClient(proto, proto).Call(context.Background(), "echo", &pipelinedString{"local"}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != "local" {
+		t.Errorf("expected %q, got %q", "local", result.Value)
+	}
+}
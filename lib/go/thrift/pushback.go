@@ -0,0 +1,67 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// RetryAfterHeader is the THeader response header a server pushes back on
+// the retries of a rejected request with: its value is the number of
+// milliseconds the client should wait before retrying, or a negative number
+// if it should not retry. See SetRetryAfter.
+const RetryAfterHeader = "thrift-retry-after"
+
+// SetRetryAfter sets the RetryAfterHeader of the response of the request of
+// ctx, asking the client to retry it after d, or not to retry it if d is
+// negative, and reports whether it could be set (THeaderProtocol is
+// required).
+//
+// It must only be called for the requests rejected before being processed,
+// e.g. because the server is overloaded, as RetryMiddleware retries them
+// whether the error is retryable or not.
+func SetRetryAfter(ctx context.Context, d time.Duration) bool {
+	ms := d.Milliseconds()
+	if d < 0 {
+		ms = -1
+	} else if d%time.Millisecond != 0 {
+		ms++
+	}
+	return SetResponseHeader(ctx, RetryAfterHeader, strconv.FormatInt(ms, 10))
+}
+
+// RetryAfter returns the delay of the RetryAfterHeader of headers, negative
+// if the call should not be retried, and reports whether it's set.
+func RetryAfter(headers THeaderMap) (time.Duration, bool) {
+	value, ok := headers[RetryAfterHeader]
+	if !ok {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if ms < 0 {
+		return -1, true
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
@@ -0,0 +1,192 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pushbackProcessor echoes the string arguments of the requests, except for
+// the first rejections ones, which are replied with exc and the pushback
+// delay.
+type pushbackProcessor struct {
+	*mockProcessor
+
+	exc        TApplicationException
+	pushback   time.Duration
+	rejections int32
+	calls      int32
+}
+
+func (p *pushbackProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, _, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	value, err := readStringArgs(ctx, in)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	if atomic.AddInt32(&p.calls, 1) <= p.rejections {
+		SetRetryAfter(ctx, p.pushback)
+		return true, WrapTException(writeApplicationException(ctx, out, name, seqID, p.exc))
+	}
+	out.WriteMessageBegin(ctx, name, REPLY, seqID)
+	writeStringArgs(ctx, out, value)
+	return true, WrapTException(out.Flush(ctx))
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, c := range []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"250", 250 * time.Millisecond, true},
+		{"0", 0, true},
+		{"-1", -1, true},
+		{"soon", 0, false},
+		{"", 0, false},
+	} {
+		headers := THeaderMap{}
+		if c.value != "" {
+			headers[RetryAfterHeader] = c.value
+		}
+		d, ok := RetryAfter(headers)
+		if d != c.expected || ok != c.ok {
+			t.Errorf("%q: expected %v, %v, got %v, %v", c.value, c.expected, c.ok, d, ok)
+		}
+	}
+
+	trans := NewTHeaderTransport(NewTMemoryBuffer())
+	ctx := SetResponseHelper(context.Background(), TResponseHelper{
+		THeaderResponseHelper: NewTHeaderResponseHelper(NewTHeaderProtocol(trans)),
+	})
+	if !SetRetryAfter(ctx, 1500*time.Microsecond) {
+		t.Fatal("expected SetRetryAfter to succeed with THeaderProtocol")
+	}
+	if got := trans.writeHeaders[RetryAfterHeader]; got != "2" {
+		t.Errorf("expected the delay to be rounded up to 2ms, got %q", got)
+	}
+}
+
+func TestRetryMiddlewarePushback(t *testing.T) {
+	call := func(t *testing.T, processor *pushbackProcessor, ctx context.Context) (int32, time.Duration, error) {
+		t.Helper()
+		serv, addr := startTestSocketServer(t, processor, func(s *TSimpleServer) {
+			s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+			s.outputProtocolFactory = s.inputProtocolFactory
+		})
+		t.Cleanup(func() {
+			serv.Stop()
+		})
+		proto := NewTHeaderProtocolConf(dialTestSocketServer(t, addr), nil)
+		// The backoff is too long for the test to pass without pushback.
+		client := WrapClient(NewTStandardClient(proto, proto), RetryMiddleware(RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     time.Hour,
+		}))
+		start := time.Now()
+		var result pipelinedString
+		_, err := client.Call(ctx, "echo", &pipelinedString{"hello"}, &result)
+		if err == nil && result.Value != "hello" {
+			t.Errorf("expected %q, got %q", "hello", result.Value)
+		}
+		return atomic.LoadInt32(&processor.calls), time.Since(start), err
+	}
+
+	t.Run("retry-after", func(t *testing.T) {
+		// INTERNAL_ERROR is not retryable, but the server says it's safe.
+		calls, elapsed, err := call(t, &pushbackProcessor{
+			exc:        NewTApplicationException(INTERNAL_ERROR, "overloaded"),
+			pushback:   20 * time.Millisecond,
+			rejections: 2,
+		}, context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 3 {
+			t.Errorf("expected 3 attempts, got %d", calls)
+		}
+		if elapsed < 40*time.Millisecond {
+			t.Errorf("expected the retries to wait for the pushback, took %v", elapsed)
+		}
+	})
+
+	t.Run("no-retry", func(t *testing.T) {
+		calls, _, err := call(t, &pushbackProcessor{
+			exc:        NewTApplicationExceptionWithReason(INTERNAL_ERROR, ExceptionReasonRateLimited, "quota exhausted"),
+			pushback:   -1,
+			rejections: 1,
+		}, context.Background())
+		var tae TApplicationException
+		if !errors.As(err, &tae) || tae.TypeId() != INTERNAL_ERROR {
+			t.Errorf("expected INTERNAL_ERROR, got %v", err)
+		}
+		if reason := ExceptionReason(err); reason != ExceptionReasonRateLimited {
+			t.Errorf("expected the reason to be sent with the reply, got %q", reason)
+		}
+		if calls != 1 {
+			t.Errorf("expected a single attempt, got %d", calls)
+		}
+	})
+
+	t.Run("past-deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		calls, elapsed, err := call(t, &pushbackProcessor{
+			exc:        NewTApplicationExceptionWithReason(INTERNAL_ERROR, ExceptionReasonRateLimited, "rate limited"),
+			pushback:   time.Minute,
+			rejections: 1,
+		}, ctx)
+		if err == nil || calls != 1 {
+			t.Errorf("expected a single failed attempt, got %d: %v", calls, err)
+		}
+		if elapsed > 500*time.Millisecond {
+			t.Errorf("expected the call to fail right away, took %v", elapsed)
+		}
+	})
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitOptions{
+		Rate:  10,
+		Burst: 1,
+	})
+	now := time.Now()
+	if !limiter.allow("", now) {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if limiter.allow("", now) {
+		t.Fatal("expected the second request to be rejected")
+	}
+	d, ok := limiter.retryAfter("", now.Add(25*time.Millisecond))
+	if !ok || d < 74*time.Millisecond || d > 75*time.Millisecond {
+		t.Errorf("expected a token in 75ms, got %v, %v", d, ok)
+	}
+
+	if _, ok := NewRateLimiter(RateLimitOptions{}).retryAfter("", now); ok {
+		t.Error("expected no pushback without a rate")
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitKeyFunc returns the key of the token bucket a request is rate
+// limited by.
+type RateLimitKeyFunc func(ctx context.Context, method string) string
+
+// RateLimitByMethod is a RateLimitKeyFunc limiting every method separately.
+func RateLimitByMethod(ctx context.Context, method string) string {
+	return method
+}
+
+// RateLimitByPeer is a RateLimitKeyFunc limiting every client host
+// separately, see RemoteAddrFromContext.
+//
+// The requests of unknown peers share the same bucket.
+func RateLimitByPeer(ctx context.Context, method string) string {
+	addr, ok := RemoteAddrFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// RateLimitOptions configures a RateLimiter.
+type RateLimitOptions struct {
+	// Rate is the number of requests allowed per second and per key.
+	Rate float64
+
+	// Burst is the maximum number of requests allowed at once per key, 1 if
+	// less than 1.
+	Burst int
+
+	// Key returns the key requests are limited by.
+	//
+	// If nil, all the requests share the same bucket.
+	Key RateLimitKeyFunc
+
+	// OnReject is called for every rejected request, for example to record
+	// metrics by key.
+	OnReject func(ctx context.Context, method, key string)
+
+	// Oneway reports the oneway methods, see OnewayFunc.
+	Oneway OnewayFunc
+}
+
+// RateLimiter is a token bucket rate limiter for servers.
+//
+// The requests over the limit are rejected with a TApplicationException of
+// type INTERNAL_ERROR, and the connection is kept open. With THeaderProtocol,
+// the clients are told its ExceptionReasonRateLimited reason, and the
+// rejections push back on their retries until their bucket has a token
+// again, see SetRetryAfter.
+type RateLimiter struct {
+	opts     RateLimitOptions
+	rejected int64
+
+	mu      sync.Mutex
+	buckets map[string]*tTokenBucket
+	sweepAt int
+}
+
+// Buckets are swept when there are more than this many of them.
+const minRateLimitSweep = 1024
+
+// NewRateLimiter creates a RateLimiter.
+func NewRateLimiter(opts RateLimitOptions) *RateLimiter {
+	if opts.Burst < 1 {
+		opts.Burst = 1
+	}
+	return &RateLimiter{
+		opts:    opts,
+		buckets: make(map[string]*tTokenBucket),
+		sweepAt: minRateLimitSweep,
+	}
+}
+
+// Rejected returns the number of requests rejected so far.
+func (l *RateLimiter) Rejected() int64 {
+	return atomic.LoadInt64(&l.rejected)
+}
+
+// Middleware returns the ProcessorMiddleware enforcing the limit.
+func (l *RateLimiter) Middleware() ProcessorMiddleware {
+	return func(name string, next TProcessorFunction) TProcessorFunction {
+		return WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+				var key string
+				if l.opts.Key != nil {
+					key = l.opts.Key(ctx, name)
+				}
+				if l.allow(key, time.Now()) {
+					return next.Process(ctx, seqID, in, out)
+				}
+				atomic.AddInt64(&l.rejected, 1)
+				if l.opts.OnReject != nil {
+					l.opts.OnReject(ctx, name, key)
+				}
+				if wait, ok := l.retryAfter(key, time.Now()); ok {
+					SetRetryAfter(ctx, wait)
+				}
+				exc := NewTApplicationExceptionWithReason(INTERNAL_ERROR, ExceptionReasonRateLimited, "rate limit exceeded")
+				if err := skipRequestWithException(ctx, in, out, name, l.opts.Oneway.messageType(ctx, name), seqID, exc); err != nil {
+					return false, WrapTException(err)
+				}
+				return true, exc
+			},
+		}
+	}
+}
+
+func (l *RateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.sweepAt {
+			l.sweep(now)
+		}
+		b = &tTokenBucket{tokens: float64(l.opts.Burst), last: now}
+		l.buckets[key] = b
+	}
+	return b.take(now, l.opts.Rate, float64(l.opts.Burst))
+}
+
+// retryAfter returns the time until the bucket of key has a token, false if
+// it never will.
+func (l *RateLimiter) retryAfter(key string, now time.Time) (time.Duration, bool) {
+	if l.opts.Rate <= 0 {
+		return 0, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		return 0, true
+	}
+	missing := 1 - b.refill(now, l.opts.Rate, float64(l.opts.Burst))
+	if missing <= 0 {
+		return 0, true
+	}
+	return time.Duration(missing / l.opts.Rate * float64(time.Second)), true
+}
+
+// sweep removes the buckets that are full, as they are the same as new ones.
+//
+// It must be called with l.mu held.
+func (l *RateLimiter) sweep(now time.Time) {
+	burst := float64(l.opts.Burst)
+	for key, b := range l.buckets {
+		if b.refill(now, l.opts.Rate, burst) >= burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.sweepAt = 2 * len(l.buckets)
+	if l.sweepAt < minRateLimitSweep {
+		l.sweepAt = minRateLimitSweep
+	}
+}
+
+type tTokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tTokenBucket) refill(now time.Time, rate, burst float64) float64 {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+	return b.tokens
+}
+
+func (b *tTokenBucket) take(now time.Time, rate, burst float64) bool {
+	if b.refill(now, rate, burst) < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,149 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	l := NewRateLimiter(RateLimitOptions{Rate: 10, Burst: 2})
+	now := time.Now()
+	for i, c := range []struct {
+		after   time.Duration
+		allowed bool
+	}{
+		{0, true},
+		{0, true},
+		{0, false},
+		{50 * time.Millisecond, false},
+		{50 * time.Millisecond, true},
+		{0, false},
+		{time.Second, true},
+		{0, true},
+		{0, false},
+	} {
+		now = now.Add(c.after)
+		if allowed := l.allow("", now); allowed != c.allowed {
+			t.Errorf("#%d: expected allowed %v, got %v", i, c.allowed, allowed)
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := NewRateLimiter(RateLimitOptions{Rate: 1})
+	now := time.Now()
+	for i := 0; i < minRateLimitSweep; i++ {
+		l.allow(string(rune(i)), now)
+	}
+	// The buckets used a second ago are full again.
+	l.allow("new", now.Add(time.Second))
+	if len(l.buckets) != 1 {
+		t.Errorf("expected full buckets to be swept, got %d buckets", len(l.buckets))
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	var rejectedKeys []string
+	limiter := NewRateLimiter(RateLimitOptions{
+		Rate: 1e-9,
+		Key:  RateLimitByMethod,
+		OnReject: func(ctx context.Context, method, key string) {
+			rejectedKeys = append(rejectedKeys, key)
+		},
+	})
+	var processed int
+	handler := WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			processed++
+			return true, nil
+		},
+	}
+	processor := WrapProcessor(&mockWrappableProcessor{
+		ProcessorFuncs: map[string]TProcessorFunction{
+			"a": handler,
+			"b": handler,
+		},
+	}, limiter.Middleware())
+
+	ctx := context.Background()
+	buf := NewTMemoryBuffer()
+	proto := NewTBinaryProtocolConf(buf, nil)
+	call := func(name string) (bool, TException) {
+		buf.Reset()
+		args := NewTBinaryProtocolConf(buf, nil)
+		args.WriteStructBegin(ctx, "args")
+		args.WriteFieldStop(ctx)
+		args.WriteStructEnd(ctx)
+		return processor.ProcessorMap()[name].Process(ctx, 1, proto, proto)
+	}
+
+	if _, err := call("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call("b"); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := call("a")
+	if !ok {
+		t.Error("expected the connection to be kept open")
+	}
+	var tae TApplicationException
+	if !errors.As(err, &tae) || tae.TypeId() != INTERNAL_ERROR || ExceptionReason(err) != ExceptionReasonRateLimited {
+		t.Fatalf("expected a rate limited INTERNAL_ERROR, got %v", err)
+	}
+	_, typeID, _, _ := proto.ReadMessageBegin(ctx)
+	if typeID != EXCEPTION {
+		t.Errorf("expected exception reply, got %v", typeID)
+	}
+	exc := NewTApplicationException(UNKNOWN_APPLICATION_EXCEPTION, "")
+	if err := exc.Read(ctx, proto); err != nil {
+		t.Fatal(err)
+	}
+	if exc.TypeId() != INTERNAL_ERROR {
+		t.Errorf("expected INTERNAL_ERROR reply, got %v", exc)
+	}
+
+	if processed != 2 {
+		t.Errorf("expected 2 processed requests, got %d", processed)
+	}
+	if limiter.Rejected() != 1 {
+		t.Errorf("expected 1 rejected request, got %d", limiter.Rejected())
+	}
+	if len(rejectedKeys) != 1 || rejectedKeys[0] != "a" {
+		t.Errorf("unexpected rejected keys %v", rejectedKeys)
+	}
+}
+
+func TestRateLimitByPeer(t *testing.T) {
+	if key := RateLimitByPeer(context.Background(), "m"); key != "" {
+		t.Errorf("expected empty key for unknown peer, got %q", key)
+	}
+	ctx := context.WithValue(context.Background(), peerKey{}, tPeer{
+		addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234},
+	})
+	if key := RateLimitByPeer(ctx, "m"); key != "10.0.0.1" {
+		t.Errorf("expected peer host as key, got %q", key)
+	}
+}
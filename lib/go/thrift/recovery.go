@@ -0,0 +1,80 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// RecoveryOptions configures RecoveryMiddleware.
+type RecoveryOptions struct {
+	// OnPanic is called with the value recovered from a panic of a processor
+	// function and the stack trace of the panicking goroutine.
+	//
+	// If nil, they are logged to Logger.
+	OnPanic func(ctx context.Context, method string, recovered interface{}, stack []byte)
+
+	// Logger is used when OnPanic is nil, StdLogger(nil) if nil as well.
+	Logger Logger
+
+	// Oneway reports the oneway methods, see OnewayFunc.
+	Oneway OnewayFunc
+}
+
+// RecoveryMiddleware returns a ProcessorMiddleware recovering from the panics
+// of the processor functions, which would otherwise crash the server.
+//
+// The client is replied with a TApplicationException of type INTERNAL_ERROR,
+// without the details of the panic, and the connection is kept open. As the
+// panic can happen after part of the reply is written, it should be used with
+// buffered or framed output transports for the reply to be well-formed.
+func RecoveryMiddleware(opts RecoveryOptions) ProcessorMiddleware {
+	onPanic := opts.OnPanic
+	if onPanic == nil {
+		logger := fallbackLogger(opts.Logger)
+		onPanic = func(_ context.Context, method string, recovered interface{}, stack []byte) {
+			logger(fmt.Sprintf("panic processing %q: %v\n%s", method, recovered, stack))
+		}
+	}
+	return func(name string, next TProcessorFunction) TProcessorFunction {
+		return WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (ok bool, err TException) {
+				defer func() {
+					recovered := recover()
+					if recovered == nil {
+						return
+					}
+					onPanic(ctx, name, recovered, debug.Stack())
+					exc := NewTApplicationException(INTERNAL_ERROR, "Internal error processing "+name)
+					if !opts.Oneway.IsOneway(ctx, name) {
+						if writeErr := writeApplicationException(ctx, out, name, seqID, exc); writeErr != nil {
+							ok, err = false, WrapTException(writeErr)
+							return
+						}
+					}
+					ok, err = true, exc
+				}()
+				return next.Process(ctx, seqID, in, out)
+			},
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	panicking := WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			panic("boom")
+		},
+	}
+	type panicInfo struct {
+		method    string
+		recovered interface{}
+		stack     string
+	}
+	var panics []panicInfo
+	processor := WrapProcessor(&mockWrappableProcessor{
+		ProcessorFuncs: map[string]TProcessorFunction{
+			"call":   panicking,
+			"notify": panicking,
+		},
+	}, RecoveryMiddleware(RecoveryOptions{
+		OnPanic: func(ctx context.Context, method string, recovered interface{}, stack []byte) {
+			panics = append(panics, panicInfo{method, recovered, string(stack)})
+		},
+		Oneway: func(method string) bool {
+			return method == "notify"
+		},
+	}))
+
+	ctx := context.Background()
+	buf := NewTMemoryBuffer()
+	proto := NewTBinaryProtocolConf(buf, nil)
+	ok, err := processor.ProcessorMap()["call"].Process(ctx, 1, proto, proto)
+	if !ok {
+		t.Error("expected the connection to be kept open")
+	}
+	var tae TApplicationException
+	if !errors.As(err, &tae) || tae.TypeId() != INTERNAL_ERROR {
+		t.Errorf("expected INTERNAL_ERROR, got %v", err)
+	}
+	if len(panics) != 1 || panics[0].method != "call" || panics[0].recovered != "boom" {
+		t.Fatalf("unexpected panics %+v", panics)
+	}
+	if !strings.Contains(panics[0].stack, "TestRecoveryMiddleware") {
+		t.Errorf("expected the stack trace of the panic, got %s", panics[0].stack)
+	}
+
+	name, typeID, seqID, readErr := proto.ReadMessageBegin(ctx)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if name != "call" || typeID != EXCEPTION || seqID != 1 {
+		t.Errorf("unexpected reply %q %v %d", name, typeID, seqID)
+	}
+	exc := NewTApplicationException(UNKNOWN_APPLICATION_EXCEPTION, "")
+	if err := exc.Read(ctx, proto); err != nil {
+		t.Fatal(err)
+	}
+	if exc.TypeId() != INTERNAL_ERROR || strings.Contains(exc.Error(), "boom") {
+		t.Errorf("unexpected exception %v", exc)
+	}
+
+	buf.Reset()
+	if ok, _ := processor.ProcessorMap()["notify"].Process(ctx, 2, proto, proto); !ok {
+		t.Error("expected the connection to be kept open")
+	}
+	if len(panics) != 2 {
+		t.Errorf("expected 2 panics, got %d", len(panics))
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no reply to oneway request, got %d bytes", buf.Len())
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DefaultRedactionMask is the default RedactOptions.Mask.
+const DefaultRedactionMask = "<redacted>"
+
+// RedactOptions configures Redact.
+type RedactOptions struct {
+	// Mask replaces the values of the sensitive fields, DefaultRedactionMask
+	// if empty.
+	Mask string
+
+	// Sensitive reports whether the field of the struct type typeName, named
+	// as in the IDL, is sensitive in addition to the tagged ones, e.g. for the
+	// code generated from IDL files without the annotations.
+	Sensitive func(typeName, field string) bool
+}
+
+// Redact renders v for logging with the default RedactOptions, see
+// RedactOptions.Redact.
+func Redact(v interface{}) string {
+	return RedactOptions{}.Redact(v)
+}
+
+// Redact renders v, a generated struct or a struct with thrift tags (see
+// EncodeStruct), for logging, with the values of its sensitive fields, and of
+// the ones of the structs it contains, replaced by the Mask.
+//
+// The sensitive fields are the ones with the "sensitive" option in their
+// thrift tag, which the generated code has for the fields annotated with
+// sensitive or pii in the IDL:
+//
+//	struct Login {
+//	  1: string user
+//	  2: string password (sensitive = "true")
+//	}
+//
+// v is rendered like the String method of the generated structs, e.g.
+// `Login({User:alice Password:<redacted>})`, except that the pointers are
+// rendered as the values they point to, and that the fields without a thrift
+// tag are omitted.
+func (o RedactOptions) Redact(v interface{}) string {
+	if s, ok := v.(tReflectStruct); ok {
+		v = s.v
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Sprintf("%v", v)
+	}
+	var sb strings.Builder
+	sb.WriteString(rv.Type().Name())
+	sb.WriteByte('(')
+	o.redactValue(&sb, rv, DEFAULT_RECURSION_DEPTH)
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+func (o RedactOptions) redactValue(sb *strings.Builder, v reflect.Value, maxDepth int) {
+	if maxDepth <= 0 {
+		sb.WriteString("...")
+		return
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			sb.WriteString("<nil>")
+			return
+		}
+		o.redactValue(sb, v.Elem(), maxDepth-1)
+	case reflect.Struct:
+		o.redactStruct(sb, v, maxDepth)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			fmt.Fprintf(sb, "%v", v.Interface())
+			return
+		}
+		sb.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			o.redactValue(sb, v.Index(i), maxDepth-1)
+		}
+		sb.WriteByte(']')
+	case reflect.Map:
+		// The entries are sorted by their rendered keys, so that the output
+		// is deterministic. The values of the sets are omitted.
+		set := isJSONSet(v.Type())
+		entries := make([][2]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			var key, value strings.Builder
+			o.redactValue(&key, iter.Key(), maxDepth-1)
+			if !set {
+				o.redactValue(&value, iter.Value(), maxDepth-1)
+			}
+			entries = append(entries, [2]string{key.String(), value.String()})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i][0] < entries[j][0]
+		})
+		sb.WriteString("map[")
+		for i, e := range entries {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(e[0])
+			if !set {
+				sb.WriteByte(':')
+				sb.WriteString(e[1])
+			}
+		}
+		sb.WriteByte(']')
+	default:
+		if v.CanInterface() {
+			fmt.Fprintf(sb, "%v", v.Interface())
+		} else {
+			fmt.Fprintf(sb, "%v", v)
+		}
+	}
+}
+
+func (o RedactOptions) redactStruct(sb *strings.Builder, v reflect.Value, maxDepth int) {
+	info, err := reflectStructInfo(v.Type())
+	if err != nil {
+		// The values of an invalid struct can't be told sensitive or not.
+		fmt.Fprintf(sb, "{%s}", o.mask())
+		return
+	}
+	sb.WriteByte('{')
+	for i, f := range info.fields {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(v.Type().Field(f.index).Name)
+		sb.WriteByte(':')
+		if f.sensitive || (o.Sensitive != nil && o.Sensitive(info.name, f.name)) {
+			sb.WriteString(o.mask())
+			continue
+		}
+		o.redactValue(sb, v.Field(f.index), maxDepth-1)
+	}
+	sb.WriteByte('}')
+}
+
+func (o RedactOptions) mask() string {
+	if o.Mask == "" {
+		return DefaultRedactionMask
+	}
+	return o.Mask
+}
@@ -0,0 +1,91 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"strings"
+	"testing"
+)
+
+type redactTestCard struct {
+	Number string `thrift:"number,1,required,sensitive"`
+	Expiry string `thrift:"expiry,2"`
+}
+
+type redactTestLogin struct {
+	User     string                    `thrift:"user,1"`
+	Password *string                   `thrift:"password,2,sensitive"`
+	Cards    []*redactTestCard         `thrift:"cards,3"`
+	ByName   map[string]redactTestCard `thrift:"byName,4"`
+	Tags     map[string]struct{}       `thrift:"tags,5"`
+	Email    string                    `thrift:"email,6"`
+	Next     *redactTestLogin          `thrift:"next,7"`
+	internal string
+}
+
+func TestRedact(t *testing.T) {
+	password := "hunter2"
+	login := &redactTestLogin{
+		User:     "alice",
+		Password: &password,
+		Cards:    []*redactTestCard{{Number: "4111", Expiry: "01/30"}, nil},
+		ByName:   map[string]redactTestCard{"b": {Number: "5500"}, "a": {Number: "3400"}},
+		Tags:     map[string]struct{}{"y": {}, "x": {}},
+		Email:    "alice@example.com",
+		internal: "internal",
+	}
+	const expected = "redactTestLogin({User:alice Password:<redacted> " +
+		"Cards:[{Number:<redacted> Expiry:01/30} <nil>] " +
+		"ByName:map[a:{Number:<redacted> Expiry:} b:{Number:<redacted> Expiry:}] " +
+		"Tags:map[x y] Email:alice@example.com Next:<nil>})"
+	if got := Redact(login); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if got := Redact(ReflectStruct(login)); got != expected {
+		t.Errorf("expected the same rendering through ReflectStruct, got %s", got)
+	}
+
+	got := RedactOptions{
+		Mask: "***",
+		Sensitive: func(typeName, field string) bool {
+			return typeName == "redactTestLogin" && field == "email"
+		},
+	}.Redact(*login)
+	if strings.Contains(got, "hunter2") || strings.Contains(got, "4111") || strings.Contains(got, "alice@example.com") {
+		t.Errorf("sensitive value rendered in %s", got)
+	}
+	if !strings.Contains(got, "Email:***") {
+		t.Errorf("expected the email to be masked, got %s", got)
+	}
+
+	// The generated structs are rendered like their String method, enums
+	// included.
+	generated := &MyTestStruct{Int64: 42, E: MyTestEnum_FIRST, StringList: []string{"a"}}
+	if got := Redact(generated); !strings.HasPrefix(got, "MyTestStruct({On:false B:0 Int16:0 Int32:0 Int64:42 ") ||
+		!strings.Contains(got, "StringList:[a]") || !strings.HasSuffix(got, "E:FIRST})") {
+		t.Errorf("unexpected rendering %s", got)
+	}
+
+	// The cycles are cut at the recursion depth.
+	login.Next = login
+	if got := Redact(login); !strings.Contains(got, "...") || strings.Contains(got, "hunter2") {
+		t.Errorf("unexpected rendering of a cycle %s", got)
+	}
+}
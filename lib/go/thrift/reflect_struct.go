@@ -0,0 +1,516 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EncodeStruct writes v, a struct or a pointer to a struct, to out as a
+// thrift struct, using the thrift tags of its fields like the generated code
+// does, so that the programs which can't run the compiler can still talk to
+// thrift services:
+//
+//	type User struct {
+//		ID    int64    `thrift:"id,1,required"`
+//		Name  *string  `thrift:"name,2"`
+//		Roles []string `thrift:"roles,3,set"`
+//	}
+//
+// The tag is the name of the field in the IDL, its id, then its options:
+// "required", "set" for the slices of the set fields, which are lists by
+// default, and "sensitive" for the fields masked by Redact. The fields without
+// a thrift tag are ignored.
+//
+// The Go types map to the thrift types as follows: bool to bool, int8 and
+// uint8 to byte, int16 to i16, int32 and the generated enums (the int64 types
+// implementing encoding.TextMarshaler) to i32, int64 and int to i64, float64
+// and float32 to double, string to string, []byte to binary, slices to lists (or
+// sets), maps to maps, and structs to structs, the fields of a type
+// implementing TStruct (like the generated structs) being written by its
+// Write method. The pointers are written as the value they point to.
+//
+// The nil pointers, slices and maps are the unset fields, not written. They
+// fail the encoding if the field is required.
+func EncodeStruct(ctx context.Context, out TProtocol, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot encode %T as a struct", v)
+	}
+	info, err := reflectStructInfo(rv.Type())
+	if err != nil {
+		return err
+	}
+	return writeReflectStruct(ctx, out, rv, info)
+}
+
+// DecodeStruct reads the thrift struct of in into v, a non-nil pointer to a
+// struct with thrift tags, see EncodeStruct.
+//
+// The fields of in not in v, or of another type, are skipped, and the
+// decoding fails if a required field of v is not in in.
+func DecodeStruct(ctx context.Context, in TProtocol, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot decode a struct into %T", v)
+	}
+	info, err := reflectStructInfo(rv.Elem().Type())
+	if err != nil {
+		return err
+	}
+	return readReflectStruct(ctx, in, rv.Elem(), info)
+}
+
+// ReflectStruct returns the TStruct encoding and decoding v with EncodeStruct
+// and DecodeStruct, for example to serialize it with a TSerializer, or to use
+// it as the arguments or the result of a call:
+//
+//	var result struct {
+//		Success *User `thrift:"success,0"`
+//	}
+//	_, err := client.Call(ctx, "getUser", thrift.ReflectStruct(&args), thrift.ReflectStruct(&result))
+func ReflectStruct(v interface{}) TStruct {
+	return tReflectStruct{v}
+}
+
+type tReflectStruct struct {
+	v interface{}
+}
+
+func (s tReflectStruct) Write(ctx context.Context, out TProtocol) error {
+	return EncodeStruct(ctx, out, s.v)
+}
+
+func (s tReflectStruct) Read(ctx context.Context, in TProtocol) error {
+	return DecodeStruct(ctx, in, s.v)
+}
+
+var (
+	tStructType       = reflect.TypeOf((*TStruct)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// isReflectEnum reports whether t is an enum type of the generated code.
+func isReflectEnum(t reflect.Type) bool {
+	return t.Kind() == reflect.Int64 && t.Implements(textMarshalerType)
+}
+
+// tReflectField is a field of a struct with thrift tags.
+type tReflectField struct {
+	index     int
+	name      string
+	id        int16
+	required  bool
+	set       bool
+	sensitive bool
+	ttype     TType
+}
+
+// tReflectStructInfo are the thrift fields of a struct type.
+type tReflectStructInfo struct {
+	name   string
+	fields []tReflectField
+	byID   map[int16]int
+}
+
+// reflectStructInfos caches the tReflectStructInfo of the struct types.
+var reflectStructInfos sync.Map
+
+func reflectStructInfo(t reflect.Type) (*tReflectStructInfo, error) {
+	if info, ok := reflectStructInfos.Load(t); ok {
+		return info.(*tReflectStructInfo), nil
+	}
+	info := &tReflectStructInfo{
+		name: t.Name(),
+		byID: make(map[int16]int),
+	}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("thrift")
+		if !ok || sf.PkgPath != "" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("missing id in the thrift tag of %s.%s", t, sf.Name)
+		}
+		id, err := strconv.ParseInt(parts[1], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id in the thrift tag of %s.%s: %w", t, sf.Name, err)
+		}
+		f := tReflectField{
+			index: i,
+			name:  parts[0],
+			id:    int16(id),
+		}
+		for _, option := range parts[2:] {
+			switch option {
+			case "required":
+				f.required = true
+			case "set":
+				f.set = true
+			case "sensitive":
+				f.sensitive = true
+			}
+		}
+		if f.ttype, err = reflectTType(sf.Type, f.set); err != nil {
+			return nil, fmt.Errorf("field %s.%s: %w", t, sf.Name, err)
+		}
+		if _, ok := info.byID[f.id]; ok {
+			return nil, fmt.Errorf("duplicate thrift id %d in %s", f.id, t)
+		}
+		info.byID[f.id] = len(info.fields)
+		info.fields = append(info.fields, f)
+	}
+	actual, _ := reflectStructInfos.LoadOrStore(t, info)
+	return actual.(*tReflectStructInfo), nil
+}
+
+// reflectTType returns the TType of the Go type t, a SET instead of a LIST if
+// set is true.
+func reflectTType(t reflect.Type, set bool) (TType, error) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return reflectTType(t.Elem(), set)
+	case reflect.Bool:
+		return BOOL, nil
+	case reflect.Int8, reflect.Uint8:
+		return BYTE, nil
+	case reflect.Int16:
+		return I16, nil
+	case reflect.Int32:
+		return I32, nil
+	case reflect.Int64, reflect.Int:
+		if isReflectEnum(t) {
+			return I32, nil
+		}
+		return I64, nil
+	case reflect.Float32, reflect.Float64:
+		return DOUBLE, nil
+	case reflect.String:
+		return STRING, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return STRING, nil
+		}
+		if _, err := reflectTType(t.Elem(), false); err != nil {
+			return STOP, err
+		}
+		if set {
+			return SET, nil
+		}
+		return LIST, nil
+	case reflect.Map:
+		if _, err := reflectTType(t.Key(), false); err != nil {
+			return STOP, err
+		}
+		if _, err := reflectTType(t.Elem(), false); err != nil {
+			return STOP, err
+		}
+		return MAP, nil
+	case reflect.Struct:
+		// The fields of the nested structs are checked when they're encoded
+		// or decoded, as they may be recursive.
+		return STRUCT, nil
+	default:
+		return STOP, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+func writeReflectStruct(ctx context.Context, out TProtocol, v reflect.Value, info *tReflectStructInfo) error {
+	if err := out.WriteStructBegin(ctx, info.name); err != nil {
+		return err
+	}
+	for _, f := range info.fields {
+		fv := v.Field(f.index)
+		switch fv.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map:
+			if fv.IsNil() {
+				if f.required {
+					return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("required field %s of %s is not set", f.name, info.name))
+				}
+				continue
+			}
+		}
+		if err := out.WriteFieldBegin(ctx, f.name, f.ttype, f.id); err != nil {
+			return err
+		}
+		if err := writeReflectValue(ctx, out, fv, f.set); err != nil {
+			return PrependError(fmt.Sprintf("%s.%s: ", info.name, f.name), err)
+		}
+		if err := out.WriteFieldEnd(ctx); err != nil {
+			return err
+		}
+	}
+	if err := out.WriteFieldStop(ctx); err != nil {
+		return err
+	}
+	return out.WriteStructEnd(ctx)
+}
+
+func writeReflectValue(ctx context.Context, out TProtocol, v reflect.Value, set bool) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return errors.New("nil element")
+		}
+		return writeReflectValue(ctx, out, v.Elem(), set)
+	case reflect.Bool:
+		return out.WriteBool(ctx, v.Bool())
+	case reflect.Int8:
+		return out.WriteByte(ctx, int8(v.Int()))
+	case reflect.Uint8:
+		return out.WriteByte(ctx, int8(v.Uint()))
+	case reflect.Int16:
+		return out.WriteI16(ctx, int16(v.Int()))
+	case reflect.Int32:
+		return out.WriteI32(ctx, int32(v.Int()))
+	case reflect.Int64, reflect.Int:
+		if isReflectEnum(v.Type()) {
+			return out.WriteI32(ctx, int32(v.Int()))
+		}
+		return out.WriteI64(ctx, v.Int())
+	case reflect.Float32, reflect.Float64:
+		return out.WriteDouble(ctx, v.Float())
+	case reflect.String:
+		return out.WriteString(ctx, v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return out.WriteBinary(ctx, v.Bytes())
+		}
+		elemType, _ := reflectTType(v.Type().Elem(), false)
+		var err error
+		if set {
+			err = out.WriteSetBegin(ctx, elemType, v.Len())
+		} else {
+			err = out.WriteListBegin(ctx, elemType, v.Len())
+		}
+		if err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := writeReflectValue(ctx, out, v.Index(i), false); err != nil {
+				return err
+			}
+		}
+		if set {
+			return out.WriteSetEnd(ctx)
+		}
+		return out.WriteListEnd(ctx)
+	case reflect.Map:
+		keyType, _ := reflectTType(v.Type().Key(), false)
+		valueType, _ := reflectTType(v.Type().Elem(), false)
+		if err := out.WriteMapBegin(ctx, keyType, valueType, v.Len()); err != nil {
+			return err
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := writeReflectValue(ctx, out, iter.Key(), false); err != nil {
+				return err
+			}
+			if err := writeReflectValue(ctx, out, iter.Value(), false); err != nil {
+				return err
+			}
+		}
+		return out.WriteMapEnd(ctx)
+	case reflect.Struct:
+		if reflect.PtrTo(v.Type()).Implements(tStructType) {
+			if !v.CanAddr() {
+				ptr := reflect.New(v.Type())
+				ptr.Elem().Set(v)
+				v = ptr.Elem()
+			}
+			return v.Addr().Interface().(TStruct).Write(ctx, out)
+		}
+		info, err := reflectStructInfo(v.Type())
+		if err != nil {
+			return err
+		}
+		return writeReflectStruct(ctx, out, v, info)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+}
+
+// readReflectStruct reads a struct into v, which must be settable.
+func readReflectStruct(ctx context.Context, in TProtocol, v reflect.Value, info *tReflectStructInfo) error {
+	if _, err := in.ReadStructBegin(ctx); err != nil {
+		return err
+	}
+	isset := make([]bool, len(info.fields))
+	for {
+		_, fieldType, id, err := in.ReadFieldBegin(ctx)
+		if err != nil {
+			return err
+		}
+		if fieldType == STOP {
+			break
+		}
+		i, ok := info.byID[id]
+		if ok && info.fields[i].ttype == fieldType {
+			f := info.fields[i]
+			if err := readReflectValue(ctx, in, v.Field(f.index), fieldType); err != nil {
+				return PrependError(fmt.Sprintf("%s.%s: ", info.name, f.name), err)
+			}
+			isset[i] = true
+		} else if err := in.Skip(ctx, fieldType); err != nil {
+			return err
+		}
+		if err := in.ReadFieldEnd(ctx); err != nil {
+			return err
+		}
+	}
+	if err := in.ReadStructEnd(ctx); err != nil {
+		return err
+	}
+	for i, f := range info.fields {
+		if f.required && !isset[i] {
+			return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("required field %s of %s is not set", f.name, info.name))
+		}
+	}
+	return nil
+}
+
+// readReflectValue reads a value of type ttype into v, which must be
+// settable.
+func readReflectValue(ctx context.Context, in TProtocol, v reflect.Value, ttype TType) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return readReflectValue(ctx, in, v.Elem(), ttype)
+	case reflect.Bool:
+		b, err := in.ReadBool(ctx)
+		v.SetBool(b)
+		return err
+	case reflect.Int8:
+		b, err := in.ReadByte(ctx)
+		v.SetInt(int64(b))
+		return err
+	case reflect.Uint8:
+		b, err := in.ReadByte(ctx)
+		v.SetUint(uint64(uint8(b)))
+		return err
+	case reflect.Int16:
+		i, err := in.ReadI16(ctx)
+		v.SetInt(int64(i))
+		return err
+	case reflect.Int32:
+		i, err := in.ReadI32(ctx)
+		v.SetInt(int64(i))
+		return err
+	case reflect.Int64, reflect.Int:
+		if ttype == I32 {
+			i, err := in.ReadI32(ctx)
+			v.SetInt(int64(i))
+			return err
+		}
+		i, err := in.ReadI64(ctx)
+		v.SetInt(i)
+		return err
+	case reflect.Float32, reflect.Float64:
+		f, err := in.ReadDouble(ctx)
+		v.SetFloat(f)
+		return err
+	case reflect.String:
+		s, err := in.ReadString(ctx)
+		v.SetString(s)
+		return err
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := in.ReadBinary(ctx)
+			v.SetBytes(b)
+			return err
+		}
+		var elemType TType
+		var size int
+		var err error
+		if ttype == SET {
+			elemType, size, err = in.ReadSetBegin(ctx)
+		} else {
+			elemType, size, err = in.ReadListBegin(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		if expected, _ := reflectTType(v.Type().Elem(), false); elemType != expected {
+			return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("unexpected element type %v, expected %v", elemType, expected))
+		}
+		slice := reflect.MakeSlice(v.Type(), size, size)
+		for i := 0; i < size; i++ {
+			if err := readReflectValue(ctx, in, slice.Index(i), elemType); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		if ttype == SET {
+			return in.ReadSetEnd(ctx)
+		}
+		return in.ReadListEnd(ctx)
+	case reflect.Map:
+		keyType, valueType, size, err := in.ReadMapBegin(ctx)
+		if err != nil {
+			return err
+		}
+		expectedKey, _ := reflectTType(v.Type().Key(), false)
+		expectedValue, _ := reflectTType(v.Type().Elem(), false)
+		if size > 0 && (keyType != expectedKey || valueType != expectedValue) {
+			return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("unexpected map types %v and %v, expected %v and %v", keyType, valueType, expectedKey, expectedValue))
+		}
+		m := reflect.MakeMapWithSize(v.Type(), size)
+		for i := 0; i < size; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := readReflectValue(ctx, in, key, keyType); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := readReflectValue(ctx, in, value, valueType); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+		return in.ReadMapEnd(ctx)
+	case reflect.Struct:
+		if reflect.PtrTo(v.Type()).Implements(tStructType) {
+			return v.Addr().Interface().(TStruct).Read(ctx, in)
+		}
+		info, err := reflectStructInfo(v.Type())
+		if err != nil {
+			return err
+		}
+		return readReflectStruct(ctx, in, v, info)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+}
+
+var _ TStruct = tReflectStruct{}
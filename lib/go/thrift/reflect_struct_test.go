@@ -0,0 +1,224 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type reflectTestAddress struct {
+	City string `thrift:"city,1,required"`
+}
+
+type reflectTestUser struct {
+	ID        int64                          `thrift:"id,1,required"`
+	Name      *string                        `thrift:"name,2"`
+	Roles     []string                       `thrift:"roles,3,set"`
+	Scores    map[string]float64             `thrift:"scores,4"`
+	Address   *reflectTestAddress            `thrift:"address,5"`
+	Previous  []reflectTestAddress           `thrift:"previous,6"`
+	Avatar    []byte                         `thrift:"avatar,7"`
+	Flags     map[int16][]bool               `thrift:"flags,8"`
+	Level     int8                           `thrift:"level,9"`
+	Generated *MyTestStruct                  `thrift:"generated,10"`
+	Friends   []*reflectTestUser             `thrift:"friends,11"`
+	Labels    map[reflectTestAddress]float32 `thrift:"labels,12"`
+
+	// Ignored, as it has no thrift tag.
+	Cache string
+}
+
+// reflectTestGenerated mirrors MyTestStruct, with a set instead of the
+// stringSet map and without some of its fields.
+type reflectTestGenerated struct {
+	On         bool              `thrift:"on,1"`
+	Int64      int64             `thrift:"int64,5"`
+	St         string            `thrift:"st,7"`
+	StringMap  map[string]string `thrift:"stringMap,9"`
+	StringList []string          `thrift:"stringList,10"`
+	StringSet  []string          `thrift:"stringSet,11,set"`
+	E          int32             `thrift:"e,12"`
+}
+
+func TestReflectStructRoundTrip(t *testing.T) {
+	name := "alice"
+	user := &reflectTestUser{
+		ID:       1,
+		Name:     &name,
+		Roles:    []string{"admin"},
+		Scores:   map[string]float64{"math": 9.5},
+		Address:  &reflectTestAddress{City: "Paris"},
+		Previous: []reflectTestAddress{{City: "Lyon"}, {City: "Nice"}},
+		Avatar:   []byte{0xff, 0x00},
+		Flags:    map[int16][]bool{3: {true, false}},
+		Level:    -2,
+		// The generated code decodes the empty containers as non-nil.
+		Generated: &MyTestStruct{
+			St:         "generated",
+			Bin:        []byte{},
+			StringMap:  map[string]string{},
+			StringList: []string{},
+			StringSet:  map[string]struct{}{"a": {}},
+			E:          MyTestEnum_THIRD,
+		},
+		Friends: []*reflectTestUser{{ID: 2}},
+		Labels:  map[reflectTestAddress]float32{{City: "Rome"}: 0.5},
+		Cache:   "not encoded",
+	}
+	for _, factory := range []TProtocolFactory{
+		NewTBinaryProtocolFactoryConf(nil),
+		NewTCompactProtocolFactoryConf(nil),
+	} {
+		serializer := &TSerializer{Transport: NewTMemoryBuffer()}
+		serializer.Protocol = factory.GetProtocol(serializer.Transport)
+		b, err := serializer.Write(context.Background(), ReflectStruct(user))
+		if err != nil {
+			t.Fatal(err)
+		}
+		deserializer := &TDeserializer{Transport: NewTMemoryBuffer()}
+		deserializer.Protocol = factory.GetProtocol(deserializer.Transport)
+		var decoded reflectTestUser
+		if err := deserializer.Read(context.Background(), ReflectStruct(&decoded), b); err != nil {
+			t.Fatal(err)
+		}
+		expected := *user
+		expected.Cache = ""
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("%T: expected %+v, got %+v", factory, expected, decoded)
+		}
+	}
+}
+
+func TestReflectStructGeneratedCompatibility(t *testing.T) {
+	ctx := context.Background()
+	generated := &MyTestStruct{
+		On:         true,
+		B:          3,
+		Int64:      64,
+		D:          1.5,
+		St:         "hello",
+		StringMap:  map[string]string{"k": "v"},
+		StringList: []string{"x", "y"},
+		StringSet:  map[string]struct{}{"s": {}},
+		E:          MyTestEnum_SECOND,
+	}
+	buf := NewTMemoryBuffer()
+	proto := NewTBinaryProtocolConf(buf, nil)
+	if err := generated.Write(ctx, proto); err != nil {
+		t.Fatal(err)
+	}
+	// The fields not in reflectTestGenerated are skipped.
+	var mirror reflectTestGenerated
+	if err := DecodeStruct(ctx, proto, &mirror); err != nil {
+		t.Fatal(err)
+	}
+	expected := reflectTestGenerated{
+		On:         true,
+		Int64:      64,
+		St:         "hello",
+		StringMap:  map[string]string{"k": "v"},
+		StringList: []string{"x", "y"},
+		StringSet:  []string{"s"},
+		E:          int32(MyTestEnum_SECOND),
+	}
+	if !reflect.DeepEqual(mirror, expected) {
+		t.Errorf("expected %+v, got %+v", expected, mirror)
+	}
+
+	buf.Reset()
+	if err := EncodeStruct(ctx, proto, mirror); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &MyTestStruct{}
+	if err := decoded.Read(ctx, proto); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.St != "hello" || decoded.E != MyTestEnum_SECOND || len(decoded.StringSet) != 1 || decoded.B != 0 {
+		t.Errorf("unexpected decoded struct %+v", decoded)
+	}
+}
+
+func TestReflectStructRequiredFields(t *testing.T) {
+	ctx := context.Background()
+	buf := NewTMemoryBuffer()
+	proto := NewTBinaryProtocolConf(buf, nil)
+
+	var pe TProtocolException
+	err := EncodeStruct(ctx, proto, &reflectTestUser{
+		Address: &reflectTestAddress{},
+	})
+	if err != nil {
+		t.Fatalf("expected the empty required string to be encoded: %v", err)
+	}
+	if err := DecodeStruct(ctx, proto, &reflectTestUser{}); err != nil {
+		t.Fatal(err)
+	}
+
+	type optionalCity struct {
+		City *string `thrift:"city,1"`
+	}
+	buf.Reset()
+	if err := EncodeStruct(ctx, proto, optionalCity{}); err != nil {
+		t.Fatal(err)
+	}
+	err = DecodeStruct(ctx, proto, &reflectTestAddress{})
+	if !errors.As(err, &pe) || pe.TypeId() != INVALID_DATA {
+		t.Errorf("expected INVALID_DATA for the missing required field, got %v", err)
+	}
+
+	type requiredList struct {
+		Cities []string `thrift:"cities,1,required"`
+	}
+	err = EncodeStruct(ctx, proto, requiredList{})
+	if !errors.As(err, &pe) || pe.TypeId() != INVALID_DATA {
+		t.Errorf("expected INVALID_DATA for the nil required field, got %v", err)
+	}
+}
+
+func TestReflectStructInvalidTypes(t *testing.T) {
+	ctx := context.Background()
+	proto := NewTBinaryProtocolConf(NewTMemoryBuffer(), nil)
+	for _, v := range []interface{}{
+		"not a struct",
+		&struct {
+			Count uint32 `thrift:"count,1"`
+		}{},
+		&struct {
+			Value interface{} `thrift:"value,1"`
+		}{},
+		&struct {
+			A int32 `thrift:"a,1"`
+			B int32 `thrift:"b,1"`
+		}{},
+		&struct {
+			A int32 `thrift:"a"`
+		}{},
+	} {
+		if err := EncodeStruct(ctx, proto, v); err == nil {
+			t.Errorf("%T: expected an error", v)
+		}
+	}
+	if err := DecodeStruct(ctx, proto, reflectTestAddress{}); err == nil {
+		t.Error("expected an error decoding into a struct value")
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RequestIDHeader is the THeader carrying the id of a request, for the
+// correlation of the logs of a request across services.
+const RequestIDHeader = "thrift-request-id"
+
+// See https://godoc.org/context#WithValue on why do we need the unexported typedefs.
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx with the request id set.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request id set in ctx by WithRequestID,
+// or read from the RequestIDHeader of the request being handled.
+func RequestIDFromContext(ctx context.Context) (id string, ok bool) {
+	if id, ok = ctx.Value(requestIDKey{}).(string); ok {
+		return id, true
+	}
+	return GetHeader(ctx, RequestIDHeader)
+}
+
+// RequestIDClientMiddleware is a ClientMiddleware sending the request id of
+// the context of the calls in the RequestIDHeader, generating a new one when
+// the context has none.
+//
+// As the server requests have the id read from their RequestIDHeader, it's
+// propagated to the calls made by their handlers. It requires
+// THeaderProtocol.
+func RequestIDClientMiddleware(next TClient) TClient {
+	return WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			id, ok := RequestIDFromContext(ctx)
+			if !ok {
+				id = newRequestID()
+				ctx = WithRequestID(ctx, id)
+			}
+			return next.Call(addWriteHeader(ctx, RequestIDHeader, id), method, args, result)
+		},
+	}
+}
+
+// RequestIDProcessorMiddleware is a ProcessorMiddleware ensuring that every
+// request has an id, generating one for the requests without a
+// RequestIDHeader.
+//
+// The id is available to the handlers through RequestIDFromContext, and is
+// sent back in the RequestIDHeader of the response, including the exception
+// ones, so that clients can report it along with errors.
+//
+// It must be before AccessLogMiddleware in the middlewares passed to
+// WrapProcessor, for the generated ids to be logged.
+func RequestIDProcessorMiddleware(name string, next TProcessorFunction) TProcessorFunction {
+	return WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			id, ok := RequestIDFromContext(ctx)
+			if !ok {
+				id = newRequestID()
+			}
+			ctx = WithRequestID(ctx, id)
+			if helper, ok := GetResponseHelper(ctx); ok {
+				helper.SetHeader(RequestIDHeader, id)
+			}
+			return next.Process(ctx, seqID, in, out)
+		},
+	}
+}
+
+func newRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
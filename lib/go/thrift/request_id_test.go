@@ -0,0 +1,102 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRequestIDClientMiddleware(t *testing.T) {
+	var sent []string
+	client := WrapClient(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			for _, key := range GetWriteHeaderList(ctx) {
+				if key == RequestIDHeader {
+					id, _ := GetHeader(ctx, key)
+					sent = append(sent, id)
+				}
+			}
+			return ResponseMeta{}, nil
+		},
+	}, RequestIDClientMiddleware)
+
+	ctx := context.Background()
+	client.Call(ctx, "method", nil, nil)
+	client.Call(ctx, "method", nil, nil)
+	if len(sent) != 2 || len(sent[0]) != 32 || sent[0] == sent[1] {
+		t.Errorf("expected 2 different generated ids, got %q", sent)
+	}
+
+	client.Call(WithRequestID(ctx, "explicit"), "method", nil, nil)
+	// The id of the request being handled by a server.
+	serverCtx := AddReadTHeaderToContext(ctx, THeaderMap{RequestIDHeader: "incoming"})
+	client.Call(serverCtx, "method", nil, nil)
+	if len(sent) != 4 || sent[2] != "explicit" || sent[3] != "incoming" {
+		t.Errorf("expected propagated ids, got %q", sent)
+	}
+}
+
+func TestRequestIDProcessorMiddleware(t *testing.T) {
+	var entries []AccessLogEntry
+	var handled []string
+	processor := WrapProcessor(&mockWrappableProcessor{
+		ProcessorFuncs: map[string]TProcessorFunction{
+			"method": WrappedTProcessorFunction{
+				Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+					id, _ := RequestIDFromContext(ctx)
+					handled = append(handled, id)
+					return true, nil
+				},
+			},
+		},
+	},
+		RequestIDProcessorMiddleware,
+		AccessLogMiddleware(AccessLogOptions{
+			Handler: func(ctx context.Context, entry AccessLogEntry) {
+				entries = append(entries, entry)
+			},
+		}),
+	)
+	proto := NewTHeaderProtocolConf(NewTMemoryBuffer(), nil)
+	ctx := SetResponseHelper(context.Background(), TResponseHelper{
+		THeaderResponseHelper: NewTHeaderResponseHelper(proto),
+	})
+	f := processor.ProcessorMap()["method"]
+
+	f.Process(AddReadTHeaderToContext(ctx, THeaderMap{RequestIDHeader: "incoming"}), 1, proto, proto)
+	if len(handled) != 1 || handled[0] != "incoming" {
+		t.Errorf("expected the incoming id, got %q", handled)
+	}
+	if id := proto.transport.writeHeaders[RequestIDHeader]; id != "incoming" {
+		t.Errorf("expected the id in the response headers, got %q", id)
+	}
+
+	f.Process(ctx, 2, proto, proto)
+	if len(handled) != 2 || len(handled[1]) != 32 {
+		t.Fatalf("expected a generated id, got %q", handled)
+	}
+	if id := proto.transport.writeHeaders[RequestIDHeader]; id != handled[1] {
+		t.Errorf("expected the generated id %q in the response headers, got %q", handled[1], id)
+	}
+	if len(entries) != 2 || entries[0].RequestID != "incoming" || entries[1].RequestID != handled[1] {
+		t.Errorf("expected the ids in the access logs, got %+v", entries)
+	}
+}
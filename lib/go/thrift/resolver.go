@@ -0,0 +1,224 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDNSSRVInterval is the interval between the lookups of a
+// DNSSRVResolver watching its records.
+const DefaultDNSSRVInterval = 30 * time.Second
+
+// ResolvedAddress is an address of an endpoint returned by a Resolver.
+type ResolvedAddress struct {
+	Address string
+
+	// Weight is the weight of the endpoint, see TEndpoint.Weight.
+	Weight int
+}
+
+// Resolver discovers the endpoints of a service, for a TBalancedClient, see
+// TBalancedClient.Watch.
+//
+// Resolvers for service registries (Consul, etcd, Kubernetes, etc.) can be
+// implemented outside of this package.
+type Resolver interface {
+	// Resolve returns the current addresses of the endpoints.
+	Resolve(ctx context.Context) ([]ResolvedAddress, error)
+
+	// Watch calls update with the addresses of the endpoints, once they're
+	// known and then every time they change, until ctx is done. It returns
+	// the error of ctx then, or the error ending the watch.
+	Watch(ctx context.Context, update func([]ResolvedAddress)) error
+}
+
+// NewStaticResolver returns a Resolver of a fixed list of addresses, with
+// the same weight.
+func NewStaticResolver(addresses ...string) Resolver {
+	resolved := make([]ResolvedAddress, 0, len(addresses))
+	for _, address := range addresses {
+		resolved = append(resolved, ResolvedAddress{
+			Address: address,
+			Weight:  1,
+		})
+	}
+	return tStaticResolver(resolved)
+}
+
+type tStaticResolver []ResolvedAddress
+
+func (r tStaticResolver) Resolve(ctx context.Context) ([]ResolvedAddress, error) {
+	return append([]ResolvedAddress(nil), r...), nil
+}
+
+func (r tStaticResolver) Watch(ctx context.Context, update func([]ResolvedAddress)) error {
+	addresses, _ := r.Resolve(ctx)
+	update(addresses)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// DNSSRVResolverOptions configures NewDNSSRVResolver.
+type DNSSRVResolverOptions struct {
+	// Service, Proto and Name are the parts of the SRV records name, see
+	// net.LookupSRV, e.g. "thrift", "tcp" and "users.example.com" for
+	// "_thrift._tcp.users.example.com".
+	Service string
+	Proto   string
+	Name    string
+
+	// Interval is the interval between the lookups while watching,
+	// DefaultDNSSRVInterval if 0.
+	Interval time.Duration
+
+	// Resolver is the DNS resolver, net.DefaultResolver if nil.
+	Resolver *net.Resolver
+}
+
+// NewDNSSRVResolver returns a Resolver of the targets of DNS SRV records.
+//
+// Only the targets with the lowest priority are returned, with the weights of
+// their records (at least 1). While watching, the records are looked up again
+// every Interval, and the failed lookups keep the previous addresses.
+func NewDNSSRVResolver(opts DNSSRVResolverOptions) Resolver {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultDNSSRVInterval
+	}
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+	return &tDNSSRVResolver{
+		opts:      opts,
+		lookupSRV: opts.Resolver.LookupSRV,
+	}
+}
+
+type tDNSSRVResolver struct {
+	opts      DNSSRVResolverOptions
+	lookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func (r *tDNSSRVResolver) Resolve(ctx context.Context) ([]ResolvedAddress, error) {
+	_, records, err := r.lookupSRV(ctx, r.opts.Service, r.opts.Proto, r.opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []ResolvedAddress
+	var priority uint16
+	for _, srv := range records {
+		if len(addresses) > 0 && srv.Priority > priority {
+			continue
+		}
+		if len(addresses) == 0 || srv.Priority < priority {
+			addresses = addresses[:0]
+			priority = srv.Priority
+		}
+		weight := int(srv.Weight)
+		if weight < 1 {
+			weight = 1
+		}
+		addresses = append(addresses, ResolvedAddress{
+			Address: net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))),
+			Weight:  weight,
+		})
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].Address < addresses[j].Address
+	})
+	return addresses, nil
+}
+
+func (r *tDNSSRVResolver) Watch(ctx context.Context, update func([]ResolvedAddress)) error {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	var last []ResolvedAddress
+	known := false
+	for {
+		if addresses, err := r.Resolve(ctx); err == nil && (!known || !sameAddresses(addresses, last)) {
+			update(addresses)
+			last, known = addresses, true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func sameAddresses(a, b []ResolvedAddress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Watch keeps the endpoints of c up to date with the addresses of resolver,
+// until ctx is done, creating the TClients of the new addresses with dial,
+// e.g. a TStandardClient over a pool of connections. The TClients of the
+// removed addresses are closed if they implement io.Closer, failing the
+// calls they're still sending.
+//
+// The addresses whose dial fails are skipped until the addresses change
+// again. Watch blocks, so it's usually run in its own goroutine, and returns
+// the error of resolver.Watch. The endpoints are left as they are then.
+func (c *TBalancedClient) Watch(ctx context.Context, resolver Resolver, dial func(address string) (TClient, error)) error {
+	clients := make(map[string]TClient)
+	return resolver.Watch(ctx, func(addresses []ResolvedAddress) {
+		endpoints := make([]TEndpoint, 0, len(addresses))
+		current := make(map[string]TClient, len(addresses))
+		for _, address := range addresses {
+			client, ok := clients[address.Address]
+			if !ok {
+				var err error
+				if client, err = dial(address.Address); err != nil {
+					continue
+				}
+			}
+			current[address.Address] = client
+			endpoints = append(endpoints, TEndpoint{
+				Address: address.Address,
+				Weight:  address.Weight,
+				Client:  client,
+			})
+		}
+		c.SetEndpoints(endpoints)
+		for address, client := range clients {
+			if _, ok := current[address]; !ok {
+				if closer, ok := client.(io.Closer); ok {
+					closer.Close()
+				}
+			}
+		}
+		clients = current
+	})
+}
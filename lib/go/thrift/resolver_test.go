@@ -0,0 +1,174 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// closingClient is a TClient recording whether it's closed.
+type closingClient struct {
+	WrappedTClient
+
+	closed bool
+}
+
+func (c *closingClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestDNSSRVResolver(t *testing.T) {
+	var mu sync.Mutex
+	records := []*net.SRV{
+		{Target: "b.example.com.", Port: 9090, Priority: 10, Weight: 5},
+		{Target: "backup.example.com.", Port: 9090, Priority: 20, Weight: 1},
+		{Target: "a.example.com.", Port: 9090, Priority: 10, Weight: 0},
+	}
+	var lookupErr error
+	r := NewDNSSRVResolver(DNSSRVResolverOptions{
+		Service:  "thrift",
+		Proto:    "tcp",
+		Name:     "users.example.com",
+		Interval: time.Millisecond,
+	}).(*tDNSSRVResolver)
+	r.lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if service != "thrift" || proto != "tcp" || name != "users.example.com" {
+			t.Errorf("unexpected lookup of %s %s %s", service, proto, name)
+		}
+		return "", records, lookupErr
+	}
+
+	addresses, err := r.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ResolvedAddress{
+		{Address: "a.example.com:9090", Weight: 1},
+		{Address: "b.example.com:9090", Weight: 5},
+	}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected %v, got %v", expected, addresses)
+	}
+
+	// Watch only reports the changes, and ignores the failed lookups.
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan []ResolvedAddress, 10)
+	done := make(chan error)
+	go func() {
+		done <- r.Watch(ctx, func(addresses []ResolvedAddress) {
+			updates <- addresses
+		})
+	}()
+	if addresses := <-updates; len(addresses) != 2 {
+		t.Errorf("unexpected first update %v", addresses)
+	}
+	mu.Lock()
+	lookupErr = errors.New("timeout")
+	mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	lookupErr = nil
+	records = records[:2]
+	mu.Unlock()
+	if addresses := <-updates; len(addresses) != 1 || addresses[0].Address != "b.example.com:9090" {
+		t.Errorf("unexpected update %v", addresses)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Watch to return the error of its context, got %v", err)
+	}
+	if len(updates) != 0 {
+		t.Errorf("expected no update without changes, got %v", <-updates)
+	}
+}
+
+func TestTBalancedClientWatch(t *testing.T) {
+	c := NewTBalancedClient(nil, TBalancedClientOptions{})
+	dialed := make(map[string]*closingClient)
+	updates := make(chan func([]ResolvedAddress))
+	resolver := &watchFuncResolver{updates: updates}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Watch(ctx, resolver, func(address string) (TClient, error) {
+		if address == "bad:1" {
+			return nil, errors.New("refused")
+		}
+		client := &closingClient{
+			WrappedTClient: WrappedTClient{
+				Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+					return ResponseMeta{}, nil
+				},
+			},
+		}
+		dialed[address] = client
+		return client, nil
+	})
+	update := <-updates
+	update([]ResolvedAddress{{Address: "a:1", Weight: 1}, {Address: "bad:1", Weight: 1}})
+	if _, err := c.Call(ctx, "m", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	a := dialed["a:1"]
+	update([]ResolvedAddress{{Address: "a:1", Weight: 1}, {Address: "b:1", Weight: 1}})
+	if dialed["a:1"] != a || dialed["b:1"] == nil {
+		t.Errorf("expected only the new address to be dialed, got %v", dialed)
+	}
+	update([]ResolvedAddress{{Address: "b:1", Weight: 1}})
+	if !a.closed || dialed["b:1"].closed {
+		t.Error("expected only the client of the removed address to be closed")
+	}
+	if len(c.endpoints) != 1 || c.endpoints[0].Address != "b:1" {
+		t.Errorf("unexpected endpoints %v", c.endpoints)
+	}
+}
+
+// watchFuncResolver passes the update function of Watch to the test.
+type watchFuncResolver struct {
+	updates chan func([]ResolvedAddress)
+}
+
+func (r *watchFuncResolver) Resolve(ctx context.Context) ([]ResolvedAddress, error) {
+	return nil, nil
+}
+
+func (r *watchFuncResolver) Watch(ctx context.Context, update func([]ResolvedAddress)) error {
+	r.updates <- update
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStaticResolver(t *testing.T) {
+	addresses, err := NewStaticResolver("a:1", "b:1").Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 2 || addresses[1] != (ResolvedAddress{Address: "b:1", Weight: 1}) {
+		t.Errorf("unexpected addresses %v", addresses)
+	}
+}
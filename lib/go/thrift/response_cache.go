@@ -0,0 +1,456 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultResponseCacheMaxEntries is the maximum number of responses kept by a
+// ResponseCache when neither MaxEntries nor MaxBytes are set.
+const DefaultResponseCacheMaxEntries = 1024
+
+// ResponseCacheOptions configures NewResponseCache.
+type ResponseCacheOptions struct {
+	// Methods are the TTLs of the cached responses, by method name as set
+	// in the processor map. Only the idempotent methods without side
+	// effects should be cached, the other ones are not.
+	Methods map[string]time.Duration
+
+	// MaxEntries and MaxBytes bound the number of responses and the total
+	// size of the responses and their keys, the least recently used ones
+	// being evicted. They're unbounded if <= 0, but at least one of them
+	// should be set, see DefaultResponseCacheMaxEntries.
+	MaxEntries int
+	MaxBytes   int64
+
+	// Vary returns a string added to the cache key of a request, for the
+	// methods whose response depends on more than their arguments, e.g. the
+	// authenticated client or a THeader.
+	//
+	// If nil, the key only depends on the method and arguments.
+	Vary func(ctx context.Context, method string) string
+}
+
+// ResponseCacheStats are the counters of a ResponseCache.
+type ResponseCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+
+	Entries int
+	Bytes   int64
+}
+
+// ResponseCache caches the serialized responses of idempotent methods, to
+// absorb read-heavy hot keys, see Middleware.
+type ResponseCache struct {
+	opts ResponseCacheOptions
+
+	mu      sync.Mutex
+	lru     *list.List // of *tCachedResponse, most recently used first
+	entries map[string]*list.Element
+	stats   ResponseCacheStats
+}
+
+type tCachedResponse struct {
+	key     string
+	result  []byte
+	expires time.Time
+}
+
+func (e *tCachedResponse) size() int64 {
+	return int64(len(e.key) + len(e.result))
+}
+
+// NewResponseCache returns a ResponseCache configured with opts.
+func NewResponseCache(opts ResponseCacheOptions) *ResponseCache {
+	if opts.MaxEntries <= 0 && opts.MaxBytes <= 0 {
+		opts.MaxEntries = DefaultResponseCacheMaxEntries
+	}
+	return &ResponseCache{
+		opts:    opts,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Middleware returns a ProcessorMiddleware replying to the requests of the
+// cached methods from the cache when possible.
+//
+// The cache key is a hash of the method name, the string returned by Vary,
+// and the arguments, in a canonical form where the fields of the structs are
+// ordered by id (the elements of maps and sets keep the order in which they
+// were sent). The arguments are read and buffered before being passed to the
+// processor function.
+//
+// Only the successful replies are cached, not the exceptions. The cached
+// replies are sent without the THeaders set by the handler.
+func (c *ResponseCache) Middleware() ProcessorMiddleware {
+	return func(name string, next TProcessorFunction) TProcessorFunction {
+		ttl, ok := c.opts.Methods[name]
+		if !ok || ttl <= 0 {
+			return next
+		}
+		return WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+				args := NewTMemoryBuffer()
+				argsProto := NewTBinaryProtocolConf(args, nil)
+				if err := copyValue(ctx, in, argsProto, STRUCT, true, DEFAULT_RECURSION_DEPTH); err != nil {
+					return false, WrapTException(err)
+				}
+				if err := in.ReadMessageEnd(ctx); err != nil {
+					return false, WrapTException(err)
+				}
+				key := c.key(ctx, name, args.Bytes())
+
+				if result, hit := c.get(key); hit {
+					if err := writeCachedReply(ctx, out, name, seqID, result); err != nil {
+						return false, WrapTException(err)
+					}
+					return true, nil
+				}
+
+				reply := NewTMemoryBuffer()
+				ok, err := next.Process(ctx, seqID, argsProto, &TDebugProtocol{
+					Delegate:    out,
+					Logger:      NopLogger,
+					DuplicateTo: NewTBinaryProtocolConf(reply, nil),
+				})
+				if err == nil {
+					if result, cacheable := cacheableResult(ctx, reply); cacheable {
+						c.put(key, result, ttl)
+					}
+				}
+				return ok, err
+			},
+		}
+	}
+}
+
+// Stats returns the counters of c.
+func (c *ResponseCache) Stats() ResponseCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// Purge removes all the cached responses, for example after a write
+// invalidating them.
+func (c *ResponseCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.stats.Bytes = 0
+}
+
+func (c *ResponseCache) key(ctx context.Context, name string, args []byte) string {
+	var vary string
+	if c.opts.Vary != nil {
+		vary = c.opts.Vary(ctx, name)
+	}
+	return requestKey(name, vary, args)
+}
+
+// requestKey returns the hash of a request of method name with the canonical
+// arguments args, and the string vary.
+func requestKey(name, vary string, args []byte) string {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(vary))
+	h.Write([]byte{0})
+	h.Write(args)
+	return string(h.Sum(nil))
+}
+
+func (c *ResponseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	entry := elem.Value.(*tCachedResponse)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.stats.Hits++
+	return entry.result, true
+}
+
+func (c *ResponseCache) put(key string, result []byte, ttl time.Duration) {
+	entry := &tCachedResponse{
+		key:     key,
+		result:  result,
+		expires: time.Now().Add(ttl),
+	}
+	if c.opts.MaxBytes > 0 && entry.size() > c.opts.MaxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.stats.Bytes += entry.size()
+	for (c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries) || (c.opts.MaxBytes > 0 && c.stats.Bytes > c.opts.MaxBytes) {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove removes elem, c.mu must be held.
+func (c *ResponseCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*tCachedResponse)
+	delete(c.entries, entry.key)
+	c.stats.Bytes -= entry.size()
+}
+
+// cacheableResult returns the result struct of the reply captured in buf, if
+// it's a successful REPLY.
+func cacheableResult(ctx context.Context, buf *TMemoryBuffer) ([]byte, bool) {
+	proto := NewTBinaryProtocolConf(buf, nil)
+	if _, typeID, _, err := proto.ReadMessageBegin(ctx); err != nil || typeID != REPLY {
+		return nil, false
+	}
+	result := append([]byte(nil), buf.Bytes()...)
+	// The result structs have the success as field 0 (none for void
+	// methods), and the declared exceptions as the other fields.
+	if _, err := proto.ReadStructBegin(ctx); err != nil {
+		return nil, false
+	}
+	_, fieldType, id, err := proto.ReadFieldBegin(ctx)
+	if err != nil || (fieldType != STOP && id != 0) {
+		return nil, false
+	}
+	return result, true
+}
+
+// writeCachedReply writes the REPLY of a request with a cached result struct.
+func writeCachedReply(ctx context.Context, out TProtocol, name string, seqID int32, result []byte) error {
+	if err := out.WriteMessageBegin(ctx, name, REPLY, seqID); err != nil {
+		return err
+	}
+	in := NewTBinaryProtocolConf(&TMemoryBuffer{Buffer: bytes.NewBuffer(result)}, nil)
+	if err := copyValue(ctx, in, out, STRUCT, false, DEFAULT_RECURSION_DEPTH); err != nil {
+		return err
+	}
+	if err := out.WriteMessageEnd(ctx); err != nil {
+		return err
+	}
+	return out.Flush(ctx)
+}
+
+// copyValue reads a value of type typeID from in and writes it to out,
+// with the fields of the structs ordered by id if sortFields is true, and
+// at most maxDepth levels of nesting.
+func copyValue(ctx context.Context, in, out TProtocol, typeID TType, sortFields bool, maxDepth int) error {
+	if maxDepth <= 0 {
+		return NewTProtocolExceptionWithType(DEPTH_LIMIT, errors.New("Depth limit exceeded"))
+	}
+	switch typeID {
+	case BOOL:
+		v, err := in.ReadBool(ctx)
+		if err != nil {
+			return err
+		}
+		return out.WriteBool(ctx, v)
+	case BYTE:
+		v, err := in.ReadByte(ctx)
+		if err != nil {
+			return err
+		}
+		return out.WriteByte(ctx, v)
+	case I16:
+		v, err := in.ReadI16(ctx)
+		if err != nil {
+			return err
+		}
+		return out.WriteI16(ctx, v)
+	case I32:
+		v, err := in.ReadI32(ctx)
+		if err != nil {
+			return err
+		}
+		return out.WriteI32(ctx, v)
+	case I64:
+		v, err := in.ReadI64(ctx)
+		if err != nil {
+			return err
+		}
+		return out.WriteI64(ctx, v)
+	case DOUBLE:
+		v, err := in.ReadDouble(ctx)
+		if err != nil {
+			return err
+		}
+		return out.WriteDouble(ctx, v)
+	case STRING:
+		v, err := in.ReadBinary(ctx)
+		if err != nil {
+			return err
+		}
+		return out.WriteBinary(ctx, v)
+	case STRUCT:
+		return copyStruct(ctx, in, out, sortFields, maxDepth)
+	case MAP:
+		keyType, valueType, size, err := in.ReadMapBegin(ctx)
+		if err != nil {
+			return err
+		}
+		if err := out.WriteMapBegin(ctx, keyType, valueType, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := copyValue(ctx, in, out, keyType, sortFields, maxDepth-1); err != nil {
+				return err
+			}
+			if err := copyValue(ctx, in, out, valueType, sortFields, maxDepth-1); err != nil {
+				return err
+			}
+		}
+		if err := in.ReadMapEnd(ctx); err != nil {
+			return err
+		}
+		return out.WriteMapEnd(ctx)
+	case SET:
+		elemType, size, err := in.ReadSetBegin(ctx)
+		if err != nil {
+			return err
+		}
+		if err := out.WriteSetBegin(ctx, elemType, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := copyValue(ctx, in, out, elemType, sortFields, maxDepth-1); err != nil {
+				return err
+			}
+		}
+		if err := in.ReadSetEnd(ctx); err != nil {
+			return err
+		}
+		return out.WriteSetEnd(ctx)
+	case LIST:
+		elemType, size, err := in.ReadListBegin(ctx)
+		if err != nil {
+			return err
+		}
+		if err := out.WriteListBegin(ctx, elemType, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := copyValue(ctx, in, out, elemType, sortFields, maxDepth-1); err != nil {
+				return err
+			}
+		}
+		if err := in.ReadListEnd(ctx); err != nil {
+			return err
+		}
+		return out.WriteListEnd(ctx)
+	default:
+		return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("Unknown data type %d", typeID))
+	}
+}
+
+// copyStruct copies a struct, see copyValue.
+func copyStruct(ctx context.Context, in, out TProtocol, sortFields bool, maxDepth int) error {
+	type field struct {
+		name   string
+		typeID TType
+		id     int16
+		value  *TMemoryBuffer
+	}
+	var fields []field
+	name, err := in.ReadStructBegin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := out.WriteStructBegin(ctx, name); err != nil {
+		return err
+	}
+	for {
+		fieldName, typeID, id, err := in.ReadFieldBegin(ctx)
+		if err != nil {
+			return err
+		}
+		if typeID == STOP {
+			break
+		}
+		if sortFields {
+			// Buffered, to be written once all the fields are read.
+			f := field{fieldName, typeID, id, NewTMemoryBuffer()}
+			if err := copyValue(ctx, in, NewTBinaryProtocolConf(f.value, nil), typeID, true, maxDepth-1); err != nil {
+				return err
+			}
+			fields = append(fields, f)
+		} else {
+			if err := out.WriteFieldBegin(ctx, fieldName, typeID, id); err != nil {
+				return err
+			}
+			if err := copyValue(ctx, in, out, typeID, false, maxDepth-1); err != nil {
+				return err
+			}
+			if err := out.WriteFieldEnd(ctx); err != nil {
+				return err
+			}
+		}
+		if err := in.ReadFieldEnd(ctx); err != nil {
+			return err
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].id < fields[j].id
+	})
+	for _, f := range fields {
+		if err := out.WriteFieldBegin(ctx, f.name, f.typeID, f.id); err != nil {
+			return err
+		}
+		if err := copyValue(ctx, NewTBinaryProtocolConf(f.value, nil), out, f.typeID, false, maxDepth-1); err != nil {
+			return err
+		}
+		if err := out.WriteFieldEnd(ctx); err != nil {
+			return err
+		}
+	}
+	if err := in.ReadStructEnd(ctx); err != nil {
+		return err
+	}
+	if err := out.WriteFieldStop(ctx); err != nil {
+		return err
+	}
+	return out.WriteStructEnd(ctx)
+}
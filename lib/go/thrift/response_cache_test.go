@@ -0,0 +1,163 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheMiddleware(t *testing.T) {
+	calls := make(map[string]int)
+	get := WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			value, err := readStringArgs(ctx, in)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			calls[value]++
+			if value == "fail" {
+				exc := NewTApplicationException(INTERNAL_ERROR, "failed")
+				writeApplicationException(ctx, out, "get", seqID, exc)
+				return true, exc
+			}
+			out.WriteMessageBegin(ctx, "get", REPLY, seqID)
+			out.WriteStructBegin(ctx, "result")
+			out.WriteFieldBegin(ctx, "success", STRING, 0)
+			out.WriteString(ctx, "v:"+value)
+			out.WriteFieldEnd(ctx)
+			out.WriteFieldStop(ctx)
+			out.WriteStructEnd(ctx)
+			out.WriteMessageEnd(ctx)
+			return true, WrapTException(out.Flush(ctx))
+		},
+	}
+	cache := NewResponseCache(ResponseCacheOptions{
+		Methods: map[string]time.Duration{
+			"get":   time.Minute,
+			"short": time.Nanosecond,
+		},
+	})
+	middleware := cache.Middleware()
+	call := func(method string, seqID int32, writeArgs func(ctx context.Context, proto TProtocol)) string {
+		t.Helper()
+		ctx := context.Background()
+		in := NewTBinaryProtocolConf(NewTMemoryBuffer(), nil)
+		writeArgs(ctx, in)
+		out := NewTBinaryProtocolConf(NewTMemoryBuffer(), nil)
+		if ok, _ := middleware(method, get).Process(ctx, seqID, in, out); !ok {
+			t.Fatalf("%s: expected the connection to be kept open", method)
+		}
+		_, typeID, replySeqID, err := out.ReadMessageBegin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replySeqID != seqID {
+			t.Errorf("expected reply seqid %d, got %d", seqID, replySeqID)
+		}
+		if typeID == EXCEPTION {
+			return "exception"
+		}
+		value, err := readStringArgs(ctx, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	args := func(value string) func(ctx context.Context, proto TProtocol) {
+		return func(ctx context.Context, proto TProtocol) {
+			writeStringArgs(ctx, proto, value)
+		}
+	}
+
+	for seqID := int32(1); seqID <= 2; seqID++ {
+		if value := call("get", seqID, args("a")); value != "v:a" {
+			t.Errorf("expected v:a, got %q", value)
+		}
+	}
+	if calls["a"] != 1 {
+		t.Errorf("expected the second call to be replied from the cache, got %d calls", calls["a"])
+	}
+
+	// The key doesn't depend on the order of the fields.
+	for i, order := range [][]int16{{1, 2}, {2, 1}} {
+		order := order
+		call("get", int32(i), func(ctx context.Context, proto TProtocol) {
+			proto.WriteStructBegin(ctx, "args")
+			for _, id := range order {
+				proto.WriteFieldBegin(ctx, "value", STRING, id)
+				proto.WriteString(ctx, "b")
+				proto.WriteFieldEnd(ctx)
+			}
+			proto.WriteFieldStop(ctx)
+			proto.WriteStructEnd(ctx)
+			proto.WriteMessageEnd(ctx)
+		})
+	}
+	if calls["b"] != 1 {
+		t.Errorf("expected the reordered arguments to hit the cache, got %d calls", calls["b"])
+	}
+
+	for seqID := int32(1); seqID <= 2; seqID++ {
+		if value := call("get", seqID, args("fail")); value != "exception" {
+			t.Errorf("expected an exception, got %q", value)
+		}
+		call("short", seqID, args("c"))
+		time.Sleep(time.Millisecond)
+	}
+	if calls["fail"] != 2 {
+		t.Errorf("expected the exceptions not to be cached, got %d calls", calls["fail"])
+	}
+	if calls["c"] != 2 {
+		t.Errorf("expected the expired response not to be used, got %d calls", calls["c"])
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 6 || stats.Entries != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	cache.Purge()
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("expected an empty cache after Purge, got %+v", stats)
+	}
+}
+
+func TestResponseCacheBounds(t *testing.T) {
+	cache := NewResponseCache(ResponseCacheOptions{MaxEntries: 2, MaxBytes: 10})
+	cache.put("a", []byte("1"), time.Minute)
+	cache.put("b", []byte("2"), time.Minute)
+	cache.get("a")
+	cache.put("c", []byte("3"), time.Minute)
+	if _, ok := cache.get("b"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	if _, ok := cache.get("a"); !ok {
+		t.Error("expected the recently used entry to be kept")
+	}
+	cache.put("d", []byte("12345678"), time.Minute)
+	if stats := cache.Stats(); stats.Entries != 1 || stats.Bytes != 9 || stats.Evictions != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	cache.put("e", []byte("too large to cache"), time.Minute)
+	if _, ok := cache.get("e"); ok {
+		t.Error("expected the entries larger than MaxBytes not to be cached")
+	}
+}
@@ -88,6 +88,22 @@ func GetResponseHelper(ctx context.Context) (helper TResponseHelper, ok bool) {
 	return
 }
 
+// SetResponseHeader sets a THeader header written back to the client with the
+// reply of the request being handled, for example to send hints like
+// cache-control or the shard that served the request.
+//
+// The headers set are only written with the reply of the current request. It
+// returns false, and the header is not sent, if ctx is not the context of a
+// request of a server using THeaderProtocol.
+func SetResponseHeader(ctx context.Context, key, value string) bool {
+	helper, _ := GetResponseHelper(ctx)
+	if helper.THeaderResponseHelper == nil || helper.proto == nil {
+		return false
+	}
+	helper.SetHeader(key, value)
+	return true
+}
+
 // SetResponseHelper injects TResponseHelper into the context object.
 func SetResponseHelper(ctx context.Context, helper TResponseHelper) context.Context {
 	return context.WithValue(ctx, responseHelperKey{}, helper)
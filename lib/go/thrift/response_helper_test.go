@@ -135,3 +135,26 @@ func TestTResponseHelperZeroValue(t *testing.T) {
 	helper.SetHeader("foo", "bar")
 	helper.ClearHeaders()
 }
+
+func TestSetResponseHeader(t *testing.T) {
+	if SetResponseHeader(context.Background(), "key", "value") {
+		t.Error("expected SetResponseHeader to fail without a response helper")
+	}
+	ctx := SetResponseHelper(context.Background(), TResponseHelper{
+		THeaderResponseHelper: NewTHeaderResponseHelper(NewTBinaryProtocolConf(NewTMemoryBuffer(), nil)),
+	})
+	if SetResponseHeader(ctx, "key", "value") {
+		t.Error("expected SetResponseHeader to fail without THeaderProtocol")
+	}
+
+	trans := NewTHeaderTransport(NewTMemoryBuffer())
+	ctx = SetResponseHelper(context.Background(), TResponseHelper{
+		THeaderResponseHelper: NewTHeaderResponseHelper(NewTHeaderProtocol(trans)),
+	})
+	if !SetResponseHeader(ctx, "key", "value") {
+		t.Error("expected SetResponseHeader to succeed with THeaderProtocol")
+	}
+	if trans.writeHeaders["key"] != "value" {
+		t.Errorf("expected the header to be set, got %v", trans.writeHeaders)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Default values of RetryPolicy.
+const (
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultRetryMaxBackoff = 5 * time.Second
+)
+
+// RetryPolicy configures the retries of RetryMiddleware.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a call, including
+	// the first one. The calls are not retried if it's 1 or less.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry, DefaultRetryBackoff if
+	// 0. It doubles for every following retry, up to MaxBackoff,
+	// DefaultRetryMaxBackoff if 0. The delays are randomized by up to half,
+	// so that the clients failing together don't retry together.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable reports whether a failed attempt of method can be retried.
+	// If nil, IsRetryableError is used.
+	Retryable func(method string, err error) bool
+
+	// Budget, if not nil, limits the retries to a fraction of the recent
+	// calls, shared with the other middlewares using it. The calls are not
+	// retried once it's exhausted.
+	Budget *RetryBudget
+
+	// OnRetry is called before every retry, for example to record metrics.
+	// attempt is the number of the failed attempt, starting at 1.
+	OnRetry func(ctx context.Context, method string, attempt int, err error)
+}
+
+// IsRetryableError reports whether err guarantees that the call failed before
+// being processed by the server, so that it can be retried even if its method
+// is not idempotent: ErrCircuitOpen, ErrNoReadyEndpoint, NOT_OPEN
+// TTransportExceptions and the TApplicationExceptions of reason
+// ExceptionReasonRateLimited.
+func IsRetryableError(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrNoReadyEndpoint) {
+		return true
+	}
+	var te TTransportException
+	if errors.As(err, &te) && te.TypeId() == NOT_OPEN {
+		return true
+	}
+	return ExceptionReason(err) == ExceptionReasonRateLimited
+}
+
+// RetryMiddleware returns a ClientMiddleware retrying the failed calls with
+// an exponential backoff, according to policy or to the Retry of the
+// CallOptions of the call if set.
+//
+// The servers can push back on the retries of the calls they rejected with
+// their RetryAfterHeader, see SetRetryAfter: the calls are then retried after
+// the delay they set instead of the backoff, whether their error is retryable
+// or not, or not retried.
+//
+// With a TBalancedClient, the retries are sent to different endpoints when
+// possible. The calls stop being retried when their context is done, and
+// fail with the error of their last attempt.
+func RetryMiddleware(policy RetryPolicy) ClientMiddleware {
+	return func(next TClient) TClient {
+		return WrappedTClient{
+			Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+				p := policy
+				if override := CallOptionsFromContext(ctx).Retry; override != nil {
+					p = *override
+				}
+				if p.Budget != nil {
+					ctx = p.Budget.deposit(ctx)
+				}
+				if p.MaxAttempts <= 1 {
+					return next.Call(ctx, method, args, result)
+				}
+				return retriedCall(ctx, next, p, method, args, result)
+			},
+		}
+	}
+}
+
+func retriedCall(ctx context.Context, next TClient, policy RetryPolicy, method string, args, result TStruct) (ResponseMeta, error) {
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = func(method string, err error) bool {
+			return IsRetryableError(err)
+		}
+	}
+	backoff, maxBackoff := policy.Backoff, policy.MaxBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRetryMaxBackoff
+	}
+
+	ctx = withBalancerAttempts(ctx)
+	for attempt := 1; ; attempt++ {
+		meta, err := next.Call(withCallAttempt(ctx, attempt), method, args, result)
+		if err == nil || attempt >= policy.MaxAttempts {
+			return meta, err
+		}
+		pushback, pushedBack := RetryAfter(meta.Headers)
+		if pushback < 0 || !pushedBack && !retryable(method, err) {
+			return meta, err
+		}
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if pushedBack {
+			delay = pushback
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+				// The call would time out before the server accepts it.
+				return meta, err
+			}
+		}
+		if policy.Budget != nil && !policy.Budget.withdraw() {
+			return meta, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return meta, err
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(ctx, method, attempt, err)
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+type callAttemptKey struct{}
+
+// CallAttemptFromContext returns the number of the attempt of the call made
+// with ctx, starting at 1: the retries of RetryMiddleware and the hedges of
+// HedgingMiddleware are numbered from 2.
+func CallAttemptFromContext(ctx context.Context) int {
+	if attempt, ok := ctx.Value(callAttemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+func withCallAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, callAttemptKey{}, attempt)
+}
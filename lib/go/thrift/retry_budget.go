@@ -0,0 +1,157 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Default values of RetryBudgetOptions.
+const (
+	DefaultRetryBudgetRatio        = 0.2
+	DefaultRetryBudgetMinPerSecond = 10
+	DefaultRetryBudgetWindow       = 10 * time.Second
+)
+
+// retryBudgetBuckets is the number of buckets of the rolling window of a
+// RetryBudget.
+const retryBudgetBuckets = 10
+
+// RetryBudgetOptions configures NewRetryBudget.
+type RetryBudgetOptions struct {
+	// Ratio is the maximum number of extra attempts per call, between 0
+	// and 1, DefaultRetryBudgetRatio if 0: with 0.2, the retries and the
+	// hedges add at most 20% of load to the servers.
+	Ratio float64
+
+	// MinPerSecond is the number of extra attempts allowed per second
+	// regardless of Ratio, so that the clients making few calls can still
+	// retry them, DefaultRetryBudgetMinPerSecond if 0.
+	MinPerSecond float64
+
+	// Window is the duration of the rolling window of calls the budget is
+	// computed on, DefaultRetryBudgetWindow if 0.
+	Window time.Duration
+}
+
+// RetryBudget limits the extra attempts of the calls, the retries of
+// RetryMiddleware and the hedges of HedgingMiddleware, to a fraction of the
+// recent calls, so that they can't amplify an outage into a retry storm.
+//
+// A RetryBudget is shared by the middlewares it's set in, see
+// RetryPolicy.Budget and HedgingOptions.RetryBudget, usually all the
+// middlewares of the calls to the same servers. Every call is counted once,
+// even when it goes through several of them.
+type RetryBudget struct {
+	opts RetryBudgetOptions
+	now  func() time.Time
+
+	mu      sync.Mutex
+	buckets [retryBudgetBuckets]tRetryBudgetBucket
+}
+
+type tRetryBudgetBucket struct {
+	// index is the index of the bucket in time, since the zero time.
+	index int64
+	calls int
+	extra int
+}
+
+// NewRetryBudget returns a RetryBudget configured with opts.
+func NewRetryBudget(opts RetryBudgetOptions) *RetryBudget {
+	if opts.Ratio <= 0 {
+		opts.Ratio = DefaultRetryBudgetRatio
+	}
+	if opts.MinPerSecond <= 0 {
+		opts.MinPerSecond = DefaultRetryBudgetMinPerSecond
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultRetryBudgetWindow
+	}
+	return &RetryBudget{
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// Available returns the number of extra attempts currently allowed.
+func (b *RetryBudget) Available() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.available(b.bucketIndex())
+}
+
+type retryBudgetCallKey struct{}
+
+// deposit counts the call of ctx, unless it was already counted by b, and
+// returns the context of its attempts.
+func (b *RetryBudget) deposit(ctx context.Context) context.Context {
+	if counted, _ := ctx.Value(retryBudgetCallKey{}).(*RetryBudget); counted == b {
+		return ctx
+	}
+	b.mu.Lock()
+	b.bucket(b.bucketIndex()).calls++
+	b.mu.Unlock()
+	return context.WithValue(ctx, retryBudgetCallKey{}, b)
+}
+
+// withdraw counts an extra attempt, and reports whether it's allowed.
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	index := b.bucketIndex()
+	if b.available(index) < 1 {
+		return false
+	}
+	b.bucket(index).extra++
+	return true
+}
+
+func (b *RetryBudget) bucketIndex() int64 {
+	return b.now().UnixNano() / int64(b.opts.Window/retryBudgetBuckets)
+}
+
+// bucket returns the bucket of index, reset if it was of an older index.
+// b.mu must be held.
+func (b *RetryBudget) bucket(index int64) *tRetryBudgetBucket {
+	bucket := &b.buckets[index%retryBudgetBuckets]
+	if bucket.index != index {
+		*bucket = tRetryBudgetBucket{index: index}
+	}
+	return bucket
+}
+
+// available returns the number of extra attempts allowed. b.mu must be held.
+func (b *RetryBudget) available(index int64) int {
+	var calls, extra int
+	for _, bucket := range b.buckets {
+		if index-bucket.index < retryBudgetBuckets {
+			calls += bucket.calls
+			extra += bucket.extra
+		}
+	}
+	allowed := b.opts.Ratio*float64(calls) + b.opts.MinPerSecond*b.opts.Window.Seconds()
+	if n := int(allowed) - extra; n > 0 {
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,115 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	now := time.Unix(1000, 0)
+	budget := NewRetryBudget(RetryBudgetOptions{
+		Ratio:        0.5,
+		MinPerSecond: 0.1,
+		Window:       10 * time.Second,
+	})
+	budget.now = func() time.Time { return now }
+	if n := budget.Available(); n != 1 {
+		t.Errorf("expected the minimum of 1 extra attempt, got %d", n)
+	}
+	for i := 0; i < 4; i++ {
+		budget.deposit(context.Background())
+	}
+	if n := budget.Available(); n != 3 {
+		t.Errorf("expected 3 extra attempts, got %d", n)
+	}
+	for i := 0; i < 3; i++ {
+		if !budget.withdraw() {
+			t.Fatalf("expected extra attempt %d to be allowed", i+1)
+		}
+	}
+	if budget.withdraw() {
+		t.Error("expected the budget to be exhausted")
+	}
+
+	// The calls and attempts expire with the window.
+	now = now.Add(11 * time.Second)
+	if n := budget.Available(); n != 1 {
+		t.Errorf("expected the minimum once the window passed, got %d", n)
+	}
+
+	// A call going through several middlewares is counted once.
+	ctx := budget.deposit(context.Background())
+	budget.deposit(ctx)
+	if n := budget.Available(); n != 1 {
+		t.Errorf("expected the call to be counted once, got %d extra attempts", n)
+	}
+}
+
+func TestRetryBudgetSharedByRetriesAndHedges(t *testing.T) {
+	budget := NewRetryBudget(RetryBudgetOptions{
+		Ratio:        0.1,
+		MinPerSecond: 0.1,
+		Window:       10 * time.Second,
+	})
+	var attempts int32
+	client := WrapClient(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			atomic.AddInt32(&attempts, 1)
+			select {
+			case <-time.After(20 * time.Millisecond):
+			case <-ctx.Done():
+			}
+			return ResponseMeta{}, NewTTransportException(NOT_OPEN, "unavailable")
+		},
+	}, RetryMiddleware(RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		Budget:      budget,
+	}), HedgingMiddleware(HedgingOptions{
+		Idempotent: func(method string) bool {
+			return true
+		},
+		Delay:       time.Millisecond,
+		RetryBudget: budget,
+	}))
+	call := func() int32 {
+		atomic.StoreInt32(&attempts, 0)
+		if _, err := client.Call(context.Background(), "get", nil, &hedgingResult{}); err == nil {
+			t.Fatal("expected the call to fail")
+		}
+		return atomic.LoadInt32(&attempts)
+	}
+
+	// The single extra attempt of the budget is spent by the hedge, so
+	// the call isn't retried.
+	if n := call(); n != 2 {
+		t.Errorf("expected a hedge and no retry, got %d attempts", n)
+	}
+	if n := call(); n != 1 {
+		t.Errorf("expected no extra attempt once the budget is exhausted, got %d attempts", n)
+	}
+	if n := budget.Available(); n != 0 {
+		t.Errorf("expected the budget to be exhausted, got %d extra attempts", n)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryMiddleware(t *testing.T) {
+	var attempts int
+	errs := []error{
+		ErrCircuitOpen,
+		NewTApplicationExceptionWithReason(INTERNAL_ERROR, ExceptionReasonRateLimited, "rate limited"),
+		nil,
+	}
+	var retried []int
+	client := WrapClient(WrappedTClient{
+		Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+			err := errs[attempts%len(errs)]
+			attempts++
+			return ResponseMeta{}, err
+		},
+	}, RetryMiddleware(RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		OnRetry: func(ctx context.Context, method string, attempt int, err error) {
+			retried = append(retried, attempt)
+		},
+	}))
+	ctx := context.Background()
+
+	if _, err := client.Call(ctx, "m", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || len(retried) != 2 || retried[1] != 2 {
+		t.Errorf("expected 3 attempts, got %d and retries %v", attempts, retried)
+	}
+
+	// Overridden by the CallOptions.
+	attempts = 0
+	noRetry := WithCallOptions(ctx, CallRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	if _, err := client.Call(noRetry, "m", nil, nil); !errors.Is(err, ErrCircuitOpen) || attempts != 1 {
+		t.Errorf("expected a single failed attempt, got %d: %v", attempts, err)
+	}
+
+	// The errors after which the call may have been processed are not
+	// retried by default.
+	attempts = 0
+	errs = []error{errors.New("connection reset")}
+	if _, err := client.Call(ctx, "m", nil, nil); err == nil || attempts != 1 {
+		t.Errorf("expected a single failed attempt, got %d: %v", attempts, err)
+	}
+}
+
+func TestRetryMiddlewareEndpoints(t *testing.T) {
+	called := make(map[string]int)
+	endpoint := func(address string, err error) TEndpoint {
+		return TEndpoint{
+			Address: address,
+			Client: WrappedTClient{
+				Wrapped: func(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+					called[address]++
+					return ResponseMeta{}, err
+				},
+			},
+		}
+	}
+	balanced := NewTBalancedClient([]TEndpoint{
+		endpoint("overloaded", NewTApplicationExceptionWithReason(INTERNAL_ERROR, ExceptionReasonRateLimited, "rate limited")),
+		endpoint("ready", nil),
+	}, TBalancedClientOptions{
+		Policy: LoadBalancingPolicyFunc(func(ctx context.Context, method string, endpoints []EndpointStatus) int {
+			return 0
+		}),
+	})
+	client := WrapClient(balanced, RetryMiddleware(RetryPolicy{
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+	}))
+	if _, err := client.Call(context.Background(), "m", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if called["overloaded"] != 1 || called["ready"] != 1 {
+		t.Errorf("expected the retry to go to the other endpoint, got %v", called)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+)
+
+// TSeqIDMismatchPolicy is how a client handles a reply whose seqid is not the
+// one it expects next: the seqid of the call of a TStandardClient, or of the
+// oldest call of a TPipelinedClient still waiting for its reply, as the
+// servers reply in order by default.
+//
+// The zero value is the default of the client, SeqIDMismatchFail for
+// TStandardClient and SeqIDMismatchDeliver for TPipelinedClient.
+type TSeqIDMismatchPolicy int
+
+const (
+	// SeqIDMismatchClose fails the calls with a *TSeqIDMismatchError and
+	// closes the connection, as the following replies can't be trusted.
+	SeqIDMismatchClose TSeqIDMismatchPolicy = iota + 1
+
+	// SeqIDMismatchSkip discards the reply and reads the next one, e.g. to
+	// ignore the late replies of the calls given up on. The call of a
+	// TPipelinedClient the discarded reply was for, if any, fails with a
+	// *TSeqIDMismatchError.
+	SeqIDMismatchSkip
+
+	// SeqIDMismatchDeliver delivers the reply to the call of a
+	// TPipelinedClient waiting for it, so that the replies can be received
+	// in any order, and discards the replies no call is waiting for.
+	// TStandardClient only has a single call waiting, and handles it as
+	// SeqIDMismatchSkip.
+	SeqIDMismatchDeliver
+
+	// SeqIDMismatchFail fails the call with a BAD_SEQUENCE_ID
+	// TApplicationException, leaving the connection open, after checking the
+	// method name of the reply. TPipelinedClient, which can't fail a call
+	// without losing track of the following replies, handles it as
+	// SeqIDMismatchClose.
+	SeqIDMismatchFail
+)
+
+func (p TSeqIDMismatchPolicy) String() string {
+	switch p {
+	case SeqIDMismatchClose:
+		return "close"
+	case SeqIDMismatchSkip:
+		return "skip"
+	case SeqIDMismatchDeliver:
+		return "deliver"
+	case SeqIDMismatchFail:
+		return "fail"
+	default:
+		return "default"
+	}
+}
+
+// orDefault returns p, or def if p is the zero value.
+func (p TSeqIDMismatchPolicy) orDefault(def TSeqIDMismatchPolicy) TSeqIDMismatchPolicy {
+	if p == 0 {
+		return def
+	}
+	return p
+}
+
+// TSeqIDMismatchError is the error of the calls failed by a reply with an
+// unexpected seqid under the SeqIDMismatchClose and SeqIDMismatchSkip
+// policies, see TSeqIDMismatchPolicy.
+//
+// It's a BAD_SEQUENCE_ID TApplicationException.
+type TSeqIDMismatchError struct {
+	// Method is the method of the call expecting the reply.
+	Method string
+
+	// Expected is the seqid expected, 0 if no reply was.
+	Expected int32
+
+	// Received is the seqid of the reply.
+	Received int32
+}
+
+func (e *TSeqIDMismatchError) Error() string {
+	return fmt.Sprintf("%s: out of order sequence response: expected seqid %d, got %d", e.Method, e.Expected, e.Received)
+}
+
+func (e *TSeqIDMismatchError) TExceptionType() TExceptionType {
+	return TExceptionTypeApplication
+}
+
+func (e *TSeqIDMismatchError) TypeId() int32 {
+	return BAD_SEQUENCE_ID
+}
+
+// Read reads a TApplicationException, the seqids not being part of its
+// encoding.
+func (e *TSeqIDMismatchError) Read(ctx context.Context, iprot TProtocol) error {
+	var exc tApplicationException
+	return exc.Read(ctx, iprot)
+}
+
+// Write writes e as a BAD_SEQUENCE_ID TApplicationException.
+func (e *TSeqIDMismatchError) Write(ctx context.Context, oprot TProtocol) error {
+	return NewTApplicationException(BAD_SEQUENCE_ID, e.Error()).Write(ctx, oprot)
+}
+
+// closeOnSeqIDMismatch closes the connection of iprot, whose reply with an
+// unexpected seqid is left unread, and so is whatever follows it.
+func closeOnSeqIDMismatch(iprot TProtocol, method string, expected, received int32) error {
+	iprot.Transport().Close()
+	return &TSeqIDMismatchError{
+		Method:   method,
+		Expected: expected,
+		Received: received,
+	}
+}
+
+var _ TApplicationException = (*TSeqIDMismatchError)(nil)
@@ -0,0 +1,152 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestStandardClientSeqIDMismatch(t *testing.T) {
+	for _, c := range []struct {
+		label       string
+		policy      TSeqIDMismatchPolicy
+		staleMethod string
+		// The expected error: nil, a TApplicationException left as it was
+		// before the policies, or a *TSeqIDMismatchError.
+		expected error
+	}{
+		{"default", 0, "echo", NewTApplicationException(BAD_SEQUENCE_ID, "echo: out of order sequence response")},
+		{"default-wrong-method", 0, "other", NewTApplicationException(WRONG_METHOD_NAME, "echo: wrong method name")},
+		{"fail", SeqIDMismatchFail, "echo", NewTApplicationException(BAD_SEQUENCE_ID, "echo: out of order sequence response")},
+		{"close", SeqIDMismatchClose, "echo", &TSeqIDMismatchError{Method: "echo", Expected: 1, Received: 0}},
+		{"skip", SeqIDMismatchSkip, "echo", nil},
+		{"deliver", SeqIDMismatchDeliver, "echo", nil},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer serverConn.Close()
+			sock := NewTSocketFromConnConf(clientConn, nil)
+			defer sock.Close()
+			proto := NewTBinaryProtocolConf(sock, nil)
+			client := NewTStandardClient(proto, proto)
+			client.SetSeqIDMismatchPolicy(c.policy)
+
+			// The server replies to a previous call first.
+			go func() {
+				ctx := context.Background()
+				server := NewTBinaryProtocolConf(NewTSocketFromConnConf(serverConn, nil), nil)
+				method, _, seqID, err := server.ReadMessageBegin(ctx)
+				if err != nil {
+					return
+				}
+				value, err := readStringArgs(ctx, server)
+				if err != nil {
+					return
+				}
+				writePipelinedReply(server, pipelinedRequest{c.staleMethod, seqID - 1, "stale"})
+				writePipelinedReply(server, pipelinedRequest{method, seqID, value})
+			}()
+			var result pipelinedString
+			_, err := client.Call(context.Background(), "echo", &pipelinedString{"value"}, &result)
+			switch expected := c.expected.(type) {
+			case nil:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Value != "value" {
+					t.Errorf("expected %q, got %q", "value", result.Value)
+				}
+			case *TSeqIDMismatchError:
+				var mismatch *TSeqIDMismatchError
+				if !errors.As(err, &mismatch) || *mismatch != *expected {
+					t.Fatalf("expected %v, got %v", expected, err)
+				}
+				if exc, ok := err.(TApplicationException); !ok || exc.TypeId() != BAD_SEQUENCE_ID {
+					t.Errorf("expected a BAD_SEQUENCE_ID TApplicationException, got %v", err)
+				}
+				if sock.IsOpen() {
+					t.Error("expected the connection to be closed")
+				}
+			case TApplicationException:
+				exc, ok := err.(*tApplicationException)
+				if !ok || exc.TypeId() != expected.TypeId() || exc.Error() != expected.Error() {
+					t.Fatalf("expected %v, got %#v", expected, err)
+				}
+				if !sock.IsOpen() {
+					t.Error("expected the connection to stay open")
+				}
+			}
+		})
+	}
+}
+
+func TestPipelinedClientSeqIDMismatch(t *testing.T) {
+	// startCalls starts two calls in order, and returns their requests and
+	// the channels receiving their errors.
+	startCalls := func(t *testing.T, client *TPipelinedClient, server TProtocol) ([]pipelinedRequest, []chan error) {
+		var requests []pipelinedRequest
+		var errs []chan error
+		for _, value := range []string{"first", "second"} {
+			done := make(chan error, 1)
+			go func(value string) {
+				var result pipelinedString
+				_, err := client.Call(context.Background(), "echo", &pipelinedString{value}, &result)
+				if err == nil && result.Value != value {
+					t.Errorf("expected %q, got %q", value, result.Value)
+				}
+				done <- err
+			}(value)
+			requests = append(requests, readPipelinedRequest(t, server))
+			errs = append(errs, done)
+		}
+		return requests, errs
+	}
+
+	t.Run("close", func(t *testing.T) {
+		client, server := startPipelinedServer(t)
+		client.SetSeqIDMismatchPolicy(SeqIDMismatchClose)
+		requests, errs := startCalls(t, client, server)
+		writePipelinedReply(server, requests[1])
+		for _, done := range errs {
+			var mismatch *TSeqIDMismatchError
+			if err := <-done; !errors.As(err, &mismatch) || mismatch.Expected != requests[0].seqID || mismatch.Received != requests[1].seqID {
+				t.Errorf("expected a *TSeqIDMismatchError, got %v", err)
+			}
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		client, server := startPipelinedServer(t)
+		client.SetSeqIDMismatchPolicy(SeqIDMismatchSkip)
+		requests, errs := startCalls(t, client, server)
+		writePipelinedReply(server, requests[1])
+		var mismatch *TSeqIDMismatchError
+		if err := <-errs[1]; !errors.As(err, &mismatch) {
+			t.Errorf("expected a *TSeqIDMismatchError, got %v", err)
+		}
+		writePipelinedReply(server, requests[0])
+		if err := <-errs[0]; err != nil {
+			t.Error(err)
+		}
+	})
+}
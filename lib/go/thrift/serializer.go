@@ -20,13 +20,25 @@
 package thrift
 
 import (
+	"bufio"
 	"context"
+	"fmt"
+	"io"
 	"sync"
 )
 
 type TSerializer struct {
 	Transport *TMemoryBuffer
 	Protocol  TProtocol
+
+	// ProtocolFactory creates the protocol of WriteTo. If nil, the factory
+	// of Protocol is used, if it's one of the protocols of this package.
+	ProtocolFactory TProtocolFactory
+
+	// The writer, buffer and protocol of WriteTo, reused between calls.
+	out      *tCountingWriter
+	outBuf   *bufio.Writer
+	outProto TProtocol
 }
 
 type TStruct interface {
@@ -80,6 +92,70 @@ func (t *TSerializer) Write(ctx context.Context, msg TStruct) (b []byte, err err
 	return
 }
 
+// WriteTo streams msg to w through a buffer, instead of materializing it
+// whole in Transport like Write, for the very large structs. It returns the
+// number of bytes written to w.
+//
+// The protocol is created with ProtocolFactory, see its documentation.
+func (t *TSerializer) WriteTo(ctx context.Context, w io.Writer, msg TStruct) (int64, error) {
+	if t.out == nil {
+		t.out = &tCountingWriter{}
+		t.outBuf = bufio.NewWriter(t.out)
+	}
+	if t.outProto == nil {
+		factory := t.ProtocolFactory
+		if factory == nil {
+			if factory = protocolFactoryOf(t.Protocol); factory == nil {
+				return 0, fmt.Errorf("thrift: TSerializer.WriteTo requires a ProtocolFactory for %T", t.Protocol)
+			}
+		}
+		t.outProto = factory.GetProtocol(&StreamTransport{Writer: t.outBuf})
+	}
+	t.out.w, t.out.n = w, 0
+	defer func() {
+		t.out.w = nil
+	}()
+	t.outBuf.Reset(t.out)
+	err := msg.Write(ctx, t.outProto)
+	if err == nil {
+		err = t.outProto.Flush(ctx)
+	}
+	if err != nil {
+		// The state of the protocol is unknown after a failure.
+		t.outProto = nil
+	}
+	return t.out.n, err
+}
+
+// protocolFactoryOf returns the factory of the protocols like p, nil if p is
+// not a protocol of this package.
+func protocolFactoryOf(p TProtocol) TProtocolFactory {
+	switch p := p.(type) {
+	case *TBinaryProtocol:
+		return NewTBinaryProtocolFactoryConf(p.cfg)
+	case *TCompactProtocol:
+		return NewTCompactProtocolFactoryConf(p.cfg)
+	case *TJSONProtocol:
+		return NewTJSONProtocolFactory()
+	case *TSimpleJSONProtocol:
+		return NewTSimpleJSONProtocolFactoryConf(p.cfg)
+	default:
+		return nil
+	}
+}
+
+// tCountingWriter counts the bytes written to w.
+type tCountingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *tCountingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // TSerializerPool is the thread-safe version of TSerializer, it uses resource
 // pool of TSerializer under the hood.
 //
@@ -115,8 +191,9 @@ func NewTSerializerPoolSizeFactory(size int, factory TProtocolFactory) *TSeriali
 				protocol := factory.GetProtocol(transport)
 
 				return &TSerializer{
-					Transport: transport,
-					Protocol:  protocol,
+					Transport:       transport,
+					Protocol:        protocol,
+					ProtocolFactory: factory,
 				}
 			},
 		},
@@ -134,3 +211,10 @@ func (t *TSerializerPool) Write(ctx context.Context, msg TStruct) ([]byte, error
 	defer t.pool.Put(s)
 	return s.Write(ctx, msg)
 }
+
+// WriteTo streams msg to w with a pooled TSerializer, see TSerializer.WriteTo.
+func (t *TSerializerPool) WriteTo(ctx context.Context, w io.Writer, msg TStruct) (int64, error) {
+	s := t.pool.Get().(*TSerializer)
+	defer t.pool.Put(s)
+	return s.WriteTo(ctx, w, msg)
+}
@@ -20,9 +20,12 @@
 package thrift
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -281,6 +284,157 @@ func TestSerializerPoolAsync(t *testing.T) {
 	wg.Wait()
 }
 
+// tMaxWriteWriter records the size of the largest write.
+type tMaxWriteWriter struct {
+	bytes.Buffer
+	max int
+}
+
+func (w *tMaxWriteWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		w.max = len(p)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestSerializerWriteTo(t *testing.T) {
+	ctx := context.Background()
+	msg := &MyTestStruct{St: "large"}
+	for i := 0; i < 10000; i++ {
+		msg.StringList = append(msg.StringList, fmt.Sprintf("element %d", i))
+	}
+	for name, pf := range map[string]TProtocolFactory{
+		"Binary":  NewTBinaryProtocolFactoryConf(nil),
+		"Compact": NewTCompactProtocolFactoryConf(nil),
+		"JSON":    NewTJSONProtocolFactory(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := plainSerializer(pf).(*TSerializer)
+			expected, err := s.Write(ctx, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool := NewTSerializerPoolSizeFactory(1024, pf)
+			for i := 0; i < 2; i++ {
+				var w tMaxWriteWriter
+				n, err := s.WriteTo(ctx, &w, msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != int64(len(expected)) || !bytes.Equal(w.Bytes(), expected) {
+					t.Errorf("expected the %d bytes of Write, got %d bytes, n=%d", len(expected), w.Len(), n)
+				}
+				if w.max > 4096 {
+					t.Errorf("expected buffered writes, got one of %d bytes", w.max)
+				}
+
+				w.Reset()
+				if _, err := pool.WriteTo(ctx, &w, msg); err != nil || !bytes.Equal(w.Bytes(), expected) {
+					t.Errorf("unexpected output of the pool, error %v", err)
+				}
+			}
+		})
+	}
+
+	failing := &TSerializer{Transport: NewTMemoryBuffer(), ProtocolFactory: NewTBinaryProtocolFactoryConf(nil)}
+	if _, err := failing.WriteTo(ctx, errorWriter{}, msg); err == nil {
+		t.Error("expected the error of the writer")
+	}
+	var buf bytes.Buffer
+	if _, err := failing.WriteTo(ctx, &buf, &MyTestStruct{St: "after failure"}); err != nil {
+		t.Error(err)
+	}
+	var m MyTestStruct
+	if err := NewTDeserializer().Read(ctx, &m, buf.Bytes()); err != nil || m.St != "after failure" {
+		t.Errorf("unexpected struct after a failure %v, error %v", m, err)
+	}
+
+	unknown := &TSerializer{Transport: NewTMemoryBuffer()}
+	unknown.Protocol = NewTDebugProtocolFactoryWithLogger(NewTBinaryProtocolFactoryConf(nil), "", NopLogger).GetProtocol(unknown.Transport)
+	if _, err := unknown.WriteTo(ctx, &buf, msg); err == nil {
+		t.Error("expected an error without a ProtocolFactory")
+	}
+}
+
+// tPlainReader hides the io.ByteReader of its Reader.
+type tPlainReader struct {
+	io.Reader
+}
+
+func TestDeserializerReadFrom(t *testing.T) {
+	ctx := context.Background()
+	first := &MyTestStruct{St: "first", StringList: []string{"a", "b"}}
+	second := &MyTestStruct{St: "second", Int64: 42}
+	for name, newFactory := range map[string]func(conf *TConfiguration) TProtocolFactory{
+		"Binary": func(conf *TConfiguration) TProtocolFactory {
+			return NewTBinaryProtocolFactoryConf(conf)
+		},
+		"Compact": func(conf *TConfiguration) TProtocolFactory {
+			return NewTCompactProtocolFactoryConf(conf)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pf := newFactory(nil)
+			s := NewTSerializerPoolSizeFactory(1024, pf)
+			var stream bytes.Buffer
+			n1, err := s.WriteTo(ctx, &stream, first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n2, err := s.WriteTo(ctx, &stream, second)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Consecutive structs are read from an io.ByteReader.
+			d := plainDeserializer(pf).(*TDeserializer)
+			r := bufio.NewReader(bytes.NewReader(stream.Bytes()))
+			for _, c := range []struct {
+				expected *MyTestStruct
+				n        int64
+			}{
+				{first, n1},
+				{second, n2},
+			} {
+				var m MyTestStruct
+				n, err := d.ReadFrom(ctx, r, &m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != c.n || !Equal(&m, c.expected) {
+					t.Errorf("expected %v of %d bytes, got %v of %d bytes", c.expected, c.n, &m, n)
+				}
+			}
+
+			var m MyTestStruct
+			if _, err := NewTDeserializerPoolSizeFactory(1024, pf).ReadFrom(ctx, tPlainReader{bytes.NewReader(stream.Bytes())}, &m); err != nil || m.St != "first" {
+				t.Errorf("unexpected struct %v, error %v", &m, err)
+			}
+
+			// The input is limited to the MaxMessageSize of the protocol.
+			limited := NewTDeserializer()
+			limited.ProtocolFactory = newFactory(&TConfiguration{MaxMessageSize: int32(n1 - 1)})
+			_, err = limited.ReadFrom(ctx, bytes.NewReader(stream.Bytes()), &m)
+			var pe TProtocolException
+			if !errors.As(err, &pe) || pe.TypeId() != SIZE_LIMIT {
+				t.Errorf("expected a SIZE_LIMIT error, got %v", err)
+			}
+		})
+	}
+
+	// The sizes read are checked before allocating.
+	var m MyTestStruct
+	if _, err := NewTDeserializer().ReadFrom(ctx, bytes.NewReader([]byte{0x0b, 0x00, 0x07, 0x7f, 0xff, 0xff, 0xff}), &m); err == nil {
+		t.Error("expected an error for a string of 2GB")
+	}
+}
+
+type errorWriter struct{}
+
+func (errorWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
 func BenchmarkSerializer(b *testing.B) {
 	sharedSerializer := NewTSerializer()
 	poolSerializer := NewTSerializerPool(NewTSerializer)
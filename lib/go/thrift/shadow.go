@@ -0,0 +1,147 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ShadowOptions configures ShadowMiddleware.
+type ShadowOptions struct {
+	// Processor is the secondary processor the sampled requests are
+	// mirrored to, usually a new version of the service being validated.
+	// Its replies are discarded.
+	//
+	// It gets the method names as set in the processor map of the wrapped
+	// processor. To mirror requests to a remote endpoint, use the generated
+	// processor of the service with a generated client as the handler.
+	Processor TProcessor
+
+	// Sampler decides whether a request is mirrored, see ShadowSampleRate.
+	//
+	// If nil, all the requests are mirrored.
+	Sampler func(ctx context.Context, method string) bool
+
+	// MaxInFlight is the maximum number of mirrored requests processed at
+	// the same time, so that a slow secondary doesn't accumulate
+	// goroutines. The requests over it are not mirrored.
+	//
+	// If <= 0, there's no limit.
+	MaxInFlight int
+
+	// Timeout is the timeout of the mirrored requests, 0 for none.
+	//
+	// The mirrored requests are not canceled with the original ones, but
+	// keep the values of their context, e.g. the THeaders.
+	Timeout time.Duration
+
+	// OnError is called with the errors of the mirrored requests, for
+	// example to record metrics.
+	OnError func(ctx context.Context, method string, err error)
+}
+
+// ShadowSampleRate returns a sampler for ShadowOptions mirroring the given
+// fraction of the requests, between 0 and 1.
+func ShadowSampleRate(rate float64) func(ctx context.Context, method string) bool {
+	return func(ctx context.Context, method string) bool {
+		return rand.Float64() < rate
+	}
+}
+
+// ShadowMiddleware returns a ProcessorMiddleware mirroring a sample of the
+// requests to a secondary processor, asynchronously once the original
+// request is processed, for validating new versions of a service against
+// production traffic.
+//
+// The arguments are captured while the original processor function reads
+// them, so the fields it doesn't know are not mirrored. The requests whose
+// arguments failed to be read are not mirrored either.
+func ShadowMiddleware(opts ShadowOptions) ProcessorMiddleware {
+	var inFlight int64
+	return func(name string, next TProcessorFunction) TProcessorFunction {
+		return WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+				if opts.Processor == nil || (opts.Sampler != nil && !opts.Sampler(ctx, name)) {
+					return next.Process(ctx, seqID, in, out)
+				}
+				buf := NewTMemoryBuffer()
+				ok, err := next.Process(ctx, seqID, &TDebugProtocol{
+					Delegate:    in,
+					Logger:      NopLogger,
+					DuplicateTo: NewTBinaryProtocolConf(buf, nil),
+				}, out)
+				if errors.As(err, new(TTransportException)) || errors.As(err, new(TProtocolException)) {
+					return ok, err
+				}
+				if opts.MaxInFlight > 0 && atomic.AddInt64(&inFlight, 1) > int64(opts.MaxInFlight) {
+					atomic.AddInt64(&inFlight, -1)
+					return ok, err
+				}
+				go func() {
+					if opts.MaxInFlight > 0 {
+						defer atomic.AddInt64(&inFlight, -1)
+					}
+					shadowRequest(tDetachedContext{ctx}, opts, name, seqID, buf)
+				}()
+				return ok, err
+			},
+		}
+	}
+}
+
+// shadowRequest processes the request of the arguments in buf with the
+// secondary processor of opts.
+func shadowRequest(ctx context.Context, opts ShadowOptions, name string, seqID int32, buf *TMemoryBuffer) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	in := NewStoredMessageProtocol(NewTBinaryProtocolConf(buf, nil), name, CALL, seqID)
+	out := NewTBinaryProtocolConf(NewTMemoryBuffer(), nil)
+	_, err := opts.Processor.Process(ctx, in, out)
+	if err != nil && opts.OnError != nil {
+		opts.OnError(ctx, name, err)
+	}
+}
+
+// tDetachedContext keeps the values of a context, without its deadline and
+// cancellation.
+type tDetachedContext struct {
+	context.Context
+}
+
+func (tDetachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (tDetachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (tDetachedContext) Err() error {
+	return nil
+}
+
+var _ context.Context = tDetachedContext{}
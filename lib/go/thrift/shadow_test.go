@@ -0,0 +1,146 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// readStringArgs reads args with a single string field.
+func readStringArgs(ctx context.Context, in TProtocol) (string, error) {
+	var value string
+	if _, err := in.ReadStructBegin(ctx); err != nil {
+		return "", err
+	}
+	for {
+		_, typeID, _, err := in.ReadFieldBegin(ctx)
+		if err != nil {
+			return "", err
+		}
+		if typeID == STOP {
+			break
+		}
+		if value, err = in.ReadString(ctx); err != nil {
+			return "", err
+		}
+		in.ReadFieldEnd(ctx)
+	}
+	if err := in.ReadStructEnd(ctx); err != nil {
+		return "", err
+	}
+	return value, in.ReadMessageEnd(ctx)
+}
+
+func writeStringArgs(ctx context.Context, out TProtocol, value string) {
+	out.WriteStructBegin(ctx, "args")
+	out.WriteFieldBegin(ctx, "value", STRING, 1)
+	out.WriteString(ctx, value)
+	out.WriteFieldEnd(ctx)
+	out.WriteFieldStop(ctx)
+	out.WriteStructEnd(ctx)
+	out.WriteMessageEnd(ctx)
+}
+
+func TestShadowMiddleware(t *testing.T) {
+	type mirrored struct {
+		method string
+		value  string
+	}
+	type ctxKey struct{}
+	shadowed := make(chan mirrored, 10)
+	errs := make(chan error, 10)
+	shadow := &mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			ctx := context.Background()
+			name, _, _, err := in.ReadMessageBegin(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			value, err := readStringArgs(ctx, in)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			shadowed <- mirrored{method: name, value: value}
+			if value == "fail" {
+				return true, WrapTException(errors.New("shadow failure"))
+			}
+			return true, nil
+		},
+	}
+	middleware := ShadowMiddleware(ShadowOptions{
+		Processor: shadow,
+		Sampler: func(ctx context.Context, method string) bool {
+			return ctx.Value(ctxKey{}) != "skip"
+		},
+		OnError: func(ctx context.Context, method string, err error) {
+			errs <- err
+		},
+	})
+	f := middleware("echo", WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			if _, err := readStringArgs(ctx, in); err != nil {
+				return false, WrapTException(err)
+			}
+			return true, nil
+		},
+	})
+
+	call := func(ctx context.Context, value string) {
+		t.Helper()
+		proto := NewTBinaryProtocolConf(NewTMemoryBuffer(), nil)
+		writeStringArgs(ctx, proto, value)
+		if ok, err := f.Process(ctx, 1, proto, proto); !ok || err != nil {
+			t.Fatalf("unexpected result %v, %v", ok, err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	call(ctx, "hello")
+	cancel()
+	select {
+	case m := <-shadowed:
+		if m.method != "echo" || m.value != "hello" {
+			t.Errorf("unexpected mirrored request %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to be mirrored")
+	}
+
+	call(context.WithValue(context.Background(), ctxKey{}, "skip"), "skipped")
+	call(context.Background(), "fail")
+	select {
+	case m := <-shadowed:
+		if m.value != "fail" {
+			t.Errorf("expected the request not sampled not to be mirrored, got %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to be mirrored")
+	}
+	select {
+	case err := <-errs:
+		if err.Error() != "shadow failure" {
+			t.Errorf("unexpected shadow error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected OnError to be called")
+	}
+}
@@ -104,6 +104,8 @@ type TSimpleJSONProtocol struct {
 
 	writer *bufio.Writer
 	reader *bufio.Reader
+
+	limits tFieldSizeLimiter
 }
 
 // Deprecated: Use NewTSimpleJSONProtocolConf instead.:
@@ -347,10 +349,11 @@ func (p *TSimpleJSONProtocol) WriteBinary(ctx context.Context, v []byte) error {
 // Reading methods.
 func (p *TSimpleJSONProtocol) ReadMessageBegin(ctx context.Context) (name string, typeId TMessageType, seqId int32, err error) {
 	p.resetContextStack() // THRIFT-3735
+	p.limits.reset()
 	if isNull, err := p.ParseListBegin(); isNull || err != nil {
 		return name, typeId, seqId, err
 	}
-	if name, err = p.ReadString(ctx); err != nil {
+	if name, err = p.readString(); err != nil {
 		return name, typeId, seqId, err
 	}
 	bTypeId, err := p.ReadByte(ctx)
@@ -368,12 +371,18 @@ func (p *TSimpleJSONProtocol) ReadMessageEnd(ctx context.Context) error {
 	return p.ParseListEnd()
 }
 
+func (p *TSimpleJSONProtocol) resetFieldSizeLimits() {
+	p.limits.reset()
+}
+
 func (p *TSimpleJSONProtocol) ReadStructBegin(ctx context.Context) (name string, err error) {
+	p.limits.structBegin()
 	_, err = p.ParseObjectStart()
 	return "", err
 }
 
 func (p *TSimpleJSONProtocol) ReadStructEnd(ctx context.Context) error {
+	p.limits.structEnd()
 	return p.ParseObjectEnd()
 }
 
@@ -435,27 +444,46 @@ func (p *TSimpleJSONProtocol) ReadMapBegin(ctx context.Context) (keyType TType,
 	if err != nil {
 		return keyType, valueType, 0, err
 	}
-	size = int(iSize)
+	size, err = p.limits.containerBegin(ctx, p.cfg, MAP, keyType, valueType, int(iSize))
 	return keyType, valueType, size, err
 }
 
 func (p *TSimpleJSONProtocol) ReadMapEnd(ctx context.Context) error {
+	if e := p.limits.containerEnd(ctx, p); e != nil {
+		return e
+	}
 	return p.ParseListEnd()
 }
 
 func (p *TSimpleJSONProtocol) ReadListBegin(ctx context.Context) (elemType TType, size int, e error) {
-	return p.ParseElemListBegin()
+	elemType, size, e = p.ParseElemListBegin()
+	if e != nil {
+		return elemType, size, e
+	}
+	size, e = p.limits.containerBegin(ctx, p.cfg, LIST, STOP, elemType, size)
+	return elemType, size, e
 }
 
 func (p *TSimpleJSONProtocol) ReadListEnd(ctx context.Context) error {
+	if e := p.limits.containerEnd(ctx, p); e != nil {
+		return e
+	}
 	return p.ParseListEnd()
 }
 
 func (p *TSimpleJSONProtocol) ReadSetBegin(ctx context.Context) (elemType TType, size int, e error) {
-	return p.ParseElemListBegin()
+	elemType, size, e = p.ParseElemListBegin()
+	if e != nil {
+		return elemType, size, e
+	}
+	size, e = p.limits.containerBegin(ctx, p.cfg, SET, STOP, elemType, size)
+	return elemType, size, e
 }
 
 func (p *TSimpleJSONProtocol) ReadSetEnd(ctx context.Context) error {
+	if e := p.limits.containerEnd(ctx, p); e != nil {
+		return e
+	}
 	return p.ParseListEnd()
 }
 
@@ -540,6 +568,14 @@ func (p *TSimpleJSONProtocol) ReadDouble(ctx context.Context) (float64, error) {
 }
 
 func (p *TSimpleJSONProtocol) ReadString(ctx context.Context) (string, error) {
+	v, err := p.readString()
+	if err != nil {
+		return v, err
+	}
+	return p.limits.limitString(ctx, p.cfg, v)
+}
+
+func (p *TSimpleJSONProtocol) readString() (string, error) {
 	var v string
 	if err := p.ParsePreValue(); err != nil {
 		return v, err
@@ -570,6 +606,14 @@ func (p *TSimpleJSONProtocol) ReadString(ctx context.Context) (string, error) {
 }
 
 func (p *TSimpleJSONProtocol) ReadBinary(ctx context.Context) ([]byte, error) {
+	v, err := p.readBinary()
+	if err != nil {
+		return v, err
+	}
+	return p.limits.limitBinary(ctx, p.cfg, v)
+}
+
+func (p *TSimpleJSONProtocol) readBinary() ([]byte, error) {
 	var v []byte
 	if err := p.ParsePreValue(); err != nil {
 		return nil, err
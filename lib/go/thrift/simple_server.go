@@ -20,6 +20,7 @@
 package thrift
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -69,6 +70,211 @@ type TSimpleServer struct {
 	forwardHeaders []string
 
 	logger Logger
+
+	// The server transport the server was created with, and the additional
+	// ones, see AddServerTransport.
+	listener  *tServerListener
+	listeners []*tServerListener
+
+	// Connections being served, used by Shutdown.
+	connMu  sync.Mutex
+	conns   map[*tServerConn]struct{}
+	drained int64
+
+	// Limits, see SetMaxConnections, SetMaxInFlightRequests and
+	// SetMaxQueuedRequests.
+	connSlots      chan struct{}
+	connPolicy     LimitExceededPolicy
+	inFlightSlots  chan struct{}
+	inFlightPolicy LimitExceededPolicy
+	inFlightQueue  chan struct{}
+	stopped        chan struct{}
+	// aborted is closed by Stop, or by Shutdown once its context is done, to
+	// abort the requests waiting for an in-flight slot.
+	aborted chan struct{}
+
+	// Oneway requests limit, see SetOnewayLimit.
+	onewayRate     float64
+	onewayBurst    int
+	onewayPolicy   LimitExceededPolicy
+	onewayAccepted int64
+	onewayDropped  int64
+	onewayClosed   int64
+
+	// See SetPreDecodeHook.
+	preDecodeHook PreDecodeHook
+
+	// See SetPingReplies.
+	pingReplies bool
+
+	// See SetCancellationNotifications.
+	cancellable *tCancellableRequests
+
+	// See SetCompressionNegotiation.
+	transforms []THeaderTransformID
+
+	// See SetStatsHandler.
+	statsHandler TStatsHandler
+
+	// See SetErrorClassifier.
+	errorClassifier ErrorClassifier
+
+	// See SetRequestTracking.
+	trackRequests bool
+	requestsMu    sync.Mutex
+	requests      map[int64]*tInFlightRequest
+	lastRequestID int64
+
+	// See SetSlowRequestWatchdog.
+	slowRequests *SlowRequestOptions
+
+	// See SetIdleTimeout and SetMaxConnectionAge.
+	idleTimeout time.Duration
+	maxConnAge  time.Duration
+
+	// See SetBaseContext and SetConnContext.
+	baseContext func(TServerTransport) context.Context
+	connContext func(ctx context.Context, conn TTransport) context.Context
+
+	// Live configuration, see SetTConfiguration.
+	confMu  sync.Mutex
+	conf    *TConfiguration
+	confGen int64
+}
+
+// DefaultListenerName is the name of the server transport a TSimpleServer is
+// created with, in its ListenerStats.
+const DefaultListenerName = "default"
+
+// ListenerStats are the connection counts of one of the server transports of a
+// TSimpleServer.
+type ListenerStats struct {
+	// Accepted is the number of connections accepted so far.
+	Accepted int64
+	// Rejected is the number of accepted connections closed or rejected
+	// because of the connection limit.
+	Rejected int64
+	// Active is the number of connections being served.
+	Active int64
+}
+
+// tServerListener is one of the server transports of a TSimpleServer.
+type tServerListener struct {
+	name      string
+	transport TServerTransport
+	baseCtx   context.Context
+
+	accepted int64
+	rejected int64
+	active   int64
+}
+
+// LimitExceededPolicy defines what TSimpleServer does when one of its limits
+// is exceeded.
+type LimitExceededPolicy int
+
+const (
+	// LimitExceededBlock waits until the limit is no longer exceeded.
+	//
+	// For connections, it stops accepting new connections, leaving them in
+	// the listen backlog of the operating system.
+	LimitExceededBlock LimitExceededPolicy = iota
+
+	// LimitExceededReject replies to the request with an INTERNAL_ERROR
+	// TApplicationException. Oneway requests are dropped without reply.
+	//
+	// For connections, the first request of the connection is rejected, and
+	// the connection closed afterwards.
+	LimitExceededReject
+
+	// LimitExceededClose closes the connection without replying.
+	LimitExceededClose
+)
+
+// OnewayStats are the oneway request counts reported by
+// TSimpleServer.OnewayStats.
+type OnewayStats struct {
+	// Accepted is the number of oneway requests processed.
+	Accepted int64
+	// Dropped is the number of oneway requests over the limit dropped
+	// without being processed.
+	Dropped int64
+	// Closed is the number of connections closed for being over the limit.
+	Closed int64
+}
+
+// ShutdownStats are the request counts reported by TSimpleServer.Shutdown.
+type ShutdownStats struct {
+	// Drained is the number of in-flight requests that completed before
+	// the deadline.
+	Drained int
+	// Aborted is the number of in-flight requests whose connections were
+	// force-closed when the deadline was reached.
+	Aborted int
+}
+
+// Server connection states.
+const (
+	serverConnIdle int32 = iota
+	serverConnBusy
+	serverConnClosing
+)
+
+// tServerConn wraps an accepted connection to track whether it's idle, or in
+// the middle of a request, which starts when its first bytes are read.
+type tServerConn struct {
+	TTransport
+
+	state int32
+
+	// expired is set when the connection reached its max age while busy,
+	// it's closed once the current request is processed.
+	expired   int32
+	idleTimer *time.Timer
+
+	// onRequest is called by Read when the first bytes of a request are
+	// read, from the goroutine serving the connection.
+	onRequest func()
+}
+
+func (c *tServerConn) Read(buf []byte) (int, error) {
+	n, err := c.TTransport.Read(buf)
+	if n > 0 && atomic.CompareAndSwapInt32(&c.state, serverConnIdle, serverConnBusy) {
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+		}
+		if c.onRequest != nil {
+			c.onRequest()
+		}
+	}
+	return n, err
+}
+
+// closeIfIdle closes the connection if it's idle.
+func (c *tServerConn) closeIfIdle() {
+	if atomic.CompareAndSwapInt32(&c.state, serverConnIdle, serverConnClosing) {
+		c.interrupt()
+	}
+}
+
+func (c *tServerConn) closing() bool {
+	return atomic.LoadInt32(&c.state) == serverConnClosing
+}
+
+func (c *tServerConn) SetTConfiguration(conf *TConfiguration) {
+	PropagateTConfiguration(c.TTransport, conf)
+}
+
+// interrupt closes the connection to unblock its pending reads.
+//
+// Interrupt is preferred over Close when available, as TSocket.Close is not
+// safe to call concurrently with Read.
+func (c *tServerConn) interrupt() {
+	if i, ok := c.TTransport.(interface{ Interrupt() error }); ok {
+		i.Interrupt()
+		return
+	}
+	c.TTransport.Close()
 }
 
 func NewTSimpleServer2(processor TProcessor, serverTransport TServerTransport) *TSimpleServer {
@@ -121,6 +327,12 @@ func NewTSimpleServerFactory6(processorFactory TProcessorFactory, serverTranspor
 		outputTransportFactory: outputTransportFactory,
 		inputProtocolFactory:   inputProtocolFactory,
 		outputProtocolFactory:  outputProtocolFactory,
+		stopped:                make(chan struct{}),
+		aborted:                make(chan struct{}),
+		listener: &tServerListener{
+			name:      DefaultListenerName,
+			transport: serverTransport,
+		},
 	}
 }
 
@@ -148,8 +360,50 @@ func (p *TSimpleServer) OutputProtocolFactory() TProtocolFactory {
 	return p.outputProtocolFactory
 }
 
+// Listen makes all the server transports of the server listen.
 func (p *TSimpleServer) Listen() error {
-	return p.serverTransport.Listen()
+	for _, l := range p.allListeners() {
+		if err := l.transport.Listen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddServerTransport adds a server transport to accept connections from, in
+// addition to the one the server was created with, for example to serve the
+// same processor over TCP, a unix socket and TLS.
+//
+// The connections from all the server transports share the limits and
+// settings of the server, and Stop and Shutdown stop all of them. Their
+// connection counts are reported separately by ListenerStats, under name.
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) AddServerTransport(name string, serverTransport TServerTransport) {
+	p.listeners = append(p.listeners, &tServerListener{
+		name:      name,
+		transport: serverTransport,
+	})
+}
+
+// ListenerStats returns the connection counts of every server transport, by
+// the name they were added with. The server transport the server was created
+// with is named DefaultListenerName.
+func (p *TSimpleServer) ListenerStats() map[string]ListenerStats {
+	listeners := p.allListeners()
+	stats := make(map[string]ListenerStats, len(listeners))
+	for _, l := range listeners {
+		stats[l.name] = ListenerStats{
+			Accepted: atomic.LoadInt64(&l.accepted),
+			Rejected: atomic.LoadInt64(&l.rejected),
+			Active:   atomic.LoadInt64(&l.active),
+		}
+	}
+	return stats
+}
+
+func (p *TSimpleServer) allListeners() []*tServerListener {
+	listeners := make([]*tServerListener, 0, len(p.listeners)+1)
+	return append(append(listeners, p.listener), p.listeners...)
 }
 
 // SetForwardHeaders sets the list of header keys that will be auto forwarded
@@ -180,32 +434,370 @@ func (p *TSimpleServer) SetLogger(logger Logger) {
 	p.logger = logger
 }
 
+// SetMaxConnections limits the number of connections served concurrently to
+// max, with policy defining what happens to the connections accepted over the
+// limit.
+//
+// max <= 0 disables the limit (the default).
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetMaxConnections(max int, policy LimitExceededPolicy) {
+	p.connSlots = nil
+	if max > 0 {
+		p.connSlots = make(chan struct{}, max)
+	}
+	p.connPolicy = policy
+}
+
+// SetMaxInFlightRequests limits the number of requests processed
+// concurrently, across all the connections, to max, with policy defining what
+// happens to the requests over the limit.
+//
+// With LimitExceededBlock, the requests waiting for a slot are replied with an
+// INTERNAL_ERROR TApplicationException when Stop is called, or when the
+// context of Shutdown is done.
+//
+// max <= 0 disables the limit (the default).
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetMaxInFlightRequests(max int, policy LimitExceededPolicy) {
+	p.inFlightSlots = nil
+	if max > 0 {
+		p.inFlightSlots = make(chan struct{}, max)
+	}
+	p.inFlightPolicy = policy
+}
+
+// SetMaxQueuedRequests bounds the number of requests waiting for an in-flight
+// slot with LimitExceededBlock to max, see SetMaxInFlightRequests. The
+// requests over it are rejected as with LimitExceededReject, and their
+// connections stay usable.
+//
+// max < 0 removes the bound (the default).
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetMaxQueuedRequests(max int) {
+	p.inFlightQueue = nil
+	if max >= 0 {
+		p.inFlightQueue = make(chan struct{}, max)
+	}
+}
+
+// SetOnewayLimit limits the rate of the oneway requests of every connection to
+// rate per second, with bursts of up to burst requests, with policy defining
+// what happens to the requests over the limit.
+//
+// As oneway requests are not replied, a client can send them faster than the
+// server processes them without ever waiting. With LimitExceededBlock the
+// server stops reading from the connection until the rate is back under the
+// limit, so that the client is slowed down by TCP flow control. With
+// LimitExceededReject the requests over the limit are dropped, and with
+// LimitExceededClose the connection is closed. See OnewayStats.
+//
+// rate <= 0 disables the limit (the default), burst is 1 if less than 1.
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetOnewayLimit(rate float64, burst int, policy LimitExceededPolicy) {
+	if burst < 1 {
+		burst = 1
+	}
+	p.onewayRate = rate
+	p.onewayBurst = burst
+	p.onewayPolicy = policy
+}
+
+// OnewayStats returns the oneway request counts of the server, they are only
+// counted when SetOnewayLimit is used.
+func (p *TSimpleServer) OnewayStats() OnewayStats {
+	return OnewayStats{
+		Accepted: atomic.LoadInt64(&p.onewayAccepted),
+		Dropped:  atomic.LoadInt64(&p.onewayDropped),
+		Closed:   atomic.LoadInt64(&p.onewayClosed),
+	}
+}
+
+// SetPreDecodeHook sets a hook called for every request before its arguments
+// are decoded, and before it's subject to the other limits of the server, see
+// PreDecodeHook.
+//
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetPreDecodeHook(hook PreDecodeHook) {
+	p.preDecodeHook = hook
+}
+
+// SetPingReplies makes the server reply to the pings of the clients, see
+// Ping, without calling its processor, its hooks or its limits.
+//
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetPingReplies(enabled bool) {
+	p.pingReplies = enabled
+}
+
+// SetCancellationNotifications makes the server cancel the context of the
+// requests whose client sent a cancellation notification, see
+// CancellationMiddleware. It requires THeaderProtocol.
+//
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetCancellationNotifications(enabled bool) {
+	p.cancellable = nil
+	if enabled {
+		p.cancellable = &tCancellableRequests{}
+	}
+}
+
+// SetCompressionNegotiation makes the server compress its replies with the
+// first of the transforms advertised by the client that are in transforms,
+// and advertise transforms to the clients, see
+// CompressionNegotiationMiddleware. The replies to the clients advertising no
+// transform are not compressed. It requires THeaderProtocol.
+//
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetCompressionNegotiation(transforms ...THeaderTransformID) {
+	p.transforms = transforms
+}
+
+// SetStatsHandler sets the TStatsHandler notified of the stats of all the
+// requests, including the ones rejected by the limits of the server.
+//
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetStatsHandler(handler TStatsHandler) {
+	p.statsHandler = handler
+}
+
+// SetErrorClassifier sets the ErrorClassifier mapping the errors returned by
+// the handlers to the errors replied to the clients.
+//
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetErrorClassifier(classifier ErrorClassifier) {
+	p.errorClassifier = classifier
+}
+
+// SetRequestTracking enables the tracking of the requests being processed,
+// for InFlightRequests and CancelRequest.
+//
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetRequestTracking(enabled bool) {
+	p.trackRequests = enabled
+}
+
+// SetIdleTimeout makes the server close the connections that have not sent
+// any request for timeout.
+//
+// timeout <= 0 disables it (the default).
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetIdleTimeout(timeout time.Duration) {
+	p.idleTimeout = timeout
+}
+
+// SetMaxConnectionAge makes the server close the connections once they have
+// been open for age, forcing the clients to reconnect, which rebalances them
+// when the servers are behind a load balancer.
+//
+// A connection in the middle of a request is closed after replying to it.
+//
+// age <= 0 disables it (the default).
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetMaxConnectionAge(age time.Duration) {
+	p.maxConnAge = age
+}
+
+// SetBaseContext sets the function returning the base context of all the
+// connections accepted from the server transport.
+//
+// It's called once when AcceptLoop starts. If not set, or if it returns nil,
+// context.Background() is used.
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetBaseContext(baseContext func(listener TServerTransport) context.Context) {
+	p.baseContext = baseContext
+}
+
+// SetConnContext sets the function deriving the context of a newly accepted
+// connection from the base context, see SetBaseContext.
+//
+// The context it returns is the parent context of all the requests of the
+// connection, so it can be used to pass per-connection values (tenant,
+// identity, accept time, etc.) to the handlers.
+// If it returns nil, the base context is used.
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetConnContext(connContext func(ctx context.Context, conn TTransport) context.Context) {
+	p.connContext = connContext
+}
+
+// SetTConfiguration implements TConfigurationSetter.
+//
+// It can be called on a running server, to loosen or tighten limits like
+// MaxMessageSize or SocketTimeout without a restart. The new configuration is
+// propagated to the transports and protocols of every connection, including
+// the ones already open, as soon as the first bytes of their next request are
+// read, so that it never changes in the middle of a message.
+func (p *TSimpleServer) SetTConfiguration(conf *TConfiguration) {
+	p.confMu.Lock()
+	defer p.confMu.Unlock()
+	p.conf = conf
+	atomic.AddInt64(&p.confGen, 1)
+}
+
+// tConfiguration returns the configuration set by SetTConfiguration and its
+// generation, 0 if it was never called.
+func (p *TSimpleServer) tConfiguration() (*TConfiguration, int64) {
+	p.confMu.Lock()
+	defer p.confMu.Unlock()
+	return p.conf, atomic.LoadInt64(&p.confGen)
+}
+
+// newConnContext returns the parent context of the requests from conn,
+// accepted from l.
+func (p *TSimpleServer) newConnContext(conn TTransport, l *tServerListener) context.Context {
+	ctx := l.baseCtx
+	if ctx == nil {
+		ctx = defaultCtx
+	}
+	ctx = AddPeerToContext(ctx, conn)
+	if p.errorClassifier != nil {
+		ctx = AddErrorClassifierToContext(ctx, p.errorClassifier)
+	}
+	if p.connContext != nil {
+		if connCtx := p.connContext(ctx, conn); connCtx != nil {
+			ctx = connCtx
+		}
+	}
+	return ctx
+}
+
+// acquireConnSlot is called before accepting a connection, it returns false
+// when the server is stopped while waiting for a free slot.
+func (p *TSimpleServer) acquireConnSlot() bool {
+	if p.connSlots == nil || p.connPolicy != LimitExceededBlock {
+		return true
+	}
+	select {
+	case p.connSlots <- struct{}{}:
+		return true
+	case <-p.stopped:
+		return false
+	}
+}
+
+// tryConnSlot is called after accepting a connection, it returns false when
+// the connection is over the limit.
+func (p *TSimpleServer) tryConnSlot() bool {
+	if p.connSlots == nil || p.connPolicy == LimitExceededBlock {
+		return true
+	}
+	select {
+	case p.connSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *TSimpleServer) releaseConnSlot() {
+	if p.connSlots != nil {
+		<-p.connSlots
+	}
+}
+
+// serveClient processes the requests from client, then releases its
+// connection slot.
+func (p *TSimpleServer) serveClient(client TTransport, l *tServerListener) {
+	defer p.releaseConnSlot()
+	atomic.AddInt64(&l.active, 1)
+	defer atomic.AddInt64(&l.active, -1)
+	if err := p.processRequests(client, l); err != nil {
+		p.logger(fmt.Sprintf("error processing request: %v", err))
+	}
+}
+
+// rejectClient handles a connection over the limit according to the
+// connection limit policy.
+func (p *TSimpleServer) rejectClient(client TTransport, l *tServerListener) {
+	atomic.AddInt64(&l.rejected, 1)
+	if p.connPolicy != LimitExceededReject {
+		client.Close()
+		return
+	}
+	processor := &tRejectingProcessor{
+		exc: NewTApplicationException(INTERNAL_ERROR, "server connection limit exceeded"),
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := p.serveRequests(client, l, processor); err != nil {
+			p.logger(fmt.Sprintf("error rejecting connection: %v", err))
+		}
+	}()
+}
+
 func (p *TSimpleServer) innerAccept() (int32, error) {
-	client, err := p.serverTransport.Accept()
+	return p.acceptFrom(p.listener)
+}
+
+func (p *TSimpleServer) acceptFrom(l *tServerListener) (int32, error) {
+	if !p.acquireConnSlot() {
+		return atomic.LoadInt32(&p.closed), nil
+	}
+	client, err := l.transport.Accept()
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	closed := atomic.LoadInt32(&p.closed)
-	if closed != 0 {
-		return closed, nil
-	}
-	if err != nil {
+	if closed != 0 || err != nil || client == nil {
+		if p.connPolicy == LimitExceededBlock {
+			p.releaseConnSlot()
+		}
+		if closed != 0 {
+			return closed, nil
+		}
 		return 0, err
 	}
-	if client != nil {
-		p.wg.Add(1)
-		go func() {
-			defer p.wg.Done()
-			if err := p.processRequests(client); err != nil {
-				p.logger(fmt.Sprintf("error processing request: %v", err))
-			}
-		}()
+	atomic.AddInt64(&l.accepted, 1)
+	if !p.tryConnSlot() {
+		p.rejectClient(client, l)
+		return 0, nil
 	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.serveClient(client, l)
+	}()
 	return 0, nil
 }
 
+// AcceptLoop accepts and serves the connections from all the server
+// transports of the server, until it's stopped.
+//
+// It returns once all the server transports stopped accepting connections,
+// with the first error returned by one of them.
 func (p *TSimpleServer) AcceptLoop() error {
+	p.mu.Lock()
+	listeners := p.allListeners()
+	for _, l := range listeners {
+		l.baseCtx = defaultCtx
+		if p.baseContext != nil {
+			if ctx := p.baseContext(l.transport); ctx != nil {
+				l.baseCtx = ctx
+			}
+		}
+	}
+	p.mu.Unlock()
+	if len(listeners) == 1 {
+		return p.acceptLoop(listeners[0])
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l *tServerListener) {
+			errs <- p.acceptLoop(l)
+		}(l)
+	}
+	var firstErr error
+	for range listeners {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (p *TSimpleServer) acceptLoop(l *tServerListener) error {
 	for {
-		closed, err := p.innerAccept()
+		closed, err := p.acceptFrom(l)
 		if err != nil {
 			return err
 		}
@@ -232,12 +824,92 @@ func (p *TSimpleServer) Stop() error {
 	if atomic.LoadInt32(&p.closed) != 0 {
 		return nil
 	}
-	atomic.StoreInt32(&p.closed, 1)
-	p.serverTransport.Interrupt()
+	p.beginStop()
+	close(p.aborted)
 	p.wg.Wait()
 	return nil
 }
 
+// beginStop marks the server as closed and stops accepting new connections,
+// it must be called with p.mu held.
+func (p *TSimpleServer) beginStop() {
+	atomic.StoreInt32(&p.closed, 1)
+	if p.stopped != nil {
+		close(p.stopped)
+	}
+	for _, l := range p.allListeners() {
+		l.transport.Interrupt()
+	}
+}
+
+// Shutdown gracefully stops the server.
+//
+// It stops accepting new connections, closes the idle ones, and waits for the
+// in-flight requests to complete, including the ones waiting for an in-flight
+// slot, see SetMaxInFlightRequests. If ctx is done before that, the connections
+// still processing requests are force-closed and ctx's error is returned,
+// without waiting for their handlers to return.
+//
+// The returned ShutdownStats report how many in-flight requests were drained
+// and how many were aborted.
+func (p *TSimpleServer) Shutdown(ctx context.Context) (ShutdownStats, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return ShutdownStats{}, nil
+	}
+	atomic.StoreInt64(&p.drained, 0)
+	p.beginStop()
+
+	p.connMu.Lock()
+	for conn := range p.conns {
+		conn.closeIfIdle()
+	}
+	p.connMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.wg.Wait()
+	}()
+
+	var stats ShutdownStats
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		p.connMu.Lock()
+		for conn := range p.conns {
+			if atomic.CompareAndSwapInt32(&conn.state, serverConnBusy, serverConnClosing) {
+				stats.Aborted++
+				conn.interrupt()
+			}
+		}
+		p.connMu.Unlock()
+		close(p.aborted)
+	}
+	stats.Drained = int(atomic.LoadInt64(&p.drained))
+	return stats, err
+}
+
+func (p *TSimpleServer) trackConn(client TTransport) *tServerConn {
+	conn := &tServerConn{TTransport: client}
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+	if p.conns == nil {
+		p.conns = make(map[*tServerConn]struct{})
+	}
+	p.conns[conn] = struct{}{}
+	return conn
+}
+
+func (p *TSimpleServer) untrackConn(conn *tServerConn) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+	delete(p.conns, conn)
+}
+
 // If err is actually EOF, return nil, otherwise return err as-is.
 func treatEOFErrorsAsNil(err error) error {
 	if err == nil {
@@ -253,16 +925,103 @@ func treatEOFErrorsAsNil(err error) error {
 	return err
 }
 
-func (p *TSimpleServer) processRequests(client TTransport) (err error) {
+func (p *TSimpleServer) processRequests(client TTransport, l *tServerListener) error {
+	processor := p.processorFactory.GetProcessor(client)
+	if p.trackRequests {
+		processor = &tRequestTrackingProcessor{
+			TProcessor: processor,
+			server:     p,
+		}
+	}
+	if p.slowRequests != nil {
+		processor = &tSlowRequestProcessor{
+			TProcessor: processor,
+			server:     p,
+		}
+	}
+	if p.inFlightSlots != nil {
+		processor = &tInFlightLimitedProcessor{
+			TProcessor: processor,
+			server:     p,
+		}
+	}
+	if p.onewayRate > 0 {
+		// Outside of the in-flight limit, so that a connection waiting
+		// for its oneway rate doesn't hold an in-flight slot.
+		processor = &tOnewayLimitedProcessor{
+			TProcessor: processor,
+			server:     p,
+			bucket: tTokenBucket{
+				tokens: float64(p.onewayBurst),
+				last:   time.Now(),
+			},
+		}
+	}
+	if p.preDecodeHook != nil {
+		processor = &tPreDecodeProcessor{
+			TProcessor: processor,
+			hook:       p.preDecodeHook,
+		}
+	}
+	if p.cancellable != nil {
+		// Outside of the limits, so that the notifications are not
+		// delayed by the requests they cancel.
+		processor = &tCancellationProcessor{
+			TProcessor: processor,
+			requests:   p.cancellable,
+		}
+	}
+	if p.pingReplies {
+		// Outermost, so that the pings are not subject to the hooks and
+		// limits.
+		processor = &tPingProcessor{TProcessor: processor}
+	}
+	return p.serveRequests(client, l, processor)
+}
+
+func (p *TSimpleServer) serveRequests(client TTransport, l *tServerListener, processor TProcessor) (err error) {
 	defer func() {
 		err = treatEOFErrorsAsNil(err)
 	}()
 
-	processor := p.processorFactory.GetProcessor(client)
-	inputTransport, err := p.inputTransportFactory.GetTransport(client)
+	// The connection needs to be tracked before checking p.closed, so that
+	// Shutdown either sees it or it sees p.closed.
+	conn := p.trackConn(client)
+	defer p.untrackConn(conn)
+	connCtx := p.newConnContext(client, l)
+	defer func() {
+		// Errors from the connection being closed on purpose (idle
+		// timeout, max age, shutdown) are expected.
+		if conn.closing() {
+			err = nil
+		}
+	}()
+	if p.idleTimeout > 0 {
+		conn.idleTimer = time.AfterFunc(p.idleTimeout, func() {
+			conn.closeIfIdle()
+		})
+		defer conn.idleTimer.Stop()
+	}
+	if p.maxConnAge > 0 {
+		ageTimer := time.AfterFunc(p.maxConnAge, func() {
+			// A busy connection is closed by the loop below once its
+			// request is processed.
+			atomic.StoreInt32(&conn.expired, 1)
+			conn.closeIfIdle()
+		})
+		defer ageTimer.Stop()
+	}
+
+	inputTransport, err := p.inputTransportFactory.GetTransport(conn)
 	if err != nil {
 		return err
 	}
+	// The bytes of the requests and replies, for the payload sizes.
+	var statsIn, statsOut *tByteCountingTransport
+	if p.statsHandler != nil {
+		statsIn = &tByteCountingTransport{TTransport: inputTransport}
+		inputTransport = statsIn
+	}
 	inputProtocol := p.inputProtocolFactory.GetProtocol(inputTransport)
 	var outputTransport TTransport
 	var outputProtocol TProtocol
@@ -273,14 +1032,27 @@ func (p *TSimpleServer) processRequests(client TTransport) (err error) {
 	headerProtocol, ok := inputProtocol.(*THeaderProtocol)
 	if ok {
 		outputProtocol = inputProtocol
+		statsOut = statsIn
 	} else {
 		oTrans, err := p.outputTransportFactory.GetTransport(client)
 		if err != nil {
 			return err
 		}
+		if p.statsHandler != nil {
+			statsOut = &tByteCountingTransport{TTransport: oTrans}
+			oTrans = statsOut
+		}
 		outputTransport = oTrans
 		outputProtocol = p.outputProtocolFactory.GetProtocol(outputTransport)
 	}
+	if p.statsHandler != nil {
+		processor = &tStatsProcessor{
+			TProcessor: processor,
+			handler:    p.statsHandler,
+			in:         statsIn,
+			out:        statsOut,
+		}
+	}
 
 	if inputTransport != nil {
 		defer inputTransport.Close()
@@ -288,17 +1060,31 @@ func (p *TSimpleServer) processRequests(client TTransport) (err error) {
 	if outputTransport != nil {
 		defer outputTransport.Close()
 	}
+	var confGen int64
+	conn.onRequest = func() {
+		if atomic.LoadInt64(&p.confGen) == confGen {
+			return
+		}
+		var conf *TConfiguration
+		conf, confGen = p.tConfiguration()
+		for _, impl := range []interface{}{conn, inputTransport, outputTransport, inputProtocol, outputProtocol} {
+			PropagateTConfiguration(impl, conf)
+		}
+	}
 	for {
 		if atomic.LoadInt32(&p.closed) != 0 {
 			return nil
 		}
+		// The payload sizes are counted from here, see PayloadSizes.
+		beginCountedRequest(inputProtocol.Transport(), outputProtocol.Transport(), statsIn, statsOut)
 
 		ctx := SetResponseHelper(
-			defaultCtx,
+			connCtx,
 			TResponseHelper{
 				THeaderResponseHelper: NewTHeaderResponseHelper(outputProtocol),
 			},
 		)
+		cancel := func() {}
 		if headerProtocol != nil {
 			// We need to call ReadFrame here, otherwise we won't
 			// get any headers on the AddReadTHeaderToContext call.
@@ -309,11 +1095,30 @@ func (p *TSimpleServer) processRequests(client TTransport) (err error) {
 			if err := headerProtocol.ReadFrame(ctx); err != nil {
 				return err
 			}
+			// The response headers set by the handler of the previous
+			// request, see SetResponseHeader.
+			headerProtocol.ClearWriteHeaders()
+			if p.transforms != nil {
+				negotiateCompression(headerProtocol, p.transforms)
+			}
 			ctx = AddReadTHeaderToContext(ctx, headerProtocol.GetReadHeaders())
 			ctx = SetWriteHeaderList(ctx, p.forwardHeaders)
+			ctx, cancel = ContextWithDeadlineFromHeader(ctx)
 		}
 
 		ok, err := processor.Process(ctx, inputProtocol, outputProtocol)
+		cancel()
+		if atomic.CompareAndSwapInt32(&conn.state, serverConnBusy, serverConnIdle) {
+			if atomic.LoadInt32(&p.closed) != 0 && !errors.Is(err, errRequestAborted) {
+				atomic.AddInt64(&p.drained, 1)
+			}
+			if atomic.LoadInt32(&conn.expired) != 0 {
+				return nil
+			}
+			if conn.idleTimer != nil {
+				conn.idleTimer.Reset(p.idleTimeout)
+			}
+		}
 		if errors.Is(err, ErrAbandonRequest) {
 			return client.Close()
 		}
@@ -330,3 +1135,153 @@ func (p *TSimpleServer) processRequests(client TTransport) (err error) {
 	}
 	return nil
 }
+
+// tInFlightLimitedProcessor enforces TSimpleServer's in-flight requests limit.
+type tInFlightLimitedProcessor struct {
+	TProcessor
+
+	server *TSimpleServer
+}
+
+func (p *tInFlightLimitedProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	// The message begin is read before taking a slot, so that idle
+	// connections don't hold one.
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+
+	s := p.server
+	select {
+	case s.inFlightSlots <- struct{}{}:
+	default:
+		switch s.inFlightPolicy {
+		case LimitExceededReject:
+			exc := NewTApplicationException(INTERNAL_ERROR, "server in-flight request limit exceeded")
+			if err := skipRequestWithException(ctx, in, out, name, typeID, seqID, exc); err != nil {
+				return false, WrapTException(err)
+			}
+			return true, nil
+		case LimitExceededClose:
+			return false, nil
+		default:
+			if s.inFlightQueue != nil {
+				select {
+				case s.inFlightQueue <- struct{}{}:
+				default:
+					exc := NewTApplicationException(INTERNAL_ERROR, "server in-flight request queue is full")
+					if err := skipRequestWithException(ctx, in, out, name, typeID, seqID, exc); err != nil {
+						return false, WrapTException(err)
+					}
+					return true, nil
+				}
+			}
+			// The request is in flight once its message begin is read,
+			// so Shutdown waits for it to get a slot.
+			select {
+			case s.inFlightSlots <- struct{}{}:
+			case <-s.aborted:
+				s.leaveInFlightQueue()
+				return abortRequest(ctx, in, out, name, typeID, seqID)
+			}
+			s.leaveInFlightQueue()
+		}
+	}
+	defer func() {
+		<-s.inFlightSlots
+	}()
+	return p.TProcessor.Process(ctx, NewStoredMessageProtocol(in, name, typeID, seqID), out)
+}
+
+// leaveInFlightQueue is called by the requests done waiting for an in-flight
+// slot, see SetMaxQueuedRequests.
+func (p *TSimpleServer) leaveInFlightQueue() {
+	if p.inFlightQueue != nil {
+		<-p.inFlightQueue
+	}
+}
+
+// errRequestAborted is the error of the requests aborted by Stop, or by
+// Shutdown once its context is done, while waiting for an in-flight slot.
+var errRequestAborted = errors.New("thrift: request aborted by the server stopping")
+
+// abortRequest replies to a request aborted while waiting for an in-flight
+// slot with an INTERNAL_ERROR TApplicationException, and closes its
+// connection.
+func abortRequest(ctx context.Context, in, out TProtocol, name string, typeID TMessageType, seqID int32) (bool, TException) {
+	exc := NewTApplicationException(INTERNAL_ERROR, "server stopped")
+	// The connection is closed anyway, the reply is only attempted.
+	skipRequestWithException(ctx, in, out, name, typeID, seqID, exc)
+	return false, WrapTException(errRequestAborted)
+}
+
+// tOnewayLimitedProcessor enforces TSimpleServer's oneway requests limit on a
+// connection.
+type tOnewayLimitedProcessor struct {
+	TProcessor
+
+	server *TSimpleServer
+	bucket tTokenBucket
+}
+
+func (p *tOnewayLimitedProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	if typeID == ONEWAY {
+		s := p.server
+		rate, burst := s.onewayRate, float64(s.onewayBurst)
+		for !p.bucket.take(time.Now(), rate, burst) {
+			switch s.onewayPolicy {
+			case LimitExceededReject:
+				atomic.AddInt64(&s.onewayDropped, 1)
+				if err := skipRequestWithException(ctx, in, out, name, typeID, seqID, nil); err != nil {
+					return false, WrapTException(err)
+				}
+				return true, nil
+			case LimitExceededClose:
+				atomic.AddInt64(&s.onewayClosed, 1)
+				return false, nil
+			default:
+				wait := time.Duration((1 - p.bucket.tokens) / rate * float64(time.Second))
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-s.stopped:
+					timer.Stop()
+					return false, nil
+				}
+			}
+		}
+		atomic.AddInt64(&s.onewayAccepted, 1)
+	}
+	return p.TProcessor.Process(ctx, NewStoredMessageProtocol(in, name, typeID, seqID), out)
+}
+
+// tRejectingProcessor rejects the first request of a connection with exc,
+// then closes the connection.
+type tRejectingProcessor struct {
+	exc TApplicationException
+}
+
+func (p *tRejectingProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	return false, WrapTException(skipRequestWithException(ctx, in, out, name, typeID, seqID, p.exc))
+}
+
+func (p *tRejectingProcessor) ProcessorMap() map[string]TProcessorFunction {
+	return nil
+}
+
+func (p *tRejectingProcessor) AddToProcessorMap(string, TProcessorFunction) {}
+
+var (
+	_ TConfigurationSetter = (*TSimpleServer)(nil)
+	_ TProcessor           = (*tInFlightLimitedProcessor)(nil)
+	_ TProcessor           = (*tOnewayLimitedProcessor)(nil)
+	_ TProcessor           = (*tRejectingProcessor)(nil)
+)
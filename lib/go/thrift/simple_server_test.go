@@ -20,9 +20,14 @@
 package thrift
 
 import (
+	"context"
+	"fmt"
 	"testing"
 	"errors"
 	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type mockServerTransport struct {
@@ -154,3 +159,817 @@ func TestNoHangDuringStopFromDanglingLockAcquireDuringAcceptLoop(t *testing.T) {
 	runtime.Gosched()
 	serv.Stop()
 }
+
+// blockingEchoProcessor returns an echo processor signaling started when it
+// starts processing a request, and waiting for release before replying.
+func blockingEchoProcessor(started chan<- struct{}, release <-chan struct{}) *mockProcessor {
+	return &mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			ctx := context.Background()
+			name, _, seqID, err := in.ReadMessageBegin(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			started <- struct{}{}
+			<-release
+			value, err := in.ReadString(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			in.ReadMessageEnd(ctx)
+			out.WriteMessageBegin(ctx, name, REPLY, seqID)
+			out.WriteString(ctx, value)
+			out.WriteMessageEnd(ctx)
+			return true, WrapTException(out.Flush(ctx))
+		},
+	}
+}
+
+// startTestSocketServer starts a TSimpleServer on a local TCP port, configure
+// is called before it starts accepting connections if non-nil.
+func startTestSocketServer(t *testing.T, processor TProcessor, configure func(*TSimpleServer)) (*TSimpleServer, string) {
+	t.Helper()
+	serverTrans, err := NewTServerSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serv := NewTSimpleServer2(processor, serverTrans)
+	serv.SetLogger(func(string) {})
+	if configure != nil {
+		configure(serv)
+	}
+	if err := serv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go serv.AcceptLoop()
+	return serv, serverTrans.Addr().String()
+}
+
+func dialTestSocketServer(t *testing.T, addr string) *TSocket {
+	t.Helper()
+	sock, err := NewTSocketConf(addr, &TConfiguration{SocketTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sock.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sock.Close()
+	})
+	return sock
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	serv, addr := startTestSocketServer(t, blockingEchoProcessor(started, release), nil)
+
+	busy := dialTestSocketServer(t, addr)
+	idle := dialTestSocketServer(t, addr)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- echoCall(t, NewTBinaryProtocolConf(busy, nil), 1, "drain")
+	}()
+	<-started
+
+	shutdownDone := make(chan struct{})
+	var stats ShutdownStats
+	var err error
+	go func() {
+		defer close(shutdownDone)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stats, err = serv.Shutdown(ctx)
+	}()
+
+	// The idle connection is closed right away.
+	if _, readErr := idle.Read(make([]byte, 1)); readErr == nil {
+		t.Error("expected idle connection to be closed")
+	}
+
+	close(release)
+	if callErr := <-result; callErr != nil {
+		t.Errorf("in-flight request failed: %v", callErr)
+	}
+	<-shutdownDone
+	if err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+	if stats.Drained != 1 || stats.Aborted != 0 {
+		t.Errorf("expected 1 drained and 0 aborted requests, got %+v", stats)
+	}
+}
+
+func TestShutdownAbortsAfterDeadline(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	serv, addr := startTestSocketServer(t, blockingEchoProcessor(started, release), nil)
+
+	busy := dialTestSocketServer(t, addr)
+	result := make(chan error, 1)
+	go func() {
+		result <- echoCall(t, NewTBinaryProtocolConf(busy, nil), 1, "abort")
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	stats, err := serv.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded error, got %v", err)
+	}
+	if stats.Drained != 0 || stats.Aborted != 1 {
+		t.Errorf("expected 0 drained and 1 aborted requests, got %+v", stats)
+	}
+	if callErr := <-result; callErr == nil {
+		t.Error("expected aborted request to fail")
+	}
+}
+
+// callExpectingException makes an "echo" call with empty args over proto and
+// returns the TApplicationException it's replied with.
+func callExpectingException(proto TProtocol, seqID int32) (TApplicationException, error) {
+	ctx := context.Background()
+	proto.WriteMessageBegin(ctx, "echo", CALL, seqID)
+	proto.WriteStructBegin(ctx, "echo_args")
+	proto.WriteFieldStop(ctx)
+	proto.WriteStructEnd(ctx)
+	proto.WriteMessageEnd(ctx)
+	if err := proto.Flush(ctx); err != nil {
+		return nil, err
+	}
+	_, typeID, _, err := proto.ReadMessageBegin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if typeID != EXCEPTION {
+		return nil, fmt.Errorf("expected exception, got message type %v", typeID)
+	}
+	exc := NewTApplicationException(UNKNOWN_APPLICATION_EXCEPTION, "")
+	if err := exc.Read(ctx, proto); err != nil {
+		return nil, err
+	}
+	return exc, proto.ReadMessageEnd(ctx)
+}
+
+func TestMaxConnections(t *testing.T) {
+	for _, c := range []struct {
+		label  string
+		policy LimitExceededPolicy
+	}{
+		{"block", LimitExceededBlock},
+		{"reject", LimitExceededReject},
+		{"close", LimitExceededClose},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			started := make(chan struct{}, 2)
+			release := make(chan struct{})
+			serv, addr := startTestSocketServer(t, blockingEchoProcessor(started, release), func(s *TSimpleServer) {
+				s.SetMaxConnections(1, c.policy)
+			})
+			// Registered before dialing, so the clients are closed first.
+			t.Cleanup(func() {
+				serv.Stop()
+			})
+
+			first := dialTestSocketServer(t, addr)
+			firstResult := make(chan error, 1)
+			go func() {
+				firstResult <- echoCall(t, NewTBinaryProtocolConf(first, nil), 1, "first")
+			}()
+			<-started
+
+			second := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+			switch c.policy {
+			case LimitExceededBlock:
+				secondResult := make(chan error, 1)
+				go func() {
+					secondResult <- echoCall(t, second, 1, "second")
+				}()
+				select {
+				case <-started:
+					t.Fatal("second connection should not be served while the first one is open")
+				case <-time.After(50 * time.Millisecond):
+				}
+				close(release)
+				if err := <-firstResult; err != nil {
+					t.Fatal(err)
+				}
+				first.Close()
+				if err := <-secondResult; err != nil {
+					t.Error(err)
+				}
+			case LimitExceededReject:
+				exc, err := callExpectingException(second, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if exc.TypeId() != INTERNAL_ERROR {
+					t.Errorf("expected INTERNAL_ERROR, got %v", exc)
+				}
+				close(release)
+				if err := <-firstResult; err != nil {
+					t.Error(err)
+				}
+			case LimitExceededClose:
+				if err := echoCall(t, second, 1, "second"); err == nil {
+					t.Error("expected second call to fail")
+				}
+				close(release)
+				if err := <-firstResult; err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxInFlightRequests(t *testing.T) {
+	for _, c := range []struct {
+		label  string
+		policy LimitExceededPolicy
+	}{
+		{"block", LimitExceededBlock},
+		{"reject", LimitExceededReject},
+		{"close", LimitExceededClose},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			started := make(chan struct{}, 2)
+			release := make(chan struct{})
+			serv, addr := startTestSocketServer(t, blockingEchoProcessor(started, release), func(s *TSimpleServer) {
+				s.SetMaxInFlightRequests(1, c.policy)
+			})
+			// Registered before dialing, so the clients are closed first.
+			t.Cleanup(func() {
+				serv.Stop()
+			})
+
+			first := dialTestSocketServer(t, addr)
+			firstResult := make(chan error, 1)
+			go func() {
+				firstResult <- echoCall(t, NewTBinaryProtocolConf(first, nil), 1, "first")
+			}()
+			<-started
+
+			second := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+			switch c.policy {
+			case LimitExceededBlock:
+				secondResult := make(chan error, 1)
+				go func() {
+					secondResult <- echoCall(t, second, 1, "second")
+				}()
+				select {
+				case <-started:
+					t.Fatal("second request should wait for the first one")
+				case <-time.After(50 * time.Millisecond):
+				}
+				close(release)
+				if err := <-firstResult; err != nil {
+					t.Fatal(err)
+				}
+				if err := <-secondResult; err != nil {
+					t.Error(err)
+				}
+			case LimitExceededReject:
+				exc, err := callExpectingException(second, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if exc.TypeId() != INTERNAL_ERROR {
+					t.Errorf("expected INTERNAL_ERROR, got %v", exc)
+				}
+				close(release)
+				if err := <-firstResult; err != nil {
+					t.Fatal(err)
+				}
+				// The connection is still usable after the rejection.
+				if err := echoCall(t, second, 2, "second"); err != nil {
+					t.Error(err)
+				}
+			case LimitExceededClose:
+				if err := echoCall(t, second, 1, "second"); err == nil {
+					t.Error("expected second call to fail")
+				}
+				close(release)
+				if err := <-firstResult; err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxQueuedRequests(t *testing.T) {
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	serv, addr := startTestSocketServer(t, blockingEchoProcessor(started, release), func(s *TSimpleServer) {
+		s.SetMaxInFlightRequests(1, LimitExceededBlock)
+		s.SetMaxQueuedRequests(1)
+	})
+	// Registered before dialing, so the clients are closed first.
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	first := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+	second := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+	third := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+
+	firstResult := make(chan error, 1)
+	go func() {
+		firstResult <- echoCall(t, first, 1, "first")
+	}()
+	<-started
+
+	// The second request waits for the slot, the third one exceeds the
+	// queue.
+	secondResult := make(chan error, 1)
+	go func() {
+		secondResult <- echoCall(t, second, 1, "second")
+	}()
+	select {
+	case <-started:
+		t.Fatal("second request should wait for the first one")
+	case <-time.After(50 * time.Millisecond):
+	}
+	exc, err := callExpectingException(third, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exc.TypeId() != INTERNAL_ERROR {
+		t.Errorf("expected INTERNAL_ERROR, got %v", exc)
+	}
+
+	close(release)
+	if err := <-firstResult; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-secondResult; err != nil {
+		t.Fatal(err)
+	}
+	// The connection of the rejected request is still usable, and the queue
+	// is free again.
+	if err := echoCall(t, third, 2, "third"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMaxInFlightRequestsShutdown(t *testing.T) {
+	// start starts a server with a request in flight and a second one
+	// queued, waiting for its slot.
+	start := func(t *testing.T) (serv *TSimpleServer, release chan struct{}, firstResult chan error, second TProtocol) {
+		started := make(chan struct{}, 2)
+		release = make(chan struct{})
+		serv, addr := startTestSocketServer(t, blockingEchoProcessor(started, release), func(s *TSimpleServer) {
+			s.SetMaxInFlightRequests(1, LimitExceededBlock)
+			s.SetMaxQueuedRequests(1)
+		})
+		first := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+		firstResult = make(chan error, 1)
+		go func() {
+			firstResult <- echoCall(t, first, 1, "first")
+		}()
+		<-started
+		second = NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+		return serv, release, firstResult, second
+	}
+	// waiting waits for the second request to wait for its slot.
+	waiting := func() {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Run("shutdown", func(t *testing.T) {
+		serv, release, firstResult, second := start(t)
+		secondResult := make(chan error, 1)
+		go func() {
+			secondResult <- echoCall(t, second, 1, "second")
+		}()
+		waiting()
+
+		shutdownDone := make(chan struct{})
+		var stats ShutdownStats
+		var err error
+		go func() {
+			defer close(shutdownDone)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			stats, err = serv.Shutdown(ctx)
+		}()
+		waiting()
+		close(release)
+		if err := <-firstResult; err != nil {
+			t.Error(err)
+		}
+		// The waiting request is processed before the server stops.
+		if err := <-secondResult; err != nil {
+			t.Error(err)
+		}
+		<-shutdownDone
+		if err != nil {
+			t.Errorf("unexpected shutdown error: %v", err)
+		}
+		if stats.Drained != 2 || stats.Aborted != 0 {
+			t.Errorf("expected 2 drained and 0 aborted requests, got %+v", stats)
+		}
+	})
+
+	t.Run("shutdown deadline", func(t *testing.T) {
+		serv, release, firstResult, second := start(t)
+		defer close(release)
+		secondResult := make(chan error, 1)
+		go func() {
+			secondResult <- echoCall(t, second, 1, "second")
+		}()
+		waiting()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		stats, err := serv.Shutdown(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded error, got %v", err)
+		}
+		if stats.Drained != 0 || stats.Aborted != 2 {
+			t.Errorf("expected 0 drained and 2 aborted requests, got %+v", stats)
+		}
+		if err := <-firstResult; err == nil {
+			t.Error("expected the first request to fail")
+		}
+		if err := <-secondResult; err == nil {
+			t.Error("expected the waiting request to fail")
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		serv, release, firstResult, second := start(t)
+		excResult := make(chan TApplicationException, 1)
+		go func() {
+			exc, err := callExpectingException(second, 1)
+			if err != nil {
+				t.Error(err)
+			}
+			excResult <- exc
+		}()
+		waiting()
+
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			serv.Stop()
+		}()
+		// The waiting request is replied without waiting for the slot.
+		if exc := <-excResult; exc != nil && exc.TypeId() != INTERNAL_ERROR {
+			t.Errorf("expected INTERNAL_ERROR, got %v", exc)
+		}
+		close(release)
+		if err := <-firstResult; err != nil {
+			t.Error(err)
+		}
+		<-stopped
+	})
+}
+
+func TestIdleTimeout(t *testing.T) {
+	serv, addr := startTestSocketServer(t, echoProcessor(), func(s *TSimpleServer) {
+		s.SetIdleTimeout(100 * time.Millisecond)
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	idle := dialTestSocketServer(t, addr)
+	active := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+
+	// Keep the active connection busy for longer than the idle timeout.
+	for i := int32(1); i <= 6; i++ {
+		if err := echoCall(t, active, i, "active"); err != nil {
+			t.Fatalf("active connection closed: %v", err)
+		}
+		time.Sleep(40 * time.Millisecond)
+	}
+	if _, err := idle.Read(make([]byte, 1)); err == nil {
+		t.Error("expected idle connection to be closed")
+	}
+}
+
+func TestMaxConnectionAge(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	serv, addr := startTestSocketServer(t, blockingEchoProcessor(started, release), func(s *TSimpleServer) {
+		s.SetMaxConnectionAge(50 * time.Millisecond)
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	proto := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+	result := make(chan error, 1)
+	go func() {
+		result <- echoCall(t, proto, 1, "aging")
+	}()
+	<-started
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	// The request in progress is replied, then the connection is closed.
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	if err := echoCall(t, proto, 2, "expired"); err == nil {
+		t.Error("expected connection to be closed after its max age")
+	}
+}
+
+type testServerContextKey int
+
+const (
+	testBaseContextKey testServerContextKey = iota
+	testConnContextKey
+)
+
+// contextCapturingProcessor wraps a mockProcessor, sending the context of
+// every successfully processed request to ctxs.
+type contextCapturingProcessor struct {
+	*mockProcessor
+
+	ctxs chan context.Context
+}
+
+func (p *contextCapturingProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	ok, err := p.mockProcessor.Process(ctx, in, out)
+	if err == nil {
+		p.ctxs <- ctx
+	}
+	return ok, err
+}
+
+func TestBaseContextAndConnContext(t *testing.T) {
+	processor := &contextCapturingProcessor{
+		mockProcessor: echoProcessor(),
+		ctxs:          make(chan context.Context, 10),
+	}
+	var baseCalls int32
+	serv, addr := startTestSocketServer(t, processor, func(s *TSimpleServer) {
+		s.SetBaseContext(func(listener TServerTransport) context.Context {
+			atomic.AddInt32(&baseCalls, 1)
+			if _, ok := listener.(*TServerSocket); !ok {
+				t.Errorf("unexpected listener %T", listener)
+			}
+			return context.WithValue(context.Background(), testBaseContextKey, "base")
+		})
+		s.SetConnContext(func(ctx context.Context, conn TTransport) context.Context {
+			socket, ok := conn.(*TSocket)
+			if !ok {
+				t.Errorf("unexpected conn %T", conn)
+				return ctx
+			}
+			return context.WithValue(ctx, testConnContextKey, socket.Conn().RemoteAddr().String())
+		})
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	var clientAddrs []string
+	for i := 0; i < 2; i++ {
+		sock := dialTestSocketServer(t, addr)
+		clientAddrs = append(clientAddrs, sock.Conn().LocalAddr().String())
+		proto := NewTBinaryProtocolConf(sock, nil)
+		for seqID := int32(1); seqID <= 2; seqID++ {
+			if err := echoCall(t, proto, seqID, "ctx"); err != nil {
+				t.Fatal(err)
+			}
+			ctx := <-processor.ctxs
+			if got := ctx.Value(testBaseContextKey); got != "base" {
+				t.Errorf("expected base context value, got %v", got)
+			}
+			if got := ctx.Value(testConnContextKey); got != clientAddrs[i] {
+				t.Errorf("expected conn context value %q, got %v", clientAddrs[i], got)
+			}
+		}
+	}
+	if got := atomic.LoadInt32(&baseCalls); got != 1 {
+		t.Errorf("expected BaseContext to be called once, got %d", got)
+	}
+}
+
+func TestMultipleServerTransports(t *testing.T) {
+	extraTrans, err := NewTServerSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serv, addr := startTestSocketServer(t, echoProcessor(), func(s *TSimpleServer) {
+		s.AddServerTransport("extra", extraTrans)
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	extraAddr := extraTrans.Addr().String()
+
+	var socks []*TSocket
+	for i, a := range []string{addr, extraAddr, extraAddr} {
+		sock := dialTestSocketServer(t, a)
+		socks = append(socks, sock)
+		if err := echoCall(t, NewTBinaryProtocolConf(sock, nil), int32(i), a); err != nil {
+			t.Fatalf("%s: %v", a, err)
+		}
+	}
+	stats := serv.ListenerStats()
+	for name, expected := range map[string]ListenerStats{
+		DefaultListenerName: {Accepted: 1, Active: 1},
+		"extra":             {Accepted: 2, Active: 2},
+	} {
+		if got := stats[name]; got != expected {
+			t.Errorf("%s: expected stats %+v, got %+v", name, expected, got)
+		}
+	}
+
+	for _, sock := range socks {
+		sock.Close()
+	}
+	serv.Stop()
+	for _, a := range []string{addr, extraAddr} {
+		if sock, err := NewTSocketConf(a, nil); err == nil && sock.Open() == nil {
+			sock.Close()
+			t.Errorf("%s: expected server transport to be closed by Stop", a)
+		}
+	}
+	if got := serv.ListenerStats()["extra"].Active; got != 0 {
+		t.Errorf("expected no active connections after Stop, got %d", got)
+	}
+}
+
+func TestSetTConfigurationOnRunningServer(t *testing.T) {
+	serv, addr := startTestSocketServer(t, echoProcessor(), nil)
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	value := strings.Repeat("x", 1000)
+
+	open := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+	if err := echoCall(t, open, 1, value); err != nil {
+		t.Fatal(err)
+	}
+
+	serv.SetTConfiguration(&TConfiguration{MaxMessageSize: 100})
+	// Both the open connection and the new ones get the tighter limit.
+	if err := echoCall(t, open, 2, value); err == nil {
+		t.Error("expected the open connection to reject a message over the new limit")
+	}
+	if err := echoCall(t, NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil), 1, value); err == nil {
+		t.Error("expected a new connection to reject a message over the new limit")
+	}
+
+	serv.SetTConfiguration(&TConfiguration{})
+	if err := echoCall(t, NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil), 1, value); err != nil {
+		t.Errorf("expected the limit to be loosened: %v", err)
+	}
+}
+
+// onewayCountingProcessor returns an echo processor also accepting oneway
+// requests with empty args, which it counts.
+func onewayCountingProcessor(oneways *int32) *mockProcessor {
+	echo := echoProcessor()
+	return &mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			ctx := context.Background()
+			name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+			if err != nil {
+				return false, WrapTException(err)
+			}
+			if typeID != ONEWAY {
+				return echo.ProcessFunc(NewStoredMessageProtocol(in, name, typeID, seqID), out)
+			}
+			if err := in.Skip(ctx, STRUCT); err != nil {
+				return false, WrapTException(err)
+			}
+			atomic.AddInt32(oneways, 1)
+			return true, WrapTException(in.ReadMessageEnd(ctx))
+		},
+	}
+}
+
+func sendOneways(t *testing.T, proto TProtocol, n int) {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		proto.WriteMessageBegin(ctx, "ping", ONEWAY, int32(i))
+		proto.WriteStructBegin(ctx, "ping_args")
+		proto.WriteFieldStop(ctx)
+		proto.WriteStructEnd(ctx)
+		proto.WriteMessageEnd(ctx)
+	}
+	if err := proto.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnewayLimit(t *testing.T) {
+	t.Run("drop", func(t *testing.T) {
+		var oneways int32
+		serv, addr := startTestSocketServer(t, onewayCountingProcessor(&oneways), func(s *TSimpleServer) {
+			s.SetOnewayLimit(1e-9, 2, LimitExceededReject)
+		})
+		t.Cleanup(func() {
+			serv.Stop()
+		})
+
+		proto := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+		sendOneways(t, proto, 5)
+		// Two-way requests are not limited.
+		if err := echoCall(t, proto, 10, "sync"); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt32(&oneways); got != 2 {
+			t.Errorf("expected 2 processed oneway requests, got %d", got)
+		}
+		if stats := serv.OnewayStats(); stats != (OnewayStats{Accepted: 2, Dropped: 3}) {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		var oneways int32
+		serv, addr := startTestSocketServer(t, onewayCountingProcessor(&oneways), func(s *TSimpleServer) {
+			s.SetOnewayLimit(1e-9, 1, LimitExceededClose)
+		})
+		t.Cleanup(func() {
+			serv.Stop()
+		})
+
+		proto := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+		sendOneways(t, proto, 2)
+		if err := echoCall(t, proto, 10, "sync"); err == nil {
+			t.Error("expected the connection to be closed")
+		}
+		if stats := serv.OnewayStats(); stats != (OnewayStats{Accepted: 1, Closed: 1}) {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		var oneways int32
+		serv, addr := startTestSocketServer(t, onewayCountingProcessor(&oneways), func(s *TSimpleServer) {
+			s.SetOnewayLimit(50, 1, LimitExceededBlock)
+		})
+		t.Cleanup(func() {
+			serv.Stop()
+		})
+
+		proto := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+		start := time.Now()
+		sendOneways(t, proto, 6)
+		if err := echoCall(t, proto, 10, "sync"); err != nil {
+			t.Fatal(err)
+		}
+		// 5 requests over the burst, at 50 per second.
+		if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+			t.Errorf("expected the oneway requests to be slowed down, took %v", elapsed)
+		}
+		if got := atomic.LoadInt32(&oneways); got != 6 {
+			t.Errorf("expected 6 processed oneway requests, got %d", got)
+		}
+	})
+}
+
+// firstResponseHeaderProcessor wraps a mockProcessor, setting a response
+// header in the first request only.
+type firstResponseHeaderProcessor struct {
+	*mockProcessor
+
+	calls int32
+}
+
+func (p *firstResponseHeaderProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	if atomic.AddInt32(&p.calls, 1) == 1 && !SetResponseHeader(ctx, "shard", "7") {
+		return false, NewTApplicationException(INTERNAL_ERROR, "SetResponseHeader failed")
+	}
+	return p.mockProcessor.Process(ctx, in, out)
+}
+
+func TestServerResponseHeaders(t *testing.T) {
+	serv, addr := startTestSocketServer(t, &firstResponseHeaderProcessor{mockProcessor: echoProcessor()}, func(s *TSimpleServer) {
+		s.inputProtocolFactory = NewTHeaderProtocolFactoryConf(nil)
+		s.outputProtocolFactory = s.inputProtocolFactory
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	proto := NewTHeaderProtocolConf(dialTestSocketServer(t, addr), nil)
+	if err := echoCall(t, proto, 1, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if got := proto.GetReadHeaders()["shard"]; got != "7" {
+		t.Errorf("expected shard response header, got %q", got)
+	}
+	// The header is only sent with the reply of the request that set it.
+	if err := echoCall(t, proto, 2, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := proto.GetReadHeaders()["shard"]; ok {
+		t.Errorf("expected no shard response header, got %q", got)
+	}
+}
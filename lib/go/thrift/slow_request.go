@@ -0,0 +1,184 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// maxStacksSize bounds the goroutine stacks captured for a slow request.
+const maxStacksSize = 64 << 20
+
+// SlowRequestOptions configures the slow request watchdog of a TSimpleServer,
+// see TSimpleServer.SetSlowRequestWatchdog.
+type SlowRequestOptions struct {
+	// Threshold is the latency over which a request is reported as slow,
+	// <= 0 to only watch the methods listed in Methods.
+	Threshold time.Duration
+
+	// Methods are the thresholds of the methods overriding Threshold, by
+	// method name as read from the wire (e.g. "Service:method" with
+	// TMultiplexedProcessor). A threshold <= 0 disables the watchdog for
+	// the method.
+	Methods map[string]time.Duration
+
+	// CaptureStacks makes the watchdog capture the stacks of all the
+	// goroutines when a request crosses its threshold, while it's still
+	// running, so that they show where it's stuck. It stops the world for
+	// the time of the capture, so it should only be enabled while
+	// investigating.
+	CaptureStacks bool
+
+	// Handler is called with the slow requests. If nil, they're formatted
+	// with SlowRequest.String and passed to the logger of the server.
+	Handler func(ctx context.Context, req SlowRequest)
+}
+
+// SlowRequest is a request reported by the slow request watchdog.
+//
+// Every slow request is reported twice: once when it crosses its threshold
+// while still running, with Stacks if captured, and once when it's done.
+type SlowRequest struct {
+	Method string
+	SeqID  int32
+
+	// Peer is the address of the client, nil if unknown.
+	Peer net.Addr
+
+	Start     time.Time
+	Threshold time.Duration
+
+	// Elapsed is the time since Start when reported, the latency of the
+	// request if Done.
+	Elapsed time.Duration
+	Done    bool
+
+	// Stacks are the stacks of all the goroutines, as formatted by
+	// runtime.Stack, when SlowRequestOptions.CaptureStacks is set and the
+	// request is not Done.
+	Stacks []byte
+}
+
+// String formats the request without its Stacks.
+func (r SlowRequest) String() string {
+	var sb strings.Builder
+	if r.Done {
+		sb.WriteString("slow request done:")
+	} else {
+		sb.WriteString("slow request running:")
+	}
+	fmt.Fprintf(&sb, " method=%q seqid=%d", r.Method, r.SeqID)
+	if r.Peer != nil {
+		fmt.Fprintf(&sb, " peer=%s", r.Peer)
+	}
+	fmt.Fprintf(&sb, " elapsed=%s threshold=%s", r.Elapsed, r.Threshold)
+	return sb.String()
+}
+
+// SetSlowRequestWatchdog enables the reporting of the requests whose latency
+// exceeds a threshold, for debugging tail latency.
+//
+// It must be called before Serve or AcceptLoop.
+func (p *TSimpleServer) SetSlowRequestWatchdog(opts SlowRequestOptions) {
+	p.slowRequests = &opts
+}
+
+// threshold returns the threshold of method, <= 0 if not watched.
+func (opts *SlowRequestOptions) threshold(method string) time.Duration {
+	if threshold, ok := opts.Methods[method]; ok {
+		return threshold
+	}
+	return opts.Threshold
+}
+
+// tSlowRequestProcessor reports the slow requests of a connection.
+type tSlowRequestProcessor struct {
+	TProcessor
+
+	server *TSimpleServer
+}
+
+func (p *tSlowRequestProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	in = NewStoredMessageProtocol(in, name, typeID, seqID)
+	opts := p.server.slowRequests
+	threshold := opts.threshold(name)
+	if threshold <= 0 {
+		return p.TProcessor.Process(ctx, in, out)
+	}
+	req := SlowRequest{
+		Method:    name,
+		SeqID:     seqID,
+		Start:     time.Now(),
+		Threshold: threshold,
+	}
+	req.Peer, _ = RemoteAddrFromContext(ctx)
+	reported := make(chan struct{})
+	timer := time.AfterFunc(threshold, func() {
+		defer close(reported)
+		running := req
+		running.Elapsed = time.Since(req.Start)
+		if opts.CaptureStacks {
+			running.Stacks = goroutineStacks()
+		}
+		p.report(ctx, running)
+	})
+	defer func() {
+		if !timer.Stop() {
+			// Reported as done after being reported as running.
+			<-reported
+			done := req
+			done.Elapsed = time.Since(req.Start)
+			done.Done = true
+			p.report(ctx, done)
+		}
+	}()
+	return p.TProcessor.Process(ctx, in, out)
+}
+
+func (p *tSlowRequestProcessor) report(ctx context.Context, req SlowRequest) {
+	if handler := p.server.slowRequests.Handler; handler != nil {
+		handler(ctx, req)
+		return
+	}
+	fallbackLogger(p.server.logger)(req.String())
+}
+
+// goroutineStacks returns the stacks of all the goroutines.
+func goroutineStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStacksSize {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+var _ TProcessor = (*tSlowRequestProcessor)(nil)
@@ -0,0 +1,88 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSlowRequestWatchdog(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	reports := make(chan SlowRequest, 2)
+	serv, addr := startTestSocketServer(t, blockingEchoProcessor(started, release), func(s *TSimpleServer) {
+		s.SetSlowRequestWatchdog(SlowRequestOptions{
+			Threshold: time.Hour,
+			Methods: map[string]time.Duration{
+				"echo": 10 * time.Millisecond,
+			},
+			CaptureStacks: true,
+			Handler: func(ctx context.Context, req SlowRequest) {
+				reports <- req
+			},
+		})
+	})
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- echoCall(t, NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil), 1, "slow")
+	}()
+	<-started
+	running := <-reports
+	if running.Done || running.Method != "echo" || running.SeqID != 1 || running.Peer == nil || running.Elapsed < running.Threshold {
+		t.Errorf("unexpected running report %+v", running)
+	}
+	if !bytes.Contains(running.Stacks, []byte("blockingEchoProcessor")) {
+		t.Errorf("expected the stacks to show the blocked handler, got:\n%s", running.Stacks)
+	}
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	done := <-reports
+	if !done.Done || done.Stacks != nil || done.Elapsed < running.Elapsed {
+		t.Errorf("unexpected done report %+v", done)
+	}
+}
+
+func TestSlowRequestThreshold(t *testing.T) {
+	opts := &SlowRequestOptions{
+		Threshold: time.Second,
+		Methods: map[string]time.Duration{
+			"fast":   time.Millisecond,
+			"ignore": 0,
+		},
+	}
+	for method, expected := range map[string]time.Duration{
+		"other":  time.Second,
+		"fast":   time.Millisecond,
+		"ignore": 0,
+	} {
+		if threshold := opts.threshold(method); threshold != expected {
+			t.Errorf("%s: expected threshold %v, got %v", method, expected, threshold)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// tSNIProcessorFactory routes connections by their TLS server name.
+type tSNIProcessorFactory struct {
+	processors map[string]TProcessor
+	fallback   TProcessor
+}
+
+// NewTSNIProcessorFactory returns a TProcessorFactory routing every TLS
+// connection to a processor by the server name (SNI) requested by the client,
+// so that multiple services can share the same port.
+//
+// The keys of processors are server names, either exact (e.g.
+// "users.example.com") or wildcards matching a single label (e.g.
+// "*.example.com"), compared case-insensitively.
+//
+// The connections without a server name, not using TLS, or whose server name
+// is not in processors, get fallback. If fallback is nil, their first request
+// is rejected with an INTERNAL_ERROR TApplicationException, then the
+// connection is closed.
+//
+// To also serve a different certificate per server name, set
+// GetConfigForClient in the tls.Config of the TSSLServerSocket.
+func NewTSNIProcessorFactory(processors map[string]TProcessor, fallback TProcessor) TProcessorFactory {
+	f := &tSNIProcessorFactory{
+		processors: make(map[string]TProcessor, len(processors)),
+		fallback:   fallback,
+	}
+	for name, processor := range processors {
+		f.processors[strings.ToLower(name)] = processor
+	}
+	return f
+}
+
+func (f *tSNIProcessorFactory) GetProcessor(trans TTransport) TProcessor {
+	return &tSNIProcessor{
+		factory: f,
+		trans:   trans,
+	}
+}
+
+// route returns the processor of the server name.
+func (f *tSNIProcessorFactory) route(name string) TProcessor {
+	if processor := f.lookup(name); processor != nil {
+		return processor
+	}
+	if f.fallback != nil {
+		return f.fallback
+	}
+	return &tRejectingProcessor{
+		exc: NewTApplicationException(INTERNAL_ERROR, fmt.Sprintf("no processor for server name %q", name)),
+	}
+}
+
+func (f *tSNIProcessorFactory) lookup(name string) TProcessor {
+	if name == "" {
+		return nil
+	}
+	name = strings.ToLower(name)
+	if processor, ok := f.processors[name]; ok {
+		return processor
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return f.processors["*"+name[i:]]
+	}
+	return nil
+}
+
+// tSNIProcessor is the processor of a connection of a tSNIProcessorFactory.
+//
+// The TLS handshake is done on the first read of the connection, so the
+// processor is only chosen once the message begin of the first request is
+// read, instead of when the connection is accepted.
+type tSNIProcessor struct {
+	factory   *tSNIProcessorFactory
+	trans     TTransport
+	processor TProcessor
+}
+
+func (p *tSNIProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	if p.processor != nil {
+		return p.processor.Process(ctx, in, out)
+	}
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	p.processor = p.factory.route(tlsServerName(p.trans))
+	return p.processor.Process(ctx, NewStoredMessageProtocol(in, name, typeID, seqID), out)
+}
+
+func (p *tSNIProcessor) ProcessorMap() map[string]TProcessorFunction {
+	if p.processor == nil {
+		return nil
+	}
+	return p.processor.ProcessorMap()
+}
+
+func (p *tSNIProcessor) AddToProcessorMap(name string, f TProcessorFunction) {
+	if p.processor != nil {
+		p.processor.AddToProcessorMap(name, f)
+	}
+}
+
+// tlsServerName returns the server name requested by the client of trans, ""
+// if trans is not a TLS connection.
+func tlsServerName(trans TTransport) string {
+	c, ok := trans.(interface{ Conn() net.Conn })
+	if !ok {
+		return ""
+	}
+	conn := c.Conn()
+	if sc, ok := conn.(*socketConn); ok && sc != nil {
+		conn = sc.Conn
+	}
+	tlsConn, ok := conn.(interface{ ConnectionState() tls.ConnectionState })
+	if !ok {
+		return ""
+	}
+	return tlsConn.ConnectionState().ServerName
+}
+
+var (
+	_ TProcessorFactory = (*tSNIProcessorFactory)(nil)
+	_ TProcessor        = (*tSNIProcessor)(nil)
+)
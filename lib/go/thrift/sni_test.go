@@ -0,0 +1,123 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestSNIProcessorFactory(t *testing.T) {
+	cert := selfSignedCertificate(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTrans := &mockServerTransport{
+		ListenFunc: func() error {
+			return nil
+		},
+		AcceptFunc: func() (TTransport, error) {
+			conn, err := listener.Accept()
+			if err != nil {
+				return nil, err
+			}
+			return NewTSSLSocketFromConnConf(conn, nil), nil
+		},
+		CloseFunc: func() error {
+			return listener.Close()
+		},
+		InterruptFunc: func() error {
+			return listener.Close()
+		},
+	}
+	newProcessor := func() *contextCapturingProcessor {
+		return &contextCapturingProcessor{
+			mockProcessor: echoProcessor(),
+			ctxs:          make(chan context.Context, 10),
+		}
+	}
+	users, orders := newProcessor(), newProcessor()
+	serv := NewTSimpleServerFactory2(NewTSNIProcessorFactory(map[string]TProcessor{
+		"users.example.com":    users,
+		"*.orders.example.com": orders,
+	}, nil), serverTrans)
+	serv.SetLogger(func(string) {})
+	go serv.AcceptLoop()
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+
+	dial := func(t *testing.T, serverName string) TProtocol {
+		t.Helper()
+		sock, err := NewTSSLSocketConf(listener.Addr().String(), &TConfiguration{
+			SocketTimeout: 5 * time.Second,
+			TLSConfig: &tls.Config{
+				ServerName:         serverName,
+				InsecureSkipVerify: true,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sock.Open(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			sock.Close()
+		})
+		return NewTBinaryProtocolConf(sock, nil)
+	}
+
+	for _, c := range []struct {
+		serverName string
+		expected   *contextCapturingProcessor
+	}{
+		{"users.example.com", users},
+		{"USERS.example.com", users},
+		{"eu.orders.example.com", orders},
+	} {
+		proto := dial(t, c.serverName)
+		for seqID := int32(1); seqID <= 2; seqID++ {
+			if err := echoCall(t, proto, seqID, c.serverName); err != nil {
+				t.Fatalf("%s: %v", c.serverName, err)
+			}
+			select {
+			case <-c.expected.ctxs:
+			case <-time.After(time.Second):
+				t.Errorf("%s: request routed to the wrong processor", c.serverName)
+			}
+		}
+	}
+
+	for _, serverName := range []string{"orders.example.com", "unknown.example.com"} {
+		exc, err := callExpectingException(dial(t, serverName), 1)
+		if err != nil {
+			t.Fatalf("%s: %v", serverName, err)
+		}
+		if exc.TypeId() != INTERNAL_ERROR {
+			t.Errorf("%s: expected INTERNAL_ERROR, got %v", serverName, exc)
+		}
+	}
+}
@@ -21,6 +21,8 @@ package thrift
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net"
 	"time"
 )
@@ -32,6 +34,14 @@ type TSocket struct {
 
 	connectTimeout time.Duration
 	socketTimeout  time.Duration
+
+	// opened is when the connection was opened by Open, zero if it wasn't.
+	opened time.Time
+
+	// readDeadline and writeDeadline bound the reads and writes in addition
+	// to the socket timeout, see setReadDeadline and setWriteDeadline.
+	readDeadline  time.Time
+	writeDeadline time.Time
 }
 
 // Deprecated: Use NewTSocketConf instead.
@@ -140,14 +150,29 @@ func (p *TSocket) pushDeadline(read, write bool) {
 	if read && write {
 		p.conn.SetDeadline(t)
 	} else if read {
-		p.conn.SetReadDeadline(t)
+		p.conn.SetReadDeadline(earliestDeadline(t, p.readDeadline))
 	} else if write {
-		p.conn.SetWriteDeadline(t)
+		p.conn.SetWriteDeadline(earliestDeadline(t, p.writeDeadline))
 	}
 }
 
+// setReadDeadline implements tDeadlineSocket.
+func (p *TSocket) setReadDeadline(t time.Time) {
+	p.readDeadline = t
+}
+
+// setWriteDeadline implements tDeadlineSocket.
+func (p *TSocket) setWriteDeadline(t time.Time) {
+	p.writeDeadline = t
+}
+
 // Connects the socket, creating a new socket object if necessary.
 func (p *TSocket) Open() error {
+	return p.openWithDeadline(time.Time{})
+}
+
+// openWithDeadline implements tDeadlineSocket.
+func (p *TSocket) openWithDeadline(deadline time.Time) error {
 	if p.conn.isValid() {
 		return NewTTransportException(ALREADY_OPEN, "Socket already connected.")
 	}
@@ -160,11 +185,15 @@ func (p *TSocket) Open() error {
 	if len(p.addr.String()) == 0 {
 		return NewTTransportException(NOT_OPEN, "Cannot open bad address.")
 	}
+	if timeout := p.cfg.GetConnectTimeout(); timeout > 0 {
+		deadline = earliestDeadline(deadline, time.Now().Add(timeout))
+	}
 	var err error
-	if p.conn, err = createSocketConnFromReturn(net.DialTimeout(
+	if p.conn, err = createSocketConnFromReturn(dialWithEvents(
+		p.cfg.GetConnectionListener(),
 		p.addr.Network(),
 		p.addr.String(),
-		p.cfg.GetConnectTimeout(),
+		deadline,
 	)); err != nil {
 		return &tTransportException{
 			typeId: NOT_OPEN,
@@ -172,9 +201,18 @@ func (p *TSocket) Open() error {
 			msg:    err.Error(),
 		}
 	}
+	p.opened = time.Now()
 	return nil
 }
 
+// address returns the address of the socket for its TConnectionEvents.
+func (p *TSocket) address() string {
+	if p.addr == nil {
+		return ""
+	}
+	return p.addr.String()
+}
+
 // Retrieve the underlying net.Conn
 func (p *TSocket) Conn() net.Conn {
 	return p.conn
@@ -194,6 +232,10 @@ func (p *TSocket) Close() error {
 			return err
 		}
 		p.conn = nil
+		if !p.opened.IsZero() {
+			notifyConnectionEvent(p.cfg.GetConnectionListener(), ConnectionClose, p.address(), time.Since(p.opened), nil)
+			p.opened = time.Time{}
+		}
 	}
 	return nil
 }
@@ -212,6 +254,9 @@ func (p *TSocket) Read(buf []byte) (int, error) {
 	// p.pushDeadline and p.conn.Read could cause the deadline set inside
 	// p.pushDeadline being reset, thus need to be avoided.
 	n, err := p.conn.Read(buf)
+	if err != nil {
+		p.notifyError(err)
+	}
 	return n, NewTTransportExceptionFromError(err)
 }
 
@@ -220,7 +265,25 @@ func (p *TSocket) Write(buf []byte) (int, error) {
 		return 0, NewTTransportException(NOT_OPEN, "Connection not open")
 	}
 	p.pushDeadline(false, true)
-	return p.conn.Write(buf)
+	n, err := p.conn.Write(buf)
+	if err != nil {
+		p.notifyError(err)
+	}
+	return n, err
+}
+
+// notifyError notifies the TConnectionListener of the failed read or write of
+// a connection opened by Open, io.EOF being the peer closing the connection.
+func (p *TSocket) notifyError(err error) {
+	if p.opened.IsZero() {
+		return
+	}
+	if errors.Is(err, io.EOF) {
+		notifyConnectionEvent(p.cfg.GetConnectionListener(), ConnectionClose, p.address(), time.Since(p.opened), nil)
+		p.opened = time.Time{}
+		return
+	}
+	notifyConnectionEvent(p.cfg.GetConnectionListener(), ConnectionError, p.address(), 0, err)
 }
 
 func (p *TSocket) Flush(ctx context.Context) error {
@@ -0,0 +1,162 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+)
+
+// SQLOptions configures the encoding of the structs of TSQLStruct, which
+// must be the same to write and to scan them.
+type SQLOptions struct {
+	// Compressor compresses the blobs, e.g. the one of NewZlibCompressor.
+	// They aren't compressed if it's nil.
+	Compressor Compressor
+
+	// Fingerprint wraps the blobs in envelopes (see MarshalEnvelope), so
+	// that Scan fails with ErrEnvelopeSchemaMismatch for the ones written
+	// with another schema than the one of the struct.
+	Fingerprint bool
+
+	// Configuration is the TConfiguration of the compact protocol of the
+	// blobs scanned. Only its MaxMessageSize applies to the envelopes, also
+	// limiting their decompressed size.
+	Configuration *TConfiguration
+}
+
+var (
+	sqlSerializers   = NewTSerializerPoolSizeFactory(1024, NewTCompactProtocolFactoryConf(nil))
+	sqlDeserializers = NewTDeserializerPoolSizeFactory(1024, NewTCompactProtocolFactoryConf(nil))
+)
+
+// TSQLStruct stores a struct of type T, for example *User, in a binary SQL
+// column, serialized with the compact protocol, as a driver.Valuer and a
+// sql.Scanner:
+//
+//	_, err := db.ExecContext(ctx, "INSERT INTO users (id, data) VALUES (?, ?)", id, thrift.NewTSQLStruct(user, opts))
+//	...
+//	data := thrift.NewTSQLStruct(&User{}, opts)
+//	err := db.QueryRowContext(ctx, "SELECT data FROM users WHERE id = ?", id).Scan(data)
+//
+// A nil Struct is stored as NULL, and NULL is scanned as a nil Struct.
+type TSQLStruct[T TStruct] struct {
+	Struct  T
+	Options SQLOptions
+}
+
+var (
+	_ driver.Valuer = TSQLStruct[TStruct]{}
+	_ sql.Scanner   = (*TSQLStruct[TStruct])(nil)
+)
+
+// NewTSQLStruct returns the TSQLStruct of msg.
+func NewTSQLStruct[T TStruct](msg T, opts SQLOptions) *TSQLStruct[T] {
+	return &TSQLStruct[T]{
+		Struct:  msg,
+		Options: opts,
+	}
+}
+
+// Value implements driver.Valuer.
+func (s TSQLStruct[T]) Value() (driver.Value, error) {
+	if isNilSQLStruct(s.Struct) {
+		return nil, nil
+	}
+	ctx := context.Background()
+	if !s.Options.Fingerprint {
+		if s.Options.Compressor != nil {
+			return sqlSerializers.WriteCompressed(ctx, s.Struct, s.Options.Compressor)
+		}
+		return sqlSerializers.Write(ctx, s.Struct)
+	}
+	b, err := MarshalEnvelope(ctx, s.Struct, THeaderProtocolCompact)
+	if err != nil || s.Options.Compressor == nil {
+		return b, err
+	}
+	return compressBytes(s.Options.Compressor, b)
+}
+
+// Scan implements sql.Scanner. It allocates Struct if it's nil.
+func (s *TSQLStruct[T]) Scan(src interface{}) error {
+	var b []byte
+	switch src := src.(type) {
+	case nil:
+		var zero T
+		s.Struct = zero
+		return nil
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	default:
+		return fmt.Errorf("thrift: cannot scan %T into %T", src, s.Struct)
+	}
+	if isNilSQLStruct(s.Struct) {
+		t := reflect.TypeOf((*T)(nil)).Elem()
+		if t.Kind() != reflect.Ptr {
+			return fmt.Errorf("thrift: cannot allocate a %s to scan into", t)
+		}
+		s.Struct = reflect.New(t.Elem()).Interface().(T)
+	}
+	ctx := context.Background()
+	if s.Options.Fingerprint {
+		max := int64(s.Options.Configuration.GetMaxMessageSize())
+		if s.Options.Compressor != nil {
+			var err error
+			if b, err = decompressBytes(s.Options.Compressor, b, max); err != nil {
+				return err
+			}
+		}
+		if int64(len(b)) > max {
+			return NewTProtocolExceptionWithType(SIZE_LIMIT, fmt.Errorf("envelope larger than %d bytes", max))
+		}
+		return UnmarshalEnvelope(ctx, b, s.Struct)
+	}
+	var d interface {
+		Read(ctx context.Context, msg TStruct, b []byte) error
+		ReadCompressed(ctx context.Context, msg TStruct, b []byte, c Compressor) error
+	} = sqlDeserializers
+	if s.Options.Configuration != nil {
+		transport := NewTMemoryBufferLen(len(b))
+		factory := NewTCompactProtocolFactoryConf(s.Options.Configuration)
+		d = &TDeserializer{
+			Transport:       transport,
+			Protocol:        factory.GetProtocol(transport),
+			ProtocolFactory: factory,
+		}
+	}
+	if s.Options.Compressor != nil {
+		return d.ReadCompressed(ctx, s.Struct, b, s.Options.Compressor)
+	}
+	return d.Read(ctx, s.Struct, b)
+}
+
+// isNilSQLStruct reports whether msg is a nil interface or pointer.
+func isNilSQLStruct(msg TStruct) bool {
+	v := reflect.ValueOf(msg)
+	return !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil())
+}
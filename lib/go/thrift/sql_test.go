@@ -0,0 +1,111 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"compress/zlib"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSQLStruct(t *testing.T) {
+	msg := &MyTestStruct{
+		St:         strings.Repeat("stored ", 100),
+		StringList: []string{"a", "b"},
+		StringSet:  map[string]struct{}{"c": {}},
+		E:          MyTestEnum_THIRD,
+	}
+	zlibCompressor, err := NewZlibCompressor(zlib.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for label, opts := range map[string]SQLOptions{
+		"plain":                  {},
+		"compressed":             {Compressor: zlibCompressor},
+		"fingerprint":            {Fingerprint: true},
+		"compressed fingerprint": {Compressor: zlibCompressor, Fingerprint: true},
+		"configuration":          {Configuration: &TConfiguration{MaxMessageSize: 10000}},
+	} {
+		t.Run(label, func(t *testing.T) {
+			value, err := NewTSQLStruct(msg, opts).Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !driver.IsValue(value) {
+				t.Fatalf("expected a driver.Value, got %T", value)
+			}
+			scanned := NewTSQLStruct[*MyTestStruct](nil, opts)
+			if err := scanned.Scan(value); err != nil {
+				t.Fatal(err)
+			}
+			if !Equal(scanned.Struct, msg) {
+				t.Errorf("expected %v, got %v", msg, scanned.Struct)
+			}
+			// The drivers may return strings too.
+			scanned = NewTSQLStruct(&MyTestStruct{}, opts)
+			if err := scanned.Scan(string(value.([]byte))); err != nil {
+				t.Fatal(err)
+			}
+			if !Equal(scanned.Struct, msg) {
+				t.Errorf("expected %v, got %v", msg, scanned.Struct)
+			}
+
+			small := opts
+			small.Configuration = &TConfiguration{MaxMessageSize: 100}
+			var te TProtocolException
+			if err := NewTSQLStruct(&MyTestStruct{}, small).Scan(value); !errors.As(err, &te) || te.TypeId() != SIZE_LIMIT {
+				t.Errorf("expected a SIZE_LIMIT TProtocolException, got %v", err)
+			}
+		})
+	}
+
+	t.Run("NULL", func(t *testing.T) {
+		value, err := TSQLStruct[*MyTestStruct]{}.Value()
+		if value != nil || err != nil {
+			t.Errorf("expected NULL, got %v, %v", value, err)
+		}
+		s := NewTSQLStruct(msg, SQLOptions{})
+		if err := s.Scan(nil); err != nil || s.Struct != nil {
+			t.Errorf("expected a nil Struct, got %v, %v", s.Struct, err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		opts := SQLOptions{Fingerprint: true}
+		value, err := NewTSQLStruct(ReflectStruct(&reflectTestUser{ID: 1}), opts).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewTSQLStruct(&MyTestStruct{}, opts).Scan(value); !errors.Is(err, ErrEnvelopeSchemaMismatch) {
+			t.Errorf("expected ErrEnvelopeSchemaMismatch, got %v", err)
+		}
+		if err := NewTSQLStruct(&MyTestStruct{}, SQLOptions{}).Scan(42); err == nil {
+			t.Error("expected an error scanning an integer")
+		}
+		if err := NewTSQLStruct[TStruct](nil, SQLOptions{}).Scan([]byte{0}); err == nil {
+			t.Error("expected an error scanning into an interface")
+		}
+	})
+}
@@ -21,6 +21,8 @@ package thrift
 
 import (
 	"context"
+	"errors"
+	"io"
 	"crypto/tls"
 	"net"
 	"time"
@@ -36,6 +38,14 @@ type TSSLSocket struct {
 	addr net.Addr
 
 	cfg *TConfiguration
+
+	// opened is when the connection was opened by Open, zero if it wasn't.
+	opened time.Time
+
+	// readDeadline and writeDeadline bound the reads and writes in addition
+	// to the socket timeout, see setReadDeadline and setWriteDeadline.
+	readDeadline  time.Time
+	writeDeadline time.Time
 }
 
 // NewTSSLSocketConf creates a net.Conn-backed TTransport, given a host and port.
@@ -147,26 +157,37 @@ func (p *TSSLSocket) pushDeadline(read, write bool) {
 	if read && write {
 		p.conn.SetDeadline(t)
 	} else if read {
-		p.conn.SetReadDeadline(t)
+		p.conn.SetReadDeadline(earliestDeadline(t, p.readDeadline))
 	} else if write {
-		p.conn.SetWriteDeadline(t)
+		p.conn.SetWriteDeadline(earliestDeadline(t, p.writeDeadline))
 	}
 }
 
+// setReadDeadline implements tDeadlineSocket.
+func (p *TSSLSocket) setReadDeadline(t time.Time) {
+	p.readDeadline = t
+}
+
+// setWriteDeadline implements tDeadlineSocket.
+func (p *TSSLSocket) setWriteDeadline(t time.Time) {
+	p.writeDeadline = t
+}
+
 // Connects the socket, creating a new socket object if necessary.
 func (p *TSSLSocket) Open() error {
+	return p.openWithDeadline(time.Time{})
+}
+
+// openWithDeadline implements tDeadlineSocket.
+func (p *TSSLSocket) openWithDeadline(deadline time.Time) error {
+	if timeout := p.cfg.GetConnectTimeout(); timeout > 0 {
+		deadline = earliestDeadline(deadline, time.Now().Add(timeout))
+	}
 	var err error
 	// If we have a hostname, we need to pass the hostname to tls.Dial for
 	// certificate hostname checks.
 	if p.hostPort != "" {
-		if p.conn, err = createSocketConnFromReturn(tls.DialWithDialer(
-			&net.Dialer{
-				Timeout: p.cfg.GetConnectTimeout(),
-			},
-			"tcp",
-			p.hostPort,
-			p.cfg.GetTLSConfig(),
-		)); err != nil {
+		if p.conn, err = createSocketConnFromReturn(p.dial("tcp", p.hostPort, deadline)); err != nil {
 			return &tTransportException{
 				typeId: NOT_OPEN,
 				err:    err,
@@ -186,14 +207,7 @@ func (p *TSSLSocket) Open() error {
 		if len(p.addr.String()) == 0 {
 			return NewTTransportException(NOT_OPEN, "Cannot open bad address.")
 		}
-		if p.conn, err = createSocketConnFromReturn(tls.DialWithDialer(
-			&net.Dialer{
-				Timeout: p.cfg.GetConnectTimeout(),
-			},
-			p.addr.Network(),
-			p.addr.String(),
-			p.cfg.GetTLSConfig(),
-		)); err != nil {
+		if p.conn, err = createSocketConnFromReturn(p.dial(p.addr.Network(), p.addr.String(), deadline)); err != nil {
 			return &tTransportException{
 				typeId: NOT_OPEN,
 				err:    err,
@@ -201,9 +215,34 @@ func (p *TSSLSocket) Open() error {
 			}
 		}
 	}
+	p.opened = time.Now()
 	return nil
 }
 
+// dial dials address and does the TLS handshake before deadline, notifying
+// the TConnectionListener if any.
+func (p *TSSLSocket) dial(network, address string, deadline time.Time) (net.Conn, error) {
+	if listener := p.cfg.GetConnectionListener(); listener != nil {
+		return dialTLSWithEvents(listener, network, address, deadline, p.cfg.GetTLSConfig())
+	}
+	return tls.DialWithDialer(
+		&net.Dialer{
+			Deadline: deadline,
+		},
+		network,
+		address,
+		p.cfg.GetTLSConfig(),
+	)
+}
+
+// address returns the address of the socket for its TConnectionEvents.
+func (p *TSSLSocket) address() string {
+	if p.hostPort != "" || p.addr == nil {
+		return p.hostPort
+	}
+	return p.addr.String()
+}
+
 // Retrieve the underlying net.Conn
 func (p *TSSLSocket) Conn() net.Conn {
 	return p.conn
@@ -223,6 +262,10 @@ func (p *TSSLSocket) Close() error {
 			return err
 		}
 		p.conn = nil
+		if !p.opened.IsZero() {
+			notifyConnectionEvent(p.cfg.GetConnectionListener(), ConnectionClose, p.address(), time.Since(p.opened), nil)
+			p.opened = time.Time{}
+		}
 	}
 	return nil
 }
@@ -236,6 +279,9 @@ func (p *TSSLSocket) Read(buf []byte) (int, error) {
 	// p.pushDeadline and p.conn.Read could cause the deadline set inside
 	// p.pushDeadline being reset, thus need to be avoided.
 	n, err := p.conn.Read(buf)
+	if err != nil {
+		p.notifyError(err)
+	}
 	return n, NewTTransportExceptionFromError(err)
 }
 
@@ -244,7 +290,25 @@ func (p *TSSLSocket) Write(buf []byte) (int, error) {
 		return 0, NewTTransportException(NOT_OPEN, "Connection not open")
 	}
 	p.pushDeadline(false, true)
-	return p.conn.Write(buf)
+	n, err := p.conn.Write(buf)
+	if err != nil {
+		p.notifyError(err)
+	}
+	return n, err
+}
+
+// notifyError notifies the TConnectionListener of the failed read or write of
+// a connection opened by Open, io.EOF being the peer closing the connection.
+func (p *TSSLSocket) notifyError(err error) {
+	if p.opened.IsZero() {
+		return
+	}
+	if errors.Is(err, io.EOF) {
+		notifyConnectionEvent(p.cfg.GetConnectionListener(), ConnectionClose, p.address(), time.Since(p.opened), nil)
+		p.opened = time.Time{}
+		return
+	}
+	notifyConnectionEvent(p.cfg.GetConnectionListener(), ConnectionError, p.address(), 0, err)
 }
 
 func (p *TSSLSocket) Flush(ctx context.Context) error {
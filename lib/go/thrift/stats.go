@@ -0,0 +1,214 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"time"
+)
+
+// TStatsHandler is notified of the stats of the requests of a client or a
+// server, so that any metrics backend can be wired in.
+//
+// See TSimpleServer.SetStatsHandler and TStandardClient.SetStatsHandler.
+type TStatsHandler interface {
+	// TagRequest is called when a request begins, the returned context is
+	// passed to the HandleStats calls of the request and, on servers, to
+	// the handler.
+	TagRequest(ctx context.Context, info TStatsRequestInfo) context.Context
+
+	// HandleStats is called with every stats event of a request.
+	HandleStats(ctx context.Context, stats TStats)
+}
+
+// TStatsRequestInfo describes the request passed to TStatsHandler.TagRequest.
+type TStatsRequestInfo struct {
+	Client bool
+	Method string
+	SeqID  int32
+}
+
+// TStats is a stats event passed to TStatsHandler.HandleStats, one of
+// *TStatsBegin, *TStatsInPayload, *TStatsOutPayload and *TStatsEnd.
+type TStats interface {
+	// IsClient reports whether the event is from a client, false for a
+	// server.
+	IsClient() bool
+}
+
+// TStatsBegin is reported when a request begins: on clients before it's
+// sent, on servers once its message begin is read.
+type TStatsBegin struct {
+	Client    bool
+	BeginTime time.Time
+}
+
+// TStatsInPayload is reported when a message is received: on clients the
+// reply, on servers the request, once its handler returned.
+type TStatsInPayload struct {
+	Client bool
+
+	// Length is the number of bytes of the message, including the framing
+	// of the transport if any, -1 if unknown.
+	Length   int64
+	RecvTime time.Time
+}
+
+// TStatsOutPayload is reported when a message is sent: on clients the
+// request, on servers the reply. It's not reported for oneway requests on
+// servers.
+type TStatsOutPayload struct {
+	Client bool
+
+	// Length is the number of bytes of the message, including the framing
+	// of the transport if any, -1 if unknown.
+	Length   int64
+	SentTime time.Time
+}
+
+// TStatsEnd is reported when a request ends.
+type TStatsEnd struct {
+	Client    bool
+	BeginTime time.Time
+	EndTime   time.Time
+
+	// Err is the error of the request, if any.
+	Err error
+}
+
+// TClientCallStats are the stats of a call of a TStandardClient, see
+// SetCallStatsFunc.
+//
+// The durations of the phases of the call are measured around the protocol
+// and transport calls: with unbuffered transports, SerializeTime and
+// DeserializeTime also include the network time of the writes and reads.
+type TClientCallStats struct {
+	Method string
+	SeqID  int32
+
+	// Attempt is the number of the attempt of the call, see
+	// CallAttemptFromContext.
+	Attempt int
+
+	// Oneway reports whether the call is oneway, in which case no reply is
+	// read.
+	Oneway bool
+
+	// BytesSent and BytesReceived are the number of bytes of the request
+	// and of the reply, including the framing of the transport if any, -1
+	// if unknown.
+	BytesSent     int64
+	BytesReceived int64
+
+	// DialTime is the time spent opening the socket of the client, if it
+	// was closed.
+	DialTime time.Duration
+
+	// SerializeTime is the time spent writing the request to the protocol.
+	SerializeTime time.Duration
+
+	// SendTime is the time spent flushing the request to the network.
+	SendTime time.Duration
+
+	// WaitTime is the time spent waiting for the reply, from the end of
+	// the request until the beginning of the reply is read, which includes
+	// the processing time of the server.
+	WaitTime time.Duration
+
+	// DeserializeTime is the time spent reading the reply from the
+	// protocol.
+	DeserializeTime time.Duration
+
+	// Duration is the total time of the call.
+	Duration time.Duration
+
+	// Err is the error of the call, if any.
+	Err error
+}
+
+func (s *TStatsBegin) IsClient() bool      { return s.Client }
+func (s *TStatsInPayload) IsClient() bool  { return s.Client }
+func (s *TStatsOutPayload) IsClient() bool { return s.Client }
+func (s *TStatsEnd) IsClient() bool        { return s.Client }
+
+// tStatsProcessor reports the stats of the requests of a TSimpleServer
+// connection to its TStatsHandler.
+type tStatsProcessor struct {
+	TProcessor
+
+	handler TStatsHandler
+	// in and out count the bytes of the requests and replies.
+	in, out *tByteCountingTransport
+}
+
+func (p *tStatsProcessor) Process(ctx context.Context, in, out TProtocol) (bool, TException) {
+	name, typeID, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, WrapTException(err)
+	}
+	ctx = p.handler.TagRequest(ctx, TStatsRequestInfo{
+		Method: name,
+		SeqID:  seqID,
+	})
+	begin := time.Now()
+	p.handler.HandleStats(ctx, &TStatsBegin{BeginTime: begin})
+	ok, exc := p.TProcessor.Process(ctx, NewStoredMessageProtocol(in, name, typeID, seqID), out)
+	now := time.Now()
+	p.handler.HandleStats(ctx, &TStatsInPayload{
+		Length:   p.in.requestRead(),
+		RecvTime: now,
+	})
+	if written := p.out.requestWritten(); typeID != ONEWAY {
+		p.handler.HandleStats(ctx, &TStatsOutPayload{
+			Length:   written,
+			SentTime: now,
+		})
+	}
+	end := &TStatsEnd{
+		BeginTime: begin,
+		EndTime:   now,
+	}
+	if exc != nil {
+		end.Err = exc
+	}
+	p.handler.HandleStats(ctx, end)
+	return ok, exc
+}
+
+// countedBytes returns the bytes read or written by trans since the current
+// request began, -1 if it doesn't count them, see NewTByteCountingTransport.
+func countedBytes(trans TTransport, read bool) int64 {
+	t, ok := trans.(*tByteCountingTransport)
+	if !ok {
+		return -1
+	}
+	if read {
+		return t.requestRead()
+	}
+	return t.requestWritten()
+}
+
+var (
+	_ TStats     = (*TStatsBegin)(nil)
+	_ TStats     = (*TStatsInPayload)(nil)
+	_ TStats     = (*TStatsOutPayload)(nil)
+	_ TStats     = (*TStatsEnd)(nil)
+	_ TProcessor = (*tStatsProcessor)(nil)
+)
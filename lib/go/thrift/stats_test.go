@@ -0,0 +1,225 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoValue is the args and result of the echo processor.
+type echoValue struct {
+	value string
+}
+
+func (v *echoValue) Write(ctx context.Context, p TProtocol) error {
+	return p.WriteString(ctx, v.value)
+}
+
+func (v *echoValue) Read(ctx context.Context, p TProtocol) (err error) {
+	v.value, err = p.ReadString(ctx)
+	return err
+}
+
+type statsTagKey struct{}
+
+// recordingStatsHandler records the stats events as strings.
+type recordingStatsHandler struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (h *recordingStatsHandler) TagRequest(ctx context.Context, info TStatsRequestInfo) context.Context {
+	return context.WithValue(ctx, statsTagKey{}, fmt.Sprintf("%s/%d", info.Method, info.SeqID))
+}
+
+func (h *recordingStatsHandler) HandleStats(ctx context.Context, stats TStats) {
+	event := fmt.Sprintf("%v ", ctx.Value(statsTagKey{}))
+	switch s := stats.(type) {
+	case *TStatsBegin:
+		event += "begin"
+	case *TStatsInPayload:
+		event += fmt.Sprintf("in %d", s.Length)
+	case *TStatsOutPayload:
+		event += fmt.Sprintf("out %d", s.Length)
+	case *TStatsEnd:
+		event += fmt.Sprintf("end %v", s.Err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, event)
+}
+
+func (h *recordingStatsHandler) recorded() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.events...)
+}
+
+func TestStatsHandler(t *testing.T) {
+	serverStats := new(recordingStatsHandler)
+	serv, addr := startTestSocketServer(t, echoProcessor(), func(s *TSimpleServer) {
+		s.SetStatsHandler(serverStats)
+	})
+	clientStats := new(recordingStatsHandler)
+	proto := NewTBinaryProtocolConf(NewTByteCountingTransport(dialTestSocketServer(t, addr)), nil)
+	client := NewTStandardClient(proto, proto)
+	client.SetStatsHandler(clientStats)
+
+	var result echoValue
+	if _, err := client.Call(context.Background(), "echo", &echoValue{value: "hello"}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.value != "hello" {
+		t.Errorf("unexpected result %q", result.value)
+	}
+	proto.Transport().Close()
+	serv.Stop()
+
+	// The messages are 16 bytes of message begin and 9 bytes of string.
+	for _, c := range []struct {
+		side     string
+		handler  *recordingStatsHandler
+		expected string
+	}{
+		{"client", clientStats, "[echo/1 begin echo/1 out 25 echo/1 in 25 echo/1 end <nil>]"},
+		{"server", serverStats, "[echo/1 begin echo/1 in 25 echo/1 out 25 echo/1 end <nil>]"},
+	} {
+		if got := fmt.Sprint(c.handler.recorded()); got != c.expected {
+			t.Errorf("%s: expected events %s, got %s", c.side, c.expected, got)
+		}
+	}
+}
+
+func TestPayloadSizesWithStatsHandler(t *testing.T) {
+	// The access log sizes and the stats both count the whole requests.
+	var mu sync.Mutex
+	var sizes []int64
+	echo := echoProcessor()
+	serverStats := new(recordingStatsHandler)
+	serv, addr := startTestSocketServer(t, &mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			ok, err := echo.ProcessFunc(in, out)
+			request, response := PayloadSizes(in, out)
+			mu.Lock()
+			defer mu.Unlock()
+			sizes = append(sizes, request, response)
+			return ok, err
+		},
+	}, func(s *TSimpleServer) {
+		s.inputTransportFactory = AccessLogTransportFactory(NewTTransportFactory())
+		s.outputTransportFactory = s.inputTransportFactory
+		s.SetStatsHandler(serverStats)
+	})
+	proto := NewTBinaryProtocolConf(dialTestSocketServer(t, addr), nil)
+	client := NewTStandardClient(proto, proto)
+
+	for i := 0; i < 2; i++ {
+		var result echoValue
+		if _, err := client.Call(context.Background(), "echo", &echoValue{value: "hello"}, &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	proto.Transport().Close()
+	serv.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, expected := fmt.Sprint(sizes), "[25 25 25 25]"; got != expected {
+		t.Errorf("expected sizes %s, got %s", expected, got)
+	}
+	expected := "[echo/1 begin echo/1 in 25 echo/1 out 25 echo/1 end <nil> echo/2 begin echo/2 in 25 echo/2 out 25 echo/2 end <nil>]"
+	if got := fmt.Sprint(serverStats.recorded()); got != expected {
+		t.Errorf("expected events %s, got %s", expected, got)
+	}
+}
+
+func TestCallStatsFunc(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	echo := echoProcessor()
+	serv, addr := startTestSocketServer(t, &mockProcessor{
+		ProcessFunc: func(in, out TProtocol) (bool, TException) {
+			time.Sleep(delay)
+			return echo.ProcessFunc(in, out)
+		},
+	}, nil)
+	t.Cleanup(func() {
+		serv.Stop()
+	})
+	proto := NewTBinaryProtocolConf(NewTByteCountingTransport(dialTestSocketServer(t, addr)), nil)
+	client := NewTStandardClient(proto, proto)
+	var calls []TClientCallStats
+	client.SetCallStatsFunc(func(ctx context.Context, stats TClientCallStats) {
+		calls = append(calls, stats)
+	})
+
+	var result echoValue
+	if _, err := client.Call(context.Background(), "echo", &echoValue{value: "hello"}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("expected the stats of 1 call, got %d", len(calls))
+	}
+	stats := calls[0]
+	// The messages are 16 bytes of message begin and 9 bytes of string.
+	if stats.Method != "echo" || stats.SeqID != 1 || stats.Attempt != 1 || stats.Oneway || stats.BytesSent != 25 || stats.BytesReceived != 25 || stats.Err != nil {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.WaitTime < delay {
+		t.Errorf("expected the wait time to include the server time, got %v", stats.WaitTime)
+	}
+	if sum := stats.DialTime + stats.SerializeTime + stats.SendTime + stats.WaitTime + stats.DeserializeTime; sum > stats.Duration {
+		t.Errorf("expected the phases to add up to at most %v, got %v", stats.Duration, sum)
+	}
+
+	// The retries are reported as further attempts.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	sock, err := NewTSocketConf(listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := NewTBinaryProtocolConf(sock, nil)
+	failing := NewTStandardClient(closed, closed)
+	failing.SetCallStatsFunc(func(ctx context.Context, stats TClientCallStats) {
+		calls = append(calls, stats)
+	})
+	calls = nil
+	retrying := WrapClient(failing, RetryMiddleware(RetryPolicy{
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+		Retryable: func(string, error) bool {
+			return true
+		},
+	}))
+	if _, err := retrying.Call(context.Background(), "echo", &echoValue{value: "hello"}, &result); err == nil {
+		t.Fatal("expected the call to fail")
+	}
+	if len(calls) != 2 || calls[0].Attempt != 1 || calls[1].Attempt != 2 || calls[1].Err == nil {
+		t.Errorf("unexpected stats of the attempts %+v", calls)
+	}
+}
@@ -0,0 +1,542 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// MarshalStructJSON returns the JSON object of v, a generated struct or a
+// struct with thrift tags (see EncodeStruct), with its fields keyed by their
+// name in the IDL, independently of the TProtocol in use.
+//
+// Unlike encoding/json with the json tags of the generated structs, it
+// follows the semantics of thrift, like the protocols:
+//
+//   - the unset optional fields are omitted, the nil pointers, slices and
+//     maps, and the fields whose IsSet method returns false, while the other
+//     fields are written even if they're zero,
+//   - the encoding fails if a required field is unset, or if a union (a
+//     struct with a CountSetFields method) hasn't exactly one field set,
+//   - the enums are written as the names of their values, the binaries in
+//     base64, the sets as arrays, even the map[T]struct{} ones, and the maps as
+//     objects keyed by their scalar keys, as strings,
+//   - NaN and the infinities are written as the strings "NaN", "Infinity" and
+//     "-Infinity", like TJSONProtocol does.
+func MarshalStructJSON(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot encode %T as a struct", v)
+	}
+	var buf bytes.Buffer
+	if err := writeJSONStruct(&buf, addressable(rv)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalStructJSON decodes data, a JSON object as written by
+// MarshalStructJSON, into v, a non-nil pointer to a generated struct or to a
+// struct with thrift tags.
+//
+// The fields of data not in v are ignored, and the null ones are unset. The
+// decoding fails if a required field of v is not in data, or if v is a union
+// and data hasn't exactly one of its fields. The enums may be the names or
+// the numbers of their values.
+func UnmarshalStructJSON(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot decode a struct into %T", v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var x interface{}
+	if err := dec.Decode(&x); err != nil {
+		return err
+	}
+	return readJSONValue(rv.Elem(), x)
+}
+
+// addressable returns v, or a copy of it if it's not addressable, so that the
+// methods of its pointer can be called.
+func addressable(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr.Elem()
+}
+
+// isUnion reports whether t is a union of the generated code.
+func isUnion(t reflect.Type) bool {
+	_, ok := reflect.PtrTo(t).MethodByName("CountSetFields" + t.Name())
+	return ok
+}
+
+// isSetReflectField reports whether the field f of the struct v is set.
+func isSetReflectField(v reflect.Value, f tReflectField) bool {
+	fv := v.Field(f.index)
+	switch fv.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if fv.IsNil() {
+			return false
+		}
+	}
+	// The optional fields with a default value have an IsSet method
+	// comparing them to it.
+	isSet := v.Addr().MethodByName("IsSet" + v.Type().Field(f.index).Name)
+	if isSet.IsValid() {
+		if fn, ok := isSet.Interface().(func() bool); ok {
+			return fn()
+		}
+	}
+	return true
+}
+
+// isJSONSet reports whether the map type t is a set, with struct{} values.
+func isJSONSet(t reflect.Type) bool {
+	return t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0
+}
+
+func writeJSONStruct(buf *bytes.Buffer, v reflect.Value) error {
+	info, err := reflectStructInfo(v.Type())
+	if err != nil {
+		return err
+	}
+	set := 0
+	buf.WriteByte('{')
+	for _, f := range info.fields {
+		if !isSetReflectField(v, f) {
+			if f.required {
+				return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("required field %s of %s is not set", f.name, info.name))
+			}
+			continue
+		}
+		if set > 0 {
+			buf.WriteByte(',')
+		}
+		set++
+		writeJSONString(buf, f.name)
+		buf.WriteByte(':')
+		if err := writeJSONValue(buf, v.Field(f.index)); err != nil {
+			return PrependError(fmt.Sprintf("%s.%s: ", info.name, f.name), err)
+		}
+	}
+	buf.WriteByte('}')
+	if set != 1 && isUnion(v.Type()) {
+		return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("union %s must have exactly one field set, got %d", info.name, set))
+	}
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+func writeJSONValue(buf *bytes.Buffer, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeJSONValue(buf, v.Elem())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeJSONString(buf, base64.StdEncoding.EncodeToString(v.Bytes()))
+			return nil
+		}
+		buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONValue(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		entries := make(map[string][2]reflect.Value, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := jsonMapKey(iter.Key())
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			entries[key] = [2]reflect.Value{iter.Key(), iter.Value()}
+		}
+		// The keys are sorted for the encoding to be deterministic.
+		sort.Strings(keys)
+		if isJSONSet(v.Type()) {
+			buf.WriteByte('[')
+			for i, key := range keys {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if err := writeJSONValue(buf, entries[key][0]); err != nil {
+					return err
+				}
+			}
+			buf.WriteByte(']')
+			return nil
+		}
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, key)
+			buf.WriteByte(':')
+			if err := writeJSONValue(buf, entries[key][1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case reflect.Struct:
+		return writeJSONStruct(buf, addressable(v))
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		switch {
+		case math.IsNaN(f):
+			writeJSONString(buf, "NaN")
+		case math.IsInf(f, 1):
+			writeJSONString(buf, "Infinity")
+		case math.IsInf(f, -1):
+			writeJSONString(buf, "-Infinity")
+		default:
+			buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+		return nil
+	case reflect.String:
+		writeJSONString(buf, v.String())
+		return nil
+	}
+	s, err := jsonScalar(v)
+	if err != nil {
+		return err
+	}
+	if isReflectEnum(v.Type()) && s != strconv.FormatInt(v.Int(), 10) {
+		writeJSONString(buf, s)
+	} else {
+		buf.WriteString(s)
+	}
+	return nil
+}
+
+// jsonScalar returns the text of the bools and integers, the names of the
+// values of the enums, or their numbers if they're unknown.
+func jsonScalar(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Int64:
+		if isReflectEnum(v.Type()) {
+			name, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+			// The String of the generated enums is "<UNSET>" for the
+			// unknown values.
+			if err == nil && len(name) > 0 && name[0] != '<' {
+				return string(name), nil
+			}
+		}
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint8:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	default:
+		return "", fmt.Errorf("unsupported type %s", v.Type())
+	}
+}
+
+// jsonMapKey returns the key of a JSON object for the map key v.
+func jsonMapKey(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "", fmt.Errorf("nil map key")
+		}
+		return jsonMapKey(v.Elem())
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	default:
+		s, err := jsonScalar(v)
+		if err != nil {
+			return "", fmt.Errorf("unsupported map key type %s", v.Type())
+		}
+		return s, nil
+	}
+}
+
+func readJSONStruct(v reflect.Value, x interface{}) error {
+	info, err := reflectStructInfo(v.Type())
+	if err != nil {
+		return err
+	}
+	obj, ok := x.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("cannot decode %s into %s", jsonKind(x), info.name)
+	}
+	set := 0
+	for _, f := range info.fields {
+		fx, ok := obj[f.name]
+		if !ok || fx == nil {
+			if f.required {
+				return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("required field %s of %s is not set", f.name, info.name))
+			}
+			continue
+		}
+		if err := readJSONValue(v.Field(f.index), fx); err != nil {
+			return PrependError(fmt.Sprintf("%s.%s: ", info.name, f.name), err)
+		}
+		set++
+	}
+	if set != 1 && isUnion(v.Type()) {
+		return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("union %s must have exactly one field set, got %d", info.name, set))
+	}
+	return nil
+}
+
+// readJSONValue decodes x, as decoded by a json.Decoder using numbers, into
+// v, which must be settable.
+func readJSONValue(v reflect.Value, x interface{}) error {
+	if x == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if err := readJSONValue(elem.Elem(), x); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Struct:
+		return readJSONStruct(v, x)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			s, ok := x.(string)
+			if !ok {
+				return fmt.Errorf("cannot decode %s into binary", jsonKind(x))
+			}
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		elems, ok := x.([]interface{})
+		if !ok {
+			return fmt.Errorf("cannot decode %s into %s", jsonKind(x), v.Type())
+		}
+		s := reflect.MakeSlice(v.Type(), len(elems), len(elems))
+		for i, elem := range elems {
+			if err := readJSONValue(s.Index(i), elem); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		if isJSONSet(v.Type()) {
+			elems, ok := x.([]interface{})
+			if !ok {
+				return fmt.Errorf("cannot decode %s into %s", jsonKind(x), v.Type())
+			}
+			for _, elem := range elems {
+				key := reflect.New(v.Type().Key()).Elem()
+				if err := readJSONValue(key, elem); err != nil {
+					return err
+				}
+				m.SetMapIndex(key, reflect.Zero(v.Type().Elem()))
+			}
+			v.Set(m)
+			return nil
+		}
+		obj, ok := x.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot decode %s into %s", jsonKind(x), v.Type())
+		}
+		for k, value := range obj {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := readJSONMapKey(key, k); err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := readJSONValue(elem, value); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Bool:
+		b, ok := x.(bool)
+		if !ok {
+			return fmt.Errorf("cannot decode %s into bool", jsonKind(x))
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.String:
+		s, ok := x.(string)
+		if !ok {
+			return fmt.Errorf("cannot decode %s into string", jsonKind(x))
+		}
+		v.SetString(s)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		var err error
+		switch x := x.(type) {
+		case json.Number:
+			f, err = x.Float64()
+		case string:
+			f, err = parseJSONFloat(x)
+		default:
+			err = fmt.Errorf("cannot decode %s into double", jsonKind(x))
+		}
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+		return nil
+	}
+	switch x := x.(type) {
+	case json.Number:
+		return setJSONInt(v, string(x))
+	case string:
+		if isReflectEnum(v.Type()) {
+			return readJSONMapKey(v, x)
+		}
+	}
+	return fmt.Errorf("cannot decode %s into %s", jsonKind(x), v.Type())
+}
+
+// readJSONMapKey decodes the key s of a JSON object into v, which must be
+// settable.
+func readJSONMapKey(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if err := readJSONMapKey(elem.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := parseJSONFloat(s)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+		return nil
+	}
+	if isReflectEnum(v.Type()) {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok && u.UnmarshalText([]byte(s)) == nil {
+			return nil
+		}
+	}
+	return setJSONInt(v, s)
+}
+
+// setJSONInt sets v, an integer, to the integer s.
+func setJSONInt(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v.OverflowInt(i) {
+			return fmt.Errorf("invalid %s %q", v.Type(), s)
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint8:
+		i, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || v.OverflowUint(i) {
+			return fmt.Errorf("invalid %s %q", v.Type(), s)
+		}
+		v.SetUint(i)
+		return nil
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+}
+
+func parseJSONFloat(s string) (float64, error) {
+	switch s {
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	default:
+		return strconv.ParseFloat(s, 64)
+	}
+}
+
+// jsonKind returns the kind of the JSON value x, for the errors.
+func jsonKind(x interface{}) string {
+	switch x.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a bool"
+	default:
+		return "null"
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// JSONTestStatus, JSONTestLogin and JSONTestUser mirror the generated code
+// of:
+//
+//	enum JSONTestStatus { ACTIVE = 1, BANNED = 2 }
+//	union JSONTestLogin { 1: string name, 2: i64 id }
+//	struct JSONTestUser {
+//	  1: required i64 id
+//	  2: optional string nickname = "anon"
+//	  ...
+//	}
+type JSONTestStatus int64
+
+const (
+	JSONTestStatus_ACTIVE JSONTestStatus = 1
+	JSONTestStatus_BANNED JSONTestStatus = 2
+)
+
+func (p JSONTestStatus) String() string {
+	switch p {
+	case JSONTestStatus_ACTIVE:
+		return "ACTIVE"
+	case JSONTestStatus_BANNED:
+		return "BANNED"
+	}
+	return "<UNSET>"
+}
+
+func (p JSONTestStatus) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *JSONTestStatus) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "ACTIVE":
+		*p = JSONTestStatus_ACTIVE
+	case "BANNED":
+		*p = JSONTestStatus_BANNED
+	default:
+		return fmt.Errorf("not a valid JSONTestStatus string")
+	}
+	return nil
+}
+
+func JSONTestStatusPtr(v JSONTestStatus) *JSONTestStatus { return &v }
+
+func (p JSONTestStatus) Values() []JSONTestStatus {
+	return []JSONTestStatus{JSONTestStatus_ACTIVE, JSONTestStatus_BANNED}
+}
+
+type JSONTestLogin struct {
+	Name *string `thrift:"name,1" json:"name,omitempty"`
+	ID   *int64  `thrift:"id,2" json:"id,omitempty"`
+}
+
+func (p *JSONTestLogin) CountSetFieldsJSONTestLogin() int {
+	count := 0
+	if p.Name != nil {
+		count++
+	}
+	if p.ID != nil {
+		count++
+	}
+	return count
+}
+
+type JSONTestUser struct {
+	ID       int64                      `thrift:"id,1,required" json:"id"`
+	Nickname string                     `thrift:"nickname,2" json:"nickname"`
+	Status   *JSONTestStatus            `thrift:"status,3" json:"status,omitempty"`
+	Weights  map[JSONTestStatus]float64 `thrift:"weights,4" json:"weights"`
+	Avatar   []byte                     `thrift:"avatar,5" json:"avatar"`
+	Login    *JSONTestLogin             `thrift:"login,6" json:"login,omitempty"`
+	Tags     map[string]struct{}        `thrift:"tags,7" json:"tags"`
+	Score    float64                    `thrift:"score,8" json:"score"`
+	Count    int32                      `thrift:"count,9" json:"count"`
+}
+
+func (p *JSONTestUser) IsSetNickname() bool {
+	return p.Nickname != "anon"
+}
+
+func TestStructJSON(t *testing.T) {
+	name := "alice"
+	user := &JSONTestUser{
+		ID:       1 << 60,
+		Nickname: "anon",
+		Status:   JSONTestStatusPtr(JSONTestStatus_ACTIVE),
+		Weights:  map[JSONTestStatus]float64{JSONTestStatus_BANNED: -1, JSONTestStatus_ACTIVE: 0.5, 7: 1},
+		Avatar:   []byte{0xca, 0xfe},
+		Login:    &JSONTestLogin{Name: &name},
+		Tags:     map[string]struct{}{"b": {}, "a": {}},
+		Score:    math.Inf(-1),
+	}
+	data, err := MarshalStructJSON(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"id":1152921504606846976,"status":"ACTIVE","weights":{"7":1,"ACTIVE":0.5,"BANNED":-1},` +
+		`"avatar":"yv4=","login":{"name":"alice"},"tags":["a","b"],"score":"-Infinity","count":0}`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+
+	decoded := &JSONTestUser{Nickname: "anon"}
+	if err := UnmarshalStructJSON(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, user) {
+		t.Errorf("expected %+v, got %+v", user, decoded)
+	}
+
+	decoded = &JSONTestUser{}
+	if err := UnmarshalStructJSON([]byte(`{"id":1,"status":2,"unknown":[1],"login":{"id":3,"name":null},"score":"NaN"}`), decoded); err != nil {
+		t.Fatal(err)
+	}
+	if *decoded.Status != JSONTestStatus_BANNED || *decoded.Login.ID != 3 || decoded.Login.Name != nil || !math.IsNaN(decoded.Score) {
+		t.Errorf("unexpected decoded struct %+v", decoded)
+	}
+
+	generated := &MyTestStruct{
+		St:        "hello",
+		E:         MyTestEnum_THIRD,
+		StringSet: map[string]struct{}{"x": {}},
+	}
+	if data, err = MarshalStructJSON(generated); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"stringSet":["x"],"e":3}`) {
+		t.Errorf("unexpected generated struct %s", data)
+	}
+	decodedGenerated := &MyTestStruct{}
+	if err := UnmarshalStructJSON(data, decodedGenerated); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodedGenerated, generated) {
+		t.Errorf("expected %+v, got %+v", generated, decodedGenerated)
+	}
+}
+
+func TestStructJSONErrors(t *testing.T) {
+	id := int64(1)
+	name := "alice"
+	if _, err := MarshalStructJSON(&JSONTestUser{ID: 1, Login: &JSONTestLogin{Name: &name, ID: &id}}); err == nil {
+		t.Error("expected an error for a union with two fields set")
+	}
+	for _, c := range []struct {
+		data string
+		err  string
+	}{
+		{`{}`, "required field id of JSONTestUser is not set"},
+		{`{"id":1,"login":{}}`, "union JSONTestLogin must have exactly one field set, got 0"},
+		{`{"id":1,"status":"GONE"}`, `JSONTestUser.status: invalid thrift.JSONTestStatus "GONE"`},
+		{`{"id":1,"count":4294967296}`, `JSONTestUser.count: invalid int32 "4294967296"`},
+		{`{"id":1,"avatar":3}`, "JSONTestUser.avatar: cannot decode a number into binary"},
+		{`[]`, "cannot decode an array into JSONTestUser"},
+	} {
+		err := UnmarshalStructJSON([]byte(c.data), &JSONTestUser{})
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error %q, got %v", c.data, c.err, err)
+		}
+	}
+}
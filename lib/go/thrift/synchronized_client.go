@@ -0,0 +1,69 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+)
+
+// TSynchronizedClient is a TClient serializing the calls to a TClient which is
+// not safe for concurrent use, such as a TStandardClient, so that it can be
+// shared by multiple goroutines. Sharing a TStandardClient without it
+// interleaves the messages of the concurrent calls on the connection.
+//
+// Each call waits for the previous ones to complete. To send them without
+// waiting for the replies of the previous ones, use a TPipelinedClient.
+type TSynchronizedClient struct {
+	client TClient
+
+	// sem is a semaphore of size 1, unlike a sync.Mutex the calls can stop
+	// waiting for it when their context is done.
+	sem chan struct{}
+}
+
+// NewTSynchronizedClient returns a TSynchronizedClient calling client:
+//
+//	client := NewMyServiceClient(thrift.NewTSynchronizedClient(
+//		thrift.NewTStandardClient(iprot, oprot),
+//	))
+func NewTSynchronizedClient(client TClient) *TSynchronizedClient {
+	return &TSynchronizedClient{
+		client: client,
+		sem:    make(chan struct{}, 1),
+	}
+}
+
+// Call implements TClient.
+//
+// When ctx is done before the previous calls complete, Call returns ctx.Err()
+// without calling the wrapped TClient.
+func (c *TSynchronizedClient) Call(ctx context.Context, method string, args, result TStruct) (ResponseMeta, error) {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ResponseMeta{}, ctx.Err()
+	}
+	defer func() {
+		<-c.sem
+	}()
+	return c.client.Call(ctx, method, args, result)
+}
+
+var _ TClient = (*TSynchronizedClient)(nil)
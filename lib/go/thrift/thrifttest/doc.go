@@ -0,0 +1,64 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package thrifttest provides TClients to test the consumers of thrift
+// services without live servers.
+//
+// A Recorder records the calls made to a real server, which a Replayer then
+// replies to as a fake server:
+//
+//	recorder := thrifttest.NewRecorder(thrift.NewTStandardClient(iprot, oprot))
+//	... calls made with NewMyServiceClient(recorder) ...
+//	err := recorder.Save("testdata/my_service.json")
+//
+//	replayer, err := thrifttest.LoadReplayer("testdata/my_service.json")
+//	client := NewMyServiceClient(replayer)
+//
+// A MockClient replies to the calls as programmed per method:
+//
+//	mock := thrifttest.NewMockClient()
+//	mock.On("getUser").WithArgs(&MyServiceGetUserArgs{ID: 1}).Return(&MyServiceGetUserResult{Success: user})
+//	mock.On("deleteUser").ReturnError(thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "denied"))
+//	client := NewMyServiceClient(mock)
+//	...
+//	mock.AssertExpectations(t)
+//
+// The method names are the ones of the IDL, and the argument and result
+// structs the ones generated for the methods, e.g. MyServiceGetUserArgs and
+// MyServiceGetUserResult for the method getUser of MyService.
+//
+// A Server serves a processor over in-memory connections, like
+// net/http/httptest does for http handlers, to test the handlers of a service
+// through a real server and client, without sockets:
+//
+//	server := thrifttest.NewServer(NewMyServiceProcessor(handler), &thrifttest.ServerOptions{
+//		ProcessorMiddlewares: []thrift.ProcessorMiddleware{authMiddleware},
+//	})
+//	defer server.Close()
+//	client := NewMyServiceClient(server.Client())
+//
+// AssertGolden locks down the wire format of structs, comparing their
+// serializations with each protocol to golden files in testdata:
+//
+//	thrifttest.AssertGolden(t, "user", &User{ID: 1, Name: "alice"})
+//
+// The golden files are (re)written by running the tests with
+// THRIFTTEST_UPDATE_GOLDEN=1, and mismatches are reported as the diff of the
+// fields decoded from both serializations.
+package thrifttest
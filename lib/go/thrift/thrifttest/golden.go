@@ -0,0 +1,257 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrifttest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// UpdateGoldenEnv is the environment variable which, set to 1, makes
+// Golden.Assert write the golden files instead of comparing with them.
+const UpdateGoldenEnv = "THRIFTTEST_UPDATE_GOLDEN"
+
+// Golden compares the serializations of structs with golden files, to lock
+// down their wire format across refactors:
+//
+//	func TestUserWireFormat(t *testing.T) {
+//		thrifttest.AssertGolden(t, "user", &User{ID: 1, Name: thrift.StringPtr("alice")})
+//	}
+//
+// The golden files are written by running the tests with the environment
+// variable THRIFTTEST_UPDATE_GOLDEN=1, or with Update, then reviewed and
+// committed.
+type Golden struct {
+	// Dir is the directory of the golden files, "testdata" if empty.
+	Dir string
+
+	// Protocols are the protocols the structs are serialized with, keyed by
+	// the names used in the names of the golden files. GoldenProtocols if
+	// nil.
+	Protocols map[string]thrift.TProtocolFactory
+
+	// Update writes the golden files instead of comparing with them.
+	Update bool
+}
+
+// GoldenProtocols returns the protocols of Golden by default: "binary",
+// "compact" and "json".
+func GoldenProtocols() map[string]thrift.TProtocolFactory {
+	return map[string]thrift.TProtocolFactory{
+		"binary":  thrift.NewTBinaryProtocolFactoryConf(nil),
+		"compact": thrift.NewTCompactProtocolFactoryConf(nil),
+		"json":    thrift.NewTJSONProtocolFactory(),
+	}
+}
+
+// AssertGolden is Assert with the default Golden.
+func AssertGolden(t TestingT, name string, msg thrift.TStruct) bool {
+	t.Helper()
+	return Golden{}.Assert(t, name, msg)
+}
+
+// Assert serializes msg with each of the protocols of g, and reports an
+// error to t for each serialization differing from its golden file,
+// <Dir>/<name>.<protocol>.golden, with the difference between their fields,
+// decoded without the schema. It returns whether they're all the same.
+func (g Golden) Assert(t TestingT, name string, msg thrift.TStruct) bool {
+	t.Helper()
+	dir := g.Dir
+	if dir == "" {
+		dir = "testdata"
+	}
+	protocols := g.Protocols
+	if protocols == nil {
+		protocols = GoldenProtocols()
+	}
+	update := g.Update || os.Getenv(UpdateGoldenEnv) == "1"
+	names := make([]string, 0, len(protocols))
+	for protocol := range protocols {
+		names = append(names, protocol)
+	}
+	sort.Strings(names)
+
+	ctx := context.Background()
+	ok := true
+	for _, protocol := range names {
+		factory := protocols[protocol]
+		path := filepath.Join(dir, name+"."+protocol+".golden")
+		actual, err := thrift.NewTSerializerPoolSizeFactory(1024, factory).Write(ctx, msg)
+		if err != nil {
+			t.Errorf("serializing %s with %s: %v", name, protocol, err)
+			ok = false
+			continue
+		}
+		if update {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+				err = ioutil.WriteFile(path, actual, 0o644)
+			}
+			if err != nil {
+				t.Errorf("updating %s: %v", path, err)
+				ok = false
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Errorf("reading the golden file of %s with %s (set %s=1 to write it): %v", name, protocol, UpdateGoldenEnv, err)
+			ok = false
+			continue
+		}
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("%s serialized with %s differs from %s:\n%s", name, protocol, path, goldenDiff(factory, expected, actual))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// goldenDiff returns the difference between the fields of the payloads
+// expected and actual, or between their bytes if they don't decode.
+func goldenDiff(factory thrift.TProtocolFactory, expected, actual []byte) string {
+	expectedLines, err := goldenLines(factory, expected)
+	if err != nil {
+		return fmt.Sprintf("the golden file doesn't decode (%v)\n- %x\n+ %x", err, expected, actual)
+	}
+	actualLines, err := goldenLines(factory, actual)
+	if err != nil {
+		return fmt.Sprintf("the serialization doesn't decode (%v)\n- %x\n+ %x", err, expected, actual)
+	}
+	diff := diffLines(expectedLines, actualLines)
+	if diff == "" {
+		// The fields are the same, but not their encoding, e.g. the
+		// order of the elements of a map.
+		return fmt.Sprintf("same fields, different bytes\n- %x\n+ %x", expected, actual)
+	}
+	return diff
+}
+
+// goldenLines decodes the struct data without its schema, one line per
+// value.
+func goldenLines(factory thrift.TProtocolFactory, data []byte) ([]string, error) {
+	var v thrift.Value
+	if err := v.Read(context.Background(), factory.GetProtocol(&thrift.TMemoryBuffer{Buffer: bytes.NewBuffer(data)})); err != nil {
+		return nil, err
+	}
+	var lines []string
+	appendGoldenLines(&lines, 0, "", v)
+	return lines, nil
+}
+
+// appendGoldenLines appends the lines of v, the value labeled label, indented
+// by depth.
+func appendGoldenLines(lines *[]string, depth int, label string, v thrift.Value) {
+	indent := strings.Repeat("  ", depth)
+	switch v.Type {
+	case thrift.STRUCT:
+		*lines = append(*lines, indent+label+"{")
+		for _, f := range v.Fields {
+			appendGoldenLines(lines, depth+1, fmt.Sprintf("%d: ", f.ID), f.Value)
+		}
+		*lines = append(*lines, indent+"}")
+	case thrift.LIST, thrift.SET:
+		*lines = append(*lines, fmt.Sprintf("%s%s%s<%s> [", indent, label, v.Type, v.ElemType))
+		for _, elem := range v.Elems {
+			appendGoldenLines(lines, depth+1, "", elem)
+		}
+		*lines = append(*lines, indent+"]")
+	case thrift.MAP:
+		*lines = append(*lines, fmt.Sprintf("%s%sMAP<%s, %s> {", indent, label, v.KeyType, v.ElemType))
+		for _, entry := range v.Entries {
+			appendGoldenLines(lines, depth+1, goldenScalar(entry.Key)+": ", entry.Value)
+		}
+		*lines = append(*lines, indent+"}")
+	default:
+		*lines = append(*lines, indent+label+goldenScalar(v))
+	}
+}
+
+// goldenScalar formats the scalar v with its type, or the container v in
+// short.
+func goldenScalar(v thrift.Value) string {
+	switch v.Type {
+	case thrift.BOOL:
+		return "BOOL " + strconv.FormatBool(v.Bool)
+	case thrift.BYTE, thrift.I16, thrift.I32, thrift.I64:
+		return fmt.Sprintf("%s %d", v.Type, v.Int)
+	case thrift.DOUBLE:
+		return "DOUBLE " + strconv.FormatFloat(v.Double, 'g', -1, 64)
+	case thrift.STRING:
+		if utf8.Valid(v.Binary) {
+			return "STRING " + strconv.Quote(string(v.Binary))
+		}
+		return fmt.Sprintf("STRING 0x%x", v.Binary)
+	default:
+		return v.String()
+	}
+}
+
+// diffLines returns the lines removed from a, prefixed by "- ", and added to
+// b, prefixed by "+ ", between the common lines, prefixed by "  ", or "" if
+// a and b are the same.
+func diffLines(a, b []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	if lcs[0][0] == len(a) && len(a) == len(b) {
+		return ""
+	}
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
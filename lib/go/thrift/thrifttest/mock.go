@@ -0,0 +1,194 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrifttest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// TestingT is the subset of testing.TB used by MockClient.AssertExpectations and
+// Golden.Assert.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// MockClient is a TClient replying to the calls as programmed with On. It's
+// safe for concurrent use.
+type MockClient struct {
+	mu           sync.Mutex
+	expectations []*Expectation
+	// unexpected are the methods of the calls no Expectation matched.
+	unexpected []string
+}
+
+// NewMockClient returns a MockClient without Expectations, failing all the
+// calls.
+func NewMockClient() *MockClient {
+	return &MockClient{}
+}
+
+// On adds an Expectation of calls of method, by default replied with a zero
+// result (nil success for the methods returning a value).
+//
+// A call is replied by the first Expectation added matching it: of the same
+// method, with equal arguments if WithArgs was set, and called less than
+// Times if it was set. The calls matching no Expectation fail with an
+// UNKNOWN_METHOD TApplicationException.
+func (m *MockClient) On(method string) *Expectation {
+	e := &Expectation{method: method}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// Call implements thrift.TClient.
+func (m *MockClient) Call(ctx context.Context, method string, args, result thrift.TStruct) (thrift.ResponseMeta, error) {
+	e := m.match(method, args)
+	if e == nil {
+		return thrift.ResponseMeta{}, thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, fmt.Sprintf("thrifttest: unexpected call to %s with arguments %v", method, args))
+	}
+	meta := thrift.ResponseMeta{Headers: e.headers}
+	if e.do != nil {
+		return meta, e.do(ctx, args, result)
+	}
+	if e.err != nil {
+		return meta, e.err
+	}
+	if e.result != nil && result != nil {
+		// The result is copied through its encoding, so that the calls
+		// don't share its fields.
+		data, err := encode(ctx, e.result)
+		if err != nil {
+			return meta, err
+		}
+		return meta, decode(ctx, data, result)
+	}
+	return meta, nil
+}
+
+// match returns the Expectation replying to a call, counting the call.
+func (m *MockClient) match(method string, args thrift.TStruct) *Expectation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.expectations {
+		if e.method != method || (e.times > 0 && e.calls >= e.times) {
+			continue
+		}
+		if e.args != nil && !reflect.DeepEqual(e.args, args) {
+			continue
+		}
+		e.calls++
+		return e
+	}
+	m.unexpected = append(m.unexpected, method)
+	return nil
+}
+
+// AssertExpectations reports an error to t for every Expectation not called
+// as many times as expected, at least once if Times wasn't set, and for every
+// unexpected call. It returns whether there were none.
+func (m *MockClient) AssertExpectations(t TestingT) bool {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ok := true
+	for _, e := range m.expectations {
+		switch {
+		case e.times > 0 && e.calls != e.times:
+			t.Errorf("thrifttest: expected %d calls to %s, got %d", e.times, e.method, e.calls)
+			ok = false
+		case e.times == 0 && e.calls == 0:
+			t.Errorf("thrifttest: expected a call to %s", e.method)
+			ok = false
+		}
+	}
+	for _, method := range m.unexpected {
+		t.Errorf("thrifttest: unexpected call to %s", method)
+		ok = false
+	}
+	return ok
+}
+
+// Expectation is an expected call of a MockClient, see MockClient.On. Its
+// methods return it, so that they can be chained, and must be called before
+// the calls it replies to are made.
+type Expectation struct {
+	method string
+
+	args    thrift.TStruct
+	result  thrift.TStruct
+	err     error
+	headers thrift.THeaderMap
+	do      func(ctx context.Context, args, result thrift.TStruct) error
+	times   int
+
+	// calls is guarded by the mu of the MockClient.
+	calls int
+}
+
+// WithArgs restricts e to the calls whose argument struct is equal to args,
+// as compared by reflect.DeepEqual, e.g. a MyServiceGetUserArgs.
+func (e *Expectation) WithArgs(args thrift.TStruct) *Expectation {
+	e.args = args
+	return e
+}
+
+// Return sets the result struct copied to the result of the calls, e.g. a
+// MyServiceGetUserResult with its Success or one of its declared exceptions
+// set.
+func (e *Expectation) Return(result thrift.TStruct) *Expectation {
+	e.result = result
+	return e
+}
+
+// ReturnError sets the error the calls fail with, usually a
+// TApplicationException or a TTransportException.
+func (e *Expectation) ReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// ReturnHeaders sets the THeader headers of the ResponseMeta of the calls.
+func (e *Expectation) ReturnHeaders(headers thrift.THeaderMap) *Expectation {
+	e.headers = headers
+	return e
+}
+
+// Do sets the function replying to the calls instead of Return and
+// ReturnError, setting result and returning the error of the call.
+func (e *Expectation) Do(fn func(ctx context.Context, args, result thrift.TStruct) error) *Expectation {
+	e.do = fn
+	return e
+}
+
+// Times restricts e to n calls, expected by AssertExpectations.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+var _ thrift.TClient = (*MockClient)(nil)
@@ -0,0 +1,248 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrifttest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// ErrNoInteraction is the error of the calls a Replayer has no recorded
+// Interaction for.
+var ErrNoInteraction = errors.New("thrifttest: no recorded interaction")
+
+// Interaction is a call recorded by a Recorder.
+type Interaction struct {
+	Method string `json:"method"`
+
+	// Args and Result are the argument and result structs of the call,
+	// encoded with TBinaryProtocol. Result is nil if the call failed with
+	// an Exception.
+	Args   []byte `json:"args"`
+	Result []byte `json:"result,omitempty"`
+
+	// Exception is the TApplicationException the call failed with, if any.
+	// The exceptions declared in the IDL are part of Result.
+	Exception *Exception `json:"exception,omitempty"`
+
+	// Headers are the THeader headers of the reply.
+	Headers thrift.THeaderMap `json:"headers,omitempty"`
+}
+
+// Exception is a recorded TApplicationException.
+type Exception struct {
+	Type    int32  `json:"type"`
+	Message string `json:"message"`
+}
+
+// Recorder is a TClient recording the calls made with another TClient,
+// usually connected to a real server, so that a Replayer can reply to them
+// later.
+//
+// Only the calls the server replied to are recorded, with a result or a
+// TApplicationException, not the ones failing with another error such as a
+// transport error, nor the oneway calls. It's safe for concurrent use if the
+// wrapped TClient is.
+type Recorder struct {
+	client thrift.TClient
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder returns a Recorder recording the calls made with client.
+func NewRecorder(client thrift.TClient) *Recorder {
+	return &Recorder{client: client}
+}
+
+// Call implements thrift.TClient.
+func (r *Recorder) Call(ctx context.Context, method string, args, result thrift.TStruct) (thrift.ResponseMeta, error) {
+	meta, err := r.client.Call(ctx, method, args, result)
+	if result == nil {
+		return meta, err
+	}
+	interaction := Interaction{
+		Method:  method,
+		Headers: meta.Headers,
+	}
+	var exc thrift.TApplicationException
+	switch {
+	case err == nil:
+		if interaction.Result, err = encode(ctx, result); err != nil {
+			return meta, err
+		}
+	case errors.As(err, &exc):
+		interaction.Exception = &Exception{
+			Type:    exc.TypeId(),
+			Message: exc.Error(),
+		}
+	default:
+		return meta, err
+	}
+	encodedArgs, encodeErr := encode(ctx, args)
+	if encodeErr != nil {
+		return meta, encodeErr
+	}
+	interaction.Args = encodedArgs
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, interaction)
+	return meta, err
+}
+
+// Interactions returns the calls recorded so far, in the order they
+// returned.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// Save writes the calls recorded so far to the file path, as JSON, see Load.
+func (r *Recorder) Save(path string) error {
+	data, err := json.MarshalIndent(r.Interactions(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Load reads the Interactions saved by Recorder.Save to the file path.
+func Load(path string) ([]Interaction, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []Interaction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("thrifttest: %s: %w", path, err)
+	}
+	return interactions, nil
+}
+
+// Replayer is a TClient replying to the calls with recorded Interactions, as
+// a fake server.
+//
+// A call is replied with the first Interaction of the same method and equal
+// arguments not replayed yet, or with the last one once they all were, so
+// that the calls made again are replied the same way. The calls without such
+// an Interaction fail with ErrNoInteraction. The oneway calls always succeed.
+// It's safe for concurrent use.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	replayed     []bool
+}
+
+// NewReplayer returns a Replayer replying with interactions.
+func NewReplayer(interactions []Interaction) *Replayer {
+	return &Replayer{
+		interactions: interactions,
+		replayed:     make([]bool, len(interactions)),
+	}
+}
+
+// LoadReplayer returns a Replayer replying with the Interactions saved by
+// Recorder.Save to the file path.
+func LoadReplayer(path string) (*Replayer, error) {
+	interactions, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(interactions), nil
+}
+
+// Call implements thrift.TClient.
+func (r *Replayer) Call(ctx context.Context, method string, args, result thrift.TStruct) (thrift.ResponseMeta, error) {
+	if result == nil {
+		return thrift.ResponseMeta{}, nil
+	}
+	interaction, ok := r.take(ctx, method, args)
+	if !ok {
+		return thrift.ResponseMeta{}, fmt.Errorf("%w for %s with arguments %v", ErrNoInteraction, method, args)
+	}
+	meta := thrift.ResponseMeta{Headers: interaction.Headers}
+	if exc := interaction.Exception; exc != nil {
+		return meta, thrift.NewTApplicationException(exc.Type, exc.Message)
+	}
+	return meta, decode(ctx, interaction.Result, result)
+}
+
+// take returns the Interaction replying to a call, see Replayer.
+func (r *Replayer) take(ctx context.Context, method string, args thrift.TStruct) (Interaction, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last := -1
+	for i, interaction := range r.interactions {
+		if interaction.Method != method || !equalArgs(ctx, interaction.Args, args) {
+			continue
+		}
+		if !r.replayed[i] {
+			r.replayed[i] = true
+			return interaction, true
+		}
+		last = i
+	}
+	if last < 0 {
+		return Interaction{}, false
+	}
+	return r.interactions[last], true
+}
+
+// equalArgs reports whether the encoded arguments data are equal to args.
+//
+// They're decoded and compared to args, rather than compared encoded, as the
+// elements of the maps are encoded in a random order.
+func equalArgs(ctx context.Context, data []byte, args thrift.TStruct) bool {
+	decoded := reflect.New(reflect.TypeOf(args).Elem()).Interface().(thrift.TStruct)
+	if err := decode(ctx, data, decoded); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(decoded, args)
+}
+
+// encode returns s encoded with TBinaryProtocol.
+func encode(ctx context.Context, s thrift.TStruct) ([]byte, error) {
+	buf := thrift.NewTMemoryBuffer()
+	if err := s.Write(ctx, thrift.NewTBinaryProtocolConf(buf, nil)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decode reads s from data, encoded with TBinaryProtocol.
+func decode(ctx context.Context, data []byte, s thrift.TStruct) error {
+	buf := &thrift.TMemoryBuffer{Buffer: bytes.NewBuffer(data)}
+	return s.Read(ctx, thrift.NewTBinaryProtocolConf(buf, nil))
+}
+
+var (
+	_ thrift.TClient = (*Recorder)(nil)
+	_ thrift.TClient = (*Replayer)(nil)
+)
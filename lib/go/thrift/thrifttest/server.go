@@ -0,0 +1,283 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrifttest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// errServerClosed is the error of the connections made to a closed Server.
+var errServerClosed = errors.New("thrifttest: server closed")
+
+// ServerOptions are the options of a Server.
+type ServerOptions struct {
+	// ProtocolFactory is the protocol of the server and its clients,
+	// TBinaryProtocol by default.
+	ProtocolFactory thrift.TProtocolFactory
+
+	// TransportFactory wraps the transports of the server and its clients,
+	// for example with TFramedTransport. By default they are not wrapped.
+	TransportFactory thrift.TTransportFactory
+
+	// ProcessorMiddlewares are installed on the processor, and
+	// ClientMiddlewares on the clients returned by Client.
+	ProcessorMiddlewares []thrift.ProcessorMiddleware
+	ClientMiddlewares    []thrift.ClientMiddleware
+
+	// Configuration is the TConfiguration of the server and its clients.
+	Configuration *thrift.TConfiguration
+}
+
+// Server is a thrift server serving a processor over in-memory connections,
+// to test service handlers without sockets. The clients returned by Client
+// call the processor through the protocol, transports and middlewares of a
+// real server, including the server side THeader headers and contexts.
+type Server struct {
+	// Server is the TSimpleServer serving the processor. It can be
+	// configured, for example with SetMaxInFlightRequests, between
+	// NewUnstartedServer and Start.
+	Server *thrift.TSimpleServer
+
+	opts     ServerOptions
+	listener *pipeListener
+	served   chan struct{}
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	clients []thrift.TTransport
+}
+
+// NewServer starts and returns a Server serving processor. The caller should
+// call Close when done, to stop it.
+func NewServer(processor thrift.TProcessor, opts *ServerOptions) *Server {
+	s := NewUnstartedServer(processor, opts)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a Server serving processor, not started yet.
+// The caller should call Start once the Server is configured, and Close when
+// done.
+func NewUnstartedServer(processor thrift.TProcessor, opts *ServerOptions) *Server {
+	s := &Server{
+		listener: newPipeListener(),
+		served:   make(chan struct{}),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.ProtocolFactory == nil {
+		s.opts.ProtocolFactory = thrift.NewTBinaryProtocolFactoryConf(s.opts.Configuration)
+	}
+	if s.opts.TransportFactory == nil {
+		s.opts.TransportFactory = thrift.NewTTransportFactory()
+	}
+	processor = thrift.WrapProcessor(processor, s.opts.ProcessorMiddlewares...)
+	s.Server = thrift.NewTSimpleServer4(processor, s.listener, s.opts.TransportFactory, s.opts.ProtocolFactory)
+	s.Server.SetLogger(thrift.NopLogger)
+	if s.opts.Configuration != nil {
+		s.Server.SetTConfiguration(s.opts.Configuration)
+	}
+	return s
+}
+
+// Start starts serving. It panics if the Server is already started.
+func (s *Server) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		panic("thrifttest: Server already started")
+	}
+	s.started = true
+	go func() {
+		defer close(s.served)
+		s.Server.Serve()
+	}()
+}
+
+// Client returns a TClient connected to the Server over a new in-memory
+// connection, with the ClientMiddlewares installed, to pass to the
+// constructors of the generated clients:
+//
+//	server := thrifttest.NewServer(NewMyServiceProcessor(handler), nil)
+//	defer server.Close()
+//	client := NewMyServiceClient(server.Client())
+//
+// The connection is closed by Close. The calls made once the Server is
+// closed fail with a TTransportException.
+func (s *Server) Client() thrift.TClient {
+	trans, err := s.dial()
+	if err == nil {
+		trans, err = s.opts.TransportFactory.GetTransport(trans)
+	}
+	if err != nil {
+		trans = &tClosedTransport{err: err}
+	}
+	iprot := s.opts.ProtocolFactory.GetProtocol(trans)
+	oprot := iprot
+	if _, ok := iprot.(*thrift.THeaderProtocol); !ok {
+		oprot = s.opts.ProtocolFactory.GetProtocol(trans)
+	}
+	return thrift.WrapClient(thrift.NewTStandardClient(iprot, oprot), s.opts.ClientMiddlewares...)
+}
+
+func (s *Server) dial() (thrift.TTransport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errServerClosed
+	}
+	client, server := net.Pipe()
+	trans := thrift.NewTSocketFromConnConf(pipeConn{client}, s.opts.Configuration)
+	s.clients = append(s.clients, trans)
+	s.listener.conns <- thrift.NewTSocketFromConnConf(pipeConn{server}, s.opts.Configuration)
+	return trans, nil
+}
+
+// Close stops the Server, waiting for the in-flight requests to complete,
+// and closes the connections of its clients.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	started := s.started
+	clients := s.clients
+	s.clients = nil
+	s.mu.Unlock()
+
+	if started {
+		s.Server.Shutdown(context.Background())
+		<-s.served
+	}
+	s.listener.Close()
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+// pipeConn is one end of a net.Pipe, skipping the empty writes, which the
+// other end would read as empty reads the transports don't expect.
+type pipeConn struct {
+	net.Conn
+}
+
+func (c pipeConn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return c.Conn.Write(b)
+}
+
+// pipeListener is a TServerTransport accepting the in-memory connections of
+// a Server.
+type pipeListener struct {
+	// conns is buffered so that dialing doesn't wait for the connections
+	// to be accepted, which the server may delay when at its connection
+	// limit.
+	conns       chan thrift.TTransport
+	interrupted chan struct{}
+	once        sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns:       make(chan thrift.TTransport, 1024),
+		interrupted: make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Listen() error {
+	return nil
+}
+
+func (l *pipeListener) Accept() (thrift.TTransport, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.interrupted:
+		return nil, errServerClosed
+	}
+}
+
+func (l *pipeListener) Interrupt() error {
+	l.once.Do(func() {
+		close(l.interrupted)
+	})
+	return nil
+}
+
+// Close interrupts the listener and closes the connections never accepted.
+func (l *pipeListener) Close() error {
+	l.Interrupt()
+	for {
+		select {
+		case conn := <-l.conns:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// tClosedTransport is the transport of the clients of a closed Server.
+type tClosedTransport struct {
+	err error
+}
+
+func (t *tClosedTransport) Open() error {
+	return t.error()
+}
+
+func (t *tClosedTransport) IsOpen() bool {
+	return false
+}
+
+func (t *tClosedTransport) Close() error {
+	return nil
+}
+
+func (t *tClosedTransport) Read([]byte) (int, error) {
+	return 0, t.error()
+}
+
+func (t *tClosedTransport) Write([]byte) (int, error) {
+	return 0, t.error()
+}
+
+func (t *tClosedTransport) Flush(context.Context) error {
+	return t.error()
+}
+
+func (t *tClosedTransport) RemainingBytes() uint64 {
+	return 0
+}
+
+func (t *tClosedTransport) error() error {
+	return thrift.NewTTransportExceptionFromError(t.err)
+}
@@ -0,0 +1,320 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrifttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/apache/thrift/lib/go/thrift/health"
+)
+
+func checkArgs(service string) *health.HealthCheckArgs {
+	return &health.HealthCheckArgs{Request: &health.HealthCheckRequest{Service: service}}
+}
+
+func checkResult(status health.ServingStatus) *health.HealthCheckResult {
+	return &health.HealthCheckResult{Success: &health.HealthCheckResponse{Status: status}}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockClient()
+	backend.On("check").WithArgs(checkArgs("users")).
+		Return(checkResult(health.ServingStatus_SERVING)).
+		ReturnHeaders(thrift.THeaderMap{"server": "a"})
+	backend.On("check").WithArgs(checkArgs("orders")).
+		ReturnError(thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "unknown service"))
+	backend.On("check").WithArgs(checkArgs("billing")).
+		ReturnError(thrift.NewTTransportException(thrift.TIMED_OUT, "timeout"))
+
+	recorder := NewRecorder(backend)
+	client := health.NewHealthClient(recorder)
+	if _, err := client.Check(ctx, &health.HealthCheckRequest{Service: "users"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Check(ctx, &health.HealthCheckRequest{Service: "orders"}); err == nil {
+		t.Fatal("expected an exception")
+	}
+	// The transport errors are not recorded.
+	if _, err := client.Check(ctx, &health.HealthCheckRequest{Service: "billing"}); err == nil {
+		t.Fatal("expected a transport error")
+	}
+	if n := len(recorder.Interactions()); n != 2 {
+		t.Fatalf("expected 2 interactions recorded, got %d", n)
+	}
+	path := filepath.Join(t.TempDir(), "health.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := LoadReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = health.NewHealthClient(replayer)
+	for i := 0; i < 2; i++ {
+		response, err := client.Check(ctx, &health.HealthCheckRequest{Service: "users"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.GetStatus() != health.ServingStatus_SERVING {
+			t.Errorf("expected SERVING, got %v", response.GetStatus())
+		}
+		if server := client.LastResponseMeta_().Headers["server"]; server != "a" {
+			t.Errorf("expected the recorded headers, got %q", server)
+		}
+	}
+	_, err = client.Check(ctx, &health.HealthCheckRequest{Service: "orders"})
+	var exc thrift.TApplicationException
+	if !errors.As(err, &exc) || exc.TypeId() != thrift.INTERNAL_ERROR || exc.Error() != "unknown service" {
+		t.Errorf("expected the recorded exception, got %v", err)
+	}
+	if _, err := client.Check(ctx, &health.HealthCheckRequest{Service: "billing"}); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expected ErrNoInteraction, got %v", err)
+	}
+}
+
+// recordingT is a TestingT recording the errors reported.
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestMockClient(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockClient()
+	mock.On("check").WithArgs(checkArgs("users")).Times(1).
+		Return(checkResult(health.ServingStatus_NOT_SERVING))
+	mock.On("check").Do(func(ctx context.Context, args, result thrift.TStruct) error {
+		service := args.(*health.HealthCheckArgs).Request.Service
+		if service == "unknown" {
+			return thrift.NewTApplicationExceptionWithReason(thrift.PROTOCOL_ERROR, thrift.ExceptionReasonInvalidArgument, "unknown service")
+		}
+		result.(*health.HealthCheckResult).Success = &health.HealthCheckResponse{Status: health.ServingStatus_SERVING}
+		return nil
+	})
+
+	client := health.NewHealthClient(mock)
+	for _, c := range []struct {
+		service  string
+		expected health.ServingStatus
+	}{
+		// The first Expectation only matches a single call.
+		{"users", health.ServingStatus_NOT_SERVING},
+		{"users", health.ServingStatus_SERVING},
+		{"orders", health.ServingStatus_SERVING},
+	} {
+		response, err := client.Check(ctx, &health.HealthCheckRequest{Service: c.service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.GetStatus() != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.service, c.expected, response.GetStatus())
+		}
+	}
+	_, err := client.Check(ctx, &health.HealthCheckRequest{Service: "unknown"})
+	var exc thrift.TApplicationException
+	if !errors.As(err, &exc) || thrift.ExceptionReason(err) != thrift.ExceptionReasonInvalidArgument {
+		t.Errorf("expected an invalid argument TApplicationException, got %v", err)
+	}
+	if !mock.AssertExpectations(t) {
+		t.Error("expected the expectations to be met")
+	}
+
+	// The unmet expectations and the unexpected calls are reported.
+	mock = NewMockClient()
+	mock.On("check").Times(2)
+	mock.On("watch")
+	client = health.NewHealthClient(mock)
+	for i := 0; i < 3; i++ {
+		client.Check(ctx, &health.HealthCheckRequest{})
+	}
+	var rt recordingT
+	if mock.AssertExpectations(&rt) || len(rt.errors) != 2 {
+		t.Errorf("expected 2 errors reported, got %q", rt.errors)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	// The wire format of the generated health structs is locked down.
+	AssertGolden(t, "health_check_result", checkResult(health.ServingStatus_SERVING))
+
+	dir := t.TempDir()
+	msg := thrift.StructValue(
+		thrift.ValueField{ID: 1, Value: thrift.StringValue("alice")},
+		thrift.ValueField{ID: 2, Value: thrift.ListValue(thrift.I32, thrift.I32Value(1), thrift.I32Value(2))},
+		thrift.ValueField{ID: 3, Value: thrift.MapValue(thrift.STRING, thrift.BOOL, thrift.ValueMapEntry{
+			Key:   thrift.StringValue("admin"),
+			Value: thrift.BoolValue(true),
+		})},
+	)
+	var rt recordingT
+	if ok := (Golden{Dir: dir}).Assert(&rt, "user", &msg); ok || len(rt.errors) != 3 {
+		t.Fatalf("expected an error per protocol for the missing golden files, got %v", rt.errors)
+	}
+	if ok := (Golden{Dir: dir, Update: true}).Assert(t, "user", &msg); !ok {
+		t.Fatal("expected the golden files to be written")
+	}
+	if ok := (Golden{Dir: dir}).Assert(t, "user", &msg); !ok {
+		t.Fatal("expected the same serializations as the golden files")
+	}
+
+	msg.SetField(1, "", thrift.StringValue("bob"))
+	msg.Fields[1].Value.Elems = msg.Fields[1].Value.Elems[:1]
+	rt = recordingT{}
+	compact := map[string]thrift.TProtocolFactory{"compact": thrift.NewTCompactProtocolFactoryConf(nil)}
+	if ok := (Golden{Dir: dir, Protocols: compact}).Assert(&rt, "user", &msg); ok || len(rt.errors) != 1 {
+		t.Fatalf("expected an error for the compact serialization, got %v", rt.errors)
+	}
+	expected := filepath.Join(dir, "user.compact.golden") + `:
+  {
+-   1: STRING "alice"
++   1: STRING "bob"
+    2: LIST<I32> [
+      I32 1
+-     I32 2
+    ]
+    3: MAP<STRING, BOOL> {
+      STRING "admin": BOOL true
+    }
+  }
+`
+	if !strings.HasSuffix(rt.errors[0], expected) {
+		t.Errorf("expected the diff of the fields\n%s\ngot\n%s", expected, rt.errors[0])
+	}
+}
+
+func TestServer(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opts ServerOptions
+	}{
+		{name: "binary"},
+		{name: "compact-framed", opts: ServerOptions{
+			ProtocolFactory:  thrift.NewTCompactProtocolFactoryConf(nil),
+			TransportFactory: thrift.NewTFramedTransportFactoryConf(thrift.NewTTransportFactory(), nil),
+		}},
+		{name: "header", opts: ServerOptions{
+			ProtocolFactory: thrift.NewTHeaderProtocolFactoryConf(nil),
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			handler := health.NewServer()
+			handler.SetServingStatus("users", health.ServingStatus_NOT_SERVING)
+
+			var mu sync.Mutex
+			var served, called []string
+			c.opts.ProcessorMiddlewares = []thrift.ProcessorMiddleware{
+				func(name string, next thrift.TProcessorFunction) thrift.TProcessorFunction {
+					return thrift.WrappedTProcessorFunction{
+						Wrapped: func(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+							mu.Lock()
+							served = append(served, name)
+							mu.Unlock()
+							return next.Process(ctx, seqID, in, out)
+						},
+					}
+				},
+			}
+			c.opts.ClientMiddlewares = []thrift.ClientMiddleware{
+				func(next thrift.TClient) thrift.TClient {
+					return thrift.WrappedTClient{
+						Wrapped: func(ctx context.Context, method string, args, result thrift.TStruct) (thrift.ResponseMeta, error) {
+							mu.Lock()
+							called = append(called, method)
+							mu.Unlock()
+							return next.Call(ctx, method, args, result)
+						},
+					}
+				},
+			}
+			server := NewServer(health.NewHealthProcessor(handler), &c.opts)
+			defer server.Close()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				client := health.NewHealthClient(server.Client())
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 5; j++ {
+						resp, err := client.Check(ctx, &health.HealthCheckRequest{Service: "users"})
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if resp.Status != health.ServingStatus_NOT_SERVING {
+							t.Errorf("expected NOT_SERVING, got %v", resp.Status)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if len(served) != 20 || len(called) != 20 || served[0] != "check" || called[0] != "check" {
+				t.Errorf("expected 20 checks through the middlewares, got served %v and called %v", served, called)
+			}
+
+			client := health.NewHealthClient(server.Client())
+			if _, err := client.Check(ctx, &health.HealthCheckRequest{}); err != nil {
+				t.Fatal(err)
+			}
+			server.Close()
+			if _, err := client.Check(ctx, &health.HealthCheckRequest{}); err == nil {
+				t.Error("expected the calls to fail once the server is closed")
+			}
+			client = health.NewHealthClient(server.Client())
+			var te thrift.TTransportException
+			if _, err := client.Check(ctx, &health.HealthCheckRequest{}); !errors.As(err, &te) {
+				t.Errorf("expected a TTransportException from the clients of a closed server, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnstartedServer(t *testing.T) {
+	server := NewUnstartedServer(health.NewHealthProcessor(health.NewServer()), nil)
+	server.Server.SetMaxConnections(1, thrift.LimitExceededReject)
+	server.Start()
+	defer server.Close()
+
+	ctx := context.Background()
+	first := health.NewHealthClient(server.Client())
+	if _, err := first.Check(ctx, &health.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	second := health.NewHealthClient(server.Client())
+	var ae thrift.TApplicationException
+	if _, err := second.Check(ctx, &health.HealthCheckRequest{}); !errors.As(err, &ae) || ae.TypeId() != thrift.INTERNAL_ERROR {
+		t.Errorf("expected the connection over the limit to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"io"
+)
+
+// TTypedSerializerPool is a TSerializerPool writing the structs of type T, for
+// example *User, without the conversions to TStruct at the call sites.
+//
+// It must be initialized with NewTTypedSerializerPool.
+type TTypedSerializerPool[T TStruct] struct {
+	pool *TSerializerPool
+}
+
+// NewTTypedSerializerPool creates a new TTypedSerializerPool, with the given
+// initial buffer size and protocol factory like
+// NewTSerializerPoolSizeFactory.
+func NewTTypedSerializerPool[T TStruct](size int, factory TProtocolFactory) *TTypedSerializerPool[T] {
+	return &TTypedSerializerPool[T]{
+		pool: NewTSerializerPoolSizeFactory(size, factory),
+	}
+}
+
+// Write returns the serialized msg.
+func (p *TTypedSerializerPool[T]) Write(ctx context.Context, msg T) ([]byte, error) {
+	return p.Append(ctx, nil, msg)
+}
+
+// WriteString returns the serialized msg as a string.
+func (p *TTypedSerializerPool[T]) WriteString(ctx context.Context, msg T) (string, error) {
+	return p.pool.WriteString(ctx, msg)
+}
+
+// WriteTo streams msg to w, see TSerializer.WriteTo.
+func (p *TTypedSerializerPool[T]) WriteTo(ctx context.Context, w io.Writer, msg T) (int64, error) {
+	return p.pool.WriteTo(ctx, w, msg)
+}
+
+// Append appends the serialized msg to b and returns the extended buffer, so
+// that the callers can reuse their buffers too.
+func (p *TTypedSerializerPool[T]) Append(ctx context.Context, b []byte, msg T) ([]byte, error) {
+	s := p.pool.pool.Get().(*TSerializer)
+	defer p.pool.pool.Put(s)
+	s.Transport.Reset()
+	if err := msg.Write(ctx, s.Protocol); err != nil {
+		return b, err
+	}
+	if err := s.Protocol.Flush(ctx); err != nil {
+		return b, err
+	}
+	if err := s.Transport.Flush(ctx); err != nil {
+		return b, err
+	}
+	return append(b, s.Transport.Bytes()...), nil
+}
+
+// TTypedDeserializerPool is a TDeserializerPool returning the structs of type
+// T, for example *User, instead of filling the ones of the callers.
+//
+// It must be initialized with NewTTypedDeserializerPool.
+type TTypedDeserializerPool[T TStruct] struct {
+	pool   *TDeserializerPool
+	newMsg func() T
+}
+
+// NewTTypedDeserializerPool creates a new TTypedDeserializerPool, with the
+// given initial buffer size and protocol factory like
+// NewTDeserializerPoolSizeFactory.
+//
+// newMsg returns the structs to read into, the constructor of the generated
+// struct can be used here:
+//
+//	users := thrift.NewTTypedDeserializerPool(NewUser, 1024, thrift.NewTCompactProtocolFactoryConf(nil))
+//	user, err := users.Read(ctx, b)
+func NewTTypedDeserializerPool[T TStruct](newMsg func() T, size int, factory TProtocolFactory) *TTypedDeserializerPool[T] {
+	return &TTypedDeserializerPool[T]{
+		pool:   NewTDeserializerPoolSizeFactory(size, factory),
+		newMsg: newMsg,
+	}
+}
+
+// Read returns a new struct read from b.
+func (p *TTypedDeserializerPool[T]) Read(ctx context.Context, b []byte) (T, error) {
+	msg := p.newMsg()
+	if err := p.pool.Read(ctx, msg, b); err != nil {
+		var zero T
+		return zero, err
+	}
+	return msg, nil
+}
+
+// ReadFrom returns a new struct read from r, see TDeserializer.ReadFrom.
+func (p *TTypedDeserializerPool[T]) ReadFrom(ctx context.Context, r io.Reader) (T, error) {
+	msg := p.newMsg()
+	if _, err := p.pool.ReadFrom(ctx, r, msg); err != nil {
+		var zero T
+		return zero, err
+	}
+	return msg, nil
+}
+
+// ReadString returns a new struct read from s.
+func (p *TTypedDeserializerPool[T]) ReadString(ctx context.Context, s string) (T, error) {
+	msg := p.newMsg()
+	if err := p.pool.ReadString(ctx, msg, s); err != nil {
+		var zero T
+		return zero, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,98 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestTypedPools(t *testing.T) {
+	ctx := context.Background()
+	for _, factory := range []TProtocolFactory{
+		NewTBinaryProtocolFactoryConf(nil),
+		NewTCompactProtocolFactoryConf(nil),
+	} {
+		serializers := NewTTypedSerializerPool[*MyTestStruct](16, factory)
+		deserializers := NewTTypedDeserializerPool(NewMyTestStruct, 16, factory)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				msg := &MyTestStruct{Int64: int64(i), St: "hello", StringList: []string{"a", "b"}}
+				b, err := serializers.Write(ctx, msg)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				got, err := deserializers.Read(ctx, b)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got.Int64 != int64(i) || got.St != "hello" || len(got.StringList) != 2 {
+					t.Errorf("unexpected struct %v", got)
+				}
+
+				s, err := serializers.WriteString(ctx, msg)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got, err := deserializers.ReadString(ctx, s); err != nil || !Equal(got, msg) {
+					t.Errorf("unexpected struct %v, error %v", got, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		prefix := []byte("prefix")
+		b, err := serializers.Append(ctx, prefix, &MyTestStruct{St: "appended"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:len(prefix)]) != "prefix" {
+			t.Errorf("expected the prefix to be kept, got %q", b)
+		}
+		got, err := deserializers.Read(ctx, b[len(prefix):])
+		if err != nil || got.St != "appended" {
+			t.Errorf("unexpected struct %v, error %v", got, err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := serializers.WriteTo(ctx, &buf, &MyTestStruct{St: "streamed"}); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := deserializers.ReadFrom(ctx, &buf); err != nil || got.St != "streamed" {
+			t.Errorf("unexpected struct %v, error %v", got, err)
+		}
+
+		if got, err := deserializers.Read(ctx, []byte{0x0b}); err == nil || got != nil {
+			t.Errorf("expected a nil struct and an error, got %v, %v", got, err)
+		}
+	}
+}
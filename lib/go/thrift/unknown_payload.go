@@ -0,0 +1,321 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// UnknownPayload is a payload decoded without its schema by
+// DecodeUnknownPayload.
+type UnknownPayload struct {
+	// Protocol is the protocol detected, ProtocolBinary or ProtocolCompact.
+	Protocol NegotiableProtocol
+	// Framed reports whether the payload starts with the size of its frame.
+	Framed bool
+
+	// Message is the message envelope of the payload, nil if it's a bare
+	// struct.
+	Message *TMessageInfo
+
+	// Fields are the fields of the struct of the payload, the arguments or
+	// the result of the message if there's one.
+	Fields []UnknownEntry
+
+	// Length is the number of bytes of the payload decoded, which may be
+	// followed by other payloads.
+	Length int
+}
+
+// UnknownEntry is an entry of the tree of an UnknownPayload: a field of a
+// struct, an element of a list or a set, or a key or a value of a map.
+type UnknownEntry struct {
+	// ID is the id of the field, 0 for the other entries.
+	ID   int16
+	Type TType
+
+	// Offset is the offset in the payload of the field, or of the value of
+	// the other entries.
+	Offset int
+
+	// Value is the value of the scalar entries: a bool for BOOL, an int64
+	// for BYTE, I16, I32 and I64, a float64 for DOUBLE, and for STRING, a
+	// string if it looks like text, or else a []byte.
+	Value interface{}
+
+	// Entries are the fields of a STRUCT, the elements of a LIST or a SET,
+	// and the keys and values of a MAP, alternating.
+	//
+	// The STRING entries whose bytes are a struct encoded with the protocol
+	// of the payload, like the payloads nested by some services, have the
+	// fields of this struct as Entries, their Value being their bytes.
+	Entries []UnknownEntry
+}
+
+// DecodeUnknownPayload decodes data, a payload of TBinaryProtocol or
+// TCompactProtocol, e.g. captured from the network, without its schema, for
+// the forensics on unknown traffic.
+//
+// The protocol, the framing, and whether the payload is a message or a bare
+// struct, are guessed by trying them all, and keeping the one decoding all
+// of data without error, or else the most bytes. If it failed, the payload
+// returned is its partial tree, with its error.
+//
+// As the types of the protocols don't tell the strings from the binaries,
+// the STRING values are guessed: text if they're valid UTF-8 of printable
+// characters, a nested struct if they decode as one, or else binary.
+func DecodeUnknownPayload(data []byte) (*UnknownPayload, error) {
+	var best *UnknownPayload
+	var bestErr error
+	for _, framed := range []bool{false, true} {
+		body := data
+		if framed {
+			if len(data) < 4 || binary.BigEndian.Uint32(data) > uint32(len(data)-4) {
+				continue
+			}
+			body = data[4 : 4+binary.BigEndian.Uint32(data)]
+		}
+		for _, protocol := range []NegotiableProtocol{ProtocolBinary, ProtocolCompact} {
+			for _, message := range []bool{true, false} {
+				p, err := decodeUnknown(body, protocol, message)
+				p.Framed = framed
+				if framed {
+					p.Length += 4
+				}
+				if best == nil || betterUnknownPayload(p, err, best, bestErr, len(data)) {
+					best, bestErr = p, err
+				}
+			}
+		}
+	}
+	return best, bestErr
+}
+
+// betterUnknownPayload reports whether p, decoded with err, is a better guess
+// than best: decoding all the data without error, or else more bytes.
+func betterUnknownPayload(p *UnknownPayload, err error, best *UnknownPayload, bestErr error, size int) bool {
+	complete := err == nil && p.Length == size
+	bestComplete := bestErr == nil && best.Length == size
+	if complete != bestComplete {
+		return complete
+	}
+	if p.Length != best.Length {
+		return p.Length > best.Length
+	}
+	return err == nil && bestErr != nil
+}
+
+// decodeUnknown decodes data with protocol, as a message or a bare struct.
+func decodeUnknown(data []byte, protocol NegotiableProtocol, message bool) (*UnknownPayload, error) {
+	d := newUnknownDecoder(data, protocol)
+	p := &UnknownPayload{Protocol: protocol}
+	ctx := context.Background()
+	if message {
+		name, typeID, seqID, err := d.in.ReadMessageBegin(ctx)
+		if err != nil {
+			return p, err
+		}
+		p.Message = &TMessageInfo{Method: name, TypeID: typeID, SeqID: seqID}
+	}
+	var err error
+	p.Fields, err = d.readStruct(ctx, DEFAULT_RECURSION_DEPTH)
+	if err == nil && message {
+		err = d.in.ReadMessageEnd(ctx)
+	}
+	p.Length = d.offset()
+	return p, err
+}
+
+// unknownDecoder decodes the values of a payload without their schema.
+type unknownDecoder struct {
+	data     []byte
+	protocol NegotiableProtocol
+	buf      *TMemoryBuffer
+	in       TProtocol
+}
+
+func newUnknownDecoder(data []byte, protocol NegotiableProtocol) *unknownDecoder {
+	d := &unknownDecoder{
+		data:     data,
+		protocol: protocol,
+		buf:      &TMemoryBuffer{Buffer: bytes.NewBuffer(data)},
+	}
+	if protocol == ProtocolCompact {
+		d.in = NewTCompactProtocolConf(d.buf, nil)
+	} else {
+		d.in = NewTBinaryProtocolConf(d.buf, nil)
+	}
+	return d
+}
+
+// offset returns the offset of the next byte read in the payload.
+func (d *unknownDecoder) offset() int {
+	return len(d.data) - d.buf.Len()
+}
+
+// readStruct reads the fields of a struct, returning the ones read before
+// an error with it.
+func (d *unknownDecoder) readStruct(ctx context.Context, maxDepth int) ([]UnknownEntry, error) {
+	if maxDepth <= 0 {
+		return nil, NewTProtocolExceptionWithType(DEPTH_LIMIT, errors.New("Depth limit exceeded"))
+	}
+	if _, err := d.in.ReadStructBegin(ctx); err != nil {
+		return nil, err
+	}
+	var fields []UnknownEntry
+	for {
+		offset := d.offset()
+		_, typeID, id, err := d.in.ReadFieldBegin(ctx)
+		if err != nil {
+			return fields, err
+		}
+		if typeID == STOP {
+			break
+		}
+		field, err := d.readValue(ctx, typeID, maxDepth-1)
+		field.ID, field.Offset = id, offset
+		fields = append(fields, field)
+		if err != nil {
+			return fields, err
+		}
+		if err := d.in.ReadFieldEnd(ctx); err != nil {
+			return fields, err
+		}
+	}
+	return fields, d.in.ReadStructEnd(ctx)
+}
+
+// readValue reads a value of type typeID, returning the partial value read
+// before an error with it.
+func (d *unknownDecoder) readValue(ctx context.Context, typeID TType, maxDepth int) (UnknownEntry, error) {
+	e := UnknownEntry{Type: typeID, Offset: d.offset()}
+	if maxDepth <= 0 {
+		return e, NewTProtocolExceptionWithType(DEPTH_LIMIT, errors.New("Depth limit exceeded"))
+	}
+	var err error
+	switch typeID {
+	case BOOL:
+		e.Value, err = d.in.ReadBool(ctx)
+	case BYTE:
+		var i int8
+		i, err = d.in.ReadByte(ctx)
+		e.Value = int64(i)
+	case I16:
+		var i int16
+		i, err = d.in.ReadI16(ctx)
+		e.Value = int64(i)
+	case I32:
+		var i int32
+		i, err = d.in.ReadI32(ctx)
+		e.Value = int64(i)
+	case I64:
+		e.Value, err = d.in.ReadI64(ctx)
+	case DOUBLE:
+		e.Value, err = d.in.ReadDouble(ctx)
+	case STRING:
+		var b []byte
+		if b, err = d.in.ReadBinary(ctx); err == nil {
+			e.Value, e.Entries = d.guessString(b, maxDepth)
+		}
+	case STRUCT:
+		e.Entries, err = d.readStruct(ctx, maxDepth)
+	case MAP:
+		var keyType, valueType TType
+		var size int
+		if keyType, valueType, size, err = d.in.ReadMapBegin(ctx); err != nil {
+			return e, err
+		}
+		for i := 0; i < size; i++ {
+			for _, typeID := range []TType{keyType, valueType} {
+				var entry UnknownEntry
+				entry, err = d.readValue(ctx, typeID, maxDepth-1)
+				e.Entries = append(e.Entries, entry)
+				if err != nil {
+					return e, err
+				}
+			}
+		}
+		err = d.in.ReadMapEnd(ctx)
+	case SET, LIST:
+		var elemType TType
+		var size int
+		if typeID == SET {
+			elemType, size, err = d.in.ReadSetBegin(ctx)
+		} else {
+			elemType, size, err = d.in.ReadListBegin(ctx)
+		}
+		if err != nil {
+			return e, err
+		}
+		for i := 0; i < size; i++ {
+			var elem UnknownEntry
+			elem, err = d.readValue(ctx, elemType, maxDepth-1)
+			e.Entries = append(e.Entries, elem)
+			if err != nil {
+				return e, err
+			}
+		}
+		if typeID == SET {
+			err = d.in.ReadSetEnd(ctx)
+		} else {
+			err = d.in.ReadListEnd(ctx)
+		}
+	default:
+		err = NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("Unknown data type %d", typeID))
+	}
+	return e, err
+}
+
+// guessString returns the value of the bytes of a STRING, with the fields of
+// the struct they encode, if any.
+func (d *unknownDecoder) guessString(b []byte, maxDepth int) (interface{}, []UnknownEntry) {
+	if isText(b) {
+		return string(b), nil
+	}
+	// A struct has at least a field and its stop.
+	if len(b) >= 2 {
+		nested := newUnknownDecoder(b, d.protocol)
+		fields, err := nested.readStruct(context.Background(), maxDepth)
+		if err == nil && len(fields) > 0 && nested.buf.Len() == 0 {
+			return b, fields
+		}
+	}
+	return b, nil
+}
+
+// isText reports whether b is valid UTF-8 of printable characters and
+// whitespace.
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// stripOffsets returns entries without their offsets.
+func stripOffsets(entries []UnknownEntry) []UnknownEntry {
+	for i := range entries {
+		entries[i].Offset = 0
+		entries[i].Entries = stripOffsets(entries[i].Entries)
+	}
+	return entries
+}
+
+func encodeValue(t *testing.T, proto TProtocol, buf *TMemoryBuffer, method string, v Value) []byte {
+	t.Helper()
+	ctx := context.Background()
+	if method != "" {
+		if err := proto.WriteMessageBegin(ctx, method, REPLY, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := v.Write(ctx, proto); err != nil {
+		t.Fatal(err)
+	}
+	if method != "" {
+		if err := proto.WriteMessageEnd(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := proto.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+func TestDecodeUnknownPayload(t *testing.T) {
+	for _, c := range []struct {
+		protocol NegotiableProtocol
+		newProto func(TTransport) TProtocol
+	}{
+		{ProtocolBinary, func(trans TTransport) TProtocol { return NewTBinaryProtocolConf(trans, nil) }},
+		{ProtocolCompact, func(trans TTransport) TProtocol { return NewTCompactProtocolConf(trans, nil) }},
+	} {
+		t.Run(c.protocol.String(), func(t *testing.T) {
+			buf := NewTMemoryBuffer()
+			nested := encodeValue(t, c.newProto(buf), buf, "", StructValue(
+				ValueField{ID: 1, Value: I32Value(7)},
+			))
+			buf.Reset()
+			data := encodeValue(t, c.newProto(buf), buf, "get", StructValue(
+				ValueField{ID: 1, Value: StringValue("héllo\n")},
+				ValueField{ID: 2, Value: BinaryValue(nested)},
+				ValueField{ID: 3, Value: BinaryValue([]byte{0xff, 0x00})},
+				ValueField{ID: 4, Value: ListValue(I16, I16Value(-1), I16Value(2))},
+				ValueField{ID: 5, Value: MapValue(BOOL, DOUBLE, ValueMapEntry{Key: BoolValue(true), Value: DoubleValue(0.5)})},
+			))
+
+			p, err := DecodeUnknownPayload(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Protocol != c.protocol || p.Framed || p.Length != len(data) {
+				t.Errorf("unexpected payload %+v", p)
+			}
+			if p.Message == nil || p.Message.Method != "get" || p.Message.TypeID != REPLY || p.Message.SeqID != 3 {
+				t.Errorf("unexpected message %+v", p.Message)
+			}
+			if p.Fields[1].Offset == 0 || p.Fields[1].Offset >= len(data) {
+				t.Errorf("unexpected offset %d", p.Fields[1].Offset)
+			}
+			expected := []UnknownEntry{
+				{ID: 1, Type: STRING, Value: "héllo\n"},
+				{ID: 2, Type: STRING, Value: nested, Entries: []UnknownEntry{
+					{ID: 1, Type: I32, Value: int64(7)},
+				}},
+				{ID: 3, Type: STRING, Value: []byte{0xff, 0x00}},
+				{ID: 4, Type: LIST, Entries: []UnknownEntry{
+					{Type: I16, Value: int64(-1)},
+					{Type: I16, Value: int64(2)},
+				}},
+				{ID: 5, Type: MAP, Entries: []UnknownEntry{
+					{Type: BOOL, Value: true},
+					{Type: DOUBLE, Value: 0.5},
+				}},
+			}
+			if fields := stripOffsets(p.Fields); !reflect.DeepEqual(fields, expected) {
+				t.Errorf("expected %+v, got %+v", expected, fields)
+			}
+
+			// A framed bare struct.
+			buf.Reset()
+			bare := encodeValue(t, c.newProto(buf), buf, "", StructValue(ValueField{ID: 9, Value: I64Value(1 << 40)}))
+			framed := append(make([]byte, 4), bare...)
+			binary.BigEndian.PutUint32(framed, uint32(len(bare)))
+			p, err = DecodeUnknownPayload(framed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected = []UnknownEntry{{ID: 9, Type: I64, Value: int64(1 << 40)}}
+			if !p.Framed || p.Message != nil || p.Length != len(framed) || !reflect.DeepEqual(stripOffsets(p.Fields), expected) {
+				t.Errorf("unexpected framed payload %+v", p)
+			}
+
+			// A truncated payload is decoded partially.
+			p, err = DecodeUnknownPayload(data[:len(data)-4])
+			if err == nil {
+				t.Fatalf("expected an error for a truncated payload, got %+v", p)
+			}
+			if p.Protocol != c.protocol || p.Message == nil || len(p.Fields) != 5 || p.Fields[0].Value != "héllo\n" {
+				t.Errorf("unexpected partial payload %+v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// TFieldViolation is a field of the arguments of a request failing a
+// validation rule.
+type TFieldViolation struct {
+	// Field is the path of the field from the arguments, the names of the
+	// fields as in the IDL separated by dots, with the indexes of the list
+	// and set elements and the keys of the map values in brackets, e.g.
+	// "request.users[2].name".
+	Field string
+
+	Description string
+}
+
+// TValidationError is the error of ValidateArguments.
+type TValidationError struct {
+	Method     string
+	Violations []TFieldViolation
+}
+
+func (e *TValidationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "invalid arguments of %s:", e.Method)
+	for i, v := range e.Violations {
+		if i > 0 {
+			sb.WriteString(";")
+		}
+		fmt.Fprintf(&sb, " %s: %s", v.Field, v.Description)
+	}
+	return sb.String()
+}
+
+// FieldRule checks the value of a field, returning an error describing why
+// it's invalid.
+//
+// value is nil for the unset optional fields, and the value of the field
+// otherwise, dereferenced for the optional fields and the structs.
+type FieldRule func(value interface{}) error
+
+// ValidationRules are the FieldRules of the arguments of the methods, by
+// method name as set in the processor map, then by field path, see
+// TFieldViolation.Field (without indexes nor keys).
+type ValidationRules map[string]map[string][]FieldRule
+
+type argumentValidatorKey struct{}
+
+// ValidationMiddleware returns a ProcessorMiddleware validating the decoded
+// arguments of the requests before calling the handler: the required fields
+// of the structs must be set, and the fields must pass their rules.
+//
+// The invalid requests are replied with a PROTOCOL_ERROR
+// TApplicationException of reason ExceptionReasonInvalidArgument listing the
+// violations, and the processor function returns a *TValidationError.
+//
+// The validation is done by the generated processor functions, see
+// ValidateArguments, so it requires code generated by this version of the
+// compiler.
+func ValidationMiddleware(rules ValidationRules) ProcessorMiddleware {
+	return func(name string, next TProcessorFunction) TProcessorFunction {
+		methodRules := rules[name]
+		return WrappedTProcessorFunction{
+			Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+				ctx = context.WithValue(ctx, argumentValidatorKey{}, methodRules)
+				return next.Process(ctx, seqID, in, out)
+			},
+		}
+	}
+}
+
+// ValidateArguments validates the decoded arguments of a request with the
+// ValidationMiddleware of ctx, returning a *TValidationError if they're
+// invalid. It returns nil if there's no ValidationMiddleware.
+//
+// It's called by the generated processor functions.
+func ValidateArguments(ctx context.Context, method string, args TStruct) error {
+	rules, ok := ctx.Value(argumentValidatorKey{}).(map[string][]FieldRule)
+	if !ok {
+		return nil
+	}
+	var violations []TFieldViolation
+	checkRequiredFields(reflect.ValueOf(args), "", &violations)
+	for path, fieldRules := range rules {
+		value := fieldValue(reflect.ValueOf(args), strings.Split(path, "."))
+		for _, rule := range fieldRules {
+			if err := rule(value); err != nil {
+				violations = append(violations, TFieldViolation{
+					Field:       path,
+					Description: err.Error(),
+				})
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Field < violations[j].Field
+	})
+	return &TValidationError{
+		Method:     method,
+		Violations: violations,
+	}
+}
+
+// thriftFieldTag returns the name of the field as in the IDL and whether
+// it's required, from the thrift tag of the generated structs.
+func thriftFieldTag(field reflect.StructField) (name string, required bool, ok bool) {
+	tag, ok := field.Tag.Lookup("thrift")
+	if !ok {
+		return "", false, false
+	}
+	parts := strings.Split(tag, ",")
+	return parts[0], len(parts) > 2 && parts[2] == "required", true
+}
+
+// checkRequiredFields appends a violation for every unset required field in
+// v, recursively.
+func checkRequiredFields(v reflect.Value, path string, violations *[]TFieldViolation) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			checkRequiredFields(v.Elem(), path, violations)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, required, ok := thriftFieldTag(t.Field(i))
+			if !ok {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			field := v.Field(i)
+			switch field.Kind() {
+			case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+				if required && field.IsNil() {
+					*violations = append(*violations, TFieldViolation{
+						Field:       fieldPath,
+						Description: "required field is not set",
+					})
+					continue
+				}
+			}
+			checkRequiredFields(field, fieldPath, violations)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			checkRequiredFields(v.Index(i), fmt.Sprintf("%s[%d]", path, i), violations)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			checkRequiredFields(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), violations)
+		}
+	}
+}
+
+// fieldValue returns the value of the field at path in v, nil if it or one of
+// its parents is unset.
+func fieldValue(v reflect.Value, path []string) interface{} {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		return nil
+	}
+	if len(path) == 0 {
+		return v.Interface()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, ok := thriftFieldTag(t.Field(i)); ok && name == path[0] {
+			return fieldValue(v.Field(i), path[1:])
+		}
+	}
+	return nil
+}
+
+// NotEmptyRule returns a FieldRule requiring the field to be set, and not
+// empty for strings, binaries and containers.
+func NotEmptyRule() FieldRule {
+	return func(value interface{}) error {
+		if value == nil {
+			return errors.New("must be set")
+		}
+		switch v := reflect.ValueOf(value); v.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+			if v.Len() == 0 {
+				return errors.New("must not be empty")
+			}
+		}
+		return nil
+	}
+}
+
+// LengthRule returns a FieldRule requiring the length of a string (in
+// characters), binary or container field to be between min and max,
+// inclusive. max < 0 means no maximum. Unset fields are valid.
+func LengthRule(min, max int) FieldRule {
+	return func(value interface{}) error {
+		if value == nil {
+			return nil
+		}
+		var n int
+		switch v := reflect.ValueOf(value); v.Kind() {
+		case reflect.String:
+			n = utf8.RuneCountInString(v.String())
+		case reflect.Slice, reflect.Map:
+			n = v.Len()
+		default:
+			return fmt.Errorf("length of a %T", value)
+		}
+		if n < min || (max >= 0 && n > max) {
+			if max < 0 {
+				return fmt.Errorf("length %d must be at least %d", n, min)
+			}
+			return fmt.Errorf("length %d must be between %d and %d", n, min, max)
+		}
+		return nil
+	}
+}
+
+// RangeRule returns a FieldRule requiring a numeric field to be between min
+// and max, inclusive. Unset fields are valid.
+func RangeRule(min, max float64) FieldRule {
+	return func(value interface{}) error {
+		if value == nil {
+			return nil
+		}
+		var f float64
+		switch v := reflect.ValueOf(value); v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f = float64(v.Int())
+		case reflect.Float32, reflect.Float64:
+			f = v.Float()
+		default:
+			return fmt.Errorf("range of a %T", value)
+		}
+		if f < min || f > max {
+			return fmt.Errorf("%v must be between %v and %v", value, min, max)
+		}
+		return nil
+	}
+}
+
+// PatternRule returns a FieldRule requiring a string field to match re.
+// Unset fields are valid.
+func PatternRule(re *regexp.Regexp) FieldRule {
+	return func(value interface{}) error {
+		if value == nil {
+			return nil
+		}
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.String {
+			return fmt.Errorf("pattern of a %T", value)
+		}
+		if !re.MatchString(v.String()) {
+			return fmt.Errorf("must match %s", re)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+type validationTestUser struct {
+	Name  string  `thrift:"name,1,required"`
+	Email *string `thrift:"email,2"`
+	Tags  []byte  `thrift:"tags,3,required"`
+}
+
+type validationTestArgs struct {
+	Users []*validationTestUser `thrift:"users,1"`
+	Owner *validationTestUser   `thrift:"owner,2"`
+	Limit int32                 `thrift:"limit,3"`
+}
+
+func (*validationTestArgs) Read(ctx context.Context, in TProtocol) error {
+	return nil
+}
+
+func (*validationTestArgs) Write(ctx context.Context, out TProtocol) error {
+	return nil
+}
+
+// argumentsKey passes the decoded arguments to the test processor function.
+type argumentsKey struct{}
+
+func TestValidationMiddleware(t *testing.T) {
+	var validationErr error
+	f := ValidationMiddleware(ValidationRules{
+		"list": {
+			"limit":       {RangeRule(1, 100)},
+			"owner":       {NotEmptyRule()},
+			"owner.name":  {LengthRule(2, 8), PatternRule(regexp.MustCompile(`^[a-z]+$`))},
+			"owner.email": {LengthRule(3, -1)},
+		},
+	})("list", WrappedTProcessorFunction{
+		Wrapped: func(ctx context.Context, seqID int32, in, out TProtocol) (bool, TException) {
+			args := ctx.Value(argumentsKey{}).(*validationTestArgs)
+			validationErr = ValidateArguments(ctx, "list", args)
+			return true, nil
+		},
+	})
+	validate := func(args *validationTestArgs) []TFieldViolation {
+		t.Helper()
+		ctx := context.WithValue(context.Background(), argumentsKey{}, args)
+		f.Process(ctx, 1, nil, nil)
+		if validationErr == nil {
+			return nil
+		}
+		var verr *TValidationError
+		if !errors.As(validationErr, &verr) || verr.Method != "list" {
+			t.Fatalf("unexpected error %v", validationErr)
+		}
+		return verr.Violations
+	}
+
+	email := "a@b"
+	valid := &validationTestArgs{
+		Users: []*validationTestUser{{Name: "x", Tags: []byte{}}},
+		Owner: &validationTestUser{Name: "alice", Email: &email, Tags: []byte{1}},
+		Limit: 10,
+	}
+	if violations := validate(valid); violations != nil {
+		t.Errorf("expected valid arguments, got %v", violations)
+	}
+
+	short := "a"
+	violations := validate(&validationTestArgs{
+		Users: []*validationTestUser{{Name: "x", Tags: []byte{}}, {Name: "y"}},
+		Owner: &validationTestUser{Name: "Alice", Email: &short, Tags: []byte{}},
+		Limit: 0,
+	})
+	expected := []TFieldViolation{
+		{"limit", "0 must be between 1 and 100"},
+		{"owner.email", "length 1 must be at least 3"},
+		{"owner.name", "must match ^[a-z]+$"},
+		{"users[1].tags", "required field is not set"},
+	}
+	if !reflect.DeepEqual(violations, expected) {
+		t.Errorf("expected violations %v, got %v", expected, violations)
+	}
+
+	violations = validate(&validationTestArgs{Limit: 1})
+	if len(violations) != 1 || violations[0].Field != "owner" || violations[0].Description != "must be set" {
+		t.Errorf("expected the unset owner to be reported, got %v", violations)
+	}
+
+	if err := ValidateArguments(context.Background(), "list", &validationTestArgs{}); err != nil {
+		t.Errorf("expected no validation without ValidationMiddleware, got %v", err)
+	}
+}
@@ -0,0 +1,448 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Value is a thrift value of any type, decoded without its schema, so that
+// the tools handling any payload, like proxies, debuggers or migration
+// scripts, can load, inspect, modify and re-encode it.
+//
+// The field of the Value holding its data depends on its Type: Bool for BOOL,
+// Int for BYTE, I16, I32 and I64, Double for DOUBLE, Binary for STRING (the
+// strings and the binaries having the same encoding), Name and Fields for
+// STRUCT, ElemType and Elems for LIST and SET, and KeyType, ElemType and
+// Entries for MAP.
+//
+// As the strings and the binaries can't be told apart without the schema,
+// the binaries decoded from a TJSONProtocol are left encoded in base64.
+//
+// A *Value is a TStruct reading and writing a STRUCT, so that it can be
+// serialized with a TSerializer, or used as the arguments or the result of a
+// call.
+type Value struct {
+	Type TType
+
+	Bool   bool
+	Int    int64
+	Double float64
+	Binary []byte
+
+	// Name is the name of the struct, only known with some protocols, like
+	// TSimpleJSONProtocol.
+	Name   string
+	Fields []ValueField
+
+	KeyType  TType
+	ElemType TType
+	Elems    []Value
+	Entries  []ValueMapEntry
+}
+
+// ValueField is a field of a STRUCT Value.
+type ValueField struct {
+	ID int16
+
+	// Name is the name of the field, only known with some protocols, like
+	// TSimpleJSONProtocol.
+	Name  string
+	Value Value
+}
+
+// ValueMapEntry is an entry of a MAP Value.
+type ValueMapEntry struct {
+	Key   Value
+	Value Value
+}
+
+// BoolValue returns the BOOL Value b.
+func BoolValue(b bool) Value {
+	return Value{Type: BOOL, Bool: b}
+}
+
+// ByteValue returns the BYTE Value i.
+func ByteValue(i int8) Value {
+	return Value{Type: BYTE, Int: int64(i)}
+}
+
+// I16Value returns the I16 Value i.
+func I16Value(i int16) Value {
+	return Value{Type: I16, Int: int64(i)}
+}
+
+// I32Value returns the I32 Value i.
+func I32Value(i int32) Value {
+	return Value{Type: I32, Int: int64(i)}
+}
+
+// I64Value returns the I64 Value i.
+func I64Value(i int64) Value {
+	return Value{Type: I64, Int: i}
+}
+
+// DoubleValue returns the DOUBLE Value f.
+func DoubleValue(f float64) Value {
+	return Value{Type: DOUBLE, Double: f}
+}
+
+// StringValue returns the STRING Value s.
+func StringValue(s string) Value {
+	return Value{Type: STRING, Binary: []byte(s)}
+}
+
+// BinaryValue returns the STRING Value of the binary b.
+func BinaryValue(b []byte) Value {
+	return Value{Type: STRING, Binary: b}
+}
+
+// StructValue returns the STRUCT Value of fields.
+func StructValue(fields ...ValueField) Value {
+	return Value{Type: STRUCT, Fields: fields}
+}
+
+// ListValue returns the LIST Value of elems, of type elemType.
+func ListValue(elemType TType, elems ...Value) Value {
+	return Value{Type: LIST, ElemType: elemType, Elems: elems}
+}
+
+// SetValue returns the SET Value of elems, of type elemType.
+func SetValue(elemType TType, elems ...Value) Value {
+	return Value{Type: SET, ElemType: elemType, Elems: elems}
+}
+
+// MapValue returns the MAP Value of entries, whose keys are of type keyType
+// and values of type valueType.
+func MapValue(keyType, valueType TType, entries ...ValueMapEntry) Value {
+	return Value{Type: MAP, KeyType: keyType, ElemType: valueType, Entries: entries}
+}
+
+// ReadValue reads a Value of type typeID from in.
+func ReadValue(ctx context.Context, in TProtocol, typeID TType) (Value, error) {
+	return readValue(ctx, in, typeID, DEFAULT_RECURSION_DEPTH)
+}
+
+func readValue(ctx context.Context, in TProtocol, typeID TType, maxDepth int) (Value, error) {
+	if maxDepth <= 0 {
+		return Value{}, NewTProtocolExceptionWithType(DEPTH_LIMIT, errors.New("Depth limit exceeded"))
+	}
+	v := Value{Type: typeID}
+	var err error
+	switch typeID {
+	case BOOL:
+		v.Bool, err = in.ReadBool(ctx)
+	case BYTE:
+		var i int8
+		i, err = in.ReadByte(ctx)
+		v.Int = int64(i)
+	case I16:
+		var i int16
+		i, err = in.ReadI16(ctx)
+		v.Int = int64(i)
+	case I32:
+		var i int32
+		i, err = in.ReadI32(ctx)
+		v.Int = int64(i)
+	case I64:
+		v.Int, err = in.ReadI64(ctx)
+	case DOUBLE:
+		v.Double, err = in.ReadDouble(ctx)
+	case STRING:
+		// Read as a string, as TJSONProtocol encodes the binaries in base64:
+		// they stay encoded, and are written back as they were.
+		var s string
+		s, err = in.ReadString(ctx)
+		v.Binary = []byte(s)
+	case STRUCT:
+		err = v.readStruct(ctx, in, maxDepth)
+	case MAP:
+		var size int
+		if v.KeyType, v.ElemType, size, err = in.ReadMapBegin(ctx); err != nil {
+			return v, err
+		}
+		v.Entries = make([]ValueMapEntry, 0, valuePreallocSize(size))
+		for i := 0; i < size; i++ {
+			var entry ValueMapEntry
+			if entry.Key, err = readValue(ctx, in, v.KeyType, maxDepth-1); err != nil {
+				return v, err
+			}
+			if entry.Value, err = readValue(ctx, in, v.ElemType, maxDepth-1); err != nil {
+				return v, err
+			}
+			v.Entries = append(v.Entries, entry)
+		}
+		err = in.ReadMapEnd(ctx)
+	case SET, LIST:
+		var size int
+		if typeID == SET {
+			v.ElemType, size, err = in.ReadSetBegin(ctx)
+		} else {
+			v.ElemType, size, err = in.ReadListBegin(ctx)
+		}
+		if err != nil {
+			return v, err
+		}
+		v.Elems = make([]Value, 0, valuePreallocSize(size))
+		for i := 0; i < size; i++ {
+			var elem Value
+			if elem, err = readValue(ctx, in, v.ElemType, maxDepth-1); err != nil {
+				return v, err
+			}
+			v.Elems = append(v.Elems, elem)
+		}
+		if typeID == SET {
+			err = in.ReadSetEnd(ctx)
+		} else {
+			err = in.ReadListEnd(ctx)
+		}
+	default:
+		err = NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("Unknown data type %d", typeID))
+	}
+	return v, err
+}
+
+// valueMaxPrealloc bounds the elements preallocated for the containers, as
+// their sizes are read from the input: a Value being much larger than its
+// encoding, the sizes allowed by the MaxMessageSize of the protocols could
+// still exhaust the memory.
+const valueMaxPrealloc = 1024
+
+func valuePreallocSize(size int) int {
+	if size > valueMaxPrealloc {
+		return valueMaxPrealloc
+	}
+	return size
+}
+
+func (v *Value) readStruct(ctx context.Context, in TProtocol, maxDepth int) error {
+	var err error
+	if v.Name, err = in.ReadStructBegin(ctx); err != nil {
+		return err
+	}
+	for {
+		name, typeID, id, err := in.ReadFieldBegin(ctx)
+		if err != nil {
+			return err
+		}
+		if typeID == STOP {
+			break
+		}
+		value, err := readValue(ctx, in, typeID, maxDepth-1)
+		if err != nil {
+			return err
+		}
+		v.Fields = append(v.Fields, ValueField{ID: id, Name: name, Value: value})
+		if err := in.ReadFieldEnd(ctx); err != nil {
+			return err
+		}
+	}
+	return in.ReadStructEnd(ctx)
+}
+
+// Read reads v as a STRUCT, implementing TStruct.
+func (v *Value) Read(ctx context.Context, in TProtocol) error {
+	*v = Value{Type: STRUCT}
+	return v.readStruct(ctx, in, DEFAULT_RECURSION_DEPTH)
+}
+
+// Write writes v to out. The elements of the LIST and SET Values must be of
+// their ElemType, and the entries of the MAP Values of their KeyType and
+// ElemType.
+func (v *Value) Write(ctx context.Context, out TProtocol) error {
+	switch v.Type {
+	case BOOL:
+		return out.WriteBool(ctx, v.Bool)
+	case BYTE:
+		return out.WriteByte(ctx, int8(v.Int))
+	case I16:
+		return out.WriteI16(ctx, int16(v.Int))
+	case I32:
+		return out.WriteI32(ctx, int32(v.Int))
+	case I64:
+		return out.WriteI64(ctx, v.Int)
+	case DOUBLE:
+		return out.WriteDouble(ctx, v.Double)
+	case STRING:
+		return out.WriteString(ctx, string(v.Binary))
+	case STRUCT:
+		if err := out.WriteStructBegin(ctx, v.Name); err != nil {
+			return err
+		}
+		for i := range v.Fields {
+			f := &v.Fields[i]
+			if err := out.WriteFieldBegin(ctx, f.Name, f.Value.Type, f.ID); err != nil {
+				return err
+			}
+			if err := f.Value.Write(ctx, out); err != nil {
+				return err
+			}
+			if err := out.WriteFieldEnd(ctx); err != nil {
+				return err
+			}
+		}
+		if err := out.WriteFieldStop(ctx); err != nil {
+			return err
+		}
+		return out.WriteStructEnd(ctx)
+	case MAP:
+		if err := out.WriteMapBegin(ctx, v.KeyType, v.ElemType, len(v.Entries)); err != nil {
+			return err
+		}
+		for i := range v.Entries {
+			e := &v.Entries[i]
+			if e.Key.Type != v.KeyType || e.Value.Type != v.ElemType {
+				return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("map entry of types %v and %v, expected %v and %v", e.Key.Type, e.Value.Type, v.KeyType, v.ElemType))
+			}
+			if err := e.Key.Write(ctx, out); err != nil {
+				return err
+			}
+			if err := e.Value.Write(ctx, out); err != nil {
+				return err
+			}
+		}
+		return out.WriteMapEnd(ctx)
+	case SET, LIST:
+		var err error
+		if v.Type == SET {
+			err = out.WriteSetBegin(ctx, v.ElemType, len(v.Elems))
+		} else {
+			err = out.WriteListBegin(ctx, v.ElemType, len(v.Elems))
+		}
+		if err != nil {
+			return err
+		}
+		for i := range v.Elems {
+			if v.Elems[i].Type != v.ElemType {
+				return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("element of type %v, expected %v", v.Elems[i].Type, v.ElemType))
+			}
+			if err := v.Elems[i].Write(ctx, out); err != nil {
+				return err
+			}
+		}
+		if v.Type == SET {
+			return out.WriteSetEnd(ctx)
+		}
+		return out.WriteListEnd(ctx)
+	default:
+		return NewTProtocolExceptionWithType(INVALID_DATA, fmt.Errorf("Unknown data type %d", v.Type))
+	}
+}
+
+// Field returns the value of the field id of the STRUCT v, false if it's not
+// set.
+func (v *Value) Field(id int16) (Value, bool) {
+	for _, f := range v.Fields {
+		if f.ID == id {
+			return f.Value, true
+		}
+	}
+	return Value{}, false
+}
+
+// SetField sets the field id of the STRUCT v to value, adding it after the
+// other fields if it's not set. name is only written by some protocols, like
+// TSimpleJSONProtocol.
+func (v *Value) SetField(id int16, name string, value Value) {
+	for i := range v.Fields {
+		if v.Fields[i].ID == id {
+			v.Fields[i].Name, v.Fields[i].Value = name, value
+			return
+		}
+	}
+	v.Fields = append(v.Fields, ValueField{ID: id, Name: name, Value: value})
+}
+
+// DeleteField unsets the field id of the STRUCT v, and reports whether it was
+// set.
+func (v *Value) DeleteField(id int16) bool {
+	for i := range v.Fields {
+		if v.Fields[i].ID == id {
+			v.Fields = append(v.Fields[:i], v.Fields[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a representation of v for debugging, with the fields of the
+// structs by id, the strings quoted and the other binaries in hexadecimal,
+// e.g. {1: 42, 2: "alice", 3: [1, 2], 4: {"k": 0x00ff}}.
+func (v Value) String() string {
+	var sb strings.Builder
+	v.format(&sb)
+	return sb.String()
+}
+
+func (v *Value) format(sb *strings.Builder) {
+	switch v.Type {
+	case BOOL:
+		sb.WriteString(strconv.FormatBool(v.Bool))
+	case BYTE, I16, I32, I64:
+		sb.WriteString(strconv.FormatInt(v.Int, 10))
+	case DOUBLE:
+		sb.WriteString(strconv.FormatFloat(v.Double, 'g', -1, 64))
+	case STRING:
+		if utf8.Valid(v.Binary) {
+			sb.WriteString(strconv.Quote(string(v.Binary)))
+		} else {
+			fmt.Fprintf(sb, "0x%x", v.Binary)
+		}
+	case STRUCT:
+		sb.WriteString("{")
+		for i := range v.Fields {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(sb, "%d: ", v.Fields[i].ID)
+			v.Fields[i].Value.format(sb)
+		}
+		sb.WriteString("}")
+	case MAP:
+		sb.WriteString("{")
+		for i := range v.Entries {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			v.Entries[i].Key.format(sb)
+			sb.WriteString(": ")
+			v.Entries[i].Value.format(sb)
+		}
+		sb.WriteString("}")
+	case SET, LIST:
+		sb.WriteString("[")
+		for i := range v.Elems {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			v.Elems[i].format(sb)
+		}
+		sb.WriteString("]")
+	default:
+		fmt.Fprintf(sb, "<%v>", v.Type)
+	}
+}
+
+var _ TStruct = (*Value)(nil)
@@ -0,0 +1,139 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements. See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership. The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValueGeneratedStruct(t *testing.T) {
+	ctx := context.Background()
+	for _, factory := range []TProtocolFactory{
+		NewTBinaryProtocolFactoryConf(nil),
+		NewTCompactProtocolFactoryConf(nil),
+		NewTJSONProtocolFactory(),
+	} {
+		buf := NewTMemoryBuffer()
+		proto := factory.GetProtocol(buf)
+		generated := &MyTestStruct{
+			On:         true,
+			B:          -1,
+			Int64:      64,
+			D:          0.5,
+			St:         "hello",
+			Bin:        []byte{0xff},
+			StringMap:  map[string]string{"k": "v"},
+			StringList: []string{"a", "b"},
+			StringSet:  map[string]struct{}{"s": {}},
+			E:          MyTestEnum_SECOND,
+		}
+		if err := generated.Write(ctx, proto); err != nil {
+			t.Fatal(err)
+		}
+		proto.Flush(ctx)
+
+		var v Value
+		if err := v.Read(ctx, proto); err != nil {
+			t.Fatalf("%T: %v", factory, err)
+		}
+		if st, ok := v.Field(7); !ok || st.Type != STRING || string(st.Binary) != "hello" {
+			t.Errorf("%T: unexpected field 7 %v", factory, st)
+		}
+		if list, _ := v.Field(10); list.Type != LIST || list.ElemType != STRING || len(list.Elems) != 2 {
+			t.Errorf("%T: unexpected field 10 %v", factory, list)
+		}
+		if e, _ := v.Field(12); e.Type != I32 || e.Int != int64(MyTestEnum_SECOND) {
+			t.Errorf("%T: unexpected field 12 %v", factory, e)
+		}
+
+		// Modified without the schema, then decoded by the generated code.
+		v.SetField(7, "st", StringValue("modified"))
+		v.SetField(10, "stringList", ListValue(STRING, StringValue("c")))
+		if !v.DeleteField(2) || v.DeleteField(2) {
+			t.Errorf("%T: expected field 2 to be deleted once", factory)
+		}
+		buf.Reset()
+		if err := v.Write(ctx, proto); err != nil {
+			t.Fatal(err)
+		}
+		proto.Flush(ctx)
+		decoded := &MyTestStruct{}
+		if err := decoded.Read(ctx, proto); err != nil {
+			t.Fatalf("%T: %v", factory, err)
+		}
+		generated.St = "modified"
+		generated.StringList = []string{"c"}
+		generated.B = 0
+		if !reflect.DeepEqual(decoded, generated) {
+			t.Errorf("%T: expected %+v, got %+v", factory, generated, decoded)
+		}
+	}
+}
+
+func TestValueString(t *testing.T) {
+	v := StructValue(
+		ValueField{ID: 1, Value: I64Value(42)},
+		ValueField{ID: 2, Value: StringValue("alice")},
+		ValueField{ID: 3, Value: ListValue(I32, I32Value(1), I32Value(2))},
+		ValueField{ID: 4, Value: MapValue(STRING, STRING, ValueMapEntry{
+			Key:   StringValue("k"),
+			Value: BinaryValue([]byte{0x00, 0xff}),
+		})},
+		ValueField{ID: 5, Value: SetValue(BOOL, BoolValue(true))},
+		ValueField{ID: 6, Value: DoubleValue(1.5)},
+	)
+	expected := `{1: 42, 2: "alice", 3: [1, 2], 4: {"k": 0x00ff}, 5: [true], 6: 1.5}`
+	if got := v.String(); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestValueInvalid(t *testing.T) {
+	ctx := context.Background()
+	proto := NewTBinaryProtocolConf(NewTMemoryBuffer(), nil)
+	for _, v := range []Value{
+		ListValue(I32, I64Value(1)),
+		MapValue(STRING, I32, ValueMapEntry{Key: StringValue("k"), Value: StringValue("v")}),
+		{Type: VOID},
+	} {
+		var pe TProtocolException
+		if err := v.Write(ctx, proto); !errors.As(err, &pe) || pe.TypeId() != INVALID_DATA {
+			t.Errorf("%v: expected INVALID_DATA, got %v", v, err)
+		}
+	}
+
+	// Nested deeper than the recursion limit.
+	nested := I32Value(1)
+	for i := 0; i < DEFAULT_RECURSION_DEPTH; i++ {
+		nested = ListValue(nested.Type, nested)
+	}
+	buf := NewTMemoryBuffer()
+	proto = NewTBinaryProtocolConf(buf, nil)
+	if err := nested.Write(ctx, proto); err != nil {
+		t.Fatal(err)
+	}
+	var pe TProtocolException
+	if _, err := ReadValue(ctx, proto, LIST); !errors.As(err, &pe) || pe.TypeId() != DEPTH_LIMIT {
+		t.Errorf("expected DEPTH_LIMIT, got %v", err)
+	}
+}